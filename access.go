@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Connection access lists, checked before the websocket upgrade:
+//
+//   - an address in CHAT_IP_DENY is refused;
+//   - with CHAT_IP_ALLOW set, only addresses in it are let in, plus
+//     connections presenting one of CHAT_CONN_API_KEYS in X-API-Key (bots
+//     and webhooks running outside the allowed networks).
+//
+// Refusals get 403 and are counted in /api/stats (connectionsDenied). All
+// three settings are hot-reloadable. So are two more checks:
+//
+//   - with CHAT_ALLOWED_ORIGINS set, the Origin header must be one of them
+//     (403), which stops other sites' pages from connecting their
+//     visitors;
+//   - an address may hold CHAT_CONNS_PER_IP connections on one instance
+//     (429), unless it is in CHAT_JOIN_EXEMPT.
+//
+// The address is the connection's peer, unless the peer is in
+// CHAT_TRUSTED_PROXIES: then CHAT_TRUSTED_PROXY_HEADER (X-Forwarded-For by
+// default) is read from the right, skipping the trusted proxies' own
+// addresses, and the first other one is the client's. Everything left of it
+// was written by the client and may be forged, so it is never looked at;
+// neither is the header of a peer that isn't a trusted proxy.
+
+// addressSettings are the settings that refuse to start the server when
+// invalid.
+var addressSettings = []string{"CHAT_TRUSTED_PROXIES", "CHAT_IP_ALLOW", "CHAT_IP_DENY"}
+
+var (
+	connectionsDenied atomic.Int64
+
+	connsMu   sync.Mutex
+	connsByIP = map[string]int{}
+)
+
+// clientIP is the address r comes from; see above.
+func clientIP(r *http.Request) string {
+	peer := peerAddr(r)
+	conf := cfg()
+	if !inPrefixes(conf.TrustedProxies, peer) {
+		return addrString(peer, r.RemoteAddr)
+	}
+	// Several header lines count as one list, in order.
+	hops := strings.Split(strings.Join(r.Header.Values(conf.TrustedProxyHeader), ","), ",")
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A malformed entry means the list can't be followed further;
+			// the last proxy is as far as it can be trusted.
+			break
+		}
+		client = hop.Unmap()
+		if !inPrefixes(conf.TrustedProxies, client) {
+			break
+		}
+	}
+	return addrString(client, r.RemoteAddr)
+}
+
+// peerAddr is the address of r's TCP peer, invalid if it can't be parsed.
+func peerAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
+
+func addrString(addr netip.Addr, fallback string) string {
+	if !addr.IsValid() {
+		return fallback
+	}
+	return addr.String()
+}
+
+func inPrefixes(prefixes []netip.Prefix, addr netip.Addr) bool {
+	return addr.IsValid() && slices.ContainsFunc(prefixes, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// connectionAllowed applies the access lists to a connection from ip.
+func connectionAllowed(r *http.Request, ip string) bool {
+	conf := cfg()
+	addr, _ := netip.ParseAddr(ip)
+	if inPrefixes(conf.IPDeny, addr) {
+		return false
+	}
+	if len(conf.IPAllow) == 0 || inPrefixes(conf.IPAllow, addr) {
+		return true
+	}
+	key := r.Header.Get("X-API-Key")
+	return key != "" && slices.Contains(conf.ConnKeyHashes, hashKey(key))
+}
+
+// originAllowed checks r's Origin against CHAT_ALLOWED_ORIGINS. Browsers
+// always send one; other clients must too once the list is set.
+func originAllowed(r *http.Request) bool {
+	origins := cfg().AllowedOrigins
+	origin := r.Header.Get("Origin")
+	return len(origins) == 0 || slices.ContainsFunc(origins, func(o string) bool { return strings.EqualFold(o, origin) })
+}
+
+// takeConnection counts a connection from ip, unless ip already holds
+// CHAT_CONNS_PER_IP on this instance. releaseConnection undoes it.
+func takeConnection(ip string) bool {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	if max := cfg().ConnsPerIP; max > 0 && !joinExempt(ip) && connsByIP[ip] >= max {
+		return false
+	}
+	connsByIP[ip]++
+	return true
+}
+
+func releaseConnection(ip string) {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	if connsByIP[ip]--; connsByIP[ip] <= 0 {
+		delete(connsByIP, ip)
+	}
+}
+
+// hashKeys digests the configured API keys, so that the keys themselves
+// never show up in config logs or GET /api/config.
+func hashKeys(keys []string) []string {
+	var hashes []string
+	for _, k := range keys {
+		hashes = append(hashes, hashKey(k))
+	}
+	return hashes
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// warnProxyHeader points out a proxy header that will be ignored, as
+// CHAT_TRUSTED_PROXY_HEADER alone used to be enough.
+func warnProxyHeader() {
+	if setting("CHAT_TRUSTED_PROXY_HEADER") != "" && len(cfg().TrustedProxies) == 0 {
+		log.Println("⚠️ CHAT_TRUSTED_PROXY_HEADER is ignored without CHAT_TRUSTED_PROXIES; client addresses are the connections' peers")
+	}
+}
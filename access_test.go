@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+// TestClientIP checks which address clientIP takes from the peer and the
+// forwarding header, with 10.0.0.0/8 as the trusted proxies.
+func TestClientIP(t *testing.T) {
+	setConfig(t, func(c *config) {
+		c.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+		c.TrustedProxyHeader = "X-Forwarded-For"
+	})
+
+	for _, tc := range []struct {
+		name   string
+		peer   string
+		header []string
+		want   string
+	}{
+		{"untrusted peer", "203.0.113.9:4000", nil, "203.0.113.9"},
+		{"untrusted peer sending the header", "203.0.113.9:4000", []string{"198.51.100.7"}, "203.0.113.9"},
+		{"trusted peer without the header", "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"one hop", "10.0.0.1:4000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"forged left-most hop", "10.0.0.1:4000", []string{"6.6.6.6, 198.51.100.7"}, "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.1:4000", []string{"6.6.6.6, 198.51.100.7, 10.0.0.3, 10.0.0.2"}, "198.51.100.7"},
+		{"only trusted proxies", "10.0.0.1:4000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"malformed last hop", "10.0.0.1:4000", []string{"198.51.100.7, not-an-ip"}, "10.0.0.1"},
+		{"malformed hop behind a proxy", "10.0.0.1:4000", []string{"198.51.100.7, not-an-ip, 10.0.0.2"}, "10.0.0.2"},
+		{"malformed forged hop", "10.0.0.1:4000", []string{"not-an-ip, 198.51.100.7"}, "198.51.100.7"},
+		{"empty hop", "10.0.0.1:4000", []string{"198.51.100.7,"}, "10.0.0.1"},
+		{"several header lines", "10.0.0.1:4000", []string{"6.6.6.6, 198.51.100.7", "10.0.0.2"}, "198.51.100.7"},
+		{"forged header line", "10.0.0.1:4000", []string{"6.6.6.6", "198.51.100.7"}, "198.51.100.7"},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.1]:4000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"IPv4-mapped untrusted peer", "[::ffff:203.0.113.9]:4000", []string{"198.51.100.7"}, "203.0.113.9"},
+		{"IPv4-mapped hops", "10.0.0.1:4000", []string{"::ffff:198.51.100.7, ::ffff:10.0.0.2"}, "198.51.100.7"},
+		{"IPv6 client", "10.0.0.1:4000", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"unparsable peer", "@", []string{"198.51.100.7"}, "@"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/ws", nil)
+			r.RemoteAddr = tc.peer
+			for _, line := range tc.header {
+				r.Header.Add("X-Forwarded-For", line)
+			}
+			if got := clientIP(r); got != tc.want {
+				t.Errorf("clientIP is %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
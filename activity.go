@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Activity statistics. Each message a user sends bumps one counter in the
+// workspace's hash for that UTC day, chat:activity:<YYYY-MM-DD>, with the
+// field "msg:<name>" (public, room and group messages) or "dm:<name>"
+// (direct messages, end-to-end encrypted ones included), in the same
+// transaction that stores the message. Days expire after activityTTL, so
+// "this week" is the sum of the last activityWeek days. System messages
+// and auto-replies aren't counted. CHAT_ACTIVITY_STATS=false stops
+// collection, and the frames and endpoints then answer that statistics
+// are disabled.
+const (
+	activityWeek = 7
+	activityTTL  = (activityWeek + 1) * 24 * time.Hour
+	leaderboardN = 10
+)
+
+// activityKind is the counter msg, stored at key, goes to, or "" if it
+// isn't counted.
+func activityKind(ws workspace, key string, msg ChatMessage) string {
+	switch {
+	case !cfg().ActivityStats || msg.System || msg.Kind == "autoreply":
+		return ""
+	case ws.isDMKey(key):
+		return "dm"
+	default:
+		return "msg"
+	}
+}
+
+func activityDay(t time.Time) string { return t.UTC().Format(time.DateOnly) }
+
+// countActivity queues the counter update for a message of kind by name,
+// sent at unix time t, on pipe.
+func countActivity(ctx context.Context, pipe redis.Pipeliner, ws workspace, kind, name string, t int64) {
+	key := ws.activityKey(activityDay(time.Unix(t, 0)))
+	pipe.HIncrBy(ctx, key, kind+":"+name, 1)
+	pipe.Expire(ctx, key, activityTTL)
+}
+
+// activityDays returns the keys of the last n days, today first.
+func activityDays(ws workspace, n int) []string {
+	now := time.Now()
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = ws.activityKey(activityDay(now.AddDate(0, 0, -i)))
+	}
+	return keys
+}
+
+func userActivity(ctx context.Context, ws workspace, name string) protocol.ActivityStats {
+	var st protocol.ActivityStats
+	pipe := rdb.Pipeline()
+	days := activityDays(ws, activityWeek)
+	cmds := make([]*redis.SliceCmd, len(days))
+	for i, key := range days {
+		cmds[i] = pipe.HMGet(ctx, key, "msg:"+name, "dm:"+name)
+	}
+	pipe.Exec(ctx)
+	for i, cmd := range cmds {
+		vals := cmd.Val()
+		if len(vals) != 2 {
+			continue
+		}
+		msgs, dms := counterValue(vals[0]), counterValue(vals[1])
+		st.MessagesWeek += msgs + dms
+		st.DMsWeek += dms
+		if i == 0 {
+			st.MessagesToday, st.DMsToday = msgs+dms, dms
+		}
+	}
+	return st
+}
+
+func counterValue(v interface{}) int64 {
+	s, _ := v.(string)
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+// {"type":"my_stats"} returns your own counters.
+func handleMyStats(c *client, data []byte) {
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	if !cfg().ActivityStats {
+		sendError(c, "disabled", "activity statistics are disabled on this server")
+		return
+	}
+	c.writeJSON(protocol.NewMyStats(userActivity(c.ctx, c.ws, name)))
+}
+
+// whoisActivity is the "activity" of a whois answer.
+func whoisActivity(ctx context.Context, ws workspace, name string) interface{} {
+	if !cfg().ActivityStats {
+		return map[string]bool{"disabled": true}
+	}
+	return userActivity(ctx, ws, name)
+}
+
+type leader struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// GET /api/admin/activity?workspace=&period=day|week&kind=messages|dms&limit=
+// returns the leaderboard. DELETE /api/admin/activity?workspace=&name=
+// resets one user's counters, or everyone's without a name.
+func handleActivityAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	if !cfg().ActivityStats {
+		http.Error(w, "activity statistics are disabled", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		period, kind := q.Get("period"), q.Get("kind")
+		if period == "" {
+			period = "week"
+		}
+		if kind == "" {
+			kind = "messages"
+		}
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = leaderboardN
+		}
+		if (period != "day" && period != "week") || (kind != "messages" && kind != "dms") {
+			http.Error(w, "period must be day or week, kind messages or dms", http.StatusBadRequest)
+			return
+		}
+		days := activityDays(ws, activityWeek)
+		if period == "day" {
+			days = days[:1]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"workspace": string(ws),
+			"period":    period,
+			"kind":      kind,
+			"leaders":   leaderboard(ctx, days, kind, limit),
+		})
+
+	case http.MethodDelete:
+		if name := q.Get("name"); name != "" {
+			resetActivity(ctx, ws, resolveName(ctx, ws, name))
+		} else {
+			rdb.Del(ctx, activityDays(ws, activityWeek+1)...)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func leaderboard(ctx context.Context, days []string, kind string, limit int) []leader {
+	totals := map[string]int64{}
+	for _, key := range days {
+		counts, _ := rdb.HGetAll(ctx, key).Result()
+		for field, v := range counts {
+			k, name, _ := strings.Cut(field, ":")
+			if k == "dm" || (k == "msg" && kind == "messages") {
+				totals[name] += counterValue(v)
+			}
+		}
+	}
+	list := make([]leader, 0, len(totals))
+	for name, n := range totals {
+		list = append(list, leader{Name: name, Count: n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list[:min(limit, len(list))]
+}
+
+// resetActivity deletes name's counters for every day still kept.
+func resetActivity(ctx context.Context, ws workspace, name string) {
+	pipe := rdb.Pipeline()
+	for _, key := range activityDays(ws, activityWeek+1) {
+		pipe.HDel(ctx, key, "msg:"+name, "dm:"+name)
+	}
+	pipe.Exec(ctx)
+}
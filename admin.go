@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// Admin channel. A websocket connection becomes an admin connection by
+// sending {"type":"admin_auth","token":"<CHAT_ADMIN_TOKEN>"}. From then on
+// it may send the other admin_* frames, which act on the connection's
+// workspace, and admin_subscribe opts it into the admin feed: moderation
+// actions from every admin and health warnings from every instance,
+// published on <prefix>admin. Every admin_* frame passes through
+// handleAdminFrame, the one place that answers forbidden to everyone else.
+//
+// Bans live in chat:bans (hash: name -> reason) and stop joins; mutes in
+// chat:mutes (zset: name -> unix time the mute ends, +inf for indefinite)
+// and reject every message the user sends. The pinned notice is a
+// ChatMessage in chat:pinned, sent in init.
+const adminAlertInterval = 10 * time.Second
+
+// A protocol.Kicked frame is published on a user's personal channel; every
+// instance forwards it and then closes the connections that receive it.
+var kickedPrefix = []byte(`{"type":"kicked"`)
+
+func publishAdminEvent(ev protocol.AdminEvent) {
+	ev.Type = protocol.TypeAdminEvent
+	ev.Time = time.Now().Unix()
+	frame, _ := json.Marshal(ev)
+	publish(adminChannel(), frame)
+}
+
+var (
+	alertMu   sync.Mutex
+	lastAlert = map[string]time.Time{}
+)
+
+// adminAlert sends a health warning to the admin feed, at most once per
+// adminAlertInterval per kind. It never blocks: it may be called from the
+// Redis hook while Redis is struggling.
+func adminAlert(kind, message string) {
+	alertMu.Lock()
+	if time.Since(lastAlert[kind]) < adminAlertInterval {
+		alertMu.Unlock()
+		return
+	}
+	lastAlert[kind] = time.Now()
+	alertMu.Unlock()
+	go publishAdminEvent(protocol.AdminEvent{Event: "health", Reason: kind, Instance: instanceID, Message: message})
+}
+
+// adminAlertf is adminAlert with formatting.
+func adminAlertf(kind, format string, args ...interface{}) {
+	adminAlert(kind, fmt.Sprintf(format, args...))
+}
+
+// {"type":"admin_auth","token":"..."}
+func handleAdminAuth(c *client, data []byte) {
+	var req protocol.AdminAuthRequest
+	json.Unmarshal(data, &req)
+	token := cfg().AdminToken
+	if token == "" || !ids.Equal(req.Token, token) {
+		log.Println("⚠️ Failed admin_auth on a websocket connection")
+		sendError(c, "forbidden", "invalid admin token")
+		return
+	}
+	c.admin = true
+	c.writeJSON(protocol.NewAdminAuth())
+	admit(c)
+}
+
+// handleAdminFrame runs an admin_* frame for an admin connection.
+func handleAdminFrame(c *client, ev Event) {
+	if !c.admin {
+		sendError(c, "forbidden", ev.Type+" needs an admin connection")
+		return
+	}
+	data := []byte(ev.Data)
+	switch ev.Type {
+	case protocol.TypeAdminSubscribe:
+		handleAdminSubscribe(c)
+	case protocol.TypeAdminKick:
+		handleAdminKick(c, data)
+	case protocol.TypeAdminBan:
+		handleAdminBan(c, data)
+	case protocol.TypeAdminUnban:
+		handleAdminUnban(c, data)
+	case protocol.TypeAdminMute:
+		handleAdminMute(c, data)
+	case protocol.TypeAdminUnmute:
+		handleAdminUnmute(c, data)
+	case protocol.TypeAdminAnnounce:
+		handleAdminAnnounce(c, data)
+	case protocol.TypeAdminPin:
+		handleAdminPin(c, data)
+	case protocol.TypeAdminUnannounce:
+		handleAdminUnannounce(c, data)
+	default:
+		sendError(c, "unknown_type", "unknown frame type: "+ev.Type)
+	}
+}
+
+// adminName is how an admin connection is named in the feed.
+func adminName(c *client) string {
+	if name := c.userName(); name != "" {
+		return name
+	}
+	return "admin"
+}
+
+func handleAdminSubscribe(c *client) {
+	if c.adminFeed {
+		return
+	}
+	c.adminFeed = true
+	sub := subscribeUntil(c.ctx, adminChannel())
+	go func() {
+		for msg := range sub.Messages() {
+			if payload, ok := openPayload(msg); ok {
+				c.writeMessage(payload)
+			}
+		}
+	}()
+	c.writeJSON(protocol.NewAdminSubscribed())
+}
+
+func decodeModeration(c *client, data []byte, typ string) (protocol.ModerationRequest, bool) {
+	var req protocol.ModerationRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Name == "" || req.Duration < 0 {
+		sendError(c, "bad_frame", "invalid "+typ+" frame")
+		return req, false
+	}
+	req.Name = resolveName(c.ctx, c.ws, req.Name)
+	return req, true
+}
+
+// kick closes name's connections on every instance.
+func kick(ws workspace, name, reason string) {
+	frame, _ := json.Marshal(protocol.NewKicked(reason))
+	publish(ws.userChannel(name), frame)
+}
+
+// kickReason reports whether payload is a protocol.Kicked.
+func kickReason(payload []byte) (string, bool) {
+	if !bytes.HasPrefix(payload, kickedPrefix) {
+		return "", false
+	}
+	var f protocol.Kicked
+	if json.Unmarshal(payload, &f) != nil || f.Type != protocol.TypeKicked {
+		return "", false
+	}
+	return f.Reason, true
+}
+
+// {"type":"admin_kick","name":"bob","reason":"spam"}
+func handleAdminKick(c *client, data []byte) {
+	req, ok := decodeModeration(c, data, "admin_kick")
+	if !ok {
+		return
+	}
+	kick(c.ws, req.Name, req.Reason)
+	log.Printf("🛡 %s kicked %s", adminName(c), req.Name)
+	publishAdminEvent(protocol.AdminEvent{Event: "kick", Workspace: string(c.ws), Name: req.Name, By: adminName(c), Reason: req.Reason})
+}
+
+// {"type":"admin_ban","name":"bob","reason":"spam"} bans and kicks.
+func handleAdminBan(c *client, data []byte) {
+	req, ok := decodeModeration(c, data, "admin_ban")
+	if !ok {
+		return
+	}
+	rdb.HSet(c.ctx, c.ws.bansKey(), req.Name, req.Reason)
+	kick(c.ws, req.Name, req.Reason)
+	log.Printf("🛡 %s banned %s", adminName(c), req.Name)
+	publishAdminEvent(protocol.AdminEvent{Event: "ban", Workspace: string(c.ws), Name: req.Name, By: adminName(c), Reason: req.Reason})
+}
+
+// {"type":"admin_unban","name":"bob"}
+func handleAdminUnban(c *client, data []byte) {
+	req, ok := decodeModeration(c, data, "admin_unban")
+	if !ok {
+		return
+	}
+	rdb.HDel(c.ctx, c.ws.bansKey(), req.Name)
+	publishAdminEvent(protocol.AdminEvent{Event: "unban", Workspace: string(c.ws), Name: req.Name, By: adminName(c)})
+}
+
+// {"type":"admin_mute","name":"bob","duration":3600,"reason":"cool off"}
+func handleAdminMute(c *client, data []byte) {
+	req, ok := decodeModeration(c, data, "admin_mute")
+	if !ok {
+		return
+	}
+	until := math.Inf(1)
+	if req.Duration > 0 {
+		until = float64(time.Now().Unix() + req.Duration)
+	}
+	rdb.ZAdd(c.ctx, c.ws.mutesKey(), redis.Z{Score: until, Member: req.Name})
+	log.Printf("🛡 %s muted %s", adminName(c), req.Name)
+	ev := protocol.AdminEvent{Event: "mute", Workspace: string(c.ws), Name: req.Name, By: adminName(c), Reason: req.Reason}
+	if req.Duration > 0 {
+		ev.Until = int64(until)
+	}
+	publishAdminEvent(ev)
+}
+
+// {"type":"admin_unmute","name":"bob"}
+func handleAdminUnmute(c *client, data []byte) {
+	req, ok := decodeModeration(c, data, "admin_unmute")
+	if !ok {
+		return
+	}
+	rdb.ZRem(c.ctx, c.ws.mutesKey(), req.Name)
+	publishAdminEvent(protocol.AdminEvent{Event: "unmute", Workspace: string(c.ws), Name: req.Name, By: adminName(c)})
+}
+
+// {"type":"admin_announce","text":"Maintenance at 18:00 UTC"} posts a
+// system message to the public chat. With "sticky":true it also stays a
+// notice (see notices.go), for "ttl" seconds or until admin_unannounce.
+func handleAdminAnnounce(c *client, data []byte) {
+	var req protocol.AnnounceRequest
+	if err := json.Unmarshal(data, &req); err != nil || strings.TrimSpace(req.Text) == "" || req.TTL < 0 {
+		sendError(c, "bad_frame", "invalid admin_announce frame")
+		return
+	}
+	if req.Sticky && len(stickyAnnouncements(c.ctx, c.ws)) >= maxStickyAnnouncements {
+		sendError(c, "too_many", fmt.Sprintf("at most %d sticky announcements; take one down first", maxStickyAnnouncements))
+		return
+	}
+	msg := newMessage("system", req.Text)
+	msg.System = true
+	jsonMsg, _ := json.Marshal(msg)
+	if !storeMessage(c.ctx, c.ws, c.ws.messagesKey(), msg, jsonMsg) {
+		sendError(c, "not_stored", "message could not be stored; please retry")
+		return
+	}
+	publish(c.ws.messagesChannel(), jsonMsg)
+	bridgeMessage(c.ws, "global", msg)
+	if req.Sticky && addStickyAnnouncement(c.ctx, c.ws, msg, req.TTL) != nil {
+		sendError(c, "not_stored", "the announcement was posted but could not be kept as a notice")
+	}
+	publishAdminEvent(protocol.AdminEvent{Event: "announce", Workspace: string(c.ws), By: adminName(c), Message: req.Text})
+}
+
+// {"type":"admin_pin","text":"Read the rules"} sets the pinned notice
+// everyone sees; an empty text removes it. Connected clients get
+// {"type":"pinned","message":{...}|null}.
+func handleAdminPin(c *client, data []byte) {
+	var req protocol.PinRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid admin_pin frame")
+		return
+	}
+
+	var pinned *ChatMessage
+	if strings.TrimSpace(req.Text) == "" {
+		rdb.Del(c.ctx, c.ws.pinnedKey())
+	} else {
+		msg := newMessage(adminName(c), req.Text)
+		msg.System = true
+		jsonMsg, _ := json.Marshal(msg)
+		rdb.Set(c.ctx, c.ws.pinnedKey(), seal(jsonMsg), 0)
+		pinned = &msg
+	}
+	frame, _ := json.Marshal(protocol.NewPinned(pinned))
+	publish(c.ws.messagesChannel(), frame)
+	publishAdminEvent(protocol.AdminEvent{Event: "pin", Workspace: string(c.ws), By: adminName(c), Message: req.Text})
+}
+
+// pinnedMessage returns the workspace's pinned notice, or nil.
+func pinnedMessage(ctx context.Context, ws workspace) *ChatMessage {
+	raw, err := rdb.Get(ctx, ws.pinnedKey()).Result()
+	if err != nil {
+		return nil
+	}
+	msg, ok := decodeMessage(raw)
+	if !ok {
+		return nil
+	}
+	return &msg
+}
+
+// rejectBanned refuses a join by a banned name and closes the connection.
+func rejectBanned(c *client, name string) bool {
+	reason, err := rdb.HGet(c.ctx, c.ws.bansKey(), name).Result()
+	if err != nil {
+		return false
+	}
+	frame := errorFrame(c, "banned", "you are banned")
+	frame.Reason = reason
+	c.writeJSON(frame)
+	closeClient(c, websocket.ClosePolicyViolation, closeReason(protocol.CloseBanned, reason))
+	return true
+}
+
+// rejectMuted sends a muted error if name is muted.
+func rejectMuted(c *client, name string) bool {
+	until, err := rdb.ZScore(c.ctx, c.ws.mutesKey(), name).Result()
+	if err != nil || until <= float64(time.Now().Unix()) {
+		return false
+	}
+	frame := errorFrame(c, "muted", "you are muted")
+	if !math.IsInf(until, 1) {
+		frame.Until = int64(until)
+	}
+	c.writeJSON(frame)
+	return true
+}
+
+// describeInstances lists instance IDs for an alert.
+func describeInstances(ids []string) string {
+	if len(ids) == 1 {
+		return "instance " + ids[0]
+	}
+	return strconv.Itoa(len(ids)) + " instances (" + strings.Join(ids, ", ") + ")"
+}
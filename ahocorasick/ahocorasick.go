@@ -0,0 +1,96 @@
+// Package ahocorasick finds which of a fixed set of patterns occur in a
+// text in one pass over it, however many patterns there are (Aho and
+// Corasick, 1975). Matching is on bytes; callers wanting case-insensitive
+// matching lower-case patterns and text alike.
+package ahocorasick
+
+// Matcher is immutable once built and safe for concurrent use.
+type Matcher struct {
+	nodes []node
+}
+
+type node struct {
+	next map[byte]int32
+	fail int32
+	// out lists the patterns ending here, including those reached by
+	// following fail links.
+	out []int
+}
+
+// New builds a matcher for patterns. Empty patterns never match.
+func New(patterns []string) *Matcher {
+	m := &Matcher{nodes: []node{{}}}
+	for i, p := range patterns {
+		if p == "" {
+			continue
+		}
+		n := int32(0)
+		for j := 0; j < len(p); j++ {
+			next, ok := m.nodes[n].next[p[j]]
+			if !ok {
+				next = int32(len(m.nodes))
+				m.nodes = append(m.nodes, node{})
+				if m.nodes[n].next == nil {
+					m.nodes[n].next = map[byte]int32{}
+				}
+				m.nodes[n].next[p[j]] = next
+			}
+			n = next
+		}
+		m.nodes[n].out = append(m.nodes[n].out, i)
+	}
+
+	// Breadth first, so a node's fail target is finished before the node.
+	queue := []int32{}
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for b, child := range m.nodes[n].next {
+			f := m.nodes[n].fail
+			for {
+				if next, ok := m.nodes[f].next[b]; ok && next != child {
+					m.nodes[child].fail = next
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			fail := m.nodes[child].fail
+			m.nodes[child].out = append(m.nodes[child].out, m.nodes[fail].out...)
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+// Match returns the indexes of the patterns that occur in text, each once,
+// in the order they are first found.
+func (m *Matcher) Match(text string) []int {
+	var found []int
+	seen := map[int]bool{}
+	n := int32(0)
+	for i := 0; i < len(text); i++ {
+		for {
+			if next, ok := m.nodes[n].next[text[i]]; ok {
+				n = next
+				break
+			}
+			if n == 0 {
+				break
+			}
+			n = m.nodes[n].fail
+		}
+		for _, p := range m.nodes[n].out {
+			if !seen[p] {
+				seen[p] = true
+				found = append(found, p)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"websocket-chatapp/usertoken"
+)
+
+// User API tokens. Integrations acting for a user (reading their DMs over
+// REST, say) send "Authorization: Bearer <token>", where the token names the
+// user and workspace and is signed with CHAT_API_TOKEN_KEY (see package
+// usertoken). Admins issue them with POST /api/admin/tokens; websocket
+// joins are unauthenticated, so there is no way for a user to mint their
+// own. Tokens aren't stored: they can't be revoked one by one, only by
+// letting them expire or changing the key.
+const (
+	defaultTokenTTL = 30 * 24 * time.Hour
+	maxTokenTTL     = 365 * 24 * time.Hour
+)
+
+var apiTokenKey []byte
+
+// initAPITokens loads CHAT_API_TOKEN_KEY: base64 of at least 32 bytes.
+func initAPITokens() error {
+	raw := cfg().APITokenKey
+	if raw == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) < 32 {
+		return fmt.Errorf("CHAT_API_TOKEN_KEY: want base64 of at least 32 bytes")
+	}
+	apiTokenKey = key
+	fmt.Println("🔑 User API tokens enabled")
+	return nil
+}
+
+// POST /api/admin/tokens {"workspace":"","name":"alice","ttl":"720h"}
+// returns {"token":"...","expires":<unix>}.
+func handleTokensAPI(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if apiTokenKey == nil {
+		http.Error(w, "user API tokens are not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+	var req struct {
+		Workspace string `json:"workspace"`
+		Name      string `json:"name"`
+		TTL       string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "invalid token request", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultTokenTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxTokenTTL {
+			http.Error(w, "ttl must be a duration up to "+maxTokenTTL.String(), http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	ws, ok := lookupWorkspace(r.Context(), req.Workspace)
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+
+	claims := usertoken.Claims{Workspace: string(ws), User: resolveName(r.Context(), ws, strings.TrimSpace(req.Name)), Expires: time.Now().Add(ttl).Unix()}
+	if ws == defaultWorkspace {
+		claims.Workspace = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":   usertoken.Issue(apiTokenKey, claims),
+		"expires": claims.Expires,
+	})
+}
+
+// bearerToken is r's bearer token, or "".
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// requireUser checks r's user token and returns whom it names, answering
+// the request itself when the token is missing or invalid, or its user's
+// account is deactivated.
+func requireUser(w http.ResponseWriter, r *http.Request) (workspace, string, bool) {
+	ws, user, ok := verifyUser(w, r)
+	if ok && isDeactivated(r.Context(), ws, user) {
+		http.Error(w, "forbidden: the account is deactivated", http.StatusForbidden)
+		return "", "", false
+	}
+	return ws, user, ok
+}
+
+// verifyUser is requireUser for the one endpoint a deactivated user may
+// still reach: their own status (see handleUserStatusAPI).
+func verifyUser(w http.ResponseWriter, r *http.Request) (workspace, string, bool) {
+	if apiTokenKey == nil {
+		http.Error(w, "user API tokens are not enabled on this server", http.StatusNotImplemented)
+		return "", "", false
+	}
+	token := bearerToken(r)
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+	claims, err := usertoken.Verify(apiTokenKey, token, time.Now())
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return "", "", false
+	}
+	ws, ok := lookupWorkspace(r.Context(), claims.Workspace)
+	if !ok {
+		http.Error(w, "unauthorized: unknown workspace", http.StatusUnauthorized)
+		return "", "", false
+	}
+	return ws, claims.User, true
+}
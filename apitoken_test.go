@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-chatapp/usertoken"
+)
+
+// TestUserTokenRefused checks that the server refuses user tokens that are
+// forged, expired or meant for elsewhere, and that a valid one reaches its
+// own workspace's DMs only.
+func TestUserTokenRefused(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	addr := startServer(t, "", withAdmin, "CHAT_API_TOKEN_KEY=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if code, data := api(t, addr, http.MethodPost, "/api/workspaces", []byte(`{"id":"acme"}`)); code != http.StatusOK {
+		t.Fatalf("creating a workspace got %d: %s", code, data)
+	}
+	alice := dial(t, addr, "", "alice")
+	dial(t, addr, "", "bob")
+	acmeAlice := dial(t, addr, "/acme", "alice")
+	dial(t, addr, "/acme", "bob")
+	alice.SendDM("alice", "bob", "in the default workspace")
+	await(t, alice, "message")
+	acmeAlice.SendDM("alice", "bob", "in acme")
+	await(t, acmeAlice, "message")
+
+	valid := usertoken.Claims{Workspace: "acme", User: "alice", Expires: time.Now().Add(time.Hour).Unix()}
+	token := usertoken.Issue(key, valid)
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(usertoken.Claims{User: "alice", Expires: valid.Expires})
+
+	for _, tc := range []struct {
+		name, token, path string
+		want              int
+	}{
+		{"no token", "", "/api/dm/bob/messages", http.StatusUnauthorized},
+		{"garbage", "not-a-token", "/api/dm/bob/messages", http.StatusUnauthorized},
+		{"bad signature", usertoken.Issue([]byte("fedcba9876543210fedcba9876543210"), valid), "/api/dm/bob/messages", http.StatusUnauthorized},
+		{"claims moved to another workspace", parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2], "/api/dm/bob/messages", http.StatusUnauthorized},
+		{"expired", usertoken.Issue(key, usertoken.Claims{Workspace: "acme", User: "alice", Expires: time.Now().Add(-time.Second).Unix()}), "/api/dm/bob/messages", http.StatusUnauthorized},
+		{"unknown workspace", usertoken.Issue(key, usertoken.Claims{Workspace: "globex", User: "alice", Expires: valid.Expires}), "/api/dm/bob/messages", http.StatusUnauthorized},
+		{"admin API", token, "/api/workspaces", http.StatusUnauthorized},
+		{"valid", token, "/api/dm/bob/messages", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, data := apiAs(t, addr, tc.token, http.MethodGet, tc.path, nil)
+			if code != tc.want {
+				t.Fatalf("got %d, want %d: %s", code, tc.want, data)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			var page struct {
+				Messages []struct {
+					Text string `json:"text"`
+				} `json:"messages"`
+			}
+			if err := json.Unmarshal(data, &page); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Messages) != 1 || page.Messages[0].Text != "in acme" {
+				t.Errorf("an acme token read %s, want acme's DM only", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"websocket-chatapp/username"
+)
+
+// Message attribution. A message an incoming webhook or a bot posted says
+// so in via ("webhook" or "bot"), which clients render as a badge, and its
+// user is always the identity behind it: the hook's name, bound to its
+// token when the hook is created, or the bot connection's joined name. A
+// delivery or a bot's message may ask to be shown under another name with
+// displayName, which is kept in its own field and never replaces user.
+//
+// Neither a hook's name nor a display name may be a member's name (any
+// spelling registered in chat:names, see names.go, or a legacy one), so a
+// script can't post as "alice" and have it read as alice's. Only bot
+// connections (hello with "kind":"bot", or ?kind=bot) may set displayName.
+const (
+	viaWebhook     = "webhook"
+	viaBot         = "bot"
+	maxDisplayName = 64
+)
+
+// isMemberName reports whether name is, case aside, a member's name.
+func isMemberName(ctx context.Context, ws workspace, name string) bool {
+	if n, _ := rdb.HExists(ctx, ws.namesKey(), username.Canonical(name)).Result(); n {
+		return true
+	}
+	legacy, _ := rdb.SIsMember(ctx, ws.namesLegacyKey(), name).Result()
+	return legacy
+}
+
+// validDisplayName reports whether name may be shown as a message's author.
+func validDisplayName(name string) bool {
+	return utf8.ValidString(name) && utf8.RuneCountInString(name) <= maxDisplayName &&
+		strings.TrimSpace(name) == name && username.Canonical(name) != ""
+}
+
+func (c *client) isBot() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deviceKind == viaBot
+}
+
+// applyDisplayName sets msg's display name, or sends the error and returns
+// false. A bot may use its own name; other members' are refused.
+func applyDisplayName(c *client, msg *ChatMessage, name string) bool {
+	if name == "" {
+		return true
+	}
+	if !c.isBot() {
+		sendError(c, "forbidden", "only bot connections may set displayName")
+		return false
+	}
+	if !validDisplayName(name) {
+		sendError(c, "bad_frame", "displayName must be 1 to 64 characters without surrounding spaces")
+		return false
+	}
+	if username.Canonical(name) == username.Canonical(msg.User) {
+		return true
+	}
+	if isMemberName(c.ctx, c.ws, name) {
+		sendError(c, "impersonation", name+" is a member's name", "name", name)
+		return false
+	}
+	msg.DisplayName = name
+	return true
+}
@@ -0,0 +1,81 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestAttribution checks that webhooks and bots can't post as a member.
+// With alice online, it tries to create an incoming webhook named after
+// her, deliver to a hook with her name (in another case) as displayName,
+// and send as her from a bot connection and with displayName from a normal
+// one. Each must be refused, and the messages allowed instead must reach
+// alice with their hook or bot as user, via set and the display name kept
+// apart.
+func TestAttribution(t *testing.T) {
+	addr := startServer(t, "", withAdmin)
+	alice := dial(t, addr, "", "alice")
+	alice.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "deploys"})
+	await(t, alice, protocol.TypeRoomJoined)
+
+	t.Run("hooks", func(t *testing.T) {
+		if code, _ := postJSON(t, addr, "/api/admin/hooks", map[string]string{"room": "deploys", "name": "Alice"}); code != http.StatusConflict {
+			t.Errorf("a hook named Alice got %d, want 409", code)
+		}
+		code, body := postJSON(t, addr, "/api/admin/hooks", map[string]string{"room": "deploys", "name": "ci"})
+		if code != http.StatusCreated {
+			t.Fatalf("creating a hook got %d: %s", code, body)
+		}
+		var hook struct {
+			URL string `json:"url"`
+		}
+		json.Unmarshal(body, &hook)
+		if code, _ := postJSON(t, addr, hook.URL, map[string]string{"text": "as alice", "displayName": "ALICE"}); code != http.StatusConflict {
+			t.Errorf("a delivery as ALICE got %d, want 409", code)
+		}
+		if code, body := postJSON(t, addr, hook.URL, map[string]string{"text": "build passed", "displayName": "Deploy bot"}); code != http.StatusOK {
+			t.Fatalf("a delivery got %d: %s", code, body)
+		}
+		expectRoomMessage(t, alice, "build passed", "ci", "webhook", "Deploy bot")
+	})
+
+	t.Run("bots", func(t *testing.T) {
+		bot := dial(t, addr, "?kind=bot", "buildbot")
+		bot.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "deploys"})
+		await(t, bot, protocol.TypeRoomJoined)
+		bot.SendFrame(protocol.RoomSendRequest{Type: protocol.TypeRoomSend, Room: "deploys", Text: "as alice", DisplayName: "alice"})
+		refused(t, bot, "impersonation")
+		bot.SendFrame(protocol.SendRequest{Type: protocol.TypeMsg, Text: "as alice", DisplayName: "Alice"})
+		refused(t, bot, "impersonation")
+		bot.SendFrame(protocol.RoomSendRequest{Type: protocol.TypeRoomSend, Room: "deploys", Text: "tests passed", DisplayName: "CI"})
+		expectRoomMessage(t, alice, "tests passed", "buildbot", "bot", "CI")
+	})
+
+	t.Run("members", func(t *testing.T) {
+		alice.SendFrame(protocol.SendRequest{Type: protocol.TypeMsg, Text: "as someone", DisplayName: "Someone"})
+		refused(t, alice, "forbidden")
+	})
+}
+
+// postJSON POSTs v as JSON to path with the admin token.
+func postJSON(t *testing.T, addr, path string, v interface{}) (int, []byte) {
+	t.Helper()
+	data, _ := json.Marshal(v)
+	return api(t, addr, http.MethodPost, path, data)
+}
+
+// expectRoomMessage checks that the next room message c reads is text,
+// attributed as given.
+func expectRoomMessage(t *testing.T, c *client.Client, text, user, via, displayName string) {
+	t.Helper()
+	var frame protocol.RoomMessage
+	decode(t, await(t, c, protocol.TypeRoomMessage), &frame)
+	m := frame.Message
+	if m.Text != text || m.User != user || m.Via != via || m.DisplayName != displayName {
+		t.Errorf("got %q from %q via %q as %q, want %q from %q via %q as %q", m.Text, m.User, m.Via, m.DisplayName, text, user, via, displayName)
+	}
+}
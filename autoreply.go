@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"websocket-chatapp/protocol"
+)
+
+// Vacation auto-replies. While a user has one enabled, the first DM from
+// each sender within CHAT_AUTOREPLY_COOLDOWN is answered with their text,
+// as a DM of kind "autoreply". The text lives in chat:user:<name>:autoreply
+// and each sender's cooldown in chat:user:<name>:autoreply:sent:<sender>,
+// which expires with the cooldown. Auto-replies never go through the DM
+// handlers, so they can't trigger the other side's auto-reply.
+const maxAutoReplySize = 1024
+
+// {"type":"autoreply","text":"On PTO until Monday","enabled":true}
+// {"type":"autoreply","enabled":false} turns it off; {"type":"autoreply"}
+// just reports the current setting.
+func handleAutoReply(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.AutoReplyRequest
+	if err := json.Unmarshal(data, &req); err != nil || len(req.Text) > maxAutoReplySize {
+		sendError(c, "bad_frame", "invalid autoreply frame")
+		return
+	}
+
+	switch {
+	case req.Enabled == nil:
+	case *req.Enabled:
+		if req.Text == "" {
+			sendError(c, "bad_frame", "autoreply needs a text")
+			return
+		}
+		rdb.HSet(ctx, ws.autoReplyKey(name), "text", req.Text)
+	default:
+		clearAutoReply(ctx, ws, name)
+	}
+
+	text, err := rdb.HGet(ctx, ws.autoReplyKey(name), "text").Result()
+	c.writeJSON(protocol.NewAutoReply(err == nil, text))
+}
+
+// clearAutoReply removes name's auto-reply and every sender's cooldown, so
+// turning it on again answers everyone afresh.
+func clearAutoReply(ctx context.Context, ws workspace, name string) {
+	keys := []string{ws.autoReplyKey(name)}
+	iter := rdb.Scan(ctx, 0, ws.autoReplySentKey(escapeGlob(name), "*"), 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	rdb.Del(ctx, keys...)
+}
+
+// autoReply answers a DM from sender to receiver if receiver has an
+// auto-reply on and hasn't answered sender within the cooldown. SET NX
+// makes the cooldown check atomic across instances.
+func autoReply(ctx context.Context, ws workspace, sender, receiver string) {
+	if sender == receiver {
+		return
+	}
+	text, err := rdb.HGet(ctx, ws.autoReplyKey(receiver), "text").Result()
+	if err != nil {
+		return
+	}
+	if ok, _ := rdb.SetNX(ctx, ws.autoReplySentKey(receiver, sender), 1, cfg().AutoReplyCooldown).Result(); !ok {
+		return
+	}
+
+	msg := newMessage(receiver, text)
+	msg.Kind = "autoreply"
+	jsonMsg, _ := json.Marshal(msg)
+	if !storeMessage(ctx, ws, ws.dmKey(receiver, sender), msg, jsonMsg) {
+		return
+	}
+	publishDM(ws, msg, sender)
+	bridgeMessage(ws, dmConversation(receiver, sender), msg)
+}
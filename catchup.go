@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Catching up after a resubscribe. Public messages published while this
+// instance's subscription was down (Redis restarting or failing over) never
+// reach it; they are in history, though. So the public message listener
+// remembers the messages it delivered, and when the subscription comes back
+// it reads the messages stored since and delivers those it hasn't, at most
+// maxCatchUp of them. Messages are matched by ID, over the last
+// catchUpSlack, which also covers messages spilled during the outage: they
+// keep the time they were sent but are stored later. Other broadcasts (DMs,
+// room messages, events) aren't replayed; clients find the messages in
+// history.
+const (
+	catchUpSlack   = 30 * time.Second
+	maxCatchUp     = 500
+	catchUpMemory  = 1024 // IDs remembered
+	catchUpTimeout = 10 * time.Second
+)
+
+type publicCursor struct {
+	newest int64 // time of the newest message delivered
+	ids    map[string]bool
+	order  []string // ids, oldest first
+}
+
+func newPublicCursor() *publicCursor {
+	return &publicCursor{newest: time.Now().Unix(), ids: map[string]bool{}}
+}
+
+// note records that payload is being delivered, reporting false if it is
+// a message that already was. Other frames (pins, link previews) have a
+// type and are always delivered.
+func (p *publicCursor) note(payload []byte) bool {
+	var msg struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Time int64  `json:"time"`
+	}
+	if json.Unmarshal(payload, &msg) != nil || msg.Type != "" || msg.ID == "" {
+		return true
+	}
+	if p.ids[msg.ID] {
+		return false
+	}
+	p.newest = max(p.newest, msg.Time)
+	p.ids[msg.ID] = true
+	p.order = append(p.order, msg.ID)
+	if len(p.order) > catchUpMemory {
+		delete(p.ids, p.order[0])
+		p.order = p.order[1:]
+	}
+	return true
+}
+
+// missed returns the stored public messages of ws that weren't delivered,
+// oldest first, noting them as delivered.
+func (p *publicCursor) missed(ctx context.Context, ws workspace) [][]byte {
+	ctx, cancel := context.WithTimeout(ctx, catchUpTimeout)
+	defer cancel()
+	since := p.newest - int64(catchUpSlack/time.Second)
+	raw, err := latestBetween(ctx, ws, ws.messagesKey(), strconv.FormatInt(since, 10), "+inf", maxCatchUp)
+	if err != nil {
+		return nil
+	}
+	var missed [][]byte
+	for _, member := range raw {
+		payload, err := unseal(member)
+		if err != nil {
+			continue
+		}
+		if p.note(payload) {
+			missed = append(missed, payload)
+		}
+	}
+	return missed
+}
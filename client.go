@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/framesig"
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// client is one websocket connection. Writes may come from the worker
+// handling its frames and from any of the pub/sub listeners at once, so
+// they are serialized here, and the first failed write tears the
+// connection down.
+type client struct {
+	conn *websocket.Conn
+	ws   workspace
+	cfg  config // cfg with ws's overrides, read once on connect
+
+	overrides map[string]int // ws's overrides, for limit
+
+	readOnly bool   // spectator connection; see spectator.go
+	protocol string // negotiated subprotocol; see protocol.go
+	ip       string // client address; see throttle.go
+	roster   string // rosterDiff or rosterEvents; see roster.go
+	invite   string // code given with ?invite=; see invites.go
+
+	// See sessions.go.
+	id         string
+	connected  time.Time
+	lastActive atomic.Int64 // unix ms
+
+	signer *framesig.Signer // non-nil on signed connections; see signing.go
+
+	// Set by admin_auth and admin_subscribe; only the connection's frame
+	// handling touches them. See admin.go.
+	admin     bool
+	adminFeed bool
+
+	writeMu sync.Mutex
+
+	mu         sync.Mutex // guards the fields below
+	name       string
+	closed     bool
+	device     string // label and kind the client gave; see sessions.go
+	deviceKind string
+	lang       string                 // catalog language; see lang.go
+	rttMs      float64                // rolling average of application-level ping RTT
+	pingRTTMs  float64                // and of control-frame ping RTT; see rttping.go
+	rttSlow    bool                   // pingRTTMs is over CHAT_RTT_SLOW
+	echoes     map[string]pendingEcho // by message ID; see tempid.go
+	holding    bool                   // frames wait in held until init is sent; see init.go
+	held       [][]byte
+	pending    bool // not admitted to an invite-only server yet; see invites.go
+
+	// ctx is cancelled on teardown; Redis calls made for this connection
+	// use it, so they stop as soon as the client is gone.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+
+	timedOut atomic.Bool // a Redis call for this connection timed out; see redisTimeoutHook
+	dropped  atomic.Bool // the network failed rather than anyone closing; see closeClient
+
+	// Frames read but not handled yet; see inqueue.go.
+	inMu      sync.Mutex // guards the fields below
+	inbox     []inboundFrame
+	scheduled bool    // on the ready list or with a worker
+	inboundMs float64 // rolling average of read-to-handled time
+	inflight  sync.WaitGroup
+}
+
+var (
+	clientsMu sync.RWMutex
+	clients   = make(map[*client]bool)
+
+	slowEvictions atomic.Int64 // clients disconnected for a write timeout
+)
+
+func newClient(parent context.Context, conn *websocket.Conn, ws workspace, readOnly bool) *client {
+	overrides := ws.overrides(parent)
+	c := &client{conn: conn, ws: ws, cfg: withOverrides(overrides), overrides: overrides, readOnly: readOnly, protocol: protocolOf(conn), id: ids.New(), connected: time.Now()}
+	c.lastActive.Store(c.connected.UnixMilli())
+	c.ctx, c.cancel = context.WithCancel(context.WithValue(parent, clientKey{}, c))
+	clientsMu.Lock()
+	clients[c] = true
+	clientsMu.Unlock()
+	return c
+}
+
+// connectedClients returns a snapshot of the registry so callers can write
+// (and possibly evict) without holding the lock.
+func connectedClients() []*client {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	list := make([]*client, 0, len(clients))
+	for c := range clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+func (c *client) userName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+func (c *client) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("❌ Encode error:", err)
+		return err
+	}
+	return c.writeMessage(data)
+}
+
+// writeMessage writes one frame, after adding this connection's tempId if
+// it is the copy of a message it sent, and after outbound middleware (which
+// may transform or drop it) and the size guard (see framesize.go). While
+// init is being sent the frame waits instead (see init.go).
+func (c *client) writeMessage(data []byte) error {
+	c.mu.Lock()
+	if c.holding && !c.closed {
+		c.held = append(c.held, data)
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+	return c.write(data)
+}
+
+// write writes one frame now; see writeMessage.
+func (c *client) write(data []byte) error {
+	data = c.withTempID(data)
+	if len(outboundChain) > 0 {
+		var ok bool
+		if data, ok = runOutbound(Recipient{Workspace: c.ws, Name: c.userName()}, data); !ok {
+			return nil
+		}
+	}
+	var ok bool
+	if data, ok = guardOutbound(c, data); !ok {
+		return nil
+	}
+	c.writeMu.Lock()
+	var err error
+	if c.signer != nil {
+		// Sealed under writeMu, so sequence numbers go out in order.
+		data, err = c.signer.Seal(data)
+	}
+	if err == nil {
+		c.conn.SetWriteDeadline(time.Now().Add(cfg().WriteTimeout))
+		start := time.Now()
+		err = c.conn.WriteMessage(websocket.TextMessage, data)
+		wsWriteHistogram.Observe(time.Since(start).Seconds())
+	}
+	c.writeMu.Unlock()
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			slowEvictions.Add(1)
+			log.Printf("🐢 Write to %q timed out after %s; disconnecting slow client", c.userName(), cfg().WriteTimeout)
+			closeClient(c, websocket.CloseTryAgainLater, closeReason(protocol.CloseTooSlow, "too slow"))
+		} else {
+			log.Println("❌ Write error:", err)
+			// After a close frame, in either direction, it's no drop.
+			c.dropped.Store(!errors.Is(err, websocket.ErrCloseSent))
+			closeClient(c, websocket.CloseInternalServerErr, closeReason(protocol.CloseWriteFailed, "write failed"))
+		}
+	}
+	return err
+}
+
+// closeReason is the reason text of a close frame, which clients decode
+// with protocol.DecodeCloseReason.
+func closeReason(kind, reason string) string {
+	return protocol.CloseReason{Kind: kind, Reason: reason}.Encode()
+}
+
+// closeClient is the one way a connection ends, whatever noticed first:
+// the read loop, a failed write, a kick or ban, a drain or shutdown. It
+// runs once per connection; later calls, from the other readers and
+// writers that find the connection gone, return at once. In order it
+// sends a close frame with code and reason, made by closeReason, which just
+// fails on a socket that is already broken; takes the client out of the
+// registry; cancels its context, which ends its subscriptions and
+// listeners; removes its presence; records the leave event; and closes the
+// socket. A dropped connection (see dropped) keeps its presence for
+// CHAT_PRESENCE_GRACE, so a user whose network blips isn't shown leaving
+// and coming back; reconnecting within that time keeps them online
+// throughout, as the new connection is counted before the old one is
+// uncounted.
+func closeClient(c *client, code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		name := c.name
+		c.closed = true
+		c.mu.Unlock()
+
+		// gorilla lets WriteControl run alongside a blocked writer, so a
+		// slow client's stuck write doesn't hold this up.
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+
+		clientsMu.Lock()
+		delete(clients, c)
+		clientsMu.Unlock()
+		c.cancel()
+		releaseClient(c)
+
+		// The connection's context is gone; cleanup outlives it.
+		if grace := cfg().PresenceGrace; name != "" && c.listed() && c.dropped.Load() && grace > 0 {
+			ws := c.ws
+			time.AfterFunc(grace, func() { removePresence(serverCtx, ws, name) })
+		} else if name != "" && c.listed() {
+			removePresence(serverCtx, c.ws, name)
+		}
+		if name != "" {
+			releaseName(c, name)
+			dropSession(c, name)
+		}
+		if name != "" {
+			recordEvent(c.ws, chatEvent{Type: "leave", User: name})
+		}
+		c.conn.Close()
+	})
+}
+
+// join records the user's name and subscribes to their personal DM
+// channel and their control channel. The subscriptions end with the
+// connection's context. A connection joins once: join reports false,
+// changing nothing, if it already has a name (or is closed).
+func (c *client) join(name string) bool {
+	c.mu.Lock()
+	if c.closed || c.name != "" {
+		c.mu.Unlock()
+		return false
+	}
+	c.name = name
+	c.mu.Unlock()
+
+	sub := subscribeUntil(c.ctx, c.ws.userChannel(name))
+	go func() {
+		for msg := range sub.Messages() {
+			payload, ok := openPayload(msg)
+			if !ok {
+				continue
+			}
+			if c.writeMessage(payload) != nil {
+				return
+			}
+			markDelivered(c, payload)
+			if string(payload) == accountDeletedFrame {
+				closeClient(c, websocket.ClosePolicyViolation, closeReason(protocol.CloseAccountDeleted, "account deleted"))
+				return
+			}
+			if isDeactivatedFrame(payload) {
+				closeClient(c, websocket.ClosePolicyViolation, closeReason(protocol.CloseAccountDeactivated, "account deactivated"))
+				return
+			}
+			if reason, kicked := kickReason(payload); kicked {
+				closeClient(c, websocket.ClosePolicyViolation, closeReason(protocol.CloseKicked, reason))
+				return
+			}
+		}
+	}()
+	listenSessionControl(c, name)
+	return true
+}
@@ -0,0 +1,280 @@
+// Package client is a small Go client for the chat server's websocket
+// protocol, used by bots, tests and cmd/loadtest.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/protocol"
+)
+
+// Message is a chat message as delivered by the server.
+type Message = protocol.Message
+
+// Frame is one frame received from the server. Typed JSON frames carry
+// their "type"; plain chat messages (public or DM) have Type "message" and
+// Message set; anything else is Type "text".
+type Frame struct {
+	Type    string
+	Message *Message
+	Raw     []byte
+}
+
+// Client is one connection. When a draining server sends a reconnect
+// frame, or a server closes the connection because it shuts down, the
+// client moves to a new connection by itself after the delay asked for
+// (see reconnect); callers just keep reading and writing. Other closes
+// end the client: Read returns them as typed errors (see close.go).
+type Client struct {
+	url string
+
+	writeMu sync.Mutex // guards the fields below; held while writing
+	conn    *websocket.Conn
+	name    string // joined as, to join again after reconnecting
+	closed  bool
+	moving  chan struct{} // non-nil while reconnecting; closed when done
+}
+
+// Protocol is the websocket subprotocol the client asks for.
+const Protocol = "chat.v1.json"
+
+var dialer = &websocket.Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+	Subprotocols:     []string{Protocol},
+}
+
+// Dial connects to the server's websocket endpoint, e.g. ws://localhost:8080/ws.
+func Dial(url string) (*Client, error) {
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{url: url, conn: conn}, nil
+}
+
+func (c *Client) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *Client) Join(name string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.name = name
+	return c.conn.WriteMessage(websocket.TextMessage, []byte("join:"+name))
+}
+
+func (c *Client) current() *websocket.Conn {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn
+}
+
+// Reconnect attempts after a reconnect frame before staying put, and the
+// most jitter added to the delay a shutting-down server asks for, so that
+// clients it gave the same delay (older servers give none) don't all come
+// back at once.
+const (
+	reconnectTries   = 5
+	reconnectBackoff = time.Second
+	shutdownJitter   = time.Second
+)
+
+// closeLinger is how long a closed connection's socket stays half open;
+// see closeConn.
+const closeLinger = time.Second
+
+// reconnect dials a new connection after the delay, joins it under the
+// same name and swaps it in, then closes the old one. Frames may arrive
+// twice around the switch, but none are missed. If the server can't be
+// reached, the old connection is kept for as long as it lasts.
+func (c *Client) reconnect(after time.Duration, done chan struct{}) {
+	defer func() {
+		c.writeMu.Lock()
+		c.moving = nil
+		c.writeMu.Unlock()
+		close(done)
+	}()
+	time.Sleep(after)
+	var conn *websocket.Conn
+	for i := 0; i < reconnectTries; i++ {
+		var err error
+		if conn, _, err = dialer.Dial(c.url, nil); err == nil {
+			break
+		}
+		time.Sleep(reconnectBackoff << i)
+	}
+	if conn == nil {
+		return
+	}
+
+	c.writeMu.Lock()
+	if c.closed {
+		c.writeMu.Unlock()
+		conn.Close()
+		return
+	}
+	if c.name != "" && conn.WriteMessage(websocket.TextMessage, []byte("join:"+c.name)) != nil {
+		c.writeMu.Unlock()
+		conn.Close()
+		return
+	}
+	old := c.conn
+	c.conn = conn
+	c.writeMu.Unlock()
+
+	closeConn(old)
+}
+
+// closeConn closes conn with a normal close frame. The socket is only
+// half closed at first, so frames the server writes before it reads the
+// close still land, rather than failing as on a dropped connection; it is
+// closed for good once the server has had closeLinger to answer.
+func closeConn(conn *websocket.Conn) error {
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	if hc, ok := conn.NetConn().(interface{ CloseWrite() error }); ok && err == nil && hc.CloseWrite() == nil {
+		time.AfterFunc(closeLinger, func() { conn.Close() })
+		return nil
+	}
+	return conn.Close()
+}
+
+// Leave signs out: the server takes the user offline, answers with a
+// leave frame and closes the connection, and Read then returns an
+// ErrClosed of kind left. The client won't join again on a reconnect.
+func (c *Client) Leave() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.name = ""
+	return c.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"`+protocol.TypeLeave+`"}`))
+}
+
+// Send posts a public message.
+func (c *Client) Send(user, text string) error {
+	return c.write([]byte("msg:" + user + ":" + text))
+}
+
+// SendDM sends a direct message from one user to another.
+func (c *Client) SendDM(from, to, text string) error {
+	return c.write([]byte("dm:" + from + ":" + to + ":" + text))
+}
+
+// SendFrame sends a JSON frame such as {"type":"mark_read",...}.
+func (c *Client) SendFrame(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(data)
+}
+
+// Read blocks until the next frame arrives. Reconnect frames are acted on
+// and also returned. A close for a shutdown is followed by a reconnect and
+// Read goes on with the new connection; any other close is returned as
+// an ErrKicked, ErrBanned or ErrClosed, and the client doesn't reconnect.
+func (c *Client) Read() (Frame, error) {
+	for {
+		c.writeMu.Lock()
+		conn, moving := c.conn, c.moving
+		c.writeMu.Unlock()
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if moving != nil {
+				<-moving // the old connection may go before the new one is up
+			}
+			if conn != c.current() {
+				continue // swapped by reconnect; read the new one
+			}
+			if ce, ok := err.(*websocket.CloseError); ok {
+				err = closeError(ce)
+			}
+			var shutdown *ErrServerShutdown
+			if errors.As(err, &shutdown) && c.reconnectAfterShutdown(conn, shutdown.ReconnectAfter) {
+				continue
+			}
+			return Frame{}, err
+		}
+		f := ParseFrame(data)
+		if f.Type == protocol.TypeReconnect {
+			var req protocol.Reconnect
+			json.Unmarshal(data, &req)
+
+			c.writeMu.Lock()
+			if c.moving == nil {
+				c.moving = make(chan struct{})
+				go c.reconnect(time.Duration(req.After)*time.Millisecond, c.moving)
+			}
+			c.writeMu.Unlock()
+		}
+		return f, nil
+	}
+}
+
+// reconnectAfterShutdown moves from conn, closed by a shutting-down
+// server, to a new connection, reporting whether it did.
+func (c *Client) reconnectAfterShutdown(conn *websocket.Conn, after time.Duration) bool {
+	c.writeMu.Lock()
+	if c.closed || c.conn != conn || c.moving != nil {
+		c.writeMu.Unlock()
+		return false
+	}
+	done := make(chan struct{})
+	c.moving = done
+	c.writeMu.Unlock()
+	c.reconnect(after+rand.N(shutdownJitter), done)
+	return c.current() != conn
+}
+
+func ParseFrame(data []byte) Frame {
+	f := Frame{Type: "text", Raw: data}
+
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(data, &typed) != nil {
+		return f
+	}
+	if typed.Type != "" {
+		f.Type = typed.Type
+		return f
+	}
+
+	var msg Message
+	if json.Unmarshal(data, &msg) == nil && msg.User != "" {
+		f.Type = "message"
+		f.Message = &msg
+	}
+	return f
+}
+
+// RetryAfter reports how long the server asked the client to wait before
+// reconnecting, if err (from Read) is the close it sends when shutting
+// down: Read reconnects by itself, so this is only returned when that
+// failed. Waiting that long rather than reconnecting at once spreads a
+// restart's reconnects out.
+func RetryAfter(err error) (time.Duration, bool) {
+	var shutdown *ErrServerShutdown
+	if !errors.As(err, &shutdown) {
+		return 0, false
+	}
+	return shutdown.ReconnectAfter, true
+}
+
+// Close closes the connection with a normal close frame, so the server
+// takes the user offline at once rather than waiting out a drop, and
+// doesn't reconnect.
+func (c *Client) Close() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.closed = true
+	return closeConn(c.conn)
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/protocol"
+)
+
+// Read returns one of these errors when the server closes the connection,
+// decoded from the close frame's reason (protocol.CloseReason), so callers
+// can tell why with errors.As rather than by matching text. Each unwraps to
+// the *websocket.CloseError it came from.
+
+// ErrKicked means an admin kicked the user. Joining again is allowed.
+type ErrKicked struct {
+	Reason string
+	close  *websocket.CloseError
+}
+
+func (e *ErrKicked) Error() string { return "kicked: " + e.Reason }
+func (e *ErrKicked) Unwrap() error { return e.close }
+
+// ErrBanned means the name is banned, or with Address set that the
+// client's address is, after too many rejected joins. Connecting again
+// before Until fails; a zero Until is a ban that lasts until an admin
+// lifts it.
+type ErrBanned struct {
+	Reason  string
+	Until   time.Time
+	Address bool
+	close   *websocket.CloseError
+}
+
+func (e *ErrBanned) Error() string {
+	if e.Until.IsZero() {
+		return "banned: " + e.Reason
+	}
+	return fmt.Sprintf("banned until %s: %s", e.Until.Format(time.RFC3339), e.Reason)
+}
+func (e *ErrBanned) Unwrap() error { return e.close }
+
+// ErrServerShutdown means the server shut down or drained, asking the
+// client to come back after ReconnectAfter. Read reconnects by itself
+// (see Read) and returns it only if that failed.
+type ErrServerShutdown struct {
+	ReconnectAfter time.Duration
+	close          *websocket.CloseError
+}
+
+func (e *ErrServerShutdown) Error() string {
+	return fmt.Sprintf("server shut down; reconnect after %s", e.ReconnectAfter)
+}
+func (e *ErrServerShutdown) Unwrap() error { return e.close }
+
+// ErrClosed is any other close by the server. Kind is one of the
+// protocol.Close kinds, or "" if the reason wasn't a protocol.CloseReason.
+type ErrClosed struct {
+	Code   int
+	Kind   string
+	Reason string
+	close  *websocket.CloseError
+}
+
+func (e *ErrClosed) Error() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("closed by server (%d): %s", e.Code, e.Reason)
+	}
+	return fmt.Sprintf("closed by server (%d, %s): %s", e.Code, e.Kind, e.Reason)
+}
+func (e *ErrClosed) Unwrap() error { return e.close }
+
+// closeError decodes a close from the server into one of the errors above.
+func closeError(ce *websocket.CloseError) error {
+	r, ok := protocol.DecodeCloseReason(ce.Text)
+	if !ok {
+		r.Reason = ce.Text
+		if ce.Code == websocket.CloseGoingAway {
+			// Servers before close kinds: {"reason":...,"retryAfterMs":...}.
+			r.Kind = protocol.CloseShutdown
+			json.Unmarshal([]byte(ce.Text), &r)
+		}
+	}
+	switch r.Kind {
+	case protocol.CloseKicked:
+		return &ErrKicked{Reason: r.Reason, close: ce}
+	case protocol.CloseBanned, protocol.CloseAddressBanned:
+		e := &ErrBanned{Reason: r.Reason, Address: r.Kind == protocol.CloseAddressBanned, close: ce}
+		if r.Until > 0 {
+			e.Until = time.Unix(r.Until, 0)
+		}
+		return e
+	case protocol.CloseShutdown:
+		e := &ErrServerShutdown{close: ce}
+		if r.RetryAfterMs != nil {
+			e.ReconnectAfter = time.Duration(*r.RetryAfterMs) * time.Millisecond
+		}
+		return e
+	}
+	return &ErrClosed{Code: ce.Code, Kind: r.Kind, Reason: r.Reason, close: ce}
+}
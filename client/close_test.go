@@ -0,0 +1,182 @@
+package client_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// standIn is a server that closes a case's first connection the way the
+// chat server does (protocol.CloseReason in the close frame); later
+// connections get the join echoed back as a joined frame. Cases are named
+// by the URL path, /ws/<name>.
+type standIn struct {
+	url string
+
+	mu    sync.Mutex
+	cases map[string]closeCase
+	conns map[string]int
+}
+
+// A close the stand-in server sends on a case's first connection.
+type closeCase struct {
+	code   int
+	reason string
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{cases: map[string]closeCase{}, conns: map[string]int{}}
+	upgrader := websocket.Upgrader{Subprotocols: []string{client.Protocol}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/ws/")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.mu.Lock()
+		s.conns[name]++
+		n, cc := s.conns[name], s.cases[name]
+		s.mu.Unlock()
+		_, join, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if n == 1 {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(cc.code, cc.reason), time.Now().Add(time.Second))
+			conn.ReadMessage() // until the client closes
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"joined","name":"`+strings.TrimPrefix(string(join), "join:")+`"}`))
+		conn.ReadMessage()
+	}))
+	t.Cleanup(srv.Close)
+	s.url = "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/"
+	return s
+}
+
+// dial connects to the case name, closed with code and reason, and joins.
+func (s *standIn) dial(t *testing.T, name string, code int, reason string) *client.Client {
+	t.Helper()
+	s.mu.Lock()
+	s.cases[name] = closeCase{code, reason}
+	s.mu.Unlock()
+	c, err := client.Dial(s.url + name)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.Join("bot")
+	return c
+}
+
+// TestCloseErrors checks that, for each close kind, Read returns the
+// matching typed error and the client doesn't reconnect.
+func TestCloseErrors(t *testing.T) {
+	s := newStandIn(t)
+	until := time.Now().Add(15 * time.Minute).Truncate(time.Second)
+	type closeTest struct {
+		name   string
+		code   int
+		reason string
+		check  func(error) string // what's wrong with Read's error, if anything
+	}
+	cases := []closeTest{
+		{"kicked", websocket.ClosePolicyViolation, protocol.CloseReason{Kind: protocol.CloseKicked, Reason: "spam"}.Encode(), func(err error) string {
+			var e *client.ErrKicked
+			if !errors.As(err, &e) || e.Reason != "spam" {
+				return "want ErrKicked{spam}"
+			}
+			return ""
+		}},
+		{"banned", websocket.ClosePolicyViolation, protocol.CloseReason{Kind: protocol.CloseBanned, Reason: "flooding"}.Encode(), func(err error) string {
+			var e *client.ErrBanned
+			if !errors.As(err, &e) || e.Reason != "flooding" || !e.Until.IsZero() || e.Address {
+				return "want ErrBanned{flooding} with no end"
+			}
+			return ""
+		}},
+		{"address_banned", websocket.ClosePolicyViolation, protocol.CloseReason{Kind: protocol.CloseAddressBanned, Reason: "too many rejected joins", Until: until.Unix()}.Encode(), func(err error) string {
+			var e *client.ErrBanned
+			if !errors.As(err, &e) || !e.Address || !e.Until.Equal(until) {
+				return "want an address ErrBanned until " + until.String()
+			}
+			return ""
+		}},
+		{"plain", websocket.CloseNormalClosure, "bye", func(err error) string {
+			var e *client.ErrClosed
+			if !errors.As(err, &e) || e.Kind != "" || e.Reason != "bye" {
+				return "want ErrClosed with reason bye and no kind"
+			}
+			return ""
+		}},
+	}
+	for _, kind := range []string{protocol.CloseAccountDeleted, protocol.CloseAccountDeactivated, protocol.CloseSessionClosed, protocol.CloseLeft, protocol.CloseTooSlow, protocol.CloseWriteFailed, protocol.CloseBadFrame, protocol.CloseUnsupportedProtocol} {
+		cases = append(cases, closeTest{kind, websocket.ClosePolicyViolation, protocol.CloseReason{Kind: kind, Reason: "because"}.Encode(), func(err error) string {
+			var e *client.ErrClosed
+			if !errors.As(err, &e) || e.Kind != kind || e.Reason != "because" || e.Code != websocket.ClosePolicyViolation {
+				return "want ErrClosed{" + kind + "}"
+			}
+			return ""
+		}})
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := s.dial(t, tc.name, tc.code, tc.reason)
+			_, err := c.Read()
+			if msg := tc.check(err); msg != "" {
+				t.Errorf("Read returned %T %v; %s", err, err, msg)
+			}
+			time.Sleep(100 * time.Millisecond)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.conns[tc.name] != 1 {
+				t.Error("the client reconnected")
+			}
+		})
+	}
+}
+
+// TestShutdownReconnects checks that after a close for a shutdown, Read
+// goes on with a new connection, joined again, after the delay asked for,
+// with the reason in JSON as the server sends it and in the older form.
+func TestShutdownReconnects(t *testing.T) {
+	s := newStandIn(t)
+	retry := int64(100)
+	for _, tc := range []struct{ name, reason string }{
+		{"shutdown", protocol.CloseReason{Kind: protocol.CloseShutdown, Reason: "server shutting down", RetryAfterMs: &retry}.Encode()},
+		{"shutdown_legacy", `{"reason":"server shutting down","retryAfterMs":100}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := s.dial(t, tc.name, websocket.CloseGoingAway, tc.reason)
+			start := time.Now()
+			f, err := c.Read()
+			if err != nil || f.Type != protocol.TypeJoined || !strings.Contains(string(f.Raw), `"bot"`) {
+				t.Fatalf("Read returned %v, %s; want the new connection's joined frame", err, f.Raw)
+			}
+			if waited := time.Since(start); waited < 100*time.Millisecond {
+				t.Errorf("reconnected after %s, before the 100ms asked for", waited)
+			}
+		})
+	}
+}
+
+// TestLongCloseReason checks that a long reason is cut to fit a close
+// frame, on a character boundary.
+func TestLongCloseReason(t *testing.T) {
+	long := protocol.CloseReason{Kind: protocol.CloseKicked, Reason: strings.Repeat("é", 200)}.Encode()
+	r, ok := protocol.DecodeCloseReason(long)
+	if len(long) > protocol.MaxCloseReason || !ok || !utf8.ValidString(r.Reason) || !strings.HasPrefix(strings.Repeat("é", 200), r.Reason) || len(r.Reason) < 80 {
+		t.Errorf("a long reason encodes to %d bytes: %s", len(long), long)
+	}
+}
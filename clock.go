@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// serverNow is the server clock in unix ms, as sent in serverTime fields.
+// Clients compare it with their own clock to correct relative timestamps.
+func serverNow() int64 { return time.Now().UnixMilli() }
+
+// clientTime validates a client-supplied timestamp (unix seconds, like
+// message times). Times further in the future than cfg().MaxClockSkew are
+// rejected; anything in the future within the window is clamped to now, so
+// a skewed clock can't pin state such as a forward-only read position ahead
+// of real messages.
+func clientTime(sec int64) (int64, bool) {
+	now := time.Now().Unix()
+	if sec > now+int64(cfg().MaxClockSkew/time.Second) {
+		return 0, false
+	}
+	if sec > now {
+		sec = now
+	}
+	return sec, true
+}
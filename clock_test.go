@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestClientTime checks clientTime against the default five-minute
+// CHAT_MAX_CLOCK_SKEW, in seconds.
+func TestClientTime(t *testing.T) {
+	now := time.Now().Unix()
+	for _, tc := range []struct {
+		name string
+		in   int64
+		want int64
+		ok   bool
+	}{
+		{"past", now - 3600, now - 3600, true},
+		{"within the skew", now + 60, now, true},
+		{"past the skew", now + 600, 0, false},
+		{"milliseconds", time.Now().UnixMilli(), 0, false},
+		{"far future", 9999999999, 0, false},
+	} {
+		got, ok := clientTime(tc.in)
+		if ok != tc.ok || (ok && got != tc.want && got != tc.want+1) {
+			t.Errorf("%s: clientTime(%d) = %d, %v; want %d, %v", tc.name, tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
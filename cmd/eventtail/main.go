@@ -0,0 +1,85 @@
+// Command eventtail is an example consumer of the chat server's analytics
+// stream (CHAT_EVENTS=true). It follows <prefix>events, prints each record,
+// and every -every prints how many events of each type it has seen.
+//
+//	go run ./cmd/eventtail -redis localhost:6379 -prefix chat: -from 0
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type event struct {
+	Type string `json:"type"`
+	Time int64  `json:"t"`
+	User string `json:"user"`
+}
+
+func main() {
+	addr := flag.String("redis", "localhost:6379", "Redis address")
+	prefix := flag.String("prefix", "chat:", "key prefix (CHAT_KEY_PREFIX)")
+	from := flag.String("from", "$", `stream ID to start after; "0" replays everything retained`)
+	quiet := flag.Bool("quiet", false, "only print the periodic counts")
+	every := flag.Duration("every", time.Minute, "how often to print counts")
+	flag.Parse()
+
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: *addr})
+	stream := *prefix + "events"
+
+	counts := map[string]int{}
+	users := map[string]bool{}
+	last := *from
+	nextReport := time.Now().Add(*every)
+	for {
+		res, err := rdb.XRead(ctx, &redis.XReadArgs{Streams: []string{stream, last}, Count: 500, Block: time.Second}).Result()
+		if err != nil && err != redis.Nil {
+			fmt.Fprintln(os.Stderr, "❌", err)
+			os.Exit(1)
+		}
+		for _, s := range res {
+			for _, msg := range s.Messages {
+				last = msg.ID
+				raw, _ := msg.Values["event"].(string)
+				var ev event
+				if json.Unmarshal([]byte(raw), &ev) != nil {
+					continue
+				}
+				counts[ev.Type]++
+				if ev.User != "" {
+					users[ev.User] = true
+				}
+				if !*quiet {
+					fmt.Println(raw)
+				}
+			}
+		}
+
+		if time.Now().After(nextReport) {
+			report(counts, len(users))
+			nextReport = time.Now().Add(*every)
+		}
+	}
+}
+
+func report(counts map[string]int, users int) {
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = fmt.Sprintf("%s=%d", t, counts[t])
+	}
+	fmt.Fprintf(os.Stderr, "📈 %s users=%d\n", strings.Join(parts, " "), users)
+}
@@ -0,0 +1,84 @@
+// Command flakyredis serves the in-memory store (package memredis) over TCP
+// and fails over on demand, for trying the chat server against a Redis
+// outage without a Sentinel setup. On SIGUSR1 it drops every connection and
+// refuses new ones for -down, like a master that died, then takes
+// connections again with the data intact, like the replica promoted in its
+// place.
+//
+//	go run ./cmd/flakyredis -addr 127.0.0.1:6390 -down 3s &
+//	go run . -redis 127.0.0.1:6390
+//	kill -USR1 <flakyredis pid>
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"websocket-chatapp/memredis"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6390", "address to listen on")
+	down := flag.Duration("down", 3*time.Second, "how long a failover refuses connections")
+	flag.Parse()
+
+	mem := memredis.NewServer()
+	var mu sync.Mutex
+	conns := map[net.Conn]bool{}
+
+	listen := func() net.Listener {
+		ln, err := net.Listen("tcp", *addr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		go func() {
+			for {
+				nc, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				mc, _ := mem.Dial(context.Background(), "tcp", *addr)
+				mu.Lock()
+				conns[nc], conns[mc] = true, true
+				mu.Unlock()
+				go pipe(nc, mc)
+				go pipe(mc, nc)
+			}
+		}()
+		return ln
+	}
+
+	ln := listen()
+	fmt.Printf("🧪 Serving an in-memory store at %s; kill -USR1 %d to fail over\n", *addr, os.Getpid())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	for range sig {
+		fmt.Println("💥 Master down at", time.Now().Format("15:04:05.000"))
+		ln.Close()
+		mu.Lock()
+		for c := range conns {
+			c.Close()
+		}
+		clear(conns)
+		mu.Unlock()
+		time.Sleep(*down)
+		ln = listen()
+		fmt.Println("✅ New master up at", time.Now().Format("15:04:05.000"))
+	}
+}
+
+// pipe copies from src to dst until either side closes, then closes both.
+func pipe(dst, src net.Conn) {
+	io.Copy(dst, src)
+	dst.Close()
+	src.Close()
+}
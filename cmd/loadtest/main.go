@@ -0,0 +1,340 @@
+// Command loadtest opens many websocket connections against a chat server,
+// has them exchange public messages and DMs, and reports end-to-end delivery
+// latency and how long connections took to be initialized (dial to
+// init_done). -ramp 0 opens every connection at once, like clients
+// reconnecting after a restart. At the end every connection asks for its
+// conversation list, by then holding the DMs of the run, and the time to
+// the answer is reported too.
+//
+// -churn adds short-lived connections while the others send: that many per
+// second connect under a new name, wait for init_done and leave, like users
+// coming and going. Their dial to init_done times are reported apart.
+//
+// Given the admin token (-token, or $CHAT_ADMIN_TOKEN), the server's
+// /api/stats is read before and after the run to report the Redis round
+// trips its inits made per connection and how many inits took their
+// history from the recent history cache; compare a run against a server
+// started with CHAT_HISTORY_CACHE=0.
+//
+//	go run ./cmd/loadtest -url ws://staging:8080/ws -n 500 -ramp 10s -rate 0.5 -dm 0.2 -duration 1m
+//	go run ./cmd/loadtest -n 2000 -ramp 5s -rate 0.2 -churn 50 -duration 30s
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// Every load message's text is "lt:<send unix nanos>:<seq>", so any receiver
+// can compute latency without coordination. This assumes the load generator
+// runs on one machine (one clock) for senders and receivers.
+const textPrefix = "lt:"
+
+const maxSamples = 200000
+
+type stats struct {
+	sent, received, errors atomic.Int64
+
+	mu      sync.Mutex
+	seen    int64
+	samples []time.Duration
+	inits   []time.Duration // dial to init_done, one per connection
+	churns  []time.Duration // dial to init_done of the -churn connections
+	convs   []time.Duration // conversations request to answer
+}
+
+// record keeps a uniform reservoir sample of latencies so memory stays
+// bounded on long runs.
+func (s *stats) record(d time.Duration) {
+	s.received.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, d)
+		return
+	}
+	if i := rand.Int63n(s.seen); i < maxSamples {
+		s.samples[i] = d
+	}
+}
+
+func (s *stats) recordInit(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inits = append(s.inits, d)
+}
+
+func (s *stats) recordChurn(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.churns = append(s.churns, d)
+}
+
+func (s *stats) recordConversations(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.convs = append(s.convs, d)
+}
+
+// percentile returns the p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "websocket URL of the server")
+	n := flag.Int("n", 100, "number of connections")
+	ramp := flag.Duration("ramp", 5*time.Second, "time over which connections are opened")
+	rate := flag.Float64("rate", 1, "messages per second per connection")
+	dmRatio := flag.Float64("dm", 0.1, "fraction of messages sent as DMs (0..1)")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send after ramp-up")
+	churn := flag.Float64("churn", 0, "short-lived connections opened per second while sending")
+	token := flag.String("token", os.Getenv("CHAT_ADMIN_TOKEN"), "admin token, for reading the server's /api/stats")
+	flag.Parse()
+
+	if *n < 1 || *rate <= 0 || *dmRatio < 0 || *dmRatio > 1 || *churn < 0 {
+		fmt.Fprintln(os.Stderr, "invalid flags")
+		os.Exit(2)
+	}
+
+	run := strconv.FormatInt(time.Now().Unix()%100000, 10)
+	names := make([]string, *n)
+	for i := range names {
+		names[i] = fmt.Sprintf("load-%s-%d", run, i)
+	}
+
+	before := serverStats(*url, *token)
+	var st stats
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	fmt.Printf("🚀 Opening %d connections to %s over %s\n", *n, *url, *ramp)
+	step := *ramp / time.Duration(*n)
+	for i := 0; i < *n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runConn(*url, names, i, *rate, *dmRatio, &st, stop)
+		}(i)
+		time.Sleep(step)
+	}
+
+	fmt.Printf("📨 Sending for %s\n", *duration)
+	if *churn > 0 {
+		fmt.Printf("🔁 Churning %.1f connections per second\n", *churn)
+		var churnWG sync.WaitGroup
+		interval := time.Duration(float64(time.Second) / *churn)
+		for k, end := 0, time.Now().Add(*duration); time.Now().Before(end); k++ {
+			churnWG.Add(1)
+			go func(k int) {
+				defer churnWG.Done()
+				churnConn(*url, fmt.Sprintf("churn-%s-%d", run, k), &st)
+			}(k)
+			time.Sleep(interval)
+		}
+		churnWG.Wait()
+	} else {
+		time.Sleep(*duration)
+	}
+	close(stop)
+	wg.Wait()
+	after := serverStats(*url, *token)
+
+	st.mu.Lock()
+	sort.Slice(st.samples, func(i, j int) bool { return st.samples[i] < st.samples[j] })
+	sort.Slice(st.inits, func(i, j int) bool { return st.inits[i] < st.inits[j] })
+	sort.Slice(st.convs, func(i, j int) bool { return st.convs[i] < st.convs[j] })
+	sort.Slice(st.churns, func(i, j int) bool { return st.churns[i] < st.churns[j] })
+	fmt.Println()
+	fmt.Printf("sent:     %d\n", st.sent.Load())
+	fmt.Printf("received: %d\n", st.received.Load())
+	fmt.Printf("errors:   %d\n", st.errors.Load())
+	fmt.Printf("latency:  p50=%s p95=%s p99=%s\n", percentile(st.samples, 0.50), percentile(st.samples, 0.95), percentile(st.samples, 0.99))
+	fmt.Printf("init:     p50=%s p95=%s p99=%s max=%s (%d connections)\n", percentile(st.inits, 0.50), percentile(st.inits, 0.95), percentile(st.inits, 0.99), percentile(st.inits, 1), len(st.inits))
+	if *churn > 0 {
+		fmt.Printf("churn:    p50=%s p95=%s p99=%s max=%s (%d connections)\n", percentile(st.churns, 0.50), percentile(st.churns, 0.95), percentile(st.churns, 0.99), percentile(st.churns, 1), len(st.churns))
+	}
+	fmt.Printf("convs:    p50=%s p95=%s p99=%s max=%s (%d connections)\n", percentile(st.convs, 0.50), percentile(st.convs, 0.95), percentile(st.convs, 0.99), percentile(st.convs, 1), len(st.convs))
+	if before != nil && after != nil && len(st.inits)+len(st.churns) > 0 {
+		trips := after.InitRedisTrips - before.InitRedisTrips
+		fmt.Printf("redis:    %.2f init round trips per connection (%d), history cache hits=%d misses=%d\n",
+			float64(trips)/float64(len(st.inits)+len(st.churns)), trips,
+			after.HistoryCacheHits-before.HistoryCacheHits, after.HistoryCacheMisses-before.HistoryCacheMisses)
+	}
+	st.mu.Unlock()
+}
+
+// initCounters are the /api/stats fields about init's Redis reads.
+type initCounters struct {
+	InitRedisTrips     int64 `json:"initRedisTrips"`
+	HistoryCacheHits   int64 `json:"historyCacheHits"`
+	HistoryCacheMisses int64 `json:"historyCacheMisses"`
+}
+
+// serverStats reads the init counters from the /api/stats of the server
+// behind the websocket URL, with the admin token, or returns nil if it
+// can't.
+func serverStats(wsURL, token string) *initCounters {
+	if token == "" {
+		return nil
+	}
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return nil
+	}
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	u.Path, u.RawQuery = "/api/stats", ""
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ reading server stats: %v\n", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	var c initCounters
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&c) != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ reading server stats: %s\n", resp.Status)
+		return nil
+	}
+	return &c
+}
+
+// churnConn connects as name, waits for init_done and leaves.
+func churnConn(url, name string, st *stats) {
+	dialed := time.Now()
+	c, err := client.Dial(url)
+	if err != nil {
+		st.errors.Add(1)
+		return
+	}
+	defer c.Close()
+	if err := c.Join(name); err != nil {
+		st.errors.Add(1)
+		return
+	}
+	for {
+		f, err := c.Read()
+		if err != nil {
+			st.errors.Add(1)
+			return
+		}
+		if f.Type == "init_done" {
+			st.recordChurn(time.Since(dialed))
+			return
+		}
+	}
+}
+
+func runConn(url string, names []string, i int, rate, dmRatio float64, st *stats, stop chan struct{}) {
+	name := names[i]
+	dialed := time.Now()
+	c, err := client.Dial(url)
+	if err != nil {
+		st.errors.Add(1)
+		return
+	}
+	defer c.Close()
+
+	if err := c.Join(name); err != nil {
+		st.errors.Add(1)
+		return
+	}
+
+	var convsAsked atomic.Int64 // unix nanos
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			f, err := c.Read()
+			if err != nil {
+				select {
+				case <-stop:
+				default:
+					st.errors.Add(1)
+				}
+				return
+			}
+			if f.Type == "init_done" {
+				st.recordInit(time.Since(dialed))
+				continue
+			}
+			if f.Type == "conversations" {
+				st.recordConversations(time.Since(time.Unix(0, convsAsked.Load())))
+				continue
+			}
+			// Skip our own DM echoes; everyone else's copy is a real delivery.
+			if f.Message == nil || f.Message.User == name {
+				continue
+			}
+			rest, ok := strings.CutPrefix(f.Message.Text, textPrefix)
+			if !ok {
+				continue
+			}
+			sentAt, _, _ := strings.Cut(rest, ":")
+			if ns, err := strconv.ParseInt(sentAt, 10, 64); err == nil {
+				st.record(time.Since(time.Unix(0, ns)))
+			}
+		}
+	}()
+
+	interval := time.Duration(float64(time.Second) / rate)
+	// Spread first sends so connections don't fire in lockstep.
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(interval) + 1)))
+	defer timer.Stop()
+
+	for seq := 0; ; seq++ {
+		select {
+		case <-stop:
+			convsAsked.Store(time.Now().UnixNano())
+			if c.SendFrame(protocol.ConversationsRequest{Type: protocol.TypeConversations}) != nil {
+				st.errors.Add(1)
+			}
+			// Give in-flight messages a moment to land before closing.
+			time.Sleep(2 * time.Second)
+			c.Close()
+			<-readDone
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+
+		text := fmt.Sprintf("%s%d:%d", textPrefix, time.Now().UnixNano(), seq)
+		if len(names) > 1 && rand.Float64() < dmRatio {
+			to := names[rand.Intn(len(names))]
+			for to == name {
+				to = names[rand.Intn(len(names))]
+			}
+			err = c.SendDM(name, to, text)
+		} else {
+			err = c.Send(name, text)
+		}
+		if err != nil {
+			st.errors.Add(1)
+			return
+		}
+		st.sent.Add(1)
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"log"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"websocket-chatapp/i18n"
+)
+
+// config holds settings read from CHAT_* environment variables (and the
+// optional CHAT_CONFIG_FILE) at startup. Some of them can be changed at
+// runtime; see reload.go.
+type config struct {
+	// HistoryLimit is how many public messages a new connection receives.
+	HistoryLimit int
+	// HistoryMax caps the messages kept per conversation, and
+	// HistoryRetention how long they are kept; 0 keeps them all.
+	HistoryMax       int
+	HistoryRetention time.Duration
+	// InitHistoryChunk caps the messages sent per init/history_chunk frame.
+	InitHistoryChunk int
+	// InitMemberPage caps the members listed in init; the rest are fetched
+	// with members_page.
+	InitMemberPage int
+	// InitConcurrency caps the connections computing their init state at
+	// once; InitCacheTTL is how long a computed state is shared.
+	InitConcurrency int
+	InitCacheTTL    time.Duration
+	// HistoryCache is how many recent public messages an instance keeps in
+	// memory for init; HistoryCacheCheck how often it checks them against
+	// Redis. See historycache.go.
+	HistoryCache      int
+	HistoryCacheCheck time.Duration
+	// MemberResync is how often the member cache is reloaded in full.
+	MemberResync time.Duration
+	// ReconnectJitter bounds the random delay a shutting-down server
+	// suggests to each client before it reconnects.
+	ReconnectJitter time.Duration
+	// DrainWindow is how long a draining instance takes to ask all its
+	// clients to reconnect elsewhere.
+	DrainWindow time.Duration
+	// AppPingInterval is how often the server sends application-level
+	// pings to measure per-connection RTT.
+	AppPingInterval time.Duration
+	// RTTPingInterval is how often the server sends websocket ping control
+	// frames to measure per-connection RTT; RTTSlow is the smoothed RTT
+	// above which a connection is flagged as slow.
+	RTTPingInterval time.Duration
+	RTTSlow         time.Duration
+	// MaxClockSkew is how far in the future a client-supplied timestamp
+	// may be before it is rejected.
+	MaxClockSkew time.Duration
+	// DedupeWindow is how long a public message's tempId is remembered so
+	// that a resend is not stored twice; 0 turns it off.
+	DedupeWindow time.Duration
+	// RoomMaxMembers is the capacity of rooms that don't set their own.
+	RoomMaxMembers int
+	// MaxRooms caps the rooms of a workspace, and RoomCreateQuota the rooms
+	// each user may create per UTC day (QuotaExempt users and admins
+	// aside). RoomIdleArchive archives rooms without members or messages
+	// for that long; 0 never does.
+	MaxRooms        int
+	RoomCreateQuota int
+	RoomIdleArchive time.Duration
+	// ReadOnlyRooms are room name patterns (path.Match syntax) where only
+	// the owner may post.
+	ReadOnlyRooms []string
+	// RoomChannels publishes room messages once on the room's channel
+	// instead of on every member's personal channel. Off while instances
+	// older than room channels still run; see fanout.go.
+	RoomChannels bool
+	// ListSpectators shows spectators in the member list, marked as such;
+	// otherwise they are not listed at all.
+	ListSpectators bool
+	// DailyQuota caps messages per user per UTC day; 0 is unlimited.
+	DailyQuota int
+	// QuotaExempt lists users (admins, bots) without a quota.
+	QuotaExempt []string
+	// AutoReplyCooldown is how long an auto-reply waits before answering
+	// the same sender again.
+	AutoReplyCooldown time.Duration
+	// E2EMaxPayload caps the base64 payload of an e2e_dm, in bytes.
+	E2EMaxPayload int
+	// Middleware names the built-in middleware to run, in order.
+	Middleware []string
+	// AlertKeywords are matched by the keyword_alert middleware.
+	AlertKeywords []string
+	// MaxLinks is the limit enforced by the max_links middleware.
+	MaxLinks int
+	// EmojiExpand replaces built-in emoji shortcodes in messages before
+	// they are stored.
+	EmojiExpand bool
+	// MaxMessageChars caps the length of a message's text; 0 is unlimited.
+	MaxMessageChars int
+	// MaxCodeChars caps the length of a code message's text instead; 0 is
+	// four times MaxMessageChars.
+	MaxCodeChars int
+	// ProfanityMode is what the profanity middleware does with a message
+	// containing one of ProfanityWords: "mask" or "reject".
+	ProfanityMode  string
+	ProfanityWords []string
+	// MOTD is the message of the day, shown to every user as a notice
+	// until dismissed.
+	MOTD string
+	// Lang is the language of stored system messages and of connections
+	// that don't pick one. I18nDir, if set, holds catalogs to add to the
+	// built-in ones; see the i18n package.
+	Lang    string
+	I18nDir string
+	// PublicMode layers the hardened preset under the other settings; see
+	// publicmode.go.
+	PublicMode bool
+	// KeyPrefix is prepended to every Redis key and pub/sub channel.
+	KeyPrefix string
+	// AdminToken guards the admin API; empty disables it, as does
+	// CHAT_ADMIN_API=false whatever the token.
+	AdminToken string
+	// APITokenKey is the base64 HMAC key of user API tokens; empty
+	// disables them. AdminReadDMs lets the admin token read any DM
+	// history over REST, each read audited.
+	APITokenKey  string
+	AdminReadDMs bool
+	// DMClearBoth lets dm_clear delete a DM conversation for both sides.
+	DMClearBoth bool
+	// DMToUnknown stores DMs to names that never joined, flagging their
+	// acks, instead of refusing them with unknown_user.
+	DMToUnknown bool
+	// DemoClient serves the embedded web client at /.
+	DemoClient bool
+	// Events mirrors chat events into the analytics stream.
+	Events bool
+	// EventsMaxLen is the approximate length the stream is trimmed to.
+	EventsMaxLen int
+	// EventsBuffer is how many events may wait for the stream writer
+	// before new ones are dropped.
+	EventsBuffer int
+	// EventsHashUsers replaces usernames in events with a keyed hash
+	// (HMAC-SHA256 with EventsSalt).
+	EventsHashUsers bool
+	EventsSalt      string
+	// Firehose records every stored message in its workspace's firehose
+	// stream, trimmed to about FirehoseMaxLen entries, for recorders
+	// reading GET /api/firehose, at most FirehoseConsumers at a time.
+	// FirehoseDMs records DMs and group DMs too.
+	Firehose          bool
+	FirehoseDMs       bool
+	FirehoseMaxLen    int
+	FirehoseConsumers int
+	// FrameKey is the base64 HMAC key for signed connections.
+	FrameKey string
+	// RedisTimeout bounds every Redis command.
+	RedisTimeout time.Duration
+	// RedisSentinels, if set, are the Sentinels to ask for the address of
+	// the master named RedisMaster, instead of connecting to -redis.
+	RedisSentinels []string
+	RedisMaster    string
+	// ReadBufferSize and WriteBufferSize are the websocket I/O buffers each
+	// connection holds for its lifetime; HandshakeTimeout bounds the
+	// upgrade.
+	ReadBufferSize   int
+	WriteBufferSize  int
+	HandshakeTimeout time.Duration
+	// MaxFrameBytes caps one inbound message, all fragments together.
+	MaxFrameBytes int
+	// MaxOutboundBytes caps one frame written to a client. Bigger ones
+	// are cut down by their type's rules, unless OutboundTruncate is off,
+	// or dropped; see framesize.go.
+	MaxOutboundBytes int
+	OutboundTruncate bool
+	// WriteTimeout is how long one write to a client may take before the
+	// client is disconnected as too slow.
+	WriteTimeout time.Duration
+	// PresenceGrace is how long a dropped connection keeps its user
+	// online; see closeClient.
+	PresenceGrace time.Duration
+	// SpillBuffer is how many messages that failed to store may wait for
+	// a retry before new failures are rejected.
+	SpillBuffer int
+	// PublishBuffer is how many broadcasts that failed to publish may
+	// wait for a retry before new failures are dropped.
+	PublishBuffer int
+	// InboundWorkers handle every connection's frames, taking turns
+	// between connections; InboundQueue is how many frames one connection
+	// may have waiting before more are refused. See inqueue.go.
+	InboundWorkers int
+	InboundQueue   int
+	// PubSubTimestamps stamps broadcasts with their origin and publish
+	// time, to measure pub/sub lag and drop repeats; PubSubLagWarn is the lag above which one is
+	// logged.
+	PubSubTimestamps bool
+	PubSubLagWarn    time.Duration
+	// LinkPreviews fetches previews of links in messages.
+	LinkPreviews bool
+	// LinkPreviewAllow, if set, limits previews to these domains (and
+	// their subdomains); LinkPreviewDeny excludes domains.
+	LinkPreviewAllow []string
+	LinkPreviewDeny  []string
+	// Translator picks the translate implementation: "", "noop" or
+	// "http". TranslateURL and TranslateKey configure "http".
+	Translator   string
+	TranslateURL string
+	TranslateKey string
+	// TranslateMaxChars caps the text sent to the translator.
+	TranslateMaxChars int
+	// TranslateRate is how many translations a user may request a minute.
+	TranslateRate int
+	// ActivityStats collects per-user message counters.
+	ActivityStats bool
+	// TrustedProxyHeader names the header a trusted reverse proxy puts the
+	// client address in (X-Forwarded-For by default). It is only believed
+	// from peers in TrustedProxies.
+	TrustedProxyHeader string
+	TrustedProxies     []netip.Prefix
+	// IPAllow, if set, lists the addresses that may open a websocket;
+	// IPDeny those that may not, whatever IPAllow says. ConnKeyHashes are
+	// the SHA-256 digests of the API keys that let a connection past
+	// IPAllow.
+	IPAllow       []netip.Prefix
+	IPDeny        []netip.Prefix
+	ConnKeyHashes []string
+	// AllowedOrigins, if set, are the only Origin headers a websocket
+	// upgrade is accepted with.
+	AllowedOrigins []string
+	// ConnsPerIP caps the connections an address may hold on one
+	// instance; 0 is unlimited.
+	ConnsPerIP int
+	// Guests gives every join a generated guest name, whatever name it
+	// asks for.
+	Guests bool
+	// InviteOnly makes joins need an invite code, or a name that redeemed
+	// one before.
+	InviteOnly bool
+	// JoinRate is how many joins an address may make a minute, NamesPerIP
+	// how many names it may hold at once in a workspace. JoinExempt lists
+	// addresses without either limit.
+	JoinRate   int
+	NamesPerIP int
+	JoinExempt []string
+	// IPBanStrikes rejected joins ban an address for IPBanCooldown.
+	IPBanStrikes  int
+	IPBanCooldown time.Duration
+	// RosterDebounce is the shortest time between two roster updates.
+	RosterDebounce time.Duration
+	// RosterFormat is how connections that don't choose get roster
+	// updates: "diff" (roster_diff frames) or "events" (member_add /
+	// member_remove).
+	RosterFormat string
+	// DeactivatedMembers is "hide" to leave deactivated accounts out of
+	// member search; anything else shows them, flagged.
+	DeactivatedMembers string
+	// PubSub selects the broadcast transport: "redis" or "nats".
+	PubSub string
+	// NATSURL is the NATS server when PubSub is "nats".
+	NATSURL string
+	// Notify picks the offline DM notifier: "", "smtp" or "webhook".
+	Notify string
+	// NotifyThrottle is the minimum time between emails to one recipient
+	// about the same sender.
+	NotifyThrottle time.Duration
+	// NotifyBuffer is how many notifications may wait for the notifier
+	// before new ones are dropped.
+	NotifyBuffer int
+	// SMTP server, sender address, optional PLAIN credentials and optional
+	// text/template file for emails.
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUser     string
+	SMTPPassword string
+	SMTPTemplate string
+	// NotifyWebhookURL receives offline DMs as JSON, with
+	// NotifyWebhookToken as a bearer token when set.
+	NotifyWebhookURL   string
+	NotifyWebhookToken string
+	// KafkaBrokers enables the Kafka bridge when set.
+	KafkaBrokers []string
+	// KafkaTopic receives every chat message, keyed by conversation.
+	KafkaTopic string
+	// KafkaBatchSize and KafkaLinger bound how many records are sent per
+	// request and how long the bridge waits to fill a batch.
+	KafkaBatchSize int
+	KafkaLinger    time.Duration
+	// KafkaRetries is how often a failed batch is retried before its
+	// records go to KafkaDeadLetter.
+	KafkaRetries    int
+	KafkaDeadLetter string
+	// KafkaBuffer is how many records may wait for the bridge before new
+	// ones are dropped.
+	KafkaBuffer int
+	// WebhookBuffer is how many deliveries may wait for the outgoing
+	// webhook workers before new ones are dropped.
+	WebhookBuffer int
+}
+
+// The live config is never modified in place: a reload builds a new one
+// and swaps the pointer (see reload.go), so readers see either the old
+// settings or the new ones, never a mix.
+var liveConfig atomic.Pointer[config]
+
+func init() {
+	if err := loadConfigFile(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	conf := loadConfig()
+	for _, s := range invalidSettings {
+		if name, _, _ := strings.Cut(s, "="); slices.Contains(addressSettings, name) {
+			log.Fatal("❌ Refusing to start with an invalid address list")
+		}
+	}
+	if err := checkPublicMode(&conf); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	liveConfig.Store(&conf)
+}
+
+// cfg returns the live config. Callers must treat it as read-only.
+func cfg() *config { return liveConfig.Load() }
+
+// loadConfig builds a config from the current settings; see setting.
+func loadConfig() config {
+	return config{
+		HistoryLimit:      envInt("CHAT_HISTORY_LIMIT", 20),
+		HistoryMax:        envInt("CHAT_HISTORY_MAX", 0),
+		HistoryRetention:  envDuration("CHAT_HISTORY_RETENTION", 0),
+		InitHistoryChunk:  envInt("CHAT_INIT_HISTORY_CHUNK", 100),
+		InitMemberPage:    envInt("CHAT_INIT_MEMBER_PAGE", 500),
+		InitConcurrency:   envInt("CHAT_INIT_CONCURRENCY", 32),
+		InitCacheTTL:      envDuration("CHAT_INIT_CACHE_TTL", 500*time.Millisecond),
+		HistoryCache:      envInt("CHAT_HISTORY_CACHE", 200),
+		HistoryCacheCheck: envDuration("CHAT_HISTORY_CACHE_CHECK", 30*time.Second),
+		MemberResync:      envDuration("CHAT_MEMBER_RESYNC", 30*time.Second),
+		ReconnectJitter:   envDuration("CHAT_RECONNECT_JITTER", 10*time.Second),
+		DrainWindow:       envDuration("CHAT_DRAIN_WINDOW", 30*time.Second),
+		AppPingInterval:   envDuration("CHAT_APP_PING_INTERVAL", 30*time.Second),
+		RTTPingInterval:   envDuration("CHAT_RTT_PING_INTERVAL", 15*time.Second),
+		RTTSlow:           envDuration("CHAT_RTT_SLOW", time.Second),
+		MaxClockSkew:      envDuration("CHAT_MAX_CLOCK_SKEW", 5*time.Minute),
+		DedupeWindow:      envDurationOff("CHAT_DEDUPE_WINDOW", time.Minute),
+		RoomMaxMembers:    envInt("CHAT_ROOM_MAX_MEMBERS", 1000),
+		MaxRooms:          envInt("CHAT_MAX_ROOMS", 10000),
+		RoomCreateQuota:   envInt("CHAT_ROOM_CREATE_QUOTA", 10),
+		RoomIdleArchive:   envDuration("CHAT_ROOM_IDLE_ARCHIVE", 0),
+		ReadOnlyRooms:     splitList(setting("CHAT_READONLY_ROOMS")),
+		RoomChannels:      envBool("CHAT_ROOM_CHANNELS", true),
+
+		ListSpectators:    envBool("CHAT_LIST_SPECTATORS", true),
+		DailyQuota:        envInt("CHAT_DAILY_QUOTA", 0),
+		QuotaExempt:       splitList(setting("CHAT_QUOTA_EXEMPT")),
+		AutoReplyCooldown: envDuration("CHAT_AUTOREPLY_COOLDOWN", 4*time.Hour),
+		E2EMaxPayload:     envInt("CHAT_E2E_MAX_PAYLOAD", 64*1024),
+		Middleware:        splitList(setting("CHAT_MIDDLEWARE")),
+		AlertKeywords:     splitList(setting("CHAT_ALERT_KEYWORDS")),
+		MaxLinks:          envInt("CHAT_MAX_LINKS", 3),
+		EmojiExpand:       envBool("CHAT_EMOJI_EXPAND", true),
+		MaxMessageChars:   envInt("CHAT_MAX_MESSAGE_CHARS", 0),
+		MaxCodeChars:      envInt("CHAT_MAX_CODE_CHARS", 0),
+		ProfanityMode:     envString("CHAT_PROFANITY_MODE", profanityMask),
+		ProfanityWords:    splitList(envString("CHAT_PROFANITY_WORDS", defaultProfanity)),
+		MOTD:              envString("CHAT_MOTD", ""),
+		Lang:              envString("CHAT_LANG", i18n.Fallback),
+		I18nDir:           setting("CHAT_I18N_DIR"),
+		PublicMode:        envBool("CHAT_PUBLIC_MODE", false),
+
+		KeyPrefix:          envString("CHAT_KEY_PREFIX", "chat:"),
+		AdminToken:         adminToken(),
+		APITokenKey:        setting("CHAT_API_TOKEN_KEY"),
+		AdminReadDMs:       envBool("CHAT_ADMIN_READ_DMS", false),
+		DMClearBoth:        envBool("CHAT_DM_CLEAR_BOTH", false),
+		DMToUnknown:        envBool("CHAT_DM_TO_UNKNOWN", false),
+		DemoClient:         envBool("CHAT_DEMO_CLIENT", true),
+		Events:             envBool("CHAT_EVENTS", false),
+		EventsMaxLen:       envInt("CHAT_EVENTS_MAXLEN", 100000),
+		EventsBuffer:       envInt("CHAT_EVENTS_BUFFER", 10000),
+		EventsHashUsers:    envBool("CHAT_EVENTS_HASH_USERS", false),
+		EventsSalt:         setting("CHAT_EVENTS_SALT"),
+		Firehose:           envBool("CHAT_FIREHOSE", false),
+		FirehoseDMs:        envBool("CHAT_FIREHOSE_DMS", false),
+		FirehoseMaxLen:     envInt("CHAT_FIREHOSE_MAXLEN", 1000000),
+		FirehoseConsumers:  envInt("CHAT_FIREHOSE_CONSUMERS", 2),
+		FrameKey:           setting("CHAT_FRAME_KEY"),
+		RedisTimeout:       envDuration("CHAT_REDIS_TIMEOUT", 2*time.Second),
+		RedisSentinels:     splitList(setting("CHAT_REDIS_SENTINELS")),
+		RedisMaster:        envString("CHAT_REDIS_MASTER", "mymaster"),
+		ReadBufferSize:     envInt("CHAT_READ_BUFFER_SIZE", 4096),
+		WriteBufferSize:    envInt("CHAT_WRITE_BUFFER_SIZE", 4096),
+		HandshakeTimeout:   envDuration("CHAT_HANDSHAKE_TIMEOUT", 10*time.Second),
+		WriteTimeout:       envDuration("CHAT_WRITE_TIMEOUT", 10*time.Second),
+		PresenceGrace:      envDurationOff("CHAT_PRESENCE_GRACE", 15*time.Second),
+		MaxFrameBytes:      envInt("CHAT_MAX_FRAME_BYTES", 128*1024),
+		MaxOutboundBytes:   envInt("CHAT_MAX_OUTBOUND_BYTES", 1024*1024),
+		OutboundTruncate:   envBool("CHAT_OUTBOUND_TRUNCATE", true),
+		SpillBuffer:        envInt("CHAT_SPILL_BUFFER", 1000),
+		PublishBuffer:      envInt("CHAT_PUBLISH_BUFFER", 10000),
+		InboundWorkers:     envInt("CHAT_INBOUND_WORKERS", 64),
+		InboundQueue:       envInt("CHAT_INBOUND_QUEUE", 64),
+		PubSubTimestamps:   envBool("CHAT_PUBSUB_TIMESTAMPS", true),
+		PubSubLagWarn:      envDurationOff("CHAT_PUBSUB_LAG_WARN", 500*time.Millisecond),
+		LinkPreviews:       envBool("CHAT_LINK_PREVIEWS", true),
+		LinkPreviewAllow:   splitList(setting("CHAT_LINK_PREVIEW_ALLOW")),
+		LinkPreviewDeny:    splitList(setting("CHAT_LINK_PREVIEW_DENY")),
+		Translator:         setting("CHAT_TRANSLATOR"),
+		TranslateURL:       setting("CHAT_TRANSLATE_URL"),
+		TranslateKey:       setting("CHAT_TRANSLATE_KEY"),
+		TranslateMaxChars:  envInt("CHAT_TRANSLATE_MAX_CHARS", 2000),
+		TranslateRate:      envInt("CHAT_TRANSLATE_RATE", 10),
+		ActivityStats:      envBool("CHAT_ACTIVITY_STATS", true),
+		TrustedProxyHeader: envString("CHAT_TRUSTED_PROXY_HEADER", "X-Forwarded-For"),
+		TrustedProxies:     envPrefixes("CHAT_TRUSTED_PROXIES"),
+		IPAllow:            envPrefixes("CHAT_IP_ALLOW"),
+		IPDeny:             envPrefixes("CHAT_IP_DENY"),
+		ConnKeyHashes:      hashKeys(splitList(setting("CHAT_CONN_API_KEYS"))),
+		AllowedOrigins:     splitList(setting("CHAT_ALLOWED_ORIGINS")),
+		ConnsPerIP:         envInt("CHAT_CONNS_PER_IP", 0),
+		Guests:             envBool("CHAT_GUESTS", false),
+		InviteOnly:         envBool("CHAT_INVITE_ONLY", false),
+		JoinRate:           envInt("CHAT_JOIN_RATE", 30),
+		NamesPerIP:         envInt("CHAT_NAMES_PER_IP", 10),
+		JoinExempt:         splitList(envString("CHAT_JOIN_EXEMPT", "127.0.0.1,::1")),
+		IPBanStrikes:       envInt("CHAT_IP_BAN_STRIKES", 5),
+		IPBanCooldown:      envDuration("CHAT_IP_BAN_COOLDOWN", 15*time.Minute),
+		RosterDebounce:     envDuration("CHAT_ROSTER_DEBOUNCE", 250*time.Millisecond),
+		RosterFormat:       envString("CHAT_ROSTER_FORMAT", rosterDiff),
+		DeactivatedMembers: envString("CHAT_DEACTIVATED_MEMBERS", "show"),
+		PubSub:             envString("CHAT_PUBSUB", "redis"),
+		NATSURL:            envString("CHAT_NATS_URL", "nats://localhost:4222"),
+		Notify:             setting("CHAT_NOTIFY"),
+		NotifyThrottle:     envDuration("CHAT_NOTIFY_THROTTLE", 15*time.Minute),
+		NotifyBuffer:       envInt("CHAT_NOTIFY_BUFFER", 1000),
+		SMTPAddr:           setting("CHAT_SMTP_ADDR"),
+		SMTPFrom:           setting("CHAT_SMTP_FROM"),
+		SMTPUser:           setting("CHAT_SMTP_USER"),
+		SMTPPassword:       setting("CHAT_SMTP_PASSWORD"),
+		SMTPTemplate:       setting("CHAT_SMTP_TEMPLATE"),
+		NotifyWebhookURL:   setting("CHAT_NOTIFY_WEBHOOK_URL"),
+		NotifyWebhookToken: setting("CHAT_NOTIFY_WEBHOOK_TOKEN"),
+		KafkaBrokers:       splitList(setting("CHAT_KAFKA_BROKERS")),
+		KafkaTopic:         envString("CHAT_KAFKA_TOPIC", "chat-messages"),
+		KafkaBatchSize:     envInt("CHAT_KAFKA_BATCH_SIZE", 500),
+		KafkaLinger:        envDuration("CHAT_KAFKA_LINGER", 50*time.Millisecond),
+		KafkaRetries:       envInt("CHAT_KAFKA_RETRIES", 5),
+		KafkaDeadLetter:    envString("CHAT_KAFKA_DEAD_LETTER", "kafka-dead-letter.jsonl"),
+		KafkaBuffer:        envInt("CHAT_KAFKA_BUFFER", 10000),
+		WebhookBuffer:      envInt("CHAT_WEBHOOK_BUFFER", 1000),
+	}
+}
+
+// adminToken is CHAT_ADMIN_TOKEN, unless CHAT_ADMIN_API=false turns the
+// admin API off.
+func adminToken() string {
+	if !envBool("CHAT_ADMIN_API", true) {
+		return ""
+	}
+	return setting("CHAT_ADMIN_TOKEN")
+}
+
+func envString(name, def string) string {
+	if v := setting(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(name string, def int) int {
+	v := setting(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		invalidSetting(name, v)
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+func envBool(name string, def bool) bool {
+	v := setting(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		invalidSetting(name, v)
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %t", name, v, def)
+		return def
+	}
+	return b
+}
+
+// envPrefixes reads a list of CIDR ranges; a bare address is a range of
+// one. Unlike other settings an invalid one isn't replaced by a default,
+// which would open the list: reloads reject it and startup fails (see
+// init).
+func envPrefixes(name string) []netip.Prefix {
+	v := setting(name)
+	var prefixes []netip.Prefix
+	for _, s := range splitList(v) {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				invalidSetting(name, v)
+				log.Printf("❌ Invalid %s=%q: %q is neither an address nor a CIDR range", name, v, s)
+				return nil
+			}
+			addr = addr.Unmap()
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v := setting(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		invalidSetting(name, v)
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// envDurationOff is envDuration for settings that 0 turns off.
+func envDurationOff(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(setting(name)); err == nil && d == 0 {
+		return 0
+	}
+	return envDuration(name, def)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Conversation registry. Every history zset (public, room, group and DM,
+// one per pair of participants) is added to the workspace's
+// chat:conversations set when a message is first stored in it, so jobs
+// that must visit every conversation (user data deletion and anything like
+// it) read one set instead of SCANning the keyspace. Entries are never
+// removed: a listed key may have been emptied since, and readers must
+// expect that.
+//
+// Deployments that stored messages before the registry existed are
+// backfilled once per workspace by SCANning for history keys; a marker key
+// records that it was done. Until then conversationKeys scans.
+
+// knownConversations remembers keys this instance has registered, so the
+// SADD is paid once per conversation rather than once per message.
+var knownConversations sync.Map
+
+// registerConversation adds key to ws's registry if this instance hasn't
+// already. A failed SADD is retried with the next message.
+func registerConversation(ctx context.Context, ws workspace, key string) {
+	if _, ok := knownConversations.Load(key); ok {
+		return
+	}
+	if rdb.SAdd(ctx, ws.conversationsKey(), key).Err() == nil {
+		knownConversations.Store(key, struct{}{})
+	}
+}
+
+// conversationKeys returns every history zset key of ws, backfilling the
+// registry first if it never was.
+func conversationKeys(ctx context.Context, ws workspace) ([]string, error) {
+	if n, _ := rdb.Exists(ctx, ws.conversationsBackfilledKey()).Result(); n == 0 {
+		if err := backfillConversations(ctx, ws); err != nil {
+			return nil, err
+		}
+	}
+	return rdb.SMembers(ctx, ws.conversationsKey()).Result()
+}
+
+// backfillConversations registers the history keys stored before the
+// registry existed. Running it twice, or on several instances at once, is
+// harmless.
+func backfillConversations(ctx context.Context, ws workspace) error {
+	patterns := []string{ws.messagesKey(), ws.roomMessagesKey("*"), ws.groupMessagesKey("*"), ws.dmKey("*", "*")}
+	found := 0
+	for _, p := range patterns {
+		iter := rdb.Scan(ctx, 0, p, 500).Iterator()
+		for iter.Next(ctx) {
+			if err := rdb.SAdd(ctx, ws.conversationsKey(), iter.Val()).Err(); err != nil {
+				return err
+			}
+			found++
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("backfilling the conversation registry: %w", err)
+		}
+	}
+	if err := rdb.Set(ctx, ws.conversationsBackfilledKey(), "1", 0).Err(); err != nil {
+		return err
+	}
+	if found > 0 && ws == defaultWorkspace {
+		fmt.Printf("🗂 Registered %d existing conversation(s)\n", found)
+	} else if found > 0 {
+		fmt.Printf("🗂 Registered %d existing conversation(s) in workspace %q\n", found, ws)
+	}
+	return nil
+}
+
+// backfillAllConversations migrates every workspace that needs it, at
+// startup and in the background. The DM index (see sidebar.go) is built
+// from the registry, so it comes second.
+func backfillAllConversations(ctx context.Context) {
+	for _, ws := range knownWorkspaces(ctx) {
+		if n, _ := rdb.Exists(ctx, ws.conversationsBackfilledKey()).Result(); n == 0 {
+			if err := backfillConversations(ctx, ws); err != nil {
+				log.Printf("❌ Conversation registry backfill of workspace %q failed: %v", ws, err)
+				continue
+			}
+		}
+		if n, _ := rdb.Exists(ctx, ws.dmIndexBackfilledKey()).Result(); n == 0 {
+			if err := backfillDMIndex(ctx, ws); err != nil {
+				log.Printf("❌ DM index backfill of workspace %q failed: %v", ws, err)
+			}
+		}
+	}
+}
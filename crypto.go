@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// At-rest encryption. When CHAT_ENCRYPTION_KEYS (or _FILE) is set, stored
+// messages and every pub/sub payload are sealed with AES-256-GCM. A sealed
+// value is
+//
+//	0x01 base64url(keyID[4] | nonce[12] | ciphertext)
+//
+// where the leading byte is the format version and keyID is the first four
+// bytes of the key's SHA-256. Anything else is plaintext, so entries written
+// before encryption was enabled stay readable. The first key encrypts; the
+// rest only decrypt, which is how keys are rotated (see reencrypt).
+const sealedV1 = 0x01
+
+type sealKey struct {
+	id   [4]byte
+	aead cipher.AEAD
+}
+
+var (
+	sealKeys []sealKey // sealKeys[0] encrypts; nil means encryption is off
+
+	errUnknownKey = errors.New("sealed with an unknown key")
+	errBadSealed  = errors.New("malformed sealed payload")
+)
+
+// initEncryption loads the keys: base64 32-byte keys, comma or newline
+// separated, current key first.
+func initEncryption() error {
+	raw := os.Getenv("CHAT_ENCRYPTION_KEYS")
+	if file := os.Getenv("CHAT_ENCRYPTION_KEYS_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		raw = string(b)
+	}
+
+	sealKeys = nil
+	for _, k := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("encryption key %d: want base64 of 32 bytes", len(sealKeys)+1)
+		}
+		block, _ := aes.NewCipher(key)
+		aead, _ := cipher.NewGCM(block)
+		sum := sha256.Sum256(key)
+		sk := sealKey{aead: aead}
+		copy(sk.id[:], sum[:4])
+		sealKeys = append(sealKeys, sk)
+	}
+	if len(sealKeys) > 0 {
+		fmt.Printf("🔒 Encrypting messages at rest (%d key(s) loaded)\n", len(sealKeys))
+	}
+	return nil
+}
+
+// seal encrypts data with the current key, or returns it unchanged when
+// encryption is off.
+func seal(data []byte) string {
+	if len(sealKeys) == 0 {
+		return string(data)
+	}
+	k := sealKeys[0]
+	buf := make([]byte, 4+k.aead.NonceSize(), 4+k.aead.NonceSize()+len(data)+k.aead.Overhead())
+	copy(buf, k.id[:])
+	rand.Read(buf[4:])
+	buf = k.aead.Seal(buf, buf[4:], data, nil)
+	return string([]byte{sealedV1}) + base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// unseal reverses seal. Plaintext passes through unchanged.
+func unseal(s string) ([]byte, error) {
+	if s == "" || s[0] != sealedV1 {
+		return []byte(s), nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(s[1:])
+	if err != nil || len(buf) < 4 {
+		return nil, errBadSealed
+	}
+	for _, k := range sealKeys {
+		if string(k.id[:]) != string(buf[:4]) {
+			continue
+		}
+		n := k.aead.NonceSize()
+		if len(buf) < 4+n {
+			return nil, errBadSealed
+		}
+		return k.aead.Open(nil, buf[4:4+n], buf[4+n:], nil)
+	}
+	return nil, errUnknownKey
+}
+
+// sealedWithCurrentKey reports whether s is already sealed with sealKeys[0].
+func sealedWithCurrentKey(s string) bool {
+	if len(sealKeys) == 0 || s == "" || s[0] != sealedV1 {
+		return false
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(s[1:])
+	return err == nil && len(buf) >= 4 && string(buf[:4]) == string(sealKeys[0].id[:])
+}
+
+// publish sends payload on channel, sealed like stored messages, since
+// pub/sub traffic crosses the network too, and stamped (see pubsublag.go).
+func publish(channel string, payload []byte) {
+	publishSealed(channel, stampPayload(seal(payload)))
+}
+
+// openPayload unseals a pub/sub message, logging and dropping ones it can't
+// decrypt (e.g. from an instance with a key this one doesn't have), and
+// resubscription markers. It records the payload's pub/sub lag.
+func openPayload(msg pubsubMessage) ([]byte, bool) {
+	if msg.Resubscribed {
+		return nil, false
+	}
+	data, err := unseal(unstampPayload(msg.Channel, msg.Payload))
+	if err != nil {
+		log.Println("❌ Dropping pub/sub payload on", msg.Channel+":", err)
+		return nil, false
+	}
+	return data, true
+}
+
+// chatserver reencrypt
+//
+// Rewrites every stored message that is plaintext or sealed with an older
+// key so it is sealed with the current key. Safe to run repeatedly and
+// while servers are running.
+func runReencrypt(args []string) {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	addr := fs.String("redis", redisAddr, "Redis address")
+	fs.Parse(args)
+
+	if err := initEncryption(); err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		os.Exit(1)
+	}
+	if len(sealKeys) == 0 {
+		fmt.Fprintln(os.Stderr, "❌ No encryption keys configured")
+		os.Exit(1)
+	}
+	initRedis(*addr)
+
+	n, err := reencryptAll(serverCtx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Re-encryption failed:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "🔒 Re-encrypted %d message(s)\n", n)
+}
+
+func reencryptAll(ctx context.Context) (int, error) {
+	total := 0
+	iter := rdb.Scan(ctx, 0, redisKey("*"), 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if typ, _ := rdb.Type(ctx, key).Result(); typ != "zset" {
+			continue
+		}
+		n, err := reencryptZset(ctx, key)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", key, err)
+		}
+		total += n
+	}
+	return total, iter.Err()
+}
+
+// reencryptZset re-seals the message entries of one zset. Members that are
+// neither JSON nor sealed (names in the presence and search indexes) are
+// left alone.
+func reencryptZset(ctx context.Context, key string) (int, error) {
+	n := 0
+	iter := rdb.ZScan(ctx, key, 0, "*", 500).Iterator()
+	for iter.Next(ctx) {
+		member := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+		score, _ := strconv.ParseFloat(iter.Val(), 64)
+
+		if member == "" || (member[0] != '{' && member[0] != sealedV1) || sealedWithCurrentKey(member) {
+			continue
+		}
+		plain, err := unseal(member)
+		if err != nil {
+			return n, err
+		}
+
+		pipe := rdb.TxPipeline()
+		pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: seal(plain)})
+		pipe.ZRem(ctx, key, member)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, iter.Err()
+}
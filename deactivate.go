@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Account deactivation, short of deletion: nothing is removed. A user
+// deactivates their own account with {"type":"deactivate"}, or the admin
+// (or the user, with their API token) with
+//
+//	PUT /api/users/<name>/status?workspace=  {"status":"deactivated","reason":"..."}
+//
+// The state is chat:user:<name>:status (hash: status, by, reason, since).
+// Every connection of the user, on every instance, gets an
+// account_deactivated frame and is closed. From then on, joins with the
+// name are refused with account_deactivated, so nobody else can take it
+// over either, and so are frames sent as the name without joining; DMs to
+// the user and adding them to a group DM are refused with
+// recipient_deactivated. Member search shows them with "deactivated":true,
+// or leaves them out with CHAT_DEACTIVATED_MEMBERS=hide. Their rooms, group
+// DMs and history stay as they were, so {"status":"active"}, which deletes
+// the key, restores everything.
+const (
+	statusActive      = "active"
+	statusDeactivated = "deactivated"
+
+	deactivatedMembersHide = "hide"
+)
+
+var deactivatedPrefix = []byte(`{"type":"account_deactivated"`)
+
+// isDeactivated reports whether name's account is deactivated.
+func isDeactivated(ctx context.Context, ws workspace, name string) bool {
+	status, _ := rdb.HGet(ctx, ws.statusKey(name), "status").Result()
+	return status == statusDeactivated
+}
+
+// deactivateAccount records the deactivation and closes name's
+// connections everywhere.
+func deactivateAccount(ctx context.Context, ws workspace, name, by, reason string) error {
+	err := rdb.HSet(ctx, ws.statusKey(name), map[string]interface{}{
+		"status": statusDeactivated,
+		"by":     by,
+		"reason": reason,
+		"since":  time.Now().Unix(),
+	}).Err()
+	if err != nil {
+		return err
+	}
+	frame, _ := json.Marshal(protocol.NewAccountDeactivated(by, reason))
+	publish(ws.userChannel(name), frame)
+	log.Printf("💤 %s deactivated the account %q in workspace %q", by, name, ws)
+	publishAdminEvent(protocol.AdminEvent{Event: "deactivate", Workspace: string(ws), Name: name, By: by, Reason: reason})
+	return nil
+}
+
+// reactivateAccount lifts a deactivation.
+func reactivateAccount(ctx context.Context, ws workspace, name, by string) error {
+	n, err := rdb.Del(ctx, ws.statusKey(name)).Result()
+	if err != nil || n == 0 {
+		return err
+	}
+	log.Printf("💤 %s reactivated the account %q in workspace %q", by, name, ws)
+	publishAdminEvent(protocol.AdminEvent{Event: "reactivate", Workspace: string(ws), Name: name, By: by})
+	return nil
+}
+
+// isDeactivatedFrame reports whether payload is a protocol.AccountDeactivated.
+func isDeactivatedFrame(payload []byte) bool {
+	return bytes.HasPrefix(payload, deactivatedPrefix)
+}
+
+// rejectDeactivated sends an account_deactivated error if name is
+// deactivated.
+func rejectDeactivated(c *client, name string) bool {
+	if !isDeactivated(c.ctx, c.ws, name) {
+		return false
+	}
+	sendError(c, "account_deactivated", "the account "+name+" is deactivated", "name", name)
+	return true
+}
+
+// rejectDeactivatedPeer sends a recipient_deactivated error if peer is
+// deactivated.
+func rejectDeactivatedPeer(c *client, peer string) bool {
+	if !isDeactivated(c.ctx, c.ws, peer) {
+		return false
+	}
+	sendError(c, "recipient_deactivated", peer+"'s account is deactivated", "name", peer)
+	return true
+}
+
+// {"type":"deactivate","reason":"taking a break"}
+func handleDeactivate(c *client, data []byte) {
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	var req protocol.DeactivateRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid deactivate frame")
+		return
+	}
+	if err := deactivateAccount(c.ctx, c.ws, name, name, req.Reason); err != nil {
+		sendError(c, "not_stored", "deactivation could not be stored; please retry")
+	}
+}
+
+// GET /api/users/<name>/status?workspace= reports the account's status;
+// PUT with {"status":"deactivated"|"active","reason":"..."} changes it.
+// Either takes the admin token, or the user's own API token (whose
+// workspace is used).
+func handleUserStatusAPI(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+	var ws workspace
+	var by string
+	if adminRequest(r) {
+		var ok bool
+		if ws, ok = lookupWorkspace(ctx, r.URL.Query().Get("workspace")); !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		name = resolveName(ctx, ws, name)
+		by = "admin@" + clientIP(r)
+	} else {
+		tokenWS, user, ok := verifyUser(w, r)
+		if !ok {
+			return
+		}
+		if name = resolveName(ctx, tokenWS, name); user != name {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ws, by = tokenWS, name
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Status string `json:"status"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid status request", http.StatusBadRequest)
+			return
+		}
+		var err error
+		switch req.Status {
+		case statusDeactivated:
+			err = deactivateAccount(ctx, ws, name, by, req.Reason)
+		case statusActive:
+			// A user may only undo their own deactivation, not the admin's.
+			if deactivatedBy, _ := rdb.HGet(ctx, ws.statusKey(name), "by").Result(); !adminRequest(r) && deactivatedBy != "" && deactivatedBy != name {
+				http.Error(w, "forbidden: the account was deactivated by "+deactivatedBy, http.StatusForbidden)
+				return
+			}
+			err = reactivateAccount(ctx, ws, name, by)
+		default:
+			http.Error(w, "status must be deactivated or active", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("❌ Account status error:", err)
+			http.Error(w, "could not change the status", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, _ := rdb.HGetAll(ctx, ws.statusKey(name)).Result()
+	if status["status"] != statusDeactivated {
+		status = map[string]string{"status": statusActive}
+	}
+	reply := map[string]interface{}{"name": name, "status": status["status"]}
+	for _, k := range []string{"by", "reason"} {
+		if status[k] != "" {
+			reply[k] = status[k]
+		}
+	}
+	if since, _ := strconv.ParseInt(status["since"], 10, 64); since > 0 {
+		reply["since"] = since
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
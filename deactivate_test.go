@@ -0,0 +1,159 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestDeactivatedToken checks that a deactivated user's API token reaches
+// nothing but their status, and reactivates the account only when the
+// user deactivated it themselves.
+func TestDeactivatedToken(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_API_TOKEN_KEY=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	code, data := api(t, addr, http.MethodPost, "/api/admin/tokens", []byte(`{"workspace":"","name":"alice"}`))
+	var issued struct {
+		Token string `json:"token"`
+	}
+	if code != http.StatusOK || json.Unmarshal(data, &issued) != nil {
+		t.Fatalf("issuing a token got %d: %s", code, data)
+	}
+	status := func(as, body string, want int) {
+		t.Helper()
+		method := http.MethodGet
+		if body != "" {
+			method = http.MethodPut
+		}
+		if code, data := apiAs(t, addr, as, method, "/api/users/alice/status?workspace=", []byte(body)); code != want {
+			t.Errorf("%s %s got %d, want %d: %s", method, body, code, want, data)
+		}
+	}
+	history := func(want int) {
+		t.Helper()
+		if code, data := apiAs(t, addr, issued.Token, http.MethodGet, "/api/dm/bob/messages", nil); code != want {
+			t.Errorf("DM history got %d, want %d: %s", code, want, data)
+		}
+	}
+
+	history(http.StatusOK)
+	status(issued.Token, `{"status":"deactivated"}`, http.StatusOK)
+	history(http.StatusForbidden)
+	status(issued.Token, "", http.StatusOK)
+	status(issued.Token, `{"status":"active"}`, http.StatusOK)
+	history(http.StatusOK)
+
+	status(adminToken, `{"status":"deactivated","reason":"spam"}`, http.StatusOK)
+	status(issued.Token, `{"status":"active"}`, http.StatusForbidden)
+	history(http.StatusForbidden)
+	status(adminToken, `{"status":"active"}`, http.StatusOK)
+	history(http.StatusOK)
+}
+
+// TestDeactivationPaths checks every path that refuses a deactivated
+// account: joining as it, DMs to it of each kind, putting it in a group
+// DM, and member search, with one instance showing and one hiding it; and
+// that reactivating it undoes them all.
+func TestDeactivationPaths(t *testing.T) {
+	store := serveStore(t, 0)
+	show := startServer(t, store, withAdmin)
+	hide := startServer(t, store, withAdmin, "CHAT_DEACTIVATED_MEMBERS=hide")
+	alice := dial(t, show, "", "alice")
+	dial(t, show, "", "bob")
+	dial(t, show, "", "dave")
+	carol := dial(t, show, "", "carol")
+	carolLaptop := dial(t, hide, "", "carol")
+	alice.SendFrame(protocol.GroupDMCreateRequest{Type: protocol.TypeGroupDMCreate, Members: []string{"bob", "dave"}})
+	var group protocol.GroupDM
+	decode(t, await(t, alice, protocol.TypeGroupDM), &group)
+
+	carol.SendFrame(protocol.DeactivateRequest{Type: protocol.TypeDeactivate, Reason: "taking a break"})
+	for _, c := range []*client.Client{carol, carolLaptop} {
+		var frame protocol.AccountDeactivated
+		decode(t, await(t, c, protocol.TypeAccountDeactivated), &frame)
+		if frame.By != "carol" || frame.Reason != "taking a break" {
+			t.Errorf("got %+v, want carol's own deactivation", frame)
+		}
+	}
+
+	t.Run("join", func(t *testing.T) {
+		for _, name := range []string{"carol", "CAROL"} {
+			c, err := client.Dial("ws://" + show + "/ws")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.Join(name)
+			refused(t, c, "account_deactivated")
+		}
+	})
+
+	t.Run("DMs", func(t *testing.T) {
+		alice.SendFrame(protocol.SendRequest{Type: protocol.TypeDM, To: "carol", Text: "hi"})
+		refused(t, alice, "recipient_deactivated")
+		alice.SendDM("alice", "Carol", "hi")
+		refused(t, alice, "recipient_deactivated")
+		alice.SendFrame(protocol.E2EDMRequest{Type: protocol.TypeE2EDM, To: "carol", Payload: "aGk="})
+		refused(t, alice, "recipient_deactivated")
+	})
+
+	t.Run("group DMs", func(t *testing.T) {
+		alice.SendFrame(protocol.GroupDMCreateRequest{Type: protocol.TypeGroupDMCreate, Members: []string{"bob", "carol"}})
+		refused(t, alice, "recipient_deactivated")
+		alice.SendFrame(protocol.GroupDMAddRequest{Type: protocol.TypeGroupDMAdd, ID: group.ID, Member: "carol"})
+		refused(t, alice, "recipient_deactivated")
+	})
+
+	t.Run("lookups", func(t *testing.T) {
+		alice.SendFrame(protocol.UserExistsRequest{Type: protocol.TypeUserExists, Name: "carol"})
+		var exists protocol.UserExists
+		decode(t, await(t, alice, protocol.TypeUserExists), &exists)
+		if !exists.Exists || !exists.Deactivated {
+			t.Errorf("user_exists got %+v, want carol deactivated", exists)
+		}
+		if m, ok := searchMember(t, show, "carol"); !ok || !m.Deactivated {
+			t.Errorf("member search with show got %+v (found %v), want carol deactivated", m, ok)
+		}
+		if m, ok := searchMember(t, hide, "carol"); ok {
+			t.Errorf("member search with hide got %+v", m)
+		}
+	})
+
+	t.Run("reactivated", func(t *testing.T) {
+		if code, data := api(t, show, http.MethodPut, "/api/users/carol/status?workspace=", []byte(`{"status":"active"}`)); code != http.StatusOK {
+			t.Fatalf("reactivating got %d: %s", code, data)
+		}
+		carol := dial(t, hide, "", "CAROL")
+		carol.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello}) // until carol's join is handled
+		await(t, carol, protocol.TypeHello)
+		alice.SendFrame(protocol.SendRequest{Type: protocol.TypeDM, To: "carol", Text: "welcome back"})
+		if f := await(t, carol, "message"); f.Message.Text != "welcome back" {
+			t.Errorf("carol got %q, want alice's DM", f.Message.Text)
+		}
+		alice.SendFrame(protocol.GroupDMAddRequest{Type: protocol.TypeGroupDMAdd, ID: group.ID, Member: "carol"})
+		await(t, carol, protocol.TypeGroupDM)
+		if m, ok := searchMember(t, hide, "carol"); !ok || m.Deactivated {
+			t.Errorf("member search got %+v (found %v), want carol active", m, ok)
+		}
+	})
+}
+
+// searchMember looks name up with GET /api/members on the server at addr.
+func searchMember(t *testing.T, addr, name string) (protocol.MemberMatch, bool) {
+	t.Helper()
+	code, data := api(t, addr, http.MethodGet, "/api/members?prefix="+name, nil)
+	var res struct {
+		Results []protocol.MemberMatch `json:"results"`
+	}
+	if err := json.Unmarshal(data, &res); code != http.StatusOK || err != nil {
+		t.Fatalf("GET /api/members: %d %s", code, data)
+	}
+	for _, m := range res.Results {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return protocol.MemberMatch{}, false
+}
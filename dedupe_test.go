@@ -0,0 +1,61 @@
+package main_test
+
+import (
+	"testing"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestResendDeduped has alice resend a message with its tempId after the
+// ack and again from a new connection: both resends must get the first
+// ack back and reach nobody. Two messages with the same text but their
+// own tempIds, sent back to back, must both be stored and delivered.
+func TestResendDeduped(t *testing.T) {
+	addr := startServer(t, "")
+	bob := dial(t, addr, "", "bob")
+	alice := dial(t, addr, "", "alice")
+	send := func(c *client.Client, tempID, text string) {
+		c.SendFrame(protocol.SendRequest{Type: protocol.TypeMsg, Text: text, TempID: tempID})
+	}
+	// acks reads c's frames until it has an ack for each of tempIDs.
+	acks := func(c *client.Client, tempIDs ...string) map[string]protocol.Ack {
+		t.Helper()
+		got := map[string]protocol.Ack{}
+		for len(got) < len(tempIDs) {
+			var ack protocol.Ack
+			decode(t, await(t, c, protocol.TypeAck), &ack)
+			got[ack.TempID] = ack
+		}
+		return got
+	}
+
+	send(alice, "c1", "hello")
+	first := acks(alice, "c1")["c1"]
+	send(alice, "c1", "hello")
+	if again := acks(alice, "c1")["c1"]; again != first {
+		t.Errorf("the resend was acked with %+v, want the first ack %+v", again, first)
+	}
+	alice.Close()
+	alice = dial(t, addr, "", "alice")
+	send(alice, "c1", "hello")
+	if again := acks(alice, "c1")["c1"]; again != first {
+		t.Errorf("the resend after reconnecting was acked with %+v, want the first ack %+v", again, first)
+	}
+
+	send(alice, "c2", "same text")
+	send(alice, "c3", "same text")
+	both := acks(alice, "c2", "c3")
+	if both["c2"].ID == both["c3"].ID || both["c2"].ID == first.ID {
+		t.Errorf("c2 and c3 were acked with %+v, want a message each", both)
+	}
+	send(alice, "", "end")
+
+	seen := map[string]int{}
+	for text := ""; text != "end"; seen[text]++ {
+		text = awaitText(t, bob)
+	}
+	if seen["hello"] != 1 || seen["same text"] != 2 {
+		t.Errorf("bob got %v, want hello once and same text twice", seen)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// DM delivery status. A DM is "sent" once stored and "delivered" once it
+// has been written to at least one of the recipient's connections, on any
+// instance. Deliveries are kept per sender and recipient in
+// chat:delivered:<sender>:<recipient> (hash: message ID -> unix ms), so the
+// first device to receive a message records it and tells the sender with
+//
+//	{"type":"delivered","id":"...","to":"bob","at":1700000000000}
+//
+// and later devices find it already recorded. DMs to a user who is offline
+// stay "sent": the server has no backlog it replays on connect, so they are
+// never written to a connection of theirs. dm_status reports the recorded
+// status of a sender's messages, e.g. after a reconnect.
+const (
+	deliveryTTL       = 30 * 24 * time.Hour // refreshed by every delivery
+	maxDMStatusLookup = 200
+)
+
+// publishDM sends a stored DM to the personal channels of both its
+// participants, so each of their devices sees it exactly once: the
+// recipient's copy has "direction":"in", the sender's "out", and both name
+// the recipient in "to". The sending connection gets its copy this way too,
+// with its tempId added (see tempid.go); nothing writes it directly.
+func publishDM(ws workspace, msg ChatMessage, to string) {
+	msg.To, msg.Direction = to, "in"
+	in, _ := json.Marshal(msg)
+	publish(ws.userChannel(to), in)
+	if msg.User == to {
+		return
+	}
+	msg.Direction = "out"
+	out, _ := json.Marshal(msg)
+	publish(ws.userChannel(msg.User), out)
+}
+
+// markDelivered records that payload, just written to c, reached its
+// recipient, if it is a DM to c's user.
+func markDelivered(c *client, payload []byte) {
+	if !bytes.HasPrefix(payload, []byte(`{"id":`)) {
+		return
+	}
+	var msg struct {
+		ID   string `json:"id"`
+		User string `json:"user"`
+		Type string `json:"type"`
+	}
+	name := c.userName()
+	if json.Unmarshal(payload, &msg) != nil || msg.Type != "" || msg.ID == "" || msg.User == "" || msg.User == name {
+		return
+	}
+
+	ctx, ws := serverCtx, c.ws
+	at := time.Now().UnixMilli()
+	key := ws.deliveredKey(msg.User, name)
+	if first, err := rdb.HSetNX(ctx, key, msg.ID, at).Result(); err != nil || !first {
+		return
+	}
+	rdb.Expire(ctx, key, deliveryTTL)
+	frame, _ := json.Marshal(protocol.NewDelivered(msg.ID, name, at))
+	publish(ws.userChannel(msg.User), frame)
+}
+
+// {"type":"dm_status","to":"bob","ids":["...", ...]} returns the status of
+// your DMs to bob: {"type":"dm_status","to":"bob","statuses":{"<id>":
+// {"status":"delivered","at":1700000000000}, "<id>":{"status":"sent"}}}.
+func handleDMStatus(c *client, data []byte) {
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	var req protocol.DMStatusRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.To == "" || len(req.IDs) == 0 || len(req.IDs) > maxDMStatusLookup {
+		sendError(c, "bad_frame", "invalid dm_status frame")
+		return
+	}
+	req.To = resolveName(c.ctx, c.ws, req.To)
+	c.writeJSON(protocol.NewDMStatus(req.To, dmStatuses(c.ctx, c.ws, name, req.To, req.IDs)))
+}
+
+func dmStatuses(ctx context.Context, ws workspace, sender, receiver string, ids []string) map[string]protocol.DeliveryStatus {
+	statuses := make(map[string]protocol.DeliveryStatus, len(ids))
+	vals, _ := rdb.HMGet(ctx, ws.deliveredKey(sender, receiver), ids...).Result()
+	for i, id := range ids {
+		st := protocol.DeliveryStatus{Status: "sent"}
+		if i < len(vals) {
+			if at := counterValue(vals[i]); at > 0 {
+				st = protocol.DeliveryStatus{Status: "delivered", At: at}
+			}
+		}
+		statuses[id] = st
+	}
+	return statuses
+}
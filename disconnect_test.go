@@ -0,0 +1,198 @@
+package main_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestDisconnectTriggers disconnects a user in each way a connection can
+// end and checks that every one leaves the same state behind: the
+// connection gone from the instance, its room channel unsubscribed, the
+// user offline, and one member_remove sent, never two.
+func TestDisconnectTriggers(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_PRESENCE_GRACE=0", "CHAT_WRITE_TIMEOUT=200ms")
+	mod := adminConn(t, addr, "mod")
+	flooder := rawJoin(t, addr, "flooder")
+	t.Cleanup(func() { flooder.Close() })
+	go func() {
+		for {
+			if _, _, err := flooder.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(conn *websocket.Conn, v interface{}) {
+		data, _ := json.Marshal(v)
+		conn.WriteMessage(websocket.TextMessage, data)
+	}
+	cases := []struct {
+		name    string
+		trigger func(t *testing.T, name string, victim *websocket.Conn)
+		kind    string // the close frame's kind, "" if the victim can't read it
+	}{
+		{"client close", func(t *testing.T, _ string, victim *websocket.Conn) {
+			victim.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		}, ""},
+		{"connection reset", func(t *testing.T, _ string, victim *websocket.Conn) {
+			tcp := victim.NetConn().(*net.TCPConn)
+			tcp.SetLinger(0)
+			tcp.Close()
+		}, ""},
+		{"leave", func(t *testing.T, _ string, victim *websocket.Conn) {
+			send(victim, map[string]string{"type": protocol.TypeLeave})
+		}, protocol.CloseLeft},
+		{"kick", func(t *testing.T, name string, _ *websocket.Conn) {
+			mod.SendFrame(protocol.ModerationRequest{Type: protocol.TypeAdminKick, Name: name})
+		}, protocol.CloseKicked},
+		// A ban kicks the user's connections; the banned kind is for
+		// joins refused afterwards.
+		{"ban", func(t *testing.T, name string, _ *websocket.Conn) {
+			mod.SendFrame(protocol.ModerationRequest{Type: protocol.TypeAdminBan, Name: name})
+		}, protocol.CloseKicked},
+		{"deactivate", func(t *testing.T, _ string, victim *websocket.Conn) {
+			send(victim, protocol.DeactivateRequest{Type: protocol.TypeDeactivate})
+		}, protocol.CloseAccountDeactivated},
+		{"invalid UTF-8", func(t *testing.T, _ string, victim *websocket.Conn) {
+			victim.WriteMessage(websocket.TextMessage, []byte{0xff, 0xfe})
+		}, protocol.CloseBadFrame},
+		{"write timeout", func(t *testing.T, _ string, victim *websocket.Conn) {
+			// The victim stops reading; the flood fills its buffers.
+			victim.NetConn().(*net.TCPConn).SetReadBuffer(4096)
+			var s struct {
+				SlowEvictions int64 `json:"slowEvictions"`
+			}
+			stats(t, addr, &s)
+			before := s.SlowEvictions
+			big := strings.Repeat("x", 100*1024)
+			for i := 0; s.SlowEvictions == before; i++ {
+				if i == 200 {
+					t.Fatal("no write timed out after 20MB of messages")
+				}
+				flooder.WriteMessage(websocket.TextMessage, []byte("msg:flooder:"+big))
+				time.Sleep(5 * time.Millisecond)
+				stats(t, addr, &s)
+			}
+		}, ""},
+	}
+	victim := func(trigger string) string { return "victim-" + strings.ReplaceAll(trigger, " ", "-") }
+	var victims []string
+	for _, tc := range cases {
+		victims = append(victims, victim(tc.name))
+	}
+	removed := rosterRemovals(t, dial(t, addr, "?roster=events", "bob"), victims)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := victim(tc.name)
+			var s struct {
+				Channels int `json:"channelsSubscribed"`
+			}
+			stats(t, addr, &s)
+			channels := s.Channels
+
+			victim := rawJoin(t, addr, name)
+			t.Cleanup(func() { victim.Close() })
+			send(victim, protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: name})
+			awaitRaw(t, victim, protocol.TypeRoomJoined)
+			awaitConnections(t, addr, name, 1)
+
+			tc.trigger(t, name, victim)
+			if tc.kind != "" {
+				if got := closeKind(t, victim); got != tc.kind {
+					t.Errorf("closed as %q, want %q", got, tc.kind)
+				}
+			}
+
+			awaitConnections(t, addr, name, 0)
+			select {
+			case <-removed[name]:
+			case <-time.After(5 * time.Second):
+				t.Fatal("bob got no member_remove")
+			}
+			if searchOnline(t, addr, name) {
+				t.Error("still online")
+			}
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+				if stats(t, addr, &s); s.Channels == channels {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%d channels subscribed, want %d as before joining", s.Channels, channels)
+				}
+			}
+			select {
+			case <-removed[name]:
+				t.Error("bob got a second member_remove")
+			case <-time.After(300 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// rosterRemovals reads c's frames for the rest of the test, signalling
+// each member_remove of one of names on that name's channel.
+func rosterRemovals(t *testing.T, c *client.Client, names []string) map[string]chan struct{} {
+	removed := map[string]chan struct{}{}
+	for _, name := range names {
+		removed[name] = make(chan struct{}, 2)
+	}
+	go func() {
+		for {
+			f, err := c.Read()
+			if err != nil {
+				return
+			}
+			var remove protocol.MemberRemove
+			if f.Type == protocol.TypeMemberRemove && json.Unmarshal(f.Raw, &remove) == nil && removed[remove.Name] != nil {
+				removed[remove.Name] <- struct{}{}
+			}
+		}
+	}()
+	return removed
+}
+
+// awaitRaw reads conn's frames up to one of type typ.
+func awaitRaw(t *testing.T, conn *websocket.Conn, typ string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no %s frame: %v", typ, err)
+		}
+		var f struct{ Type string }
+		if json.Unmarshal(data, &f); f.Type == typ {
+			return
+		}
+	}
+}
+
+// closeKind reads conn's frames up to the close frame and returns its
+// kind.
+func closeKind(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) {
+			t.Fatalf("no close frame: %v", err)
+		}
+		reason, _ := protocol.DecodeCloseReason(ce.Text)
+		return reason.Kind
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"websocket-chatapp/protocol"
+)
+
+// Clearing a DM conversation:
+//
+//	{"type":"dm_clear","peer":"bob"}
+//
+// removes the conversation from the requester's view only. The messages
+// stay (bob still sees them); the requester gets a watermark in
+// chat:user:<name>:cleared (peer -> time of the newest message cleared), and
+// everything that reads DM history for them (REST history, unread counts,
+// the first unread message, the conversation list, message lookups) skips
+// messages at or before it. Times are in seconds, so a message that lands
+// in the same second as the newest cleared one is cleared with it.
+//
+// With "both":true and CHAT_DM_CLEAR_BOTH on, the conversation is deleted
+// for both participants instead. Either way every connection concerned is
+// told with a dm_cleared frame.
+func handleDMClear(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.DMClearRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Peer == "" {
+		sendError(c, "bad_frame", "invalid dm_clear frame")
+		return
+	}
+	req.Peer = resolveName(ctx, ws, req.Peer)
+	if req.Both && !cfg().DMClearBoth {
+		sendError(c, "forbidden", "clearing a conversation for both sides is disabled")
+		return
+	}
+
+	if req.Both {
+		if err := deleteDMConversation(ctx, ws, name, req.Peer); err != nil {
+			log.Println("❌ DM clear error:", err)
+			sendError(c, "internal", "could not clear conversation")
+			return
+		}
+		log.Printf("🧹 %s deleted their DMs with %s", name, req.Peer)
+		publishDMCleared(ws, name, req.Peer, name, true, 0)
+		if req.Peer != name {
+			publishDMCleared(ws, req.Peer, name, name, true, 0)
+		}
+		return
+	}
+
+	until, err := clearDMConversation(ctx, ws, name, req.Peer)
+	if err != nil {
+		log.Println("❌ DM clear error:", err)
+		sendError(c, "internal", "could not clear conversation")
+		return
+	}
+	publishDMCleared(ws, name, req.Peer, name, false, until)
+}
+
+// clearDMConversation hides name's DMs with peer from name and returns the
+// watermark: the time of the newest of them, 0 if there are none.
+func clearDMConversation(ctx context.Context, ws workspace, name, peer string) (int64, error) {
+	newest, err := rdb.ZRevRangeWithScores(ctx, ws.timeIndexKey(ws.dmKey(name, peer)), 0, 0).Result()
+	if err != nil || len(newest) == 0 {
+		return 0, err
+	}
+	until := int64(newest[0].Score)
+	// Watermarks only move forward, so a stale device can't bring
+	// cleared messages back.
+	if dmClearedAt(ctx, ws, name, peer) >= until {
+		return until, nil
+	}
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, ws.dmClearedKey(name), peer, until)
+	// Out of the conversation list until the next message re-adds it.
+	pipe.ZRem(ctx, ws.userDMsKey(name), peer)
+	_, err = pipe.Exec(ctx)
+	return until, err
+}
+
+// deleteDMConversation deletes the conversation between a and b, with its
+// time index, counter, delivery receipts and watermarks.
+func deleteDMConversation(ctx context.Context, ws workspace, a, b string) error {
+	key := ws.dmKey(a, b)
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, key, ws.timeIndexKey(key))
+	pipe.HDel(ctx, ws.seqKey(), key)
+	pipe.Del(ctx, ws.deliveredKey(a, b), ws.deliveredKey(b, a))
+	pipe.ZRem(ctx, ws.userDMsKey(a), b)
+	pipe.ZRem(ctx, ws.userDMsKey(b), a)
+	pipe.HDel(ctx, ws.dmClearedKey(a), b)
+	pipe.HDel(ctx, ws.dmClearedKey(b), a)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// publishDMCleared tells name's connections that their conversation with
+// peer was cleared by by: up to until, or entirely if both.
+func publishDMCleared(ws workspace, name, peer, by string, both bool, until int64) {
+	frame := protocol.NewDMCleared(peer, by, both)
+	if !both {
+		frame.Until = until
+	}
+
+	raw, _ := json.Marshal(frame)
+	publish(ws.userChannel(name), raw)
+}
+
+// dmClearedAt is name's watermark for the conversation with peer, 0 if it
+// was never cleared.
+func dmClearedAt(ctx context.Context, ws workspace, name, peer string) int64 {
+	raw, _ := rdb.HGet(ctx, ws.dmClearedKey(name), peer).Result()
+	until, _ := strconv.ParseInt(raw, 10, 64)
+	return until
+}
+
+// dmWatermarks returns all of name's watermarks by peer.
+func dmWatermarks(ctx context.Context, ws workspace, name string) map[string]int64 {
+	all, _ := rdb.HGetAll(ctx, ws.dmClearedKey(name)).Result()
+	return parseWatermarks(all)
+}
+
+func parseWatermarks(all map[string]string) map[string]int64 {
+	watermarks := make(map[string]int64, len(all))
+	for peer, raw := range all {
+		watermarks[peer], _ = strconv.ParseInt(raw, 10, 64)
+	}
+	return watermarks
+}
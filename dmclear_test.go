@@ -0,0 +1,109 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+	"websocket-chatapp/usertoken"
+)
+
+// TestDMClearAsymmetric has alice clear her conversation with bob. She
+// must stop seeing its messages, in the conversation list and over REST,
+// while bob's view is untouched; a message after the clear must show up
+// for her alone, counted as her only unread one.
+func TestDMClearAsymmetric(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	addr := startServer(t, "", "CHAT_API_TOKEN_KEY=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	alice := dial(t, addr, "", "alice")
+	bob := dial(t, addr, "", "bob")
+	// Each DM comes back to its sender too; once both have it, it is
+	// stored.
+	alice.SendDM("alice", "bob", "one")
+	awaitText(t, alice)
+	awaitText(t, bob)
+	bob.SendDM("bob", "alice", "two")
+	awaitText(t, bob)
+	awaitText(t, alice)
+
+	// history is the texts of name's DMs with peer over REST, sorted.
+	history := func(name, peer string) []string {
+		t.Helper()
+		token := usertoken.Issue(key, usertoken.Claims{User: name, Expires: time.Now().Add(time.Hour).Unix()})
+		code, data := apiAs(t, addr, token, http.MethodGet, "/api/dm/"+peer+"/messages", nil)
+		if code != http.StatusOK {
+			t.Fatalf("%s's history got %d: %s", name, code, data)
+		}
+		var page struct {
+			Messages []struct {
+				Text string `json:"text"`
+				Time int64  `json:"time"`
+			} `json:"messages"`
+		}
+		json.Unmarshal(data, &page)
+		var texts []string
+		for _, m := range page.Messages {
+			texts = append(texts, m.Text)
+		}
+		slices.Sort(texts)
+		return texts
+	}
+	// conversation is the conversation list entry of c's DMs with peer.
+	conversation := func(c *client.Client, peer string) *protocol.Conversation {
+		t.Helper()
+		c.SendFrame(protocol.ConversationsRequest{Type: protocol.TypeConversations})
+		var convs protocol.Conversations
+		decode(t, await(t, c, protocol.TypeConversations), &convs)
+		for _, conv := range convs.Conversations {
+			if conv.Conversation == "dm:"+peer {
+				return &conv
+			}
+		}
+		return nil
+	}
+
+	alice.SendFrame(protocol.DMClearRequest{Type: protocol.TypeDMClear, Peer: "bob"})
+	var cleared protocol.DMCleared
+	decode(t, await(t, alice, protocol.TypeDMCleared), &cleared)
+	if cleared.Peer != "bob" || cleared.By != "alice" || cleared.Both || cleared.Until == 0 {
+		t.Errorf("alice got %+v", cleared)
+	}
+	bob.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello})
+	if f := await(t, bob, protocol.TypeHello, protocol.TypeDMCleared); f.Type != protocol.TypeHello {
+		t.Errorf("bob was told of alice's clear: %s", f.Raw)
+	}
+
+	if conv := conversation(alice, "bob"); conv != nil {
+		t.Errorf("alice's conversation list still has bob: %+v", conv)
+	}
+	if got := history("alice", "bob"); len(got) != 0 {
+		t.Errorf("alice's history after clearing: %q", got)
+	}
+	if conv := conversation(bob, "alice"); conv == nil || conv.Last == nil || conv.Last.Snippet != "two" {
+		t.Errorf("bob's conversation with alice is %+v, want it ending with two", conv)
+	}
+	if got, want := history("bob", "alice"), []string{"one", "two"}; !slices.Equal(got, want) {
+		t.Errorf("bob's history %q, want %q", got, want)
+	}
+
+	// The watermark is in seconds: a message in the same second as the
+	// newest cleared one is cleared with it.
+	time.Sleep(time.Until(time.Unix(cleared.Until+1, 0)))
+	bob.SendDM("bob", "alice", "three")
+	if got := awaitText(t, alice); got != "three" {
+		t.Fatalf("alice got %q, want bob's new message", got)
+	}
+	if conv := conversation(alice, "bob"); conv == nil || conv.Last == nil || conv.Last.Snippet != "three" || conv.Unread != 1 {
+		t.Errorf("alice's conversation with bob is %+v, want three as its only unread message", conv)
+	}
+	if got, want := history("alice", "bob"), []string{"three"}; !slices.Equal(got, want) {
+		t.Errorf("alice's history %q, want %q", got, want)
+	}
+	if got, want := history("bob", "alice"), []string{"one", "three", "two"}; !slices.Equal(got, want) {
+		t.Errorf("bob's history %q, want %q", got, want)
+	}
+}
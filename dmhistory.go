@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// DM history over REST, for integrations:
+//
+//	GET /api/dm/<peer>/messages?before=<unix>&limit=50
+//
+// With a user token (see apitoken.go) it returns the DMs between the
+// token's user and peer, oldest first. Pages run
+// backwards in time: the reply's next, passed as before, gives the page
+// before it, and is absent on the oldest page. A page never splits the
+// messages of one second (times are in seconds), so none are skipped or
+// repeated; it has fewer than limit messages when that would happen, or
+// more when over limit messages share one second.
+//
+// With CHAT_ADMIN_READ_DMS on, the admin token may read any conversation,
+// naming one participant with ?user= (and ?workspace=). Every such read is
+// first recorded in the chat:audit stream and on the admin feed; if it
+// can't be recorded, it is refused.
+const (
+	defaultDMHistoryPage = 50
+	maxDMHistoryPage     = 200
+	maxAuditEntries      = 10000
+)
+
+func handleDMHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest, _ := strings.CutPrefix(r.URL.Path, "/api/dm/")
+	peer, ok := strings.CutSuffix(rest, "/messages")
+	if !ok || peer == "" || strings.Contains(peer, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	var before int64
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid before", http.StatusBadRequest)
+			return
+		}
+		before = n
+	}
+	limit := defaultDMHistoryPage
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDMHistoryPage)
+	}
+
+	var ws workspace
+	var user string
+	if adminRequest(r) {
+		if !cfg().AdminReadDMs {
+			http.Error(w, "admin reads of DMs are disabled (CHAT_ADMIN_READ_DMS)", http.StatusForbidden)
+			return
+		}
+		if ws, ok = lookupWorkspace(ctx, q.Get("workspace")); !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		if user = resolveName(ctx, ws, q.Get("user")); user == "" {
+			http.Error(w, "name one participant with ?user=", http.StatusBadRequest)
+			return
+		}
+		peer = resolveName(ctx, ws, peer)
+		ev := protocol.AdminEvent{Event: "dm_read", Workspace: string(ws), Name: user, By: "admin@" + clientIP(r), Reason: "DMs with " + peer}
+		if err := audit(ctx, ev); err != nil {
+			log.Println("❌ Audit log error:", err)
+			http.Error(w, "could not record the read in the audit log", http.StatusInternalServerError)
+			return
+		}
+	} else if ws, user, ok = requireUser(w, r); !ok {
+		return
+	} else {
+		peer = resolveName(ctx, ws, peer)
+	}
+
+	// Users don't see what they cleared (see dmclear.go); audited admin
+	// reads see everything.
+	var after int64
+	if !adminRequest(r) {
+		after = dmClearedAt(ctx, ws, user, peer)
+	}
+	msgs, next, err := dmHistoryPage(ctx, ws, user, peer, after, before, limit)
+	if err != nil {
+		log.Println("❌ DM history error:", err)
+		http.Error(w, "could not read history", http.StatusInternalServerError)
+		return
+	}
+	page := map[string]interface{}{
+		"user":     user,
+		"peer":     peer,
+		"messages": msgs,
+	}
+	if next > 0 {
+		page["next"] = next
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// adminRequest reports whether r carries the admin token.
+func adminRequest(r *http.Request) bool {
+	token := cfg().AdminToken
+	return token != "" && ids.Equal(bearerToken(r), token)
+}
+
+// dmHistoryPage returns up to limit DMs between user and peer newer than
+// after and older than before (0 for the newest), oldest first, and the
+// before of the page preceding it, or 0 if there is none.
+func dmHistoryPage(ctx context.Context, ws workspace, user, peer string, after, before int64, limit int) ([]ChatMessage, int64, error) {
+	min, max := "-inf", "+inf"
+	if after > 0 {
+		min = "(" + strconv.FormatInt(after, 10)
+	}
+	if before > 0 {
+		max = "(" + strconv.FormatInt(before, 10)
+	}
+	key := ws.dmKey(user, peer)
+	// limit+1, to know whether there is an older page.
+	raws, err := latestBetween(ctx, ws, key, min, max, int64(limit)+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	msgs := append([]ChatMessage{}, decodeHistory(raws)...)
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Time < msgs[j].Time })
+	if len(msgs) <= limit {
+		return msgs, 0, nil
+	}
+
+	page := msgs[len(msgs)-limit:]
+	t := page[0].Time
+	if msgs[len(msgs)-limit-1].Time != t {
+		return page, t, nil
+	}
+	// The cut falls inside second t: leave all of it to the next page...
+	for len(page) > 0 && page[0].Time == t {
+		page = page[1:]
+	}
+	if len(page) > 0 {
+		return page, t + 1, nil
+	}
+	// ...unless it is the whole page; then return all of it.
+	second := strconv.FormatInt(t, 10)
+	raws, err = messagesBetween(ctx, ws, key, second, second, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return decodeHistory(raws), t, nil
+}
+
+// audit records an admin's access to private data, an export (see
+// export.go) or a firehose read (see firehose.go), in the chat:audit stream (about maxAuditEntries kept) and on
+// the admin feed.
+func audit(ctx context.Context, ev protocol.AdminEvent) error {
+	ev.Type = "admin_event"
+	ev.Time = time.Now().Unix()
+	raw, _ := json.Marshal(ev)
+	err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: auditKey(),
+		MaxLen: maxAuditEntries,
+		Approx: true,
+		Values: []string{"event", string(raw)},
+	}).Err()
+	if err != nil {
+		return err
+	}
+	publishAdminEvent(ev)
+	switch {
+	case ev.Event == "export_start":
+		log.Printf("🕵 %s is exporting %s in workspace %q", ev.By, ev.Export.Scope, ev.Workspace)
+	case ev.Event == "firehose_start":
+		log.Printf("🕵 %s is reading the firehose of workspace %q %s", ev.By, ev.Workspace, ev.Message)
+	case ev.Event == "firehose_end":
+		log.Printf("🕵 %s stopped reading the firehose of workspace %q: %s", ev.By, ev.Workspace, ev.Message)
+	case ev.Export != nil:
+		log.Printf("🕵 %s exported %s in workspace %q: %d messages, %d bytes", ev.By, ev.Export.Scope, ev.Workspace, ev.Export.Messages, ev.Export.Bytes)
+	default:
+		log.Printf("🕵 %s read %s's %s in workspace %q", ev.By, ev.Name, ev.Reason, ev.Workspace)
+	}
+	return nil
+}
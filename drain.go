@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/protocol"
+)
+
+// Draining, for deploys. SIGUSR1 or POST /api/admin/drain makes the
+// instance fail /readyz (so the load balancer stops sending it upgrades)
+// and refuse new connections, then, over CHAT_DRAIN_WINDOW, asks its
+// clients in random batches of equal size to move:
+//
+//	{"type":"reconnect","after":730}
+//
+// "after" is a random delay in ms within one batch period, so arrivals at
+// the other instances are spread evenly over the window. Clients stay
+// connected, and keep receiving messages, until they reconnect; those
+// still here drainGrace after the window are closed with 1001. The
+// instance then idles, not ready, until it is stopped.
+const (
+	drainBatchPeriod = time.Second
+	drainGrace       = 5 * time.Second
+)
+
+var (
+	draining     atomic.Bool
+	drainStarted atomic.Int64 // unix ms
+	drainAsked   atomic.Int64 // reconnect frames sent
+)
+
+// watchDrainSignal starts a drain on SIGUSR1.
+func watchDrainSignal() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			startDrain()
+		}
+	}()
+}
+
+// startDrain begins draining; it reports false if already draining.
+func startDrain() bool {
+	if !draining.CompareAndSwap(false, true) {
+		return false
+	}
+	drainStarted.Store(time.Now().UnixMilli())
+	window := cfg().DrainWindow
+	fmt.Printf("🚰 Draining %d connection(s) over %s\n", len(connectedClients()), window)
+	go runDrain(window)
+	return true
+}
+
+func runDrain(window time.Duration) {
+	list := connectedClients()
+	mathrand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+
+	batches := max(int(window/drainBatchPeriod), 1)
+	size := (len(list) + batches - 1) / batches
+	tick := time.NewTicker(drainBatchPeriod)
+	defer tick.Stop()
+	for len(list) > 0 {
+		batch := list[:min(size, len(list))]
+		list = list[len(batch):]
+		for _, c := range batch {
+			c.writeJSON(protocol.NewReconnect(mathrand.Int64N(drainBatchPeriod.Milliseconds())))
+
+			drainAsked.Add(1)
+		}
+		select {
+		case <-tick.C:
+		case <-serverCtx.Done():
+			return
+		}
+	}
+
+	select {
+	case <-time.After(drainGrace):
+	case <-serverCtx.Done():
+		return
+	}
+	stragglers := connectedClients()
+	for _, c := range stragglers {
+		closeClient(c, websocket.CloseGoingAway, reconnectAdvice())
+	}
+	log.Printf("🚰 Drain finished; closed %d straggler(s)", len(stragglers))
+}
+
+// GET /readyz answers 200 while the instance takes connections and 503
+// once it is draining, or while a pub/sub listener waits to be restarted
+// (see listeners.go), naming it.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if down := listeners.Down(); len(down) > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, s := range down {
+			fmt.Fprintf(w, "listener %s down (%s), restarted %d time(s)\n", s.Name, s.Reason, s.Restarts)
+		}
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// POST /api/admin/drain starts draining this instance; GET reports
+// progress.
+func handleDrainAPI(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !startDrain() {
+			status = http.StatusConflict // already draining
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instance":    instanceID,
+		"draining":    draining.Load(),
+		"started":     drainStarted.Load(),
+		"asked":       drainAsked.Load(),
+		"connections": len(connectedClients()),
+	})
+}
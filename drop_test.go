@@ -0,0 +1,98 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestAbruptDrop has alice's TCP connection reset mid-conversation, with
+// no close frame, right after she sends a message, so the server's writes
+// to her fail as well as its read. She must be gone from the server's
+// connections at once but stay online for CHAT_PRESENCE_GRACE; then bob
+// must get her member_remove. Dropped again, she comes straight back: bob
+// must never see her leave, and she must have one session.
+func TestAbruptDrop(t *testing.T) {
+	const grace = time.Second
+	addr := startServer(t, "", withAdmin, "CHAT_PRESENCE_GRACE="+grace.String())
+	bob := dial(t, addr, "?roster=events", "bob")
+
+	dropped := time.Now()
+	dropConn(t, rawJoin(t, addr, "alice"))
+	awaitConnections(t, addr, "alice", 0)
+	if !slices.Contains(memberNames(t, bob), "alice") {
+		t.Error("alice went offline within the grace period")
+	}
+	var remove protocol.MemberRemove
+	decode(t, await(t, bob, protocol.TypeMemberRemove), &remove)
+	if remove.Name != "alice" {
+		t.Errorf("bob got member_remove for %q", remove.Name)
+	}
+	if waited := time.Since(dropped); waited < grace {
+		t.Errorf("alice went offline %s after dropping, within the grace period", waited)
+	}
+	if slices.Contains(memberNames(t, bob), "alice") {
+		t.Error("alice is still a member after the grace period")
+	}
+
+	dropConn(t, rawJoin(t, addr, "alice"))
+	back := dial(t, addr, "", "alice")
+	time.Sleep(grace + grace/2)
+	bob.SendFrame(protocol.MembersPageRequest{Type: protocol.TypeMembersPage, Limit: 100})
+	for {
+		f := await(t, bob, protocol.TypeMembersPage, protocol.TypeMemberRemove)
+		if f.Type == protocol.TypeMemberRemove {
+			t.Errorf("bob got %s after alice came back within the grace period", f.Raw)
+			continue
+		}
+		var page protocol.MembersPage
+		decode(t, f, &page)
+		if !slices.Contains(page.Members, "alice") {
+			t.Error("alice is offline after coming back within the grace period")
+		}
+		break
+	}
+	back.SendFrame(map[string]string{"type": protocol.TypeSessions})
+	var sessions protocol.Sessions
+	decode(t, await(t, back, protocol.TypeSessions), &sessions)
+	if len(sessions.Sessions) != 1 {
+		t.Errorf("alice has %d sessions after coming back, want 1", len(sessions.Sessions))
+	}
+}
+
+// rawJoin joins as name on a bare connection, which the client package
+// would close cleanly.
+func rawJoin(t *testing.T, addr, name string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("join:"+name))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var f struct{ Type string }
+		if json.Unmarshal(data, &f); f.Type == protocol.TypeJoined {
+			return conn
+		}
+	}
+}
+
+// dropConn sends a message on conn and resets the TCP connection under it.
+func dropConn(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.WriteMessage(websocket.TextMessage, []byte("msg:alice:going, going"))
+	tcp := conn.NetConn().(*net.TCPConn)
+	tcp.SetLinger(0) // close with a reset
+	tcp.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"websocket-chatapp/protocol"
+)
+
+// End-to-end encrypted DMs. The payload is an opaque base64 blob the server
+// stores, delivers and replays as-is (kind "e2e", Text empty); only its size
+// and encoding are checked. Clients exchange public keys through their
+// profile with publish_key / get_key.
+const maxPublicKeySize = 4096
+
+// {"type":"e2e_dm","to":"bob","payload":"<base64>"}
+func handleE2EDM(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.E2EDMRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.To == "" || req.Payload == "" || !validTempID(req.TempID) {
+		sendError(c, "bad_frame", "invalid e2e_dm frame")
+		return
+	}
+	req.To = resolveName(c.ctx, ws, req.To)
+	if len(req.Payload) > cfg().E2EMaxPayload {
+		sendError(c, "too_large", "e2e payload is too large")
+		return
+	}
+	if _, err := base64.StdEncoding.DecodeString(req.Payload); err != nil {
+		sendError(c, "bad_frame", "e2e payload must be base64")
+		return
+	}
+	ok, known := checkRecipient(c, req.To)
+	if !ok || rejectDeactivatedPeer(c, req.To) || !takeQuota(c) {
+		return
+	}
+
+	msg := newMessage(name, "")
+	msg.Kind = "e2e"
+	msg.Payload = req.Payload
+	if !runInbound(c, "e2e", "dm:"+req.To, &msg) {
+		return
+	}
+	jsonMsg, _ := json.Marshal(msg)
+
+	if !storeMessage(ctx, ws, ws.dmKey(name, req.To), msg, jsonMsg) {
+		sendError(c, "not_stored", "message could not be stored; please retry")
+		return
+	}
+	c.expectEcho(msg.ID, req.TempID)
+	sendDMAck(c, req.TempID, msg, known)
+	publishDM(ws, msg, req.To)
+	touchActivity(ctx, ws, name)
+	countTalker(ctx, ws, name)
+	recordEvent(ws, chatEvent{Type: "e2e_dm", User: name, Peer: req.To})
+	bridgeMessage(ws, dmConversation(name, req.To), msg)
+
+	notifyOffline(ws, req.To, msg)
+	autoReply(ctx, ws, name, req.To)
+}
+
+// {"type":"publish_key","key":"<public key>"}
+func handlePublishKey(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+
+	var req protocol.PublishKeyRequest
+	if err := json.Unmarshal(data, &req); err != nil || len(req.Key) > maxPublicKeySize {
+		sendError(c, "bad_frame", "invalid publish_key frame")
+		return
+	}
+	key := strings.TrimSpace(req.Key)
+	if key == "" {
+		rdb.HDel(ctx, c.ws.profileKey(name), "publicKey")
+		return
+	}
+	rdb.HSet(ctx, c.ws.profileKey(name), "publicKey", key)
+}
+
+// {"type":"get_key","name":"bob"}
+func handleGetKey(c *client, data []byte) {
+	ctx := c.ctx
+	var req protocol.GetKeyRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Name == "" {
+		sendError(c, "bad_frame", "invalid get_key frame")
+		return
+	}
+	req.Name = resolveName(ctx, c.ws, req.Name)
+
+	key, _ := rdb.HGet(ctx, c.ws.profileKey(req.Name), "publicKey").Result()
+	c.writeJSON(protocol.NewKey(req.Name, key))
+}
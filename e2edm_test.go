@@ -0,0 +1,87 @@
+package main_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+	"websocket-chatapp/usertoken"
+)
+
+// TestE2EDM has alice and bob exchange public keys and alice send bob an
+// e2e_dm. The payload must reach bob and come back from history exactly
+// as sent, with no text, although a rejecting profanity filter is on and
+// the payload spells out profanity; oversized and non-base64 payloads are
+// refused.
+func TestE2EDM(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	addr := startServer(t, "", "CHAT_MIDDLEWARE=profanity", "CHAT_PROFANITY_MODE=reject", "CHAT_E2E_MAX_PAYLOAD=1024",
+		"CHAT_API_TOKEN_KEY="+base64.StdEncoding.EncodeToString(key))
+	alice := dial(t, addr, "", "alice")
+	bob := dial(t, addr, "", "bob")
+
+	// getKey asks c for name's public key.
+	getKey := func(c *client.Client, name string) string {
+		t.Helper()
+		c.SendFrame(protocol.GetKeyRequest{Type: protocol.TypeGetKey, Name: name})
+		var k protocol.Key
+		decode(t, await(t, c, protocol.TypeKey), &k)
+		return k.Key
+	}
+	alice.SendFrame(protocol.PublishKeyRequest{Type: protocol.TypePublishKey, Key: "alice-public-key"})
+	bob.SendFrame(protocol.PublishKeyRequest{Type: protocol.TypePublishKey, Key: "bob-public-key"})
+	// publish_key has no answer: hello waits until each is handled.
+	for _, c := range []*client.Client{alice, bob} {
+		c.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello})
+		await(t, c, protocol.TypeHello)
+	}
+	if got := getKey(alice, "BOB"); got != "bob-public-key" {
+		t.Errorf("alice got bob's key %q", got)
+	}
+	if got := getKey(bob, "alice"); got != "alice-public-key" {
+		t.Errorf("bob got alice's key %q", got)
+	}
+	if got := getKey(bob, "nobody"); got != "" {
+		t.Errorf("got key %q for a user who published none", got)
+	}
+
+	alice.SendDM("alice", "bob", "shit")
+	refused(t, alice, "profanity")
+	// Valid base64 that the profanity filter would refuse as text.
+	payload := "shitfuck" + base64.StdEncoding.EncodeToString([]byte("ciphertext"))
+	alice.SendFrame(protocol.E2EDMRequest{Type: protocol.TypeE2EDM, To: "bob", Payload: payload, TempID: "t1"})
+	var ack protocol.Ack
+	decode(t, await(t, alice, protocol.TypeAck), &ack)
+	f := await(t, bob, "message")
+	if m := f.Message; m.Kind != "e2e" || m.Payload != payload || m.Text != "" || m.ID != ack.ID {
+		t.Errorf("bob got %s, want the e2e message %s with the payload as sent", f.Raw, ack.ID)
+	}
+
+	for _, tc := range []struct {
+		payload, code string
+	}{
+		{strings.Repeat("A", 1028), "too_large"},
+		{"not base64!", "bad_frame"},
+	} {
+		alice.SendFrame(protocol.E2EDMRequest{Type: protocol.TypeE2EDM, To: "bob", Payload: tc.payload})
+		refused(t, alice, tc.code)
+	}
+
+	token := usertoken.Issue(key, usertoken.Claims{User: "bob", Expires: time.Now().Add(time.Hour).Unix()})
+	code, data := apiAs(t, addr, token, http.MethodGet, "/api/dm/alice/messages", nil)
+	if code != http.StatusOK {
+		t.Fatalf("bob's history got %d: %s", code, data)
+	}
+	var page struct {
+		Messages []protocol.Message `json:"messages"`
+	}
+	json.Unmarshal(data, &page)
+	if len(page.Messages) != 1 || page.Messages[0].Kind != "e2e" || page.Messages[0].Payload != payload || page.Messages[0].Text != "" {
+		t.Errorf("bob's history is %s, want the one e2e message as sent", data)
+	}
+}
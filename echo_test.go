@@ -0,0 +1,188 @@
+package main_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// settle is how long clients keep reading after the last send.
+const settle = 1500 * time.Millisecond
+
+// TestEchoOnce checks that every client gets every broadcast exactly once
+// with two instances on one store: users on both post public messages and
+// DMs across and within instances, and each message must reach each
+// client that should see it once, the sender's own instance included. It
+// then publishes one stamped broadcast twice, as a retried publish would,
+// and checks it is delivered once.
+func TestEchoOnce(t *testing.T) {
+	store := serveStore(t, 0)
+	a, b := startServer(t, store, withAdmin), startServer(t, store, withAdmin)
+	r := newReceipts()
+	users := map[string]*client.Client{}
+	for _, u := range []struct{ name, on string }{{"alice", a}, {"carol", a}, {"bob", b}, {"dave", b}} {
+		users[u.name] = r.join(t, u.on, u.name)
+	}
+
+	users["alice"].Send("alice", "public from a")
+	users["bob"].Send("bob", "public from b")
+	users["alice"].SendDM("alice", "bob", "dm a to b")
+	users["alice"].SendDM("alice", "carol", "dm a to a")
+	users["dave"].SendDM("dave", "carol", "dm b to a")
+	time.Sleep(settle)
+
+	everyone := []string{"alice", "bob", "carol", "dave"}
+	r.expect(t, "public from a", everyone...)
+	r.expect(t, "public from b", everyone...)
+	r.expect(t, "dm a to b", "alice", "bob")
+	r.expect(t, "dm a to a", "alice", "carol")
+	r.expect(t, "dm b to a", "dave", "carol")
+
+	// A retried publish: the same stamp twice.
+	rdb := redis.NewClient(&redis.Options{Addr: store})
+	defer rdb.Close()
+	stamped := "\x03someinstance:7:" + strconv.FormatInt(time.Now().UnixMilli(), 10) + `:{"id":"retried","user":"erin","text":"retried dm","time":1}`
+	for i := 0; i < 2; i++ {
+		if err := rdb.Publish(context.Background(), "chat:dm:dave", stamped).Err(); err != nil {
+			t.Fatalf("publishing: %v", err)
+		}
+	}
+	time.Sleep(settle)
+	r.expect(t, "retried dm", "dave")
+	var s struct {
+		PubSubDuplicates int64 `json:"pubsubDuplicates"`
+	}
+	stats(t, b, &s)
+	if s.PubSubDuplicates < 1 {
+		t.Errorf("pubsubDuplicates is %d, want the repeat counted", s.PubSubDuplicates)
+	}
+}
+
+// receipts counts the messages each user reads.
+type receipts struct {
+	mu        sync.Mutex
+	byUser    map[string]map[string]int    // user -> text -> copies
+	direction map[string]map[string]string // user -> text -> a DM copy's direction
+}
+
+func newReceipts() *receipts {
+	return &receipts{byUser: map[string]map[string]int{}, direction: map[string]map[string]string{}}
+}
+
+// join connects name to the instance at addr and counts the messages it
+// reads from then on.
+func (r *receipts) join(t *testing.T, addr, name string) *client.Client {
+	t.Helper()
+	return r.joinDevice(t, addr, name, name)
+}
+
+// joinDevice is join for one of name's devices, counted as device.
+func (r *receipts) joinDevice(t *testing.T, addr, name, device string) *client.Client {
+	t.Helper()
+	c, err := client.Dial("ws://" + addr + "/ws")
+	if err != nil {
+		t.Fatalf("%s: dial: %v", name, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Join(name); err != nil {
+		t.Fatalf("%s: join: %v", name, err)
+	}
+	r.mu.Lock()
+	r.byUser[device] = map[string]int{}
+	r.direction[device] = map[string]string{}
+	r.mu.Unlock()
+	ready := make(chan struct{})
+	go func() {
+		for {
+			f, err := c.Read()
+			if err != nil {
+				return
+			}
+			switch {
+			case f.Type == protocol.TypeInitDone:
+				c.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello}) // until the join is handled
+			case f.Type == protocol.TypeHello:
+				close(ready)
+			case f.Message != nil:
+				r.mu.Lock()
+				r.byUser[device][f.Message.Text]++
+				r.direction[device][f.Message.Text] = f.Message.Direction
+				r.mu.Unlock()
+			}
+		}
+	}()
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: no init_done and hello", device)
+	}
+	return c
+}
+
+// expect checks that text reached each of users once and nobody else.
+func (r *receipts) expect(t *testing.T, text string, users ...string) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	want := map[string]bool{}
+	for _, u := range users {
+		want[u] = true
+	}
+	for u, texts := range r.byUser {
+		n := texts[text]
+		if want[u] && n != 1 {
+			t.Errorf("%q reached %s %d times, want once", text, u, n)
+		}
+		if !want[u] && n != 0 {
+			t.Errorf("%q reached %s, who isn't a recipient", text, u)
+		}
+	}
+}
+
+// expectDirection checks that each of devices read text as direction.
+func (r *receipts) expectDirection(t *testing.T, text, direction string, devices ...string) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range devices {
+		if got := r.direction[d][text]; got != direction {
+			t.Errorf("%s read %q as %q, want %q", d, text, got, direction)
+		}
+	}
+}
+
+// TestDMDevices checks that with two devices each, on different
+// instances, a DM in either direction reaches every device of both users
+// once, marked out for the sender's and in for the recipient's, the
+// sending device included.
+func TestDMDevices(t *testing.T) {
+	store := serveStore(t, 0)
+	a, b := startServer(t, store, withAdmin), startServer(t, store, withAdmin)
+	r := newReceipts()
+	alicePhone := r.joinDevice(t, a, "alice", "alice phone")
+	r.joinDevice(t, b, "alice", "alice laptop")
+	bobPhone := r.joinDevice(t, b, "bob", "bob phone")
+	r.joinDevice(t, a, "bob", "bob laptop")
+	r.join(t, a, "carol")
+
+	alicePhone.SendFrame(protocol.SendRequest{Type: protocol.TypeDM, To: "bob", Text: "alice to bob", TempID: "t1"})
+	bobPhone.SendDM("bob", "alice", "bob to alice")
+	time.Sleep(settle)
+
+	alice, bob := []string{"alice phone", "alice laptop"}, []string{"bob phone", "bob laptop"}
+	both := append(slices.Clone(alice), bob...)
+	r.expect(t, "alice to bob", both...)
+	r.expect(t, "bob to alice", both...)
+	r.expectDirection(t, "alice to bob", "out", alice...)
+	r.expectDirection(t, "alice to bob", "in", bob...)
+	r.expectDirection(t, "bob to alice", "out", bob...)
+	r.expectDirection(t, "bob to alice", "in", alice...)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"websocket-chatapp/emoji"
+	"websocket-chatapp/protocol"
+)
+
+// Emoji. Built-in shortcodes (:tada:) are replaced by their characters
+// before a message is stored, unless CHAT_EMOJI_EXPAND is off, so history
+// reads the same to every client. Custom emoji are registered by an admin
+// in chat:emoji (hash: name -> file ID of the image); a message using one
+// keeps the :name: in its text and lists it, resolved, in its emoji field.
+// Unknown shortcodes are left alone. Each instance keeps a workspace's
+// registry cached, dropped whenever it changes anywhere: a change is
+// published on the chat:emoji channel as the emoji_update frame, which
+// each instance also passes on to its clients of the workspace.
+const (
+	maxCustomEmoji = 500
+	maxFileIDLen   = 128
+)
+
+var (
+	emojiMu      sync.Mutex
+	emojiSets    = map[workspace]map[string]string{}
+	emojiChanges = map[workspace]int{} // so a load that raced a change isn't kept
+)
+
+// expandEmoji expands msg's shortcodes; runInbound calls it before the
+// middleware, which so see the text as it will be stored.
+func expandEmoji(ctx context.Context, ws workspace, msg *ChatMessage) {
+	var registry map[string]string
+	text, names := emoji.Expand(msg.Text, cfg().EmojiExpand, func(name string) bool {
+		if registry == nil {
+			registry = customEmoji(ctx, ws)
+		}
+		_, ok := registry[name]
+		return ok
+	})
+	msg.Text = text
+	for _, name := range names {
+		msg.Emoji = append(msg.Emoji, protocol.CustomEmoji{Name: name, File: registry[name]})
+	}
+}
+
+// customEmoji returns ws's registry, loading it if needed. Like compiled
+// webhooks, it is kept only while this instance hears about changes.
+func customEmoji(ctx context.Context, ws workspace) map[string]string {
+	emojiMu.Lock()
+	registry, ok := emojiSets[ws]
+	gen := emojiChanges[ws]
+	emojiMu.Unlock()
+	if ok {
+		return registry
+	}
+
+	registry, err := rdb.HGetAll(ctx, ws.emojiKey()).Result()
+	if err != nil {
+		return map[string]string{}
+	}
+	if ws.listened() {
+		emojiMu.Lock()
+		if emojiChanges[ws] == gen {
+			emojiSets[ws] = registry
+		}
+		emojiMu.Unlock()
+	}
+	return registry
+}
+
+// listenEmojiChanges drops ws's cached registry on every change, and
+// passes the emoji_update on to ws's clients here.
+func listenEmojiChanges(ws workspace, sub subscription) {
+	for msg := range sub.Messages() {
+		emojiMu.Lock()
+		delete(emojiSets, ws)
+		emojiChanges[ws]++
+		emojiMu.Unlock()
+
+		frame, ok := openPayload(msg)
+		if !ok {
+			continue
+		}
+		for _, c := range workspaceClients(ws) {
+			c.writeMessage(frame)
+		}
+	}
+}
+
+// validFileID accepts the IDs the file API hands out: letters, digits, "-",
+// "_" and ".".
+func validFileID(id string) bool {
+	if id == "" || len(id) > maxFileIDLen {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// emojiList is ws's registry sorted by name.
+func emojiList(ctx context.Context, ws workspace) []protocol.CustomEmoji {
+	all, _ := rdb.HGetAll(ctx, ws.emojiKey()).Result()
+	list := make([]protocol.CustomEmoji, 0, len(all))
+	for name, file := range all {
+		list = append(list, protocol.CustomEmoji{Name: name, File: file})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GET /api/emoji?workspace=acme lists the custom emoji, for clients
+// building a picker.
+func handleEmojiAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ws, ok := lookupWorkspace(ctx, r.URL.Query().Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"workspace": string(ws), "emoji": emojiList(ctx, ws)})
+}
+
+// GET /api/admin/emoji?workspace=acme lists the custom emoji; PUT
+// {"workspace":"acme","name":"partyparrot","file":"<file ID>"} registers or
+// replaces one and DELETE ?workspace=acme&name=partyparrot removes one. All
+// need "Authorization: Bearer <CHAT_ADMIN_TOKEN>".
+func handleAdminEmojiAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		handleEmojiAPI(w, r)
+
+	case http.MethodPut:
+		var req struct {
+			Workspace string `json:"workspace"`
+			Name      string `json:"name"`
+			File      string `json:"file"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !emoji.ValidName(req.Name) || !validFileID(req.File) {
+			http.Error(w, "invalid emoji; name must be lower-case letters, digits, _, + or - and file a file ID", http.StatusBadRequest)
+			return
+		}
+		if _, ok := emoji.Lookup(req.Name); ok {
+			http.Error(w, ":"+req.Name+": is a built-in emoji", http.StatusConflict)
+			return
+		}
+		ws, ok := lookupWorkspace(ctx, req.Workspace)
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		exists, _ := rdb.HExists(ctx, ws.emojiKey(), req.Name).Result()
+		if n, _ := rdb.HLen(ctx, ws.emojiKey()).Result(); !exists && n >= maxCustomEmoji {
+			http.Error(w, fmt.Sprintf("at most %d custom emoji per workspace", maxCustomEmoji), http.StatusConflict)
+			return
+		}
+
+		if err := rdb.HSet(ctx, ws.emojiKey(), req.Name, req.File).Err(); err != nil {
+			http.Error(w, "could not register emoji", http.StatusInternalServerError)
+			return
+		}
+		frame, _ := json.Marshal(protocol.NewEmojiUpdate(req.Name, req.File))
+		publish(ws.emojiChannel(), frame)
+		publishAdminEvent(protocol.AdminEvent{Event: "emoji_add", Workspace: string(ws), Name: req.Name, Message: req.File})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(protocol.CustomEmoji{Name: req.Name, File: req.File})
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		name := q.Get("name")
+		if n, _ := rdb.HDel(ctx, ws.emojiKey(), name).Result(); n == 0 {
+			http.Error(w, "no such emoji", http.StatusNotFound)
+			return
+		}
+		frame, _ := json.Marshal(protocol.NewEmojiUpdate(name, ""))
+		publish(ws.emojiChannel(), frame)
+		publishAdminEvent(protocol.AdminEvent{Event: "emoji_delete", Workspace: string(ws), Name: name})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,170 @@
+// Package emoji expands :shortcode: emoji in chat messages. A shortcode is
+// a name of lower-case letters, digits, "_", "+" and "-" between colons.
+// The built-in ones (Builtin) become their unicode characters; others may
+// be custom emoji, which a caller resolves; anything else is left as
+// written, so "12:30:45" or ":notanemoji:" come through unchanged.
+package emoji
+
+import "strings"
+
+// MaxNameLen is the longest shortcode name, in bytes.
+const MaxNameLen = 32
+
+// ValidName reports whether name can be a shortcode (without the colons).
+func ValidName(name string) bool {
+	if name == "" || len(name) > MaxNameLen {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !nameByte(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func nameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '_' || b == '+' || b == '-'
+}
+
+// Lookup returns the characters of a built-in shortcode.
+func Lookup(name string) (string, bool) {
+	s, ok := Builtin[name]
+	return s, ok
+}
+
+// Expand replaces the built-in shortcodes in text with their characters,
+// if builtins is set, and returns the names of the other shortcodes for
+// which custom reports true, each once, in order of first appearance. Those
+// stay in the text as written. custom may be nil.
+func Expand(text string, builtins bool, custom func(name string) bool) (string, []string) {
+	if strings.IndexByte(text, ':') < 0 {
+		return text, nil
+	}
+	var (
+		b     strings.Builder
+		found []string
+		seen  map[string]bool
+	)
+	b.Grow(len(text))
+	i := 0
+	for i < len(text) {
+		if text[i] != ':' {
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		// A closing colon may open the next shortcode, so an unknown one
+		// only consumes its opening colon and name.
+		end := i + 1
+		for end < len(text) && end-i-1 <= MaxNameLen && nameByte(text[end]) {
+			end++
+		}
+		name := text[i+1 : end]
+		if end == len(text) || text[end] != ':' || !ValidName(name) {
+			b.WriteString(text[i:end])
+			i = end
+			continue
+		}
+		if s, ok := Builtin[name]; ok && builtins {
+			b.WriteString(s)
+			i = end + 1
+			continue
+		}
+		if _, ok := Builtin[name]; !ok && custom != nil && custom(name) {
+			if !seen[name] {
+				if seen == nil {
+					seen = map[string]bool{}
+				}
+				seen[name] = true
+				found = append(found, name)
+			}
+			b.WriteString(text[i : end+1])
+			i = end + 1
+			continue
+		}
+		b.WriteString(text[i:end])
+		i = end
+	}
+	return b.String(), found
+}
+
+// Builtin maps the common shortcodes to their characters.
+var Builtin = map[string]string{
+	"+1":                    "👍",
+	"-1":                    "👎",
+	"100":                   "💯",
+	"angry":                 "😠",
+	"bangbang":              "‼️",
+	"beers":                 "🍻",
+	"blush":                 "😊",
+	"boom":                  "💥",
+	"broken_heart":          "💔",
+	"bug":                   "🐛",
+	"bulb":                  "💡",
+	"cake":                  "🍰",
+	"calendar":              "📆",
+	"check":                 "✔️",
+	"clap":                  "👏",
+	"coffee":                "☕",
+	"confused":              "😕",
+	"cry":                   "😢",
+	"eyes":                  "👀",
+	"facepalm":              "🤦",
+	"fire":                  "🔥",
+	"grin":                  "😁",
+	"grinning":              "😀",
+	"heart":                 "❤️",
+	"heart_eyes":            "😍",
+	"hourglass":             "⌛",
+	"hugs":                  "🤗",
+	"joy":                   "😂",
+	"laughing":              "😆",
+	"lock":                  "🔒",
+	"mag":                   "🔍",
+	"memo":                  "📝",
+	"muscle":                "💪",
+	"neutral_face":          "😐",
+	"no_entry":              "⛔",
+	"ok":                    "🆗",
+	"ok_hand":               "👌",
+	"partying_face":         "🥳",
+	"pensive":               "😔",
+	"point_right":           "👉",
+	"point_up":              "☝️",
+	"pray":                  "🙏",
+	"question":              "❓",
+	"raised_hands":          "🙌",
+	"rocket":                "🚀",
+	"rofl":                  "🤣",
+	"rotating_light":        "🚨",
+	"scream":                "😱",
+	"see_no_evil":           "🙈",
+	"shrug":                 "🤷",
+	"slightly_smiling_face": "🙂",
+	"sleeping":              "😴",
+	"smile":                 "😄",
+	"smiley":                "😃",
+	"sob":                   "😭",
+	"sparkles":              "✨",
+	"star":                  "⭐",
+	"star_struck":           "🤩",
+	"stuck_out_tongue":      "😛",
+	"sunglasses":            "😎",
+	"sweat_smile":           "😅",
+	"tada":                  "🎉",
+	"thinking":              "🤔",
+	"thumbsdown":            "👎",
+	"thumbsup":              "👍",
+	"trophy":                "🏆",
+	"upside_down_face":      "🙃",
+	"v":                     "✌️",
+	"warning":               "⚠️",
+	"wave":                  "👋",
+	"white_check_mark":      "✅",
+	"wink":                  "😉",
+	"x":                     "❌",
+	"yum":                   "😋",
+	"zap":                   "⚡",
+	"zzz":                   "💤",
+}
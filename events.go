@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Analytics events. With CHAT_EVENTS on, every significant event (messages,
+// DMs, joins and leaves, room joins and leaves) is mirrored as a compact
+// JSON record into the <prefix>events stream, trimmed to about
+// CHAT_EVENTS_MAXLEN entries. Recording never blocks a handler: records go
+// through a buffered channel to a single writer, and are dropped (and
+// counted) when the buffer is full. cmd/eventtail is an example consumer.
+type chatEvent struct {
+	Type      string `json:"type"`
+	Time      int64  `json:"t"`
+	Workspace string `json:"ws,omitempty"`
+	User      string `json:"user,omitempty"`
+	Peer      string `json:"peer,omitempty"`  // DM recipient
+	Room      string `json:"room,omitempty"`  // room name
+	Group     string `json:"group,omitempty"` // group DM id
+	Len       int    `json:"len,omitempty"`   // message text length; content is never recorded
+}
+
+var (
+	eventQueue    chan chatEvent
+	eventsDropped atomic.Int64
+)
+
+// startEvents starts the stream writer when CHAT_EVENTS is on.
+func startEvents() {
+	if !cfg().Events {
+		return
+	}
+	if cfg().EventsHashUsers && cfg().EventsSalt == "" {
+		log.Println("⚠️ CHAT_EVENTS_HASH_USERS without CHAT_EVENTS_SALT: hashed names can be reversed by guessing")
+	}
+	eventQueue = make(chan chatEvent, cfg().EventsBuffer)
+	go writeEvents(eventQueue)
+	fmt.Printf("📈 Recording analytics events to %s\n", eventsKey())
+}
+
+// recordEvent queues ev for the stream. It returns immediately.
+func recordEvent(ws workspace, ev chatEvent) {
+	if eventQueue == nil {
+		return
+	}
+	ev.Time = time.Now().UnixMilli()
+	ev.Workspace = string(ws)
+	ev.User = eventUser(ev.User)
+	ev.Peer = eventUser(ev.Peer)
+	select {
+	case eventQueue <- ev:
+	default:
+		if eventsDropped.Add(1)%1000 == 1 {
+			log.Printf("⚠️ Analytics event buffer full; %d event(s) dropped so far", eventsDropped.Load())
+			adminAlertf("events_dropped", "analytics event buffer full; %d event(s) dropped so far", eventsDropped.Load())
+		}
+	}
+}
+
+// eventUser reduces a username to a keyed hash when CHAT_EVENTS_HASH_USERS
+// is on, so consumers can still count distinct users without seeing names.
+func eventUser(name string) string {
+	if name == "" || !cfg().EventsHashUsers {
+		return name
+	}
+	mac := hmac.New(sha256.New, []byte(cfg().EventsSalt))
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func writeEvents(queue <-chan chatEvent) {
+	for ev := range queue {
+		data, _ := json.Marshal(ev)
+		err := rdb.XAdd(serverCtx, &redis.XAddArgs{
+			Stream: eventsKey(),
+			MaxLen: int64(cfg().EventsMaxLen),
+			Approx: true,
+			Values: []string{"event", string(data)},
+		}).Err()
+		if err != nil {
+			log.Println("❌ Could not record analytics event:", err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// History exports, for legal holds:
+//
+//	GET /api/admin/export?workspace=acme
+//	GET /api/admin/export?workspace=acme&room=general&notify=true
+//	GET /api/admin/export?workspace=acme&user=alice&peer=bob
+//
+// stream the public timeline, a room or a DM conversation as NDJSON, one
+// message per line, oldest first; since and until (unix seconds) narrow
+// it. The export covers what was stored when it started. Every export is
+// recorded in the chat:audit stream and on the admin feed once it ends,
+// with what was sent (protocol.ExportInfo), cut short or not. notify=true
+// on a room first posts "History exported by <by> at <time>" into it,
+// where by is the by parameter or "an admin"; if that can't be posted,
+// nothing is exported. A DM export needs CHAT_ADMIN_READ_DMS and is also
+// recorded before anything is read; if that can't be recorded, it is
+// refused.
+const exportBatch = 500
+
+func handleExportAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+	var info protocol.ExportInfo
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"since", &info.Since}, {"until", &info.Until}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid "+p.name, http.StatusBadRequest)
+				return
+			}
+			*p.dst = n
+		}
+	}
+	notify, _ := strconv.ParseBool(q.Get("notify"))
+	room, user, peer := q.Get("room"), resolveName(ctx, ws, q.Get("user")), resolveName(ctx, ws, q.Get("peer"))
+	if notify && room == "" {
+		http.Error(w, "notify applies to room exports", http.StatusBadRequest)
+		return
+	}
+
+	ev := protocol.AdminEvent{Event: "export", Workspace: string(ws), By: "admin@" + clientIP(r), Export: &info}
+	var key string
+	switch {
+	case room != "":
+		if n, _ := rdb.Exists(ctx, ws.roomMetaKey(room)).Result(); n == 0 {
+			http.Error(w, "no such room", http.StatusNotFound)
+			return
+		}
+		key, info.Scope, ev.Name = ws.roomMessagesKey(room), "room:"+room, room
+
+	case user != "" || peer != "":
+		if !cfg().AdminReadDMs {
+			http.Error(w, "admin reads of DMs are disabled (CHAT_ADMIN_READ_DMS)", http.StatusForbidden)
+			return
+		}
+		if user == "" || peer == "" || user == peer {
+			http.Error(w, "name both participants with ?user= and ?peer=", http.StatusBadRequest)
+			return
+		}
+		key, info.Scope, ev.Name, ev.Reason = ws.dmKey(user, peer), dmConversation(user, peer), user, "DMs with "+peer
+		start := ev
+		start.Event, start.Export = "export_start", &protocol.ExportInfo{Scope: info.Scope, Since: info.Since, Until: info.Until}
+		if err := audit(ctx, start); err != nil {
+			log.Println("❌ Audit log error:", err)
+			http.Error(w, "could not record the export in the audit log", http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		key, info.Scope = ws.messagesKey(), "global"
+	}
+
+	if notify {
+		at := time.Now().UTC().Format(time.RFC3339)
+		msg := systemMessage("system.export_notice_admin", "at", at)
+		if by := q.Get("by"); by != "" {
+			msg = systemMessage("system.export_notice", "by", by, "at", at)
+		}
+		if !postRoomMessage(ctx, ws, room, msg) {
+			http.Error(w, "could not notify the room; nothing was exported", http.StatusInternalServerError)
+			return
+		}
+		info.Notified = true
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "export-"+string(ws)+".ndjson"))
+	err := streamExport(ctx, w, key, &info)
+	info.Complete = err == nil
+	if err != nil {
+		log.Printf("⚠️ Export of %s in workspace %q cut short after %d bytes: %v", info.Scope, ws, info.Bytes, err)
+	}
+	// Recorded even if the client went away, so not under its context.
+	if err := audit(context.WithoutCancel(ctx), ev); err != nil {
+		log.Println("❌ Audit log error:", err)
+	}
+}
+
+// streamExport writes the messages of the conversation at key stored so
+// far, within info's range, to w, counting them in info.
+func streamExport(ctx context.Context, w io.Writer, key string, info *protocol.ExportInfo) error {
+	newest, err := rdb.ZRevRangeWithScores(ctx, key, 0, 0).Result()
+	if err != nil || len(newest) == 0 {
+		return err
+	}
+	max := strconv.FormatFloat(newest[0].Score, 'f', -1, 64)
+	flusher, _ := w.(http.Flusher)
+
+	min := "-inf"
+	for {
+		entries, err := rdb.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: min, Max: max, Count: exportBatch}).Result()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, e := range entries {
+			raw, _ := e.Member.(string)
+			msg, ok := decodeMessage(raw)
+			if !ok || info.Since > 0 && msg.Time < info.Since || info.Until > 0 && msg.Time > info.Until {
+				continue
+			}
+			line, _ := json.Marshal(msg)
+			n, err := w.Write(append(line, '\n'))
+			info.Bytes += int64(n)
+			if err != nil {
+				return err
+			}
+			info.Messages++
+			if info.First == 0 {
+				info.First = msg.Time
+			}
+			info.Last = msg.Time
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		min = "(" + strconv.FormatFloat(entries[len(entries)-1].Score, 'f', -1, 64)
+	}
+}
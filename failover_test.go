@@ -0,0 +1,177 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestRedisFailover runs the server against cmd/flakyredis and fails the
+// store over while alice and bob talk. Every message alice sends, before,
+// during and after the outage, must be acked or refused with not_stored,
+// never neither; each acked one must reach bob exactly once; both
+// connections must stay open; the server must resubscribe, and messages
+// must flow again within seconds of the store coming back.
+func TestRedisFailover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("fails a store over for several seconds")
+	}
+	const down = 2 * time.Second
+	bin := filepath.Join(t.TempDir(), "flakyredis")
+	if out, err := exec.Command("go", "build", "-o", bin, "./cmd/flakyredis").CombinedOutput(); err != nil {
+		t.Fatalf("building flakyredis: %v\n%s", err, out)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := ln.Addr().String()
+	ln.Close()
+	flaky := exec.Command(bin, "-addr", store, "-down", down.String())
+	if testing.Verbose() {
+		flaky.Stdout, flaky.Stderr = os.Stdout, os.Stderr
+	}
+	if err := flaky.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flaky.Process.Kill()
+		flaky.Wait()
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if nc, err := net.Dial("tcp", store); err == nil {
+			nc.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("flakyredis didn't start")
+		}
+	}
+
+	addr := startServer(t, store, withAdmin)
+	alice := dial(t, addr, "", "alice")
+	bob := dial(t, addr, "", "bob")
+
+	// seen is what one connection was sent, until it ends.
+	type seen struct {
+		mu       sync.Mutex
+		texts    map[string]int
+		acked    map[string]bool
+		notStore int
+		err      error
+	}
+	read := func(c *client.Client) *seen {
+		s := &seen{texts: map[string]int{}, acked: map[string]bool{}}
+		go func() {
+			for {
+				f, err := c.Read()
+				s.mu.Lock()
+				switch {
+				case err != nil:
+					s.err = err
+				case f.Type == "message" && f.Message.TempID == "":
+					s.texts[f.Message.Text]++
+				case f.Type == protocol.TypeAck:
+					var ack protocol.Ack
+					json.Unmarshal(f.Raw, &ack)
+					s.acked[ack.TempID] = true
+				case f.Type == protocol.TypeError:
+					var e protocol.Error
+					if json.Unmarshal(f.Raw, &e); e.Code == "not_stored" {
+						s.notStore++
+					}
+				}
+				s.mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+		return s
+	}
+	sent, got := read(alice), read(bob)
+	send := func(text string) {
+		alice.SendFrame(protocol.SendRequest{Type: protocol.TypeMsg, Text: text, TempID: text})
+	}
+	// arrived waits up to wait for bob to have text.
+	arrived := func(text string, wait time.Duration) bool {
+		for deadline := time.Now().Add(wait); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			got.mu.Lock()
+			n := got.texts[text]
+			got.mu.Unlock()
+			if n > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	send("before")
+	if !arrived("before", 5*time.Second) {
+		t.Fatal("bob didn't get the message before the failover")
+	}
+	var before struct {
+		Resubscribes int64 `json:"resubscribes"`
+	}
+	stats(t, addr, &before)
+
+	flaky.Process.Signal(syscall.SIGUSR1)
+	up := time.Now().Add(down)
+	time.Sleep(200 * time.Millisecond)
+	send("during 1")
+	time.Sleep(down / 2)
+	send("during 2")
+	time.Sleep(time.Until(up))
+	send("after")
+	if !arrived("after", 10*time.Second) {
+		t.Fatal("bob didn't get a message within 10s of the store coming back")
+	}
+	t.Logf("messages flowed again %s after the store came back", time.Since(up).Round(time.Millisecond))
+	// Give whatever waited out the outage time to land.
+	arrived("during 1", 3*time.Second)
+	arrived("during 2", 3*time.Second)
+
+	texts := []string{"before", "during 1", "during 2", "after"}
+	sent.mu.Lock()
+	got.mu.Lock()
+	defer sent.mu.Unlock()
+	defer got.mu.Unlock()
+	acked := 0
+	for _, text := range texts {
+		if !sent.acked[text] {
+			continue
+		}
+		acked++
+		if n := got.texts[text]; n != 1 {
+			t.Errorf("bob got %q %d times, want once", text, n)
+		}
+	}
+	if acked+sent.notStore != len(texts) {
+		t.Errorf("%d of %d messages acked and %d refused with not_stored: some were lost silently", acked, len(texts), sent.notStore)
+	}
+	for text, n := range got.texts {
+		if !sent.acked[text] {
+			t.Errorf("bob got %q %d times though alice was never told it was stored", text, n)
+		}
+	}
+	for name, s := range map[string]*seen{"alice": sent, "bob": got} {
+		if s.err != nil {
+			t.Errorf("%s's connection ended: %v", name, s.err)
+		}
+	}
+	var after struct {
+		Resubscribes int64 `json:"resubscribes"`
+	}
+	if stats(t, addr, &after); after.Resubscribes <= before.Resubscribes {
+		t.Error("the server didn't resubscribe after the failover")
+	}
+}
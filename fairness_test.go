@@ -0,0 +1,198 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestInboundFairness is a load test of the inbound queues (see
+// inqueue.go): it checks that clients sending as fast as they can don't
+// slow everyone else down when Redis is slow. The server runs against a
+// store that adds 2ms to every round trip, with 4 inbound workers. Eight
+// spammers send public messages every millisecond, far faster than it can
+// store them, and eight other clients send one every 250ms, for 5 seconds.
+//
+// Connections take turns, so each gets about workers/connections of what
+// the server can handle; the others send well under that share and should
+// never wait behind the spammers' queues. The test fails unless the
+// spammers were refused with queue_full and the others never were, and
+// the others' p99 from send to ack stays under the spammers' median (and
+// under a second). Each side's percentiles, and the server's own read to
+// handled time of both (inboundMs in /api/stats), are logged.
+func TestInboundFairness(t *testing.T) {
+	if testing.Short() {
+		t.Skip("a 5-second load test")
+	}
+	const (
+		spammers = 8
+		spamRate = time.Millisecond
+		normal   = 8
+		every    = 250 * time.Millisecond
+		workers  = 4
+		latency  = 2 * time.Millisecond
+		duration = 5 * time.Second
+		maxP99   = time.Second
+	)
+	addr := startServer(t, serveStore(t, latency), withAdmin,
+		"CHAT_INBOUND_WORKERS="+strconv.Itoa(workers), "CHAT_NAMES_PER_IP=1000", "CHAT_JOIN_RATE=1000")
+
+	var spam, others ackTimes
+	stop := time.Now().Add(duration)
+	var wg sync.WaitGroup
+	for i := 0; i < spammers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sendUntil(t, addr, fmt.Sprintf("spammer%d", i), spamRate, stop, duration, &spam)
+		}(i)
+	}
+	for i := 0; i < normal; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sendUntil(t, addr, fmt.Sprintf("user%d", i), every, stop, duration, &others)
+		}(i)
+	}
+	time.Sleep(time.Until(stop) - duration/10)
+	inbound := inboundMs(t, addr)
+	wg.Wait()
+
+	for _, s := range []struct {
+		name string
+		side *ackTimes
+	}{{"spammers", &spam}, {"others", &others}} {
+		t.Logf("%-9s acks %6d  p50 %8s  p99 %8s  queue_full %6d  server read to handled %6.0fms", s.name+":", len(s.side.samples),
+			s.side.percentile(.5).Round(time.Millisecond), s.side.percentile(.99).Round(time.Millisecond), s.side.refused.Load(), inbound[s.name])
+	}
+	switch {
+	case spam.refused.Load() == 0:
+		t.Error("the spammers were never refused with queue_full")
+	case others.refused.Load() > 0:
+		t.Errorf("the other clients were refused with queue_full %d times", others.refused.Load())
+	case len(others.samples) == 0:
+		t.Error("the other clients got no acks")
+	case others.percentile(.99) >= spam.percentile(.5):
+		t.Error("the other clients' p99 isn't under the spammers' median")
+	case others.percentile(.99) > maxP99:
+		t.Errorf("the other clients' p99 is over %s", maxP99)
+	}
+}
+
+// ackTimes is what the clients of one kind saw.
+type ackTimes struct {
+	refused atomic.Int64
+
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (s *ackTimes) record(d time.Duration) {
+	s.mu.Lock()
+	s.samples = append(s.samples, d)
+	s.mu.Unlock()
+}
+
+func (s *ackTimes) percentile(p float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), s.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// sendUntil joins as name and sends a message every interval until stop,
+// recording the time to each ack in s. It then waits up to drain for the
+// acks of what was queued, so slow acks still count.
+func sendUntil(t *testing.T, addr, name string, interval time.Duration, stop time.Time, drain time.Duration, s *ackTimes) {
+	c, err := client.Dial("ws://" + addr + "/ws")
+	if err != nil {
+		t.Errorf("%s: dial: %v", name, err)
+		return
+	}
+	defer c.Close()
+	if err := c.Join(name); err != nil {
+		t.Errorf("%s: join: %v", name, err)
+		return
+	}
+
+	var mu sync.Mutex
+	sent := map[string]time.Time{}
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			f, err := c.Read()
+			if err != nil {
+				return
+			}
+			switch f.Type {
+			case protocol.TypeAck:
+				var ack protocol.Ack
+				json.Unmarshal(f.Raw, &ack)
+				mu.Lock()
+				if at, ok := sent[ack.TempID]; ok {
+					s.record(time.Since(at))
+					delete(sent, ack.TempID)
+				}
+				mu.Unlock()
+			case protocol.TypeError:
+				var e protocol.Error
+				json.Unmarshal(f.Raw, &e)
+				if e.Code == "queue_full" {
+					s.refused.Add(1)
+				}
+			}
+		}
+	}()
+
+	for i := 0; time.Now().Before(stop); i++ {
+		tempID := name + "-" + strconv.Itoa(i)
+		mu.Lock()
+		sent[tempID] = time.Now()
+		mu.Unlock()
+		if err := c.SendFrame(protocol.SendRequest{Type: protocol.TypeMsg, Text: "load", TempID: tempID}); err != nil {
+			t.Errorf("%s: send: %v", name, err)
+			return
+		}
+		time.Sleep(interval)
+	}
+	select {
+	case <-readDone:
+	case <-time.After(drain):
+	}
+}
+
+// inboundMs reads the server's average read to handled time of the
+// spammers' connections and the others', from GET /api/stats.
+func inboundMs(t *testing.T, addr string) map[string]float64 {
+	var s struct {
+		Connections []protocol.ConnectionStats `json:"connections"`
+	}
+	stats(t, addr, &s)
+	sum, n := map[string]float64{}, map[string]float64{}
+	for _, c := range s.Connections {
+		kind := "others"
+		if strings.HasPrefix(c.Name, "spammer") {
+			kind = "spammers"
+		}
+		sum[kind] += c.InboundMs
+		n[kind]++
+	}
+	for kind := range sum {
+		sum[kind] /= n[kind]
+	}
+	return sum
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"websocket-chatapp/supervisor"
+)
+
+// Fan-out channels. The public timeline and each room have their own
+// pub/sub channel (<prefix>messages and <prefix>room:<name>), and an
+// instance subscribes to one only while it hosts a connection that wants
+// it: any connection of the workspace for the timeline, a connection of a
+// room member for a room. Each channel keeps the set of connections
+// holding it; the first acquire subscribes and the last release
+// unsubscribes. A Redis subscription survives reconnects by itself (see
+// redisSubscription), so a channel acquired while Redis is down starts
+// delivering once it is back, and is never subscribed twice.
+//
+// Room membership belongs to the user, not the connection: join_room and
+// leave_room on one connection (and deleting the room) reach the user's
+// other connections, here or on other instances, as room_join and
+// room_leave commands on their control channel (see sessions.go).
+//
+// Before CHAT_ROOM_CHANNELS, room messages went to every member's personal
+// channel. Instances still deliver those, so during an upgrade run the new
+// version with CHAT_ROOM_CHANNELS=false until no old instance is left, then
+// turn it on (it is hot-reloadable).
+type fanout struct {
+	sub     subscription
+	clients map[*client]bool
+	// stop ends the channel's supervised listener (see listeners.go).
+	stop context.CancelFunc
+}
+
+var (
+	fanoutMu sync.Mutex
+	fanouts  = map[string]*fanout{}
+)
+
+// acquireChannel adds c to channel's connections. On the first it
+// subscribes, starts listen on the subscription, supervised, and reports
+// true. A closed connection is not added, so closeClient's releaseClient
+// can't miss it.
+func acquireChannel(channel string, c *client, listen func(subscription, *fanout)) bool {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	if c.ctx.Err() != nil {
+		return false
+	}
+	if f := fanouts[channel]; f != nil {
+		f.clients[c] = true
+		return false
+	}
+	ctx, stop := context.WithCancel(serverCtx)
+	f := &fanout{sub: subscribeUntil(ctx, channel), clients: map[*client]bool{c: true}, stop: stop}
+	fanouts[channel] = f
+	listeners.Go(ctx, "channel "+channel, func(last supervisor.Exit) {
+		if last == supervisor.Returned {
+			f.resubscribe(ctx, channel)
+		}
+		fanoutMu.Lock()
+		sub := f.sub
+		fanoutMu.Unlock()
+		listen(sub, f)
+	})
+	return true
+}
+
+// resubscribe replaces f's subscription, which ended.
+func (f *fanout) resubscribe(ctx context.Context, channel string) {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	f.sub.Close()
+	f.sub = subscribeUntil(ctx, channel)
+}
+
+// releaseChannel removes c from channel's connections, unsubscribing after
+// the last.
+func releaseChannel(channel string, c *client) {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	releaseLocked(channel, c)
+}
+
+func releaseLocked(channel string, c *client) {
+	f := fanouts[channel]
+	if f == nil || !f.clients[c] {
+		return
+	}
+	delete(f.clients, c)
+	if len(f.clients) == 0 {
+		delete(fanouts, channel)
+		f.stop()
+		f.sub.Close()
+	}
+}
+
+// releaseClient releases every channel c holds; closeClient calls it after
+// cancelling c's context.
+func releaseClient(c *client) {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	for channel, f := range fanouts {
+		if f.clients[c] {
+			releaseLocked(channel, c)
+		}
+	}
+}
+
+// subscribedChannels is how many fan-out channels this instance listens to.
+func subscribedChannels() int {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	return len(fanouts)
+}
+
+// deliver writes payload to the connections holding the channel.
+func (f *fanout) deliver(payload []byte) {
+	fanoutMu.Lock()
+	list := make([]*client, 0, len(f.clients))
+	for c := range f.clients {
+		list = append(list, c)
+	}
+	fanoutMu.Unlock()
+	for _, c := range list {
+		c.writeMessage(payload)
+	}
+}
+
+// followTimeline subscribes c to its workspace's public messages. A
+// timeline subscribed afresh may follow a gap with no subscriber, so the
+// cached init states, which nothing invalidated meanwhile, are dropped.
+func followTimeline(c *client) {
+	ws := c.ws
+	if acquireChannel(ws.messagesChannel(), c, func(sub subscription, f *fanout) { listenPublicMessages(ws, sub, f) }) {
+		dropInitStates(ws)
+	}
+}
+
+// enterRoom makes c deliver room's messages; leaveRoom stops it.
+func enterRoom(c *client, room string) {
+	ws := c.ws
+	acquireChannel(ws.roomChannel(room), c, func(sub subscription, f *fanout) {
+		for msg := range sub.Messages() {
+			if payload, ok := openPayload(msg); ok {
+				f.deliver(payload)
+			}
+		}
+	})
+}
+
+func leaveRoom(c *client, room string) {
+	releaseChannel(c.ws.roomChannel(room), c)
+}
+
+// publishRoomMembership tells name's connections everywhere to enter or
+// leave room.
+func publishRoomMembership(ws workspace, name, room string, joined bool) {
+	op := "room_leave"
+	if joined {
+		op = "room_join"
+	}
+	raw, _ := json.Marshal(sessionControl{Op: op, Room: room})
+	publish(ws.sessionControlChannel(name), raw)
+}
+
+// publishRoom sends frame to room's members, and to the users in also
+// (who just left it) on their personal channels.
+func publishRoom(ctx context.Context, ws workspace, room string, frame []byte, also []string) {
+	if cfg().RoomChannels {
+		publish(ws.roomChannel(room), frame)
+		for _, m := range also {
+			publish(ws.userChannel(m), frame)
+		}
+		return
+	}
+	members, _ := rdb.SMembers(ctx, ws.roomMembersKey(room)).Result()
+	for _, m := range append(members, also...) {
+		publish(ws.userChannel(m), frame)
+	}
+}
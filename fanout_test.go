@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTransport records its subscriptions; nothing is published through
+// it, the test sends on a subscription directly.
+type fakeTransport struct {
+	mu   sync.Mutex
+	subs []*fakeSubscription
+}
+
+func (*fakeTransport) Publish(channel, payload string) error { return nil }
+
+func (t *fakeTransport) Subscribe(channel string) subscription {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &fakeSubscription{ch: make(chan pubsubMessage, 1)}
+	t.subs = append(t.subs, s)
+	return s
+}
+
+func (t *fakeTransport) subscribed() []*fakeSubscription {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*fakeSubscription(nil), t.subs...)
+}
+
+type fakeSubscription struct {
+	ch     chan pubsubMessage
+	once   sync.Once
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *fakeSubscription) Messages() <-chan pubsubMessage { return s.ch }
+
+// Close is the owner closing it; drop is the transport losing it.
+func (s *fakeSubscription) Close() {
+	s.drop()
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
+func (s *fakeSubscription) drop() { s.once.Do(func() { close(s.ch) }) }
+
+func (s *fakeSubscription) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// useFakeTransport swaps the pub/sub transport for a fake one for the
+// test.
+func useFakeTransport(t *testing.T) *fakeTransport {
+	fake := &fakeTransport{}
+	old := transport
+	transport = fake
+	t.Cleanup(func() { transport = old })
+	return fake
+}
+
+// heldClient is a connection whose frames are kept in held, as before
+// init, instead of written.
+func heldClient(t *testing.T) *client {
+	c := &client{holding: true}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	t.Cleanup(c.cancel)
+	return c
+}
+
+func heldFrames(c *client) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.held)
+}
+
+// acquireTest acquires channel for c with a listener that delivers every
+// payload.
+func acquireTest(channel string, c *client) bool {
+	return acquireChannel(channel, c, func(sub subscription, f *fanout) {
+		for msg := range sub.Messages() {
+			f.deliver([]byte(msg.Payload))
+		}
+	})
+}
+
+func holders(channel string) int {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	if f := fanouts[channel]; f != nil {
+		return len(f.clients)
+	}
+	return 0
+}
+
+// waitFor fails the test if cond isn't true within 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// TestFanoutRefcount checks the fan-out channels' reference counting: the
+// last release unsubscribes and the next acquire subscribes afresh, a
+// connection acquiring while the subscription is being made again after a
+// reconnect shares it, and a closed connection acquires nothing.
+func TestFanoutRefcount(t *testing.T) {
+	t.Run("last member leaves", func(t *testing.T) {
+		fake := useFakeTransport(t)
+		const channel = "test:refcount:last"
+		a, b := heldClient(t), heldClient(t)
+		if !acquireTest(channel, a) || acquireTest(channel, b) {
+			t.Fatal("only the first acquire should subscribe")
+		}
+		releaseChannel(channel, a)
+		releaseChannel(channel, a)
+		if holders(channel) != 1 || fake.subscribed()[0].isClosed() {
+			t.Fatal("releasing one of two holders (twice) ended the subscription")
+		}
+		releaseChannel(channel, b)
+		if holders(channel) != 0 || !fake.subscribed()[0].isClosed() {
+			t.Fatal("the last release didn't unsubscribe")
+		}
+
+		c := heldClient(t)
+		if !acquireTest(channel, c) {
+			t.Fatal("acquiring after the last release didn't subscribe again")
+		}
+		subs := fake.subscribed()
+		if len(subs) != 2 {
+			t.Fatalf("%d subscriptions, want 2", len(subs))
+		}
+		subs[1].ch <- pubsubMessage{Payload: "hi"}
+		waitFor(t, "the message", func() bool { return heldFrames(c) == 1 })
+		if heldFrames(a) != 0 || heldFrames(b) != 0 {
+			t.Error("a released connection got the message")
+		}
+		releaseChannel(channel, c)
+	})
+
+	t.Run("first member joins during a reconnect", func(t *testing.T) {
+		fake := useFakeTransport(t)
+		const channel = "test:refcount:reconnect"
+		a := heldClient(t)
+		acquireTest(channel, a)
+		// The listener ends with its subscription and is restarted after
+		// the supervisor's backoff; b comes in meanwhile.
+		fake.subscribed()[0].drop()
+		b := heldClient(t)
+		if acquireTest(channel, b) {
+			t.Fatal("acquiring during the reconnect subscribed a second time")
+		}
+		waitFor(t, "the resubscription", func() bool { return len(fake.subscribed()) == 2 })
+		fake.subscribed()[1].ch <- pubsubMessage{Payload: "hi"}
+		waitFor(t, "the message", func() bool { return heldFrames(a) == 1 && heldFrames(b) == 1 })
+
+		releaseChannel(channel, a)
+		releaseChannel(channel, b)
+		if !fake.subscribed()[1].isClosed() {
+			t.Error("the last release didn't unsubscribe")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if n := len(fake.subscribed()); n != 2 {
+			t.Errorf("%d subscriptions after the last release, want 2", n)
+		}
+	})
+
+	t.Run("closed connection", func(t *testing.T) {
+		fake := useFakeTransport(t)
+		const channel = "test:refcount:closed"
+		c := heldClient(t)
+		c.cancel()
+		if acquireTest(channel, c) || holders(channel) != 0 || len(fake.subscribed()) != 0 {
+			t.Error("a closed connection acquired the channel")
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// The firehose, for compliance recording. With CHAT_FIREHOSE on, storing
+// a message also adds it to its workspace's firehose stream, in the same
+// transaction, so the stream has every stored message in the order they
+// were stored: public, room and, with CHAT_FIREHOSE_DMS, DM and group DM
+// messages (clients are told, with "dmsRecorded":true in init). A
+// recorder reads it with
+//
+//	GET /api/firehose?workspace=acme&since=<seq>
+//
+// as NDJSON, or as server-sent events with ?format=sse or Accept:
+// text/event-stream, one protocol.FirehoseRecord per line or event. Its seq
+// is the stream entry's ID: a recorder that reconnects passes the last one
+// it got as since (or, for SSE, Last-Event-ID) and misses nothing, as long
+// as the stream (about CHAT_FIREHOSE_MAXLEN entries) still has it;
+// otherwise it gets 410. since=0 starts at the oldest entry kept, no since
+// at the next message stored. Each record also has its message's sequence
+// number in its conversation (see order.go), which has no gaps, and with
+// CHAT_FRAME_KEY set, an HMAC-SHA256 over conversation, number and
+// message made when it was stored: a record changed or taken out of the
+// stream shows. The stream is polled every firehosePoll; an idle stream
+// sends a keepalive (an empty line, or an SSE comment) every
+// firehoseKeepalive.
+//
+// Every read is recorded in the chat:audit stream and on the admin feed
+// when it starts (if that can't be recorded, it is refused) and ends. At
+// most CHAT_FIREHOSE_CONSUMERS read at once across instances and
+// workspaces, counted in chat:firehose:consumers; the others get 429.
+const (
+	firehoseBatch     = 500
+	firehosePoll      = 250 * time.Millisecond
+	firehoseKeepalive = 15 * time.Second
+	firehoseHeartbeat = 10 * time.Second
+)
+
+var (
+	firehoseSeq  = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+	firehoseStop = make(chan struct{}) // closed on shutdown
+)
+
+// stopFirehoses ends every firehose read, so shutdown needn't wait.
+func stopFirehoses() { close(firehoseStop) }
+
+// addFirehose queues s's entry in its workspace's firehose on pipe, when
+// it has one.
+func addFirehose(ctx context.Context, pipe redis.Pipeliner, s *spilledMessage) {
+	conversation, private, ok := s.ws.conversationOf(s.key)
+	if !cfg().Firehose || !ok || private && !cfg().FirehoseDMs {
+		return
+	}
+	values := []string{"conversation", conversation, "seq", strconv.FormatInt(s.seq, 10), "message", s.member}
+	if frameKey != nil {
+		if plain, err := unseal(s.member); err == nil {
+			values = append(values, "mac", firehoseMAC(conversation, s.seq, plain))
+		}
+	}
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.ws.firehoseKey(),
+		MaxLen: int64(cfg().FirehoseMaxLen),
+		Approx: true,
+		Values: values,
+	})
+}
+
+func firehoseMAC(conversation string, seq int64, message []byte) string {
+	mac := hmac.New(sha256.New, frameKey)
+	fmt.Fprintf(mac, "%s\n%d\n", conversation, seq)
+	mac.Write(message)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func handleFirehoseAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !cfg().Firehose {
+		http.Error(w, "the firehose is off (CHAT_FIREHOSE)", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+	since := q.Get("since")
+	if since == "" {
+		since = r.Header.Get("Last-Event-ID")
+	}
+	if since != "" && since != "0" && !firehoseSeq.MatchString(since) {
+		http.Error(w, "invalid since", http.StatusBadRequest)
+		return
+	}
+	last, err := firehoseStart(ctx, ws, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	sse := q.Get("format") == "sse" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+
+	consumer := ids.New()
+	if !takeFirehoseSlot(ctx, consumer) {
+		http.Error(w, fmt.Sprintf("at most %d firehose consumers at a time", cfg().FirehoseConsumers), http.StatusTooManyRequests)
+		return
+	}
+	defer rdb.ZRem(context.WithoutCancel(ctx), firehoseConsumersKey(), consumer)
+
+	ev := protocol.AdminEvent{Event: "firehose_start", Workspace: string(ws), By: "admin@" + clientIP(r), Name: consumer, Message: "since " + last}
+	if err := audit(ctx, ev); err != nil {
+		log.Println("❌ Audit log error:", err)
+		http.Error(w, "could not record the read in the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	sent, last, err := streamFirehose(ctx, w, ws, consumer, last, sse)
+
+	ev.Event, ev.Message = "firehose_end", fmt.Sprintf("%d messages, up to %s", sent, last)
+	if err != nil {
+		ev.Reason = err.Error()
+	}
+	// Recorded even if the recorder went away, so not under its context.
+	if err := audit(context.WithoutCancel(ctx), ev); err != nil {
+		log.Println("❌ Audit log error:", err)
+	}
+}
+
+// firehoseStart returns the ID to read ws's firehose after: since, if no
+// entry after it was trimmed away, or the newest ID for no since.
+func firehoseStart(ctx context.Context, ws workspace, since string) (string, error) {
+	key := ws.firehoseKey()
+	switch since {
+	case "":
+		newest, err := rdb.XRevRangeN(ctx, key, "+", "-", 1).Result()
+		if err != nil || len(newest) == 0 {
+			return "0-0", err
+		}
+		return newest[0].ID, nil
+	case "0":
+		return "0-0", nil
+	}
+	if found, err := rdb.XRange(ctx, key, since, since).Result(); err != nil || len(found) > 0 {
+		return since, err
+	}
+	oldest, err := rdb.XRangeN(ctx, key, "-", "+", 1).Result()
+	if err != nil {
+		return since, err
+	}
+	if len(oldest) > 0 && streamIDLess(since, oldest[0].ID) {
+		return "", fmt.Errorf("messages after %s are no longer in the firehose; the oldest kept is %s", since, oldest[0].ID)
+	}
+	return since, nil
+}
+
+// streamIDLess reports whether stream ID a comes before b.
+func streamIDLess(a, b string) bool {
+	ams, aseq, _ := strings.Cut(a, "-")
+	bms, bseq, _ := strings.Cut(b, "-")
+	am, _ := strconv.ParseUint(ams, 10, 64)
+	bm, _ := strconv.ParseUint(bms, 10, 64)
+	as, _ := strconv.ParseUint(aseq, 10, 64)
+	bs, _ := strconv.ParseUint(bseq, 10, 64)
+	return am < bm || am == bm && as < bs
+}
+
+// takeFirehoseSlot registers consumer, unless the most allowed already
+// read. Consumers an instance stopped refreshing (it crashed) are dropped.
+func takeFirehoseSlot(ctx context.Context, consumer string) bool {
+	now := time.Now().Unix()
+	key := firehoseConsumersKey()
+	rdb.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now-int64(3*firehoseHeartbeat/time.Second), 10))
+	if err := rdb.ZAdd(ctx, key, redis.Z{Score: float64(now), Member: consumer}).Err(); err != nil {
+		return false
+	}
+	if n, err := rdb.ZCard(ctx, key).Result(); err != nil || n > int64(cfg().FirehoseConsumers) {
+		rdb.ZRem(ctx, key, consumer)
+		return false
+	}
+	return true
+}
+
+// streamFirehose writes ws's firehose after last to w until the recorder
+// goes away or the server shuts down, returning how many records it sent
+// and the last one's seq.
+func streamFirehose(ctx context.Context, w http.ResponseWriter, ws workspace, consumer, last string, sse bool) (int, string, error) {
+	flusher, _ := w.(http.Flusher)
+	heartbeat := time.NewTicker(firehoseHeartbeat)
+	defer heartbeat.Stop()
+	idleSince, sent := time.Now(), 0
+	for {
+		entries, err := rdb.XRangeN(ctx, ws.firehoseKey(), "("+last, "+", firehoseBatch).Result()
+		if err != nil && ctx.Err() == nil {
+			return sent, last, err
+		}
+		for _, e := range entries {
+			rec, ok := firehoseRecord(e)
+			if !ok {
+				log.Printf("❌ Firehose entry %s of workspace %q is unreadable; skipped", e.ID, ws)
+				last = e.ID
+				continue
+			}
+			line, _ := json.Marshal(rec)
+			if sse {
+				_, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", e.ID, line)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", line)
+			}
+			if err != nil {
+				return sent, last, err
+			}
+			sent++
+			last = e.ID
+		}
+		if len(entries) > 0 {
+			idleSince = time.Now()
+		} else if time.Since(idleSince) >= firehoseKeepalive {
+			keepalive := "\n"
+			if sse {
+				keepalive = ": keepalive\n\n"
+			}
+			if _, err := fmt.Fprint(w, keepalive); err != nil {
+				return sent, last, err
+			}
+			idleSince = time.Now()
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(entries) == firehoseBatch {
+			continue
+		}
+		select {
+		case <-time.After(firehosePoll):
+		case <-heartbeat.C:
+			rdb.ZAdd(ctx, firehoseConsumersKey(), redis.Z{Score: float64(time.Now().Unix()), Member: consumer})
+		case <-ctx.Done():
+			return sent, last, nil
+		case <-firehoseStop:
+			return sent, last, nil
+		}
+	}
+}
+
+// firehoseRecord decodes one firehose entry.
+func firehoseRecord(e redis.XMessage) (protocol.FirehoseRecord, bool) {
+	conversation, _ := e.Values["conversation"].(string)
+	seqField, _ := e.Values["seq"].(string)
+	sealed, _ := e.Values["message"].(string)
+	mac, _ := e.Values["mac"].(string)
+	seq, err := strconv.ParseInt(seqField, 10, 64)
+	if err != nil {
+		return protocol.FirehoseRecord{}, false
+	}
+	plain, err := unseal(sealed)
+	if err != nil || !json.Valid(plain) {
+		return protocol.FirehoseRecord{}, false
+	}
+	return protocol.FirehoseRecord{Seq: e.ID, Conversation: conversation, ConversationSeq: seq, Message: plain, MAC: mac}, true
+}
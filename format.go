@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+)
+
+// Message formats. msg, dm, room_send and group_dm_send may say how their
+// text is meant to be rendered with "format": "plain" (the default),
+// "markdown", or "code", which may name its language in "lang" ("go",
+// "python", "c++") for highlighting. The server renders neither. It checks
+// the format against the allow-list, stores it with the message (plain as
+// no format at all, like every message from before formats) and passes it
+// on, so history, exports and the bridges keep it. Code is kept as it was
+// written: its shortcodes aren't expanded (":=" and "::" are code, not
+// emoji), its links aren't previewed, and it may be longer (see maxChars).
+// Markdown gets what plain text gets.
+const (
+	formatPlain    = "plain"
+	formatMarkdown = "markdown"
+	formatCode     = "code"
+	maxLangHint    = 32
+)
+
+var messageFormats = map[string]bool{formatPlain: true, formatMarkdown: true, formatCode: true}
+
+// validLangHint reports whether lang may name a code message's language:
+// up to 32 lowercase letters, digits and "+#._-".
+func validLangHint(lang string) bool {
+	if lang == "" || len(lang) > maxLangHint {
+		return false
+	}
+	for _, r := range lang {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || strings.ContainsRune("+#._-", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyFormat sets msg's format and language hint, or sends the error and
+// returns false. Hints are taken case-insensitively.
+func applyFormat(c *client, msg *ChatMessage, format, lang string) bool {
+	if format != "" && !messageFormats[format] {
+		sendError(c, "bad_format", "format must be plain, markdown or code")
+		return false
+	}
+	lang = strings.ToLower(lang)
+	if lang != "" && (format != formatCode || !validLangHint(lang)) {
+		sendError(c, "bad_format", "lang is for code, and may be up to 32 letters, digits and +#._-")
+		return false
+	}
+	if format != formatPlain {
+		msg.Format = format
+	}
+	msg.Lang = lang
+	return true
+}
+
+// maxChars is the most characters a message of format may have; 0 is
+// unlimited.
+func maxChars(format string) int {
+	conf := cfg()
+	if format != formatCode {
+		return conf.MaxMessageChars
+	}
+	if conf.MaxCodeChars > 0 {
+		return conf.MaxCodeChars
+	}
+	return 4 * conf.MaxMessageChars
+}
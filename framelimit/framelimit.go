@@ -0,0 +1,290 @@
+// Package framelimit cuts frames the chat server sends down to a size
+// limit, by the rules of their type. Optional enrichments go first: a
+// message's resolved emoji and meta, a group DM's last message in the
+// joined frame, the snippets of a conversation list, a link preview's
+// image (then its description and title are cut short, ending in "…").
+// Then lists are cut short, as few entries as needed, in order:
+//
+//	init              history (oldest first), spectators, members
+//	history_chunk     history (oldest first)
+//	room_joined       history (oldest first)
+//	group_dm_history  history (oldest first)
+//	joined            roomsGone, groupDms, rooms (with their unread counts
+//	                  and read positions)
+//	room_list         rooms
+//	conversations     conversations
+//	members_page      spectators, members
+//	member_search     results
+//	sessions          sessions
+//
+// Histories lose their oldest messages, other lists their last entries.
+// A frame that was cut says "truncated":true, or for a message, the
+// message does. Any other type has no rule, and neither does a frame that
+// isn't JSON.
+package framelimit
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"websocket-chatapp/protocol"
+)
+
+// Cut returns data, a frame over max bytes, cut down to fit by its type's
+// rules, or false if it has none or still doesn't fit.
+func Cut(data []byte, max int) ([]byte, bool) {
+	typ, ok := Type(data)
+	if !ok {
+		return nil, false
+	}
+	rule, ok := rules[typ]
+	if !ok {
+		return nil, false
+	}
+	return rule(data, max)
+}
+
+// Type returns the type of a JSON frame, "" for a message, and false if
+// data isn't JSON.
+func Type(data []byte) (string, bool) {
+	var frame struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(data, &frame) != nil {
+		return "", false
+	}
+	return frame.Type, true
+}
+
+// rules cut a frame of one type ("" for a message, which has
+// none) down to at most max bytes, reporting false if they can't.
+var rules = map[string]func(data []byte, max int) ([]byte, bool){
+	"": func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max, stripMessage)
+	},
+	protocol.TypeRoomMessage: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max, func(f *protocol.RoomMessage, fits func() bool) { stripMessage(&f.Message, fits) })
+	},
+	protocol.TypeLinkPreview: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max,
+			func(f *protocol.LinkPreview, fits func() bool) { f.Truncated, f.Preview.Image = true, "" },
+			func(f *protocol.LinkPreview, fits func() bool) { cutString(&f.Preview.Description, fits) },
+			func(f *protocol.LinkPreview, fits func() bool) { cutString(&f.Preview.Title, fits) })
+	},
+	protocol.TypeInit: func(data []byte, max int) ([]byte, bool) {
+		// The history as messages rather than raw JSON; the outer field
+		// hides Init's.
+		type initFrame struct {
+			protocol.Init
+			History []protocol.Message `json:"history"`
+		}
+		return shrink(data, max,
+			func(f *initFrame, fits func() bool) {
+				f.Truncated = true
+				stripMessages(f.History, fits)
+				if f.Pinned != nil {
+					stripMessage(f.Pinned, fits)
+				}
+			},
+			func(f *initFrame, fits func() bool) { elide(&f.History, true, fits) },
+			func(f *initFrame, fits func() bool) { elide(&f.Spectators, false, fits) },
+			func(f *initFrame, fits func() bool) { elide(&f.Members, false, fits) })
+	},
+	protocol.TypeHistoryChunk: func(data []byte, max int) ([]byte, bool) {
+		type chunkFrame struct {
+			protocol.HistoryChunk
+			History []protocol.Message `json:"history"`
+		}
+		return shrink(data, max,
+			func(f *chunkFrame, fits func() bool) { f.Truncated = true; stripMessages(f.History, fits) },
+			func(f *chunkFrame, fits func() bool) { elide(&f.History, true, fits) })
+	},
+	protocol.TypeRoomJoined: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max,
+			func(f *protocol.RoomJoined, fits func() bool) { f.Truncated = true; stripMessages(f.History, fits) },
+			func(f *protocol.RoomJoined, fits func() bool) { elide(&f.History, true, fits) })
+	},
+	protocol.TypeGroupDMHistory: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max,
+			func(f *protocol.GroupDMHistory, fits func() bool) { f.Truncated = true; stripMessages(f.History, fits) },
+			func(f *protocol.GroupDMHistory, fits func() bool) { elide(&f.History, true, fits) })
+	},
+	protocol.TypeJoined: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max,
+			func(f *protocol.Joined, fits func() bool) {
+				f.Truncated = true
+				for i := range f.GroupDMs {
+					f.GroupDMs[i].LastMessage = nil
+				}
+			},
+			func(f *protocol.Joined, fits func() bool) { elide(&f.RoomsGone, false, fits) },
+			func(f *protocol.Joined, fits func() bool) { elide(&f.GroupDMs, false, joinedFits(f, fits)) },
+			func(f *protocol.Joined, fits func() bool) { elide(&f.Rooms, false, joinedFits(f, fits)) })
+	},
+	protocol.TypeRoomList: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max, func(f *protocol.RoomList, fits func() bool) {
+			f.Truncated = true
+			elide(&f.Rooms, false, fits)
+		})
+	},
+	protocol.TypeConversations: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max,
+			func(f *protocol.Conversations, fits func() bool) {
+				f.Truncated = true
+				for i := range f.Conversations {
+					f.Conversations[i].Last = nil
+				}
+			},
+			func(f *protocol.Conversations, fits func() bool) { elide(&f.Conversations, false, fits) })
+	},
+	protocol.TypeMembersPage: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max,
+			func(f *protocol.MembersPage, fits func() bool) { f.Truncated = true; elide(&f.Spectators, false, fits) },
+			func(f *protocol.MembersPage, fits func() bool) { elide(&f.Members, false, fits) })
+	},
+	protocol.TypeMemberSearch: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max, func(f *protocol.MemberSearch, fits func() bool) {
+			f.Truncated = true
+			elide(&f.Results, false, fits)
+		})
+	},
+	protocol.TypeSessions: func(data []byte, max int) ([]byte, bool) {
+		return shrink(data, max, func(f *protocol.Sessions, fits func() bool) {
+			f.Truncated = true
+			elide(&f.Sessions, false, fits)
+		})
+	},
+}
+
+// joinedFits wraps fits for cutting a joined frame's group DMs or rooms:
+// each time, the unread counts and read positions of those cut are left
+// out before it measures.
+func joinedFits(f *protocol.Joined, fits func() bool) func() bool {
+	unread, read := f.RoomUnread, f.ReadPositions
+	return func() bool {
+		kept := map[string]bool{}
+		for _, room := range f.Rooms {
+			kept["room:"+room] = true
+		}
+		for _, g := range f.GroupDMs {
+			kept["group:"+g.ID] = true
+		}
+		if unread != nil {
+			f.RoomUnread = map[string]int64{}
+			for room, n := range unread {
+				if kept["room:"+room] {
+					f.RoomUnread[room] = n
+				}
+			}
+		}
+		if read != nil {
+			f.ReadPositions = map[string]protocol.ReadPosition{}
+			for conversation, pos := range read {
+				if kept[conversation] || !isRoomOrGroup(conversation) {
+					f.ReadPositions[conversation] = pos
+				}
+			}
+		}
+		return fits()
+	}
+}
+
+func isRoomOrGroup(conversation string) bool {
+	return strings.HasPrefix(conversation, "room:") || strings.HasPrefix(conversation, "group:")
+}
+
+// shrink decodes data as a T and applies steps to it in order until it
+// encodes within max. A step may call fits to see whether the frame fits
+// as it stands.
+func shrink[T any](data []byte, max int, steps ...func(f *T, fits func() bool)) ([]byte, bool) {
+	var f T
+	if json.Unmarshal(data, &f) != nil {
+		return nil, false
+	}
+	var out []byte
+	fits := func() bool {
+		out, _ = json.Marshal(&f)
+		return len(out) <= max
+	}
+	for _, step := range steps {
+		step(&f, fits)
+		if fits() {
+			return out, true
+		}
+	}
+	return nil, false
+}
+
+// stripMessage leaves out a message's enrichments.
+func stripMessage(m *protocol.Message, fits func() bool) {
+	if m.Emoji != nil || m.Meta != nil {
+		m.Emoji, m.Meta, m.Truncated = nil, nil, true
+	}
+}
+
+func stripMessages(list []protocol.Message, fits func() bool) {
+	for i := range list {
+		stripMessage(&list[i], fits)
+	}
+}
+
+// elide cuts *list short until fits, keeping its last entries if keepLast
+// and its first ones otherwise, and reports whether it dropped any.
+func elide[T any](list *[]T, keepLast bool, fits func() bool) bool {
+	all := *list
+	if len(all) == 0 || fits() {
+		return false
+	}
+	keep := func(n int) {
+		if keepLast {
+			*list = all[len(all)-n:]
+		} else {
+			*list = all[:n]
+		}
+	}
+	// The most entries that fit is in [lo, hi]; all of them don't.
+	lo, hi := 0, len(all)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		keep(mid)
+		if fits() {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	keep(lo)
+	return true
+}
+
+// cutString cuts *s short, ending it with "…", until fits, or to nothing.
+func cutString(s *string, fits func() bool) {
+	all := *s
+	if all == "" || fits() {
+		return
+	}
+	lo, hi := 0, len(all)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		*s = cutAt(all, mid)
+		if fits() {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	*s = cutAt(all, lo)
+}
+
+// cutAt is s cut to at most n bytes, on a character boundary, and ended
+// with "…"; "" for n 0.
+func cutAt(s string, n int) string {
+	if n == 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
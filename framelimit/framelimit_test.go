@@ -0,0 +1,334 @@
+package framelimit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"websocket-chatapp/framelimit"
+	"websocket-chatapp/protocol"
+)
+
+// rule is one frame to cut: to limit (a fraction of its size), and what
+// to check about the result, decoded into a fresh value like frame.
+type rule struct {
+	name  string
+	frame interface{}
+	limit float64
+	check func(t *testing.T, out []byte)
+}
+
+// TestCut cuts an oversized frame of every type with a rule to a limit and
+// checks what is left: that it fits and is still that frame, says it was
+// truncated, lost its enrichments before any list entry, kept the newest
+// history or the first entries of other lists, and dropped the unread
+// counts and read positions of the rooms and group DMs cut from joined. A
+// new list in such a frame needs a rule and a case here.
+func TestCut(t *testing.T) {
+	history := messages(100)
+	rooms := names("room", 500)
+	for _, r := range []rule{
+		{"message", enriched(protocol.Message{User: "alice", Text: "party :tada:", Time: 1}), .5, func(t *testing.T, out []byte) {
+			var m protocol.Message
+			decode(t, out, &m)
+			if !m.Truncated || m.Emoji != nil || m.Meta != nil || m.Text != "party :tada:" {
+				t.Errorf("got %s", out)
+			}
+		}},
+		{"room_message", protocol.NewRoomMessage("general", enriched(protocol.Message{User: "alice", Text: "hi", Time: 1})), .5, func(t *testing.T, out []byte) {
+			var f protocol.RoomMessage
+			decode(t, out, &f)
+			if f.Type != protocol.TypeRoomMessage || f.Room != "general" || !f.Message.Truncated || f.Message.Emoji != nil || f.Message.Text != "hi" {
+				t.Errorf("got %s", out)
+			}
+		}},
+		{"link_preview", protocol.NewLinkPreview("global", "m1", protocol.Preview{
+			URL: "https://example.com/", Title: "Example", Image: "https://example.com/" + strings.Repeat("i", 2000), Description: strings.Repeat("é", 5000),
+		}), .5, func(t *testing.T, out []byte) {
+			var f protocol.LinkPreview
+			decode(t, out, &f)
+			d := f.Preview.Description
+			if !f.Truncated || f.Preview.Image != "" || f.Preview.Title != "Example" || f.Preview.URL != "https://example.com/" ||
+				!strings.HasSuffix(d, "…") || !utf8.ValidString(d) || len(d) < 1000 {
+				t.Errorf("got image %q, title %q, %d bytes of description", f.Preview.Image, f.Preview.Title, len(d))
+			}
+		}},
+		{"init, history cut", initFrame(history, names("user", 50)), .5, func(t *testing.T, out []byte) {
+			f := decodeInit(t, out)
+			if !f.Truncated || len(f.history) == 0 || len(f.history) == len(history) || !slices.Equal(f.history, texts(history[len(history)-len(f.history):])) ||
+				len(f.Members) != 50 || f.Pinned.Emoji != nil {
+				t.Errorf("kept %d of %d messages, %d members", len(f.history), len(history), len(f.Members))
+			}
+		}},
+		{"init, members cut", initFrame(history, names("user", 2000)), .2, func(t *testing.T, out []byte) {
+			f := decodeInit(t, out)
+			if !f.Truncated || len(f.history) != 0 || len(f.Members) == 0 || len(f.Members) == 2000 || !slices.Equal(f.Members, names("user", len(f.Members))) {
+				t.Errorf("kept %d messages, %d members", len(f.history), len(f.Members))
+			}
+		}},
+		{"history_chunk", protocol.NewHistoryChunk(raw(history)), .5, func(t *testing.T, out []byte) {
+			var f struct {
+				Type      string             `json:"type"`
+				History   []protocol.Message `json:"history"`
+				Truncated bool               `json:"truncated"`
+			}
+			decode(t, out, &f)
+			checkNewest(t, f.Type == protocol.TypeHistoryChunk && f.Truncated, f.History, history)
+		}},
+		{"room_joined", protocol.NewRoomJoined(protocol.Room{Name: "general"}, history), .5, func(t *testing.T, out []byte) {
+			var f protocol.RoomJoined
+			decode(t, out, &f)
+			checkNewest(t, f.Truncated && f.Room.Name == "general", f.History, history)
+		}},
+		{"group_dm_history", protocol.NewGroupDMHistory("g1", history), .5, func(t *testing.T, out []byte) {
+			var f protocol.GroupDMHistory
+			decode(t, out, &f)
+			checkNewest(t, f.Truncated && f.ID == "g1", f.History, history)
+		}},
+		{"joined", joinedFrame(rooms), .3, func(t *testing.T, out []byte) {
+			var f protocol.Joined
+			decode(t, out, &f)
+			if !f.Truncated || f.Name != "alice" || f.Session != "s1" || len(f.RoomsGone) != 0 || len(f.GroupDMs) != 0 ||
+				len(f.Rooms) == 0 || len(f.Rooms) == len(rooms) || !slices.Equal(f.Rooms, rooms[:len(f.Rooms)]) {
+				t.Errorf("kept %d gone rooms, %d group DMs, %d of %d rooms", len(f.RoomsGone), len(f.GroupDMs), len(f.Rooms), len(rooms))
+			}
+			for room := range f.RoomUnread {
+				if !slices.Contains(f.Rooms, room) {
+					t.Errorf("kept the unread count of %s, which was cut", room)
+				}
+			}
+			for conversation := range f.ReadPositions {
+				room, isRoom := strings.CutPrefix(conversation, "room:")
+				if isRoom && !slices.Contains(f.Rooms, room) || strings.HasPrefix(conversation, "group:") {
+					t.Errorf("kept the read position of %s, which was cut", conversation)
+				}
+			}
+			if _, ok := f.ReadPositions["global"]; !ok || len(f.RoomUnread) != len(f.Rooms) {
+				t.Errorf("lost the global read position or unread counts of rooms it kept")
+			}
+		}},
+		{"joined, enrichments only", joinedFrame(nil), .9, func(t *testing.T, out []byte) {
+			var f protocol.Joined
+			decode(t, out, &f)
+			if !f.Truncated || len(f.GroupDMs) != 20 || len(f.RoomsGone) != 20 || f.GroupDMs[0].LastMessage != nil {
+				t.Errorf("cut lists, or kept the group DMs' last messages, when dropping those was enough")
+			}
+		}},
+		{"room_list", roomList(rooms), .5, func(t *testing.T, out []byte) {
+			var f protocol.RoomList
+			decode(t, out, &f)
+			if !f.Truncated || f.Next != "cursor" || len(f.Rooms) == 0 || len(f.Rooms) == len(rooms) || f.Rooms[len(f.Rooms)-1].Name != rooms[len(f.Rooms)-1] {
+				t.Errorf("kept %d of %d rooms, next %q", len(f.Rooms), len(rooms), f.Next)
+			}
+		}},
+		{"conversations", conversations(rooms), .6, func(t *testing.T, out []byte) {
+			var f protocol.Conversations
+			decode(t, out, &f)
+			if !f.Truncated || len(f.Conversations) != len(rooms) || f.Conversations[0].Last != nil {
+				t.Errorf("kept %d of %d, snippets dropped: %v", len(f.Conversations), len(rooms), f.Conversations[0].Last == nil)
+			}
+		}},
+		{"conversations, list cut", conversations(rooms), .1, func(t *testing.T, out []byte) {
+			var f protocol.Conversations
+			decode(t, out, &f)
+			if !f.Truncated || len(f.Conversations) == 0 || len(f.Conversations) == len(rooms) || f.Conversations[0].Conversation != "room:"+rooms[0] {
+				t.Errorf("kept %d of %d", len(f.Conversations), len(rooms))
+			}
+		}},
+		{"members_page", protocol.NewMembersPage(0, names("user", 500), names("guest", 500), 1000), .6, func(t *testing.T, out []byte) {
+			var f protocol.MembersPage
+			decode(t, out, &f)
+			if !f.Truncated || len(f.Members) != 500 || len(f.Spectators) == 0 || len(f.Spectators) == 500 || f.MemberCount != 1000 {
+				t.Errorf("kept %d members, %d spectators", len(f.Members), len(f.Spectators))
+			}
+		}},
+		{"member_search", protocol.NewMemberSearch("us", matches(500)), .5, func(t *testing.T, out []byte) {
+			var f protocol.MemberSearch
+			decode(t, out, &f)
+			if !f.Truncated || f.Prefix != "us" || len(f.Results) == 0 || len(f.Results) == 500 || f.Results[0].Name != "user0" {
+				t.Errorf("kept %d of 500", len(f.Results))
+			}
+		}},
+		{"sessions", protocol.NewSessions(sessions(200)), .5, func(t *testing.T, out []byte) {
+			var f protocol.Sessions
+			decode(t, out, &f)
+			if !f.Truncated || len(f.Sessions) == 0 || len(f.Sessions) == 200 || f.Sessions[0].ID != "s0" {
+				t.Errorf("kept %d of 200", len(f.Sessions))
+			}
+		}},
+	} {
+		t.Run(r.name, func(t *testing.T) {
+			data, _ := json.Marshal(r.frame)
+			max := int(float64(len(data)) * r.limit)
+			out, ok := framelimit.Cut(data, max)
+			if !ok {
+				t.Fatalf("a %d-byte frame couldn't be cut to %d", len(data), max)
+			}
+			if len(out) > max {
+				t.Errorf("cut to %d bytes, over the limit of %d", len(out), max)
+			}
+			if typ, _ := framelimit.Type(out); typ != typeOf(data) {
+				t.Errorf("cut to a %q frame", typ)
+			}
+			r.check(t, out)
+		})
+	}
+}
+
+// TestCutRefused checks that frames without a rule, and frames that can't
+// be cut enough, are refused.
+func TestCutRefused(t *testing.T) {
+	for _, r := range []rule{
+		{"whois (no rule)", protocol.Whois{Type: protocol.TypeWhois, Name: strings.Repeat("x", 5000)}, .5, nil},
+		{"message without enrichments", protocol.Message{User: "alice", Text: strings.Repeat("x", 5000)}, .5, nil},
+		{"text frame", "not json " + strings.Repeat("x", 5000), .5, nil},
+		{"init with nothing left to cut", protocol.NewInit(nil, nil, 0, raw(nil), 1), .5, nil},
+	} {
+		data, _ := json.Marshal(r.frame)
+		if s, ok := r.frame.(string); ok {
+			data = []byte(s)
+		}
+		if out, ok := framelimit.Cut(data, int(float64(len(data))*r.limit)); ok {
+			t.Errorf("%s: cut to %s, want refused", r.name, out)
+		}
+	}
+}
+
+func enriched(m protocol.Message) protocol.Message {
+	m.Meta = map[string]string{"alert": strings.Repeat("a", 500)}
+	for i := 0; i < 20; i++ {
+		m.Emoji = append(m.Emoji, protocol.CustomEmoji{Name: fmt.Sprint("tada", i), File: strings.Repeat("e", 50) + ".png"})
+	}
+	return m
+}
+
+func messages(n int) []protocol.Message {
+	var list []protocol.Message
+	for i := 0; i < n; i++ {
+		list = append(list, protocol.Message{ID: fmt.Sprint("m", i), User: "alice", Text: fmt.Sprintf("message %d %s", i, strings.Repeat("x", 100)), Time: int64(i)})
+	}
+	return list
+}
+
+func texts(list []protocol.Message) []string {
+	var t []string
+	for _, m := range list {
+		t = append(t, m.Text)
+	}
+	return t
+}
+
+func raw(list []protocol.Message) json.RawMessage {
+	if list == nil {
+		list = []protocol.Message{}
+	}
+	data, _ := json.Marshal(list)
+	return data
+}
+
+func names(prefix string, n int) []string {
+	var list []string
+	for i := 0; i < n; i++ {
+		list = append(list, fmt.Sprint(prefix, i))
+	}
+	return list
+}
+
+func initFrame(history []protocol.Message, members []string) protocol.Init {
+	f := protocol.NewInit(members, []string{}, len(members), raw(history), 1)
+	pinned := enriched(protocol.Message{User: "admin", Text: "read the rules"})
+	f.Pinned = &pinned
+	return f
+}
+
+type decodedInit struct {
+	protocol.Init
+	history []string
+}
+
+func decodeInit(t *testing.T, data []byte) decodedInit {
+	t.Helper()
+	var f decodedInit
+	decode(t, data, &f.Init)
+	var list []protocol.Message
+	json.Unmarshal(f.History, &list)
+	f.history = texts(list)
+	return f
+}
+
+// checkNewest checks that a cut history kept the last of all, and some
+// but not all.
+func checkNewest(t *testing.T, ok bool, got, all []protocol.Message) {
+	t.Helper()
+	if !ok || len(got) == 0 || len(got) == len(all) || !slices.Equal(texts(got), texts(all[len(all)-len(got):])) {
+		t.Errorf("kept %d of %d messages, not the newest", len(got), len(all))
+	}
+}
+
+func joinedFrame(rooms []string) protocol.Joined {
+	f := protocol.NewJoined("alice", "s1")
+	f.Rooms, f.RoomUnread, f.RoomsGone = rooms, map[string]int64{}, names("gone", 20)
+	f.ReadPositions = map[string]protocol.ReadPosition{"global": {ID: "m1", Time: 1}}
+	for _, room := range rooms {
+		f.RoomUnread[room] = 3
+		f.ReadPositions["room:"+room] = protocol.ReadPosition{ID: "m1", Time: 1}
+	}
+	for i := 0; i < 20; i++ {
+		last := protocol.Message{User: "bob", Text: strings.Repeat("z", 200)}
+		id := fmt.Sprint("g", i)
+		f.GroupDMs = append(f.GroupDMs, protocol.GroupDMSummary{ID: id, Members: []string{"alice", "bob"}, LastMessage: &last})
+		f.ReadPositions["group:"+id] = protocol.ReadPosition{ID: "m1", Time: 1}
+	}
+	return f
+}
+
+func roomList(rooms []string) protocol.RoomList {
+	var list []protocol.RoomListing
+	for _, room := range rooms {
+		list = append(list, protocol.RoomListing{Name: room, Topic: strings.Repeat("t", 100), Members: 3})
+	}
+	f := protocol.NewRoomList(list)
+	f.Next = "cursor"
+	return f
+}
+
+func conversations(rooms []string) protocol.Conversations {
+	var list []protocol.Conversation
+	for _, room := range rooms {
+		list = append(list, protocol.Conversation{Conversation: "room:" + room, Unread: 1,
+			Last: &protocol.LastActivity{ID: "m1", User: "bob", Snippet: strings.Repeat("s", 80), Time: 1}})
+	}
+	return protocol.NewConversations(list)
+}
+
+func matches(n int) []protocol.MemberMatch {
+	var list []protocol.MemberMatch
+	for i := 0; i < n; i++ {
+		list = append(list, protocol.MemberMatch{Name: fmt.Sprint("user", i), Online: true})
+	}
+	return list
+}
+
+func sessions(n int) []protocol.Session {
+	var list []protocol.Session
+	for i := 0; i < n; i++ {
+		list = append(list, protocol.Session{ID: fmt.Sprint("s", i), Device: "laptop", IP: "203.0.113.7", Instance: "i1"})
+	}
+	return list
+}
+
+func typeOf(data []byte) string {
+	typ, _ := framelimit.Type(data)
+	return typ
+}
+
+func decode(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+}
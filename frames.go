@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"websocket-chatapp/protocol"
+)
+
+// handleFrame dispatches a JSON frame on its "type"; the rest of the payload
+// is decoded by the handler itself.
+func handleFrame(c *client, ev Event) {
+	if strings.HasPrefix(ev.Type, "admin_") && ev.Type != protocol.TypeAdminAuth {
+		handleAdminFrame(c, ev)
+		return
+	}
+	data := []byte(ev.Data)
+	switch ev.Type {
+	case protocol.TypeMsg, protocol.TypeDM:
+		handleSendFrame(c, ev)
+	case protocol.TypeAdminAuth:
+		handleAdminAuth(c, data)
+	case protocol.TypeGroupDMCreate:
+		handleGroupDMCreate(c, data)
+	case protocol.TypeGroupDMSend:
+		handleGroupDMSend(c, data)
+	case protocol.TypeGroupDMAdd:
+		handleGroupDMAdd(c, data)
+	case protocol.TypeGroupDMRemove:
+		handleGroupDMRemove(c, data)
+	case protocol.TypeGroupDMLeave:
+		handleGroupDMLeave(c, data)
+	case protocol.TypeGroupDMHistory:
+		handleGroupDMHistory(c, data)
+	case protocol.TypeJoinRoom:
+		handleJoinRoom(c, data)
+	case protocol.TypeLeaveRoom:
+		handleLeaveRoom(c, data)
+	case protocol.TypeRoomSend:
+		handleRoomSend(c, data)
+	case protocol.TypeRoomSetCapacity:
+		handleRoomSetCapacity(c, data)
+	case protocol.TypeRoomInfo:
+		handleRoomInfo(c, data)
+	case protocol.TypeRoomInvite:
+		handleRoomInvite(c, data)
+	case protocol.TypeRoomUpdate:
+		handleRoomUpdate(c, data)
+	case protocol.TypeRoomDelete:
+		handleRoomDelete(c, data)
+	case protocol.TypeRoomList:
+		handleRoomList(c, data)
+	case protocol.TypeDMStatus:
+		handleDMStatus(c, data)
+	case protocol.TypeQuota:
+		handleQuota(c, data)
+	case protocol.TypeAutoReply:
+		handleAutoReply(c, data)
+	case protocol.TypeTranslate:
+		handleTranslate(c, data)
+	case protocol.TypeWatch:
+		handleWatch(c, data)
+	case protocol.TypeSnooze:
+		handleSnooze(c, data)
+	case protocol.TypeConversations:
+		handleConversations(c, data)
+	case protocol.TypeDMClear:
+		handleDMClear(c, data)
+	case protocol.TypeHello:
+		handleHello(c, data)
+	case protocol.TypeSessions:
+		handleSessions(c, data)
+	case protocol.TypeSessionKill:
+		handleSessionKill(c, data)
+	case protocol.TypeLeave:
+		handleLeave(c, data)
+	case protocol.TypeNotifyEmail:
+		handleNotifyEmail(c, data)
+	case protocol.TypeE2EDM:
+		handleE2EDM(c, data)
+	case protocol.TypePublishKey:
+		handlePublishKey(c, data)
+	case protocol.TypeGetKey:
+		handleGetKey(c, data)
+	case protocol.TypeMarkRead:
+		handleMarkRead(c, data)
+	case protocol.TypeDismiss:
+		handleDismiss(c, data)
+	case protocol.TypeDeactivate:
+		handleDeactivate(c, data)
+	case protocol.TypeGenInvites:
+		handleGenInvites(c, data)
+	case protocol.TypePing:
+		handlePing(c, data)
+	case protocol.TypePong:
+		handlePong(c, data)
+	case protocol.TypeWhois:
+		handleWhois(c, data)
+	case protocol.TypeMyStats:
+		handleMyStats(c, data)
+	case protocol.TypeMembersPage:
+		handleMembersPage(c, data)
+	case protocol.TypeMemberSearch:
+		handleMemberSearch(c, data)
+	case protocol.TypeUserExists:
+		handleUserExists(c, data)
+	case protocol.TypeProfileUpdate:
+		handleProfileUpdate(c, data)
+	case protocol.TypePollCreate:
+		handlePollCreate(c, data)
+	case protocol.TypePollVote:
+		handlePollVote(c, data)
+	default:
+		log.Println("⚠️ Unknown frame type:", ev.Type)
+		sendError(c, "unknown_type", "unknown frame type: "+ev.Type)
+	}
+}
+
+// sendError sends an error frame with code and message, in c's language;
+// vars (name, value pairs) fill in the catalog's text. See lang.go.
+func sendError(c *client, code, message string, vars ...string) {
+	c.writeJSON(errorFrame(c, code, message, vars...))
+}
+
+// requireJoined returns the name the connection joined with, or sends a
+// not_joined error and returns "".
+func requireJoined(c *client) string {
+	name := c.userName()
+	if name == "" {
+		sendError(c, "not_joined", "join the chat first")
+	}
+	return name
+}
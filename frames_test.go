@@ -0,0 +1,98 @@
+package main_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestUnexpectedFrames has alice send binary frames, some holding what
+// would be valid chat frames as text, and empty frames, mixed with text
+// messages, one of them fragmented. Binary frames must each get an
+// unsupported_frame error, empty ones nothing, and neither may reach bob
+// as a message; the text messages must arrive in order and the
+// connection stay open.
+func TestUnexpectedFrames(t *testing.T) {
+	addr := startServer(t, "", withAdmin)
+	bob := dial(t, addr, "", "bob")
+	// A small write buffer makes the long message go out in fragments.
+	dialer := websocket.Dialer{WriteBufferSize: 256, HandshakeTimeout: 5 * time.Second}
+	alice, _, err := dialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { alice.Close() })
+	alice.WriteMessage(websocket.TextMessage, []byte("join:alice"))
+	awaitRaw(t, alice, protocol.TypeInitDone)
+
+	var before struct {
+		FramesRefused int64 `json:"framesRefused"`
+	}
+	stats(t, addr, &before)
+	long := strings.Repeat("fragmented ", 200)
+	frames := []struct {
+		binary bool
+		data   string
+	}{
+		{false, "msg:alice:first"},
+		{true, "msg:alice:binary prefix frame"},
+		{false, ""},
+		{true, `{"type":"msg","text":"binary JSON frame"}`},
+		{true, ""},
+		{false, "msg:alice:" + long},
+		{true, "\x00\xff\x00"},
+		{false, ""},
+		{false, `{"type":"msg","text":"last"}`},
+	}
+	binaries := 0
+	for _, f := range frames {
+		if f.binary {
+			binaries++
+			alice.WriteMessage(websocket.BinaryMessage, []byte(f.data))
+		} else {
+			alice.WriteMessage(websocket.TextMessage, []byte(f.data))
+		}
+	}
+
+	for _, want := range []string{"first", long, "last"} {
+		if got := awaitText(t, bob); got != want {
+			t.Fatalf("bob got %.40q, want %.40q", got, want)
+		}
+	}
+	// Everything alice sent has been handled by now: her errors are in.
+	errs := 0
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for texts := 0; texts < 3; {
+		_, data, err := alice.ReadMessage()
+		if err != nil {
+			t.Fatalf("alice's connection: %v", err)
+		}
+		var f struct {
+			Type, Code, Text string
+		}
+		json.Unmarshal(data, &f)
+		switch {
+		case f.Type == "" && f.Text != "": // a message
+			texts++
+		case f.Type == protocol.TypeError:
+			if f.Code != "unsupported_frame" {
+				t.Errorf("alice got error %s", data)
+			}
+			errs++
+		}
+	}
+	if errs != binaries {
+		t.Errorf("alice got %d errors for %d binary frames", errs, binaries)
+	}
+	var after struct {
+		FramesRefused int64 `json:"framesRefused"`
+	}
+	if stats(t, addr, &after); after.FramesRefused-before.FramesRefused != int64(binaries) {
+		t.Errorf("framesRefused went up by %d, want %d", after.FramesRefused-before.FramesRefused, binaries)
+	}
+}
@@ -0,0 +1,326 @@
+// Package framesig signs and verifies websocket frames with HMAC-SHA256,
+// for deployments where frames pass through a proxy that must not be able
+// to change or replay them unnoticed.
+//
+// A signed frame is a JSON envelope carrying the original frame, a
+// sequence number and a MAC:
+//
+//	{"seq":7,"frame":{"type":"init",...},"mac":"<base64url>"}
+//	{"seq":8,"text":"Welcome alice!","mac":"<base64url>"}
+//
+// JSON frames go in "frame", anything else in "text". The MAC is
+//
+//	HMAC-SHA256(key, "chat-frame-v1\n" + conn + "\n" + dir + "\n" + C(envelope without "mac"))
+//
+// where conn is the connection ID the server announces when signing
+// starts, dir is "s2c" or "c2s", and C is the canonical form below. Each
+// side numbers its frames 1, 2, 3…; a receiver accepts a frame only if
+// its seq is higher than the last one it accepted. conn and dir stop a
+// frame from being replayed on another connection or reflected back.
+//
+// The canonical form is that of RFC 8785 (JSON Canonicalization Scheme),
+// so a proxy that re-encodes JSON (reorders keys, changes whitespace or
+// escapes) doesn't break signatures: no insignificant whitespace, object
+// keys sorted by their UTF-16 code units, strings escaped only where JSON
+// requires it (", \, and control characters, using \b \f \n \r \t or
+// \u00xx), and numbers in the shortest form that round-trips a float64,
+// written as ECMAScript does. Duplicate keys are an error.
+package framesig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const macContext = "chat-frame-v1"
+
+// Directions, as used in the MAC.
+const (
+	ServerToClient = "s2c"
+	ClientToServer = "c2s"
+)
+
+var (
+	ErrUnsigned  = errors.New("framesig: frame is not a signed envelope")
+	ErrBadMAC    = errors.New("framesig: MAC mismatch")
+	ErrReplayed  = errors.New("framesig: sequence number not increasing")
+	errDuplicate = errors.New("framesig: duplicate object key")
+)
+
+// Canonicalize returns the canonical form of a JSON document.
+func Canonicalize(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	if err := canonicalValue(dec, &buf); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, errors.New("framesig: trailing data after JSON value")
+	}
+	return buf.Bytes(), nil
+}
+
+func canonicalValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			buf.WriteByte('[')
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := canonicalValue(dec, buf); err != nil {
+					return err
+				}
+			}
+			dec.Token() // ]
+			buf.WriteByte(']')
+			return nil
+		}
+		return canonicalObject(dec, buf)
+	case string:
+		writeString(buf, t)
+	case json.Number:
+		s, err := canonicalNumber(t)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func canonicalObject(dec *json.Decoder, buf *bytes.Buffer) error {
+	type member struct {
+		key   string
+		value []byte
+	}
+	var members []member
+	seen := map[string]bool{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if seen[key] {
+			return errDuplicate
+		}
+		seen[key] = true
+		var v bytes.Buffer
+		if err := canonicalValue(dec, &v); err != nil {
+			return err
+		}
+		members = append(members, member{key, v.Bytes()})
+	}
+	dec.Token() // }
+
+	sort.Slice(members, func(i, j int) bool { return lessUTF16(members[i].key, members[j].key) })
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeString(buf, m.key)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber formats n as ECMAScript's Number.prototype.toString
+// does for the nearest float64.
+func canonicalNumber(n json.Number) (string, error) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", fmt.Errorf("framesig: number %s out of range", n)
+	}
+	if f == 0 {
+		return "0", nil // also -0
+	}
+	abs := math.Abs(f)
+	if abs >= 1e21 || abs < 1e-6 {
+		// Exponent form, without the leading zeros Go adds: 1e-7, 1.5e+21.
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		mant, exp, _ := strings.Cut(s, "e")
+		sign := exp[0]
+		exp = strings.TrimLeft(exp[1:], "0")
+		return mant + "e" + string(sign) + exp, nil
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// Signer holds one connection's key, ID and sequence numbers. Its methods
+// are safe for concurrent use, but callers must serialize Seal with the
+// writes so frames go out in sequence order.
+type Signer struct {
+	key  []byte
+	conn string
+	// sendDir and recvDir are the directions of frames this side sends
+	// and receives.
+	sendDir, recvDir string
+
+	mu      sync.Mutex
+	sendSeq uint64
+	recvSeq uint64
+}
+
+// NewSigner returns a Signer for one end of connection conn. server says
+// which end.
+func NewSigner(key []byte, conn string, server bool) *Signer {
+	s := &Signer{key: key, conn: conn, sendDir: ClientToServer, recvDir: ServerToClient}
+	if server {
+		s.sendDir, s.recvDir = ServerToClient, ClientToServer
+	}
+	return s
+}
+
+type envelope struct {
+	Seq   uint64          `json:"seq"`
+	Frame json.RawMessage `json:"frame,omitempty"`
+	Text  *string         `json:"text,omitempty"`
+	MAC   string          `json:"mac,omitempty"`
+}
+
+// Seal wraps payload in a signed envelope with the next sequence number.
+func (s *Signer) Seal(payload []byte) ([]byte, error) {
+	s.mu.Lock()
+	s.sendSeq++
+	seq := s.sendSeq
+	s.mu.Unlock()
+
+	env := envelope{Seq: seq}
+	if json.Valid(payload) && isObjectOrArray(payload) {
+		env.Frame = payload
+	} else {
+		text := string(payload)
+		env.Text = &text
+	}
+	mac, err := s.mac(s.sendDir, env)
+	if err != nil {
+		return nil, err
+	}
+	env.MAC = mac
+	return json.Marshal(env)
+}
+
+// Open verifies a signed envelope and returns the frame inside it: the
+// JSON of "frame" in canonical form, or the bytes of "text".
+func (s *Signer) Open(data []byte) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.MAC == "" || (env.Frame == nil) == (env.Text == nil) {
+		return nil, ErrUnsigned
+	}
+	got, err := base64.RawURLEncoding.DecodeString(env.MAC)
+	if err != nil {
+		return nil, ErrBadMAC
+	}
+	want, err := s.mac(s.recvDir, env)
+	if err != nil {
+		return nil, err
+	}
+	wantRaw, _ := base64.RawURLEncoding.DecodeString(want)
+	if !hmac.Equal(got, wantRaw) {
+		return nil, ErrBadMAC
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if env.Seq <= s.recvSeq {
+		return nil, ErrReplayed
+	}
+	s.recvSeq = env.Seq
+
+	if env.Text != nil {
+		return []byte(*env.Text), nil
+	}
+	return Canonicalize(env.Frame)
+}
+
+// MAC computes the base64url MAC of an envelope (without its "mac" member)
+// for the given direction. It is exported for clients in other languages
+// to check their implementation against.
+func MAC(key []byte, conn, dir string, unsignedEnvelope []byte) (string, error) {
+	canon, err := Canonicalize(unsignedEnvelope)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(macContext + "\n" + conn + "\n" + dir + "\n"))
+	h.Write(canon)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *Signer) mac(dir string, env envelope) (string, error) {
+	env.MAC = ""
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return MAC(s.key, s.conn, dir, raw)
+}
+
+func isObjectOrArray(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[')
+}
@@ -0,0 +1,97 @@
+package framesig_test
+
+import (
+	"testing"
+
+	"websocket-chatapp/framesig"
+)
+
+// TestCanonicalize checks the canonical form against RFC 8785: numbers as
+// ECMAScript writes them, keys in UTF-16 order, minimal escaping.
+func TestCanonicalize(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`1e3`, `1000`},
+		{`1E3`, `1000`},
+		{`100e-2`, `1`},
+		{`1.0`, `1`},
+		{`-0`, `0`},
+		{`0.0e10`, `0`},
+		{`1E-7`, `1e-7`},
+		{`0.000001`, `0.000001`},
+		{`1e-6`, `0.000001`},
+		{`1.5e21`, `1.5e+21`},
+		{`1e21`, `1e+21`},
+		{`999999999999999999999`, `1e+21`},
+		{`123456789012345678901`, `123456789012345680000`},
+		{`5e-324`, `5e-324`},
+		{`-1.25E+2`, `-125`},
+		{`0.1`, `0.1`},
+		{`[1e0, 2E+0, 3.00]`, `[1,2,3]`},
+		// Keys sorted by UTF-16 code units: the emoji's surrogates
+		// (D83D...) come before U+FB33, though its code point is higher.
+		{`{"\ufb33":1,"\ud83d\ude00":2,"\u20ac":3,"\u00f6":4,"\u0080":5,"1":6,"\r":7}`,
+			"{\"\\r\":7,\"1\":6,\"\u0080\":5,\"ö\":4,\"€\":3,\"😀\":2,\"\ufb33\":1}"},
+		{`{"b":1,"a":{"d":1,"c":2},"aa":3}`, `{"a":{"c":2,"d":1},"aa":3,"b":1}`},
+		{`{"a":{"x":1},"b":{"x":2}}`, `{"a":{"x":1},"b":{"x":2}}`},
+		{`"\u00e9\/\u001f\b\u007f"`, "\"é/\\u001f\\b\u007f\""},
+		{" { \"a\" :\n[ true , false , null ] } ", `{"a":[true,false,null]}`},
+	} {
+		got, err := framesig.Canonicalize([]byte(tc.in))
+		if err != nil || string(got) != tc.want {
+			t.Errorf("Canonicalize(%s) = %s, %v; want %s", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+// TestCanonicalizeRefused checks the documents that have no canonical form.
+func TestCanonicalizeRefused(t *testing.T) {
+	for _, in := range []string{
+		`{"a":1,"a":2}`,
+		`{"a":1,"\u0061":2}`, // the same key, escaped
+		`{"x":{"b":1,"b":1}}`,
+		`[{"k":1,"k":1}]`,
+		`1e400`,
+		`-1e400`,
+		`{"a":1} {"b":2}`,
+		`{"a":`,
+	} {
+		if got, err := framesig.Canonicalize([]byte(in)); err == nil {
+			t.Errorf("Canonicalize(%s) = %s, want an error", in, got)
+		}
+	}
+}
+
+// TestMAC checks MAC against a vector computed independently, and that
+// re-encoding the envelope (key order, whitespace, escapes, number forms)
+// doesn't change it while changing the connection, direction or content
+// does.
+func TestMAC(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	const want = "tXNcrrberDl3loIT2Ji_md5Bk25o_VWV7F4v-OyI5ok"
+	for _, env := range []string{
+		`{"frame":{"n":1000,"text":"héllo","type":"msg"},"seq":7}`,
+		`{"seq":7,"frame":{"type":"msg","text":"h\u00e9llo","n":1e3}}`,
+		"{ \"seq\" : 7.0 ,\n \"frame\" : { \"n\" : 10E2 , \"type\" : \"msg\" , \"text\" : \"h\\u00E9llo\" } }",
+	} {
+		if got, err := framesig.MAC(key, "conn-1", framesig.ClientToServer, []byte(env)); err != nil || got != want {
+			t.Errorf("MAC(%s) = %s, %v; want %s", env, got, err, want)
+		}
+	}
+	env := []byte(`{"frame":{"n":1000,"text":"héllo","type":"msg"},"seq":7}`)
+	for _, tc := range []struct {
+		name, conn, dir string
+		env             []byte
+	}{
+		{"another connection", "conn-2", framesig.ClientToServer, env},
+		{"the other direction", "conn-1", framesig.ServerToClient, env},
+		{"another seq", "conn-1", framesig.ClientToServer, []byte(`{"frame":{"n":1000,"text":"héllo","type":"msg"},"seq":8}`)},
+		{"another number", "conn-1", framesig.ClientToServer, []byte(`{"frame":{"n":1001,"text":"héllo","type":"msg"},"seq":7}`)},
+	} {
+		if got, _ := framesig.MAC(key, tc.conn, tc.dir, tc.env); got == want {
+			t.Errorf("%s has the same MAC", tc.name)
+		}
+	}
+	if _, err := framesig.MAC(key, "conn-1", framesig.ClientToServer, []byte(`{"seq":7,"seq":8}`)); err == nil {
+		t.Error("MAC of an envelope with a duplicate key succeeded")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+
+	"websocket-chatapp/framelimit"
+	"websocket-chatapp/protocol"
+)
+
+// Outbound frame size. Some frames the server builds grow with the data
+// behind them: the joined frame of a user in 500 rooms, a room_list page
+// of long topics, a link preview with an enormous description. Every frame
+// is checked against CHAT_MAX_OUTBOUND_BYTES just before it is written
+// (after outbound middleware, before signing). A bigger one is cut down by
+// the rules of its type (see package framelimit): optional enrichments go
+// first, then lists are cut short, and the frame says "truncated":true. A
+// frame with no rule, one that doesn't fit even then, or any frame with
+// CHAT_OUTBOUND_TRUNCATE=false, is dropped and logged, and the client gets
+// a frame_too_large error naming its type instead: a frame is sent whole,
+// cut down by these rules, or not at all.
+
+var (
+	outboundTruncated atomic.Int64 // frames cut down to fit
+	outboundRejected  atomic.Int64 // frames dropped for their size
+)
+
+// guardOutbound returns data if it is within CHAT_MAX_OUTBOUND_BYTES, or
+// cut down to fit; otherwise it tells c and reports false.
+func guardOutbound(c *client, data []byte) ([]byte, bool) {
+	max := cfg().MaxOutboundBytes
+	if len(data) <= max {
+		return data, true
+	}
+	typ, isJSON := framelimit.Type(data)
+	name := frameName(typ, isJSON)
+	if cfg().OutboundTruncate {
+		if out, ok := framelimit.Cut(data, max); ok {
+			outboundTruncated.Add(1)
+			log.Printf("✂️ Cut a %s frame for %q from %d to %d bytes (CHAT_MAX_OUTBOUND_BYTES)", name, c.userName(), len(data), len(out))
+			return out, true
+		}
+	}
+	outboundRejected.Add(1)
+	log.Printf("❌ Dropped a %s frame of %d bytes for %q: over CHAT_MAX_OUTBOUND_BYTES (%d)", name, len(data), c.userName(), max)
+	if typ == protocol.TypeError {
+		return nil, false // the error below would be dropped too
+	}
+	sendError(c, "frame_too_large", "a "+name+" frame for you was over "+strconv.Itoa(max)+" bytes and was dropped", "frame", name, "max", strconv.Itoa(max))
+	return nil, false
+}
+
+func frameName(typ string, isJSON bool) string {
+	switch {
+	case !isJSON:
+		return "text"
+	case typ == "":
+		return "message"
+	}
+	return typ
+}
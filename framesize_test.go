@@ -0,0 +1,50 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestOutboundLimit starts the server with a small CHAT_MAX_OUTBOUND_BYTES
+// and checks that a late joiner's init is cut to its newest history, and
+// that a message too big for the limit is dropped with a frame_too_large
+// error. The rules themselves are tested in package framelimit.
+func TestOutboundLimit(t *testing.T) {
+	const limit = 8192
+	addr := startServer(t, "", fmt.Sprint("CHAT_MAX_OUTBOUND_BYTES=", limit))
+	alice := dial(t, addr, "", "alice")
+	for i := 0; i < 30; i++ {
+		alice.SendFrame(protocol.SendRequest{Type: protocol.TypeMsg, Text: fmt.Sprintf("%03d %s", i, strings.Repeat("x", 400)), TempID: fmt.Sprint(i)})
+		await(t, alice, "message") // so it is in the server's recent history
+	}
+
+	bob, err := client.Dial("ws://" + addr + "/ws")
+	if err != nil {
+		t.Fatalf("bob: dial: %v", err)
+	}
+	t.Cleanup(func() { bob.Close() })
+	f := await(t, bob, protocol.TypeInit)
+	var init protocol.Init
+	decode(t, f, &init)
+	var history []protocol.Message
+	json.Unmarshal(init.History, &history)
+	if len(f.Raw) > limit || !init.Truncated || len(history) == 0 || !strings.HasPrefix(history[len(history)-1].Text, "029 ") {
+		t.Errorf("bob's init: %d bytes, truncated %v, %d messages", len(f.Raw), init.Truncated, len(history))
+	}
+	if err := bob.Join("bob"); err != nil {
+		t.Fatalf("bob: join: %v", err)
+	}
+	await(t, bob, protocol.TypeInitDone)
+
+	alice.SendFrame(protocol.SendRequest{Type: protocol.TypeMsg, Text: strings.Repeat("y", limit)})
+	var e protocol.Error
+	decode(t, await(t, bob, protocol.TypeError), &e)
+	if e.Code != "frame_too_large" || !strings.Contains(e.Message, "message") {
+		t.Errorf("bob got error %q (%s), want frame_too_large for a message", e.Code, e.Message)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// Group DMs are unnamed private conversations between a handful of users.
+// Each one has its own member set and history zset; messages are delivered
+// through every member's personal dm:<user> channel, all within one
+// workspace.
+const (
+	minGroupDMOthers  = 2
+	maxGroupDMMembers = 8
+)
+
+func handleGroupDMCreate(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.GroupDMCreateRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid group_dm_create frame")
+		return
+	}
+
+	var others []string
+	seen := map[string]bool{name: true}
+	for _, m := range req.Members {
+		m = resolveName(ctx, ws, strings.TrimSpace(m))
+		if m == "" || seen[m] {
+			continue
+		}
+		seen[m] = true
+		others = append(others, m)
+	}
+	if len(others) < minGroupDMOthers {
+		sendError(c, "bad_request", fmt.Sprintf("a group DM needs at least %d other members", minGroupDMOthers))
+		return
+	}
+	if len(others)+1 > maxGroupDMMembers {
+		sendError(c, "group_full", fmt.Sprintf("a group DM can have at most %d members", maxGroupDMMembers), "max", strconv.Itoa(maxGroupDMMembers))
+		return
+	}
+	for _, m := range others {
+		if rejectDeactivatedPeer(c, m) {
+			return
+		}
+	}
+
+	id := ids.New()
+	members := append([]string{name}, others...)
+
+	pipe := rdb.TxPipeline()
+	for _, m := range members {
+		pipe.SAdd(ctx, ws.groupMembersKey(id), m)
+		pipe.SAdd(ctx, ws.userGroupsKey(m), id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		sendError(c, "internal", "could not create group DM")
+		return
+	}
+
+	postGroupMessage(ctx, ws, id, systemMessage("system.group_started", "name", name, "others", strings.Join(others, ", ")))
+	publishGroupUpdate(ws, id, members, nil)
+}
+
+func handleGroupDMSend(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.GroupDMSendRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Text == "" || !validTempID(req.TempID) {
+		sendError(c, "bad_frame", "invalid group_dm_send frame")
+		return
+	}
+	if !requireGroupMember(c, req.ID, name) || !takeQuota(c) {
+		return
+	}
+
+	msg := newMessage(name, req.Text)
+	if !applyFormat(c, &msg, req.Format, req.Lang) || !runInbound(c, "group_dm", "group:"+req.ID, &msg) {
+		return
+	}
+	c.expectEcho(msg.ID, req.TempID)
+	if !postGroupMessage(ctx, ws, req.ID, msg) {
+		sendError(c, "not_stored", "message could not be stored; please retry")
+		return
+	}
+	sendAck(c, req.TempID, msg)
+	touchActivity(ctx, ws, name)
+	countTalker(ctx, ws, name)
+	recordEvent(ws, chatEvent{Type: "group_dm", User: name, Group: req.ID, Len: len(msg.Text)})
+	bridgeMessage(ws, "group:"+req.ID, msg)
+	previewLinks(ws, "group:"+req.ID, msg)
+	setReadPosition(ctx, ws, name, "group:"+req.ID, protocol.ReadPosition{ID: msg.ID, Time: msg.Time})
+}
+
+func handleGroupDMAdd(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.GroupDMAddRequest
+	if err := json.Unmarshal(data, &req); err != nil || strings.TrimSpace(req.Member) == "" {
+		sendError(c, "bad_frame", "invalid group_dm_add frame")
+		return
+	}
+	if !requireGroupMember(c, req.ID, name) {
+		return
+	}
+
+	member := resolveName(ctx, ws, strings.TrimSpace(req.Member))
+	members, _ := rdb.SMembers(ctx, ws.groupMembersKey(req.ID)).Result()
+	for _, m := range members {
+		if m == member {
+			sendError(c, "bad_request", member+" is already in this conversation")
+			return
+		}
+	}
+	if len(members)+1 > maxGroupDMMembers {
+		sendError(c, "group_full", fmt.Sprintf("a group DM can have at most %d members", maxGroupDMMembers), "max", strconv.Itoa(maxGroupDMMembers))
+		return
+	}
+	if rejectDeactivatedPeer(c, member) {
+		return
+	}
+
+	rdb.SAdd(ctx, ws.groupMembersKey(req.ID), member)
+	rdb.SAdd(ctx, ws.userGroupsKey(member), req.ID)
+
+	postGroupMessage(ctx, ws, req.ID, systemMessage("system.group_added", "name", name, "member", member))
+	publishGroupUpdate(ws, req.ID, append(members, member), nil)
+}
+
+func handleGroupDMRemove(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.GroupDMRemoveRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Member == "" {
+		sendError(c, "bad_frame", "invalid group_dm_remove frame")
+		return
+	}
+	req.Member = resolveName(ctx, ws, req.Member)
+	if !requireGroupMember(c, req.ID, name) {
+		return
+	}
+	if ok, _ := rdb.SIsMember(ctx, ws.groupMembersKey(req.ID), req.Member).Result(); !ok {
+		sendError(c, "bad_request", req.Member+" is not in this conversation")
+		return
+	}
+
+	leaveGroup(ctx, ws, req.ID, req.Member, systemMessage("system.group_removed", "name", name, "member", req.Member))
+}
+
+func handleGroupDMLeave(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.GroupDMLeaveRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid group_dm_leave frame")
+		return
+	}
+	if !requireGroupMember(c, req.ID, name) {
+		return
+	}
+
+	leaveGroup(ctx, ws, req.ID, name, systemMessage("system.group_left", "name", name))
+}
+
+func handleGroupDMHistory(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.GroupDMHistoryRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid group_dm_history frame")
+		return
+	}
+	if !requireGroupMember(c, req.ID, name) {
+		return
+	}
+
+	rawHistory, _ := rdb.ZRange(ctx, ws.groupMessagesKey(req.ID), -20, -1).Result()
+	history := decodeHistory(rawHistory)
+
+	c.writeJSON(protocol.NewGroupDMHistory(req.ID, history))
+}
+
+func requireGroupMember(c *client, id, name string) bool {
+	ctx := c.ctx
+	if id != "" {
+		if ok, _ := rdb.SIsMember(ctx, c.ws.groupMembersKey(id), name).Result(); ok {
+			return true
+		}
+	}
+	sendError(c, "not_found", "no such group DM")
+	return false
+}
+
+// leaveGroup drops member from future delivery. Their past messages stay in
+// the conversation history.
+func leaveGroup(ctx context.Context, ws workspace, id, member string, notice ChatMessage) {
+	rdb.SRem(ctx, ws.groupMembersKey(id), member)
+	rdb.SRem(ctx, ws.userGroupsKey(member), id)
+	rdb.HDel(ctx, ws.readPosKey(member), "group:"+id)
+
+	postGroupMessage(ctx, ws, id, notice)
+	members, _ := rdb.SMembers(ctx, ws.groupMembersKey(id)).Result()
+	publishGroupUpdate(ws, id, members, []string{member})
+}
+
+// postGroupMessage stores and delivers msg, reporting false if it could
+// not be stored (see storeMessage).
+func postGroupMessage(ctx context.Context, ws workspace, id string, msg ChatMessage) bool {
+	jsonMsg, _ := json.Marshal(msg)
+	if !storeMessage(ctx, ws, ws.groupMessagesKey(id), msg, jsonMsg) {
+		return false
+	}
+
+	frame, _ := json.Marshal(protocol.NewGroupDM(id, msg))
+	members, _ := rdb.SMembers(ctx, ws.groupMembersKey(id)).Result()
+	for _, m := range members {
+		publish(ws.userChannel(m), frame)
+	}
+	return true
+}
+
+// publishGroupUpdate tells current members (and anyone just removed) what the
+// membership of a group DM now looks like.
+func publishGroupUpdate(ws workspace, id string, members, removed []string) {
+	frame, _ := json.Marshal(protocol.NewGroupDMUpdate(id, members))
+
+	for _, m := range append(members, removed...) {
+		publish(ws.userChannel(m), frame)
+	}
+}
+
+func groupDMSummaries(ctx context.Context, ws workspace, name string) []protocol.GroupDMSummary {
+	summaries := []protocol.GroupDMSummary{}
+	ids, _ := rdb.SMembers(ctx, ws.userGroupsKey(name)).Result()
+	for _, id := range ids {
+		s := protocol.GroupDMSummary{ID: id}
+		s.Members, _ = rdb.SMembers(ctx, ws.groupMembersKey(id)).Result()
+
+		if last, _ := rdb.ZRange(ctx, ws.groupMessagesKey(id), -1, -1).Result(); len(last) == 1 {
+			if msg, ok := decodeMessage(last[0]); ok {
+				s.LastMessage = &msg
+			}
+		}
+
+		pos := getReadPosition(ctx, ws, name, "group:"+id)
+		s.Unread, _ = countBetween(ctx, ws, ws.groupMessagesKey(id), "("+strconv.FormatInt(pos.Time, 10), "+inf")
+
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
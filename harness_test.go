@@ -0,0 +1,256 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/memredis"
+	"websocket-chatapp/protocol"
+)
+
+// The end-to-end tests run the real server: TestMain builds it once, each
+// test starts its own instance (startServer) and talks to it with the Go
+// client, so they see the server exactly as clients do.
+
+var serverBin string
+
+// adminToken is the admin token of servers given the withAdmin setting.
+const (
+	adminToken = "test-admin"
+	withAdmin  = "CHAT_ADMIN_TOKEN=" + adminToken
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "chattest")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	serverBin = filepath.Join(dir, "chat")
+	if out, err := exec.Command("go", "build", "-o", serverBin, ".").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building the server: %v\n%s", err, out)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// startServer runs the server on a free port with the given settings
+// (NAME=value) and waits until it is ready; it is stopped when the test
+// ends. It uses the Redis at store, or the in-memory store if store is "".
+// It returns the server's address.
+func startServer(t testing.TB, store string, env ...string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	args := []string{"-memory", "-listen", addr}
+	if store != "" {
+		args = []string{"-redis", store, "-listen", addr}
+	}
+	cmd := exec.Command(serverBin, args...)
+	cmd.Env = append(os.Environ(), env...)
+	if testing.Verbose() {
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting the server: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if res, err := http.Get("http://" + addr + "/readyz"); err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return addr
+			}
+		}
+	}
+	t.Fatalf("the server on %s didn't get ready", addr)
+	return ""
+}
+
+// serveStore serves an in-memory store (package memredis) on a free port,
+// as cmd/flakyredis does, for instances that share it (see startServer),
+// holding every request for latency. It returns the store's address.
+func serveStore(t testing.TB, latency time.Duration) string {
+	t.Helper()
+	mem := memredis.NewServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mc, _ := mem.Dial(context.Background(), "tcp", "")
+			go delayedPipe(mc, nc, latency)
+			go func() { io.Copy(nc, mc); nc.Close() }()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// delayedPipe copies from src to dst, holding each read for latency.
+func delayedPipe(dst, src net.Conn, latency time.Duration) {
+	defer dst.Close()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			time.Sleep(latency)
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dial connects to the server at addr (with query, if any, e.g.
+// "?roster=events") and joins as name, reading up to init_done. The
+// connection is closed when the test ends.
+func dial(t testing.TB, addr, query, name string) *client.Client {
+	t.Helper()
+	c, err := client.Dial("ws://" + addr + "/ws" + query)
+	if err != nil {
+		t.Fatalf("%s: dial: %v", name, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Join(name); err != nil {
+		t.Fatalf("%s: join: %v", name, err)
+	}
+	await(t, c, protocol.TypeInitDone)
+	return c
+}
+
+// adminConn makes a connection to the server at addr (given withAdmin) an
+// admin one, then joins as name and subscribes to the admin feed, so that
+// the test can await each moderation action's admin_event. It becomes an
+// admin before joining, which an invite-only server asks of admins
+// without a code.
+func adminConn(t testing.TB, addr, name string) *client.Client {
+	t.Helper()
+	c, err := client.Dial("ws://" + addr + "/ws")
+	if err != nil {
+		t.Fatalf("%s: dial: %v", name, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SendFrame(protocol.AdminAuthRequest{Type: protocol.TypeAdminAuth, Token: adminToken})
+	await(t, c, protocol.TypeAdminAuth)
+	if err := c.Join(name); err != nil {
+		t.Fatalf("%s: join: %v", name, err)
+	}
+	await(t, c, protocol.TypeJoined)
+	c.SendFrame(map[string]string{"type": protocol.TypeAdminSubscribe})
+	await(t, c, protocol.TypeAdminSubscribed)
+	return c
+}
+
+// await reads c's frames up to one of type typ (or of one of types),
+// failing the test if none comes within 5 seconds.
+func await(t testing.TB, c *client.Client, typ string, types ...string) client.Frame {
+	t.Helper()
+	done := make(chan client.Frame, 1)
+	go func() {
+		for {
+			f, err := c.Read()
+			if err != nil {
+				return
+			}
+			if f.Type == typ || slices.Contains(types, f.Type) {
+				done <- f
+				return
+			}
+		}
+	}()
+	select {
+	case f := <-done:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s frame", typ)
+	}
+	return client.Frame{}
+}
+
+// decode unmarshals f into v.
+func decode(t testing.TB, f client.Frame, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(f.Raw, v); err != nil {
+		t.Fatalf("decoding %s: %v", f.Type, err)
+	}
+}
+
+// refused checks that c's next error frame has code.
+func refused(t testing.TB, c *client.Client, code string) {
+	t.Helper()
+	var e protocol.Error
+	decode(t, await(t, c, protocol.TypeError), &e)
+	if e.Code != code {
+		t.Fatalf("got error %q (%s), want %s", e.Code, e.Message, code)
+	}
+}
+
+// api sends body (if not nil) to path on the server at addr with the admin
+// token, and returns the response's status and body.
+func api(t testing.TB, addr, method, path string, body []byte) (int, []byte) {
+	t.Helper()
+	return apiAs(t, addr, adminToken, method, path, body)
+}
+
+// apiAs is api with token as the bearer token instead of the admin's.
+func apiAs(t testing.TB, addr, token, method, path string, body []byte) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, "http://"+addr+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return res.StatusCode, data
+}
+
+// stats reads the server's /api/stats into v.
+func stats(t testing.TB, addr string, v interface{}) {
+	t.Helper()
+	code, data := api(t, addr, http.MethodGet, "/api/stats", nil)
+	if code != http.StatusOK {
+		t.Fatalf("stats got %d: %s", code, data)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-chatapp/ids"
+)
+
+// messageSchemaVersion is stamped on every message written from now on so
+// future format migrations can tell old entries from new ones. Entries
+// written before versioning existed decode with V == 0.
+const messageSchemaVersion = 1
+
+const (
+	maxLoggedPayload = 200
+	maxLoggedCorrupt = 1000
+)
+
+var (
+	corruptHistoryEntries atomic.Int64
+
+	loggedCorruptMu sync.Mutex
+	loggedCorrupt   = make(map[string]bool)
+)
+
+func newMessage(user, text string) ChatMessage {
+	return ChatMessage{
+		ID:   ids.New(),
+		User: user,
+		Text: text,
+		Time: time.Now().Unix(),
+		V:    messageSchemaVersion,
+	}
+}
+
+// errNoMessageID is a stored entry that decodes but isn't a message: null,
+// {}, or anything else without an ID. Messages stored before they had IDs
+// (V 0) are told apart by their user and time.
+var errNoMessageID = errors.New("no message ID")
+
+// decodeMessage parses one stored history entry, decrypting it first if it
+// is sealed. Entries that fail to decode are counted and logged (once per
+// payload) and reported as not ok.
+func decodeMessage(raw string) (ChatMessage, bool) {
+	var msg ChatMessage
+	data, err := unseal(raw)
+	if err == nil {
+		err = json.Unmarshal(data, &msg)
+	}
+	if err == nil && msg.ID == "" && (msg.V != 0 || msg.User == "" || msg.Time <= 0) {
+		err = errNoMessageID
+	}
+	if err != nil {
+		corruptHistoryEntries.Add(1)
+		logCorruptEntry(raw, err)
+		return msg, false
+	}
+	return msg, true
+}
+
+// decodeHistory parses a page of history, skipping corrupted entries rather
+// than handing clients zero-value messages.
+func decodeHistory(raw []string) []ChatMessage {
+	history := make([]ChatMessage, 0, len(raw))
+	for _, h := range raw {
+		if msg, ok := decodeMessage(h); ok {
+			history = append(history, msg)
+		}
+	}
+	return history
+}
+
+func logCorruptEntry(raw string, err error) {
+	loggedCorruptMu.Lock()
+	defer loggedCorruptMu.Unlock()
+	if loggedCorrupt[raw] || len(loggedCorrupt) >= maxLoggedCorrupt {
+		return
+	}
+	loggedCorrupt[raw] = true
+
+	if len(raw) > maxLoggedPayload {
+		raw = raw[:maxLoggedPayload] + "..."
+	}
+	log.Printf("⚠️ Skipping corrupted history entry (%v): %q", err, raw)
+}
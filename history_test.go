@@ -0,0 +1,62 @@
+package main_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestCorruptHistory seeds the public history with entries that aren't
+// messages, between real ones, and checks that init skips them, keeping
+// a message stored before messages had IDs.
+func TestCorruptHistory(t *testing.T) {
+	store := serveStore(t, 0)
+	rdb := redis.NewClient(&redis.Options{Addr: store})
+	defer rdb.Close()
+	entries := []string{
+		`{"id":"m1","user":"alice","text":"first","time":1700000000,"v":1}`,
+		`null`,
+		`{}`,
+		`[]`,
+		`42`,
+		`"text"`,
+		`not json`,
+		`{"id":"","user":"mallory","text":"no id","time":1700000001,"v":1}`,
+		`{"user":"mallory","text":"no time"}`,
+		`{"text":"no user","time":1700000001}`,
+		`{"id":`,
+		"\xff\xfe",
+		`{"user":"bob","text":"from before IDs","time":1600000000}`,
+		`{"id":"m2","user":"alice","text":"last","time":1700000002,"v":1}`,
+	}
+	for i, e := range entries {
+		if err := rdb.ZAdd(context.Background(), "chat:messages", redis.Z{Score: float64(i + 1), Member: e}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := startServer(t, store)
+
+	c, err := client.Dial("ws://" + addr + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	var init protocol.Init
+	decode(t, await(t, c, protocol.TypeInit), &init)
+	var history []protocol.Message
+	if err := json.Unmarshal(init.History, &history); err != nil {
+		t.Fatalf("init history %s: %v", init.History, err)
+	}
+	var texts []string
+	for _, m := range history {
+		texts = append(texts, m.Text)
+	}
+	if len(texts) != 3 || texts[0] != "first" || texts[1] != "from before IDs" || texts[2] != "last" {
+		t.Errorf("init history holds %q, want first, from before IDs and last", texts)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Recent public history in memory. Every new connection's init reads the
+// last CHAT_HISTORY_LIMIT public messages, and after a restart thousands of
+// connections do it at once. So while an instance follows a workspace's
+// timeline (see fanout.go) it keeps the last CHAT_HISTORY_CACHE public
+// messages in memory, appended from the broadcasts it delivers, and init
+// takes its history from there without asking Redis.
+//
+// The buffer is seeded from Redis by the first init that needs it, and
+// read again every CHAT_HISTORY_CACHE_CHECK to correct it: broadcasts from
+// several instances may arrive in another order than Redis numbered them,
+// and deleting a user's data on another instance changes messages this one
+// has. Messages broadcast while a read is under way are kept on top of what
+// it returned. Until it is seeded, after a resubscribe (a gap), after this
+// instance deleted public messages, and for requests larger than the
+// buffer, init reads Redis as before. Messages past CHAT_HISTORY_RETENTION
+// are filtered out and the buffer never holds more than CHAT_HISTORY_MAX. There are no edits to apply: messages change
+// only by deletion or anonymization, which the checks pick up.
+//
+// GET /api/stats counts inits served from the buffer (historyCacheHits)
+// and from Redis (historyCacheMisses), and the Redis round trips made
+// computing init states (initRedisTrips).
+type recentHistory struct {
+	mu   sync.Mutex
+	live bool // this instance follows the timeline
+	gen  int  // raised each time it starts following
+	warm bool // msgs matches Redis, as of the last read, plus broadcasts
+	// complete means msgs is all the history there is: the last read
+	// returned less than it asked for.
+	complete bool
+	msgs     []ChatMessage // oldest first
+	appended int64         // messages ever appended
+}
+
+var (
+	recentMu sync.Mutex
+	recent   = map[workspace]*recentHistory{}
+
+	historyCacheHits   atomic.Int64
+	historyCacheMisses atomic.Int64
+)
+
+func recentHistoryOf(ws workspace) *recentHistory {
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	r := recent[ws]
+	if r == nil {
+		r = &recentHistory{}
+		recent[ws] = r
+	}
+	return r
+}
+
+// follow starts keeping ws's recent history, until the returned stop is
+// called: the timeline listener runs between the two.
+func (r *recentHistory) follow(ws workspace) (stop func()) {
+	r.mu.Lock()
+	r.live, r.warm, r.complete, r.msgs = true, false, false, nil
+	r.gen++
+	gen := r.gen
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(serverCtx)
+	go func() {
+		for {
+			select {
+			case <-time.After(cfg().HistoryCacheCheck):
+			case <-ctx.Done():
+				return
+			}
+			if r.isWarm() {
+				if err := r.refresh(ctx, ws); err != nil && ctx.Err() == nil {
+					log.Printf("⚠️ Checking the recent history of workspace %q failed: %v", ws, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		r.mu.Lock()
+		if r.gen == gen {
+			r.live, r.warm, r.complete, r.msgs = false, false, false, nil
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *recentHistory) isWarm() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.warm
+}
+
+// add appends a delivered broadcast, if it is a message: other frames
+// (pins, link previews) have a type.
+func (r *recentHistory) add(payload []byte) {
+	var msg ChatMessage
+	var frame struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(payload, &frame) != nil || frame.Type != "" || json.Unmarshal(payload, &msg) != nil || msg.ID == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.live {
+		return
+	}
+	r.msgs = append(r.msgs, msg)
+	r.appended++
+	if size := recentHistorySize(); len(r.msgs) > size {
+		r.msgs = append(r.msgs[:0:0], r.msgs[len(r.msgs)-size:]...)
+		r.complete = false
+	}
+}
+
+// forget stops serving from the buffer until the next read: messages were
+// missed or removed.
+func (r *recentHistory) forget() {
+	r.mu.Lock()
+	r.warm = false
+	r.mu.Unlock()
+}
+
+// recentHistorySize is how many messages the buffer holds: the setting,
+// but never more than history keeps.
+func recentHistorySize() int {
+	size := cfg().HistoryCache
+	if max := cfg().HistoryMax; max > 0 {
+		size = min(size, max)
+	}
+	return size
+}
+
+// refresh reads the buffer's worth of history from Redis and makes it the
+// buffer, keeping the messages broadcast meanwhile that the read didn't
+// return.
+func (r *recentHistory) refresh(ctx context.Context, ws workspace) error {
+	size := recentHistorySize()
+	r.mu.Lock()
+	if !r.live || size <= 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	gen, start := r.gen, r.appended
+	r.mu.Unlock()
+
+	raw, err := rdb.ZRange(ctx, ws.messagesKey(), -int64(size), -1).Result()
+	if err != nil {
+		return err
+	}
+	snapshot := decodeHistory(raw)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.live || r.gen != gen {
+		return nil
+	}
+	read := make(map[string]bool, len(snapshot))
+	for _, m := range snapshot {
+		read[m.ID] = true
+	}
+	since := min(int(r.appended-start), len(r.msgs))
+	for _, m := range r.msgs[len(r.msgs)-since:] {
+		if !read[m.ID] {
+			snapshot = append(snapshot, m)
+		}
+	}
+	r.complete = len(raw) < size && len(snapshot) <= size
+	if len(snapshot) > size {
+		snapshot = snapshot[len(snapshot)-size:]
+	}
+	r.msgs, r.warm = snapshot, true
+	return nil
+}
+
+// latest returns the last n messages, oldest first, if the buffer can
+// tell what they are, seeding it first if it can be.
+func (r *recentHistory) latest(ctx context.Context, ws workspace, n int) ([]ChatMessage, bool) {
+	if n > recentHistorySize() {
+		return nil, false
+	}
+	r.mu.Lock()
+	seed := r.live && !r.warm
+	r.mu.Unlock()
+	if seed {
+		if err := r.refresh(ctx, ws); err != nil {
+			return nil, false
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.warm || (len(r.msgs) < n && !r.complete) {
+		return nil, false
+	}
+	msgs := r.msgs[max(len(r.msgs)-n, 0):]
+	if retention := cfg().HistoryRetention; retention > 0 {
+		cutoff := time.Now().Add(-retention).Unix()
+		for len(msgs) > 0 && msgs[0].Time < cutoff {
+			msgs = msgs[1:]
+		}
+	}
+	return append([]ChatMessage{}, msgs...), true
+}
+
+// recentPublicHistory returns ws's last n public messages, from the buffer
+// if it can, else from Redis.
+func recentPublicHistory(ctx context.Context, ws workspace, n int) []ChatMessage {
+	if history, ok := recentHistoryOf(ws).latest(ctx, ws, n); ok {
+		historyCacheHits.Add(1)
+		return history
+	}
+	historyCacheMisses.Add(1)
+	raw, _ := rdb.ZRange(ctx, ws.messagesKey(), -int64(n), -1).Result()
+	return decodeHistory(raw)
+}
+
+// forgetRecentHistory stops serving ws's buffer until it is read again,
+// after this instance removed or changed public messages.
+func forgetRecentHistory(ws workspace) {
+	recentMu.Lock()
+	r := recent[ws]
+	recentMu.Unlock()
+	if r != nil {
+		r.forget()
+	}
+}
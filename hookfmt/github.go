@@ -0,0 +1,151 @@
+package hookfmt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// GitHub names the event in X-GitHub-Event; the body is the event's
+// payload. https://docs.github.com/webhooks/webhook-events-and-payloads
+
+type ghUser struct {
+	Login string `json:"login"`
+}
+
+type ghIssue struct {
+	Number      int             `json:"number"`
+	Title       string          `json:"title"`
+	HTMLURL     string          `json:"html_url"`
+	Merged      bool            `json:"merged"`
+	PullRequest json.RawMessage `json:"pull_request"`
+}
+
+type ghPayload struct {
+	Action     string `json:"action"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender ghUser `json:"sender"`
+
+	// push
+	Ref     string `json:"ref"`
+	Created bool   `json:"created"`
+	Deleted bool   `json:"deleted"`
+	Forced  bool   `json:"forced"`
+	Compare string `json:"compare"`
+	Commits []struct {
+		Message string `json:"message"`
+	} `json:"commits"`
+	HeadCommit *struct {
+		Message string `json:"message"`
+		URL     string `json:"url"`
+	} `json:"head_commit"`
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+
+	// pull_request, issues, issue_comment
+	PullRequest *ghIssue `json:"pull_request"`
+	Issue       *ghIssue `json:"issue"`
+	Comment     *struct {
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	} `json:"comment"`
+
+	// ping
+	Zen string `json:"zen"`
+}
+
+func formatGitHub(header http.Header, body []byte) (string, error) {
+	event := header.Get("X-GitHub-Event")
+	var p ghPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return "", ErrPayload
+	}
+	repo, actor := p.Repository.FullName, p.Sender.Login
+
+	switch {
+	case event == "ping":
+		return line(repo, "", "GitHub webhook connected", p.Zen, ""), nil
+
+	case event == "push":
+		if actor == "" {
+			actor = p.Pusher.Name
+		}
+		link := p.Compare
+		if p.Deleted {
+			link = ""
+		}
+		return line(repo, actor, pushSummary(p.Ref, len(p.Commits), p.Created, p.Deleted, p.Forced), ghHeadline(p), link), nil
+
+	case event == "pull_request" && p.PullRequest != nil:
+		action := p.Action
+		if action == "closed" && p.PullRequest.Merged {
+			action = "merged"
+		}
+		return line(repo, actor, ghAction(action)+" pull request #"+itoa(p.PullRequest.Number), p.PullRequest.Title, p.PullRequest.HTMLURL), nil
+
+	case event == "issues" && p.Issue != nil:
+		return line(repo, actor, ghAction(p.Action)+" issue #"+itoa(p.Issue.Number), p.Issue.Title, p.Issue.HTMLURL), nil
+
+	case event == "issue_comment" && p.Issue != nil && p.Comment != nil:
+		kind := "issue"
+		if len(p.Issue.PullRequest) > 0 && string(p.Issue.PullRequest) != "null" {
+			kind = "pull request"
+		}
+		what := "commented on " + kind + " #" + itoa(p.Issue.Number)
+		if p.Action != "created" {
+			what = ghAction(p.Action) + " a comment on " + kind + " #" + itoa(p.Issue.Number)
+		}
+		return line(repo, actor, what, quote(p.Issue.Title, p.Comment.Body), p.Comment.HTMLURL), nil
+	}
+
+	return generic(repo, actor, event, p.Action), nil
+}
+
+// ghHeadline is the pushed head commit's subject, with a count of the
+// others.
+func ghHeadline(p ghPayload) string {
+	if p.HeadCommit == nil {
+		return ""
+	}
+	s := snippet(p.HeadCommit.Message)
+	if n := len(p.Commits) - 1; n > 0 {
+		s += " (+" + itoa(n) + " more)"
+	}
+	return s
+}
+
+// pushSummary describes a push to ref in words: "pushed 2 commits to
+// main", "created tag v1.0", "deleted branch old".
+func pushSummary(ref string, commits int, created, deleted, forced bool) string {
+	kind, name := "branch", ref
+	if b, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		name = b
+	} else if t, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		kind, name = "tag", t
+	}
+	switch {
+	case deleted:
+		return "deleted " + kind + " " + name
+	case kind == "tag", created && commits == 0:
+		return "created " + kind + " " + name
+	case forced:
+		return "force-pushed " + plural(commits, "commit") + " to " + name
+	}
+	return "pushed " + plural(commits, "commit") + " to " + name
+}
+
+// ghAction turns an action into the verb a line uses.
+func ghAction(action string) string {
+	switch action {
+	case "synchronize":
+		return "updated"
+	case "ready_for_review":
+		return "marked ready for review"
+	case "review_requested":
+		return "requested review on"
+	}
+	return humanize(action)
+}
@@ -0,0 +1,144 @@
+package hookfmt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// GitLab names the event in X-Gitlab-Event ("Push Hook") and again in the
+// body's object_kind, which is what is used here.
+// https://docs.gitlab.com/user/project/integrations/webhook_events/
+
+type glPayload struct {
+	ObjectKind string `json:"object_kind"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	User *struct {
+		Username string `json:"username"`
+	} `json:"user"`
+
+	// push and tag_push
+	UserUsername string `json:"user_username"`
+	Ref          string `json:"ref"`
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	CheckoutSHA  string `json:"checkout_sha"`
+	TotalCommits int    `json:"total_commits_count"`
+	Commits      []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+	} `json:"commits"`
+
+	// merge_request, issue, note
+	ObjectAttributes struct {
+		IID          int    `json:"iid"`
+		Title        string `json:"title"`
+		URL          string `json:"url"`
+		Action       string `json:"action"`
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+	} `json:"object_attributes"`
+	MergeRequest *glObject `json:"merge_request"`
+	Issue        *glObject `json:"issue"`
+}
+
+type glObject struct {
+	IID   int    `json:"iid"`
+	Title string `json:"title"`
+}
+
+// glZeroSHA is the before or after of a push creating or deleting a ref.
+const glZeroSHA = "0000000000000000000000000000000000000000"
+
+func formatGitLab(header http.Header, body []byte) (string, error) {
+	var p glPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return "", ErrPayload
+	}
+	project := p.Project.PathWithNamespace
+	actor := p.UserUsername
+	if p.User != nil {
+		actor = p.User.Username
+	}
+	attrs := p.ObjectAttributes
+
+	switch p.ObjectKind {
+	case "push", "tag_push":
+		created, deleted := p.Before == glZeroSHA, p.After == glZeroSHA
+		link := ""
+		if !created && !deleted && p.Project.WebURL != "" {
+			link = p.Project.WebURL + "/-/compare/" + p.Before + "..." + p.After
+		}
+		return line(project, actor, pushSummary(p.Ref, p.TotalCommits, created, deleted, false), glHeadline(p), link), nil
+
+	case "merge_request":
+		return line(project, actor, glAction(attrs.Action)+" merge request !"+itoa(attrs.IID), attrs.Title, attrs.URL), nil
+
+	case "issue":
+		return line(project, actor, glAction(attrs.Action)+" issue #"+itoa(attrs.IID), attrs.Title, attrs.URL), nil
+
+	case "note":
+		what, title := "commented", ""
+		switch {
+		case attrs.NoteableType == "MergeRequest" && p.MergeRequest != nil:
+			what, title = "commented on merge request !"+itoa(p.MergeRequest.IID), p.MergeRequest.Title
+		case attrs.NoteableType == "Issue" && p.Issue != nil:
+			what, title = "commented on issue #"+itoa(p.Issue.IID), p.Issue.Title
+		case attrs.NoteableType != "":
+			what = "commented on a " + humanize(attrs.NoteableType)
+		}
+		return line(project, actor, what, quote(title, attrs.Note), attrs.URL), nil
+	}
+
+	kind := p.ObjectKind
+	if kind == "" {
+		kind = strings.TrimSuffix(header.Get("X-Gitlab-Event"), " Hook")
+	}
+	return generic(project, actor, kind, attrs.Action), nil
+}
+
+// glHeadline is the subject of the commit the push left the ref at, with
+// a count of the others.
+func glHeadline(p glPayload) string {
+	if len(p.Commits) == 0 {
+		return ""
+	}
+	head := p.Commits[len(p.Commits)-1]
+	for _, c := range p.Commits {
+		if c.ID == p.CheckoutSHA {
+			head = c
+		}
+	}
+	s := snippet(head.Message)
+	if n := p.TotalCommits - 1; n > 0 {
+		s += " (+" + itoa(n) + " more)"
+	}
+	return s
+}
+
+// glAction turns a merge request or issue action into past tense.
+func glAction(action string) string {
+	switch action {
+	case "open":
+		return "opened"
+	case "close":
+		return "closed"
+	case "reopen":
+		return "reopened"
+	case "merge":
+		return "merged"
+	case "update":
+		return "updated"
+	case "approve", "approved":
+		return "approved"
+	case "unapprove", "unapproved":
+		return "unapproved"
+	case "":
+		return "updated"
+	}
+	return humanize(action)
+}
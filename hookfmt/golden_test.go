@@ -0,0 +1,98 @@
+package hookfmt_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"websocket-chatapp/hookfmt"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden file instead of checking it")
+
+// TestGolden runs every example delivery in testdata
+// (<format>/<name>.json, {"headers":{...},"body":...}) through the
+// formatter its directory names and compares the messages, line for line,
+// with testdata/golden.txt. A changed wording, a lost field or a formatter
+// that starts failing shows up as a difference. When a change in the
+// messages is intended, rewrite the file with go test ./hookfmt -update
+// and commit it along with the change. A new formatter or event gets an
+// example delivery here too.
+func TestGolden(t *testing.T) {
+	file := filepath.Join("testdata", "golden.txt")
+	got := render(t, "testdata")
+	if *update {
+		if err := os.WriteFile(file, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	wantLines := bytes.Split(want, []byte("\n"))
+	gotLines := bytes.Split(got, []byte("\n"))
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var w, g []byte
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if !bytes.Equal(w, g) {
+			t.Errorf("line %d:\n  want %s\n  got  %s", i+1, w, g)
+		}
+	}
+	t.Log("-update rewrites the golden file if the change is intended")
+}
+
+// render formats every example, one "<format>/<name>: <message>" line each,
+// sorted by path.
+func render(t *testing.T, dir string) []byte {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	var buf bytes.Buffer
+	for _, path := range paths {
+		rel, _ := filepath.Rel(dir, path)
+		format := filepath.Base(filepath.Dir(path))
+		f, ok := hookfmt.Lookup(format)
+		if !ok {
+			t.Fatalf("%s: no formatter named %q", rel, format)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var example struct {
+			Headers map[string]string `json:"headers"`
+			Body    json.RawMessage   `json:"body"`
+		}
+		if err := json.Unmarshal(raw, &example); err != nil {
+			t.Fatalf("%s: %v", rel, err)
+		}
+		header := http.Header{}
+		for k, v := range example.Headers {
+			header.Set(k, v)
+		}
+		msg, err := f(header, example.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", rel, err)
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", filepath.ToSlash(rel), msg)
+	}
+	return buf.Bytes()
+}
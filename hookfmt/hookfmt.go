@@ -0,0 +1,157 @@
+// Package hookfmt turns the payloads other services POST to incoming
+// webhooks into short chat messages. Each format (github, gitlab, text) is
+// a Formatter registered under its name; an incoming webhook names the one
+// its deliveries go through. A formatter reads what it needs from the
+// delivery's headers and JSON body and condenses it to one line: where it
+// happened, who did it, what and a link. Event types a formatter doesn't
+// know get a generic summary rather than an error, so a service adding
+// events never breaks a hook.
+//
+// testdata holds example deliveries for every formatter and golden.txt
+// what each renders to; TestGolden checks them.
+package hookfmt
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Formatter renders one delivery as message text. It returns "" for a
+// delivery that should post nothing.
+type Formatter func(header http.Header, body []byte) (string, error)
+
+// ErrPayload is returned for a body a formatter can't read.
+var ErrPayload = errors.New("unreadable webhook payload")
+
+var (
+	mu         sync.RWMutex
+	formatters = map[string]Formatter{}
+)
+
+// Register makes f available as name, replacing any formatter of that name.
+func Register(name string, f Formatter) {
+	mu.Lock()
+	defer mu.Unlock()
+	formatters[name] = f
+}
+
+// Lookup returns the formatter registered as name.
+func Lookup(name string) (Formatter, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, ok := formatters[name]
+	return f, ok
+}
+
+// Names lists the registered formats, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register("text", formatText)
+	Register("github", formatGitHub)
+	Register("gitlab", formatGitLab)
+}
+
+// formatText posts {"text":"..."} bodies, or the body itself if it is not
+// JSON, for scripts and services without a formatter of their own.
+func formatText(header http.Header, body []byte) (string, error) {
+	var p struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(body, &p) == nil {
+		return strings.TrimSpace(p.Text), nil
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+		return "", ErrPayload
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// maxSnippet is how much of a comment or commit message a line quotes.
+const maxSnippet = 140
+
+// snippet is the first line of s, cut to maxSnippet characters.
+func snippet(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > maxSnippet {
+		return string(r[:maxSnippet-1]) + "…"
+	}
+	return s
+}
+
+// line assembles "[where] who what: title link", leaving out empty parts.
+func line(where, who, what, title, link string) string {
+	var b strings.Builder
+	if where != "" {
+		b.WriteString("[" + where + "] ")
+	}
+	if who != "" {
+		b.WriteString(who + " ")
+	}
+	b.WriteString(what)
+	if title != "" {
+		b.WriteString(": " + title)
+	}
+	if link != "" {
+		b.WriteString(" " + link)
+	}
+	return b.String()
+}
+
+// generic summarizes an event no formatter knows: "[where] who: event
+// (action)".
+func generic(where, who, event, action string) string {
+	what := humanize(event)
+	if what == "" {
+		what = "unknown"
+	}
+	what += " event"
+	if action != "" {
+		what += " (" + humanize(action) + ")"
+	}
+	if who != "" {
+		who += ":"
+	}
+	return line(where, who, what, "", "")
+}
+
+// quote joins a title and a quoted comment.
+func quote(title, comment string) string {
+	if title == "" {
+		return snippet(comment)
+	}
+	return title + " — " + snippet(comment)
+}
+
+// plural is "1 commit" or "3 commits".
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return itoa(n) + " " + word + "s"
+}
+
+func itoa(n int) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+// humanize turns an event or action name ("issue_comment", "Push Hook")
+// into words.
+func humanize(s string) string {
+	return strings.ToLower(strings.NewReplacer("_", " ", "-", " ").Replace(s))
+}
@@ -0,0 +1,160 @@
+// Package i18n holds the catalogs of the server's human-readable text, per
+// language. A catalog maps a key ("welcome", "error.unknown_user") to a
+// text with {name} placeholders:
+//
+//	{"welcome": "¡Bienvenido, {name}!", "error.not_joined": "Únete al chat primero"}
+//
+// English (en) and Spanish (es) are built in, from catalogs/*.json. LoadDir
+// adds <lang>.json files from a directory, extending or overriding those,
+// so a deployment can add a language or reword one. A key a language
+// lacks falls back to its base language (es for es-MX), then to English,
+// then to the caller's own text.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fallback is the language every lookup ends at.
+const Fallback = "en"
+
+//go:embed catalogs/*.json
+var builtin embed.FS
+
+var (
+	mu       sync.RWMutex
+	catalogs = map[string]map[string]string{}
+)
+
+func init() {
+	entries, _ := builtin.ReadDir("catalogs")
+	for _, e := range entries {
+		data, _ := builtin.ReadFile("catalogs/" + e.Name())
+		if err := add(e.Name(), data); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// LoadDir adds every <lang>.json in dir.
+func LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if err := add(filepath.Base(f), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func add(file string, data []byte) error {
+	lang := Normalize(strings.TrimSuffix(file, ".json"))
+	if lang == "" {
+		return fmt.Errorf("i18n: %s: the file name must be a language tag, such as es.json", file)
+	}
+	var texts map[string]string
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return fmt.Errorf("i18n: %s: %w", file, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if catalogs[lang] == nil {
+		catalogs[lang] = map[string]string{}
+	}
+	for k, v := range texts {
+		catalogs[lang][k] = v
+	}
+	return nil
+}
+
+// Normalize returns tag lower-cased with "-" between its parts ("pt-br"),
+// or "" if it isn't a plausible language tag.
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if tag == "" || len(tag) > 35 {
+		return ""
+	}
+	for _, r := range tag {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return ""
+		}
+	}
+	return tag
+}
+
+// Match picks the language to use for a preference: a tag ("es-MX") or a
+// list in Accept-Language form ("fr-CA,fr;q=0.9,es;q=0.8"), taken in the
+// order given. It returns the first that has a catalog, or its base
+// language if that has one, else "".
+func Match(pref string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, part := range strings.Split(pref, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		tag = Normalize(tag)
+		if tag == "" {
+			continue
+		}
+		if catalogs[tag] != nil {
+			return tag
+		}
+		if base, _, ok := strings.Cut(tag, "-"); ok && catalogs[base] != nil {
+			return base
+		}
+	}
+	return ""
+}
+
+// Text returns key's text in lang with vars (name, value pairs) filled in,
+// or false if neither lang, its base language nor Fallback has key.
+func Text(lang, key string, vars ...string) (string, bool) {
+	mu.RLock()
+	text, ok := catalogs[lang][key]
+	if !ok {
+		if base, _, cut := strings.Cut(lang, "-"); cut {
+			text, ok = catalogs[base][key]
+		}
+	}
+	if !ok {
+		text, ok = catalogs[Fallback][key]
+	}
+	mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return Fill(text, vars...), true
+}
+
+// Fill replaces each {name} in text with its value from vars.
+func Fill(text string, vars ...string) string {
+	for i := 0; i+1 < len(vars); i += 2 {
+		text = strings.ReplaceAll(text, "{"+vars[i]+"}", vars[i+1])
+	}
+	return text
+}
+
+// Languages lists the languages with a catalog, sorted.
+func Languages() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
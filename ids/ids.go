@@ -0,0 +1,149 @@
+// Package ids generates every identifier and secret the server hands out,
+// so there is one format of each and its strength is written down here:
+//
+//   - New: a ULID, for things listed in creation order (messages, group
+//     DMs, archives, webhooks, connections). 26 characters of Crockford
+//     base32: 48 bits of Unix milliseconds, then 80 random bits. IDs from
+//     one process sort in creation order even within a millisecond (the
+//     random part counts up); IDs from different instances sort by
+//     millisecond. Not secret: the time is readable from the ID.
+//   - Token: a secret, for anything that grants access (incoming webhook
+//     URLs). 256 random bits, 43 characters of unpadded URL-safe base64.
+//   - Code: a secret short enough to type (invite codes), n characters of
+//     Crockford base32, 5 random bits each.
+//
+// All randomness comes from crypto/rand. Compare secrets with Equal, in
+// constant time, never with ==.
+package ids
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// crockford is Crockford's base32 alphabet: no I, L, O or U, so codes
+// read aloud or typed are hard to get wrong.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDLen is the length of an ID from New.
+const ULIDLen = 26
+
+// TokenBytes is the random bytes in a Token.
+const TokenBytes = 32
+
+var (
+	mu       sync.Mutex
+	lastMs   uint64
+	lastRand [10]byte
+)
+
+// New returns a ULID.
+func New() string {
+	return newAt(time.Now())
+}
+
+func newAt(now time.Time) string {
+	ms := uint64(now.UnixMilli())
+	mu.Lock()
+	switch {
+	case ms > lastMs:
+		rand.Read(lastRand[:])
+	case increment(&lastRand):
+		// Same millisecond, or the clock stepped back: count up, in order.
+		ms = lastMs
+	default:
+		ms = lastMs + 1
+		rand.Read(lastRand[:])
+	}
+	lastMs = ms
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(id[6:], lastRand[:])
+	mu.Unlock()
+	return encodeULID(id)
+}
+
+// increment adds one to r, reporting false if it overflowed.
+func increment(r *[10]byte) bool {
+	for i := len(r) - 1; i >= 0; i-- {
+		r[i]++
+		if r[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID writes the 128 bits of id as 26 base32 digits, the first
+// holding the top 3 bits.
+func encodeULID(id [16]byte) string {
+	var out [ULIDLen]byte
+	// Walk the bits from the least significant end, 5 at a time.
+	var acc uint32
+	bits := 0
+	pos := ULIDLen - 1
+	for i := len(id) - 1; i >= 0; i-- {
+		acc |= uint32(id[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			out[pos] = crockford[acc&31]
+			pos--
+			acc >>= 5
+			bits -= 5
+		}
+	}
+	out[0] = crockford[acc&31]
+	return string(out[:])
+}
+
+// Time is the time a ULID was made, to the millisecond, if id is one.
+func Time(id string) (time.Time, bool) {
+	if len(id) != ULIDLen {
+		return time.Time{}, false
+	}
+	var ms uint64
+	for i := 0; i < 10; i++ { // the first 10 digits are 50 bits, the top 2 zero
+		d := indexCrockford(id[i])
+		if d < 0 {
+			return time.Time{}, false
+		}
+		ms = ms<<5 | uint64(d)
+	}
+	return time.UnixMilli(int64(ms)), true
+}
+
+func indexCrockford(c byte) int {
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// Token returns a new secret token.
+func Token() string {
+	b := make([]byte, TokenBytes)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Code returns a new n-character code, 5n bits of entropy.
+func Code(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = crockford[b[i]&31] // 256 is a multiple of 32: no bias
+	}
+	return string(b)
+}
+
+// Equal compares two secrets in constant time (for equal lengths).
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,84 @@
+package ids_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-chatapp/ids"
+)
+
+const (
+	crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	base64URL = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// TestConcurrent generates IDs, tokens and codes from many goroutines at
+// once and checks that none repeats, that IDs from one goroutine sort in
+// the order they were made, and that every value has its documented
+// length and alphabet.
+func TestConcurrent(t *testing.T) {
+	workers, n := 16, 50000
+	if testing.Short() {
+		n = 5000
+	}
+	for _, kind := range []struct {
+		name string
+		gen  func() string
+		size int
+		abc  string
+	}{
+		{"ID", ids.New, ids.ULIDLen, crockford},
+		{"token", ids.Token, 43, base64URL},
+		{"code", func() string { return ids.Code(10) }, 10, crockford},
+	} {
+		t.Run(kind.name, func(t *testing.T) {
+			results := make([][]string, workers)
+			var wg sync.WaitGroup
+			for w := range results {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					out := make([]string, n)
+					for i := range out {
+						out[i] = kind.gen()
+					}
+					results[w] = out
+				}()
+			}
+			wg.Wait()
+
+			seen := make(map[string]bool, workers*n)
+			for w, out := range results {
+				for i, v := range out {
+					if len(v) != kind.size || strings.Trim(v, kind.abc) != "" {
+						t.Fatalf("%q: want %d characters of %q", v, kind.size, kind.abc)
+					}
+					if seen[v] {
+						t.Fatalf("%q generated twice", v)
+					}
+					seen[v] = true
+					if kind.name == "ID" && i > 0 && v <= out[i-1] {
+						t.Fatalf("worker %d: ID %q made after %q sorts before it", w, v, out[i-1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTime(t *testing.T) {
+	before := time.Now().Truncate(time.Millisecond)
+	id := ids.New()
+	at, ok := ids.Time(id)
+	if !ok || at.Before(before) || at.After(time.Now()) {
+		t.Errorf("Time(%q) = %v, %v; want about %v", id, at, ok, before)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !ids.Equal("s3cret", "s3cret") || ids.Equal("s3cret", "s3creT") || ids.Equal("s3cret", "s3cret!") {
+		t.Error("Equal compares wrongly")
+	}
+}
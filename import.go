@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+	"websocket-chatapp/username"
+)
+
+// Bulk imports, for moving history over from another chat system:
+//
+//	POST /api/import?workspace=acme
+//	POST /api/import?workspace=acme&dryRun=true
+//
+// read NDJSON, one protocol.ImportLine per line, and store each as a
+// message sent by its user at its time, in the public timeline, a room
+// (which must exist) or the DMs between user and peer. Imported messages
+// get new IDs and are never published: they show up in history, search
+// and exports, not as live messages. A line with an externalId imported
+// before (or earlier in the same import) is skipped as a duplicate, so an
+// import that stopped halfway can be sent again whole.
+//
+// History is ordered by when messages were stored, so a line older than
+// the newest message of its conversation is rejected rather than stored
+// out of order: lines must come oldest first, and a conversation should be
+// imported before anyone uses it. Lines are written importBatch at a time;
+// after each batch the answer gets a protocol.ImportProgress line, and the
+// last one has done (or error, if the import stopped early: a line over
+// maxImportLine or a Redis failure). Rejected lines don't stop it.
+// dryRun=true checks every line the same way, duplicates and order
+// included, and writes nothing. CHAT_HISTORY_MAX and
+// CHAT_HISTORY_RETENTION apply to imported history like any other.
+const (
+	importBatch     = 500
+	maxImportLine   = 64 * 1024
+	maxImportErrors = 100 // rejected lines reported; later ones are only counted
+	maxExternalID   = 128
+)
+
+func handleImportAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(q.Get("dryRun"))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	imp := &importer{
+		ctx:    ctx,
+		ws:     ws,
+		w:      w,
+		names:  map[string]string{},
+		rooms:  map[string]bool{},
+		newest: map[string]int64{},
+		seen:   map[string]bool{},
+	}
+	imp.progress.DryRun = dryRun
+	err := imp.run(bufio.NewScanner(r.Body))
+	p := imp.progress
+	p.Done, p.Errors = err == nil, imp.errors
+	if err != nil {
+		p.Error = err.Error()
+	}
+	imp.report(p)
+
+	by := "admin@" + clientIP(r)
+	summary := fmt.Sprintf("%d lines: %d imported, %d duplicates, %d rejected", p.Lines, p.Imported, p.Duplicates, p.Rejected)
+	switch {
+	case dryRun:
+		log.Printf("📥 Dry run of an import into workspace %q by %s: %s", ws, by, summary)
+	case err != nil:
+		log.Printf("⚠️ Import into workspace %q by %s stopped after %s: %v", ws, by, summary, err)
+	default:
+		log.Printf("📥 Import into workspace %q by %s: %s", ws, by, summary)
+	}
+	if !dryRun {
+		publishAdminEvent(protocol.AdminEvent{Event: "import", Workspace: string(ws), By: by, Message: summary, Reason: p.Error})
+	}
+}
+
+// importer is one import's state.
+type importer struct {
+	ctx context.Context
+	ws  workspace
+	w   http.ResponseWriter
+
+	names  map[string]string // registered spelling, by name as given
+	rooms  map[string]bool   // whether the room exists
+	newest map[string]int64  // time of the newest message, by history key
+	seen   map[string]bool   // external IDs imported (or to be) by this import
+
+	batch    []importEntry
+	progress protocol.ImportProgress
+	errors   []protocol.ImportError // rejected lines not reported yet
+	reported int                    // rejected lines reported so far
+	global   bool                   // the public timeline got messages
+}
+
+// importEntry is a line that passed the checks that need no Redis reads
+// of history.
+type importEntry struct {
+	line       int64
+	key        string
+	peer       string // for a DM
+	room       string
+	externalID string
+	msg        ChatMessage
+}
+
+// run reads the import, line by line, writing (and reporting) a batch
+// whenever one fills up.
+func (imp *importer) run(sc *bufio.Scanner) error {
+	sc.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	for sc.Scan() {
+		if err := imp.ctx.Err(); err != nil {
+			return err
+		}
+		imp.progress.Lines++
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		e, reason := imp.parse(imp.progress.Lines, sc.Bytes())
+		if reason != "" {
+			imp.reject(imp.progress.Lines, reason)
+			continue
+		}
+		imp.batch = append(imp.batch, e)
+		if len(imp.batch) == importBatch {
+			if err := imp.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d is over %d bytes", imp.progress.Lines+1, maxImportLine)
+		}
+		return err
+	}
+	if len(imp.batch) > 0 {
+		return imp.flush()
+	}
+	return nil
+}
+
+// parse checks one line, returning why it can't be imported if it can't.
+func (imp *importer) parse(n int64, data []byte) (importEntry, string) {
+	var line protocol.ImportLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return importEntry{}, "not a JSON import line"
+	}
+	if !validJoinName(line.User) {
+		return importEntry{}, "user is missing or not a valid name"
+	}
+	if strings.TrimSpace(line.Text) == "" || !utf8.ValidString(line.Text) {
+		return importEntry{}, "text is empty or not valid UTF-8"
+	}
+	if max := cfg().MaxMessageChars; max > 0 && utf8.RuneCountInString(line.Text) > max {
+		return importEntry{}, fmt.Sprintf("text is over %d characters", max)
+	}
+	if line.Time <= 0 || line.Time > time.Now().Unix() {
+		return importEntry{}, "time is missing or in the future"
+	}
+	if len(line.ExternalID) > maxExternalID {
+		return importEntry{}, fmt.Sprintf("externalId is over %d bytes", maxExternalID)
+	}
+
+	user := imp.resolve(line.User)
+	e := importEntry{line: n, externalID: line.ExternalID, msg: ChatMessage{
+		ID:   ids.New(),
+		User: user,
+		Text: line.Text,
+		Time: line.Time,
+		V:    messageSchemaVersion,
+	}}
+	if line.ExternalID != "" {
+		e.msg.Meta = map[string]string{"externalId": line.ExternalID}
+	}
+	switch {
+	case line.Room != "" && line.Peer != "":
+		return importEntry{}, "give a room or a peer, not both"
+	case line.Room != "":
+		exists, ok := imp.rooms[line.Room]
+		if !ok {
+			n, err := rdb.Exists(imp.ctx, imp.ws.roomMetaKey(line.Room)).Result()
+			if err != nil {
+				return importEntry{}, "could not look the room up; import this line again"
+			}
+			exists = n > 0
+			imp.rooms[line.Room] = exists
+		}
+		if !exists {
+			return importEntry{}, "no such room"
+		}
+		e.key, e.room = imp.ws.roomMessagesKey(line.Room), line.Room
+	case line.Peer != "":
+		if !validJoinName(line.Peer) {
+			return importEntry{}, "peer is not a valid name"
+		}
+		e.peer = imp.resolve(line.Peer)
+		if username.Canonical(e.peer) == username.Canonical(user) {
+			return importEntry{}, "peer is the user"
+		}
+		e.key = imp.ws.dmKey(user, e.peer)
+	default:
+		e.key = imp.ws.messagesKey()
+	}
+	return e, ""
+}
+
+// resolve returns name's registered spelling, registering name if it has
+// none (outside a dry run), so imported users can be found and written to.
+func (imp *importer) resolve(name string) string {
+	if resolved, ok := imp.names[name]; ok {
+		return resolved
+	}
+	resolved := resolveName(imp.ctx, imp.ws, name)
+	if !imp.progress.DryRun {
+		registerName(imp.ctx, imp.ws, resolved)
+	}
+	imp.names[name] = resolved
+	return resolved
+}
+
+// flush checks the batch against what is stored, writes what passes and
+// reports progress.
+func (imp *importer) flush() error {
+	batch := imp.batch
+	imp.batch = imp.batch[:0]
+
+	// One round trip for the batch's external IDs, and for the newest
+	// message of every conversation not seen yet.
+	pipe := rdb.Pipeline()
+	var external []string
+	for _, e := range batch {
+		if e.externalID != "" {
+			external = append(external, e.externalID)
+		}
+	}
+	var importedCmd *redis.SliceCmd
+	if len(external) > 0 {
+		importedCmd = pipe.HMGet(imp.ctx, imp.ws.importedKey(), external...)
+	}
+	newestCmds := map[string]*redis.ZSliceCmd{}
+	for _, e := range batch {
+		if _, ok := imp.newest[e.key]; ok || newestCmds[e.key] != nil {
+			continue
+		}
+		newestCmds[e.key] = pipe.ZRevRangeWithScores(imp.ctx, imp.ws.timeIndexKey(e.key), 0, 0)
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(imp.ctx); err != nil && err != redis.Nil {
+			return err
+		}
+	}
+	stored := map[string]bool{}
+	if importedCmd != nil {
+		for i, v := range importedCmd.Val() {
+			if v != nil {
+				stored[external[i]] = true
+			}
+		}
+	}
+	for key, cmd := range newestCmds {
+		imp.newest[key] = 0
+		if z := cmd.Val(); len(z) > 0 {
+			imp.newest[key] = int64(z[0].Score)
+		}
+	}
+
+	// Duplicates first, so importing a file again finds it all already
+	// there rather than out of order.
+	var accepted []importEntry
+	counts := map[string]int64{}
+	for _, e := range batch {
+		if e.externalID != "" && (stored[e.externalID] || imp.seen[e.externalID]) {
+			imp.progress.Duplicates++
+			continue
+		}
+		if e.msg.Time < imp.newest[e.key] {
+			imp.reject(e.line, "older than the newest message of its conversation")
+			continue
+		}
+		imp.newest[e.key] = e.msg.Time
+		if e.externalID != "" {
+			imp.seen[e.externalID] = true
+		}
+		accepted = append(accepted, e)
+		counts[e.key]++
+	}
+	if !imp.progress.DryRun && len(accepted) > 0 {
+		if err := imp.write(accepted, counts); err != nil {
+			return err
+		}
+	}
+	imp.progress.Imported += int64(len(accepted))
+	p := imp.progress
+	p.Errors, imp.errors = imp.errors, nil
+	return imp.report(p)
+}
+
+// write stores accepted, which has counts[key] entries for each history
+// key, in one transaction.
+func (imp *importer) write(accepted []importEntry, counts map[string]int64) error {
+	ctx, ws := imp.ctx, imp.ws
+	pipe := rdb.Pipeline()
+	seqCmds := map[string]*redis.IntCmd{}
+	for key, n := range counts {
+		seqCmds[key] = pipe.HIncrBy(ctx, ws.seqKey(), key, n)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	next := map[string]int64{}
+	for key, cmd := range seqCmds {
+		next[key] = cmd.Val() - counts[key] + 1
+		registerConversation(ctx, ws, key)
+	}
+
+	tx := rdb.TxPipeline()
+	rooms := map[string]int64{}
+	for _, e := range accepted {
+		jsonMsg, _ := json.Marshal(e.msg)
+		s := spilledMessage{ws: ws, key: e.key, id: e.msg.ID, time: e.msg.Time, seq: next[e.key], member: seal(jsonMsg), user: e.msg.User}
+		next[e.key]++
+		addOrdered(ctx, tx, ws, s.key, s.seq, s.time, s.member)
+		if e.peer != "" {
+			indexDM(ctx, tx, ws, e.msg.User, e.peer, s.time)
+		}
+		addFirehose(ctx, tx, &s)
+		if e.externalID != "" {
+			tx.HSet(ctx, ws.importedKey(), e.externalID, e.msg.ID)
+		}
+		if e.room != "" {
+			rooms[e.room] = s.time
+		}
+		if s.key == ws.messagesKey() {
+			imp.global = true
+		}
+	}
+	if max := cfg().HistoryMax; max > 0 {
+		for key := range counts {
+			trimOrdered(ctx, tx, ws, key, max)
+		}
+	}
+	if _, err := tx.Exec(ctx); err != nil {
+		return err
+	}
+	for room, t := range rooms {
+		touchRoomDirectory(ctx, ws, room, t)
+	}
+	if imp.global {
+		forgetRecentHistory(ws)
+	}
+	return nil
+}
+
+// reject counts line n as rejected for reason, keeping it for the next
+// progress line while fewer than maxImportErrors have been.
+func (imp *importer) reject(n int64, reason string) {
+	imp.progress.Rejected++
+	if imp.reported < maxImportErrors {
+		imp.reported++
+		imp.errors = append(imp.errors, protocol.ImportError{Line: n, Error: reason})
+	}
+}
+
+// report writes one progress line and flushes it to the client.
+func (imp *importer) report(p protocol.ImportProgress) error {
+	line, _ := json.Marshal(p)
+	if _, err := imp.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if f, ok := imp.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
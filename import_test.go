@@ -0,0 +1,222 @@
+package main_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+const (
+	importLines = 10000
+	importRoom  = "archive"
+	importPeer  = "archivist"
+)
+
+// importFixture is a generated import and what it should leave behind.
+type importFixture struct {
+	body       []byte
+	imported   int64
+	duplicates int64
+	rejected   int64
+	want       map[string][]string // texts by conversation, oldest first
+}
+
+// TestImport imports a generated 10000-line history into the public
+// timeline, a room and a DM conversation through POST /api/import. The
+// fixture has lines repeating an earlier externalId, lines that must be
+// rejected (a time in the future, a room that doesn't exist, a line older
+// than its conversation) and a blank line. A dry run must count everything
+// and write nothing; the import must count the same, deliver nothing to a
+// member who is online, and leave each conversation's export with exactly
+// the expected messages, oldest first, with their original times;
+// importing it again must find only duplicates.
+func TestImport(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_ADMIN_READ_DMS=true")
+
+	// The DM peer is online, in the room, and must see none of it.
+	watcher := dial(t, addr, "", importPeer)
+	watcher.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: importRoom})
+	await(t, watcher, protocol.TypeRoomJoined)
+	var delivered atomic.Int64
+	go func() {
+		for {
+			f, err := watcher.Read()
+			if err != nil {
+				return
+			}
+			if bytes.Contains(f.Raw, []byte("imported ")) {
+				delivered.Add(1)
+			}
+		}
+	}()
+
+	fx := generateImport()
+	p := postImport(t, addr, "/api/import?dryRun=true", fx.body)
+	checkImport(t, "dry run", p, fx, true)
+	for conversation := range fx.want {
+		if got := exportConversation(t, addr, conversation); len(got) != 0 {
+			t.Fatalf("the dry run wrote %d messages to %s", len(got), conversation)
+		}
+	}
+
+	p = postImport(t, addr, "/api/import", fx.body)
+	checkImport(t, "import", p, fx, false)
+	for conversation, want := range fx.want {
+		got := exportConversation(t, addr, conversation)
+		if len(got) != len(want) {
+			t.Fatalf("%s has %d messages, want %d", conversation, len(got), len(want))
+		}
+		var last int64
+		ids := map[string]bool{}
+		for i, m := range got {
+			if m.Text != want[i] {
+				t.Fatalf("%s message %d is %q, want %q", conversation, i, m.Text, want[i])
+			}
+			if m.Time < last {
+				t.Fatalf("%s message %d (time %d) is before the one above it (%d)", conversation, i, m.Time, last)
+			}
+			if m.ID == "" || ids[m.ID] || strings.HasPrefix(m.ID, "ext-") {
+				t.Fatalf("%s message %d has ID %q, want a new unique one", conversation, i, m.ID)
+			}
+			if m.Meta["externalId"] == "" {
+				t.Fatalf("%s message %d lost its externalId", conversation, i)
+			}
+			last, ids[m.ID] = m.Time, true
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if n := delivered.Load(); n > 0 {
+		t.Errorf("%d imported messages were delivered live", n)
+	}
+
+	p = postImport(t, addr, "/api/import", fx.body)
+	if p.Imported != 0 || p.Duplicates != fx.imported+fx.duplicates {
+		t.Errorf("importing again imported %d and found %d duplicates, want 0 and %d", p.Imported, p.Duplicates, fx.imported+fx.duplicates)
+	}
+}
+
+// generateImport builds the fixture: importLines messages a second apart,
+// starting a month ago, spread over the public timeline, the room and the
+// DMs between user<n> and the peer.
+func generateImport() importFixture {
+	fx := importFixture{want: map[string][]string{}}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	base := time.Now().Add(-30 * 24 * time.Hour).Unix()
+	for i := 0; i < importLines; i++ {
+		line := protocol.ImportLine{
+			User:       fmt.Sprintf("user%d", i%10),
+			Text:       fmt.Sprintf("imported %d", i),
+			Time:       base + int64(i),
+			ExternalID: fmt.Sprintf("ext-%d", i),
+		}
+		conversation := "global"
+		switch i % 4 {
+		case 2:
+			line.Room, conversation = importRoom, "room"
+		case 3:
+			line.Peer, conversation = importPeer, "dm:"+line.User
+		}
+		switch {
+		case i%100 == 99:
+			// Sent again: the same message, seen twice in the export.
+			line.ExternalID = fmt.Sprintf("ext-%d", i-4)
+			fx.duplicates++
+		case i%1000 == 500:
+			line.Time = time.Now().Add(time.Hour).Unix()
+			fx.rejected++
+		case i%1000 == 700:
+			line.Room, line.Peer = "nosuch", ""
+			fx.rejected++
+		case i == 5004:
+			line.Time = base // older than what its conversation has by then
+			fx.rejected++
+		default:
+			fx.want[conversation] = append(fx.want[conversation], line.Text)
+			fx.imported++
+		}
+		enc.Encode(line)
+		if i == 1234 {
+			body.WriteString("\n")
+		}
+	}
+	body.WriteString("not json\n")
+	fx.rejected++
+	fx.body = body.Bytes()
+	return fx
+}
+
+// checkImport checks the progress lines of one import against fx.
+func checkImport(t *testing.T, what string, p protocol.ImportProgress, fx importFixture, dryRun bool) {
+	t.Helper()
+	if !p.Done || p.Error != "" {
+		t.Fatalf("%s didn't finish: %+v", what, p)
+	}
+	if p.DryRun != dryRun || p.Lines != importLines+2 || p.Imported != fx.imported || p.Duplicates != fx.duplicates || p.Rejected != fx.rejected {
+		t.Fatalf("%s counted %d lines, %d imported, %d duplicates, %d rejected; want %d, %d, %d, %d",
+			what, p.Lines, p.Imported, p.Duplicates, p.Rejected, importLines+2, fx.imported, fx.duplicates, fx.rejected)
+	}
+}
+
+// postImport sends body to path and returns the last progress line, after
+// checking that there was one per batch and that the rejected lines were
+// all reported.
+func postImport(t *testing.T, addr, path string, body []byte) protocol.ImportProgress {
+	t.Helper()
+	code, data := api(t, addr, http.MethodPost, path, body)
+	if code != http.StatusOK {
+		t.Fatalf("POST %s got %d: %s", path, code, data)
+	}
+	var p protocol.ImportProgress
+	var progress, errors int64
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		p = protocol.ImportProgress{}
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			t.Fatalf("POST %s: bad progress line %q", path, sc.Text())
+		}
+		progress++
+		errors += int64(len(p.Errors))
+	}
+	if progress < 2 {
+		t.Fatalf("POST %s reported progress %d times: %+v", path, progress, p)
+	}
+	if errors != p.Rejected {
+		t.Fatalf("POST %s listed %d rejected lines, counted %d", path, errors, p.Rejected)
+	}
+	return p
+}
+
+// exportConversation returns the messages of one of the fixture's
+// conversations, as the export API streams them.
+func exportConversation(t *testing.T, addr, conversation string) []protocol.Message {
+	t.Helper()
+	query := ""
+	switch {
+	case conversation == "room":
+		query = "room=" + importRoom
+	case strings.HasPrefix(conversation, "dm:"):
+		query = "user=" + strings.TrimPrefix(conversation, "dm:") + "&peer=" + importPeer
+	}
+	code, data := api(t, addr, http.MethodGet, "/api/admin/export?"+query, nil)
+	if code != http.StatusOK {
+		t.Fatalf("exporting %s got %d", conversation, code)
+	}
+	var list []protocol.Message
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var m protocol.Message
+		json.Unmarshal(sc.Bytes(), &m)
+		list = append(list, m)
+	}
+	return list
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Event is one parsed inbound frame. The legacy prefix commands fill in the
+// named fields; JSON frames only carry their type and the raw frame, which
+// the handler for that type decodes itself.
+type Event struct {
+	Type string // "join", "msg", "dm", or the "type" of a JSON frame
+	Name string // join
+	From string // msg, dm
+	To   string // dm
+	Text string // msg, dm
+	// TempID, DisplayName, Format and Lang are set by the JSON forms of
+	// msg and dm; see handleSendFrame.
+	TempID      string
+	DisplayName string
+	Format      string
+	Lang        string
+	Data        json.RawMessage
+}
+
+var (
+	errBadFrame       = errors.New("invalid JSON frame")
+	errMalformed      = errors.New("malformed command")
+	errUnknownCommand = errors.New("unknown command")
+)
+
+// ParseInbound parses a frame received from a client. It never panics and
+// has no side effects, so it is safe to feed arbitrary input.
+//
+// Accepted forms:
+//
+//	{"type":"...", ...}
+//	join:<name>
+//	msg:<user>:<text>
+//	dm:<sender>:<receiver>:<text>
+func ParseInbound(data []byte) (Event, error) {
+	text := string(data)
+
+	if strings.HasPrefix(text, "{") {
+		var env struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &env); err != nil || env.Type == "" {
+			return Event{}, errBadFrame
+		}
+		return Event{Type: env.Type, Data: json.RawMessage(data)}, nil
+	}
+
+	if rest, ok := strings.CutPrefix(text, "join:"); ok {
+		name := strings.TrimSpace(rest)
+		if name == "" {
+			return Event{}, errMalformed
+		}
+		return Event{Type: "join", Name: name}, nil
+	}
+
+	if rest, ok := strings.CutPrefix(text, "dm:"); ok {
+		parts := strings.SplitN(rest, ":", 3)
+		if len(parts) < 3 {
+			return Event{}, errMalformed
+		}
+		return Event{Type: "dm", From: parts[0], To: parts[1], Text: parts[2]}, nil
+	}
+
+	if rest, ok := strings.CutPrefix(text, "msg:"); ok {
+		parts := strings.SplitN(rest, ":", 2)
+		if len(parts) < 2 {
+			return Event{}, errMalformed
+		}
+		return Event{Type: "msg", From: parts[0], Text: parts[1]}, nil
+	}
+
+	return Event{}, errUnknownCommand
+}
+
+// Encode renders the event back into wire form. For any event returned by
+// ParseInbound, ParseInbound(e.Encode()) yields the same event.
+func (e Event) Encode() []byte {
+	if e.Data != nil {
+		return e.Data
+	}
+	switch e.Type {
+	case "join":
+		return []byte("join:" + e.Name)
+	case "msg":
+		return []byte("msg:" + e.From + ":" + e.Text)
+	case "dm":
+		return []byte("dm:" + e.From + ":" + e.To + ":" + e.Text)
+	}
+	return nil
+}
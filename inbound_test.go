@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// FuzzParseInbound checks that ParseInbound takes any input without
+// panicking, fails only with its own errors, and that what it parses
+// encodes back to the same event.
+func FuzzParseInbound(f *testing.F) {
+	for _, seed := range []string{
+		"join:alice",
+		"join:  ",
+		"join:al:ice",
+		"msg:alice:hi",
+		"msg:alice:hi: there",
+		"msg:al:ice:hi",
+		"msg::",
+		"msg:",
+		"dm:alice:bob:hi",
+		"dm:a:l:i:c:e",
+		"dm:::",
+		"dm:alice:",
+		"",
+		"ping",
+		`{"type":"msg","text":"hi"}`,
+		`{"type":""}`,
+		`{"type":1}`,
+		`{"type":"msg"`,
+		"{",
+		"msg:\xff\xfe:\xc3\x28",
+		"dm:\xed\xa0\x80:bob:hi",
+		"join:\xff",
+		"{\"type\":\"\xff\"}",
+		"msg:alice:" + strings.Repeat("x", 1<<20),
+		"dm:" + strings.Repeat("a:", 1<<16),
+		`{"type":"msg","text":"` + strings.Repeat("y", 1<<20) + `"}`,
+		strings.Repeat("{", 1<<16),
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ev, err := ParseInbound(data)
+		if err != nil {
+			if err != errBadFrame && err != errMalformed && err != errUnknownCommand {
+				t.Fatalf("ParseInbound(%q) returned %v", data, err)
+			}
+			return
+		}
+		if ev.Type == "" {
+			t.Fatalf("ParseInbound(%q) returned an event with no type", data)
+		}
+		again, err := ParseInbound(ev.Encode())
+		if err != nil {
+			t.Fatalf("ParseInbound(%q) = %+v, which encodes to %q: %v", data, ev, ev.Encode(), err)
+		}
+		if !bytes.Equal(again.Data, ev.Data) || !reflect.DeepEqual(again, ev) {
+			t.Fatalf("ParseInbound(%q) = %+v, but its encoding parses to %+v", data, ev, again)
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/hookfmt"
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+	"websocket-chatapp/username"
+)
+
+// Incoming webhooks: a URL another service (GitHub, GitLab, a cron job)
+// POSTs to, each delivery posting one message to a room. An admin creates
+// one with
+//
+//	POST /api/admin/hooks {"workspace":"","room":"deploys","format":"github","name":"github"}
+//
+// and gets back its URL, /hooks/<token>, once. The token is the hook's
+// only credential, so only its SHA-256 is kept: chat:incoming_hooks (hash:
+// digest -> JSON registration). The format names a formatter in package
+// hookfmt, which condenses the delivery to one line; "text" (the default)
+// posts {"text":"..."} bodies as they are. Messages are posted as the
+// hook's name with kind and via "webhook" (see attribution.go), and go to
+// the room's watchers, the Kafka bridge and outgoing webhooks like any
+// other room message. The name may not be a member's, and a delivery's
+// displayName (a top-level body field or ?displayName=) neither.
+const (
+	maxIncomingHooks      = 50
+	maxIncomingHookBody   = 1 << 20
+	defaultIncomingFormat = "text"
+	incomingHookKind      = "webhook"
+)
+
+// incomingHook is a registration. Its token is never stored.
+type incomingHook struct {
+	ID        string `json:"id"`
+	Workspace string `json:"workspace"`
+	Room      string `json:"room"`
+	Format    string `json:"format"`
+	Name      string `json:"name"`
+	Created   int64  `json:"created"`
+}
+
+func hookTokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// listIncomingHooks returns ws's hooks, oldest first, with their digests.
+func listIncomingHooks(r *http.Request, ws workspace) (map[string]incomingHook, []incomingHook, error) {
+	all, err := rdb.HGetAll(r.Context(), incomingHooksKey()).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	byDigest := map[string]incomingHook{}
+	list := []incomingHook{}
+	for digest, raw := range all {
+		var h incomingHook
+		if json.Unmarshal([]byte(raw), &h) != nil || workspace(h.Workspace) != ws {
+			continue
+		}
+		byDigest[digest] = h
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created < list[j].Created })
+	return byDigest, list, nil
+}
+
+// GET /api/admin/hooks?workspace= lists the incoming webhooks; POST
+// {"workspace","room","format","name"} creates one and returns it with its
+// token and URL; DELETE ?workspace=&id= removes one.
+func handleIncomingHooksAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ws, ok := lookupWorkspace(ctx, r.URL.Query().Get("workspace"))
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		_, list, err := listIncomingHooks(r, ws)
+		if err != nil {
+			http.Error(w, "could not read the hooks", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"workspace": string(ws), "hooks": list, "formats": hookfmt.Names()})
+
+	case http.MethodPost:
+		var req struct {
+			Workspace string `json:"workspace"`
+			Room      string `json:"room"`
+			Format    string `json:"format"`
+			Name      string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validRoomName(req.Room) {
+			http.Error(w, "invalid hook; room is required", http.StatusBadRequest)
+			return
+		}
+		if req.Format == "" {
+			req.Format = defaultIncomingFormat
+		}
+		if _, ok := hookfmt.Lookup(req.Format); !ok {
+			http.Error(w, "format must be one of "+strings.Join(hookfmt.Names(), ", "), http.StatusBadRequest)
+			return
+		}
+		if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+			req.Name = req.Format
+			if req.Format == defaultIncomingFormat {
+				req.Name = incomingHookKind
+			}
+		}
+		ws, ok := lookupWorkspace(ctx, req.Workspace)
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		if n, _ := rdb.Exists(ctx, ws.roomMetaKey(req.Room)).Result(); n == 0 {
+			http.Error(w, "no such room", http.StatusNotFound)
+			return
+		}
+		if !validDisplayName(req.Name) {
+			http.Error(w, "name must be at most 64 characters", http.StatusBadRequest)
+			return
+		}
+		if isMemberName(ctx, ws, req.Name) {
+			http.Error(w, req.Name+" is a member's name", http.StatusConflict)
+			return
+		}
+		_, list, err := listIncomingHooks(r, ws)
+		if err != nil {
+			http.Error(w, "could not read the hooks", http.StatusInternalServerError)
+			return
+		}
+		if len(list) >= maxIncomingHooks {
+			http.Error(w, fmt.Sprintf("at most %d incoming webhooks per workspace", maxIncomingHooks), http.StatusConflict)
+			return
+		}
+
+		h := incomingHook{ID: ids.New(), Workspace: string(ws), Room: req.Room, Format: req.Format, Name: req.Name, Created: time.Now().Unix()}
+		token := ids.Token()
+		raw, _ := json.Marshal(h)
+		if err := rdb.HSet(ctx, incomingHooksKey(), hookTokenDigest(token), raw).Err(); err != nil {
+			http.Error(w, "could not create the hook", http.StatusInternalServerError)
+			return
+		}
+		publishAdminEvent(protocol.AdminEvent{Event: "hook_add", Workspace: string(ws), By: "admin@" + clientIP(r), Name: h.ID, Message: h.Format + " -> " + h.Room})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			incomingHook
+			Token string `json:"token"`
+			URL   string `json:"url"`
+		}{h, token, "/hooks/" + token})
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		byDigest, _, err := listIncomingHooks(r, ws)
+		if err != nil {
+			http.Error(w, "could not read the hooks", http.StatusInternalServerError)
+			return
+		}
+		for digest, h := range byDigest {
+			if h.ID == q.Get("id") {
+				rdb.HDel(ctx, incomingHooksKey(), digest)
+				publishAdminEvent(protocol.AdminEvent{Event: "hook_delete", Workspace: string(ws), By: "admin@" + clientIP(r), Name: h.ID})
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.Error(w, "no such hook", http.StatusNotFound)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// POST /hooks/<token>: one delivery, formatted and posted to the hook's
+// room. Answers 200 {"id":...} with the message's ID, or 204 when the
+// formatter had nothing to post, and 409 for a displayName that is a
+// member's name.
+func handleIncomingHook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/hooks/")
+	raw, err := rdb.HGet(ctx, incomingHooksKey(), hookTokenDigest(token)).Result()
+	if err == redis.Nil {
+		http.Error(w, "no such hook", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "could not read the hook", http.StatusInternalServerError)
+		return
+	}
+	var h incomingHook
+	if json.Unmarshal([]byte(raw), &h) != nil {
+		http.Error(w, "no such hook", http.StatusNotFound)
+		return
+	}
+	ws := workspace(h.Workspace)
+	if n, _ := rdb.Exists(ctx, ws.roomMetaKey(h.Room)).Result(); n == 0 {
+		http.Error(w, "the hook's room no longer exists", http.StatusGone)
+		return
+	}
+	format, ok := hookfmt.Lookup(h.Format)
+	if !ok {
+		http.Error(w, "the hook's format is not available", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIncomingHookBody))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	text, err := format(r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	displayName := r.URL.Query().Get("displayName")
+	if displayName == "" {
+		var override struct {
+			DisplayName string `json:"displayName"`
+		}
+		json.Unmarshal(body, &override)
+		displayName = override.DisplayName
+	}
+	if displayName != "" && username.Canonical(displayName) != username.Canonical(h.Name) {
+		if !validDisplayName(displayName) {
+			http.Error(w, "displayName must be 1 to 64 characters without surrounding spaces", http.StatusBadRequest)
+			return
+		}
+		if isMemberName(ctx, ws, displayName) {
+			http.Error(w, displayName+" is a member's name", http.StatusConflict)
+			return
+		}
+	} else {
+		displayName = ""
+	}
+	if text == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if max := cfg().MaxMessageChars; max > 0 && utf8.RuneCountInString(text) > max {
+		text = string([]rune(text)[:max-1]) + "…"
+	}
+
+	msg := newMessage(h.Name, text)
+	msg.Kind, msg.Via, msg.DisplayName = incomingHookKind, viaWebhook, displayName
+	msg.Meta = map[string]string{"hook": h.ID}
+	if !postRoomMessage(ctx, ws, h.Room, msg) {
+		http.Error(w, "message could not be stored; please retry", http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("🪝 %s hook %s posted to %q in workspace %q", h.Format, h.ID, h.Room, ws)
+	notifyWatchers(ctx, ws, h.Room, msg)
+	recordEvent(ws, chatEvent{Type: "room_message", User: h.Name, Room: h.Room, Len: len(msg.Text)})
+	bridgeMessage(ws, "room:"+h.Room, msg)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": msg.ID})
+}
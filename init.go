@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// sendInit sends the connect-time state. Members are capped at one page
+// (clients ask for more with members_page) and history is split into
+// chunks of at most cfg().InitHistoryChunk messages: the first rides in the
+// init frame, the rest follow as history_chunk frames, and init_done marks
+// the end. A small deployment gets exactly one init frame plus init_done.
+// Limits come from c.cfg, so workspaces can override them.
+//
+// The connection follows the timeline (and gets member events) before its
+// init state is read, so nothing broadcast meanwhile falls between the
+// history and the live stream. Broadcasts that reach it before init_done
+// are held (see holdFrames) and written after it, in the order they came,
+// leaving out the messages the history already had: a client sees every
+// message once, history then live, with no gap, however fast messages
+// arrive while it connects. Messages are matched by ID, as catch-up does
+// (see catchup.go), since a broadcast doesn't carry its sequence number.
+// Member events held this way may repeat what the member list already
+// says, which adding or removing a member again doesn't change.
+func sendInit(c *client) error {
+	state, err := loadInitState(c)
+	if err != nil {
+		return err
+	}
+	frame := protocol.NewInit(state.members, state.spectators, state.memberCount, state.chunks[0], serverNow())
+	frame.ReadOnly, frame.Protocol, frame.Version, frame.Roster = c.readOnly, c.protocol, protocolVersions[c.protocol], c.roster
+	frame.Pinned, frame.Notices = state.pinned, state.notices
+	frame.DMsRecorded = cfg().Firehose && cfg().FirehoseDMs
+	if err := writeNow(c, frame); err != nil {
+		return err
+	}
+	for _, chunk := range state.chunks[1:] {
+		if err := writeNow(c, protocol.NewHistoryChunk(chunk)); err != nil {
+			return err
+		}
+	}
+	if err := writeNow(c, protocol.NewInitDone()); err != nil {
+		return err
+	}
+	return releaseHeld(c, state.ids)
+}
+
+// holdFrames makes c's frames wait until sendInit releases them.
+func holdFrames(c *client) {
+	c.mu.Lock()
+	c.holding = true
+	c.mu.Unlock()
+}
+
+// writeNow writes an init frame ahead of the held ones.
+func writeNow(c *client, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(data)
+}
+
+// releaseHeld writes c's held frames but the messages in history, and
+// stops holding once none are left; frames that arrive meanwhile are held
+// and written after.
+func releaseHeld(c *client, history map[string]bool) error {
+	for {
+		c.mu.Lock()
+		held := c.held
+		c.held = nil
+		if len(held) == 0 {
+			c.holding = false
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+		for _, data := range held {
+			if id := heldMessageID(data); id != "" && history[id] {
+				continue
+			}
+			if err := c.write(data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// heldMessageID is the ID of the message data is, or "" for other frames,
+// which have a type.
+func heldMessageID(data []byte) string {
+	var msg struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+	if json.Unmarshal(data, &msg) != nil || msg.Type != "" {
+		return ""
+	}
+	return msg.ID
+}
+
+// Init pacing. When an instance restarts, every client reconnects at once
+// and each would run its own SMEMBERS and ZRANGE; a few thousand of those
+// together time Redis out. So at most CHAT_INIT_CONCURRENCY connections
+// compute init state at a time, and a computed state (serialized once) is
+// shared for CHAT_INIT_CACHE_TTL by every connection of the workspace with
+// the same limits. A public message or pin drops the workspace's states,
+// so history is never missing anything sent before the connection was
+// registered; the member list may be up to the TTL old, which member_add
+// and member_remove then correct.
+type initState struct {
+	members     []string
+	spectators  []string
+	memberCount int
+	chunks      []json.RawMessage
+	pinned      *ChatMessage
+	notices     []protocol.Notice
+	ids         map[string]bool // of the messages in history
+}
+
+type initCacheKey struct {
+	ws                           workspace
+	historyLimit, chunk, perPage int
+}
+
+type initCacheEntry struct {
+	ready   chan struct{} // closed once state is set
+	state   *initState
+	expires time.Time
+}
+
+// initTripsKey marks the context of an init state computation, whose
+// Redis round trips are counted in initRedisTrips.
+type initTripsKey struct{}
+
+var (
+	initRedisTrips atomic.Int64
+
+	initSlots   chan struct{}
+	initCacheMu sync.Mutex
+	initCache   = map[initCacheKey]*initCacheEntry{}
+	initsShared atomic.Int64
+)
+
+func startInitPacing() {
+	initSlots = make(chan struct{}, cfg().InitConcurrency)
+}
+
+// loadInitState returns a fresh enough cached state for c or computes one,
+// once for all connections asking meanwhile.
+func loadInitState(c *client) (*initState, error) {
+	key := initCacheKey{c.ws, c.cfg.HistoryLimit, c.cfg.InitHistoryChunk, c.cfg.InitMemberPage}
+	initCacheMu.Lock()
+	e := initCache[key]
+	if e != nil && (e.state == nil || time.Now().Before(e.expires)) {
+		initCacheMu.Unlock()
+		select {
+		case <-e.ready:
+			initsShared.Add(1)
+			return e.state, nil
+		case <-c.ctx.Done():
+			return nil, c.ctx.Err()
+		}
+	}
+	e = &initCacheEntry{ready: make(chan struct{})}
+	initCache[key] = e
+	initCacheMu.Unlock()
+
+	// Waiters depend on this computation, so it mustn't end with c.
+	select {
+	case initSlots <- struct{}{}:
+	case <-serverCtx.Done():
+	}
+	state := computeInitState(serverCtx, c)
+	<-initSlots
+
+	initCacheMu.Lock()
+	e.state = state
+	e.expires = time.Now().Add(cfg().InitCacheTTL)
+	initCacheMu.Unlock()
+	close(e.ready)
+	return state, nil
+}
+
+func computeInitState(ctx context.Context, c *client) *initState {
+	ctx = context.WithValue(ctx, initTripsKey{}, true)
+	members := onlineMembers(ctx, c.ws)
+
+	history := withPolls(ctx, c.ws, recentPublicHistory(ctx, c.ws, c.cfg.HistoryLimit))
+
+	page := pageOf(members, 0, c.cfg.InitMemberPage)
+	state := &initState{
+		members:     page,
+		spectators:  spectatorsIn(ctx, c.ws, page),
+		memberCount: len(members),
+		pinned:      pinnedMessage(ctx, c.ws),
+		notices:     workspaceNotices(ctx, c.ws),
+		ids:         make(map[string]bool, len(history)),
+	}
+	for _, m := range history {
+		state.ids[m.ID] = true
+	}
+	for _, chunk := range chunkMessages(history, c.cfg.InitHistoryChunk) {
+		data, _ := json.Marshal(chunk)
+		state.chunks = append(state.chunks, data)
+	}
+	return state
+}
+
+// dropInitStates forgets ws's cached states; computations under way finish
+// for the connections already waiting on them.
+func dropInitStates(ws workspace) {
+	initCacheMu.Lock()
+	for key := range initCache {
+		if key.ws == ws {
+			delete(initCache, key)
+		}
+	}
+	initCacheMu.Unlock()
+}
+
+// chunkMessages splits history into chunks of at most size messages. It
+// always returns at least one (possibly empty) chunk.
+func chunkMessages(history []ChatMessage, size int) [][]ChatMessage {
+	chunks := [][]ChatMessage{}
+	for len(history) > size {
+		chunks = append(chunks, history[:size])
+		history = history[size:]
+	}
+	return append(chunks, history)
+}
+
+func pageOf(list []string, offset, limit int) []string {
+	if offset < 0 || offset >= len(list) {
+		return []string{}
+	}
+	end := offset + limit
+	if end > len(list) {
+		end = len(list)
+	}
+	return list[offset:end]
+}
+
+func handleMembersPage(c *client, data []byte) {
+	ctx := c.ctx
+	var req protocol.MembersPageRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Offset < 0 {
+		sendError(c, "bad_frame", "invalid members_page frame")
+		return
+	}
+	if req.Limit <= 0 || req.Limit > c.cfg.InitMemberPage {
+		req.Limit = c.cfg.InitMemberPage
+	}
+
+	members := onlineMembers(ctx, c.ws)
+
+	page := pageOf(members, req.Offset, req.Limit)
+	c.writeJSON(protocol.NewMembersPage(req.Offset, page, spectatorsIn(ctx, c.ws, page), len(members)))
+}
@@ -0,0 +1,229 @@
+package main_test
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestInitDuringFlood checks that a connection made while messages are
+// flooding in sees them as one gapless stream: one user posts numbered
+// public messages as fast as the server takes them (keeping its inbound
+// queue busy but not full), and meanwhile a new user connects every few
+// milliseconds. Each must get no message before its init frame, and then,
+// history (init and history_chunk frames) followed by live messages, every
+// number from its first to the last exactly once and in order.
+func TestInitDuringFlood(t *testing.T) {
+	if testing.Short() {
+		t.Skip("floods the server for several seconds")
+	}
+	const (
+		messages = 2000
+		readers  = 40
+		inFlight = 32
+	)
+	addr := startServer(t, "", "CHAT_HISTORY_LIMIT=200", "CHAT_INIT_HISTORY_CHUNK=7")
+	url := "ws://" + addr + "/ws"
+
+	flooder := dial(t, addr, "", "flooder")
+	// At most inFlight messages not yet back, so they fit the flooder's
+	// inbound queue (see inqueue.go) rather than being refused.
+	window := make(chan struct{}, inFlight)
+	go func() {
+		for {
+			f, err := flooder.Read()
+			if err != nil {
+				return
+			}
+			if f.Type == "message" && strings.HasPrefix(f.Message.Text, "flood ") {
+				<-window
+			}
+		}
+	}()
+	go func() {
+		for i := 1; i <= messages; i++ {
+			window <- struct{}{}
+			flooder.Send("flooder", "flood "+strconv.Itoa(i))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			readFlood(t, url, name, messages)
+		}("reader" + strconv.Itoa(i))
+		time.Sleep(40 * time.Millisecond)
+	}
+	wg.Wait()
+}
+
+// readFlood connects name and checks what it sees up to the last of the
+// flood's messages.
+func readFlood(t *testing.T, url, name string, last int) {
+	c, err := client.Dial(url)
+	if err != nil {
+		t.Errorf("%s: dial: %v", name, err)
+		return
+	}
+	defer c.Close()
+	stuck := time.AfterFunc(30*time.Second, func() { c.Close() })
+	defer stuck.Stop()
+	c.Join(name)
+	var seen []int
+	inited := false
+	for {
+		f, err := c.Read()
+		if err != nil {
+			t.Errorf("%s: read: %v (%d messages in)", name, err, len(seen))
+			return
+		}
+		var batch []protocol.Message
+		switch f.Type {
+		case protocol.TypeInit, protocol.TypeHistoryChunk:
+			inited = true
+			var frame struct {
+				History []protocol.Message `json:"history"`
+			}
+			json.Unmarshal(f.Raw, &frame)
+			batch = frame.History
+		case "message":
+			if !inited {
+				t.Errorf("%s: got %q before init", name, f.Message.Text)
+				return
+			}
+			batch = []protocol.Message{*f.Message}
+		}
+		for _, m := range batch {
+			n, err := strconv.Atoi(strings.TrimPrefix(m.Text, "flood "))
+			if err != nil {
+				continue
+			}
+			if len(seen) > 0 && n != seen[len(seen)-1]+1 {
+				t.Errorf("%s: got %d after %d (%d messages in)", name, n, seen[len(seen)-1], len(seen))
+				return
+			}
+			seen = append(seen, n)
+			if n == last {
+				return
+			}
+		}
+	}
+}
+
+// TestInitChunkBoundaries checks how the init history is split with
+// CHAT_INIT_HISTORY_CHUNK=3: no message, exactly one chunk's worth, one
+// more, and several chunks each arrive whole, in order, in as few frames
+// as fit, followed by init_done.
+func TestInitChunkBoundaries(t *testing.T) {
+	const chunk = 3
+	for _, n := range []int{0, 1, chunk, chunk + 1, 2 * chunk, 2*chunk + 1} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			addr := startServer(t, "", "CHAT_INIT_HISTORY_CHUNK="+strconv.Itoa(chunk))
+			poster := dial(t, addr, "", "poster")
+			for i := 1; i <= n; i++ {
+				poster.Send("poster", "m"+strconv.Itoa(i))
+				await(t, poster, "message")
+			}
+
+			c, err := client.Dial("ws://" + addr + "/ws")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.Join("reader")
+			var sizes []int
+			var texts []string
+			for {
+				f := await(t, c, protocol.TypeInit, protocol.TypeHistoryChunk, protocol.TypeInitDone)
+				if f.Type == protocol.TypeInitDone {
+					break
+				}
+				var frame struct {
+					History []protocol.Message `json:"history"`
+				}
+				decode(t, f, &frame)
+				if (f.Type == protocol.TypeInit) != (len(sizes) == 0) {
+					t.Fatalf("got %s as frame %d", f.Type, len(sizes)+1)
+				}
+				sizes = append(sizes, len(frame.History))
+				for _, m := range frame.History {
+					texts = append(texts, m.Text)
+				}
+			}
+
+			frames := max(1, (n+chunk-1)/chunk)
+			if len(sizes) != frames {
+				t.Errorf("history came in %d frames (%v), want %d", len(sizes), sizes, frames)
+			}
+			for _, size := range sizes {
+				if size > chunk {
+					t.Errorf("a frame has %d messages, over the chunk size (%v)", size, sizes)
+				}
+			}
+			want := []string{}
+			for i := 1; i <= n; i++ {
+				want = append(want, "m"+strconv.Itoa(i))
+			}
+			if strings.Join(texts, ",") != strings.Join(want, ",") {
+				t.Errorf("history is %v, want %v", texts, want)
+			}
+		})
+	}
+}
+
+// TestMembersPages checks that walking members_page lists every online
+// member once, in order, in pages of at most CHAT_INIT_MEMBER_PAGE
+// whatever limit is asked for, and an empty one past the end.
+func TestMembersPages(t *testing.T) {
+	const page = 2
+	addr := startServer(t, "", "CHAT_INIT_MEMBER_PAGE="+strconv.Itoa(page))
+	var want []string
+	for _, name := range []string{"erin", "alice", "dave", "carol", "bob"} {
+		sessionID(t, dial(t, addr, "", name)) // until the join is handled
+		want = append(want, name)
+	}
+	slices.Sort(want)
+
+	reader := dial(t, addr, "", "zed")
+	want = append(want, "zed")
+	var counted protocol.MembersPage
+	for deadline := time.Now().Add(5 * time.Second); counted.MemberCount != len(want); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("members_page counts %d members, want %d", counted.MemberCount, len(want))
+		}
+		reader.SendFrame(protocol.MembersPageRequest{Type: protocol.TypeMembersPage})
+		decode(t, await(t, reader, protocol.TypeMembersPage), &counted)
+	}
+
+	var got []string
+	for offset := 0; ; offset += page {
+		reader.SendFrame(protocol.MembersPageRequest{Type: protocol.TypeMembersPage, Offset: offset, Limit: 100})
+		var p protocol.MembersPage
+		decode(t, await(t, reader, protocol.TypeMembersPage), &p)
+		if p.Offset != offset || p.MemberCount != len(want) {
+			t.Fatalf("page at %d says offset %d of %d members", offset, p.Offset, p.MemberCount)
+		}
+		if len(p.Members) > page {
+			t.Fatalf("page at %d has %d members, over the page size", offset, len(p.Members))
+		}
+		if len(p.Members) == 0 {
+			if offset < len(want) {
+				t.Fatalf("page at %d is empty with %d members", offset, len(want))
+			}
+			break
+		}
+		got = append(got, p.Members...)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("the pages list %v, want %v", got, want)
+	}
+}
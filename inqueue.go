@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-chatapp/metrics"
+)
+
+// Inbound queues. A connection's read loop only reads and parses frames;
+// handling them (storing a message is a ZADD and a PUBLISH, and Redis may
+// be slow) is left to CHAT_INBOUND_WORKERS workers shared by every
+// connection. Each connection queues its frames, up to CHAT_INBOUND_QUEUE;
+// a connection with frames waiting is on the ready list once, and a worker
+// takes it from the front, handles one frame and puts it at the back if
+// it has more. So connections take turns: a client sending as fast as it
+// can gets one frame handled per round like everyone else, and waits on
+// its own queue, not theirs. Its frames are still handled one at a time,
+// in order. A frame that finds its connection's queue full is refused with
+// queue_full, to that client alone.
+//
+// When the client closes, what it sent before is still handled (for up to
+// CHAT_WRITE_TIMEOUT) before the connection is torn down. Frames of a
+// connection the server closed are dropped.
+
+// inboundFrame is a parsed frame waiting for a worker.
+type inboundFrame struct {
+	ev   Event
+	read time.Time
+}
+
+var (
+	readyMu   sync.Mutex
+	readyCond = sync.NewCond(&readyMu)
+	ready     []*client // connections with frames waiting, in turn order
+
+	inboundQueued   atomic.Int64 // frames waiting, all connections
+	inboundRejected atomic.Int64 // frames refused with queue_full
+
+	inboundWaitHistogram = metrics.NewHistogram("chat_inbound_wait_seconds",
+		"Time a frame waited in its connection's queue for a worker.",
+		[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5})
+	inboundHandleHistogram = metrics.NewHistogram("chat_inbound_handle_seconds",
+		"Time a worker spent handling one frame.",
+		[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5})
+)
+
+// startInboundWorkers starts the workers that handle every connection's
+// frames.
+func startInboundWorkers() {
+	for i := 0; i < cfg().InboundWorkers; i++ {
+		go runInboundWorker()
+	}
+}
+
+// enqueueFrame queues ev for c, refusing it if c's queue is full.
+func enqueueFrame(c *client, ev Event) {
+	c.inMu.Lock()
+	if len(c.inbox) >= cfg().InboundQueue {
+		c.inMu.Unlock()
+		inboundRejected.Add(1)
+		sendError(c, "queue_full", fmt.Sprintf("%d frames are already waiting; slow down and send this one again", cfg().InboundQueue), "max", fmt.Sprint(cfg().InboundQueue))
+		return
+	}
+	c.inbox = append(c.inbox, inboundFrame{ev: ev, read: time.Now()})
+	c.inflight.Add(1)
+	inboundQueued.Add(1)
+	schedule := !c.scheduled
+	c.scheduled = true
+	c.inMu.Unlock()
+	if schedule {
+		makeReady(c)
+	}
+}
+
+// makeReady puts c at the back of the ready list.
+func makeReady(c *client) {
+	readyMu.Lock()
+	ready = append(ready, c)
+	readyMu.Unlock()
+	readyCond.Signal()
+}
+
+func runInboundWorker() {
+	for {
+		readyMu.Lock()
+		for len(ready) == 0 {
+			readyCond.Wait()
+		}
+		c := ready[0]
+		ready[0] = nil
+		ready = ready[1:]
+		readyMu.Unlock()
+
+		c.inMu.Lock()
+		f := c.inbox[0]
+		c.inbox = c.inbox[1:]
+		c.inMu.Unlock()
+		inboundQueued.Add(-1)
+
+		handleQueued(c, f)
+
+		c.inMu.Lock()
+		more := len(c.inbox) > 0
+		c.scheduled = more
+		c.inMu.Unlock()
+		c.inflight.Done()
+		if more {
+			makeReady(c)
+		}
+	}
+}
+
+// handleQueued handles one of c's frames, unless the server closed c.
+func handleQueued(c *client, f inboundFrame) {
+	if c.ctx.Err() != nil {
+		return
+	}
+	start := time.Now()
+	inboundWaitHistogram.Observe(start.Sub(f.read).Seconds())
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("❌ Handling a %s frame panicked: %v\n%s", f.ev.Type, p, debug.Stack())
+			sendError(c, "internal", "the server failed handling your last frame")
+		}
+		inboundHandleHistogram.Observe(time.Since(start).Seconds())
+		c.recordInbound(float64(time.Since(f.read).Microseconds()) / 1000)
+	}()
+
+	// JSON frames: {"type":"...", ...}
+	if f.ev.Data != nil {
+		handleFrame(c, f.ev)
+	} else {
+		handleLegacyFrame(c, f.ev)
+	}
+	if c.timedOut.Swap(false) {
+		sendError(c, "timeout", "the server timed out handling your last frame; it may not have taken effect")
+	}
+	touchSession(c)
+}
+
+// waitInbound waits until c's queued frames are handled, or for at most
+// d.
+func waitInbound(c *client, d time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+	}
+}
+
+func (c *client) recordInbound(ms float64) {
+	c.inMu.Lock()
+	defer c.inMu.Unlock()
+	if c.inboundMs == 0 {
+		c.inboundMs = ms
+		return
+	}
+	c.inboundMs += rttSmoothing * (ms - c.inboundMs)
+}
+
+// inboundStats returns how many of c's frames wait and the rolling
+// average of their read-to-handled time.
+func (c *client) inboundStats() (queued int, ms float64) {
+	c.inMu.Lock()
+	defer c.inMu.Unlock()
+	return len(c.inbox), c.inboundMs
+}
+
+// longestInboundQueue is the most frames any one connection here has
+// waiting.
+func longestInboundQueue() int {
+	longest := 0
+	for _, c := range connectedClients() {
+		if n, _ := c.inboundStats(); n > longest {
+			longest = n
+		}
+	}
+	return longest
+}
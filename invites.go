@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// Invite-only mode, for a closed beta. With CHAT_INVITE_ONLY on, join:
+// needs an invite code, given when connecting (/ws?invite=<code>), unless
+// the name is already on the workspace's allow-list or the connection is
+// an admin one. An admin connection generates codes:
+//
+//	{"type":"gen_invites","count":20,"ttl":"7d"}
+//
+// Codes are kept in chat:invites (hash: code -> protocol.Invite JSON). A
+// code is used once: redeeming it claims it in chat:invites:used (code ->
+// name) with HSETNX, so of two joins with one code exactly one gets in.
+// The name then goes on the allow-list, chat:invites:allowed, and joins
+// with it need no code from then on. GET /api/admin/invites lists the
+// outstanding and used codes and DELETE revokes an unused one. Expired
+// codes are dropped when the list is read. With the mode off, codes are
+// ignored and joins work as before.
+const (
+	maxInvitesPerRequest = 100
+	defaultInviteTTL     = 7 * 24 * time.Hour
+	maxInviteTTL         = 90 * 24 * time.Hour
+)
+
+var (
+	errUnknownInvite = errors.New("unknown invite code")
+	errInviteExpired = errors.New("this invite code has expired")
+	errInviteUsed    = errors.New("this invite code has already been used")
+)
+
+// inviteCodeLen is the length of an invite code: 50 bits, easy to type.
+const inviteCodeLen = 10
+
+// normalizeInviteCode accepts codes as typed, in any case, reading the
+// letters Crockford's alphabet leaves out as the digits they look like.
+func normalizeInviteCode(code string) string {
+	return inviteCodeReplacer.Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+var inviteCodeReplacer = strings.NewReplacer("O", "0", "I", "1", "L", "1")
+
+// parseInviteTTL reads a Go duration, or a number of days ("7d").
+func parseInviteTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultInviteTTL, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 || d > maxInviteTTL {
+		return 0, fmt.Errorf("ttl must be positive and at most %s", maxInviteTTL)
+	}
+	return d, nil
+}
+
+// generateInvites stores count new codes valid for ttl.
+func generateInvites(ctx context.Context, ws workspace, count int, ttl time.Duration, by string) ([]protocol.Invite, error) {
+	now := time.Now().Unix()
+	invites := make([]protocol.Invite, count)
+	fields := make(map[string]interface{}, count)
+	for i := range invites {
+		invites[i] = protocol.Invite{Code: ids.Code(inviteCodeLen), By: by, Created: now, Expires: now + int64(ttl/time.Second)}
+		raw, _ := json.Marshal(invites[i])
+		fields[invites[i].Code] = raw
+	}
+	if err := rdb.HSet(ctx, ws.invitesKey(), fields).Err(); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// redeemInvite uses code up for name and puts name on the allow-list.
+func redeemInvite(ctx context.Context, ws workspace, code, name string) error {
+	raw, err := rdb.HGet(ctx, ws.invitesKey(), code).Result()
+	if err == redis.Nil {
+		return errUnknownInvite
+	}
+	if err != nil {
+		return err
+	}
+	var inv protocol.Invite
+	if json.Unmarshal([]byte(raw), &inv) != nil {
+		return errUnknownInvite
+	}
+	if inv.Expires <= time.Now().Unix() {
+		return errInviteExpired
+	}
+	ok, err := rdb.HSetNX(ctx, ws.inviteUsesKey(), code, name).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errInviteUsed
+	}
+	if err := rdb.SAdd(ctx, ws.invitedKey(), name).Err(); err != nil {
+		// Give the code back rather than lose it.
+		rdb.HDel(ctx, ws.inviteUsesKey(), code)
+		return err
+	}
+	inv.UsedBy, inv.UsedAt = name, time.Now().Unix()
+	updated, _ := json.Marshal(inv)
+	rdb.HSet(ctx, ws.invitesKey(), code, updated)
+	log.Printf("🎟 %q joined workspace %q with invite %s", name, ws, code)
+	return nil
+}
+
+// admitInvited reports whether name may join on c, redeeming the code c
+// connected with if it has to. It sends the error itself.
+func admitInvited(c *client, name string) bool {
+	if !cfg().InviteOnly || c.admin {
+		return true
+	}
+	if ok, _ := rdb.SIsMember(c.ctx, c.ws.invitedKey(), name).Result(); ok {
+		return true
+	}
+	if c.invite == "" {
+		sendError(c, "invite_required", "this server is invite-only; connect with ?invite=<code>")
+		return false
+	}
+	err := redeemInvite(c.ctx, c.ws, c.invite, name)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errUnknownInvite), errors.Is(err, errInviteExpired), errors.Is(err, errInviteUsed):
+		sendError(c, "invalid_invite", err.Error())
+	default:
+		sendError(c, "internal", "could not check the invite code; please retry")
+	}
+	return false
+}
+
+// A connection to an invite-only server is held back until it is
+// admitted, by joining (with a code or a name on the allow-list) or by
+// becoming an admin connection: until then it gets no init, no history
+// and no broadcasts, and may only send admissionFrames. Connections made
+// while the mode was off stay admitted when it is turned on.
+var admissionFrames = map[string]bool{
+	"join":                 true,
+	protocol.TypeHello:     true,
+	protocol.TypeAdminAuth: true,
+	protocol.TypePong:      true,
+}
+
+// holdBack marks c as not admitted yet.
+func holdBack(c *client) {
+	c.mu.Lock()
+	c.pending = true
+	c.mu.Unlock()
+}
+
+// admitted reports whether c may get the timeline and broadcasts.
+func (c *client) admitted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.pending
+}
+
+// admit lets c in if it was held back: it follows the timeline from now
+// on and gets its init state, as connections to other servers do on
+// connecting. Frames written to it meanwhile follow init_done.
+func admit(c *client) {
+	c.mu.Lock()
+	if !c.pending {
+		c.mu.Unlock()
+		return
+	}
+	c.pending, c.holding = false, true
+	c.mu.Unlock()
+	followTimeline(c)
+	sendInit(c)
+}
+
+// rejectUnadmitted sends an invite_required error if c isn't admitted yet
+// and typ isn't one of admissionFrames.
+func rejectUnadmitted(c *client, typ string) bool {
+	if admissionFrames[typ] || c.admitted() {
+		return false
+	}
+	sendError(c, "invite_required", "this server is invite-only; join first")
+	return true
+}
+
+// listInvites returns ws's outstanding and used codes, newest first,
+// deleting expired unused ones.
+func listInvites(ctx context.Context, ws workspace) (outstanding, used []protocol.Invite, err error) {
+	all, err := rdb.HGetAll(ctx, ws.invitesKey()).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	uses, err := rdb.HGetAll(ctx, ws.inviteUsesKey()).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().Unix()
+	outstanding, used = []protocol.Invite{}, []protocol.Invite{}
+	for code, raw := range all {
+		var inv protocol.Invite
+		if json.Unmarshal([]byte(raw), &inv) != nil {
+			continue
+		}
+		if name, ok := uses[code]; ok {
+			inv.UsedBy = name // in case the record wasn't updated
+			used = append(used, inv)
+			continue
+		}
+		if inv.Expires <= now {
+			rdb.HDel(ctx, ws.invitesKey(), code)
+			continue
+		}
+		outstanding = append(outstanding, inv)
+	}
+	for _, list := range [][]protocol.Invite{outstanding, used} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Created != list[j].Created {
+				return list[i].Created > list[j].Created
+			}
+			return list[i].Code < list[j].Code
+		})
+	}
+	return outstanding, used, nil
+}
+
+// {"type":"gen_invites","count":20,"ttl":"7d"}, from an admin connection.
+func handleGenInvites(c *client, data []byte) {
+	if !c.admin {
+		sendError(c, "forbidden", "gen_invites needs an admin connection")
+		return
+	}
+	var req protocol.GenInvitesRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Count < 1 || req.Count > maxInvitesPerRequest {
+		sendError(c, "bad_frame", fmt.Sprintf("invalid gen_invites frame; count must be 1 to %d", maxInvitesPerRequest))
+		return
+	}
+	ttl, err := parseInviteTTL(req.TTL)
+	if err != nil {
+		sendError(c, "bad_frame", "invalid ttl: "+err.Error())
+		return
+	}
+	invites, err := generateInvites(c.ctx, c.ws, req.Count, ttl, adminName(c))
+	if err != nil {
+		sendError(c, "not_stored", "invite codes could not be stored; please retry")
+		return
+	}
+	c.writeJSON(protocol.NewGenInvites(invites))
+	publishAdminEvent(protocol.AdminEvent{Event: "gen_invites", Workspace: string(c.ws), By: adminName(c), Message: strconv.Itoa(req.Count) + " invite(s) valid for " + ttl.String()})
+}
+
+// GET /api/admin/invites?workspace= lists the codes:
+// {"outstanding":[...],"used":[...]}. DELETE ?workspace=&code= revokes an
+// unused one.
+func handleInvitesAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		outstanding, used, err := listInvites(ctx, ws)
+		if err != nil {
+			http.Error(w, "could not read the invites", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"workspace": string(ws), "outstanding": outstanding, "used": used})
+
+	case http.MethodDelete:
+		code := normalizeInviteCode(q.Get("code"))
+		if used, _ := rdb.HExists(ctx, ws.inviteUsesKey(), code).Result(); used {
+			http.Error(w, "the code has already been used", http.StatusConflict)
+			return
+		}
+		if n, _ := rdb.HDel(ctx, ws.invitesKey(), code).Result(); n == 0 {
+			http.Error(w, "no such invite code", http.StatusNotFound)
+			return
+		}
+		publishAdminEvent(protocol.AdminEvent{Event: "revoke_invite", Workspace: string(ws), By: "admin@" + clientIP(r), Name: code})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,73 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestInviteOnlyHoldsBack checks that, on an invite-only server, a
+// connection that hasn't been admitted gets no init, history or
+// broadcasts, and can send nothing but a join; and that joining with a
+// code admits it.
+func TestInviteOnlyHoldsBack(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_INVITE_ONLY=true")
+	admin := adminConn(t, addr, "admin")
+	admin.Send("admin", "before anyone got in")
+	await(t, admin, "message")
+
+	anon, err := client.Dial("ws://" + addr + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { anon.Close() })
+	anon.SendFrame(protocol.MembersPageRequest{Type: protocol.TypeMembersPage, Limit: 10})
+	refused(t, anon, "invite_required")
+	anon.Join("eve")
+	refused(t, anon, "invite_required")
+
+	admin.SendFrame(protocol.GenInvitesRequest{Type: protocol.TypeGenInvites, Count: 1})
+	var invites protocol.GenInvites
+	decode(t, await(t, admin, protocol.TypeGenInvites), &invites)
+	alice, err := client.Dial("ws://" + addr + "/ws?invite=" + invites.Invites[0].Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { alice.Close() })
+	alice.Join("alice")
+	var init protocol.Init
+	decode(t, await(t, alice, protocol.TypeInit), &init)
+	if len(init.History) < 10 {
+		t.Errorf("alice's init has no history: %s", init.History)
+	}
+	await(t, alice, protocol.TypeJoined)
+	alice.Send("alice", "now I'm in")
+	await(t, alice, "message")
+
+	// By now anon would have had anything sent to it.
+	frames := make(chan client.Frame, 100)
+	go func() {
+		for {
+			f, err := anon.Read()
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- f
+		}
+	}()
+	anon.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello})
+	for {
+		select {
+		case f := <-frames:
+			if f.Type == protocol.TypeHello {
+				return
+			}
+			t.Errorf("a connection that wasn't admitted got %s", f.Raw)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no hello frame")
+		}
+	}
+}
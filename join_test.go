@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"slices"
+	"testing"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestDoubleJoin has connections join a second time, under another name
+// or the same one, after the first join and right behind it. The second
+// join must be refused with already_joined and change nothing: the member
+// list and DMs stay the first name's, and once the connections close
+// neither name is left online.
+func TestDoubleJoin(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_PRESENCE_GRACE=0")
+	erin := dial(t, addr, "", "erin")
+
+	alice := dial(t, addr, "", "alice")
+	for _, name := range []string{"bob", "alice"} {
+		alice.Join(name)
+		refused(t, alice, "already_joined")
+	}
+
+	// The second join is sent before the first is answered.
+	carol, err := client.Dial("ws://" + addr + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { carol.Close() })
+	carol.Join("carol")
+	carol.Join("dave")
+	var joined protocol.Joined
+	decode(t, await(t, carol, protocol.TypeJoined), &joined)
+	if joined.Name != "carol" {
+		t.Errorf("joined as %q, want carol", joined.Name)
+	}
+	refused(t, carol, "already_joined")
+
+	members := memberNames(t, erin)
+	slices.Sort(members)
+	if want := []string{"alice", "carol", "erin"}; !slices.Equal(members, want) {
+		t.Errorf("members %v, want %v", members, want)
+	}
+	for _, name := range []string{"bob", "dave"} {
+		if searchOnline(t, addr, name) {
+			t.Errorf("%s is online", name)
+		}
+	}
+
+	erin.SendDM("erin", "bob", "for bob")
+	erin.SendDM("erin", "alice", "for alice")
+	if got := awaitText(t, alice); got != "for alice" {
+		t.Errorf("alice's connection got %q, want her own DM", got)
+	}
+
+	alice.Close()
+	carol.Close()
+	awaitOffline(t, addr, "alice", "bob", "carol", "dave")
+	if members := memberNames(t, erin); !slices.Equal(members, []string{"erin"}) {
+		t.Errorf("members %v after the connections closed, want only erin", members)
+	}
+}
@@ -0,0 +1,81 @@
+// Package kafka produces the chat server's records to one Kafka topic. It
+// wraps a franz-go client (github.com/twmb/franz-go/pkg/kgo) behind the
+// small interface the bridge needs: batches in, the records that could not
+// be written out, so the caller owns retries and the dead-letter log.
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// deliveryTimeout bounds how long one Produce waits for its records,
+// including the client's own retries, before returning them as failed.
+const deliveryTimeout = 10 * time.Second
+
+// Record is one message; records with the same key go to the same
+// partition (hashed as the Java client does), so their order is preserved.
+type Record struct {
+	Key   []byte
+	Value []byte
+}
+
+// Producer sends records to one topic with acks from all in-sync replicas.
+// It is safe for concurrent use.
+type Producer struct {
+	client *kgo.Client
+}
+
+// NewProducer makes a producer for topic. It connects lazily: an error
+// means the settings are unusable, not that the brokers are down.
+func NewProducer(brokers []string, topic, clientID string) (*Producer, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ClientID(clientID),
+		kgo.DefaultProduceTopic(topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.RecordDeliveryTimeout(deliveryTimeout),
+		// The bridge batches and lingers itself.
+		kgo.ProducerLinger(0),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Producer{client: client}, nil
+}
+
+// Produce sends records and returns the ones that could not be written,
+// in order, with the first error. Retrying just the failed ones keeps
+// per-key order as long as the caller does not send newer records for
+// those keys in between.
+func (p *Producer) Produce(records []Record) (failed []Record, err error) {
+	batch := make([]*kgo.Record, len(records))
+	index := make(map[*kgo.Record]int, len(records))
+	for i, r := range records {
+		batch[i] = &kgo.Record{Key: r.Key, Value: r.Value}
+		index[batch[i]] = i
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout+time.Second)
+	defer cancel()
+	// Results come in the order the records were finished, not sent.
+	errs := make([]error, len(records))
+	for _, res := range p.client.ProduceSync(ctx, batch...) {
+		errs[index[res.Record]] = res.Err
+	}
+	for i, e := range errs {
+		if e != nil {
+			failed = append(failed, records[i])
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	return failed, err
+}
+
+// Close flushes nothing and closes every broker connection.
+func (p *Producer) Close() {
+	p.client.Close()
+}
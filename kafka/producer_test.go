@@ -0,0 +1,126 @@
+package kafka_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+
+	"websocket-chatapp/kafka"
+)
+
+// cluster starts an in-process Kafka with topic "chat" in 3 partitions.
+func cluster(t *testing.T) *kfake.Cluster {
+	t.Helper()
+	c, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, "chat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// TestProduce sends records for three keys in two batches and reads the
+// topic back: each key's records must be on one partition, in the order
+// they were produced.
+func TestProduce(t *testing.T) {
+	c := cluster(t)
+	p, err := kafka.NewProducer(c.ListenAddrs(), "chat", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	keys := []string{"general", "dm:alice,bob", "acme/room:lounge"}
+	want := map[string][]string{}
+	for batch := range 2 {
+		var records []kafka.Record
+		for i := range 10 {
+			key := keys[i%len(keys)]
+			value := fmt.Sprintf("%d-%d", batch, i)
+			records = append(records, kafka.Record{Key: []byte(key), Value: []byte(value)})
+			want[key] = append(want[key], value)
+		}
+		if failed, err := p.Produce(records); err != nil || failed != nil {
+			t.Fatalf("batch %d: %d record(s) failed: %v", batch, len(failed), err)
+		}
+	}
+
+	consumer, err := kgo.NewClient(kgo.SeedBrokers(c.ListenAddrs()...), kgo.ConsumeTopics("chat"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got := map[string][]string{}
+	partitions := map[string]int32{}
+	for n := 0; n < 20; {
+		fetches := consumer.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("read %d of 20 records: %v", n, err)
+		}
+		fetches.EachRecord(func(r *kgo.Record) {
+			key := string(r.Key)
+			if p, ok := partitions[key]; ok && p != r.Partition {
+				t.Errorf("%s is on partitions %d and %d", key, p, r.Partition)
+			}
+			partitions[key] = r.Partition
+			got[key] = append(got[key], string(r.Value))
+			n++
+		})
+	}
+	for _, key := range keys {
+		if !slices.Equal(got[key], want[key]) {
+			t.Errorf("%s: read %q, want %q", key, got[key], want[key])
+		}
+	}
+}
+
+// TestProduceFailed has the broker refuse every produce: Produce must
+// return all the records, in order, with the broker's error.
+func TestProduceFailed(t *testing.T) {
+	c := cluster(t)
+	c.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		c.KeepControl()
+		produce := req.(*kmsg.ProduceRequest)
+		resp := produce.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range produce.Topics {
+			rt := kmsg.NewProduceResponseTopic()
+			rt.Topic, rt.TopicID = topic.Topic, topic.TopicID
+			for _, part := range topic.Partitions {
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.Partition = part.Partition
+				rp.ErrorCode = kerr.TopicAuthorizationFailed.Code
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			resp.Topics = append(resp.Topics, rt)
+		}
+		return resp, nil, true
+	})
+	p, err := kafka.NewProducer(c.ListenAddrs(), "chat", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	records := []kafka.Record{
+		{Key: []byte("general"), Value: []byte("1")},
+		{Key: []byte("dm:alice,bob"), Value: []byte("2")},
+		{Key: []byte("general"), Value: []byte("3")},
+	}
+	failed, err := p.Produce(records)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !slices.EqualFunc(failed, records, func(a, b kafka.Record) bool { return string(a.Value) == string(b.Value) }) {
+		t.Errorf("failed %d record(s), want all %d in order", len(failed), len(records))
+	}
+}
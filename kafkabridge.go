@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"websocket-chatapp/kafka"
+)
+
+// Kafka bridge. With CHAT_KAFKA_BROKERS set, every chat message accepted by
+// this instance is also produced to CHAT_KAFKA_TOPIC, keyed by workspace
+// and conversation so each conversation stays on one partition, in order.
+// The bridge is fully decoupled from delivery: handlers enqueue without
+// blocking, one writer batches and retries, records that still fail are
+// appended to the dead-letter file, and records that don't fit in the
+// buffer while Kafka is slow or down are dropped and counted.
+type kafkaRecord struct {
+	Workspace    string      `json:"workspace,omitempty"`
+	Conversation string      `json:"conversation"`
+	Message      ChatMessage `json:"message"`
+}
+
+var (
+	kafkaQueue   chan kafka.Record
+	kafkaDropped atomic.Int64
+)
+
+func startKafkaBridge() {
+	if len(cfg().KafkaBrokers) == 0 {
+		return
+	}
+	dl, err := os.OpenFile(cfg().KafkaDeadLetter, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Fatal("❌ Kafka dead-letter file: ", err)
+	}
+	producer, err := kafka.NewProducer(cfg().KafkaBrokers, cfg().KafkaTopic, "chat-"+instanceID)
+	if err != nil {
+		log.Fatal("❌ Kafka: ", err)
+	}
+	kafkaQueue = make(chan kafka.Record, cfg().KafkaBuffer)
+	go runKafkaBridge(producer, kafkaQueue, json.NewEncoder(dl))
+	fmt.Printf("📤 Bridging messages to Kafka topic %s\n", cfg().KafkaTopic)
+}
+
+// bridgeMessage queues msg for Kafka and the outgoing webhooks. It returns
+// immediately.
+func bridgeMessage(ws workspace, conversation string, msg ChatMessage) {
+	queueWebhooks(ws, conversation, msg)
+	if kafkaQueue == nil {
+		return
+	}
+	value, _ := json.Marshal(kafkaRecord{Workspace: string(ws), Conversation: conversation, Message: msg})
+	rec := kafka.Record{Key: []byte(string(ws) + "/" + conversation), Value: value}
+	select {
+	case kafkaQueue <- rec:
+	default:
+		if kafkaDropped.Add(1)%1000 == 1 {
+			log.Printf("⚠️ Kafka bridge buffer full; %d message(s) dropped so far", kafkaDropped.Load())
+			adminAlertf("kafka_dropped", "Kafka bridge buffer full; %d message(s) dropped so far", kafkaDropped.Load())
+		}
+	}
+}
+
+// dmConversation names a DM conversation the same way from both sides.
+func dmConversation(a, b string) string {
+	pair := []string{a, b}
+	sort.Strings(pair)
+	return "dm:" + strings.Join(pair, ",")
+}
+
+func runKafkaBridge(p *kafka.Producer, queue <-chan kafka.Record, deadLetter *json.Encoder) {
+	for rec := range queue {
+		batch := []kafka.Record{rec}
+		linger := time.After(cfg().KafkaLinger)
+	collect:
+		for len(batch) < cfg().KafkaBatchSize {
+			select {
+			case rec := <-queue:
+				batch = append(batch, rec)
+			case <-linger:
+				break collect
+			}
+		}
+		sendKafkaBatch(p, batch, deadLetter)
+	}
+}
+
+// sendKafkaBatch produces batch, retrying failed records with backoff. It
+// blocks the writer (never a handler) while retrying, so later records
+// queue up behind the failed ones and per-conversation order holds.
+func sendKafkaBatch(p *kafka.Producer, batch []kafka.Record, deadLetter *json.Encoder) {
+	backoff := 200 * time.Millisecond
+	var err error
+	for attempt := 0; ; attempt++ {
+		if batch, err = p.Produce(batch); err == nil {
+			return
+		}
+		if attempt == cfg().KafkaRetries {
+			break
+		}
+		log.Printf("⚠️ Kafka produce failed (%d record(s), attempt %d): %v", len(batch), attempt+1, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 10*time.Second)
+	}
+
+	log.Printf("❌ Giving up on %d Kafka record(s): %v", len(batch), err)
+	adminAlertf("kafka_dead_letter", "gave up on %d Kafka record(s): %v", len(batch), err)
+	for _, rec := range batch {
+		deadLetter.Encode(map[string]interface{}{
+			"time":  time.Now().UnixMilli(),
+			"error": err.Error(),
+			"key":   string(rec.Key),
+			"value": json.RawMessage(rec.Value),
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// TestKafkaBridge runs the bridge against an in-process Kafka. Messages
+// from two conversations must come out of the topic keyed by workspace and
+// conversation, each conversation on one partition and in order. Once the
+// broker refuses produces, a message must end up in the dead-letter file
+// after the retries.
+func TestKafkaBridge(t *testing.T) {
+	initMemory() // for the admin alert of the dead letter
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, "chat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	deadLetter := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	setConfig(t, func(c *config) {
+		c.KafkaBrokers, c.KafkaTopic, c.KafkaDeadLetter = cluster.ListenAddrs(), "chat", deadLetter
+		c.KafkaRetries, c.KafkaLinger = 1, 10*time.Millisecond
+	})
+	startKafkaBridge()
+	defer func() {
+		close(kafkaQueue)
+		kafkaQueue = nil
+	}()
+
+	ws := workspace("acme")
+	want := map[string][]string{}
+	for i, conv := range []string{"room:general", dmConversation("bob", "alice"), "room:general", "room:general", dmConversation("alice", "bob")} {
+		msg := newMessage("alice", string(rune('a'+i)))
+		bridgeMessage(ws, conv, msg)
+		key := "acme/" + conv
+		want[key] = append(want[key], msg.Text)
+	}
+
+	consumer, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics("chat"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got := map[string][]string{}
+	partitions := map[string]int32{}
+	for n := 0; n < 5; {
+		fetches := consumer.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("read %d of 5 records: %v", n, err)
+		}
+		fetches.EachRecord(func(r *kgo.Record) {
+			var rec kafkaRecord
+			json.Unmarshal(r.Value, &rec)
+			key := string(r.Key)
+			if rec.Workspace+"/"+rec.Conversation != key {
+				t.Errorf("record %s has key %s", r.Value, key)
+			}
+			if p, ok := partitions[key]; ok && p != r.Partition {
+				t.Errorf("%s is on partitions %d and %d", key, p, r.Partition)
+			}
+			partitions[key] = r.Partition
+			got[key] = append(got[key], rec.Message.Text)
+			n++
+		})
+	}
+	for key, texts := range want {
+		if !slices.Equal(got[key], texts) {
+			t.Errorf("%s: read %q, want %q", key, got[key], texts)
+		}
+	}
+
+	cluster.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.KeepControl()
+		produce := req.(*kmsg.ProduceRequest)
+		resp := produce.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range produce.Topics {
+			rt := kmsg.NewProduceResponseTopic()
+			rt.Topic, rt.TopicID = topic.Topic, topic.TopicID
+			for _, part := range topic.Partitions {
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.Partition, rp.ErrorCode = part.Partition, kerr.TopicAuthorizationFailed.Code
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			resp.Topics = append(resp.Topics, rt)
+		}
+		return resp, nil, true
+	})
+	bridgeMessage(ws, "room:general", newMessage("alice", "refused"))
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		f, err := os.Open(deadLetter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry struct {
+			Key   string      `json:"key"`
+			Value kafkaRecord `json:"value"`
+			Error string      `json:"error"`
+		}
+		scanner := bufio.NewScanner(f)
+		found := scanner.Scan() && json.Unmarshal(scanner.Bytes(), &entry) == nil
+		f.Close()
+		if found {
+			if entry.Key != "acme/room:general" || entry.Value.Message.Text != "refused" || entry.Error == "" {
+				t.Errorf("dead letter %+v, want the refused message with its error", entry)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nothing in the dead-letter file")
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Every Redis key and pub/sub channel name is built here from
+// cfg().KeyPrefix, so CHAT_KEY_PREFIX fully isolates one deployment from
+// anything else sharing the Redis. Don't write key or channel literals
+// anywhere else.
+func redisKey(parts ...string) string {
+	return cfg().KeyPrefix + strings.Join(parts, ":")
+}
+
+// key scopes a key to the workspace. The default workspace keeps the
+// unscoped names so existing deployments keep their data.
+func (ws workspace) key(parts ...string) string {
+	if ws == defaultWorkspace {
+		return redisKey(parts...)
+	}
+	return redisKey(append([]string{"ws", string(ws)}, parts...)...)
+}
+
+// Instances and the workspace registry are shared by all workspaces.
+func instancesKey() string  { return redisKey("instances") }
+func workspacesKey() string { return redisKey("workspaces") }
+
+// Held by the instance renumbering legacy history (see order.go).
+func orderMigrationLockKey() string { return redisKey("order_migration") }
+
+// The version of the data (string), and the lock of the instance migrating
+// it (see schema.go).
+func schemaVersionKey() string { return redisKey("schema_version") }
+func schemaLockKey() string    { return redisKey("schema_migration") }
+
+// Held by the instance dropping expired history (see retention.go).
+func historySweepLockKey() string { return redisKey("history_sweep") }
+
+// The analytics stream is shared too; records carry their workspace.
+func eventsKey() string { return redisKey("events") }
+
+// Admin reads of private data (stream).
+func auditKey() string { return redisKey("audit") }
+
+// Each instance's figures for the admin overview (hash).
+func instanceStatsKey(id string) string { return redisKey("instance", id, "stats") }
+
+// Join throttling by client address, across workspaces: joins per minute
+// (counter), rejected joins (counter) and bans (string with a TTL). See
+// throttle.go.
+func ipJoinsKey(ip string, minute int64) string {
+	return redisKey("ip", ip, "joins", strconv.FormatInt(minute, 10))
+}
+func ipStrikesKey(ip string) string { return redisKey("ip", ip, "strikes") }
+func ipBanKey(ip string) string     { return redisKey("ip", ip, "banned") }
+
+// Link previews are of public pages, so one cache serves every workspace
+// (string, keyed by the SHA-256 of the URL).
+func previewKey(urlHash string) string { return redisKey("preview", urlHash) }
+
+// The admin feed spans workspaces; events carry theirs.
+func adminChannel() string { return redisKey("admin") }
+
+// Config overrides set through the admin API reach every instance here.
+func configChannel() string { return redisKey("config_reload") }
+
+// Presence.
+func (ws workspace) membersKey() string      { return ws.key("members") }
+func (ws workspace) membersSinceKey() string { return ws.key("members", "since") }
+func (ws workspace) spectatorsKey() string   { return ws.key("members", "spectators") }
+func (ws workspace) instanceMembersKey(id string) string {
+	return ws.key("instance", id, "members")
+}
+
+// Conversations.
+func (ws workspace) messagesKey() string { return ws.key("messages") }
+
+// dmKey is the history of the DMs between a and b, both ways: the same
+// key whichever of them is named first.
+func (ws workspace) dmKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return ws.key("dms", a, b)
+}
+func (ws workspace) deliveredKey(sender, receiver string) string {
+	return ws.key("delivered", sender, receiver)
+}
+func (ws workspace) isDMKey(key string) bool {
+	return strings.HasPrefix(key, ws.key("dms")+":")
+}
+
+// dmPeer returns the other participant of the DM history key that sender
+// writes to.
+func (ws workspace) dmPeer(key, sender string) (string, bool) {
+	pair, ok := strings.CutPrefix(key, ws.key("dms")+":")
+	a, b, ok2 := strings.Cut(pair, ":")
+	switch {
+	case !ok || !ok2:
+		return "", false
+	case a == sender:
+		return b, true
+	case b == sender:
+		return a, true
+	}
+	return "", false
+}
+
+// conversationOf names the conversation whose history is at key, as the
+// Kafka bridge does ("global", "room:<name>", "group:<id>" or
+// "dm:<a>,<b>"), and tells whether it is private (a DM or group DM).
+func (ws workspace) conversationOf(key string) (name string, private, ok bool) {
+	if key == ws.messagesKey() {
+		return "global", false, true
+	}
+	if pair, ok := strings.CutPrefix(key, ws.key("dms")+":"); ok {
+		a, b, ok := strings.Cut(pair, ":")
+		return dmConversation(a, b), true, ok
+	}
+	for _, c := range []struct {
+		prefix  string
+		private bool
+	}{{"room", false}, {"group", true}} {
+		rest, ok := strings.CutPrefix(key, ws.key(c.prefix)+":")
+		if id, ok2 := strings.CutSuffix(rest, ":messages"); ok && ok2 {
+			return c.prefix + ":" + id, c.private, true
+		}
+	}
+	return "", false, false
+}
+
+// legacyDMKey is where DMs from sender to receiver were kept before schema
+// version 1, one key per direction.
+func (ws workspace) legacyDMKey(sender, receiver string) string {
+	return ws.key("dm", sender, receiver)
+}
+func (ws workspace) roomMessagesKey(room string) string   { return ws.key("room", room, "messages") }
+func (ws workspace) roomMembersKey(room string) string    { return ws.key("room", room, "members") }
+func (ws workspace) roomMetaKey(room string) string       { return ws.key("room", room, "meta") }
+func (ws workspace) roomClosingKey(room string) string    { return ws.key("room", room, "closing") }
+func (ws workspace) roomInvitesKey(room string) string    { return ws.key("room", room, "invites") }
+func (ws workspace) roomSlowKey(room, name string) string { return ws.key("room", room, "slow", name) }
+func (ws workspace) groupMembersKey(id string) string     { return ws.key("group", id, "members") }
+func (ws workspace) groupMessagesKey(id string) string    { return ws.key("group", id, "messages") }
+func (ws workspace) translationsKey(id string) string     { return ws.key("translations", id) }
+
+// The room directory: listed rooms by last activity (sorted set: room ->
+// unix seconds), and the marker of its one-time backfill (string).
+func (ws workspace) roomDirectoryKey() string { return ws.key("rooms", "directory") }
+func (ws workspace) roomDirectoryBackfilledKey() string {
+	return ws.key("rooms", "directory", "backfilled")
+}
+
+// Every room (set), the marker of its one-time backfill (string) and the
+// rooms each user created per UTC day (string counter). See roomlimits.go.
+func (ws workspace) roomsKey() string           { return ws.key("rooms", "all") }
+func (ws workspace) roomsBackfilledKey() string { return ws.key("rooms", "all", "backfilled") }
+func (ws workspace) roomQuotaKey(name, date string) string {
+	return ws.key("room_quota", name, date)
+}
+
+// Held by the instance archiving idle rooms (see roomlimits.go).
+func roomIdleSweepLockKey() string { return redisKey("room_idle_sweep") }
+
+// A poll (hash), its votes (hash: name -> option) and the lock of the
+// instance about to broadcast its tallies. Closing times are shared by all
+// workspaces (sorted set of <workspace>/<id> by unix time). See polls.go.
+func (ws workspace) pollKey(id string) string       { return ws.key("poll", id) }
+func (ws workspace) pollVotesKey(id string) string  { return ws.key("poll", id, "votes") }
+func (ws workspace) pollUpdateKey(id string) string { return ws.key("poll", id, "update") }
+func pollsClosingKey() string                       { return redisKey("polls", "closing") }
+
+// Archived room histories (sorted sets, like the live ones) and their
+// descriptions (hash: archive id -> JSON).
+func (ws workspace) archiveKey(id string) string { return ws.key("archive", id) }
+func (ws workspace) archivesKey() string         { return ws.key("archives") }
+
+// Message order: sequence counters (hash: history key -> last number) and
+// each conversation's time index (sorted set), named after its history key
+// without the prefix (chat:times:dm:alice:bob).
+func (ws workspace) seqKey() string { return ws.key("seq") }
+func (ws workspace) timeIndexKey(historyKey string) string {
+	return ws.key("times", strings.TrimPrefix(historyKey, ws.key("")))
+}
+
+// The registry of history zset keys (set), and the marker of its one-time
+// backfill (string).
+func (ws workspace) conversationsKey() string           { return ws.key("conversations") }
+func (ws workspace) conversationsBackfilledKey() string { return ws.key("conversations", "backfilled") }
+func (ws workspace) dmIndexBackfilledKey() string       { return ws.key("user", "dms", "backfilled") }
+
+// The firehose (stream of every stored message, see firehose.go) and the
+// recorders reading one, on any instance and of any workspace (sorted set:
+// consumer ID -> unix time last seen).
+func (ws workspace) firehoseKey() string { return ws.key("firehose") }
+func firehoseConsumersKey() string       { return redisKey("firehose", "consumers") }
+
+// Messages imported with an external ID (hash: external ID -> message ID,
+// see import.go).
+func (ws workspace) importedKey() string { return ws.key("imported") }
+
+// Users.
+func (ws workspace) userGroupsKey(name string) string { return ws.key("user", name, "groups") }
+func (ws workspace) userRoomsKey(name string) string  { return ws.key("user", name, "rooms") }
+func (ws workspace) userDMsKey(name string) string    { return ws.key("user", name, "dms") }
+func (ws workspace) profileKey(name string) string    { return ws.key("user", name, "profile") }
+func (ws workspace) readPosKey(name string) string    { return ws.key("readpos", name) }
+func (ws workspace) autoReplyKey(name string) string  { return ws.key("user", name, "autoreply") }
+func (ws workspace) autoReplySentKey(name, sender string) string {
+	return ws.key("user", name, "autoreply", "sent", sender)
+}
+func (ws workspace) notifyThrottleKey(name, sender string) string {
+	return ws.key("user", name, "notified", sender)
+}
+func (ws workspace) quotaKey(name, date string) string {
+	return ws.key("quota", name, date)
+}
+func (ws workspace) watchesKey() string { return ws.key("watches") }
+func (ws workspace) snoozesKey(name string) string {
+	return ws.key("user", name, "snoozes")
+}
+func (ws workspace) dmClearedKey(name string) string {
+	return ws.key("user", name, "cleared")
+}
+func (ws workspace) sessionsKey(name string) string {
+	return ws.key("user", name, "sessions")
+}
+func (ws workspace) roomSinceKey(name string) string {
+	return ws.key("user", name, "room_since")
+}
+func (ws workspace) dismissedKey(name string) string {
+	return ws.key("user", name, "dismissed")
+}
+func (ws workspace) statusKey(name string) string {
+	return ws.key("user", name, "status")
+}
+func (ws workspace) sentKey(name, tempID string) string {
+	return ws.key("user", name, "sent", tempID)
+}
+
+// Registered name spellings (hash: canonical form -> name), and the names
+// that predate it and differ from a registered one only in case (set).
+func (ws workspace) namesKey() string       { return ws.key("names") }
+func (ws workspace) namesLegacyKey() string { return ws.key("names", "legacy") }
+
+// Names joined from one address (sorted set: name -> expiry unix time).
+func (ws workspace) ipNamesKey(ip string) string { return ws.key("ip", ip, "names") }
+func (ws workspace) activityKey(date string) string {
+	return ws.key("activity", date)
+}
+func (ws workspace) translateRateKey(name string, minute int64) string {
+	return ws.key("translate", name, strconv.FormatInt(minute, 10))
+}
+func (ws workspace) usersLexKey() string      { return ws.key("users", "lex") }
+func (ws workspace) usersActivityKey() string { return ws.key("users", "activity") }
+func (ws workspace) talkersKey(bucket int64) string {
+	return ws.key("talkers", strconv.FormatInt(bucket, 10))
+}
+
+// User data deletion jobs.
+func (ws workspace) deletionKey(name string) string { return ws.key("deletion", name) }
+func (ws workspace) deletionsKey() string           { return ws.key("deletions") }
+
+// Moderation.
+func (ws workspace) invitesKey() string    { return ws.key("invites") }
+func (ws workspace) inviteUsesKey() string { return ws.key("invites", "used") }
+func (ws workspace) invitedKey() string    { return ws.key("invites", "allowed") }
+
+func (ws workspace) bansKey() string   { return ws.key("bans") }
+func (ws workspace) mutesKey() string  { return ws.key("mutes") }
+func (ws workspace) pinnedKey() string { return ws.key("pinned") }
+
+// Sticky announcements (hash: notice ID -> notice JSON).
+func (ws workspace) announcementsKey() string { return ws.key("announcements") }
+
+// Per-workspace config overrides (hash).
+func (ws workspace) configKey() string { return ws.key("config") }
+
+// Outgoing webhook registrations (hash: id -> JSON).
+func (ws workspace) webhooksKey() string { return ws.key("webhooks") }
+
+// Incoming webhook registrations, across workspaces (hash: token SHA-256
+// -> JSON).
+func incomingHooksKey() string { return redisKey("incoming_hooks") }
+
+// Custom emoji (hash: name -> file ID).
+func (ws workspace) emojiKey() string { return ws.key("emoji") }
+
+// Pub/sub channels share the prefix too; they live in a separate namespace
+// from keys, so "<prefix>messages" the channel and the zset don't clash.
+func (ws workspace) messagesChannel() string        { return ws.key("messages") }
+func (ws workspace) memberAddChannel() string       { return ws.key("member_add") }
+func (ws workspace) memberRemoveChannel() string    { return ws.key("member_remove") }
+func (ws workspace) userChannel(name string) string { return ws.key("dm", name) }
+func (ws workspace) watchesChannel() string         { return ws.key("watches") }
+func (ws workspace) snoozesChannel() string         { return ws.key("snoozes") }
+func (ws workspace) webhooksChannel() string        { return ws.key("webhooks") }
+func (ws workspace) roomChannel(room string) string { return ws.key("room", room) }
+func (ws workspace) emojiChannel() string           { return ws.key("emoji") }
+
+// Commands for one user's connections, such as session_kill.
+func (ws workspace) sessionControlChannel(name string) string { return ws.key("control", name) }
+
+// unscopedKey maps a key from any workspace to its default-workspace form.
+func unscopedKey(key string) string {
+	rest, ok := strings.CutPrefix(key, redisKey("ws")+":")
+	if !ok {
+		return key
+	}
+	if _, rest, ok = strings.Cut(rest, ":"); !ok {
+		return key
+	}
+	return cfg().KeyPrefix + rest
+}
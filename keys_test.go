@@ -0,0 +1,101 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestNoKeyLiterals fails if a Redis key or channel name is written out
+// anywhere but keys.go: a string starting with the default prefix, or a
+// literal in the key argument of a Redis command or in the channel of
+// publish or Subscribe. The default prefix itself is config.go's to give.
+func TestNoKeyLiterals(t *testing.T) {
+	files, _ := filepath.Glob("*.go")
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if name == "keys.go" || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.BasicLit:
+				s, _ := strconv.Unquote(n.Value)
+				if n.Kind == token.STRING && strings.HasPrefix(s, "chat:") && !(name == "config.go" && s == "chat:") {
+					t.Errorf("%s: key literal %s", fset.Position(n.Pos()), n.Value)
+				}
+			case *ast.CallExpr:
+				if arg := keyArg(n); arg != nil && hasLiteral(arg) {
+					t.Errorf("%s: a key or channel built from a literal", fset.Position(arg.Pos()))
+				}
+			}
+			return true
+		})
+	}
+}
+
+// keyArg returns the argument of call naming a key or channel, if call is
+// a Redis command or a publish or Subscribe.
+func keyArg(call *ast.CallExpr) ast.Expr {
+	switch fn := call.Fun.(type) {
+	case *ast.Ident:
+		if fn.Name == "publish" && len(call.Args) > 0 {
+			return call.Args[0]
+		}
+	case *ast.SelectorExpr:
+		recv, ok := fn.X.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		switch {
+		case recv.Name == "rdb" || recv.Name == "pipe" || recv.Name == "tx":
+			if len(call.Args) > 1 {
+				return streamArg(call.Args[1])
+			}
+		case fn.Sel.Name == "Subscribe" && len(call.Args) > 0:
+			return call.Args[0]
+		}
+	}
+	return nil
+}
+
+// streamArg returns the Stream field of arg if it is a stream command's
+// arguments (&redis.XAddArgs{...}), or else arg.
+func streamArg(arg ast.Expr) ast.Expr {
+	ref, ok := arg.(*ast.UnaryExpr)
+	if !ok {
+		return arg
+	}
+	lit, ok := ref.X.(*ast.CompositeLit)
+	if !ok {
+		return arg
+	}
+	for _, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if field, ok := kv.Key.(*ast.Ident); ok && field.Name == "Stream" {
+				return kv.Value
+			}
+		}
+	}
+	return nil
+}
+
+// hasLiteral reports whether e is or contains a string literal.
+func hasLiteral(e ast.Expr) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"websocket-chatapp/i18n"
+	"websocket-chatapp/protocol"
+)
+
+// Languages. Text the server writes for people (error messages, the
+// welcome, system messages) comes from the i18n catalogs. A connection
+// picks its language with ?lang=es or hello's "lang" (a tag or an
+// Accept-Language list; the hello reply says which one it got), else it
+// gets CHAT_LANG. Frames keep their machine-readable part as it was: an
+// error's code, and a system message's code and vars, so a client may
+// render them itself. Stored system messages are written in CHAT_LANG,
+// as everyone who reads them later shares the copy.
+//
+// English error messages are the detailed ones each call site gives; a
+// catalog's "error.<code>" entry replaces it for its language.
+
+// initLanguages loads CHAT_I18N_DIR and checks CHAT_LANG.
+func initLanguages() error {
+	if dir := cfg().I18nDir; dir != "" {
+		if err := i18n.LoadDir(dir); err != nil {
+			return fmt.Errorf("CHAT_I18N_DIR: %w", err)
+		}
+	}
+	if i18n.Match(cfg().Lang) == "" {
+		return fmt.Errorf("CHAT_LANG: no catalog for %q; have %v", cfg().Lang, i18n.Languages())
+	}
+	return nil
+}
+
+// matchLang returns the language to use for pref, CHAT_LANG if none fits.
+func matchLang(pref string) string {
+	if lang := i18n.Match(pref); lang != "" {
+		return lang
+	}
+	return i18n.Match(cfg().Lang)
+}
+
+func langFromQuery(r *http.Request) string {
+	return matchLang(r.URL.Query().Get("lang"))
+}
+
+func (c *client) language() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lang
+}
+
+// localize returns key's text in lang, or fallback if no catalog has it.
+func localize(lang, key, fallback string, vars ...string) string {
+	if text, ok := i18n.Text(lang, key, vars...); ok {
+		return text
+	}
+	return fallback
+}
+
+// errorFrame is the error frame for code in c's language; message is its
+// English text.
+func errorFrame(c *client, code, message string, vars ...string) protocol.Error {
+	return protocol.NewError(code, localize(c.language(), "error."+code, message, vars...))
+}
+
+// systemMessage is a system message with key's text in CHAT_LANG, keeping
+// key and vars for clients that render it themselves.
+func systemMessage(key string, vars ...string) ChatMessage {
+	msg := newMessage("system", localize(matchLang(""), key, key, vars...))
+	msg.System = true
+	msg.Code = key
+	if len(vars) > 1 {
+		msg.Vars = make(map[string]string, len(vars)/2)
+		for i := 0; i+1 < len(vars); i += 2 {
+			msg.Vars[vars[i]] = vars[i+1]
+		}
+	}
+	return msg
+}
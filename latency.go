@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Application-level pings let clients measure RTT and clock skew, and let
+// the server keep a rolling RTT per connection. They are ordinary frames:
+// they don't touch websocket control frames or read deadlines.
+const rttSmoothing = 0.2
+
+// {"type":"ping","t":<client ms>} is answered immediately.
+func handlePing(c *client, data []byte) {
+	var req protocol.PingRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid ping frame")
+		return
+	}
+	c.writeJSON(protocol.NewPong(req.T, serverNow()))
+}
+
+// {"type":"pong","t":<echoed server ms>} answers one of our own pings.
+func handlePong(c *client, data []byte) {
+	var req protocol.PongRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return
+	}
+
+	rtt := serverNow() - req.T
+	// Ignore echoes we can't have sent recently.
+	if req.T <= 0 || rtt < 0 || rtt > 2*cfg().AppPingInterval.Milliseconds() {
+		return
+	}
+	c.recordRTT(float64(rtt))
+}
+
+func (c *client) recordRTT(ms float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rttMs == 0 {
+		c.rttMs = ms
+		return
+	}
+	c.rttMs += rttSmoothing * (ms - c.rttMs)
+}
+
+func (c *client) rtt() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rttMs
+}
+
+// runAppPings pings the client until the connection closes.
+func runAppPings(c *client) {
+	ticker := time.NewTicker(cfg().AppPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			// t doubles as serverTime so clients can re-sync their offset.
+			now := serverNow()
+			if c.writeJSON(protocol.NewPing(now)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// GET /api/stats (admin: it names every connected user)
+func handleStatsAPI(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	conns := []protocol.ConnectionStats{}
+	spectators := 0
+	for _, c := range connectedClients() {
+		pingRTT, slow := c.pingRTT()
+		queued, inboundMs := c.inboundStats()
+		conns = append(conns, protocol.ConnectionStats{Workspace: string(c.ws), Name: c.userName(), Spectator: c.readOnly, Protocol: c.protocol, RTTMs: c.rtt(), PingRTTMs: pingRTT, RTTSlow: slow, InboundQueued: queued, InboundMs: inboundMs})
+		if c.readOnly {
+			spectators++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instance":           instanceID,
+		"connections":        conns,
+		"spectators":         spectators,
+		"eventsDropped":      eventsDropped.Load(),
+		"kafkaDropped":       kafkaDropped.Load(),
+		"notifyDropped":      notifyDropped.Load(),
+		"webhooksSent":       webhooksSent.Load(),
+		"webhooksFailed":     webhooksFailed.Load(),
+		"webhooksDropped":    webhooksDropped.Load(),
+		"signatureDropped":   signatureDropped.Load(),
+		"spilled":            spilled.Load(),
+		"spillRecovered":     spillRecovered.Load(),
+		"spillLost":          spillLost.Load(),
+		"spillQueued":        len(spillQueue),
+		"publishRetried":     publishRetried.Load(),
+		"publishLost":        publishLost.Load(),
+		"publishQueued":      len(publishQueue),
+		"resubscribes":       resubscribes.Load(),
+		"channelsSubscribed": subscribedChannels(),
+		"listenerRestarts":   listeners.Restarts(),
+		"pubsubLagP99Ms":     p99Ms(pubsubLagHistogram),
+		"pubsubLagged":       pubsubLagged.Load(),
+		"pubsubDuplicates":   pubsubDuplicates.Load(),
+		"wsWriteP99Ms":       p99Ms(wsWriteHistogram),
+		"inboundQueued":      inboundQueued.Load(),
+		"inboundRejected":    inboundRejected.Load(),
+		"inboundWaitP99Ms":   p99Ms(inboundWaitHistogram),
+		"outboundTruncated":  outboundTruncated.Load(),
+		"outboundRejected":   outboundRejected.Load(),
+
+		"previewsDropped":    previewDropped.Load(),
+		"initsShared":        initsShared.Load(),
+		"initsRunning":       len(initSlots),
+		"initRedisTrips":     initRedisTrips.Load(),
+		"historyCacheHits":   historyCacheHits.Load(),
+		"historyCacheMisses": historyCacheMisses.Load(),
+		"memberCaches":       memberCacheStats(),
+		"joinsRejected":      joinsRejected.Load(),
+		"connectionsDenied":  connectionsDenied.Load(),
+		"framesRefused":      framesRefused.Load(),
+		"ipBans":             ipBans.Load(),
+		"slowEvictions":      slowEvictions.Load(),
+		"rttSlow":            slowRTTClients(),
+		"draining":           draining.Load(),
+	})
+}
+
+// {"type":"whois","name":"bob"}
+func handleWhois(c *client, data []byte) {
+	ctx := c.ctx
+	var req protocol.WhoisRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Name == "" {
+		sendError(c, "bad_frame", "invalid whois frame")
+		return
+	}
+	req.Name = resolveName(ctx, c.ws, req.Name)
+
+	online, _ := rdb.SIsMember(ctx, c.ws.membersKey(), req.Name).Result()
+	displayName, _ := rdb.HGet(ctx, c.ws.profileKey(req.Name), "displayName").Result()
+
+	// Only this instance's connections are visible here.
+	conns := []protocol.ConnectionStats{}
+	for _, other := range workspaceClients(c.ws) {
+		if other.userName() == req.Name {
+			conns = append(conns, protocol.ConnectionStats{RTTMs: other.rtt()})
+		}
+	}
+
+	c.writeJSON(protocol.NewWhois(req.Name, displayName, online, conns, whoisActivity(ctx, c.ws, req.Name)))
+}
@@ -0,0 +1,82 @@
+package main_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestLeave has alice sign out while bob watches. She must get a leave
+// frame, then a close with code 1000 and kind left; bob must get her
+// member_remove at once, long before CHAT_PRESENCE_GRACE would let a
+// dropped connection go, and no longer find her in the member list. When
+// she comes back on another connection, the session she left must be gone
+// from her sessions and unknown to session_kill.
+func TestLeave(t *testing.T) {
+	addr := startServer(t, "", "CHAT_PRESENCE_GRACE=1m")
+	bob := dial(t, addr, "?roster=events", "bob") // member_add and member_remove, not diffs
+	alice := dial(t, addr, "", "alice")
+	left := sessionID(t, alice)
+	if !slices.Contains(memberNames(t, bob), "alice") {
+		t.Fatal("alice isn't a member before leaving")
+	}
+
+	alice.Leave()
+	var leave protocol.Leave
+	decode(t, await(t, alice, protocol.TypeLeave), &leave)
+	if leave.Name != "alice" {
+		t.Errorf("the leave frame names %q", leave.Name)
+	}
+	_, err := alice.Read()
+	var closed *client.ErrClosed
+	if !errors.As(err, &closed) || closed.Code != websocket.CloseNormalClosure || closed.Kind != protocol.CloseLeft {
+		t.Errorf("after leave, Read returned %T %v; want a 1000 close of kind left", err, err)
+	}
+
+	var remove protocol.MemberRemove
+	decode(t, await(t, bob, protocol.TypeMemberRemove), &remove)
+	if remove.Name != "alice" {
+		t.Errorf("bob got member_remove for %q", remove.Name)
+	}
+	if slices.Contains(memberNames(t, bob), "alice") {
+		t.Error("alice is still a member after leaving")
+	}
+
+	back := dial(t, addr, "", "alice")
+	current := sessionID(t, back)
+	back.SendFrame(map[string]string{"type": protocol.TypeSessions})
+	var sessions protocol.Sessions
+	decode(t, await(t, back, protocol.TypeSessions), &sessions)
+	if len(sessions.Sessions) != 1 || sessions.Sessions[0].ID != current {
+		t.Errorf("alice's sessions after coming back: %+v, want only %s", sessions.Sessions, current)
+	}
+	back.SendFrame(protocol.SessionKillRequest{Type: protocol.TypeSessionKill, ID: left})
+	var e protocol.Error
+	decode(t, await(t, back, protocol.TypeError), &e)
+	if e.Code != "not_found" {
+		t.Errorf("killing the session alice left got %q, want not_found", e.Code)
+	}
+}
+
+// sessionID returns the ID of c's connection.
+func sessionID(t *testing.T, c *client.Client) string {
+	t.Helper()
+	c.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello})
+	var hello protocol.Hello
+	decode(t, await(t, c, protocol.TypeHello), &hello)
+	return hello.Session
+}
+
+// memberNames returns the member list as c sees it.
+func memberNames(t *testing.T, c *client.Client) []string {
+	t.Helper()
+	c.SendFrame(protocol.MembersPageRequest{Type: protocol.TypeMembersPage, Limit: 100})
+	var page protocol.MembersPage
+	decode(t, await(t, c, protocol.TypeMembersPage), &page)
+	return page.Members
+}
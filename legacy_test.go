@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"testing"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestLegacySender checks that msg: and dm: frames are sent as the
+// connection's joined name: an unjoined connection can't send at all, a
+// joined one can't name someone else (even to get around a ban or a
+// mute), and may give its own name in another spelling.
+func TestLegacySender(t *testing.T) {
+	addr := startServer(t, "", withAdmin)
+	admin := adminConn(t, addr, "admin")
+	alice := dial(t, addr, "", "alice")
+	mallory := dial(t, addr, "", "mallory")
+
+	t.Run("unjoined", func(t *testing.T) {
+		eve := dial(t, addr, "", "eve")
+		admin.SendFrame(protocol.ModerationRequest{Type: protocol.TypeAdminBan, Name: "eve", Reason: "spam"})
+		await(t, admin, protocol.TypeAdminEvent)
+		eve.Close()
+
+		anon, err := client.Dial("ws://" + addr + "/ws")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer anon.Close()
+		await(t, anon, protocol.TypeInitDone)
+		anon.Send("eve", "still here")
+		refused(t, anon, "not_joined")
+		anon.SendDM("eve", "alice", "still here")
+		refused(t, anon, "not_joined")
+	})
+
+	t.Run("someone else", func(t *testing.T) {
+		alice.Send("bob", "as bob")
+		refused(t, alice, "forbidden")
+		alice.SendDM("bob", "mallory", "as bob")
+		refused(t, alice, "forbidden")
+	})
+
+	t.Run("muted", func(t *testing.T) {
+		admin.SendFrame(protocol.ModerationRequest{Type: protocol.TypeAdminMute, Name: "mallory"})
+		await(t, admin, protocol.TypeAdminEvent)
+		mallory.Send("notmallory", "unmuted?")
+		refused(t, mallory, "forbidden")
+		mallory.Send("mallory", "unmuted?")
+		refused(t, mallory, "muted")
+		mallory.SendDM("mallory", "alice", "unmuted?")
+		refused(t, mallory, "muted")
+	})
+
+	t.Run("own name", func(t *testing.T) {
+		alice.Send("ALICE", "hi")
+		f := await(t, alice, "message")
+		if f.Message.User != "alice" || f.Message.Text != "hi" {
+			t.Errorf("got %q from %q, want hi from alice", f.Message.Text, f.Message.User)
+		}
+	})
+}
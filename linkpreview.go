@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Link previews. After a message with links in it has been delivered, a
+// background worker fetches each page (at most linkPreviewMaxLinks per
+// message) and sends the conversation a follow-up
+//
+//	{"type":"link_preview","conversation":"global","messageId":"...",
+//	 "preview":{"url":"...","title":"...","description":"...","image":"..."}}
+//
+// Results, failures included, are cached in chat:preview:<sha256 of URL>,
+// so a URL is fetched once per linkPreviewTTL however often it is posted.
+// Fetches never reach private, loopback or link-local addresses (checked on
+// the resolved address at connect time, so redirects and DNS tricks can't
+// get around it), follow at most linkPreviewRedirects redirects, give up
+// after linkPreviewTimeout and read at most linkPreviewMaxBytes. Hosts can
+// be restricted with CHAT_LINK_PREVIEW_ALLOW / _DENY. Failures are silent.
+const (
+	linkPreviewWorkers   = 4
+	linkPreviewBuffer    = 1000
+	linkPreviewMaxLinks  = 3
+	linkPreviewTimeout   = 5 * time.Second
+	linkPreviewMaxBytes  = 512 << 10
+	linkPreviewRedirects = 3
+	linkPreviewTTL       = 24 * time.Hour
+	linkPreviewFailTTL   = time.Hour
+	linkPreviewMaxText   = 300 // runes kept of title and description
+)
+
+type previewJob struct {
+	ws           workspace
+	conversation string // as seen by the sender
+	msg          ChatMessage
+	urls         []string
+}
+
+var (
+	previewQueue       chan previewJob
+	previewDropped     atomic.Int64
+	previewClient      *http.Client
+	errPreviewBlocked  = errors.New("address not allowed")
+	errPreviewNotHTML  = errors.New("not an HTML page")
+	errPreviewRedirect = errors.New("too many redirects")
+)
+
+// startLinkPreviews starts the preview workers. They run even while
+// CHAT_LINK_PREVIEWS is off, so turning it on takes effect at once.
+func startLinkPreviews() {
+	dialer := &net.Dialer{Timeout: linkPreviewTimeout, Control: blockPrivateAddrs}
+	previewClient = &http.Client{
+		Timeout: linkPreviewTimeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			Proxy:                 nil, // a proxy would dial on our behalf, past the address check
+			TLSHandshakeTimeout:   linkPreviewTimeout,
+			ResponseHeaderTimeout: linkPreviewTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > linkPreviewRedirects {
+				return errPreviewRedirect
+			}
+			if !previewAllowed(req.URL) {
+				return errPreviewBlocked
+			}
+			return nil
+		},
+	}
+	previewQueue = make(chan previewJob, linkPreviewBuffer)
+	for i := 0; i < linkPreviewWorkers; i++ {
+		go runLinkPreviews(previewQueue)
+	}
+}
+
+// previewLinks queues previews for the links in msg, which was sent to
+// conversation ("global", "room:<name>", "group:<id>" or "dm:<peer>"). It
+// returns immediately.
+func previewLinks(ws workspace, conversation string, msg ChatMessage) {
+	if previewQueue == nil || !cfg().LinkPreviews || msg.Format == formatCode {
+		return
+	}
+	urls := extractLinks(msg.Text)
+	if len(urls) == 0 {
+		return
+	}
+	select {
+	case previewQueue <- previewJob{ws: ws, conversation: conversation, msg: msg, urls: urls}:
+	default:
+		if previewDropped.Add(1)%1000 == 1 {
+			log.Printf("⚠️ Link preview buffer full; %d message(s) skipped so far", previewDropped.Load())
+		}
+	}
+}
+
+// extractLinks finds the distinct http(s) links in text, up to
+// linkPreviewMaxLinks. Words starting with www. count as https links.
+func extractLinks(text string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, word := range strings.Fields(text) {
+		word = strings.TrimLeft(strings.TrimRight(word, ".,;:!?)]}>'\""), "(<[{'\"")
+		lower := strings.ToLower(word)
+		if strings.HasPrefix(lower, "www.") {
+			word = "https://" + word
+		} else if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			continue
+		}
+		u, err := url.Parse(word)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		u.Fragment = ""
+		if s := u.String(); !seen[s] {
+			seen[s] = true
+			urls = append(urls, s)
+		}
+		if len(urls) == linkPreviewMaxLinks {
+			break
+		}
+	}
+	return urls
+}
+
+func runLinkPreviews(queue <-chan previewJob) {
+	for job := range queue {
+		for _, raw := range job.urls {
+			p, ok := cachedPreview(serverCtx, raw)
+			if !ok || p.Title == "" && p.Description == "" && p.Image == "" {
+				continue // failures are silent, and empty cards pointless
+			}
+			deliverPreview(serverCtx, job, p)
+		}
+	}
+}
+
+// cachedPreview returns the preview of raw from the cache or by fetching
+// it. ok is false if the page can't be previewed.
+func cachedPreview(ctx context.Context, raw string) (p protocol.Preview, ok bool) {
+	sum := sha256.Sum256([]byte(raw))
+	key := previewKey(hex.EncodeToString(sum[:]))
+	if cached, err := rdb.Get(ctx, key).Result(); err == nil {
+		if cached == "" {
+			return p, false
+		}
+		return p, json.Unmarshal([]byte(cached), &p) == nil
+	}
+
+	p, err := fetchPreview(ctx, raw)
+	if err != nil {
+		rdb.Set(ctx, key, "", linkPreviewFailTTL)
+		return p, false
+	}
+	data, _ := json.Marshal(p)
+	rdb.Set(ctx, key, data, linkPreviewTTL)
+	return p, true
+}
+
+func fetchPreview(ctx context.Context, raw string) (protocol.Preview, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return protocol.Preview{}, err
+	}
+	if !previewAllowed(u) {
+		return protocol.Preview{}, errPreviewBlocked
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return protocol.Preview{}, err
+	}
+	req.Header.Set("User-Agent", "websocket-chatapp link preview")
+	req.Header.Set("Accept", "text/html")
+	resp, err := previewClient.Do(req)
+	if err != nil {
+		return protocol.Preview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return protocol.Preview{}, fmt.Errorf("%s answered %s", u.Host, resp.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" && mt != "application/xhtml+xml" {
+		return protocol.Preview{}, errPreviewNotHTML
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxBytes))
+	if err != nil {
+		return protocol.Preview{}, err
+	}
+	return parsePreview(resp.Request.URL, string(body)), nil
+}
+
+// previewAllowed checks the scheme and the allow and deny lists. A host
+// matches a list entry equal to it or to one of its parent domains.
+func previewAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if hostListed(host, cfg().LinkPreviewDeny) {
+		return false
+	}
+	allow := cfg().LinkPreviewAllow
+	return len(allow) == 0 || hostListed(host, allow)
+}
+
+func hostListed(host string, list []string) bool {
+	for _, d := range list {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockPrivateAddrs is the dialer's Control hook: it sees the address
+// actually being connected to, after DNS.
+func blockPrivateAddrs(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return errPreviewBlocked
+	}
+	return nil
+}
+
+var cgnat = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnat.Contains(ip))
+}
+
+var (
+	titleTag = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaTag  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attr     = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// parsePreview pulls the title, description and image out of a page,
+// preferring Open Graph tags.
+func parsePreview(page *url.URL, body string) protocol.Preview {
+	meta := map[string]string{}
+	for _, tag := range metaTag.FindAllString(body, -1) {
+		attrs := map[string]string{}
+		for _, m := range attr.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+		name := attrs["property"]
+		if name == "" {
+			name = attrs["name"]
+		}
+		name = strings.ToLower(name)
+		if _, dup := meta[name]; name != "" && !dup {
+			meta[name] = html.UnescapeString(attrs["content"])
+		}
+	}
+
+	p := protocol.Preview{URL: page.String(), Title: meta["og:title"], Description: meta["og:description"]}
+	if p.Title == "" {
+		if m := titleTag.FindStringSubmatch(body); m != nil {
+			p.Title = html.UnescapeString(m[1])
+		}
+	}
+	if p.Description == "" {
+		p.Description = meta["description"]
+	}
+	p.Title = clipText(p.Title)
+	p.Description = clipText(p.Description)
+	if img, err := page.Parse(strings.TrimSpace(meta["og:image"])); err == nil && meta["og:image"] != "" && (img.Scheme == "http" || img.Scheme == "https") {
+		p.Image = img.String()
+	}
+	return p
+}
+
+// clipText collapses whitespace and cuts s to linkPreviewMaxText runes.
+func clipText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > linkPreviewMaxText {
+		s = string(r[:linkPreviewMaxText]) + "…"
+	}
+	return s
+}
+
+// deliverPreview sends the preview to everyone who got the message, each
+// with the conversation as they see it.
+func deliverPreview(ctx context.Context, job previewJob, p protocol.Preview) {
+	ws, msg := job.ws, job.msg
+	frame := func(conversation string) []byte {
+		data, _ := json.Marshal(protocol.NewLinkPreview(conversation, msg.ID, p))
+
+		return data
+	}
+
+	kind, target, _ := strings.Cut(job.conversation, ":")
+	switch kind {
+	case "global":
+		publish(ws.messagesChannel(), frame("global"))
+	case "room":
+		publishRoom(ctx, ws, target, frame(job.conversation), nil)
+	case "group":
+		members, _ := rdb.SMembers(ctx, ws.groupMembersKey(target)).Result()
+		data := frame(job.conversation)
+		for _, m := range members {
+			publish(ws.userChannel(m), data)
+		}
+
+	case "dm":
+		publish(ws.userChannel(target), frame("dm:"+msg.User))
+		if target != msg.User {
+			publish(ws.userChannel(msg.User), frame(job.conversation))
+		}
+	}
+}
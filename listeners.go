@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"websocket-chatapp/supervisor"
+)
+
+// Listener supervision. Every loop that reads a pub/sub subscription (a
+// workspace's roster, watch, snooze, webhook and emoji listeners, and each
+// fan-out channel's) runs under the supervisor: a panic, say in a write
+// path, is recovered and logged and the loop runs again on the same
+// subscription; a loop that ends because its subscription closed gets a
+// new one. Restarts back off from 100ms to 30s while they keep failing
+// within a minute. Broadcasts published while a listener is down are
+// missed, as during a Redis reconnect. /readyz answers 503 while any
+// listener waits to restart, and /metrics counts the restarts.
+var listeners = &supervisor.Registry{MinBackoff: 100 * time.Millisecond, MaxBackoff: 30 * time.Second, Healthy: time.Minute}
+
+// superviseChannel subscribes to channel at once, then runs listen on the
+// subscription under the supervisor until ctx is done.
+func superviseChannel(ctx context.Context, name, channel string, listen func(subscription)) {
+	sub, stop := subscribeWhile(ctx, channel)
+	listeners.Go(ctx, name, func(last supervisor.Exit) {
+		if last == supervisor.Returned {
+			stop()
+			sub.Close()
+			sub, stop = subscribeWhile(ctx, channel)
+		}
+		listen(sub)
+	})
+}
+
+// subscribeWhile is subscribeUntil for a subscription that may be replaced
+// before ctx is done: stop forgets it, so it isn't held until then.
+func subscribeWhile(ctx context.Context, channel string) (sub subscription, stop func() bool) {
+	sub = transport.Subscribe(channel)
+	return sub, context.AfterFunc(ctx, sub.Close)
+}
+
+// listenerName names one of ws's listeners in logs and /readyz.
+func (ws workspace) listenerName(kind string) string {
+	if ws == defaultWorkspace {
+		return kind
+	}
+	return kind + "@" + string(ws)
+}
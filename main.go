@@ -3,197 +3,531 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	mathrand "math/rand/v2"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/memredis"
+	"websocket-chatapp/protocol"
 )
 
-type ChatMessage struct {
-	User string `json:"user"`
-	Text string `json:"text"`
-	Time int64  `json:"time"`
-}
+// ChatMessage is the server's name for protocol.Message.
+type ChatMessage = protocol.Message
 
 var (
-	ctx       = context.Background()
+	redisAddr = "localhost:6379"
 	rdb       *redis.Client
-	upgrader  = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-	clients   = make(map[*websocket.Conn]bool)
-	userNames = make(map[*websocket.Conn]string)
+	upgrader  *websocket.Upgrader
 )
 
-func initRedis() {
-	rdb = redis.NewClient(&redis.Options{Addr: "localhost:6379"})
-	if err := rdb.Ping(ctx).Err(); err != nil {
+// newUpgrader applies the buffer and handshake settings. Gorilla keeps a
+// connection's read and write buffers for as long as it is open, so they
+// cost ReadBufferSize+WriteBufferSize per connection.
+func newUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:   cfg().ReadBufferSize,
+		WriteBufferSize:  cfg().WriteBufferSize,
+		HandshakeTimeout: cfg().HandshakeTimeout,
+		Subprotocols:     subprotocols,
+		// handleWebSocket checks CHAT_ALLOWED_ORIGINS itself, to count
+		// refusals with the other access checks.
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+}
+
+// serverCtx is for work not done on behalf of one connection: broadcast
+// listeners, heartbeats, background jobs. It is cancelled on shutdown.
+// Connection work uses the client's ctx instead.
+var serverCtx, stopServer = context.WithCancel(context.Background())
+
+func initRedis(addr string) {
+	if sentinels := cfg().RedisSentinels; len(sentinels) > 0 {
+		// The client asks the Sentinels for the master on every new
+		// connection, so after a failover it reconnects to the new one.
+		rdb = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:            cfg().RedisMaster,
+			SentinelAddrs:         sentinels,
+			ContextTimeoutEnabled: true,
+		})
+	} else {
+		rdb = redis.NewClient(&redis.Options{Addr: addr, ContextTimeoutEnabled: true})
+	}
+	rdb.AddHook(redisTimeoutHook{})
+	if err := rdb.Ping(serverCtx).Err(); err != nil {
 		panic(err)
 	}
 	fmt.Println("✅ Connected to Redis")
 }
 
+// initMemory points rdb at an in-process store instead of a Redis server.
+func initMemory() {
+	mem := memredis.NewServer()
+	rdb = redis.NewClient(&redis.Options{
+		Addr:            "memory",
+		Dialer:          mem.Dial,
+		Protocol:        2,
+		DisableIdentity: true,
+
+		ContextTimeoutEnabled: true,
+	})
+	rdb.AddHook(redisTimeoutHook{})
+	if err := rdb.Ping(serverCtx).Err(); err != nil {
+		panic(err)
+	}
+	fmt.Println("⚠️ Using in-memory store: chat state is NOT persisted and is lost on exit")
+}
+
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	if !connectionAllowed(r, ip) || !originAllowed(r) {
+		connectionsDenied.Add(1)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	ws, ok := lookupWorkspace(r.Context(), workspaceFromPath(r.URL.Path))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+	if draining.Load() {
+		http.Error(w, "draining; connect to another instance", http.StatusServiceUnavailable)
+		return
+	}
+	if wait := ipBanned(r.Context(), ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "too many rejected joins from your address; try again later", http.StatusTooManyRequests)
+		return
+	}
+	if !takeConnection(ip) {
+		connectionsDenied.Add(1)
+		http.Error(w, "too many connections from your address", http.StatusTooManyRequests)
+		return
+	}
+	defer releaseConnection(ip)
+	sign := signingRequested(r)
+	if sign && frameKey == nil {
+		http.Error(w, "frame signing is not enabled on this server", http.StatusBadRequest)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Upgrader error:", err)
 		return
 	}
+	if unsupportedProtocols(r) {
+		refuseProtocol(conn, r)
+		return
+	}
+
+	// Bounds a message however it is fragmented; gorilla closes with 1009
+	// past it.
+	conn.SetReadLimit(int64(cfg().MaxFrameBytes))
 
 	fmt.Println("💬 New WebSocket connection")
-	clients[conn] = true
+	// The request context lasts as long as this handler, i.e. the
+	// connection; the client's own context is also cancelled on close.
+	c := newClient(r.Context(), conn, ws, spectatorRequested(r))
+	c.ip = ip
+	c.roster = rosterFormat(r)
+	c.invite = normalizeInviteCode(r.URL.Query().Get("invite"))
+	c.device, c.deviceKind = deviceFromQuery(r)
+	c.lang = langFromQuery(r)
+	defer closeClient(c, websocket.CloseNormalClosure, "")
+	// On an invite-only server, the timeline and init wait until the
+	// connection is admitted; see invites.go.
+	pending := cfg().InviteOnly
+	if pending {
+		holdBack(c)
+	} else {
+		holdFrames(c) // until init is sent
+	}
+	ws.listen()
 
-	defer func() {
-		name := userNames[conn]
-		if name != "" {
-			rdb.SRem(ctx, "chat:members", name)
-			rdb.Publish(ctx, "member_remove", name)
+	if sign && startSigning(c) != nil {
+		return
+	}
+	if !pending {
+		followTimeline(c)
+		if sendInit(c) != nil {
+			return
 		}
-		delete(userNames, conn)
-		delete(clients, conn)
-		conn.Close()
-	}()
-
-	members, _ := rdb.SMembers(ctx, "chat:members").Result()
-	rawHistory, _ := rdb.ZRange(ctx, "chat:messages", -20, -1).Result()
-
-	var history []ChatMessage
-	for _, h := range rawHistory {
-		var msg ChatMessage
-		json.Unmarshal([]byte(h), &msg)
-		history = append(history, msg)
 	}
-
-	conn.WriteJSON(map[string]interface{}{
-		"type":    "init",
-		"members": members,
-		"history": history,
-	})
+	go runAppPings(c)
+	startControlPings(c)
 
 	for {
-		_, msg, err := conn.ReadMessage()
+		msgType, msg, err := conn.ReadMessage()
+		if errors.Is(err, websocket.ErrReadLimit) {
+			framesRefused.Add(1)
+			log.Printf("🚫 Closed connection from %s: frame over %d bytes", c.ip, cfg().MaxFrameBytes)
+			break
+		}
 		if err != nil {
-			log.Println("❌ Read error:", err)
+			// Not worth logging if closeClient closed the socket under us.
+			if c.ctx.Err() == nil {
+				log.Println("❌ Read error:", err)
+				// No close frame: the network went, not the user.
+				var ce *websocket.CloseError
+				c.dropped.Store(!errors.As(err, &ce) || ce.Code == websocket.CloseAbnormalClosure)
+			}
 			break
 		}
-
-		text := string(msg)
-
-		if strings.HasPrefix(text, "join:") {
-			name := strings.TrimSpace(text[5:])
-			if name == "" {
+		if !acceptFrame(c, msgType, msg) {
+			continue
+		}
+		if c.signer != nil {
+			if msg, ok = openSigned(c, msg); !ok {
 				continue
 			}
-			userNames[conn] = name
-			rdb.SAdd(ctx, "chat:members", name)
-			rdb.Publish(ctx, "member_add", name)
-			conn.WriteMessage(websocket.TextMessage, []byte("Welcome "+name+"!"))
+		}
 
-			go subscribeToDM(name, conn)
+		ev, err := ParseInbound(msg)
+		if err == errBadFrame {
+			sendError(c, "bad_frame", err.Error())
+			continue
+		}
+		if err != nil {
 			continue
 		}
 
-		// Direct message format: dm:sender:receiver:message
-		if strings.HasPrefix(text, "dm:") {
-			parts := strings.SplitN(text[3:], ":", 3)
-			if len(parts) < 3 {
-				continue
-			}
-			sender := parts[0]
-			receiver := parts[1]
-			message := parts[2]
-
-			msgObj := ChatMessage{
-				User: sender,
-				Text: message,
-				Time: time.Now().Unix(),
-			}
-			jsonMsg, _ := json.Marshal(msgObj)
+		if rejectUnadmitted(c, ev.Type) || rejectReadOnly(c, ev.Type) {
+			continue
+		}
+		enqueueFrame(c, ev) // see inqueue.go
+	}
+	waitInbound(c, cfg().WriteTimeout)
+}
 
-			key := fmt.Sprintf("chat:dm:%s:%s", sender, receiver)
-			rdb.ZAdd(ctx, key, redis.Z{Score: float64(msgObj.Time), Member: jsonMsg})
+// handleLegacyFrame handles the colon-separated join:, msg: and dm: frames.
+func handleLegacyFrame(c *client, ev Event) {
+	ctx, ws := c.ctx, c.ws
+	// msg: and dm: frames are sent as the connection's joined name. The
+	// name they give must be it (in any spelling), so a connection can't
+	// post as someone else, nor dodge a ban or mute by naming another
+	// sender. Guests can't choose their name, so theirs is used whatever
+	// the frame says.
+	if ev.Type != "join" {
+		name := requireJoined(c)
+		if name == "" {
+			return
+		}
+		if ev.From != name && !cfg().Guests && resolveName(ctx, ws, ev.From) != name {
+			sendError(c, "forbidden", "you joined as "+name+" and can only send as "+name)
+			return
+		}
+		ev.From = name
+	}
+	ev.To = resolveName(ctx, ws, ev.To)
+	if ev.Type != "join" && rejectDeactivated(c, ev.From) {
+		return
+	}
+	switch ev.Type {
+	case "join":
+		name := ev.Name
+		if cfg().Guests {
+			name = "guest-" + strings.ToLower(ids.Code(6))
+		}
+		if !validJoinName(name) {
+			sendError(c, "bad_name", "invalid name")
+			return
+		}
+		name = resolveName(ctx, ws, name)
 
-			rdb.Publish(ctx, "dm:"+receiver, jsonMsg)
+		if c.userName() != "" {
+			sendError(c, "already_joined", "this connection already joined as "+c.userName())
+			return
+		}
+		if !allowJoin(c, name) || rejectBanned(c, name) || rejectDeactivated(c, name) || !admitInvited(c, name) {
+			return
+		}
+		if !c.join(name) {
+			sendError(c, "already_joined", "this connection already joined")
+			return
+		}
+		admit(c)
+		registerName(ctx, ws, name)
+		holdName(c, name)
+		saveSession(c)
+		if c.listed() {
+			addPresence(ctx, ws, name, c.readOnly)
+		}
+		indexUser(ctx, ws, name)
+		recordEvent(ws, chatEvent{Type: "join", User: name})
+		c.writeMessage([]byte(localize(c.language(), "welcome", "Welcome "+name+"!", "name", name)))
+		rooms, roomUnread, roomsGone := restoreRooms(ctx, ws, name)
+		for _, room := range rooms {
+			enterRoom(c, room)
+		}
+		joined := protocol.NewJoined(name, c.id)
+		joined.Rooms, joined.RoomUnread, joined.RoomsGone = rooms, roomUnread, roomsGone
+		joined.GroupDMs = groupDMSummaries(ctx, ws, name)
+		joined.ReadPositions = readPositions(ctx, ws, name)
+		joined.Snoozes = userSnoozes(ctx, ws, name)
+		joined.Notices = userNotices(ctx, ws, name, workspaceNotices(ctx, ws))
+		c.writeJSON(joined)
 
-			conn.WriteMessage(websocket.TextMessage, jsonMsg)
-			continue
+	// Direct message format: dm:sender:receiver:message
+	case "dm":
+		sender := ev.From
+		receiver := ev.To
+		ok, known := checkRecipient(c, receiver)
+		if !ok || rejectDeactivatedPeer(c, receiver) || !takeQuota(c) {
+			return
 		}
 
-		// Public message format: msg:username:text
-		if strings.HasPrefix(text, "msg:") {
-			parts := strings.SplitN(text[4:], ":", 2)
-			if len(parts) < 2 {
-				continue
-			}
-			user := parts[0]
-			message := parts[1]
+		msgObj := newMessage(sender, ev.Text)
+		if !applyDisplayName(c, &msgObj, ev.DisplayName) || !applyFormat(c, &msgObj, ev.Format, ev.Lang) || !runInbound(c, "dm", "dm:"+receiver, &msgObj) {
+			return
+		}
+		jsonMsg, _ := json.Marshal(msgObj)
 
-			msgObj := ChatMessage{
-				User: user,
-				Text: message,
-				Time: time.Now().Unix(),
-			}
+		if !storeMessage(ctx, ws, ws.dmKey(sender, receiver), msgObj, jsonMsg) {
+			sendError(c, "not_stored", "message could not be stored; please retry")
+			return
+		}
+		c.expectEcho(msgObj.ID, ev.TempID)
+		sendDMAck(c, ev.TempID, msgObj, known)
+		publishDM(ws, msgObj, receiver)
+		touchActivity(ctx, ws, sender)
+		countTalker(ctx, ws, sender)
+		recordEvent(ws, chatEvent{Type: "dm", User: sender, Peer: receiver, Len: len(msgObj.Text)})
+		bridgeMessage(ws, dmConversation(sender, receiver), msgObj)
+		previewLinks(ws, "dm:"+receiver, msgObj)
 
-			jsonMsg, _ := json.Marshal(msgObj)
-			rdb.ZAdd(ctx, "chat:messages", redis.Z{Score: float64(msgObj.Time), Member: jsonMsg})
-			rdb.Publish(ctx, "messages", jsonMsg)
+		notifyOffline(ws, receiver, msgObj)
+		autoReply(ctx, ws, sender, receiver)
+
+	// Public message format: msg:username:text
+	case "msg":
+		user := ev.From
+		if !claimTempID(c, user, ev.TempID) {
+			return
+		}
+		if !takeQuota(c) {
+			releaseTempID(ctx, ws, user, ev.TempID)
+			return
+		}
+
+		msgObj := newMessage(user, ev.Text)
+		if !applyDisplayName(c, &msgObj, ev.DisplayName) || !applyFormat(c, &msgObj, ev.Format, ev.Lang) || !runInbound(c, "msg", "global", &msgObj) {
+			releaseTempID(ctx, ws, user, ev.TempID)
+			return
+		}
+		jsonMsg, _ := json.Marshal(msgObj)
+		if !storeMessage(ctx, ws, ws.messagesKey(), msgObj, jsonMsg) {
+			releaseTempID(ctx, ws, user, ev.TempID)
+			sendError(c, "not_stored", "message could not be stored; please retry")
+			return
 		}
+		recordTempID(ctx, ws, user, ev.TempID, msgObj)
+		c.expectEcho(msgObj.ID, ev.TempID)
+		sendAck(c, ev.TempID, msgObj)
+		publish(ws.messagesChannel(), jsonMsg)
+		notifyWatchers(ctx, ws, "", msgObj)
+		touchActivity(ctx, ws, user)
+		countTalker(ctx, ws, user)
+		recordEvent(ws, chatEvent{Type: "message", User: user, Len: len(msgObj.Text)})
+		bridgeMessage(ws, "global", msgObj)
+		previewLinks(ws, "global", msgObj)
 	}
 }
 
-func listenPublicMessages() {
-	pubsub := rdb.Subscribe(ctx, "messages")
-	ch := pubsub.Channel()
+func listenPublicMessages(ws workspace, sub subscription, f *fanout) {
+	// A run restarted by the supervisor may follow a gap.
+	dropInitStates(ws)
+	recent := recentHistoryOf(ws)
+	defer recent.follow(ws)()
+	seen := newPublicCursor()
+	deliver := func(payload []byte) {
+		recent.add(payload)
+		dropInitStates(ws)
+		f.deliver(payload)
+	}
+	ch := sub.Messages()
 	for msg := range ch {
-		for c := range clients {
-			c.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
+		if msg.Resubscribed {
+			recent.forget()
+			for _, payload := range seen.missed(serverCtx, ws) {
+				deliver(payload)
+			}
+			continue
+		}
+		payload, ok := openPayload(msg)
+		if !ok {
+			continue
+		}
+		if seen.note(payload) {
+			deliver(payload)
 		}
 	}
 }
 
-func listenMemberAdd() {
-	pubsub := rdb.Subscribe(ctx, "member_add")
-	ch := pubsub.Channel()
-	for msg := range ch {
-		for c := range clients {
-			c.WriteJSON(map[string]string{
-				"type": "member_add",
-				"name": msg.Payload,
-			})
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "snapshot":
+			runSnapshot(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "reencrypt":
+			runReencrypt(os.Args[2:])
+			return
 		}
 	}
-}
 
-func listenMemberRemove() {
-	pubsub := rdb.Subscribe(ctx, "member_remove")
-	ch := pubsub.Channel()
-	for msg := range ch {
-		for c := range clients {
-			c.WriteJSON(map[string]string{
-				"type": "member_remove",
-				"name": msg.Payload,
-			})
+	if err := initEncryption(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	if err := initFrameSigning(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	if err := initLanguages(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	if err := initAPITokens(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	if err := initMiddleware(cfg().Middleware); err != nil {
+		log.Fatal("❌ ", err)
+	}
+
+	memory := flag.Bool("memory", false, "run with an in-memory store instead of Redis (dev mode)")
+	addr := flag.String("redis", redisAddr, "Redis address")
+	listen := flag.String("listen", ":8080", "address to serve on")
+	flag.Parse()
+
+	if *memory {
+		initMemory()
+	} else {
+		initRedis(*addr)
+	}
+	if err := initTransport(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	if err := checkSchema(serverCtx); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	watchReloads()
+	warnProxyHeader()
+	logPublicMode()
+
+	watchDrainSignal()
+	runPresence(serverCtx)
+	if err := migrateOrder(serverCtx); err != nil {
+		log.Fatal("❌ Renumbering legacy history failed: ", err)
+	}
+	go backfillAllConversations(serverCtx)
+	go backfillAllRoomDirectories(serverCtx)
+	resumeDeletions(serverCtx)
+	go reportStats(serverCtx)
+	go runHistorySweeps(serverCtx)
+	go runPollClosing(serverCtx)
+	go runRoomIdleSweeps(serverCtx)
+
+	upgrader = newUpgrader()
+	startSpill()
+	startPublishQueue()
+	startInboundWorkers()
+	startInitPacing()
+	startLinkPreviews()
+	startEvents()
+	startKafkaBridge()
+	startWebhooks()
+	if err := startNotifier(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	if err := startTranslator(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+	defaultWorkspace.listen()
+
+	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/ws/", handleWebSocket)
+	http.HandleFunc("/api/workspaces", handleWorkspacesAPI)
+	http.HandleFunc("/api/users/", handleUsersAPI)
+	http.HandleFunc("/api/members", handleMembersAPI)
+	http.HandleFunc("/api/rooms", handleRoomsAPI)
+	http.HandleFunc("/api/stats", handleStatsAPI)
+	http.HandleFunc("/api/config", handleConfigAPI)
+	http.HandleFunc("/api/admin/overview", handleOverviewAPI)
+	http.HandleFunc("/api/admin/drain", handleDrainAPI)
+	http.HandleFunc("/api/admin/activity", handleActivityAPI)
+	http.HandleFunc("/api/admin/archives", handleArchivesAPI)
+	http.HandleFunc("/api/admin/export", handleExportAPI)
+	http.HandleFunc("/api/import", handleImportAPI)
+	http.HandleFunc("/api/firehose", handleFirehoseAPI)
+	http.HandleFunc("/api/admin/tokens", handleTokensAPI)
+	http.HandleFunc("/api/admin/invites", handleInvitesAPI)
+	http.HandleFunc("/api/admin/webhooks", handleWebhooksAPI)
+	http.HandleFunc("/api/admin/webhooks/test", handleWebhookTestAPI)
+	http.HandleFunc("/api/admin/hooks", handleIncomingHooksAPI)
+	http.HandleFunc("/hooks/", handleIncomingHook)
+	http.HandleFunc("/api/admin/emoji", handleAdminEmojiAPI)
+	http.HandleFunc("/api/emoji", handleEmojiAPI)
+
+	http.HandleFunc("/api/dm/", handleDMHistoryAPI)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/metrics", handleMetrics)
+	if cfg().DemoClient {
+		http.HandleFunc("/", handleIndex)
+	}
+	srv := &http.Server{Addr: *listen}
+	srv.RegisterOnShutdown(stopFirehoses)
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
 		}
+	}()
+	host := *listen
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
 	}
+	fmt.Println("🚀 Server running at http://" + host)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	shutdown(srv)
 }
 
-func subscribeToDM(username string, conn *websocket.Conn) {
-	pubsub := rdb.Subscribe(ctx, "dm:"+username)
-	ch := pubsub.Channel()
-	for msg := range ch {
-		conn.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
+// shutdown stops accepting connections, says goodbye to the open ones
+// (websockets are hijacked, so srv.Shutdown doesn't wait for them), writes
+// out spilled messages and then cancels serverCtx, which ends the
+// background listeners. Each goodbye suggests a different random delay
+// before reconnecting, so clients that follow it come back spread out
+// rather than all at once.
+func shutdown(srv *http.Server) {
+	fmt.Println("👋 Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	for _, c := range connectedClients() {
+		closeClient(c, websocket.CloseGoingAway, reconnectAdvice())
 	}
+	drainSpill()
+	stopServer()
 }
 
-func main() {
-	initRedis()
-	go listenPublicMessages()
-	go listenMemberAdd()
-	go listenMemberRemove()
-
-	http.HandleFunc("/ws", handleWebSocket)
-	fmt.Println("🚀 Server running at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// reconnectAdvice is the close reason sent on shutdown:
+// {"kind":"shutdown","reason":"server shutting down","retryAfterMs":4242},
+// with the delay picked uniformly within CHAT_RECONNECT_JITTER.
+func reconnectAdvice() string {
+	retryAfter := mathrand.Int64N(cfg().ReconnectJitter.Milliseconds() + 1)
+	return protocol.CloseReason{Kind: protocol.CloseShutdown, Reason: "server shutting down", RetryAfterMs: &retryAfter}.Encode()
 }
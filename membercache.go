@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Member cache. Reading chat:members (SMEMBERS) on every connect is O(N)
+// and, with tens of thousands online, the main cost of connecting. So each
+// instance keeps a sorted copy per workspace, kept current by the
+// member_add / member_remove broadcasts it already receives (see
+// listenRoster) and fully reloaded every CHAT_MEMBER_RESYNC in case one was
+// missed. Init payloads, members_page and member_search read it; Redis is
+// read only to fill it, or when it is more than two resync intervals old
+// (the resync has been failing). Workspaces this instance doesn't listen
+// to have no broadcasts to keep a copy current, so they read Redis.
+type memberCache struct {
+	mu      sync.Mutex
+	names   []string // sorted
+	loaded  time.Time
+	loads   int // full reads so far
+	loading bool
+	replay  []memberChange // changes seen while loading
+	done    chan struct{}  // closed when the load under way ends
+}
+
+type memberChange struct {
+	name  string
+	added bool
+}
+
+var (
+	memberCachesMu sync.Mutex
+	memberCaches   = map[workspace]*memberCache{}
+)
+
+func memberCacheOf(ws workspace) *memberCache {
+	memberCachesMu.Lock()
+	defer memberCachesMu.Unlock()
+	mc := memberCaches[ws]
+	if mc == nil {
+		mc = &memberCache{}
+		memberCaches[ws] = mc
+	}
+	return mc
+}
+
+// onlineMembers returns ws's online members, sorted. The slice is the
+// caller's.
+func onlineMembers(ctx context.Context, ws workspace) []string {
+	if !ws.listened() {
+		members, _ := rdb.SMembers(ctx, ws.membersKey()).Result()
+		sort.Strings(members)
+		return members
+	}
+	mc := memberCacheOf(ws)
+	for {
+		mc.mu.Lock()
+		if !mc.loaded.IsZero() && time.Since(mc.loaded) <= 2*cfg().MemberResync {
+			names := append([]string(nil), mc.names...)
+			mc.mu.Unlock()
+			return names
+		}
+		if !mc.loading {
+			mc.mu.Unlock()
+			mc.load(ctx, ws)
+			continue
+		}
+		done := mc.done
+		mc.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// isOnline reports whether name is in members, as returned by onlineMembers.
+func isOnline(members []string, name string) bool {
+	i := sort.SearchStrings(members, name)
+	return i < len(members) && members[i] == name
+}
+
+// load reads the member set into mc. Changes broadcast meanwhile are
+// applied on top, as the read may or may not include them.
+func (mc *memberCache) load(ctx context.Context, ws workspace) {
+	mc.mu.Lock()
+	if mc.loading {
+		mc.mu.Unlock()
+		return
+	}
+	mc.loading, mc.replay, mc.done = true, nil, make(chan struct{})
+	mc.mu.Unlock()
+
+	members, err := rdb.SMembers(ctx, ws.membersKey()).Result()
+	sort.Strings(members)
+
+	mc.mu.Lock()
+	if err == nil {
+		mc.names, mc.loaded = members, time.Now()
+		mc.loads++
+		for _, ch := range mc.replay {
+			mc.apply(ch)
+		}
+	}
+	mc.loading, mc.replay = false, nil
+	close(mc.done)
+	mc.mu.Unlock()
+}
+
+// change applies a member_add or member_remove.
+func (mc *memberCache) change(name string, added bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	ch := memberChange{name, added}
+	if mc.loading {
+		mc.replay = append(mc.replay, ch)
+	}
+	if !mc.loaded.IsZero() {
+		mc.apply(ch)
+	}
+}
+
+func (mc *memberCache) apply(ch memberChange) {
+	i := sort.SearchStrings(mc.names, ch.name)
+	present := i < len(mc.names) && mc.names[i] == ch.name
+	switch {
+	case ch.added && !present:
+		mc.names = append(mc.names, "")
+		copy(mc.names[i+1:], mc.names[i:])
+		mc.names[i] = ch.name
+	case !ch.added && present:
+		mc.names = append(mc.names[:i], mc.names[i+1:]...)
+	}
+}
+
+// resyncMembers reloads ws's cache every CHAT_MEMBER_RESYNC until ctx ends.
+func resyncMembers(ctx context.Context, ws workspace) {
+	mc := memberCacheOf(ws)
+	for {
+		select {
+		case <-time.After(cfg().MemberResync):
+		case <-ctx.Done():
+			return
+		}
+		mc.load(ctx, ws)
+	}
+}
+
+// memberCacheStats describes each cache for /api/stats.
+func memberCacheStats() map[string]interface{} {
+	memberCachesMu.Lock()
+	defer memberCachesMu.Unlock()
+	stats := map[string]interface{}{}
+	for ws, mc := range memberCaches {
+		mc.mu.Lock()
+		entry := map[string]interface{}{"members": len(mc.names), "loads": mc.loads}
+		if !mc.loaded.IsZero() {
+			entry["ageMs"] = time.Since(mc.loaded).Milliseconds()
+		}
+		mc.mu.Unlock()
+		stats[string(ws)] = entry
+	}
+	return stats
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Every user who has ever joined is indexed in chat:users:lex, a zset where
+// all scores are 0 so ZRANGEBYLEX can do prefix lookups. Entries look like
+// "<lowercased search term>\x00<username>" and exist for both the username
+// and the display name. chat:users:activity scores users by last activity.
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	// How many of the most recently active users, and at most how many
+	// online ones, member search looks at besides the index's first
+	// matches.
+	searchCandidates = 500
+)
+
+func lexEntry(term, name string) string {
+	return strings.ToLower(term) + "\x00" + name
+}
+
+func indexUser(ctx context.Context, ws workspace, name string) {
+	rdb.ZAdd(ctx, ws.usersLexKey(), redis.Z{Member: lexEntry(name, name)})
+	touchActivity(ctx, ws, name)
+}
+
+func touchActivity(ctx context.Context, ws workspace, name string) {
+	rdb.ZAdd(ctx, ws.usersActivityKey(), redis.Z{Score: float64(time.Now().Unix()), Member: name})
+}
+
+func handleProfileUpdate(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+
+	var req protocol.ProfileUpdateRequest
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid profile_update frame")
+		return
+	}
+	displayName := strings.TrimSpace(req.DisplayName)
+	ws := c.ws
+
+	if old, _ := rdb.HGet(ctx, ws.profileKey(name), "displayName").Result(); old != "" {
+		rdb.ZRem(ctx, ws.usersLexKey(), lexEntry(old, name))
+	}
+	if displayName == "" {
+		rdb.HDel(ctx, ws.profileKey(name), "displayName")
+		return
+	}
+	rdb.HSet(ctx, ws.profileKey(name), "displayName", displayName)
+	rdb.ZAdd(ctx, ws.usersLexKey(), redis.Z{Member: lexEntry(displayName, name)})
+}
+
+func handleMemberSearch(c *client, data []byte) {
+	ctx := c.ctx
+	var req protocol.MemberSearchRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid member_search frame")
+		return
+	}
+
+	c.writeJSON(protocol.NewMemberSearch(req.Prefix, searchMembers(ctx, c.ws, req.Prefix, req.Room, req.Limit)))
+}
+
+// GET /api/members?prefix=al&limit=10&room=general&workspace=acme
+func handleMembersAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"prefix":  q.Get("prefix"),
+		"results": searchMembers(ctx, ws, q.Get("prefix"), q.Get("room"), limit),
+	})
+}
+
+// searchMembers returns users whose name or display name starts with prefix
+// (case-insensitively), online users first, then by most recent activity.
+// Candidates are the first matches in the index, plus online users and the
+// searchCandidates most recently active users whose names match, so an
+// active user isn't left out for coming late in the alphabet. Display names
+// are only matched through the index.
+func searchMembers(ctx context.Context, ws workspace, prefix, room string, limit int) []protocol.MemberMatch {
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	prefix = strings.ToLower(prefix)
+
+	// Over-fetch so that dedup, room filtering and ranking have something to
+	// work with, without ever walking the whole index.
+	entries, _ := rdb.ZRangeByLex(ctx, ws.usersLexKey(), &redis.ZRangeBy{
+		Min:   "[" + prefix,
+		Max:   "[" + prefix + "\xff",
+		Count: int64(limit * 5),
+	}).Result()
+
+	var names []string
+	seen := map[string]bool{}
+	for _, e := range entries {
+		_, name, ok := strings.Cut(e, "\x00")
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	online := onlineMembers(ctx, ws)
+	recent, _ := rdb.ZRevRange(ctx, ws.usersActivityKey(), 0, searchCandidates-1).Result()
+	for _, list := range [][]string{online, recent} {
+		added := 0
+		for _, name := range list {
+			if added == searchCandidates {
+				break
+			}
+			if seen[name] || !strings.HasPrefix(strings.ToLower(name), prefix) {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+			added++
+		}
+	}
+	if len(names) == 0 {
+		return []protocol.MemberMatch{}
+	}
+
+	pipe := rdb.Pipeline()
+	activity := make([]*redis.FloatCmd, len(names))
+	displayNames := make([]*redis.StringCmd, len(names))
+	inRoom := make([]*redis.BoolCmd, len(names))
+	statuses := make([]*redis.StringCmd, len(names))
+	for i, name := range names {
+		activity[i] = pipe.ZScore(ctx, ws.usersActivityKey(), name)
+		displayNames[i] = pipe.HGet(ctx, ws.profileKey(name), "displayName")
+		statuses[i] = pipe.HGet(ctx, ws.statusKey(name), "status")
+		if room != "" {
+			inRoom[i] = pipe.SIsMember(ctx, ws.roomMembersKey(room), name)
+		}
+	}
+	pipe.Exec(ctx)
+
+	type ranked struct {
+		protocol.MemberMatch
+		lastActive float64
+	}
+	var results []ranked
+	for i, name := range names {
+		if room != "" && !inRoom[i].Val() {
+			continue
+		}
+		deactivated := statuses[i].Val() == statusDeactivated
+		if deactivated && cfg().DeactivatedMembers == deactivatedMembersHide {
+			continue
+		}
+		results = append(results, ranked{
+			MemberMatch: protocol.MemberMatch{Name: name, DisplayName: displayNames[i].Val(), Online: isOnline(online, name), Deactivated: deactivated},
+			lastActive:  activity[i].Val(),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Online != results[j].Online {
+			return results[i].Online
+		}
+		return results[i].lastActive > results[j].lastActive
+	})
+
+	matches := []protocol.MemberMatch{}
+	for i := 0; i < len(results) && i < limit; i++ {
+		matches = append(matches, results[i].MemberMatch)
+	}
+	return matches
+}
@@ -0,0 +1,50 @@
+package main_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestMemberSearchRanking seeds more long-inactive users matching a prefix
+// than a search looks at alphabetically, all sorting before the active
+// ones, and checks that an online user and a recently active one still
+// come first.
+func TestMemberSearchRanking(t *testing.T) {
+	store := serveStore(t, 0)
+	rdb := redis.NewClient(&redis.Options{Addr: store})
+	defer rdb.Close()
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("ala%03d", i)
+		rdb.ZAdd(ctx, "chat:users:lex", redis.Z{Member: name + "\x00" + name})
+		rdb.ZAdd(ctx, "chat:users:activity", redis.Z{Score: 1600000000, Member: name})
+	}
+	rdb.ZAdd(ctx, "chat:users:lex", redis.Z{Member: "aly\x00aly"})
+	rdb.ZAdd(ctx, "chat:users:activity", redis.Z{Score: float64(time.Now().Unix()), Member: "aly"})
+	addr := startServer(t, store)
+	alz := dial(t, addr, "", "alz")
+	alz.Send("alz", "here")
+	await(t, alz, "message")
+
+	status, body := api(t, addr, http.MethodGet, "/api/members?prefix=AL&limit=5", nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /api/members: %d %s", status, body)
+	}
+	var res struct {
+		Results []protocol.MemberMatch `json:"results"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("%s: %v", body, err)
+	}
+	if len(res.Results) != 5 || res.Results[0].Name != "alz" || !res.Results[0].Online || res.Results[1].Name != "aly" || res.Results[1].Online {
+		t.Errorf("got %s, want alz (online), then aly, then three others", body)
+	}
+}
@@ -0,0 +1,489 @@
+// Package memredis is an in-process stand-in for the subset of Redis the chat
+// server uses. It speaks RESP2 over net.Pipe, so the regular go-redis client
+// can talk to it through Options.Dialer and every code path stays the same as
+// against a real server. Nothing is persisted.
+package memredis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server holds the keyspace and pub/sub state shared by all connections.
+type Server struct {
+	mu   sync.Mutex
+	data map[string]*entry
+
+	subMu    sync.Mutex
+	channels map[string]map[*conn]bool
+	patterns map[string]map[*conn]bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		data:     make(map[string]*entry),
+		channels: make(map[string]map[*conn]bool),
+		patterns: make(map[string]map[*conn]bool),
+	}
+}
+
+// Dial matches redis.Options.Dialer and returns one end of an in-memory pipe
+// whose other end is served by s.
+func (s *Server) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	c := &conn{
+		srv:     s,
+		wake:    make(chan struct{}, 1),
+		drained: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.writeLoop(server)
+	go c.readLoop(server)
+	return client, nil
+}
+
+// maxDelivering is how many frames may wait to be written before pub/sub
+// messages wait for room; see deliver.
+const maxDelivering = 1024
+
+type conn struct {
+	srv *Server
+
+	// Frames waiting to be written. Replies are queued without bound: a
+	// client writes a whole pipeline before it reads any of them, so the
+	// reader must never wait on the writer.
+	outMu   sync.Mutex
+	out     [][]byte
+	wake    chan struct{} // signalled when out gets a frame
+	drained chan struct{} // closed (and replaced) when the writer takes out
+
+	done chan struct{} // closed when the read side goes away
+
+	// Only touched from readLoop.
+	multi    bool
+	queued   [][][]byte
+	subs     map[string]bool
+	psubs    map[string]bool
+	inPubSub bool
+}
+
+func (c *conn) writeLoop(nc net.Conn) {
+	defer nc.Close()
+	w := bufio.NewWriter(nc)
+	for {
+		select {
+		case <-c.wake:
+		case <-c.done:
+			return
+		}
+		c.outMu.Lock()
+		frames := c.out
+		c.out = nil
+		close(c.drained)
+		c.drained = make(chan struct{})
+		c.outMu.Unlock()
+		for _, b := range frames {
+			if _, err := w.Write(b); err != nil {
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// enqueue queues frame for the writer, unless limit (if positive) frames
+// are already waiting. If not, it returns a channel closed once they have
+// been taken.
+func (c *conn) enqueue(frame []byte, limit int) (queued bool, drained <-chan struct{}) {
+	c.outMu.Lock()
+	if limit > 0 && len(c.out) >= limit {
+		defer c.outMu.Unlock()
+		return false, c.drained
+	}
+	c.out = append(c.out, frame)
+	c.outMu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+func (c *conn) send(v interface{}) {
+	c.enqueue(appendReply(nil, v), 0)
+}
+
+func (c *conn) readLoop(nc net.Conn) {
+	r := bufio.NewReader(nc)
+	defer func() {
+		c.srv.unsubscribeAll(c)
+		close(c.done)
+		nc.Close()
+	}()
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+				c.send(errReply("ERR protocol error: " + err.Error()))
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if quit := c.handle(args); quit {
+			return
+		}
+	}
+}
+
+// handle runs one command and reports whether the connection should close.
+func (c *conn) handle(args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+
+	switch name {
+	case "QUIT":
+		c.send(simpleString("OK"))
+		return true
+	case "MULTI":
+		if c.multi {
+			c.send(errReply("ERR MULTI calls can not be nested"))
+			return false
+		}
+		c.multi = true
+		c.queued = nil
+		c.send(simpleString("OK"))
+		return false
+	case "DISCARD":
+		if !c.multi {
+			c.send(errReply("ERR DISCARD without MULTI"))
+			return false
+		}
+		c.multi = false
+		c.queued = nil
+		c.send(simpleString("OK"))
+		return false
+	case "EXEC":
+		if !c.multi {
+			c.send(errReply("ERR EXEC without MULTI"))
+			return false
+		}
+		c.multi = false
+		queued := c.queued
+		c.queued = nil
+		c.srv.mu.Lock()
+		results := make([]interface{}, len(queued))
+		for i, q := range queued {
+			results[i] = c.srv.exec(strings.ToUpper(string(q[0])), q[1:])
+		}
+		c.srv.mu.Unlock()
+		c.send(results)
+		return false
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		c.pubsub(name, args[1:])
+		return false
+	case "PING":
+		if c.inPubSub {
+			msg := []byte{}
+			if len(args) > 1 {
+				msg = args[1]
+			}
+			c.send([]interface{}{[]byte("pong"), msg})
+			return false
+		}
+	case "PUBLISH":
+		if len(args) != 3 {
+			c.send(wrongArgs(name))
+			return false
+		}
+		c.send(int64(c.srv.publish(string(args[1]), args[2])))
+		return false
+	}
+
+	if c.inPubSub && name != "PING" {
+		c.send(errReply("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"))
+		return false
+	}
+
+	if c.multi {
+		if _, ok := commands[name]; !ok {
+			c.send(errReply("ERR unknown command '" + strings.ToLower(name) + "'"))
+			return false
+		}
+		c.queued = append(c.queued, args)
+		c.send(simpleString("QUEUED"))
+		return false
+	}
+
+	c.srv.mu.Lock()
+	reply := c.srv.exec(name, args[1:])
+	c.srv.mu.Unlock()
+	c.send(reply)
+	return false
+}
+
+// exec runs a data command. The caller holds s.mu.
+func (s *Server) exec(name string, args [][]byte) interface{} {
+	cmd, ok := commands[name]
+	if !ok {
+		return errReply("ERR unknown command '" + strings.ToLower(name) + "'")
+	}
+	if len(args) < cmd.minArgs {
+		return wrongArgs(name)
+	}
+	return cmd.fn(s, args)
+}
+
+func (c *conn) pubsub(name string, args [][]byte) {
+	if c.subs == nil {
+		c.subs = make(map[string]bool)
+		c.psubs = make(map[string]bool)
+	}
+	s := c.srv
+
+	switch name {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		pattern := name == "PSUBSCRIBE"
+		kind := "subscribe"
+		if pattern {
+			kind = "psubscribe"
+		}
+		for _, a := range args {
+			ch := string(a)
+			s.subMu.Lock()
+			if pattern {
+				c.psubs[ch] = true
+				addSub(s.patterns, ch, c)
+			} else {
+				c.subs[ch] = true
+				addSub(s.channels, ch, c)
+			}
+			s.subMu.Unlock()
+			c.send([]interface{}{[]byte(kind), []byte(ch), int64(len(c.subs) + len(c.psubs))})
+		}
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		pattern := name == "PUNSUBSCRIBE"
+		kind := "unsubscribe"
+		set := c.subs
+		if pattern {
+			kind = "punsubscribe"
+			set = c.psubs
+		}
+		if len(args) == 0 {
+			for ch := range set {
+				args = append(args, []byte(ch))
+			}
+		}
+		if len(args) == 0 {
+			c.send([]interface{}{[]byte(kind), nil, int64(len(c.subs) + len(c.psubs))})
+		}
+		for _, a := range args {
+			ch := string(a)
+			s.subMu.Lock()
+			delete(set, ch)
+			if pattern {
+				removeSub(s.patterns, ch, c)
+			} else {
+				removeSub(s.channels, ch, c)
+			}
+			s.subMu.Unlock()
+			c.send([]interface{}{[]byte(kind), []byte(ch), int64(len(c.subs) + len(c.psubs))})
+		}
+	}
+	c.inPubSub = len(c.subs)+len(c.psubs) > 0
+}
+
+func addSub(m map[string]map[*conn]bool, ch string, c *conn) {
+	if m[ch] == nil {
+		m[ch] = make(map[*conn]bool)
+	}
+	m[ch][c] = true
+}
+
+func removeSub(m map[string]map[*conn]bool, ch string, c *conn) {
+	delete(m[ch], c)
+	if len(m[ch]) == 0 {
+		delete(m, ch)
+	}
+}
+
+func (s *Server) unsubscribeAll(c *conn) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for ch := range c.subs {
+		removeSub(s.channels, ch, c)
+	}
+	for p := range c.psubs {
+		removeSub(s.patterns, p, c)
+	}
+}
+
+type delivery struct {
+	c     *conn
+	frame []byte
+}
+
+func (s *Server) publish(channel string, payload []byte) int {
+	var out []delivery
+
+	s.subMu.Lock()
+	for c := range s.channels[channel] {
+		out = append(out, delivery{c, appendReply(nil, []interface{}{[]byte("message"), []byte(channel), payload})})
+	}
+	for p, conns := range s.patterns {
+		if !matchGlob(p, channel) {
+			continue
+		}
+		for c := range conns {
+			out = append(out, delivery{c, appendReply(nil, []interface{}{[]byte("pmessage"), []byte(p), []byte(channel), payload})})
+		}
+	}
+	s.subMu.Unlock()
+
+	for _, d := range out {
+		d.c.deliver(d.frame)
+	}
+	return len(out)
+}
+
+// deliver queues a pub/sub frame. Like Redis with a stuck subscriber, a
+// client that stops reading eventually loses messages rather than blocking
+// publishers forever.
+func (c *conn) deliver(frame []byte) {
+	timeout := time.After(5 * time.Second)
+	for {
+		queued, drained := c.enqueue(frame, maxDelivering)
+		if queued {
+			return
+		}
+		select {
+		case <-drained:
+		case <-c.done:
+			return
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// RESP encoding.
+
+type simpleString string
+
+type errReply string
+
+func wrongArgs(name string) errReply {
+	return errReply("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+}
+
+var (
+	errWrongType  = errReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger = errReply("ERR value is not an integer or out of range")
+	errNotFloat   = errReply("ERR value is not a valid float")
+	errSyntax     = errReply("ERR syntax error")
+)
+
+// nilArray is encoded as a RESP2 null array.
+type nilArray struct{}
+
+func appendReply(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "$-1\r\n"...)
+	case nilArray:
+		return append(b, "*-1\r\n"...)
+	case simpleString:
+		return append(append(append(b, '+'), v...), "\r\n"...)
+	case errReply:
+		return append(append(append(b, '-'), v...), "\r\n"...)
+	case int64:
+		return append(strconv.AppendInt(append(b, ':'), v, 10), "\r\n"...)
+	case int:
+		return appendReply(b, int64(v))
+	case []byte:
+		b = strconv.AppendInt(append(b, '$'), int64(len(v)), 10)
+		return append(append(append(b, "\r\n"...), v...), "\r\n"...)
+	case string:
+		return appendReply(b, []byte(v))
+	case []string:
+		b = strconv.AppendInt(append(b, '*'), int64(len(v)), 10)
+		b = append(b, "\r\n"...)
+		for _, e := range v {
+			b = appendReply(b, []byte(e))
+		}
+		return b
+	case []interface{}:
+		b = strconv.AppendInt(append(b, '*'), int64(len(v)), 10)
+		b = append(b, "\r\n"...)
+		for _, e := range v {
+			b = appendReply(b, e)
+		}
+		return b
+	}
+	panic("memredis: unsupported reply type")
+}
+
+// readCommand reads one RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		// Inline command, e.g. from a human on a raw connection.
+		fields := strings.Fields(string(line))
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = []byte(f)
+		}
+		return args, nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > 1<<20 {
+		return nil, errors.New("invalid multibulk length")
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.New("expected '$'")
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > 512<<20 {
+			return nil, errors.New("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
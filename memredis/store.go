@@ -0,0 +1,1281 @@
+package memredis
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type entry struct {
+	value   interface{} // []byte, hash, set, *zset, *stream
+	expires time.Time
+}
+
+type (
+	hash map[string][]byte
+	set  map[string]struct{}
+)
+
+type zset struct {
+	scores map[string]float64
+	sorted []zmember // rebuilt lazily after writes
+}
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+func newZset() *zset { return &zset{scores: make(map[string]float64)} }
+
+func (z *zset) add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if !exists || old != score {
+		z.scores[member] = score
+		z.sorted = nil
+	}
+	return !exists
+}
+
+func (z *zset) remove(member string) bool {
+	if _, ok := z.scores[member]; !ok {
+		return false
+	}
+	delete(z.scores, member)
+	z.sorted = nil
+	return true
+}
+
+func (z *zset) items() []zmember {
+	if z.sorted == nil {
+		z.sorted = make([]zmember, 0, len(z.scores))
+		for m, s := range z.scores {
+			z.sorted = append(z.sorted, zmember{m, s})
+		}
+		sort.Slice(z.sorted, func(i, j int) bool {
+			a, b := z.sorted[i], z.sorted[j]
+			if a.score != b.score {
+				return a.score < b.score
+			}
+			return a.member < b.member
+		})
+	}
+	return z.sorted
+}
+
+// lookup returns the live entry for key, dropping it if it has expired.
+func (s *Server) lookup(key string) *entry {
+	e, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(s.data, key)
+		return nil
+	}
+	return e
+}
+
+func (s *Server) getString(key string) ([]byte, errReply) {
+	e := s.lookup(key)
+	if e == nil {
+		return nil, ""
+	}
+	b, ok := e.value.([]byte)
+	if !ok {
+		return nil, errWrongType
+	}
+	return b, ""
+}
+
+func (s *Server) getHash(key string, create bool) (hash, errReply) {
+	e := s.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, ""
+		}
+		h := hash{}
+		s.data[key] = &entry{value: h}
+		return h, ""
+	}
+	h, ok := e.value.(hash)
+	if !ok {
+		return nil, errWrongType
+	}
+	return h, ""
+}
+
+func (s *Server) getSet(key string, create bool) (set, errReply) {
+	e := s.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, ""
+		}
+		st := set{}
+		s.data[key] = &entry{value: st}
+		return st, ""
+	}
+	st, ok := e.value.(set)
+	if !ok {
+		return nil, errWrongType
+	}
+	return st, ""
+}
+
+func (s *Server) getZset(key string, create bool) (*zset, errReply) {
+	e := s.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, ""
+		}
+		z := newZset()
+		s.data[key] = &entry{value: z}
+		return z, ""
+	}
+	z, ok := e.value.(*zset)
+	if !ok {
+		return nil, errWrongType
+	}
+	return z, ""
+}
+
+// dropIfEmpty removes container keys that lost their last element, as Redis does.
+func (s *Server) dropIfEmpty(key string) {
+	e, ok := s.data[key]
+	if !ok {
+		return
+	}
+	empty := false
+	switch v := e.value.(type) {
+	case hash:
+		empty = len(v) == 0
+	case set:
+		empty = len(v) == 0
+	case *zset:
+		empty = len(v.scores) == 0
+	}
+	if empty {
+		delete(s.data, key)
+	}
+}
+
+type command struct {
+	minArgs int
+	fn      func(s *Server, args [][]byte) interface{}
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":   {0, cmdPing},
+		"ECHO":   {1, func(s *Server, a [][]byte) interface{} { return a[0] }},
+		"HELLO":  {0, func(s *Server, a [][]byte) interface{} { return errReply("ERR unknown command 'hello'") }},
+		"CLIENT": {1, func(s *Server, a [][]byte) interface{} { return simpleString("OK") }},
+		"SELECT": {1, func(s *Server, a [][]byte) interface{} { return simpleString("OK") }},
+
+		"GET":    {1, cmdGet},
+		"SET":    {2, cmdSet},
+		"INCR":   {1, func(s *Server, a [][]byte) interface{} { return s.incrBy(a[0], 1) }},
+		"DECR":   {1, func(s *Server, a [][]byte) interface{} { return s.incrBy(a[0], -1) }},
+		"INCRBY": {2, cmdIncrBy},
+		"DECRBY": {2, cmdDecrBy},
+
+		"DEL":     {1, cmdDel},
+		"EXISTS":  {1, cmdExists},
+		"EXPIRE":  {2, expireCmd(time.Second)},
+		"PEXPIRE": {2, expireCmd(time.Millisecond)},
+		"TTL":     {1, ttlCmd(time.Second)},
+		"PTTL":    {1, ttlCmd(time.Millisecond)},
+		"PERSIST": {1, cmdPersist},
+		"TYPE":    {1, cmdType},
+		"SCAN":    {1, cmdScan},
+		"RENAME":  {2, cmdRename},
+
+		"HSET":    {3, cmdHSet},
+		"HSETNX":  {3, cmdHSetNX},
+		"HGET":    {2, cmdHGet},
+		"HMGET":   {2, cmdHMGet},
+		"HGETALL": {1, cmdHGetAll},
+		"HDEL":    {2, cmdHDel},
+		"HEXISTS": {2, cmdHExists},
+		"HLEN":    {1, cmdHLen},
+		"HINCRBY": {3, cmdHIncrBy},
+		"HSCAN":   {2, cmdHScan},
+
+		"SADD":      {2, cmdSAdd},
+		"SREM":      {2, cmdSRem},
+		"SMEMBERS":  {1, cmdSMembers},
+		"SISMEMBER": {2, cmdSIsMember},
+		"SCARD":     {1, cmdSCard},
+		"SUNION":    {1, cmdSUnion},
+		"SSCAN":     {2, cmdSScan},
+
+		"ZADD":             {3, cmdZAdd},
+		"ZINCRBY":          {3, cmdZIncrBy},
+		"ZREM":             {2, cmdZRem},
+		"ZSCORE":           {2, cmdZScore},
+		"ZCARD":            {1, cmdZCard},
+		"ZCOUNT":           {3, cmdZCount},
+		"ZRANGE":           {3, cmdZRange},
+		"ZREVRANGE":        {3, cmdZRevRange},
+		"ZRANGEBYSCORE":    {3, cmdZRangeByScore},
+		"ZREVRANGEBYSCORE": {3, cmdZRevRangeByScore},
+		"ZRANGEBYLEX":      {3, cmdZRangeByLex},
+		"ZREMRANGEBYRANK":  {3, cmdZRemRangeByRank},
+		"ZREMRANGEBYSCORE": {3, cmdZRemRangeByScore},
+		"ZSCAN":            {2, cmdZScan},
+
+		"XADD":      {4, cmdXAdd},
+		"XLEN":      {1, cmdXLen},
+		"XRANGE":    {3, cmdXRange},
+		"XREVRANGE": {3, cmdXRevRange},
+	}
+}
+
+func cmdPing(s *Server, a [][]byte) interface{} {
+	if len(a) > 0 {
+		return a[0]
+	}
+	return simpleString("PONG")
+}
+
+// Strings.
+
+func cmdGet(s *Server, a [][]byte) interface{} {
+	b, err := s.getString(string(a[0]))
+	if err != "" {
+		return err
+	}
+	if b == nil {
+		return nil
+	}
+	return b
+}
+
+func cmdSet(s *Server, a [][]byte) interface{} {
+	key := string(a[0])
+	var expires time.Time
+	var nx, xx, keepTTL bool
+	for i := 2; i < len(a); i++ {
+		switch strings.ToUpper(string(a[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX":
+			if i+1 >= len(a) {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(string(a[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				return errNotInteger
+			}
+			unit := time.Second
+			if strings.ToUpper(string(a[i])) == "PX" {
+				unit = time.Millisecond
+			}
+			expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	existing := s.lookup(key)
+	if (nx && existing != nil) || (xx && existing == nil) {
+		return nil
+	}
+	if keepTTL && existing != nil {
+		expires = existing.expires
+	}
+	s.data[key] = &entry{value: append([]byte(nil), a[1]...), expires: expires}
+	return simpleString("OK")
+}
+
+func (s *Server) incrBy(key []byte, by int64) interface{} {
+	b, err := s.getString(string(key))
+	if err != "" {
+		return err
+	}
+	var n int64
+	if b != nil {
+		var perr error
+		if n, perr = strconv.ParseInt(string(b), 10, 64); perr != nil {
+			return errNotInteger
+		}
+	}
+	n += by
+	if e := s.lookup(string(key)); e != nil {
+		e.value = []byte(strconv.FormatInt(n, 10))
+	} else {
+		s.data[string(key)] = &entry{value: []byte(strconv.FormatInt(n, 10))}
+	}
+	return n
+}
+
+func cmdIncrBy(s *Server, a [][]byte) interface{} {
+	by, err := strconv.ParseInt(string(a[1]), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	return s.incrBy(a[0], by)
+}
+
+func cmdDecrBy(s *Server, a [][]byte) interface{} {
+	by, err := strconv.ParseInt(string(a[1]), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	return s.incrBy(a[0], -by)
+}
+
+// Keys.
+
+func cmdDel(s *Server, a [][]byte) interface{} {
+	var n int64
+	for _, k := range a {
+		if s.lookup(string(k)) != nil {
+			delete(s.data, string(k))
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Server, a [][]byte) interface{} {
+	var n int64
+	for _, k := range a {
+		if s.lookup(string(k)) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func expireCmd(unit time.Duration) func(s *Server, a [][]byte) interface{} {
+	return func(s *Server, a [][]byte) interface{} {
+		n, err := strconv.ParseInt(string(a[1]), 10, 64)
+		if err != nil {
+			return errNotInteger
+		}
+		e := s.lookup(string(a[0]))
+		if e == nil {
+			return int64(0)
+		}
+		if n <= 0 {
+			delete(s.data, string(a[0]))
+			return int64(1)
+		}
+		e.expires = time.Now().Add(time.Duration(n) * unit)
+		return int64(1)
+	}
+}
+
+func ttlCmd(unit time.Duration) func(s *Server, a [][]byte) interface{} {
+	return func(s *Server, a [][]byte) interface{} {
+		e := s.lookup(string(a[0]))
+		if e == nil {
+			return int64(-2)
+		}
+		if e.expires.IsZero() {
+			return int64(-1)
+		}
+		return int64(math.Ceil(float64(time.Until(e.expires)) / float64(unit)))
+	}
+}
+
+func cmdPersist(s *Server, a [][]byte) interface{} {
+	e := s.lookup(string(a[0]))
+	if e == nil || e.expires.IsZero() {
+		return int64(0)
+	}
+	e.expires = time.Time{}
+	return int64(1)
+}
+
+func typeName(e *entry) string {
+	switch e.value.(type) {
+	case []byte:
+		return "string"
+	case hash:
+		return "hash"
+	case set:
+		return "set"
+	case *zset:
+		return "zset"
+	case *stream:
+		return "stream"
+	}
+	return "none"
+}
+
+func cmdType(s *Server, a [][]byte) interface{} {
+	e := s.lookup(string(a[0]))
+	if e == nil {
+		return simpleString("none")
+	}
+	return simpleString(typeName(e))
+}
+
+// cmdScan walks keys in sorted order; the cursor is the index of the next key.
+func cmdScan(s *Server, a [][]byte) interface{} {
+	cursor, err := strconv.Atoi(string(a[0]))
+	if err != nil || cursor < 0 {
+		return errReply("ERR invalid cursor")
+	}
+	match, typ, count := "*", "", 10
+	for i := 1; i+1 < len(a); i += 2 {
+		switch strings.ToUpper(string(a[i])) {
+		case "MATCH":
+			match = string(a[i+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(a[i+1])); err != nil || count <= 0 {
+				return errSyntax
+			}
+		case "TYPE":
+			typ = strings.ToLower(string(a[i+1]))
+		default:
+			return errSyntax
+		}
+	}
+
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var found []string
+	i := cursor
+	for ; i < len(keys) && i < cursor+count; i++ {
+		e := s.lookup(keys[i])
+		if e == nil || !matchGlob(match, keys[i]) || (typ != "" && typeName(e) != typ) {
+			continue
+		}
+		found = append(found, keys[i])
+	}
+	next := i
+	if next >= len(keys) {
+		next = 0
+	}
+	return []interface{}{[]byte(strconv.Itoa(next)), found}
+}
+
+// scanPage pages through the sorted names using the cursor and MATCH/COUNT
+// options in a (everything after the key).
+func scanPage(names []string, a [][]byte) ([]string, int, errReply) {
+	cursor, err := strconv.Atoi(string(a[0]))
+	if err != nil || cursor < 0 {
+		return nil, 0, errReply("ERR invalid cursor")
+	}
+	match, count := "*", 10
+	for i := 1; i+1 < len(a); i += 2 {
+		switch strings.ToUpper(string(a[i])) {
+		case "MATCH":
+			match = string(a[i+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(a[i+1])); err != nil || count <= 0 {
+				return nil, 0, errSyntax
+			}
+		default:
+			return nil, 0, errSyntax
+		}
+	}
+	sort.Strings(names)
+	var page []string
+	i := cursor
+	for ; i < len(names) && i < cursor+count; i++ {
+		if matchGlob(match, names[i]) {
+			page = append(page, names[i])
+		}
+	}
+	if i >= len(names) {
+		i = 0
+	}
+	return page, i, ""
+}
+
+func cmdRename(s *Server, a [][]byte) interface{} {
+	e := s.lookup(string(a[0]))
+	if e == nil {
+		return errReply("ERR no such key")
+	}
+	delete(s.data, string(a[0]))
+	s.data[string(a[1])] = e
+	return simpleString("OK")
+}
+
+// Hashes.
+
+func cmdHSet(s *Server, a [][]byte) interface{} {
+	if len(a)%2 != 1 {
+		return wrongArgs("hset")
+	}
+	h, err := s.getHash(string(a[0]), true)
+	if err != "" {
+		return err
+	}
+	var added int64
+	for i := 1; i+1 < len(a); i += 2 {
+		if _, ok := h[string(a[i])]; !ok {
+			added++
+		}
+		h[string(a[i])] = append([]byte(nil), a[i+1]...)
+	}
+	return added
+}
+
+func cmdHSetNX(s *Server, a [][]byte) interface{} {
+	if len(a) != 3 {
+		return wrongArgs("hsetnx")
+	}
+	h, err := s.getHash(string(a[0]), true)
+	if err != "" {
+		return err
+	}
+	if _, ok := h[string(a[1])]; ok {
+		return int64(0)
+	}
+	h[string(a[1])] = append([]byte(nil), a[2]...)
+	return int64(1)
+}
+
+func cmdHGet(s *Server, a [][]byte) interface{} {
+	h, err := s.getHash(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	v, ok := h[string(a[1])]
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+func cmdHMGet(s *Server, a [][]byte) interface{} {
+	h, err := s.getHash(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	out := make([]interface{}, len(a)-1)
+	for i, f := range a[1:] {
+		if v, ok := h[string(f)]; ok {
+			out[i] = v
+		}
+	}
+	return out
+}
+
+func cmdHGetAll(s *Server, a [][]byte) interface{} {
+	h, err := s.getHash(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	out := make([]interface{}, 0, 2*len(h))
+	for f, v := range h {
+		out = append(out, []byte(f), v)
+	}
+	return out
+}
+
+func cmdHDel(s *Server, a [][]byte) interface{} {
+	h, err := s.getHash(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for _, f := range a[1:] {
+		if _, ok := h[string(f)]; ok {
+			delete(h, string(f))
+			n++
+		}
+	}
+	s.dropIfEmpty(string(a[0]))
+	return n
+}
+
+func cmdHExists(s *Server, a [][]byte) interface{} {
+	h, err := s.getHash(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	if _, ok := h[string(a[1])]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func cmdHLen(s *Server, a [][]byte) interface{} {
+	h, err := s.getHash(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	return int64(len(h))
+}
+
+func cmdHIncrBy(s *Server, a [][]byte) interface{} {
+	by, perr := strconv.ParseInt(string(a[2]), 10, 64)
+	if perr != nil {
+		return errNotInteger
+	}
+	h, err := s.getHash(string(a[0]), true)
+	if err != "" {
+		return err
+	}
+	var n int64
+	if v, ok := h[string(a[1])]; ok {
+		if n, perr = strconv.ParseInt(string(v), 10, 64); perr != nil {
+			return errReply("ERR hash value is not an integer")
+		}
+	}
+	n += by
+	h[string(a[1])] = []byte(strconv.FormatInt(n, 10))
+	return n
+}
+
+func cmdHScan(s *Server, a [][]byte) interface{} {
+	h, err := s.getHash(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	page, next, err := scanPage(fields, a[1:])
+	if err != "" {
+		return err
+	}
+	out := make([]interface{}, 0, 2*len(page))
+	for _, f := range page {
+		out = append(out, []byte(f), h[f])
+	}
+	return []interface{}{[]byte(strconv.Itoa(next)), out}
+}
+
+// Sets.
+
+func cmdSAdd(s *Server, a [][]byte) interface{} {
+	st, err := s.getSet(string(a[0]), true)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for _, m := range a[1:] {
+		if _, ok := st[string(m)]; !ok {
+			st[string(m)] = struct{}{}
+			n++
+		}
+	}
+	return n
+}
+
+func cmdSRem(s *Server, a [][]byte) interface{} {
+	st, err := s.getSet(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for _, m := range a[1:] {
+		if _, ok := st[string(m)]; ok {
+			delete(st, string(m))
+			n++
+		}
+	}
+	s.dropIfEmpty(string(a[0]))
+	return n
+}
+
+func cmdSMembers(s *Server, a [][]byte) interface{} {
+	st, err := s.getSet(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	out := make([]string, 0, len(st))
+	for m := range st {
+		out = append(out, m)
+	}
+	return out
+}
+
+func cmdSIsMember(s *Server, a [][]byte) interface{} {
+	st, err := s.getSet(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	if _, ok := st[string(a[1])]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func cmdSCard(s *Server, a [][]byte) interface{} {
+	st, err := s.getSet(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	return int64(len(st))
+}
+
+func cmdSUnion(s *Server, a [][]byte) interface{} {
+	union := set{}
+	for _, k := range a {
+		st, err := s.getSet(string(k), false)
+		if err != "" {
+			return err
+		}
+		for m := range st {
+			union[m] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(union))
+	for m := range union {
+		out = append(out, m)
+	}
+	return out
+}
+
+func cmdSScan(s *Server, a [][]byte) interface{} {
+	st, err := s.getSet(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	members := make([]string, 0, len(st))
+	for m := range st {
+		members = append(members, m)
+	}
+	page, next, err := scanPage(members, a[1:])
+	if err != "" {
+		return err
+	}
+	return []interface{}{[]byte(strconv.Itoa(next)), page}
+}
+
+// Sorted sets.
+
+func parseFloat(b []byte) (float64, bool) {
+	switch strings.ToLower(string(b)) {
+	case "+inf", "inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+func formatFloat(f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return []byte("inf")
+	case math.IsInf(f, -1):
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+func cmdZAdd(s *Server, a [][]byte) interface{} {
+	key := string(a[0])
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+flags:
+	for ; i < len(a); i++ {
+		switch strings.ToUpper(string(a[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+	pairs := a[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (incr && len(pairs) != 2) {
+		return errSyntax
+	}
+
+	z, err := s.getZset(key, true)
+	if err != "" {
+		return err
+	}
+	var changed int64
+	var last interface{}
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseFloat(pairs[j])
+		if !ok {
+			s.dropIfEmpty(key)
+			return errNotFloat
+		}
+		member := string(pairs[j+1])
+		old, exists := z.scores[member]
+		if (nx && exists) || (xx && !exists) {
+			last = nil
+			continue
+		}
+		if incr && exists {
+			score += old
+		}
+		if exists && ((gt && score <= old) || (lt && score >= old)) {
+			last = nil
+			continue
+		}
+		if z.add(member, score) || (ch && old != score) {
+			changed++
+		}
+		last = formatFloat(score)
+	}
+	s.dropIfEmpty(key)
+	if incr {
+		return last
+	}
+	return changed
+}
+
+func cmdZIncrBy(s *Server, a [][]byte) interface{} {
+	return cmdZAdd(s, [][]byte{a[0], []byte("INCR"), a[1], a[2]})
+}
+
+func cmdZRem(s *Server, a [][]byte) interface{} {
+	z, err := s.getZset(string(a[0]), false)
+	if err != "" || z == nil {
+		if err != "" {
+			return err
+		}
+		return int64(0)
+	}
+	var n int64
+	for _, m := range a[1:] {
+		if z.remove(string(m)) {
+			n++
+		}
+	}
+	s.dropIfEmpty(string(a[0]))
+	return n
+}
+
+func cmdZScore(s *Server, a [][]byte) interface{} {
+	z, err := s.getZset(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	if z == nil {
+		return nil
+	}
+	score, ok := z.scores[string(a[1])]
+	if !ok {
+		return nil
+	}
+	return formatFloat(score)
+}
+
+func cmdZCard(s *Server, a [][]byte) interface{} {
+	z, err := s.getZset(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	if z == nil {
+		return int64(0)
+	}
+	return int64(len(z.scores))
+}
+
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(b []byte) (scoreBound, bool) {
+	if len(b) > 0 && b[0] == '(' {
+		f, ok := parseFloat(b[1:])
+		return scoreBound{f, true}, ok
+	}
+	f, ok := parseFloat(b)
+	return scoreBound{f, false}, ok
+}
+
+func (b scoreBound) above(f float64) bool { // f satisfies b as a lower bound
+	if b.exclusive {
+		return f > b.value
+	}
+	return f >= b.value
+}
+
+func (b scoreBound) below(f float64) bool { // f satisfies b as an upper bound
+	if b.exclusive {
+		return f < b.value
+	}
+	return f <= b.value
+}
+
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int // -1 for "-", +1 for "+"
+}
+
+func parseLexBound(b []byte) (lexBound, bool) {
+	switch {
+	case string(b) == "-":
+		return lexBound{inf: -1}, true
+	case string(b) == "+":
+		return lexBound{inf: 1}, true
+	case len(b) > 0 && b[0] == '[':
+		return lexBound{value: string(b[1:])}, true
+	case len(b) > 0 && b[0] == '(':
+		return lexBound{value: string(b[1:]), exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+func (b lexBound) above(m string) bool {
+	switch b.inf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if b.exclusive {
+		return m > b.value
+	}
+	return m >= b.value
+}
+
+func (b lexBound) below(m string) bool {
+	switch b.inf {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+	if b.exclusive {
+		return m < b.value
+	}
+	return m <= b.value
+}
+
+func cmdZCount(s *Server, a [][]byte) interface{} {
+	min, ok1 := parseScoreBound(a[1])
+	max, ok2 := parseScoreBound(a[2])
+	if !ok1 || !ok2 {
+		return errReply("ERR min or max is not a float")
+	}
+	z, err := s.getZset(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	if z == nil {
+		return int64(0)
+	}
+	var n int64
+	for _, m := range z.items() {
+		if min.above(m.score) && max.below(m.score) {
+			n++
+		}
+	}
+	return n
+}
+
+type rangeOpts struct {
+	byScore, byLex, rev, withScores bool
+	offset, count                   int
+}
+
+// parseRangeOpts reads the trailing options shared by the ZRANGE family.
+func parseRangeOpts(a [][]byte, opts rangeOpts) (rangeOpts, errReply) {
+	opts.count = -1
+	for i := 0; i < len(a); i++ {
+		switch strings.ToUpper(string(a[i])) {
+		case "BYSCORE":
+			opts.byScore = true
+		case "BYLEX":
+			opts.byLex = true
+		case "REV":
+			opts.rev = true
+		case "WITHSCORES":
+			opts.withScores = true
+		case "LIMIT":
+			if i+2 >= len(a) {
+				return opts, errSyntax
+			}
+			var err1, err2 error
+			opts.offset, err1 = strconv.Atoi(string(a[i+1]))
+			opts.count, err2 = strconv.Atoi(string(a[i+2]))
+			if err1 != nil || err2 != nil {
+				return opts, errNotInteger
+			}
+			i += 2
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, ""
+}
+
+// zrange implements every ZRANGE variant. start and stop are ranks, score
+// bounds or lex bounds depending on opts; for rev they arrive as max, min.
+func (s *Server) zrange(key string, start, stop []byte, opts rangeOpts) interface{} {
+	z, err := s.getZset(key, false)
+	if err != "" {
+		return err
+	}
+	var items []zmember
+	if z != nil {
+		items = z.items()
+	}
+
+	var picked []zmember
+	switch {
+	case opts.byScore:
+		lo, hi := start, stop
+		if opts.rev {
+			lo, hi = stop, start
+		}
+		min, ok1 := parseScoreBound(lo)
+		max, ok2 := parseScoreBound(hi)
+		if !ok1 || !ok2 {
+			return errReply("ERR min or max is not a float")
+		}
+		for _, m := range items {
+			if min.above(m.score) && max.below(m.score) {
+				picked = append(picked, m)
+			}
+		}
+	case opts.byLex:
+		lo, hi := start, stop
+		if opts.rev {
+			lo, hi = stop, start
+		}
+		min, ok1 := parseLexBound(lo)
+		max, ok2 := parseLexBound(hi)
+		if !ok1 || !ok2 {
+			return errReply("ERR min or max not valid string range item")
+		}
+		for _, m := range items {
+			if min.above(m.member) && max.below(m.member) {
+				picked = append(picked, m)
+			}
+		}
+	default:
+		from, err1 := strconv.Atoi(string(start))
+		to, err2 := strconv.Atoi(string(stop))
+		if err1 != nil || err2 != nil {
+			return errNotInteger
+		}
+		n := len(items)
+		if from < 0 {
+			from += n
+		}
+		if to < 0 {
+			to += n
+		}
+		if from < 0 {
+			from = 0
+		}
+		if to >= n {
+			to = n - 1
+		}
+		if from <= to {
+			if opts.rev {
+				for i := n - 1 - from; i >= n-1-to; i-- {
+					picked = append(picked, items[i])
+				}
+			} else {
+				picked = append(picked, items[from:to+1]...)
+			}
+		}
+	}
+
+	if (opts.byScore || opts.byLex) && opts.rev {
+		for i, j := 0, len(picked)-1; i < j; i, j = i+1, j-1 {
+			picked[i], picked[j] = picked[j], picked[i]
+		}
+	}
+	if opts.count >= 0 || opts.offset > 0 {
+		if opts.offset >= len(picked) {
+			picked = nil
+		} else {
+			picked = picked[opts.offset:]
+			if opts.count >= 0 && opts.count < len(picked) {
+				picked = picked[:opts.count]
+			}
+		}
+	}
+
+	out := make([]interface{}, 0, len(picked))
+	for _, m := range picked {
+		out = append(out, []byte(m.member))
+		if opts.withScores {
+			out = append(out, formatFloat(m.score))
+		}
+	}
+	return out
+}
+
+func cmdZRange(s *Server, a [][]byte) interface{} {
+	opts, err := parseRangeOpts(a[3:], rangeOpts{})
+	if err != "" {
+		return err
+	}
+	return s.zrange(string(a[0]), a[1], a[2], opts)
+}
+
+func cmdZRevRange(s *Server, a [][]byte) interface{} {
+	opts, err := parseRangeOpts(a[3:], rangeOpts{rev: true})
+	if err != "" {
+		return err
+	}
+	return s.zrange(string(a[0]), a[1], a[2], opts)
+}
+
+func cmdZRangeByScore(s *Server, a [][]byte) interface{} {
+	opts, err := parseRangeOpts(a[3:], rangeOpts{byScore: true})
+	if err != "" {
+		return err
+	}
+	return s.zrange(string(a[0]), a[1], a[2], opts)
+}
+
+func cmdZRevRangeByScore(s *Server, a [][]byte) interface{} {
+	opts, err := parseRangeOpts(a[3:], rangeOpts{byScore: true, rev: true})
+	if err != "" {
+		return err
+	}
+	return s.zrange(string(a[0]), a[1], a[2], opts)
+}
+
+func cmdZRangeByLex(s *Server, a [][]byte) interface{} {
+	opts, err := parseRangeOpts(a[3:], rangeOpts{byLex: true})
+	if err != "" {
+		return err
+	}
+	return s.zrange(string(a[0]), a[1], a[2], opts)
+}
+
+func cmdZRemRangeByRank(s *Server, a [][]byte) interface{} {
+	picked := s.zrange(string(a[0]), a[1], a[2], rangeOpts{count: -1})
+	return s.zremPicked(string(a[0]), picked)
+}
+
+func cmdZRemRangeByScore(s *Server, a [][]byte) interface{} {
+	picked := s.zrange(string(a[0]), a[1], a[2], rangeOpts{byScore: true, count: -1})
+	return s.zremPicked(string(a[0]), picked)
+}
+
+func (s *Server) zremPicked(key string, picked interface{}) interface{} {
+	members, ok := picked.([]interface{})
+	if !ok {
+		return picked // an error reply
+	}
+	z, _ := s.getZset(key, false)
+	var n int64
+	for _, m := range members {
+		if z != nil && z.remove(string(m.([]byte))) {
+			n++
+		}
+	}
+	s.dropIfEmpty(key)
+	return n
+}
+
+func cmdZScan(s *Server, a [][]byte) interface{} {
+	z, err := s.getZset(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	var members []string
+	if z != nil {
+		for m := range z.scores {
+			members = append(members, m)
+		}
+	}
+	page, next, err := scanPage(members, a[1:])
+	if err != "" {
+		return err
+	}
+	out := make([]interface{}, 0, 2*len(page))
+	for _, m := range page {
+		out = append(out, []byte(m), formatFloat(z.scores[m]))
+	}
+	return []interface{}{[]byte(strconv.Itoa(next)), out}
+}
+
+// matchGlob implements Redis-style glob matching: *, ?, [abc], [^a-z] and
+// backslash escapes.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return pattern == s
+			}
+			class := pattern[1 : end+1]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if s[0] >= class[i] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[end+2:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
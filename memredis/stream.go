@@ -0,0 +1,217 @@
+package memredis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Streams support what the analytics sink and the firehose need: XADD with
+// auto IDs and MAXLEN trimming, XLEN, and XRANGE and XREVRANGE, with
+// exclusive "(id" bounds. Consumer groups and blocking reads are not
+// implemented.
+type stream struct {
+	entries []streamEntry
+	lastMs  int64
+	lastSeq int64
+}
+
+type streamEntry struct {
+	ms, seq int64
+	fields  [][]byte
+}
+
+func (e streamEntry) id() string {
+	return strconv.FormatInt(e.ms, 10) + "-" + strconv.FormatInt(e.seq, 10)
+}
+
+func (e streamEntry) reply() []interface{} {
+	fields := make([]interface{}, len(e.fields))
+	for i, f := range e.fields {
+		fields[i] = f
+	}
+	return []interface{}{e.id(), fields}
+}
+
+func (s *Server) getStream(key string, create bool) (*stream, errReply) {
+	e := s.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, ""
+		}
+		st := &stream{}
+		s.data[key] = &entry{value: st}
+		return st, ""
+	}
+	st, ok := e.value.(*stream)
+	if !ok {
+		return nil, errWrongType
+	}
+	return st, ""
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN [=|~] n] * field value [field value ...]
+func cmdXAdd(s *Server, a [][]byte) interface{} {
+	key := string(a[0])
+	maxLen := -1
+	i := 1
+	for ; i < len(a); i++ {
+		switch strings.ToUpper(string(a[i])) {
+		case "NOMKSTREAM":
+			continue
+		case "MAXLEN":
+			i++
+			if i < len(a) && (string(a[i]) == "~" || string(a[i]) == "=") {
+				i++
+			}
+			if i >= len(a) {
+				return errSyntax
+			}
+			n, err := strconv.Atoi(string(a[i]))
+			if err != nil || n < 0 {
+				return errNotInteger
+			}
+			maxLen = n
+			continue
+		}
+		break
+	}
+	if i >= len(a) || string(a[i]) != "*" {
+		return errReply("ERR memredis only supports auto-generated stream IDs")
+	}
+	fields := a[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return wrongArgs("xadd")
+	}
+
+	st, err := s.getStream(key, true)
+	if err != "" {
+		return err
+	}
+	ms := time.Now().UnixMilli()
+	if ms <= st.lastMs {
+		ms, st.lastSeq = st.lastMs, st.lastSeq+1
+	} else {
+		st.lastSeq = 0
+	}
+	st.lastMs = ms
+
+	e := streamEntry{ms: ms, seq: st.lastSeq}
+	for _, f := range fields {
+		e.fields = append(e.fields, append([]byte(nil), f...))
+	}
+	st.entries = append(st.entries, e)
+	if maxLen >= 0 && len(st.entries) > maxLen {
+		st.entries = append([]streamEntry(nil), st.entries[len(st.entries)-maxLen:]...)
+	}
+	return e.id()
+}
+
+func cmdXLen(s *Server, a [][]byte) interface{} {
+	st, err := s.getStream(string(a[0]), false)
+	if err != "" {
+		return err
+	}
+	if st == nil {
+		return int64(0)
+	}
+	return int64(len(st.entries))
+}
+
+// XRANGE key start end [COUNT n]; start and end are IDs, "(id", "-" or
+// "+".
+func cmdXRange(s *Server, a [][]byte) interface{} {
+	return xrange(s, a[0], a[1], a[2], a[3:], false)
+}
+
+// XREVRANGE key end start [COUNT n], newest first.
+func cmdXRevRange(s *Server, a [][]byte) interface{} {
+	return xrange(s, a[0], a[2], a[1], a[3:], true)
+}
+
+func xrange(s *Server, key, from, to []byte, opts [][]byte, rev bool) interface{} {
+	start, ok1 := parseStreamID(string(from), false)
+	end, ok2 := parseStreamID(string(to), true)
+	if !ok1 || !ok2 {
+		return errReply("ERR Invalid stream ID specified as stream command argument")
+	}
+	count := -1
+	if len(opts) == 2 && strings.ToUpper(string(opts[0])) == "COUNT" {
+		n, err := strconv.Atoi(string(opts[1]))
+		if err != nil {
+			return errNotInteger
+		}
+		count = n
+	} else if len(opts) != 0 {
+		return errSyntax
+	}
+
+	st, err := s.getStream(string(key), false)
+	if err != "" {
+		return err
+	}
+	out := []interface{}{}
+	if st == nil {
+		return out
+	}
+	for i := range st.entries {
+		e := st.entries[i]
+		if rev {
+			e = st.entries[len(st.entries)-1-i]
+		}
+		if count >= 0 && len(out) >= count {
+			break
+		}
+		if lessID(e.ms, e.seq, start[0], start[1]) || lessID(end[0], end[1], e.ms, e.seq) {
+			continue
+		}
+		out = append(out, e.reply())
+	}
+	return out
+}
+
+// parseStreamID parses "ms-seq", "ms", "-" or "+", or any of the first
+// two after "(" to leave that ID out. A bare ms means seq 0 as a start and
+// the last seq as an end.
+func parseStreamID(id string, end bool) ([2]int64, bool) {
+	if rest, ok := strings.CutPrefix(id, "("); ok {
+		b, ok := parseStreamID(rest, end)
+		switch {
+		case !ok || rest == "-" || rest == "+":
+			return [2]int64{}, false
+		case !end && b[1] == 1<<63-1:
+			return [2]int64{b[0] + 1, 0}, true
+		case !end:
+			return [2]int64{b[0], b[1] + 1}, true
+		case b[1] == 0:
+			return [2]int64{b[0] - 1, 1<<63 - 1}, true
+		default:
+			return [2]int64{b[0], b[1] - 1}, true
+		}
+	}
+	switch id {
+	case "-":
+		return [2]int64{0, 0}, true
+	case "+":
+		return [2]int64{1<<63 - 1, 1<<63 - 1}, true
+	}
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+	ms, err := strconv.ParseInt(msPart, 10, 64)
+	if err != nil {
+		return [2]int64{}, false
+	}
+	seq := int64(0)
+	if end {
+		seq = 1<<63 - 1
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseInt(seqPart, 10, 64); err != nil {
+			return [2]int64{}, false
+		}
+	}
+	return [2]int64{ms, seq}, true
+}
+
+func lessID(ams, aseq, bms, bseq int64) bool {
+	return ams < bms || ams == bms && aseq < bseq
+}
@@ -0,0 +1,185 @@
+// Package metrics keeps the few series the server exports for Prometheus
+// and writes them in its text exposition format (version 0.0.4), so the
+// server needs no client library. Histograms have fixed buckets and are
+// safe for concurrent use.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RecentWindow is the span Quantile looks at: the observations of the
+// current window and the one before it, so between one and two windows'
+// worth.
+const RecentWindow = time.Minute
+
+// Histogram counts observations into cumulative buckets, as a Prometheus
+// histogram does.
+type Histogram struct {
+	name, help string
+	bounds     []float64 // upper bounds, ascending; +Inf is implicit
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+
+	// Per-bucket counts of the current and the previous RecentWindow.
+	recent, previous []uint64
+	rotated          time.Time
+}
+
+// NewHistogram returns a histogram with the given upper bounds, which must
+// be ascending.
+func NewHistogram(name, help string, bounds []float64) *Histogram {
+	n := len(bounds) + 1
+	return &Histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, n),
+		recent: make([]uint64, n), previous: make([]uint64, n), rotated: time.Now()}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.rotate(time.Now())
+	h.counts[i]++
+	h.recent[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// rotate starts a new window if the current one is over. h.mu is held.
+func (h *Histogram) rotate(now time.Time) {
+	switch elapsed := now.Sub(h.rotated); {
+	case elapsed < RecentWindow:
+		return
+	case elapsed < 2*RecentWindow:
+		h.previous, h.recent = h.recent, h.previous
+	default:
+		clear(h.previous)
+	}
+	clear(h.recent)
+	h.rotated = now
+}
+
+// Quantile estimates the q-quantile (0 < q <= 1) of the recent
+// observations, interpolating linearly within a bucket as Prometheus'
+// histogram_quantile does. It is 0 without observations, and the largest
+// bound if the quantile falls in the +Inf bucket.
+func (h *Histogram) Quantile(q float64) float64 {
+	h.mu.Lock()
+	h.rotate(time.Now())
+	counts := make([]uint64, len(h.recent))
+	var total uint64
+	for i := range counts {
+		counts[i] = h.recent[i] + h.previous[i]
+		total += counts[i]
+	}
+	h.mu.Unlock()
+
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var cum uint64
+	for i, n := range counts {
+		if float64(cum+n) < rank {
+			cum += n
+			continue
+		}
+		if i == len(h.bounds) {
+			break
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.bounds[i-1]
+		}
+		return lower + (h.bounds[i]-lower)*(rank-float64(cum))/float64(n)
+	}
+	if len(h.bounds) == 0 {
+		return 0
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+// WriteTo writes the histogram's HELP, TYPE, bucket, sum and count lines.
+func (h *Histogram) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var cum uint64
+	for i, n := range counts {
+		cum += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		fmt.Fprintf(cw, "%s_bucket{le=%q} %d\n", h.name, le, cum)
+	}
+	fmt.Fprintf(cw, "%s_sum %s\n%s_count %d\n", h.name, formatFloat(sum), h.name, count)
+	return cw.n, cw.err
+}
+
+// WriteGauge writes one gauge with its HELP and TYPE lines.
+func WriteGauge(w io.Writer, name, help string, v float64) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+	return err
+}
+
+// WriteGaugeVec writes a gauge with one label, a line per label value in
+// order.
+func WriteGaugeVec(w io.Writer, name, help, label string, values map[string]float64) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name); err != nil {
+		return err
+	}
+	for _, v := range slices.Sorted(maps.Keys(values)) {
+		if _, err := fmt.Fprintf(w, "%s{%s=%q} %s\n", name, label, v, formatFloat(values[v])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCounter writes one counter with its HELP and TYPE lines.
+func WriteCounter(w io.Writer, name, help string, v float64) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", name, help, name, name, formatFloat(v))
+	return err
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Middleware lets small behaviors hook into the message path without
+// touching the handlers. Inbound middleware sees every chat message after
+// parsing and before it is persisted; outbound middleware sees every frame
+// just before it is written to one connection. Both run in the order given
+// by CHAT_MIDDLEWARE. A middleware that panics is logged and skipped; it
+// never takes the connection down.
+
+// InboundMessage is a message on its way in. Middleware may change
+// Message (including Message.Meta annotations) or reject it.
+type InboundMessage struct {
+	Workspace    workspace
+	Kind         string // "msg", "dm", "group_dm", "room" or "e2e"
+	Conversation string // "global", "dm:<peer>", "group:<id>", "room:<name>"
+	Message      *ChatMessage
+}
+
+// Rejection is returned by inbound middleware to refuse a message; Code
+// and Message are sent to the sender as an error frame.
+type Rejection struct {
+	Code    string
+	Message string
+}
+
+func (r *Rejection) Error() string { return r.Code + ": " + r.Message }
+
+type InboundMiddleware interface {
+	Inbound(m *InboundMessage) *Rejection
+}
+
+// Recipient identifies the connection an outbound frame is written to.
+type Recipient struct {
+	Workspace workspace
+	Name      string // "" before join
+}
+
+type OutboundMiddleware interface {
+	// Outbound returns the frame to write, or false to drop it.
+	Outbound(to Recipient, frame []byte) ([]byte, bool)
+}
+
+// middlewareFactories are the built-ins CHAT_MIDDLEWARE can name. Each
+// returns an InboundMiddleware, an OutboundMiddleware, or both.
+var middlewareFactories = map[string]func() interface{}{
+	"keyword_alert": newKeywordAlert,
+	"max_links":     newMaxLinks,
+	"profanity":     newProfanity,
+}
+
+var (
+	inboundChain  []InboundMiddleware
+	outboundChain []OutboundMiddleware
+)
+
+func initMiddleware(names []string) error {
+	inboundChain, outboundChain = nil, nil
+	for _, name := range names {
+		factory, ok := middlewareFactories[name]
+		if !ok {
+			return fmt.Errorf("unknown middleware %q", name)
+		}
+		m := factory()
+		if in, ok := m.(InboundMiddleware); ok {
+			inboundChain = append(inboundChain, in)
+		}
+		if out, ok := m.(OutboundMiddleware); ok {
+			outboundChain = append(outboundChain, out)
+		}
+	}
+	return nil
+}
+
+// runInbound passes msg through the inbound chain. It reports false, after
+// sending the rejection to c, if the sender is muted or a middleware
+// refused the message.
+func runInbound(c *client, kind, conversation string, msg *ChatMessage) bool {
+	// Checked against the joined name, which msg.User always is here.
+	if name := c.userName(); rejectBanned(c, name) || rejectMuted(c, name) {
+		return false
+	}
+	if c.isBot() {
+		msg.Via = viaBot
+	}
+	if msg.Format != formatCode {
+		expandEmoji(c.ctx, c.ws, msg)
+	}
+	if max := maxChars(msg.Format); max > 0 && utf8.RuneCountInString(msg.Text) > max {
+		sendError(c, "too_long", fmt.Sprintf("messages may be at most %d characters", max), "max", strconv.Itoa(max))
+		return false
+	}
+	m := &InboundMessage{Workspace: c.ws, Kind: kind, Conversation: conversation, Message: msg}
+
+	for _, mw := range inboundChain {
+		if r := safeInbound(mw, m); r != nil {
+			sendError(c, r.Code, r.Message)
+			return false
+		}
+	}
+	return true
+}
+
+func safeInbound(mw InboundMiddleware, m *InboundMessage) (r *Rejection) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("❌ Inbound middleware %T panicked: %v", mw, p)
+			r = nil
+		}
+	}()
+	return mw.Inbound(m)
+}
+
+// runOutbound passes frame through the outbound chain for one recipient.
+func runOutbound(to Recipient, frame []byte) ([]byte, bool) {
+	for _, mw := range outboundChain {
+		out, ok := safeOutbound(mw, to, frame)
+		if !ok {
+			return nil, false
+		}
+		frame = out
+	}
+	return frame, true
+}
+
+func safeOutbound(mw OutboundMiddleware, to Recipient, frame []byte) (out []byte, ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("❌ Outbound middleware %T panicked: %v", mw, p)
+			out, ok = frame, true
+		}
+	}()
+	return mw.Outbound(to, frame)
+}
+
+// keywordAlert annotates messages containing one of CHAT_ALERT_KEYWORDS
+// with meta.alert and logs them. The list is read per message, so config
+// reloads take effect at once.
+type keywordAlert struct{}
+
+func newKeywordAlert() interface{} { return keywordAlert{} }
+
+func (keywordAlert) Inbound(m *InboundMessage) *Rejection {
+	text := strings.ToLower(m.Message.Text)
+	for _, w := range cfg().AlertKeywords {
+		w = strings.ToLower(w)
+		if strings.Contains(text, w) {
+			if m.Message.Meta == nil {
+				m.Message.Meta = map[string]string{}
+			}
+			m.Message.Meta["alert"] = w
+			log.Printf("🔔 Keyword %q from %s in %s", w, m.Message.User, m.Conversation)
+			return nil
+		}
+	}
+	return nil
+}
+
+// maxLinks rejects messages with more than CHAT_MAX_LINKS links.
+type maxLinks struct{}
+
+func newMaxLinks() interface{} { return maxLinks{} }
+
+func (maxLinks) Inbound(m *InboundMessage) *Rejection {
+	if max := cfg().MaxLinks; countLinks(m.Message.Text) > max {
+		return &Rejection{Code: "too_many_links", Message: fmt.Sprintf("messages may contain at most %d links", max)}
+	}
+	return nil
+}
+
+func countLinks(text string) int {
+	n := 0
+	for _, word := range strings.Fields(text) {
+		w := strings.ToLower(word)
+		if strings.HasPrefix(w, "http://") || strings.HasPrefix(w, "https://") || strings.HasPrefix(w, "www.") {
+			n++
+		}
+	}
+	return n
+}
+
+// profanity masks (CHAT_PROFANITY_MODE=mask) or rejects ("reject")
+// messages containing one of CHAT_PROFANITY_WORDS as a whole word, case
+// aside: "Shit!" is masked as "S***!", "Scunthorpe" is left alone.
+type profanity struct{}
+
+const (
+	profanityMask   = "mask"
+	profanityReject = "reject"
+
+	defaultProfanity = "fuck,fucking,fucker,shit,bullshit,bitch,asshole,bastard,cunt,dick,piss,slut,whore,wanker"
+)
+
+func newProfanity() interface{} { return profanity{} }
+
+func (profanity) Inbound(m *InboundMessage) *Rejection {
+	conf := cfg()
+	text := m.Message.Text
+	var masked strings.Builder
+	found, last, start := false, 0, -1
+	// A word is a run of letters and digits; the space added at the end
+	// closes the last one.
+	for i, r := range text + " " {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		word := text[start:i]
+		if slices.ContainsFunc(conf.ProfanityWords, func(w string) bool { return strings.EqualFold(w, word) }) {
+			_, size := utf8.DecodeRuneInString(word)
+			masked.WriteString(text[last:start])
+			masked.WriteString(word[:size])
+			masked.WriteString(strings.Repeat("*", utf8.RuneCountInString(word)-1))
+			found, last = true, i
+		}
+		start = -1
+	}
+	if !found {
+		return nil
+	}
+	if conf.ProfanityMode == profanityReject {
+		return &Rejection{Code: "profanity", Message: "messages may not contain profanity"}
+	}
+	masked.WriteString(text[last:])
+	m.Message.Text = masked.String()
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+// setConfig changes the live config for the test.
+func setConfig(t *testing.T, change func(*config)) {
+	conf := *cfg()
+	change(&conf)
+	old := liveConfig.Swap(&conf)
+	t.Cleanup(func() { liveConfig.Store(old) })
+}
+
+// TestKeywordAlert checks that keyword_alert annotates a message with the
+// first of CHAT_ALERT_KEYWORDS found in it, case aside, and changes
+// nothing else.
+func TestKeywordAlert(t *testing.T) {
+	setConfig(t, func(c *config) { c.AlertKeywords = []string{"Outage", "on fire"} })
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"all quiet", ""},
+		{"OUTAGE in eu-west", "outage"},
+		{"the build is On Fire again", "on fire"},
+		{"outage, and the build is on fire", "outage"}, // the first keyword listed wins
+		{"on\nfire", ""},
+		{"outages all week", "outage"}, // anywhere in the text, not whole words
+	} {
+		msg := &ChatMessage{User: "alice", Text: tc.text, Meta: map[string]string{"kept": "yes"}}
+		if r := (keywordAlert{}).Inbound(&InboundMessage{Conversation: "global", Message: msg}); r != nil {
+			t.Errorf("%q was rejected: %v", tc.text, r)
+		}
+		if got := msg.Meta["alert"]; got != tc.want {
+			t.Errorf("%q got alert %q, want %q", tc.text, got, tc.want)
+		}
+		if msg.Meta["kept"] != "yes" || msg.Text != tc.text {
+			t.Errorf("%q: the message was changed to %q, meta %v", tc.text, msg.Text, msg.Meta)
+		}
+	}
+
+	t.Run("no meta yet", func(t *testing.T) {
+		msg := &ChatMessage{Text: "outage"}
+		(keywordAlert{}).Inbound(&InboundMessage{Message: msg})
+		if msg.Meta["alert"] != "outage" {
+			t.Errorf("got meta %v", msg.Meta)
+		}
+	})
+
+	t.Run("follows reloads", func(t *testing.T) {
+		setConfig(t, func(c *config) { c.AlertKeywords = nil })
+		msg := &ChatMessage{Text: "outage"}
+		(keywordAlert{}).Inbound(&InboundMessage{Message: msg})
+		if msg.Meta != nil {
+			t.Errorf("got meta %v with no keywords", msg.Meta)
+		}
+	})
+}
+
+// TestMaxLinks checks that max_links refuses a message with more than
+// CHAT_MAX_LINKS words that start like a link.
+func TestMaxLinks(t *testing.T) {
+	setConfig(t, func(c *config) { c.MaxLinks = 2 })
+	for _, tc := range []struct {
+		text   string
+		reject bool
+	}{
+		{"no links here", false},
+		{"see https://a.example and http://b.example", false},
+		{"see https://a.example, http://b.example and www.c.example", true},
+		{"HTTPS://A.EXAMPLE HTTP://B.EXAMPLE WWW.C.EXAMPLE", true},
+		{"https://a.example\nhttps://b.example\thttps://c.example", true},
+		{"not links: xhttps://a.example (https://b.example) mailto:c@example.com https://d.example", false},
+		{"https: // a.example www .b.example https://c.example", false},
+	} {
+		r := (maxLinks{}).Inbound(&InboundMessage{Message: &ChatMessage{Text: tc.text}})
+		if (r != nil) != tc.reject {
+			t.Errorf("%q: got rejection %v, want rejected %v", tc.text, r, tc.reject)
+		}
+		if r != nil && r.Code != "too_many_links" {
+			t.Errorf("%q: got code %s", tc.text, r.Code)
+		}
+	}
+
+	setConfig(t, func(c *config) { c.MaxLinks = 0 })
+	if r := (maxLinks{}).Inbound(&InboundMessage{Message: &ChatMessage{Text: "www.a.example"}}); r == nil {
+		t.Error("a link got through CHAT_MAX_LINKS=0")
+	}
+}
+
+type panicking struct{}
+
+func (panicking) Inbound(*InboundMessage) *Rejection        { panic("inbound") }
+func (panicking) Outbound(Recipient, []byte) ([]byte, bool) { panic("outbound") }
+
+// TestMiddlewarePanic checks that a middleware that panics is skipped:
+// the message goes on, and the frame is written unchanged.
+func TestMiddlewarePanic(t *testing.T) {
+	if r := safeInbound(panicking{}, &InboundMessage{Message: &ChatMessage{Text: "hi"}}); r != nil {
+		t.Errorf("a panicking inbound middleware rejected the message: %v", r)
+	}
+	if out, ok := safeOutbound(panicking{}, Recipient{Name: "bob"}, []byte("frame")); !ok || string(out) != "frame" {
+		t.Errorf("a panicking outbound middleware gave %q, %v", out, ok)
+	}
+}
+
+// TestInitMiddleware checks that CHAT_MIDDLEWARE builds the chain in its
+// order and refuses unknown names.
+func TestInitMiddleware(t *testing.T) {
+	t.Cleanup(func() { initMiddleware(cfg().Middleware) })
+	if err := initMiddleware([]string{"max_links", "keyword_alert"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(inboundChain) != 2 || inboundChain[0] != (maxLinks{}) || inboundChain[1] != (keywordAlert{}) {
+		t.Errorf("the inbound chain is %v, want max_links then keyword_alert", inboundChain)
+	}
+	if err := initMiddleware([]string{"keyword_alert", "nope"}); err == nil {
+		t.Error("an unknown middleware was accepted")
+	}
+}
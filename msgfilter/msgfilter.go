@@ -0,0 +1,188 @@
+// Package msgfilter decides whether a chat message is one an outgoing
+// webhook asked for. A Filter is compiled once into a Matcher, which is
+// immutable and safe for concurrent use; matching a message costs a few
+// map lookups, one pass over the text for all keywords, and one regular
+// expression match.
+//
+// Regular expressions are Go's (RE2 syntax), which run in time linear in
+// the text whatever the pattern, so a pattern can't make matching hang the
+// way backtracking engines can. What a pattern can do is compile into a
+// large program, making every match slow; patterns are therefore limited in
+// length and in compiled size.
+package msgfilter
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"websocket-chatapp/ahocorasick"
+)
+
+const (
+	MaxPatternLen = 256  // bytes
+	MaxProgSize   = 2000 // instructions of the compiled pattern
+	MaxListLen    = 50   // entries of each list
+	MaxKeywordLen = 50   // characters
+)
+
+// Kinds of message. Kind "message" is anything users write, "system" the
+// server's notices (joins, topic changes and the like).
+const (
+	KindMessage = "message"
+	KindSystem  = "system"
+)
+
+// Filter is a webhook's filter as registered. Every list that is set must
+// match: an empty list lets everything through, except Kinds, which
+// defaults to messages only.
+type Filter struct {
+	// Rooms lists room names; "" stands for the global chat.
+	Rooms []string `json:"rooms,omitempty"`
+	// Senders, when set, are the only senders wanted; ExcludeSenders are
+	// never wanted.
+	Senders        []string `json:"senders,omitempty"`
+	ExcludeSenders []string `json:"excludeSenders,omitempty"`
+	// Keywords match anywhere in the text, ignoring case; one is enough.
+	Keywords []string `json:"keywords,omitempty"`
+	// Regex must match somewhere in the text.
+	Regex string   `json:"regex,omitempty"`
+	Kinds []string `json:"kinds,omitempty"`
+}
+
+// Message is what a filter looks at.
+type Message struct {
+	Room   string // "" for the global chat
+	Sender string
+	Text   string
+	Kind   string
+}
+
+type Matcher struct {
+	rooms, senders, excluded, kinds map[string]bool
+	keywords                        *ahocorasick.Matcher
+	re                              *regexp.Regexp
+}
+
+// Compile checks f and builds its matcher.
+func Compile(f Filter) (*Matcher, error) {
+	for _, list := range [][]string{f.Rooms, f.Senders, f.ExcludeSenders, f.Keywords, f.Kinds} {
+		if len(list) > MaxListLen {
+			return nil, fmt.Errorf("filter lists may have at most %d entries", MaxListLen)
+		}
+	}
+	m := &Matcher{
+		rooms:    set(f.Rooms),
+		senders:  set(f.Senders),
+		excluded: set(f.ExcludeSenders),
+		kinds:    set(f.Kinds),
+	}
+	if len(f.Kinds) == 0 {
+		m.kinds = map[string]bool{KindMessage: true}
+	}
+	for kind := range m.kinds {
+		if kind != KindMessage && kind != KindSystem {
+			return nil, fmt.Errorf("unknown kind %q (want %s or %s)", kind, KindMessage, KindSystem)
+		}
+	}
+
+	if len(f.Keywords) > 0 {
+		keywords := make([]string, len(f.Keywords))
+		for i, k := range f.Keywords {
+			keywords[i] = strings.ToLower(strings.TrimSpace(k))
+			if keywords[i] == "" || utf8.RuneCountInString(keywords[i]) > MaxKeywordLen {
+				return nil, fmt.Errorf("keywords must be 1 to %d characters", MaxKeywordLen)
+			}
+		}
+		m.keywords = ahocorasick.New(keywords)
+	}
+	if f.Regex != "" {
+		re, err := compileRegex(f.Regex)
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func set(list []string) map[string]bool {
+	if len(list) == 0 {
+		return nil
+	}
+	s := make(map[string]bool, len(list))
+	for _, v := range list {
+		s[v] = true
+	}
+	return s
+}
+
+// Match reports whether msg passes the filter and, if it doesn't, which
+// part refused it: "room", "sender", "kind", "keyword" or "regex". The
+// cheap checks come first.
+func (m *Matcher) Match(msg Message) (bool, string) {
+	switch {
+	case m.rooms != nil && !m.rooms[msg.Room]:
+		return false, "room"
+	case m.excluded[msg.Sender], m.senders != nil && !m.senders[msg.Sender]:
+		return false, "sender"
+	case !m.kinds[msg.Kind]:
+		return false, "kind"
+	case m.keywords != nil && len(m.keywords.Match(strings.ToLower(msg.Text))) == 0:
+		return false, "keyword"
+	case m.re != nil && !m.re.MatchString(msg.Text):
+		return false, "regex"
+	}
+	return true, ""
+}
+
+// Compiled patterns are shared by every filter using them, so reloading a
+// workspace's webhooks or testing a filter doesn't compile them again. The
+// cache is bounded by simply starting over when it is full.
+const maxCachedRegexps = 256
+
+var (
+	regexMu    sync.Mutex
+	regexCache = map[string]*regexp.Regexp{}
+)
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	regexMu.Lock()
+	re := regexCache[pattern]
+	regexMu.Unlock()
+	if re != nil {
+		return re, nil
+	}
+
+	if len(pattern) > MaxPatternLen {
+		return nil, fmt.Errorf("regex may be at most %d bytes", MaxPatternLen)
+	}
+	// Counted repetitions are expanded when compiling, so a short pattern
+	// like (a{100}){100} is huge; measure the program before building the
+	// matcher.
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	if len(prog.Inst) > MaxProgSize {
+		return nil, fmt.Errorf("regex is too complex (%d instructions compiled, at most %d)", len(prog.Inst), MaxProgSize)
+	}
+	if re, err = regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+
+	regexMu.Lock()
+	if len(regexCache) >= maxCachedRegexps {
+		clear(regexCache)
+	}
+	regexCache[pattern] = re
+	regexMu.Unlock()
+	return re, nil
+}
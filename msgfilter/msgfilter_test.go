@@ -0,0 +1,120 @@
+package msgfilter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRegexLimits checks that patterns too long or compiling too large are
+// refused, and that patterns which make backtracking engines hang match
+// long hostile texts quickly.
+func TestRegexLimits(t *testing.T) {
+	for _, pattern := range []string{
+		strings.Repeat("a", MaxPatternLen+1),
+		"(a{100}){100}",
+		"((((x{10}){10}){10}){10})",
+		"(",
+		`\p{NoSuchClass}`,
+	} {
+		if _, err := Compile(Filter{Regex: pattern}); err == nil {
+			t.Errorf("%.40q compiled", pattern)
+		}
+	}
+
+	hostile := strings.Repeat("a", 100000) + "!"
+	for _, pattern := range []string{`(a+)+$`, `(a|aa)+$`, `(a*)*b`, `^(\w+\s?)*$`, `(.*a){20}`} {
+		m, err := Compile(Filter{Regex: pattern})
+		if err != nil {
+			t.Errorf("%q: %v", pattern, err)
+			continue
+		}
+		start := time.Now()
+		m.Match(Message{Sender: "alice", Text: hostile, Kind: KindMessage})
+		if took := time.Since(start); took > time.Second {
+			t.Errorf("%q took %s on a hostile text", pattern, took)
+		}
+	}
+}
+
+// TestRegexCache checks that a pattern is compiled once and shared, and
+// that an invalid one is never cached.
+func TestRegexCache(t *testing.T) {
+	a, err := compileRegex(`deploy(ed|ing)?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := compileRegex(`deploy(ed|ing)?`); a != b {
+		t.Error("the same pattern was compiled twice")
+	}
+	compileRegex(`(a{100}){100}`)
+	regexMu.Lock()
+	_, cached := regexCache[`(a{100}){100}`]
+	regexMu.Unlock()
+	if cached {
+		t.Error("a refused pattern was cached")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	deploy := Message{Room: "ops", Sender: "alice", Text: "Deployed v2 to prod", Kind: KindMessage}
+	for _, tc := range []struct {
+		name   string
+		filter Filter
+		msg    Message
+		want   string // the part refusing it, "" if it matches
+	}{
+		{"empty filter", Filter{}, deploy, ""},
+		{"empty filter, system message", Filter{}, Message{Text: "alice joined", Kind: KindSystem}, "kind"},
+		{"system messages asked for", Filter{Kinds: []string{KindSystem}}, Message{Text: "alice joined", Kind: KindSystem}, ""},
+		{"room", Filter{Rooms: []string{"ops"}}, deploy, ""},
+		{"other room", Filter{Rooms: []string{"dev"}}, deploy, "room"},
+		{"global chat", Filter{Rooms: []string{""}}, Message{Sender: "alice", Text: "hi", Kind: KindMessage}, ""},
+		{"global chat only", Filter{Rooms: []string{""}}, deploy, "room"},
+		{"sender", Filter{Senders: []string{"alice", "bob"}}, deploy, ""},
+		{"other sender", Filter{Senders: []string{"bob"}}, deploy, "sender"},
+		{"excluded sender", Filter{ExcludeSenders: []string{"alice"}}, deploy, "sender"},
+		{"excluded beats allowed", Filter{Senders: []string{"alice"}, ExcludeSenders: []string{"alice"}}, deploy, "sender"},
+		{"keyword", Filter{Keywords: []string{"rollback", "DEPLOY"}}, deploy, ""},
+		{"no keyword", Filter{Keywords: []string{"rollback"}}, deploy, "keyword"},
+		{"regex", Filter{Regex: `v\d+ to (prod|staging)`}, deploy, ""},
+		{"regex is case-sensitive", Filter{Regex: `^deployed`}, deploy, "regex"},
+		{"case-insensitive regex", Filter{Regex: `(?i)^deployed`}, deploy, ""},
+		{"all parts", Filter{Rooms: []string{"ops"}, Senders: []string{"alice"}, Keywords: []string{"prod"}, Regex: `v2`, Kinds: []string{KindMessage, KindSystem}}, deploy, ""},
+		{"all parts but the regex", Filter{Rooms: []string{"ops"}, Senders: []string{"alice"}, Keywords: []string{"prod"}, Regex: `v3`}, deploy, "regex"},
+		{"keyword and regex both fail", Filter{Keywords: []string{"rollback"}, Regex: `v3`}, deploy, "keyword"},
+		{"room fails first", Filter{Rooms: []string{"dev"}, ExcludeSenders: []string{"alice"}, Keywords: []string{"rollback"}}, deploy, "room"},
+		{"sender before kind", Filter{Senders: []string{"bob"}, Kinds: []string{KindSystem}}, deploy, "sender"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := Compile(tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, part := m.Match(tc.msg)
+			if ok != (tc.want == "") || part != tc.want {
+				t.Errorf("Match = %v, %q; want refused by %q", ok, part, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileRefuses(t *testing.T) {
+	long := make([]string, MaxListLen+1)
+	for i := range long {
+		long[i] = "x"
+	}
+	for name, f := range map[string]Filter{
+		"unknown kind":   {Kinds: []string{"typing"}},
+		"long list":      {Rooms: long},
+		"empty keyword":  {Keywords: []string{"ok", "  "}},
+		"long keyword":   {Keywords: []string{strings.Repeat("é", MaxKeywordLen+1)}},
+		"invalid regex":  {Regex: "a**"},
+		"complex regex":  {Regex: "(x{50}){50}"},
+		"too long regex": {Regex: strings.Repeat("x", MaxPatternLen+1)},
+	} {
+		if _, err := Compile(f); err == nil {
+			t.Errorf("%s compiled", name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"websocket-chatapp/username"
+)
+
+// Case-insensitive names. Two names are the same user when their canonical
+// forms (package username: case-folded and NFC-normalized) are equal, so
+// "Bob", "bob" and "BOB" are one user. The first join with a name registers
+// its spelling in chat:names (hash: canonical form -> name). That spelling
+// is the user's name from then on: it is what others see, and every key,
+// set and channel about the user is built from it, so a user has one set
+// of keys however the name is typed. resolveName turns a name from a
+// client, a join or a DM's receiver, say, into the registered spelling.
+//
+// Names that already differed only in case when this came in (schema
+// version 2) can't be merged without mixing up two people's data. The
+// migration registers the most recently active spelling and puts the
+// others in chat:names:legacy. Those stay reachable, and can join, with
+// their exact spelling only.
+
+// resolveName returns the registered spelling of name, or name itself if
+// it isn't registered.
+func resolveName(ctx context.Context, ws workspace, name string) string {
+	if name == "" {
+		return name
+	}
+	registered, err := rdb.HGet(ctx, ws.namesKey(), username.Canonical(name)).Result()
+	if err != nil || registered == name {
+		return name
+	}
+	if legacy, _ := rdb.SIsMember(ctx, ws.namesLegacyKey(), name).Result(); legacy {
+		return name
+	}
+	return registered
+}
+
+// registerName registers name's spelling if its canonical form has none.
+func registerName(ctx context.Context, ws workspace, name string) {
+	rdb.HSetNX(ctx, ws.namesKey(), username.Canonical(name), name)
+}
+
+// unregisterName forgets name, for deleting the user.
+func unregisterName(ctx context.Context, ws workspace, name string) {
+	canonical := username.Canonical(name)
+	if registered, _ := rdb.HGet(ctx, ws.namesKey(), canonical).Result(); registered == name {
+		rdb.HDel(ctx, ws.namesKey(), canonical)
+	}
+	rdb.SRem(ctx, ws.namesLegacyKey(), name)
+}
+
+// validJoinName reports whether name has a canonical form at all: a name
+// of nothing but invalid UTF-8 has none.
+func validJoinName(name string) bool {
+	return username.Canonical(name) != ""
+}
+
+// migrateCaseInsensitiveNames registers the spelling of every user in
+// chat:users:activity, the most recently active one where several differ
+// only in case.
+func migrateCaseInsensitiveNames(ctx context.Context) error {
+	for _, ws := range knownWorkspaces(ctx) {
+		users, err := rdb.ZRevRangeWithScores(ctx, ws.usersActivityKey(), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		spellings := map[string][]string{} // most recent first
+		for _, u := range users {
+			name, _ := u.Member.(string)
+			if canonical := username.Canonical(name); canonical != "" {
+				spellings[canonical] = append(spellings[canonical], name)
+			}
+		}
+		if len(spellings) == 0 {
+			continue
+		}
+
+		registered, err := rdb.HGetAll(ctx, ws.namesKey()).Result()
+		if err != nil {
+			return err
+		}
+		fields := map[string]interface{}{}
+		var legacy []interface{}
+		var collisions []string
+		for canonical, names := range spellings {
+			winner, ok := registered[canonical]
+			if !ok {
+				winner = names[0]
+				fields[canonical] = winner
+			}
+			for _, name := range names {
+				if name != winner {
+					legacy = append(legacy, name)
+				}
+			}
+			if len(names) > 1 {
+				collisions = append(collisions, fmt.Sprintf("%q (as %q)", names, winner))
+			}
+		}
+		if len(fields) == 0 && len(legacy) == 0 {
+			continue
+		}
+		pipe := rdb.TxPipeline()
+		if len(fields) > 0 {
+			pipe.HSet(ctx, ws.namesKey(), fields)
+		}
+		if len(legacy) > 0 {
+			pipe.SAdd(ctx, ws.namesLegacyKey(), legacy...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		if len(fields) > 0 {
+			fmt.Printf("🧬 Registered %d name(s) in workspace %q\n", len(fields), ws)
+		}
+		if len(collisions) > 0 {
+			sort.Strings(collisions)
+			fmt.Printf("⚠️ Names in workspace %q that differ only in case, kept apart (other spellings reach the one in parentheses): %v\n", ws, collisions)
+		}
+	}
+	return nil
+}
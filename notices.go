@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Notices are what a late joiner must see, assembled in this order: the
+// message of the day (CHAT_MOTD), the sticky announcements that haven't
+// expired, oldest first, and the pinned notice. init carries them, and
+// joined carries them again with the user's dismissals. A notice's ID
+// changes with its content (the MOTD's is a hash of its text, the others
+// are their message's ID), so a dismissal holds across reconnects and
+// devices while an edited MOTD or a new pin is shown again. Dismissals are
+// kept per user in chat:user:<name>:dismissed; IDs of notices that are
+// gone are pruned from it on the user's next dismissal.
+//
+// Sticky announcements live in chat:announcements (hash: notice ID ->
+// notice JSON, sealed like messages) and are deleted by whichever read
+// finds them expired. The init cache needs no extra invalidation: posting
+// or removing one also publishes a frame on the public channel, and the
+// MOTD and expiry are at most CHAT_INIT_CACHE_TTL late.
+const (
+	noticeMOTD         = "motd"
+	noticeAnnouncement = "announcement"
+	noticePin          = "pin"
+
+	maxStickyAnnouncements = 20
+)
+
+// workspaceNotices returns ws's notices in order, none dismissed.
+func workspaceNotices(ctx context.Context, ws workspace) []protocol.Notice {
+	notices := []protocol.Notice{}
+	if motd := strings.TrimSpace(cfg().MOTD); motd != "" {
+		sum := sha256.Sum256([]byte(motd))
+		notices = append(notices, protocol.Notice{ID: noticeMOTD + "-" + hex.EncodeToString(sum[:4]), Kind: noticeMOTD, Text: motd})
+	}
+	notices = append(notices, stickyAnnouncements(ctx, ws)...)
+	if pinned := pinnedMessage(ctx, ws); pinned != nil {
+		notices = append(notices, protocol.Notice{ID: noticePin + "-" + pinned.ID, Kind: noticePin, Text: pinned.Text, From: pinned.User, Time: pinned.Time})
+	}
+	return notices
+}
+
+// userNotices returns a copy of notices marked with name's dismissals.
+func userNotices(ctx context.Context, ws workspace, name string, notices []protocol.Notice) []protocol.Notice {
+	dismissed, _ := rdb.SMembers(ctx, ws.dismissedKey(name)).Result()
+	seen := make(map[string]bool, len(dismissed))
+	for _, id := range dismissed {
+		seen[id] = true
+	}
+	list := make([]protocol.Notice, len(notices))
+	for i, n := range notices {
+		n.Dismissed = seen[n.ID]
+		list[i] = n
+	}
+	return list
+}
+
+// stickyAnnouncements returns ws's unexpired sticky announcements, oldest
+// first, deleting expired ones.
+func stickyAnnouncements(ctx context.Context, ws workspace) []protocol.Notice {
+	all, _ := rdb.HGetAll(ctx, ws.announcementsKey()).Result()
+	now := time.Now().Unix()
+	list := []protocol.Notice{}
+	for id, raw := range all {
+		var n protocol.Notice
+		data, err := unseal(raw)
+		if err != nil || json.Unmarshal(data, &n) != nil {
+			continue
+		}
+		if n.Expires > 0 && n.Expires <= now {
+			rdb.HDel(ctx, ws.announcementsKey(), id)
+			continue
+		}
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Time != list[j].Time {
+			return list[i].Time < list[j].Time
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// addStickyAnnouncement keeps msg as a notice for ttl seconds (0: until
+// removed) and tells connected clients.
+func addStickyAnnouncement(ctx context.Context, ws workspace, msg ChatMessage, ttl int64) error {
+	n := protocol.Notice{ID: noticeAnnouncement + "-" + msg.ID, Kind: noticeAnnouncement, Text: msg.Text, From: msg.User, Time: msg.Time}
+	if ttl > 0 {
+		n.Expires = msg.Time + ttl
+	}
+	raw, _ := json.Marshal(n)
+	if err := rdb.HSet(ctx, ws.announcementsKey(), n.ID, seal(raw)).Err(); err != nil {
+		return err
+	}
+	frame, _ := json.Marshal(protocol.NewNoticeAdded(n))
+	publish(ws.messagesChannel(), frame)
+	return nil
+}
+
+// {"type":"admin_unannounce","noticeId":"announcement-..."} takes a sticky
+// announcement down before it expires. The message stays in history.
+func handleAdminUnannounce(c *client, data []byte) {
+	var req protocol.UnannounceRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.NoticeID == "" {
+		sendError(c, "bad_frame", "invalid admin_unannounce frame")
+		return
+	}
+	if n, _ := rdb.HDel(c.ctx, c.ws.announcementsKey(), req.NoticeID).Result(); n == 0 {
+		sendError(c, "not_found", "no such sticky announcement: "+req.NoticeID)
+		return
+	}
+	frame, _ := json.Marshal(protocol.NewNoticeRemoved(req.NoticeID))
+	publish(c.ws.messagesChannel(), frame)
+	publishAdminEvent(protocol.AdminEvent{Event: "unannounce", Workspace: string(c.ws), By: adminName(c), Message: req.NoticeID})
+}
+
+// {"type":"dismiss","noticeId":"..."} hides a notice from the user; every
+// connection of theirs gets the same frame back.
+func handleDismiss(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	var req protocol.DismissRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.NoticeID == "" {
+		sendError(c, "bad_frame", "invalid dismiss frame")
+		return
+	}
+
+	current := map[string]bool{}
+	for _, n := range workspaceNotices(ctx, c.ws) {
+		current[n.ID] = true
+	}
+	if !current[req.NoticeID] {
+		sendError(c, "not_found", "no such notice: "+req.NoticeID)
+		return
+	}
+	key := c.ws.dismissedKey(name)
+	dismissed, _ := rdb.SMembers(ctx, key).Result()
+	for _, id := range dismissed {
+		if !current[id] {
+			rdb.SRem(ctx, key, id)
+		}
+	}
+	if err := rdb.SAdd(ctx, key, req.NoticeID).Err(); err != nil {
+		sendError(c, "not_stored", "dismissal could not be stored; please retry")
+		return
+	}
+	frame, _ := json.Marshal(protocol.NewDismiss(req.NoticeID))
+	publish(c.ws.userChannel(name), frame)
+}
@@ -0,0 +1,119 @@
+package main_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestNotices checks the order init and joined list notices in: the
+// MOTD, then the sticky announcements oldest first, whatever order they
+// were posted in relative to the pin, then the pin; a plain announcement
+// is not one, and an expired one drops out. It then has alice dismiss one
+// and checks the dismissal reaches her other connection, holds when she
+// reconnects, and is hers alone.
+func TestNotices(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_MOTD=Be nice")
+	admin := adminConn(t, addr, "admin")
+	for _, frame := range []interface{}{
+		protocol.PinRequest{Type: protocol.TypeAdminPin, Text: "read the rules"},
+		protocol.AnnounceRequest{Type: protocol.TypeAdminAnnounce, Text: "maintenance at 18:00", Sticky: true},
+		protocol.AnnounceRequest{Type: protocol.TypeAdminAnnounce, Text: "not sticky"},
+		protocol.AnnounceRequest{Type: protocol.TypeAdminAnnounce, Text: "gone in a second", Sticky: true, TTL: 1},
+		protocol.AnnounceRequest{Type: protocol.TypeAdminAnnounce, Text: "new emoji", Sticky: true},
+	} {
+		admin.SendFrame(frame)
+		await(t, admin, protocol.TypeAdminEvent)
+	}
+
+	// connect joins as name and returns the notices of its init and its
+	// joined frame.
+	connect := func(name string) (c *client.Client, init, joined []protocol.Notice) {
+		t.Helper()
+		c, err := client.Dial("ws://" + addr + "/ws")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		c.Join(name)
+		for done := false; init == nil || joined == nil || !done; {
+			switch f := await(t, c, protocol.TypeInit, protocol.TypeJoined, protocol.TypeInitDone); f.Type {
+			case protocol.TypeInit:
+				var frame protocol.Init
+				decode(t, f, &frame)
+				init = frame.Notices
+			case protocol.TypeJoined:
+				var frame protocol.Joined
+				decode(t, f, &frame)
+				joined = frame.Notices
+			default:
+				done = true
+			}
+		}
+		return c, init, joined
+	}
+	texts := func(notices []protocol.Notice) []string {
+		var list []string
+		for _, n := range notices {
+			list = append(list, n.Kind+": "+n.Text)
+		}
+		return list
+	}
+
+	_, init, joined := connect("bob")
+	want := []string{"motd: Be nice", "announcement: maintenance at 18:00", "announcement: gone in a second", "announcement: new emoji", "pin: read the rules"}
+	if got := texts(init); !slices.Equal(got, want) {
+		t.Fatalf("init notices %q, want %q", got, want)
+	}
+	if got := texts(joined); !slices.Equal(got, want) {
+		t.Errorf("joined notices %q, want %q", got, want)
+	}
+
+	time.Sleep(time.Until(time.Unix(init[2].Expires+1, 0)))
+	want = slices.Delete(want, 2, 3)
+	alice, _, joined := connect("alice")
+	if got := texts(joined); !slices.Equal(got, want) {
+		t.Errorf("notices after one expired %q, want %q", got, want)
+	}
+	alicePhone, _, _ := connect("alice")
+
+	maintenance := joined[1].ID
+	alice.SendFrame(protocol.DismissRequest{Type: protocol.TypeDismiss, NoticeID: maintenance})
+	for _, c := range []*client.Client{alice, alicePhone} {
+		var dismiss protocol.Dismiss
+		if decode(t, await(t, c, protocol.TypeDismiss), &dismiss); dismiss.NoticeID != maintenance {
+			t.Errorf("got a dismissal of %s, want %s", dismiss.NoticeID, maintenance)
+		}
+	}
+	alice.SendFrame(protocol.DismissRequest{Type: protocol.TypeDismiss, NoticeID: "announcement-nope"})
+	refused(t, alice, "not_found")
+
+	alice.Close()
+	alicePhone.Close()
+	// dismissed lists the IDs of the dismissed notices.
+	dismissed := func(notices []protocol.Notice) []string {
+		var ids []string
+		for _, n := range notices {
+			if n.Dismissed {
+				ids = append(ids, n.ID)
+			}
+		}
+		return ids
+	}
+	_, init, joined = connect("alice")
+	if got := dismissed(joined); !slices.Equal(got, []string{maintenance}) {
+		t.Errorf("alice reconnected with %q dismissed, want %s", got, maintenance)
+	}
+	if got := texts(joined); !slices.Equal(got, want) {
+		t.Errorf("alice reconnected with notices %q, want all of %q", got, want)
+	}
+	if got := dismissed(init); got != nil {
+		t.Errorf("init, shared by every user, has %q dismissed", got)
+	}
+	if _, _, joined := connect("bob"); dismissed(joined) != nil {
+		t.Errorf("bob has %q dismissed", dismissed(joined))
+	}
+}
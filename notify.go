@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Offline notifications. When a DM is sent to a user who isn't in the
+// member list of any instance, CHAT_NOTIFY picks a notifier to tell them
+// about it: "smtp" emails the address in their profile (set with
+// notify_email), "webhook" POSTs the message to a push gateway (FCM, APNs
+// or anything else). Notifying happens after the DM is stored and
+// delivered, off the handler, through a buffered queue; a slow or failing
+// notifier only costs notifications, never messages.
+type OfflineNotifier interface {
+	Notify(to Recipient, msg ChatMessage) error
+}
+
+const (
+	notifyWorkers = 4
+	notifyTimeout = 10 * time.Second
+)
+
+type offlineDM struct {
+	to  Recipient
+	msg ChatMessage
+}
+
+var (
+	notifier      OfflineNotifier
+	notifyQueue   chan offlineDM
+	notifyDropped atomic.Int64
+)
+
+// startNotifier sets up the configured notifier and its workers.
+func startNotifier() error {
+	switch cfg().Notify {
+	case "":
+		return nil
+	case "smtp":
+		n, err := newSMTPNotifier()
+		if err != nil {
+			return err
+		}
+		notifier = n
+		fmt.Println("📧 Emailing offline DM recipients through", cfg().SMTPAddr)
+	case "webhook":
+		if cfg().NotifyWebhookURL == "" {
+			return fmt.Errorf("CHAT_NOTIFY=webhook needs CHAT_NOTIFY_WEBHOOK_URL")
+		}
+		notifier = &webhookNotifier{url: cfg().NotifyWebhookURL, token: cfg().NotifyWebhookToken, client: &http.Client{Timeout: notifyTimeout}}
+		fmt.Println("📲 Sending offline DM notifications to", redactURL(cfg().NotifyWebhookURL))
+	default:
+		return fmt.Errorf("unknown CHAT_NOTIFY %q (want smtp or webhook)", cfg().Notify)
+	}
+
+	notifyQueue = make(chan offlineDM, cfg().NotifyBuffer)
+	for i := 0; i < notifyWorkers; i++ {
+		go runNotifier(notifyQueue)
+	}
+	return nil
+}
+
+// notifyOffline queues a notification for the recipient of a DM, to be
+// sent if they turn out to be offline. It returns immediately.
+func notifyOffline(ws workspace, to string, msg ChatMessage) {
+	if notifyQueue == nil {
+		return
+	}
+	select {
+	case notifyQueue <- offlineDM{to: Recipient{Workspace: ws, Name: to}, msg: msg}:
+	default:
+		if notifyDropped.Add(1)%1000 == 1 {
+			log.Printf("⚠️ Offline notification buffer full; %d notification(s) dropped so far", notifyDropped.Load())
+			adminAlertf("notify_dropped", "offline notification buffer full; %d notification(s) dropped so far", notifyDropped.Load())
+		}
+	}
+}
+
+func runNotifier(queue <-chan offlineDM) {
+	for dm := range queue {
+		// chat:members is shared by every instance, so this sees
+		// connections anywhere, not just here.
+		online, err := rdb.SIsMember(serverCtx, dm.to.Workspace.membersKey(), dm.to.Name).Result()
+		if err != nil || online || snoozed(serverCtx, dm.to.Workspace, dm.to.Name, "dm:"+dm.msg.User) {
+			continue
+		}
+		if err := notifier.Notify(dm.to, dm.msg); err != nil {
+			log.Printf("❌ Offline notification to %s failed: %v", dm.to.Name, err)
+		}
+	}
+}
+
+// {"type":"notify_email","email":"alice@example.com"}; an empty email
+// removes it.
+func handleNotifyEmail(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+
+	var req protocol.NotifyEmailRequest
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid notify_email frame")
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		rdb.HDel(ctx, c.ws.profileKey(name), "email")
+		return
+	}
+	if !strings.Contains(email, "@") || strings.ContainsAny(email, "\r\n<>, ") {
+		sendError(c, "bad_frame", "invalid email address")
+		return
+	}
+	rdb.HSet(ctx, c.ws.profileKey(name), "email", email)
+}
+
+// smtpNotifier emails the recipient, at most once per sender per
+// CHAT_NOTIFY_THROTTLE. The throttle is kept in Redis so it holds across
+// instances.
+type smtpNotifier struct {
+	addr     string
+	from     string
+	auth     smtp.Auth
+	template *template.Template
+	throttle time.Duration
+}
+
+// defaultEmailTemplate renders the headers after From/To and the body.
+const defaultEmailTemplate = `Subject: New message from {{.From}}
+
+{{.From}} sent you a message while you were away:
+
+{{if .Encrypted}}(end-to-end encrypted){{else}}{{.Text}}{{end}}
+`
+
+type emailData struct {
+	To, From, Text, Workspace string
+	Encrypted                 bool
+	Time                      time.Time
+}
+
+func newSMTPNotifier() (*smtpNotifier, error) {
+	conf := cfg()
+	if conf.SMTPAddr == "" || conf.SMTPFrom == "" {
+		return nil, fmt.Errorf("CHAT_NOTIFY=smtp needs CHAT_SMTP_ADDR and CHAT_SMTP_FROM")
+	}
+	text := defaultEmailTemplate
+	if conf.SMTPTemplate != "" {
+		b, err := os.ReadFile(conf.SMTPTemplate)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	tmpl, err := template.New("email").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("CHAT_SMTP_TEMPLATE: %w", err)
+	}
+
+	n := &smtpNotifier{addr: conf.SMTPAddr, from: conf.SMTPFrom, template: tmpl, throttle: conf.NotifyThrottle}
+	if conf.SMTPUser != "" {
+		host, _, _ := strings.Cut(conf.SMTPAddr, ":")
+		n.auth = smtp.PlainAuth("", conf.SMTPUser, conf.SMTPPassword, host)
+	}
+	return n, nil
+}
+
+func (n *smtpNotifier) Notify(to Recipient, msg ChatMessage) error {
+	ctx, cancel := context.WithTimeout(serverCtx, notifyTimeout)
+	defer cancel()
+	email, _ := rdb.HGet(ctx, to.Workspace.profileKey(to.Name), "email").Result()
+	if email == "" {
+		return nil
+	}
+	if ok, err := rdb.SetNX(ctx, to.Workspace.notifyThrottleKey(to.Name, msg.User), 1, n.throttle).Result(); err != nil || !ok {
+		return err
+	}
+
+	var body bytes.Buffer
+	err := n.template.Execute(&body, emailData{
+		To:        headerSafe(to.Name),
+		From:      headerSafe(msg.User),
+		Text:      msg.Text,
+		Workspace: string(to.Workspace),
+		Encrypted: msg.Kind == "e2e",
+		Time:      time.Unix(msg.Time, 0),
+	})
+	if err != nil {
+		return err
+	}
+	mail := "From: " + n.from + "\r\nTo: " + email + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n")
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{email}, []byte(mail))
+}
+
+// headerSafe keeps user-chosen names from adding mail headers.
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// webhookNotifier POSTs every offline DM as JSON, with
+// "Authorization: Bearer <CHAT_NOTIFY_WEBHOOK_TOKEN>" when set:
+//
+//	{"workspace":"","to":"bob","message":{...}}
+type webhookNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (n *webhookNotifier) Notify(to Recipient, msg ChatMessage) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"workspace": string(to.Workspace),
+		"to":        to.Name,
+		"message":   msg,
+	})
+	req, err := http.NewRequestWithContext(serverCtx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
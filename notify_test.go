@@ -0,0 +1,266 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestOfflineNotifications checks what the SMTP and webhook notifiers send
+// for DMs to offline users, that users online on another instance, or
+// without an email address, get nothing, that emails are throttled per
+// sender, and that a failing notifier doesn't cost the DM.
+func TestOfflineNotifications(t *testing.T) {
+	t.Run("smtp", func(t *testing.T) {
+		smtpAddr, mails := fakeSMTP(t)
+		store := serveStore(t, 0)
+		addrA := startServer(t, store, withAdmin, "CHAT_NOTIFY=smtp", "CHAT_SMTP_ADDR="+smtpAddr, "CHAT_SMTP_FROM=chat@example.com", "CHAT_NOTIFY_THROTTLE=1h")
+		addrB := startServer(t, store)
+
+		for _, name := range []string{"bob", "dave"} {
+			c := dial(t, addrA, "", name)
+			if name == "bob" {
+				c.SendFrame(protocol.NotifyEmailRequest{Type: protocol.TypeNotifyEmail, Email: "bob@example.com"})
+			}
+			sessionID(t, c)
+			c.Close()
+		}
+		carol := dial(t, addrB, "", "carol")
+		carol.SendFrame(protocol.NotifyEmailRequest{Type: protocol.TypeNotifyEmail, Email: "carol@example.com"})
+		sessionID(t, carol)
+		alice := dial(t, addrA, "", "alice")
+		erin := dial(t, addrA, "", "erin")
+		awaitOffline(t, addrA, "bob", "dave")
+
+		for _, dm := range []struct{ from, to, text string }{
+			{"alice", "bob", "are you there?"},
+			{"alice", "bob", "hello?"},
+			{"alice", "carol", "carol is online elsewhere"},
+			{"alice", "dave", "dave has no address"},
+			{"erin", "bob", "erin too"},
+		} {
+			c := alice
+			if dm.from == "erin" {
+				c = erin
+			}
+			c.SendDM(dm.from, dm.to, dm.text)
+			if got := awaitText(t, c); got != dm.text {
+				t.Fatalf("%s got %q back, want %q", dm.from, got, dm.text)
+			}
+		}
+
+		got := collect(t, mails, 2)
+		slices.SortFunc(got, func(a, b mail) int { return strings.Compare(a.data, b.data) })
+		for i, want := range []struct{ from, text string }{{"alice", "are you there?"}, {"erin", "erin too"}} {
+			m := got[i]
+			if !slices.Equal(m.to, []string{"bob@example.com"}) || m.from != "chat@example.com" {
+				t.Errorf("mail from %s to %v, want chat@example.com to bob@example.com", m.from, m.to)
+			}
+			for _, part := range []string{"From: chat@example.com\r\n", "To: bob@example.com\r\n", "Subject: New message from " + want.from + "\r\n", "\r\n" + want.text + "\r\n"} {
+				if !strings.Contains(m.data, part) {
+					t.Errorf("mail %d has no %q:\n%s", i, part, m.data)
+				}
+			}
+		}
+	})
+
+	t.Run("webhook", func(t *testing.T) {
+		type push struct {
+			auth string
+			body struct {
+				Workspace string `json:"workspace"`
+				To        string `json:"to"`
+				Message   struct {
+					User string `json:"user"`
+					Text string `json:"text"`
+				} `json:"message"`
+			}
+		}
+		var (
+			mu     sync.Mutex
+			pushes []push
+		)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var p push
+			p.auth = r.Header.Get("Authorization")
+			json.NewDecoder(r.Body).Decode(&p.body)
+			mu.Lock()
+			defer mu.Unlock()
+			pushes = append(pushes, p)
+			// The gateway fails the first push.
+			if len(pushes) == 1 {
+				http.Error(w, "gateway down", http.StatusBadGateway)
+			}
+		}))
+		t.Cleanup(receiver.Close)
+		addr := startServer(t, "", withAdmin, "CHAT_NOTIFY=webhook", "CHAT_NOTIFY_WEBHOOK_URL="+receiver.URL, "CHAT_NOTIFY_WEBHOOK_TOKEN=push-secret")
+
+		bob := dial(t, addr, "", "bob")
+		bob.Close()
+		alice := dial(t, addr, "", "alice")
+		dial(t, addr, "", "carol")
+		awaitOffline(t, addr, "bob")
+
+		texts := []string{"first", "second"}
+		for _, text := range texts {
+			alice.SendDM("alice", "bob", text)
+			if got := awaitText(t, alice); got != text {
+				t.Fatalf("alice got %q back, want %q", got, text)
+			}
+		}
+		alice.SendDM("alice", "carol", "carol is online")
+		awaitText(t, alice)
+
+		received := func() []push {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Clone(pushes)
+		}
+		for deadline := time.Now().Add(5 * time.Second); len(received()) < 2 && time.Now().Before(deadline); {
+			time.Sleep(20 * time.Millisecond)
+		}
+		// Give a push to carol, who is online, time to show up.
+		time.Sleep(300 * time.Millisecond)
+		got := received()
+		if len(got) != 2 {
+			t.Fatalf("%d pushes, want one for each of bob's DMs: %+v", len(got), got)
+		}
+		var pushed []string
+		for _, p := range got {
+			if p.auth != "Bearer push-secret" || p.body.Workspace != "" || p.body.To != "bob" || p.body.Message.User != "alice" {
+				t.Errorf("push %+v, want alice's DM to bob with the bearer token", p)
+			}
+			pushed = append(pushed, p.body.Message.Text)
+		}
+		slices.Sort(pushed)
+		if !slices.Equal(pushed, texts) {
+			t.Errorf("pushed %v, want %v", pushed, texts)
+		}
+
+		// The failed push cost nothing: bob finds both DMs.
+		bob = dial(t, addr, "", "bob")
+		bob.SendFrame(protocol.ConversationsRequest{Type: protocol.TypeConversations})
+		var convs protocol.Conversations
+		decode(t, await(t, bob, protocol.TypeConversations), &convs)
+		found := false
+		for _, c := range convs.Conversations {
+			if c.Last != nil && c.Last.User == "alice" {
+				found = c.Last.Snippet == "second" && c.Unread == 2
+			}
+		}
+		if !found {
+			t.Errorf("bob's conversations are %+v, want alice's two DMs", convs.Conversations)
+		}
+	})
+}
+
+// awaitOffline waits until GET /api/members has none of names online.
+func awaitOffline(t *testing.T, addr string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		for deadline := time.Now().Add(5 * time.Second); searchOnline(t, addr, name); time.Sleep(20 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s is still online", name)
+			}
+		}
+	}
+}
+
+type mail struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP serves just enough SMTP for net/smtp.SendMail on a free port,
+// delivering each mail it accepts on the channel. It returns the server's
+// address.
+func fakeSMTP(t *testing.T) (string, <-chan mail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	mails := make(chan mail, 16)
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(nc, mails)
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func serveSMTP(nc net.Conn, mails chan<- mail) {
+	defer nc.Close()
+	conn := textproto.NewConn(nc)
+	conn.PrintfLine("220 fake ESMTP")
+	var m mail
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO", "RSET", "NOOP":
+			conn.PrintfLine("250 ok")
+		case "MAIL":
+			m = mail{from: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")}
+			conn.PrintfLine("250 ok")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			conn.PrintfLine("250 ok")
+		case "DATA":
+			conn.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(conn.DotReader())
+			if err != nil {
+				return
+			}
+			// DotReader turns line endings into \n; put the wire's back.
+			m.data = strings.ReplaceAll(string(data), "\n", "\r\n")
+			mails <- m
+			conn.PrintfLine("250 queued")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+		default:
+			conn.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// collect reads n mails, then fails the test if any more come within a
+// moment.
+func collect(t *testing.T, mails <-chan mail, n int) []mail {
+	t.Helper()
+	var got []mail
+	for len(got) < n {
+		select {
+		case m := <-mails:
+			got = append(got, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d mail(s), want %d", len(got), n)
+		}
+	}
+	select {
+	case m := <-mails:
+		t.Fatalf("an unexpected mail to %v:\n%s", m.to, m.data)
+	case <-time.After(300 * time.Millisecond):
+	}
+	return got
+}
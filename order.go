@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Message order. History zsets are scored by a sequence number Redis hands
+// out per conversation (HINCRBY on the chat:seq hash, one field per history
+// key), not by the sending instance's clock, so two instances with skewed
+// clocks can't interleave a conversation out of order. The message's own
+// "time" stays what it was, and chat:times:<conversation> indexes the same
+// messages by it (score = time, member = sequence number) for the queries
+// that ask for a time range: unread counts, first unread, activity.
+//
+// History written before this (scored by time) is renumbered at startup by
+// migrateOrder, in its old order. A legacy entry is one whose score is
+// above its conversation's counter: time scores are around 1.7e9 and
+// counters start at 1, so this tells them apart without a marker, and
+// entries written later by an instance still running the old version, or
+// restored from an old snapshot, are picked up by the next start.
+const (
+	orderMigrationBatch = 100 // conversations checked per pipeline
+	orderMigrationLock  = 10 * time.Minute
+)
+
+// nextSeq hands out the next sequence number of the conversation at key.
+func nextSeq(ctx context.Context, ws workspace, key string) (int64, error) {
+	return rdb.HIncrBy(ctx, ws.seqKey(), key, 1).Result()
+}
+
+// addOrdered stores one sealed history entry under seq, with its time in
+// the time index.
+func addOrdered(ctx context.Context, pipe redis.Pipeliner, ws workspace, key string, seq, t int64, member string) {
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(seq), Member: member})
+	pipe.ZAdd(ctx, ws.timeIndexKey(key), redis.Z{Score: float64(t), Member: seq})
+}
+
+// trimOrdered keeps the newest max entries of the conversation at key.
+// Sequence and time order agree up to clock skew, so both sets lose the
+// same entries but for a few at the edge, which readers tolerate.
+func trimOrdered(ctx context.Context, pipe redis.Pipeliner, ws workspace, key string, max int) {
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-max-1))
+	pipe.ZRemRangeByRank(ctx, ws.timeIndexKey(key), 0, int64(-max-1))
+}
+
+// dropBefore deletes the entries of the conversation at key sent before
+// cutoff (unix seconds) and reports how many there were.
+func dropBefore(ctx context.Context, ws workspace, key string, cutoff int64) (int64, error) {
+	max := "(" + strconv.FormatInt(cutoff, 10)
+	seqs, err := rdb.ZRevRangeByScore(ctx, ws.timeIndexKey(key), &redis.ZRangeBy{Min: "-inf", Max: max, Count: 1}).Result()
+	if err != nil || len(seqs) == 0 {
+		return 0, err
+	}
+	pipe := rdb.TxPipeline()
+	dropped := pipe.ZRemRangeByScore(ctx, key, "-inf", seqs[0])
+	pipe.ZRemRangeByScore(ctx, ws.timeIndexKey(key), "-inf", max)
+	_, err = pipe.Exec(ctx)
+	return dropped.Val(), err
+}
+
+// messagesBetween returns up to count entries of the conversation at key
+// whose time is within [min, max] (ZRANGEBYSCORE syntax, so "(" excludes),
+// oldest first; count 0 means all.
+func messagesBetween(ctx context.Context, ws workspace, key, min, max string, count int64) ([]string, error) {
+	seqs, err := rdb.ZRangeByScore(ctx, ws.timeIndexKey(key), &redis.ZRangeBy{Min: min, Max: max, Count: count}).Result()
+	if err != nil || len(seqs) == 0 {
+		return nil, err
+	}
+	return entriesBySeq(ctx, key, seqs)
+}
+
+// latestBetween is messagesBetween keeping the newest count entries rather
+// than the oldest; they are still returned oldest first.
+func latestBetween(ctx context.Context, ws workspace, key, min, max string, count int64) ([]string, error) {
+	seqs, err := rdb.ZRevRangeByScore(ctx, ws.timeIndexKey(key), &redis.ZRangeBy{Min: min, Max: max, Count: count}).Result()
+	if err != nil || len(seqs) == 0 {
+		return nil, err
+	}
+	return entriesBySeq(ctx, key, seqs)
+}
+
+// entriesBySeq returns the entries of the conversation at key with the
+// given sequence numbers, in sequence order.
+func entriesBySeq(ctx context.Context, key string, seqs []string) ([]string, error) {
+	// Sequence numbers and times rise together up to clock skew, so fetch
+	// the span and keep the entries the index named.
+	want := map[float64]bool{}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range seqs {
+		f, _ := strconv.ParseFloat(s, 64)
+		want[f] = true
+		lo, hi = math.Min(lo, f), math.Max(hi, f)
+	}
+	entries, err := rdb.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min: strconv.FormatFloat(lo, 'f', -1, 64),
+		Max: strconv.FormatFloat(hi, 'f', -1, 64),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	raws := make([]string, 0, len(seqs))
+	for _, z := range entries {
+		if want[z.Score] {
+			raws = append(raws, z.Member.(string))
+		}
+	}
+	return raws, nil
+}
+
+// countBetween counts the messages of the conversation at key whose time is
+// within [min, max].
+func countBetween(ctx context.Context, ws workspace, key, min, max string) (int64, error) {
+	return rdb.ZCount(ctx, ws.timeIndexKey(key), min, max).Result()
+}
+
+// migrateOrder renumbers legacy entries in every conversation of every
+// workspace. It runs before the server accepts connections; if another
+// instance is already at it, this one leaves it the work.
+func migrateOrder(ctx context.Context) error {
+	ok, err := rdb.SetNX(ctx, orderMigrationLockKey(), instanceID, orderMigrationLock).Result()
+	if err != nil || !ok {
+		return err
+	}
+	defer rdb.Del(ctx, orderMigrationLockKey())
+
+	total := 0
+	for _, ws := range knownWorkspaces(ctx) {
+		keys, err := conversationKeys(ctx, ws)
+		if err != nil {
+			return err
+		}
+		for len(keys) > 0 {
+			batch := keys[:min(len(keys), orderMigrationBatch)]
+			keys = keys[len(batch):]
+			n, err := migrateOrderBatch(ctx, ws, batch)
+			total += n
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if total > 0 {
+		fmt.Printf("🔢 Renumbered %d message(s) to Redis-assigned order\n", total)
+	}
+	return nil
+}
+
+func migrateOrderBatch(ctx context.Context, ws workspace, keys []string) (int, error) {
+	counters, err := rdb.HMGet(ctx, ws.seqKey(), keys...).Result()
+	if err != nil {
+		return 0, err
+	}
+	pipe := rdb.Pipeline()
+	legacy := make([]*redis.ZSliceCmd, len(keys))
+	for i, key := range keys {
+		counter, _ := counters[i].(string)
+		if counter == "" {
+			counter = "0"
+		}
+		legacy[i] = pipe.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: "(" + counter, Max: "+inf"})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	n := 0
+	for i, key := range keys {
+		for _, z := range legacy[i].Val() {
+			member := z.Member.(string)
+			t := int64(z.Score)
+			if msg, ok := decodeMessage(member); ok {
+				t = msg.Time
+			}
+			seq, err := nextSeq(ctx, ws, key)
+			if err != nil {
+				return n, err
+			}
+			tx := rdb.TxPipeline()
+			tx.ZRem(ctx, key, member)
+			addOrdered(ctx, tx, ws, key, seq, t, member)
+			if _, err := tx.Exec(ctx); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Delivery model. A broadcast reaches this instance's clients only through
+// its own subscription, like every other instance's: handlers publish and
+// never write the frame to local connections as well, so the sender's
+// instance doesn't deliver it twice, or once before and once after the
+// others. Direct writes are replies to the connection that sent a frame
+// (errors, acks, pages), never copies of a broadcast.
+//
+// What's left is the transport handing a subscription the same broadcast
+// twice: a publish that timed out after Redis took it is retried by the
+// publish queue (see pubqueue.go). So the stamp (see pubsublag.go) carries
+// the instance that published it and a number that instance counts its
+// publishes with, and each subscription drops a broadcast whose instance
+// and number it saw among its last recentPublishesSize. They are counted
+// in pubsubDuplicates in /api/stats. Broadcasts without that stamp (older
+// instances, CHAT_PUBSUB_TIMESTAMPS off) are passed on as they come.
+const recentPublishesSize = 64
+
+var (
+	publishSeq       atomic.Uint64
+	pubsubDuplicates atomic.Int64
+)
+
+type publishOrigin struct {
+	instance string
+	seq      uint64
+}
+
+// recentPublishes remembers the last broadcasts one subscription passed
+// on. It is used by that subscription's forward loop only.
+type recentPublishes struct {
+	seen []publishOrigin
+	next int
+}
+
+// repeat reports whether payload is a broadcast already passed on, and
+// remembers it if not.
+func (r *recentPublishes) repeat(payload string) bool {
+	instance, seq, _, _, ok := parseStamp(payload)
+	if !ok || seq == 0 {
+		return false
+	}
+	for _, o := range r.seen {
+		if o.seq == seq && o.instance == instance {
+			pubsubDuplicates.Add(1)
+			return true
+		}
+	}
+	// The payload may be large; don't keep it around for its instance.
+	o := publishOrigin{strings.Clone(instance), seq}
+	if len(r.seen) < recentPublishesSize {
+		r.seen = append(r.seen, o)
+		return false
+	}
+	r.seen[r.next] = o
+	r.next = (r.next + 1) % recentPublishesSize
+	return false
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Admin overview: GET /api/admin/overview?workspace=<id> answers what an
+// on-call person wants to know in one request. Instance figures come from
+// chat:instance:<id>:stats, a hash each instance rewrites every
+// statsInterval and that expires with the instance. Messages per user are
+// counted in five-minute buckets (chat:talkers:<unix start>) that expire
+// after an hour, so "top talkers" covers the last hour to within a bucket.
+const (
+	statsInterval  = heartbeatInterval
+	talkerBucket   = 5 * time.Minute
+	talkerWindow   = time.Hour
+	overviewTalker = 10 // top talkers listed
+)
+
+// reportStats writes this instance's stats until ctx is cancelled.
+func reportStats(ctx context.Context) {
+	started := time.Now().Unix()
+	tick := time.NewTicker(statsInterval)
+	defer tick.Stop()
+	for {
+		writeInstanceStats(ctx, started)
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func writeInstanceStats(ctx context.Context, started int64) {
+	conns, spectators := 0, 0
+	workspaces := map[workspace]bool{}
+	for _, c := range connectedClients() {
+		conns++
+		if c.readOnly {
+			spectators++
+		}
+		workspaces[c.ws] = true
+	}
+
+	pipe := rdb.Pipeline()
+	pipe.HSet(ctx, instanceStatsKey(instanceID), map[string]interface{}{
+		"connections": conns,
+		"spectators":  spectators,
+		"workspaces":  len(workspaces),
+		"started":     started,
+		"updated":     time.Now().Unix(),
+	})
+	pipe.Expire(ctx, instanceStatsKey(instanceID), instanceTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println("❌ Stats report error:", err)
+	}
+}
+
+// countTalker counts one message by name towards the top talkers.
+func countTalker(ctx context.Context, ws workspace, name string) {
+	key := ws.talkersKey(time.Now().Truncate(talkerBucket).Unix())
+	pipe := rdb.Pipeline()
+	pipe.ZIncrBy(ctx, key, 1, name)
+	pipe.Expire(ctx, key, talkerWindow+talkerBucket)
+	pipe.Exec(ctx)
+}
+
+type instanceOverview struct {
+	ID          string `json:"id"`
+	Connections int    `json:"connections"`
+	Spectators  int    `json:"spectators"`
+	Workspaces  int    `json:"workspaces"`
+	Started     int64  `json:"started"`
+	Updated     int64  `json:"updated"`
+}
+
+type roomOverview struct {
+	Name             string `json:"name"`
+	Members          int64  `json:"members"`
+	MessagesLastHour int64  `json:"messagesLastHour"`
+}
+
+type talker struct {
+	Name     string `json:"name"`
+	Messages int64  `json:"messages"`
+}
+
+func handleOverviewAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	ws, ok := lookupWorkspace(ctx, r.URL.Query().Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+
+	instances := instanceOverviews(ctx)
+	total := 0
+	for _, in := range instances {
+		total += in.Connections
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instances":   instances,
+		"connections": total,
+		"workspace":   string(ws),
+		"rooms":       roomOverviews(ctx, ws),
+		"topTalkers":  topTalkers(ctx, ws),
+		"bans":        bans(ctx, ws),
+		"mutes":       activeMutes(ctx, ws),
+	})
+}
+
+// bans maps banned names to the reason given.
+func bans(ctx context.Context, ws workspace) map[string]string {
+	list, _ := rdb.HGetAll(ctx, ws.bansKey()).Result()
+	return list
+}
+
+// activeMutes maps muted names to when the mute ends (unix seconds), or
+// nil for indefinite mutes.
+func activeMutes(ctx context.Context, ws workspace) map[string]interface{} {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	list, _ := rdb.ZRangeByScoreWithScores(ctx, ws.mutesKey(), &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	mutes := map[string]interface{}{}
+	for _, z := range list {
+		if math.IsInf(z.Score, 1) {
+			mutes[z.Member.(string)] = nil
+		} else {
+			mutes[z.Member.(string)] = int64(z.Score)
+		}
+	}
+	return mutes
+}
+
+// instanceOverviews lists the live instances, i.e. those with a recent
+// heartbeat. An instance that hasn't reported stats yet shows zeros.
+func instanceOverviews(ctx context.Context) []instanceOverview {
+	ids, _ := rdb.ZRangeByScore(ctx, instancesKey(), &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-instanceTTL).Unix(), 10),
+		Max: "+inf",
+	}).Result()
+
+	list := []instanceOverview{}
+	for _, id := range ids {
+		stats, _ := rdb.HGetAll(ctx, instanceStatsKey(id)).Result()
+		in := instanceOverview{ID: id}
+		in.Connections, _ = strconv.Atoi(stats["connections"])
+		in.Spectators, _ = strconv.Atoi(stats["spectators"])
+		in.Workspaces, _ = strconv.Atoi(stats["workspaces"])
+		in.Started, _ = strconv.ParseInt(stats["started"], 10, 64)
+		in.Updated, _ = strconv.ParseInt(stats["updated"], 10, 64)
+		list = append(list, in)
+	}
+	return list
+}
+
+// roomOverviews lists every room in ws, busiest first.
+func roomOverviews(ctx context.Context, ws workspace) []roomOverview {
+	since := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	// Room names may contain ':', so cut the known ends off the key.
+	suffix := ":meta"
+	prefix := strings.TrimSuffix(ws.roomMetaKey(""), suffix)
+
+	list := []roomOverview{}
+	iter := rdb.Scan(ctx, 0, ws.roomMetaKey("*"), 500).Iterator()
+	for iter.Next(ctx) {
+		room := strings.TrimSuffix(strings.TrimPrefix(iter.Val(), prefix), suffix)
+		members, _ := rdb.SCard(ctx, ws.roomMembersKey(room)).Result()
+		recent, _ := countBetween(ctx, ws, ws.roomMessagesKey(room), since, "+inf")
+		list = append(list, roomOverview{Name: room, Members: members, MessagesLastHour: recent})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MessagesLastHour != list[j].MessagesLastHour {
+			return list[i].MessagesLastHour > list[j].MessagesLastHour
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// topTalkers adds up the buckets of the last hour.
+func topTalkers(ctx context.Context, ws workspace) []talker {
+	counts := map[string]int64{}
+	now := time.Now()
+	for t := now.Add(-talkerWindow).Truncate(talkerBucket); !t.After(now); t = t.Add(talkerBucket) {
+		scores, _ := rdb.ZRangeWithScores(ctx, ws.talkersKey(t.Unix()), 0, -1).Result()
+		for _, z := range scores {
+			counts[z.Member.(string)] += int64(z.Score)
+		}
+	}
+
+	list := []talker{}
+	for name, n := range counts {
+		list = append(list, talker{Name: name, Messages: n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Messages != list[j].Messages {
+			return list[i].Messages > list[j].Messages
+		}
+		return list[i].Name < list[j].Name
+	})
+	if len(list) > overviewTalker {
+		list = list[:overviewTalker]
+	}
+	return list
+}
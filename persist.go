@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Message persistence. Every chat message is stored with storeMessage
+// before it is published. If Redis fails the write (a timeout, a failover),
+// the message is parked in an in-process spill queue of CHAT_SPILL_BUFFER
+// entries and still published; a background retrier keeps writing it with
+// backoff until Redis takes it. Only when the spill queue is full is the
+// message dropped and the sender told (a not_stored error). On shutdown the
+// queue is drained for up to spillDrainTimeout, and whatever is still in it
+// is logged message by message as lost.
+const (
+	spillBackoffMin   = 100 * time.Millisecond
+	spillBackoffMax   = 10 * time.Second
+	spillDrainTimeout = 10 * time.Second
+)
+
+type spilledMessage struct {
+	ws     workspace
+	key    string
+	id     string
+	time   int64
+	seq    int64  // 0 until Redis has assigned one
+	member string // sealed
+
+	user     string
+	activity string // counter to bump once stored; see activity.go
+}
+
+var (
+	spillQueue chan spilledMessage
+	spillDrain = make(chan time.Time, 1) // shutdown sends the drain deadline
+	spillDone  = make(chan struct{})     // closed when the retrier exits
+
+	spilled        atomic.Int64
+	spillRecovered atomic.Int64
+	spillLost      atomic.Int64
+)
+
+// startSpill starts the spill queue's retrier.
+func startSpill() {
+	spillQueue = make(chan spilledMessage, cfg().SpillBuffer)
+	go retrySpilled(spillQueue)
+}
+
+// storeMessage adds msg (jsonMsg encoded) to the zset at key, one of ws's
+// conversations, spilling it for retry if Redis fails. It reports false
+// only if the message could be neither stored nor spilled; callers must
+// then not publish it.
+func storeMessage(ctx context.Context, ws workspace, key string, msg ChatMessage, jsonMsg []byte) bool {
+	registerConversation(ctx, ws, key)
+	s := spilledMessage{ws: ws, key: key, id: msg.ID, time: msg.Time, member: seal(jsonMsg),
+		user: msg.User, activity: activityKind(ws, key, msg)}
+	err := insertMessage(ctx, &s)
+	if err == nil {
+		return true
+	}
+
+	select {
+	case spillQueue <- s:
+		log.Printf("💾 Storing message %s in %s failed (%v); spilled for retry (%d queued)", s.id, key, err, len(spillQueue))
+		spilled.Add(1)
+		// The message will be stored after all, so the sender shouldn't
+		// get a timeout error for it.
+		if c, ok := ctx.Value(clientKey{}).(*client); ok {
+			c.timedOut.Store(false)
+		}
+		return true
+	default:
+		spillLost.Add(1)
+		log.Printf("❌ Storing message %s in %s failed (%v) and the spill queue is full; message dropped", s.id, key, err)
+		adminAlertf("spill_full", "spill queue full; %d message(s) dropped so far", spillLost.Load())
+		return false
+	}
+}
+
+// retrySpilled writes spilled messages one at a time, backing off while
+// Redis keeps failing. Once shutdown sends a deadline it exits as soon as
+// the queue is empty, or at the deadline, logging what it couldn't store.
+func retrySpilled(queue chan spilledMessage) {
+	defer close(spillDone)
+	var deadline time.Time
+	for {
+		if !deadline.IsZero() && len(queue) == 0 {
+			return
+		}
+		var s spilledMessage
+		select {
+		case s = <-queue:
+		case deadline = <-spillDrain:
+			continue
+		}
+
+		backoff := spillBackoffMin
+		for !storeSpilled(&s) {
+			if !deadline.IsZero() && time.Now().Add(backoff).After(deadline) {
+				loseSpilled(s)
+				for len(queue) > 0 {
+					loseSpilled(<-queue)
+				}
+				return
+			}
+			select {
+			case <-time.After(backoff):
+			case deadline = <-spillDrain:
+			}
+			backoff = min(backoff*2, spillBackoffMax)
+		}
+		spillRecovered.Add(1)
+		log.Printf("💾 Stored spilled message %s in %s (%d still queued)", s.id, s.key, len(queue))
+	}
+}
+
+// insertMessage takes a sequence number for s, unless it already has one
+// from an earlier attempt, and writes it. A spilled message is numbered
+// when it is finally stored, so it sorts after what was stored meanwhile.
+func insertMessage(ctx context.Context, s *spilledMessage) error {
+	if s.seq == 0 {
+		seq, err := nextSeq(ctx, s.ws, s.key)
+		if err != nil {
+			return err
+		}
+		s.seq = seq
+	}
+	pipe := rdb.TxPipeline()
+	addOrdered(ctx, pipe, s.ws, s.key, s.seq, s.time, s.member)
+	if max := cfg().HistoryMax; max > 0 {
+		trimOrdered(ctx, pipe, s.ws, s.key, max)
+	}
+
+	if s.activity != "" {
+		countActivity(ctx, pipe, s.ws, s.activity, s.user, s.time)
+	}
+	if peer, ok := s.ws.dmPeer(s.key, s.user); ok {
+		indexDM(ctx, pipe, s.ws, s.user, peer, s.time)
+	}
+	addFirehose(ctx, pipe, s)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func storeSpilled(s *spilledMessage) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg().RedisTimeout)
+	defer cancel()
+	return insertMessage(ctx, s) == nil
+}
+
+func loseSpilled(s spilledMessage) {
+	spillLost.Add(1)
+	log.Printf("❌ Lost spilled message %s for %s (time %d): never stored", s.id, s.key, s.time)
+}
+
+// drainSpill waits for the spill queue to be written out, up to
+// spillDrainTimeout.
+func drainSpill() {
+	if spillQueue == nil {
+		return
+	}
+	if n := len(spillQueue); n > 0 {
+		log.Printf("💾 Writing %d spilled message(s) before exiting", n)
+	}
+	spillDrain <- time.Now().Add(spillDrainTimeout)
+	<-spillDone
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Polls. {"type":"poll_create","question":"Lunch?","options":["pizza","sushi"],"duration":"10m"}
+// posts a message of kind "poll" to the public timeline (or, with "room",
+// to a room the sender is in), stored and delivered like any message.
+// Members vote with {"type":"poll_vote","pollId":"...","option":1}; each
+// has one vote, which they may change until the poll closes.
+//
+// A poll's question, options and closing time live in chat:poll:<id>
+// (hash) and its votes in chat:poll:<id>:votes (hash: name -> option).
+// Tallies go out as poll_update frames at most once a second per poll,
+// whichever instance the votes arrive at: the first vote takes
+// chat:poll:<id>:update and the broadcast a second later counts everything
+// voted by then. Closing is scheduled in chat:polls:closing (sorted set of
+// <workspace>/<id> by closing time), which every instance checks each
+// second; the one that takes a poll off it freezes the tallies as the
+// final result in the poll's hash and broadcasts it. History carries the
+// poll message as it was posted; init and room_joined replace its poll
+// with the current state.
+const (
+	pollMinOptions   = 2
+	pollMaxOptions   = 10
+	pollMaxQuestion  = 300 // runes
+	pollMaxOption    = 100 // runes
+	pollMinDuration  = 10 * time.Second
+	pollMaxDuration  = 7 * 24 * time.Hour
+	pollUpdateWindow = time.Second
+	pollCloseCheck   = time.Second
+
+	pollKind = "poll"
+)
+
+// {"type":"poll_create","room":"general","question":"Lunch?","options":["pizza","sushi"],"duration":"10m","tempId":"t1"}
+func handlePollCreate(c *client, data []byte) {
+	ctx, ws := c.ctx, c.ws
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	var req protocol.PollCreateRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validTempID(req.TempID) {
+		sendError(c, "bad_frame", "invalid poll_create frame")
+		return
+	}
+	duration, msg := parsePoll(&req)
+	if msg != "" {
+		sendError(c, "bad_poll", msg)
+		return
+	}
+	conversation, kind := "global", "msg"
+	if req.Room != "" {
+		if !requireRoomMember(c, req.Room, name) {
+			return
+		}
+		if readOnlyRoom(req.Room) {
+			if owner, _ := rdb.HGet(ctx, ws.roomMetaKey(req.Room), "owner").Result(); owner != name {
+				sendError(c, "read_only", req.Room+" is read-only")
+				return
+			}
+		}
+		if !checkSlowMode(c, req.Room, name) {
+			return
+		}
+		conversation, kind = "room:"+req.Room, "room"
+	}
+	if !takeQuota(c) {
+		return
+	}
+
+	m := newMessage(name, req.Question)
+	m.Kind = pollKind
+	if !runInbound(c, kind, conversation, &m) {
+		return
+	}
+	poll := protocol.Poll{ID: m.ID, Room: req.Room, Question: m.Text, Options: req.Options,
+		Votes: make([]int64, len(req.Options)), Closes: time.Now().Add(duration).Unix()}
+	m.Poll = &poll
+	if err := savePoll(ctx, ws, poll); err != nil {
+		sendError(c, "not_stored", "poll could not be stored; please retry")
+		return
+	}
+
+	c.expectEcho(m.ID, req.TempID)
+	if req.Room != "" {
+		if !postRoomMessage(ctx, ws, req.Room, m) {
+			sendError(c, "not_stored", "message could not be stored; please retry")
+			return
+		}
+		setReadPosition(ctx, ws, name, conversation, protocol.ReadPosition{ID: m.ID, Time: m.Time})
+	} else {
+		jsonMsg, _ := json.Marshal(m)
+		if !storeMessage(ctx, ws, ws.messagesKey(), m, jsonMsg) {
+			sendError(c, "not_stored", "message could not be stored; please retry")
+			return
+		}
+		publish(ws.messagesChannel(), jsonMsg)
+	}
+	sendAck(c, req.TempID, m)
+	notifyWatchers(ctx, ws, req.Room, m)
+	touchActivity(ctx, ws, name)
+	countTalker(ctx, ws, name)
+	if req.Room != "" {
+		recordEvent(ws, chatEvent{Type: "room_message", User: name, Room: req.Room, Len: len(m.Text)})
+	} else {
+		recordEvent(ws, chatEvent{Type: "message", User: name, Len: len(m.Text)})
+	}
+	bridgeMessage(ws, conversation, m)
+}
+
+// parsePoll checks a poll_create request, trimming its texts, and returns
+// how long the poll stays open, or what is wrong with it.
+func parsePoll(req *protocol.PollCreateRequest) (time.Duration, string) {
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" || utf8.RuneCountInString(req.Question) > pollMaxQuestion {
+		return 0, "a poll needs a question of at most " + strconv.Itoa(pollMaxQuestion) + " characters"
+	}
+	if len(req.Options) < pollMinOptions || len(req.Options) > pollMaxOptions {
+		return 0, "a poll needs " + strconv.Itoa(pollMinOptions) + " to " + strconv.Itoa(pollMaxOptions) + " options"
+	}
+	seen := map[string]bool{}
+	for i, o := range req.Options {
+		o = strings.TrimSpace(o)
+		if o == "" || utf8.RuneCountInString(o) > pollMaxOption || seen[strings.ToLower(o)] {
+			return 0, "options must be distinct and at most " + strconv.Itoa(pollMaxOption) + " characters"
+		}
+		seen[strings.ToLower(o)] = true
+		req.Options[i] = o
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d < pollMinDuration || d > pollMaxDuration {
+		return 0, "duration must be between " + pollMinDuration.String() + " and 168h, e.g. 10m"
+	}
+	return d, ""
+}
+
+// savePoll stores a new poll and schedules its closing.
+func savePoll(ctx context.Context, ws workspace, poll protocol.Poll) error {
+	options, _ := json.Marshal(poll.Options)
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, ws.pollKey(poll.ID), "room", poll.Room, "question", poll.Question, "options", options, "closes", poll.Closes)
+	pipe.ZAdd(ctx, pollsClosingKey(), redis.Z{Score: float64(poll.Closes), Member: string(ws) + "/" + poll.ID})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// {"type":"poll_vote","pollId":"...","option":1}
+func handlePollVote(c *client, data []byte) {
+	ctx, ws := c.ctx, c.ws
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	var req protocol.PollVoteRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.PollID == "" {
+		sendError(c, "bad_frame", "invalid poll_vote frame")
+		return
+	}
+	poll, ok := loadPoll(ctx, ws, req.PollID)
+	if !ok {
+		sendError(c, "not_found", "no such poll")
+		return
+	}
+	if poll.Room != "" && !requireRoomMember(c, poll.Room, name) {
+		return
+	}
+	if poll.Closed || time.Now().Unix() >= poll.Closes {
+		sendError(c, "poll_closed", "the poll has closed")
+		return
+	}
+	if req.Option < 0 || req.Option >= len(poll.Options) {
+		sendError(c, "bad_poll", "no such option")
+		return
+	}
+	if err := rdb.HSet(ctx, ws.pollVotesKey(poll.ID), name, req.Option).Err(); err != nil {
+		sendError(c, "not_stored", "vote could not be stored; please retry")
+		return
+	}
+	schedulePollUpdate(ws, poll.ID)
+}
+
+// schedulePollUpdate broadcasts the poll's tallies in a second, unless an
+// instance already means to.
+func schedulePollUpdate(ws workspace, id string) {
+	if ok, _ := rdb.SetNX(serverCtx, ws.pollUpdateKey(id), instanceID, 2*pollUpdateWindow).Result(); !ok {
+		return
+	}
+	time.AfterFunc(pollUpdateWindow, func() {
+		// Let go first: a vote that finds the key gone schedules the next
+		// update, and any vote that found it taken is counted below.
+		rdb.Del(serverCtx, ws.pollUpdateKey(id))
+		if poll, ok := loadPoll(serverCtx, ws, id); ok && !poll.Closed {
+			publishPoll(serverCtx, ws, poll)
+		}
+	})
+}
+
+// publishPoll sends a poll_update to whoever sees the poll.
+func publishPoll(ctx context.Context, ws workspace, poll protocol.Poll) {
+	frame, _ := json.Marshal(protocol.NewPollUpdate(poll))
+	if poll.Room != "" {
+		publishRoom(ctx, ws, poll.Room, frame, nil)
+		return
+	}
+	publish(ws.messagesChannel(), frame)
+}
+
+// loadPoll reads a poll with its current tallies, or its final result.
+func loadPoll(ctx context.Context, ws workspace, id string) (protocol.Poll, bool) {
+	pipe := rdb.Pipeline()
+	meta := pipe.HGetAll(ctx, ws.pollKey(id))
+	votes := pipe.HGetAll(ctx, ws.pollVotesKey(id))
+	pipe.Exec(ctx)
+	return pollOf(id, meta.Val(), votes.Val())
+}
+
+func pollOf(id string, meta, votes map[string]string) (protocol.Poll, bool) {
+	poll := protocol.Poll{ID: id, Room: meta["room"], Question: meta["question"]}
+	if json.Unmarshal([]byte(meta["options"]), &poll.Options) != nil || len(poll.Options) == 0 {
+		return protocol.Poll{}, false
+	}
+	poll.Closes, _ = strconv.ParseInt(meta["closes"], 10, 64)
+	if final, ok := meta["final"]; ok && json.Unmarshal([]byte(final), &poll.Votes) == nil {
+		poll.Closed = true
+		return poll, true
+	}
+	poll.Votes = make([]int64, len(poll.Options))
+	for _, v := range votes {
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 && i < len(poll.Votes) {
+			poll.Votes[i]++
+		}
+	}
+	return poll, true
+}
+
+// withPolls replaces the poll of each poll message in history with its
+// current state, in one round trip.
+func withPolls(ctx context.Context, ws workspace, history []ChatMessage) []ChatMessage {
+	var polls []int
+	for i, m := range history {
+		if m.Kind == pollKind && m.Poll != nil {
+			polls = append(polls, i)
+		}
+	}
+	if len(polls) == 0 {
+		return history
+	}
+	pipe := rdb.Pipeline()
+	metas := make([]*redis.MapStringStringCmd, len(polls))
+	votes := make([]*redis.MapStringStringCmd, len(polls))
+	for k, i := range polls {
+		metas[k] = pipe.HGetAll(ctx, ws.pollKey(history[i].ID))
+		votes[k] = pipe.HGetAll(ctx, ws.pollVotesKey(history[i].ID))
+	}
+	pipe.Exec(ctx)
+	history = append([]ChatMessage{}, history...)
+	for k, i := range polls {
+		if poll, ok := pollOf(history[i].ID, metas[k].Val(), votes[k].Val()); ok {
+			history[i].Poll = &poll
+		}
+	}
+	return history
+}
+
+// runPollClosing closes polls as they come due until ctx is cancelled.
+func runPollClosing(ctx context.Context) {
+	tick := time.NewTicker(pollCloseCheck)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			closeDuePolls(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func closeDuePolls(ctx context.Context) {
+	due, err := rdb.ZRangeByScore(ctx, pollsClosingKey(), &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(time.Now().Unix(), 10)}).Result()
+	if err != nil {
+		return
+	}
+	for _, member := range due {
+		// Whoever takes it off the schedule closes it.
+		if n, err := rdb.ZRem(ctx, pollsClosingKey(), member).Result(); err != nil || n == 0 {
+			continue
+		}
+		ws, id, _ := strings.Cut(member, "/")
+		closePoll(ctx, workspace(ws), id)
+	}
+}
+
+// closePoll freezes the poll's tallies as its final result and broadcasts
+// it.
+func closePoll(ctx context.Context, ws workspace, id string) {
+	poll, ok := loadPoll(ctx, ws, id)
+	if !ok || poll.Closed {
+		return
+	}
+	final, _ := json.Marshal(poll.Votes)
+	if err := rdb.HSet(ctx, ws.pollKey(id), "final", final).Err(); err != nil {
+		log.Printf("❌ Closing poll %s failed: %v", id, err)
+		rdb.ZAdd(ctx, pollsClosingKey(), redis.Z{Score: float64(poll.Closes), Member: string(ws) + "/" + id})
+		return
+	}
+	poll.Closed = true
+	publishPoll(ctx, ws, poll)
+}
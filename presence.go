@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+)
+
+// Each server instance records the names it has live connections for in
+// chat:instance:<id>:members (one set per workspace) and heartbeats into
+// chat:instances. Anything in a workspace's chat:members that no live
+// instance owns is a ghost left behind by a crash.
+//
+// A user may be connected several times, on one instance or several. Each
+// instance counts its own connections per name (localPresence), and a
+// name leaves its instance set when the count drops to zero, and
+// chat:members only when no other live instance has it either.
+const (
+	heartbeatInterval = 10 * time.Second
+	instanceTTL       = 3 * heartbeatInterval
+	reconcileInterval = time.Minute
+	// Members that joined within the grace period are never reaped, so an
+	// instance that is still starting up can't lose its users to a peer.
+	reconcileGrace = 2 * instanceTTL
+)
+
+var instanceID = ids.New()
+
+var (
+	localPresenceMu sync.Mutex
+	localPresence   = map[workspace]map[string]int{} // workspace -> name -> this instance's connections
+)
+
+// countPresence adds delta to name's connections on this instance and
+// returns the new count.
+func countPresence(ws workspace, name string, delta int) int {
+	localPresenceMu.Lock()
+	defer localPresenceMu.Unlock()
+	names := localPresence[ws]
+	if names == nil {
+		names = map[string]int{}
+		localPresence[ws] = names
+	}
+	n := names[name] + delta
+	if n <= 0 {
+		delete(names, name)
+		return 0
+	}
+	names[name] = n
+	return n
+}
+
+// localNames returns the names ws has connections for on this instance.
+func localNames(ws workspace) map[string]bool {
+	localPresenceMu.Lock()
+	defer localPresenceMu.Unlock()
+	names := map[string]bool{}
+	for name := range localPresence[ws] {
+		names[name] = true
+	}
+	return names
+}
+
+// addPresence counts a connection of name's and registers name as online
+// and owned by this instance. The instance set is written first so a
+// concurrent reconcile never sees the member without an owner.
+func addPresence(ctx context.Context, ws workspace, name string, spectator bool) {
+	countPresence(ws, name, 1)
+	writePresence(ctx, ws, name, spectator)
+}
+
+func writePresence(ctx context.Context, ws workspace, name string, spectator bool) {
+	rdb.SAdd(ctx, ws.instanceMembersKey(instanceID), name)
+	rdb.ZAdd(ctx, ws.membersSinceKey(), redis.Z{Score: float64(time.Now().Unix()), Member: name})
+	if spectator {
+		rdb.SAdd(ctx, ws.spectatorsKey(), name)
+	} else {
+		rdb.SRem(ctx, ws.spectatorsKey(), name)
+	}
+	rdb.SAdd(ctx, ws.membersKey(), name)
+	publish(ws.memberAddChannel(), []byte(name))
+}
+
+// removePresence uncounts a connection of name's. Only the last one on
+// this instance takes name out of the instance set, and only if no other
+// live instance has name is it taken offline. A connection counted while
+// that was under way puts name back.
+func removePresence(ctx context.Context, ws workspace, name string) {
+	if countPresence(ws, name, -1) > 0 {
+		return
+	}
+	rdb.SRem(ctx, ws.instanceMembersKey(instanceID), name)
+	if !presentElsewhere(ctx, ws, name) {
+		dropPresence(ctx, ws, name)
+	}
+	if countPresence(ws, name, 0) > 0 {
+		spectator, _ := rdb.SIsMember(ctx, ws.spectatorsKey(), name).Result()
+		writePresence(ctx, ws, name, spectator)
+	}
+}
+
+// dropPresence takes name offline whatever its connections, as when the
+// account is deleted.
+func dropPresence(ctx context.Context, ws workspace, name string) {
+	rdb.SRem(ctx, ws.membersKey(), name)
+	rdb.ZRem(ctx, ws.membersSinceKey(), name)
+	rdb.SRem(ctx, ws.spectatorsKey(), name)
+	rdb.SRem(ctx, ws.instanceMembersKey(instanceID), name)
+	publish(ws.memberRemoveChannel(), []byte(name))
+}
+
+// presentElsewhere reports whether another live instance has connections
+// for name.
+func presentElsewhere(ctx context.Context, ws workspace, name string) bool {
+	cutoff := strconv.FormatInt(time.Now().Unix()-int64(instanceTTL.Seconds()), 10)
+	live, _ := rdb.ZRangeByScore(ctx, instancesKey(), &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	pipe := rdb.Pipeline()
+	var owned []*redis.BoolCmd
+	for _, id := range live {
+		if id != instanceID {
+			owned = append(owned, pipe.SIsMember(ctx, ws.instanceMembersKey(id), name))
+		}
+	}
+	if len(owned) == 0 {
+		return false
+	}
+	pipe.Exec(ctx)
+	for _, cmd := range owned {
+		if cmd.Val() {
+			return true
+		}
+	}
+	return false
+}
+
+// heartbeat marks this instance live and rewrites its instance sets from
+// the connections it counts, which also puts back a set lost with Redis.
+func heartbeat(ctx context.Context) {
+	pipe := rdb.Pipeline()
+	pipe.ZAdd(ctx, instancesKey(), redis.Z{Score: float64(time.Now().Unix()), Member: instanceID})
+	for _, ws := range knownWorkspaces(ctx) {
+		for name := range localNames(ws) {
+			pipe.SAdd(ctx, ws.instanceMembersKey(instanceID), name)
+		}
+		pipe.Expire(ctx, ws.instanceMembersKey(instanceID), instanceTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println("❌ Heartbeat error:", err)
+	}
+	refreshHeldNames(ctx)
+}
+
+// runPresence writes the first heartbeat and reconciles once before the
+// server starts accepting connections, then keeps both going in the
+// background until ctx is cancelled.
+func runPresence(ctx context.Context) {
+	heartbeat(ctx)
+	reconcileMembers(ctx)
+
+	go func() {
+		beat := time.NewTicker(heartbeatInterval)
+		reconcile := time.NewTicker(reconcileInterval)
+		for {
+			select {
+			case <-beat.C:
+				heartbeat(ctx)
+			case <-reconcile.C:
+				reconcileMembers(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func reconcileMembers(ctx context.Context) {
+	now := time.Now().Unix()
+	cutoff := strconv.FormatInt(now-int64(instanceTTL.Seconds()), 10)
+	workspaces := knownWorkspaces(ctx)
+
+	// Forget instances that stopped heartbeating.
+	dead, _ := rdb.ZRangeByScore(ctx, instancesKey(), &redis.ZRangeBy{Min: "-inf", Max: "(" + cutoff}).Result()
+	for _, id := range dead {
+		for _, ws := range workspaces {
+			rdb.Del(ctx, ws.instanceMembersKey(id))
+		}
+		rdb.ZRem(ctx, instancesKey(), id)
+	}
+	if len(dead) > 0 {
+		adminAlert("instance_down", describeInstances(dead)+" stopped heartbeating")
+	}
+
+	live, _ := rdb.ZRangeByScore(ctx, instancesKey(), &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	removed := 0
+	for _, ws := range workspaces {
+		removed += reconcileWorkspace(ctx, ws, live, now)
+	}
+	if removed > 0 {
+		fmt.Printf("🧹 Removed %d ghost member(s)\n", removed)
+	}
+}
+
+func reconcileWorkspace(ctx context.Context, ws workspace, live []string, now int64) int {
+	// Read the member set before the owners: addPresence writes in the
+	// opposite order, so every member we see already has its owner recorded.
+	members, err := rdb.SMembers(ctx, ws.membersKey()).Result()
+	if err != nil {
+		log.Println("❌ Reconcile error:", err)
+		return 0
+	}
+
+	// This instance's own names are the ones it counts; any others left in
+	// its set (a failed SRem) are dropped from it.
+	owned := localNames(ws)
+	var keys []string
+	for _, id := range live {
+		if id != instanceID {
+			keys = append(keys, ws.instanceMembersKey(id))
+		}
+	}
+	if len(keys) > 0 {
+		names, _ := rdb.SUnion(ctx, keys...).Result()
+		for _, n := range names {
+			owned[n] = true
+		}
+	}
+	if mine, _ := rdb.SMembers(ctx, ws.instanceMembersKey(instanceID)).Result(); len(mine) > 0 {
+		local := localNames(ws)
+		for _, n := range mine {
+			if !local[n] {
+				rdb.SRem(ctx, ws.instanceMembersKey(instanceID), n)
+			}
+		}
+	}
+
+	removed := 0
+	for _, name := range members {
+		if owned[name] {
+			continue
+		}
+		since, err := rdb.ZScore(ctx, ws.membersSinceKey(), name).Result()
+		if err == nil && int64(since) > now-int64(reconcileGrace.Seconds()) {
+			continue
+		}
+		if n, _ := rdb.SRem(ctx, ws.membersKey(), name).Result(); n == 0 {
+			continue // another instance got there first
+		}
+		rdb.ZRem(ctx, ws.membersSinceKey(), name)
+		rdb.SRem(ctx, ws.spectatorsKey(), name)
+		publish(ws.memberRemoveChannel(), []byte(name))
+		removed++
+	}
+	return removed
+}
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestPresenceConnections checks that a user with two connections, on one
+// instance or on two sharing a store, stays online when one closes, and
+// goes offline when the other does.
+func TestPresenceConnections(t *testing.T) {
+	store := serveStore(t, 0)
+	a, b := startServer(t, store, withAdmin), startServer(t, store, withAdmin)
+	for _, tc := range []struct {
+		name, user, second string
+	}{
+		{"one instance", "alice", a},
+		{"two instances", "carol", b},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bob := dial(t, a, "?roster=events", "bob-"+tc.user)
+			first := dial(t, a, "", tc.user)
+			second := dial(t, tc.second, "", tc.user)
+			sessionID(t, first) // until both joins are handled
+			sessionID(t, second)
+
+			first.Close()
+			left := 0
+			if tc.second == a {
+				left = 1
+			}
+			awaitConnections(t, a, tc.user, left)
+			if !slices.Contains(memberNames(t, bob), tc.user) {
+				t.Errorf("%s left the member list with a connection still open", tc.user)
+			}
+			if !searchOnline(t, a, tc.user) {
+				t.Errorf("/api/members has %s offline with a connection still open", tc.user)
+			}
+
+			second.Close()
+			for {
+				var remove protocol.MemberRemove
+				decode(t, await(t, bob, protocol.TypeMemberRemove), &remove)
+				if remove.Name == tc.user {
+					break
+				}
+			}
+			if slices.Contains(memberNames(t, bob), tc.user) {
+				t.Errorf("%s is still a member with no connections", tc.user)
+			}
+			if searchOnline(t, a, tc.user) {
+				t.Errorf("/api/members has %s online with no connections", tc.user)
+			}
+		})
+	}
+}
+
+// awaitConnections waits until the server at addr counts n connections
+// for name.
+func awaitConnections(t *testing.T, addr, name string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		var s struct {
+			Connections []protocol.ConnectionStats `json:"connections"`
+		}
+		stats(t, addr, &s)
+		got := 0
+		for _, c := range s.Connections {
+			if c.Name == name {
+				got++
+			}
+		}
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has %d connections, want %d", name, got, n)
+		}
+	}
+}
+
+// searchOnline reports whether GET /api/members has name online.
+func searchOnline(t *testing.T, addr, name string) bool {
+	t.Helper()
+	m, _ := searchMember(t, addr, name)
+	return m.Online
+}
@@ -0,0 +1,93 @@
+package main_test
+
+import (
+	"net/http"
+	"testing"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestPrivilegeEscalation checks that a user who isn't an admin, or isn't
+// a room's owner, is refused the moderation and room commands reserved
+// for them, including after a failed admin_auth, and that nothing they
+// tried took effect.
+func TestPrivilegeEscalation(t *testing.T) {
+	addr := startServer(t, "", withAdmin)
+	alice := dial(t, addr, "", "alice")
+	alice.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "general"})
+	await(t, alice, protocol.TypeRoomJoined)
+	bob := dial(t, addr, "", "bob")
+	bob.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "general"})
+	await(t, bob, protocol.TypeRoomJoined)
+	mallory := dial(t, addr, "", "mallory")
+
+	moderation := []interface{}{
+		map[string]string{"type": protocol.TypeAdminSubscribe},
+		protocol.ModerationRequest{Type: protocol.TypeAdminKick, Name: "bob"},
+		protocol.ModerationRequest{Type: protocol.TypeAdminBan, Name: "bob"},
+		protocol.ModerationRequest{Type: protocol.TypeAdminUnban, Name: "mallory"},
+		protocol.ModerationRequest{Type: protocol.TypeAdminMute, Name: "bob"},
+		protocol.ModerationRequest{Type: protocol.TypeAdminUnmute, Name: "mallory"},
+		protocol.AnnounceRequest{Type: protocol.TypeAdminAnnounce, Text: "free admin", Sticky: true},
+		protocol.UnannounceRequest{Type: protocol.TypeAdminUnannounce, NoticeID: "x"},
+		protocol.PinRequest{Type: protocol.TypeAdminPin, Text: "free admin"},
+		protocol.GenInvitesRequest{Type: protocol.TypeGenInvites, Count: 1},
+	}
+	t.Run("admin commands", func(t *testing.T) {
+		for _, frame := range moderation {
+			mallory.SendFrame(frame)
+			refused(t, mallory, "forbidden")
+		}
+	})
+
+	t.Run("failed admin_auth", func(t *testing.T) {
+		for _, token := range []string{"", "wrong", adminToken + "x"} {
+			mallory.SendFrame(protocol.AdminAuthRequest{Type: protocol.TypeAdminAuth, Token: token})
+			refused(t, mallory, "forbidden")
+		}
+		for _, frame := range moderation {
+			mallory.SendFrame(frame)
+			refused(t, mallory, "forbidden")
+		}
+	})
+
+	t.Run("room owner's commands", func(t *testing.T) {
+		slow, topic, private, permanent := 3600, "bob's room now", true, true
+		for _, frame := range []interface{}{
+			protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "general", SlowModeSeconds: &slow},
+			protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "general", Topic: &topic},
+			protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "general", Private: &private},
+			protocol.RoomSetCapacityRequest{Type: protocol.TypeRoomSetCapacity, Room: "general", MaxMembers: 2},
+			protocol.RoomDeleteRequest{Type: protocol.TypeRoomDelete, Room: "general"},
+		} {
+			bob.SendFrame(frame)
+			refused(t, bob, "forbidden")
+		}
+		// Only an admin makes a room permanent, not even its owner.
+		alice.SendFrame(protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "general", Permanent: &permanent})
+		refused(t, alice, "forbidden")
+	})
+
+	t.Run("nothing changed", func(t *testing.T) {
+		bob.SendFrame(protocol.RoomInfoRequest{Type: protocol.TypeRoomInfo, Room: "general"})
+		var info protocol.RoomInfo
+		decode(t, await(t, bob, protocol.TypeRoom), &info)
+		if r := info.Room; r.Owner != "alice" || r.Members != 2 || r.SlowModeSeconds != 0 || r.Topic != "" || r.Private || r.Permanent || r.MaxMembers == 2 {
+			t.Errorf("general is now %+v", r)
+		}
+		bob.Send("bob", "still here")
+		if f := await(t, bob, "message", protocol.TypeError); f.Message == nil || f.Message.Text != "still here" {
+			t.Errorf("bob, neither kicked, banned nor muted, got %s", f.Raw)
+		}
+	})
+
+	t.Run("admin API", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			for _, path := range []string{"/api/workspaces", "/api/admin/tokens", "/api/admin/invites"} {
+				if code, data := apiAs(t, addr, token, http.MethodPost, path, []byte(`{"id":"mine","name":"mallory"}`)); code != http.StatusUnauthorized {
+					t.Errorf("POST %s with token %q got %d: %s", path, token, code, data)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/protocol"
+)
+
+// Subprotocols. A client may name the protocols it speaks in
+// Sec-WebSocket-Protocol; the upgrader picks the first of ours, in our
+// order of preference, that the client offered, and echoes it back. Clients
+// that send no header get the default and no header in return, as before.
+// A client that offers only protocols we don't know is upgraded and then
+// closed at once with 1002 and a reason listing what we support, rather than
+// being left to guess why its handshake failed.
+//
+// Every protocol so far is JSON; the version tells clients which frames to
+// expect.
+const defaultProtocol = "chat.v1.json"
+
+var subprotocols = []string{defaultProtocol}
+
+// protocolVersions maps each subprotocol to its protocol version.
+var protocolVersions = map[string]int{
+	"chat.v1.json": 1,
+}
+
+// unsupportedProtocols reports whether r asks only for subprotocols we
+// don't speak.
+func unsupportedProtocols(r *http.Request) bool {
+	offered := websocket.Subprotocols(r)
+	if len(offered) == 0 {
+		return false
+	}
+	for _, p := range offered {
+		if slices.Contains(subprotocols, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// refuseProtocol closes a freshly upgraded connection whose client offered
+// no subprotocol we speak.
+func refuseProtocol(conn *websocket.Conn, r *http.Request) {
+	fmt.Printf("🚫 Refused connection offering only %s\n", strings.Join(websocket.Subprotocols(r), ", "))
+	reason := closeReason(protocol.CloseUnsupportedProtocol, "unsupported subprotocol; supported: "+strings.Join(subprotocols, ", "))
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, reason), time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// Frames refused before parsing: binary, invalid UTF-8 or over
+// CHAT_MAX_FRAME_BYTES.
+var framesRefused atomic.Int64
+
+// acceptFrame checks a data frame before it reaches the parser. Every
+// subprotocol so far is JSON text, so a binary frame gets an error instead
+// of being read as text; text must be UTF-8 (RFC 6455 section 8.1), and
+// empty frames are skipped. Fragments are reassembled by gorilla, within
+// the read limit set on connect.
+func acceptFrame(c *client, msgType int, msg []byte) bool {
+	switch {
+	case msgType == websocket.BinaryMessage:
+		framesRefused.Add(1)
+		sendError(c, "unsupported_frame", "binary frames are not part of "+c.protocol+"; send text frames")
+		return false
+	case msgType != websocket.TextMessage:
+		// ReadMessage returns only data frames; this is a bug somewhere.
+		closeClient(c, websocket.CloseUnsupportedData, closeReason(protocol.CloseBadFrame, "unexpected frame type"))
+		return false
+	case len(msg) == 0:
+		return false
+	case !utf8.Valid(msg):
+		framesRefused.Add(1)
+		closeClient(c, websocket.CloseInvalidFramePayloadData, closeReason(protocol.CloseBadFrame, "text frame is not valid UTF-8"))
+		return false
+	}
+	return true
+}
+
+// protocolOf is the subprotocol conn was upgraded with, defaultProtocol if
+// the client didn't ask for one.
+func protocolOf(conn *websocket.Conn) string {
+	if p := conn.Subprotocol(); p != "" {
+		return p
+	}
+	return defaultProtocol
+}
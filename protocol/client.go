@@ -0,0 +1,379 @@
+package protocol
+
+// Frames accepted from clients. The server dispatches on Type and decodes
+// the rest; clients building frames set Type to the constant named in each
+// comment. Frames without fields beyond their type (quota, sessions,
+// my_stats, admin_subscribe) are sent as {"type":"..."} and have no struct.
+
+// SendRequest is a msg (public) or dm frame. TempID, optional, is echoed in
+// the ack and on the sender's own copy of the message.
+type SendRequest struct {
+	Type   string `json:"type"`
+	To     string `json:"to,omitempty"` // dm only
+	Text   string `json:"text"`
+	TempID string `json:"tempId,omitempty"`
+	// DisplayName (bot connections only) shows the message under another
+	// name; see Message.Via.
+	DisplayName string `json:"displayName,omitempty"`
+	// Format ("plain", "markdown" or "code") and Lang (code only) say how
+	// to render Text; see Message.Format.
+	Format string `json:"format,omitempty"`
+	Lang   string `json:"lang,omitempty"`
+}
+
+// E2EDMRequest (e2e_dm) sends an end-to-end encrypted DM; Payload is the
+// base64 ciphertext, which the server never reads.
+type E2EDMRequest struct {
+	Type    string `json:"type"`
+	To      string `json:"to"`
+	Payload string `json:"payload"`
+	TempID  string `json:"tempId,omitempty"`
+}
+
+// PublishKeyRequest (publish_key) sets the user's public key.
+type PublishKeyRequest struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
+
+// GetKeyRequest (get_key) asks for a user's public key.
+type GetKeyRequest struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// AdminAuthRequest (admin_auth) makes the connection an admin one.
+type AdminAuthRequest struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+// ModerationRequest is admin_kick, admin_ban, admin_unban, admin_mute or
+// admin_unmute.
+type ModerationRequest struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Reason   string `json:"reason,omitempty"`
+	Duration int64  `json:"duration,omitempty"` // admin_mute: seconds, 0 for indefinite
+}
+
+// AnnounceRequest (admin_announce) posts a system message to the public
+// chat. A Sticky one also stays a notice for TTL seconds, or until
+// admin_unannounce without a TTL.
+type AnnounceRequest struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Sticky bool   `json:"sticky,omitempty"`
+	TTL    int64  `json:"ttl,omitempty"`
+}
+
+// UnannounceRequest (admin_unannounce) takes down a sticky announcement.
+type UnannounceRequest struct {
+	Type     string `json:"type"`
+	NoticeID string `json:"noticeId"`
+}
+
+// PinRequest (admin_pin) sets the pinned notice; an empty text removes it.
+type PinRequest struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// AutoReplyRequest (autoreply) sets the auto-reply with Text, turns it off
+// with Enabled false, or without either reports it.
+type AutoReplyRequest struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitempty"`
+	Enabled *bool  `json:"enabled,omitempty"`
+}
+
+// DMStatusRequest (dm_status) asks what happened to the user's DMs to To.
+type DMStatusRequest struct {
+	Type string   `json:"type"`
+	To   string   `json:"to"`
+	IDs  []string `json:"ids"`
+}
+
+// DMClearRequest (dm_clear) clears the conversation with Peer, for both
+// sides if Both (and the server allows it).
+type DMClearRequest struct {
+	Type string `json:"type"`
+	Peer string `json:"peer"`
+	Both bool   `json:"both,omitempty"`
+}
+
+// GroupDMCreateRequest (group_dm_create) starts a group DM with Members.
+type GroupDMCreateRequest struct {
+	Type    string   `json:"type"`
+	Members []string `json:"members"`
+}
+
+// GroupDMSendRequest (group_dm_send) posts to group DM ID.
+type GroupDMSendRequest struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Text   string `json:"text"`
+	TempID string `json:"tempId,omitempty"`
+	// Format and Lang are as for SendRequest.
+	Format string `json:"format,omitempty"`
+	Lang   string `json:"lang,omitempty"`
+}
+
+// GroupDMAddRequest (group_dm_add) adds Member to group DM ID.
+type GroupDMAddRequest struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Member string `json:"member"`
+}
+
+// GroupDMRemoveRequest (group_dm_remove) removes Member from group DM ID.
+type GroupDMRemoveRequest struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Member string `json:"member"`
+}
+
+// GroupDMLeaveRequest (group_dm_leave) leaves group DM ID.
+type GroupDMLeaveRequest struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// GroupDMHistoryRequest (group_dm_history) asks for group DM ID's recent
+// history.
+type GroupDMHistoryRequest struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// MembersPageRequest (members_page) asks for more of the member list.
+type MembersPageRequest struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// PingRequest (ping) asks for a pong echoing T (client ms).
+type PingRequest struct {
+	Type string `json:"type"`
+	T    int64  `json:"t"`
+}
+
+// PongRequest (pong) answers the server's ping, echoing its T.
+type PongRequest struct {
+	Type string `json:"type"`
+	T    int64  `json:"t"`
+}
+
+// WhoisRequest (whois) asks about a user.
+type WhoisRequest struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// ProfileUpdateRequest (profile_update) sets the user's display name; an
+// empty one removes it.
+type ProfileUpdateRequest struct {
+	Type        string `json:"type"`
+	DisplayName string `json:"displayName"`
+}
+
+// UserExistsRequest (user_exists) asks whether Name may receive DMs, so a
+// client can check a recipient before composing.
+type UserExistsRequest struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// MemberSearchRequest (member_search) looks members up by name or display
+// name prefix, optionally within a room.
+type MemberSearchRequest struct {
+	Type   string `json:"type"`
+	Prefix string `json:"prefix"`
+	Limit  int    `json:"limit,omitempty"`
+	Room   string `json:"room,omitempty"`
+}
+
+// NotifyEmailRequest (notify_email) sets the address offline DM
+// notifications go to; an empty one removes it.
+type NotifyEmailRequest struct {
+	Type  string `json:"type"`
+	Email string `json:"email"`
+}
+
+// MarkReadRequest (mark_read) sets the read position in Conversation.
+type MarkReadRequest struct {
+	Type         string `json:"type"`
+	Conversation string `json:"conversation"`
+	ID           string `json:"id,omitempty"`
+	Time         int64  `json:"time"`
+}
+
+// JoinRoomRequest (join_room) joins a room, creating it if needed.
+type JoinRoomRequest struct {
+	Type string `json:"type"`
+	Room string `json:"room"`
+}
+
+// LeaveRoomRequest (leave_room) leaves a room.
+type LeaveRoomRequest struct {
+	Type string `json:"type"`
+	Room string `json:"room"`
+}
+
+// RoomSendRequest (room_send) posts to a room.
+type RoomSendRequest struct {
+	Type   string `json:"type"`
+	Room   string `json:"room"`
+	Text   string `json:"text"`
+	TempID string `json:"tempId,omitempty"`
+	// DisplayName, Format and Lang are as for SendRequest.
+	DisplayName string `json:"displayName,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Lang        string `json:"lang,omitempty"`
+}
+
+// PollCreateRequest (poll_create) posts a poll to the public timeline, or
+// to Room. Duration is how long voting stays open, e.g. "10m".
+type PollCreateRequest struct {
+	Type     string   `json:"type"`
+	Room     string   `json:"room,omitempty"`
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Duration string   `json:"duration"`
+	TempID   string   `json:"tempId,omitempty"`
+}
+
+// PollVoteRequest (poll_vote) votes for an option, by index from 0. A
+// later vote replaces the earlier one until the poll closes.
+type PollVoteRequest struct {
+	Type   string `json:"type"`
+	PollID string `json:"pollId"`
+	Option int    `json:"option"`
+}
+
+// RoomSetCapacityRequest (room_set_capacity) sets a room's member limit;
+// owner only.
+type RoomSetCapacityRequest struct {
+	Type       string `json:"type"`
+	Room       string `json:"room"`
+	MaxMembers int    `json:"maxMembers"`
+}
+
+// RoomInfoRequest (room_info) asks for a room's metadata.
+type RoomInfoRequest struct {
+	Type string `json:"type"`
+	Room string `json:"room"`
+}
+
+// RoomInviteRequest (room_invite) lets Name join a private room; members
+// and admin connections only.
+type RoomInviteRequest struct {
+	Type string `json:"type"`
+	Room string `json:"room"`
+	Name string `json:"name"`
+}
+
+// RoomUpdateRequest (room_update) changes a room's settings; owner or
+// admin only. Settings left out are unchanged. SlowModeSeconds 0 turns
+// slow mode off; HistoryVisibility is "all" or "since_join"; an empty
+// Topic removes it. Permanent is for admin connections only.
+type RoomUpdateRequest struct {
+	Type              string  `json:"type"`
+	Room              string  `json:"room"`
+	SlowModeSeconds   *int    `json:"slowModeSeconds,omitempty"`
+	HistoryVisibility *string `json:"historyVisibility,omitempty"`
+	Topic             *string `json:"topic,omitempty"`
+	Private           *bool   `json:"private,omitempty"`
+	Unlisted          *bool   `json:"unlisted,omitempty"`
+	Permanent         *bool   `json:"permanent,omitempty"`
+}
+
+// RoomListRequest (room_list) asks for a page of the room directory, after
+// Cursor if given. Filter is "public" (the default: listed public rooms)
+// or "all" (those and every room the requester is in); Query keeps the
+// rooms whose name or topic contains it, in any case.
+type RoomListRequest struct {
+	Type   string `json:"type"`
+	Filter string `json:"filter,omitempty"`
+	Query  string `json:"query,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// RoomDeleteRequest (room_delete) deletes a room, archiving its history if
+// Archive; owner or admin only.
+type RoomDeleteRequest struct {
+	Type    string `json:"type"`
+	Room    string `json:"room"`
+	Archive bool   `json:"archive,omitempty"`
+}
+
+// GenInvitesRequest (gen_invites) asks an admin connection for Count
+// invite codes valid for TTL (a Go duration or days, "7d"; default 7d).
+type GenInvitesRequest struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// HelloRequest (hello) labels the connection's device and may pick the
+// language of the server's text (a tag, "es", or an Accept-Language list).
+type HelloRequest struct {
+	Type   string `json:"type"`
+	Device string `json:"device,omitempty"`
+	Kind   string `json:"kind,omitempty"` // mobile, tablet, desktop, web, bot or other
+	Lang   string `json:"lang,omitempty"`
+}
+
+// SessionKillRequest (session_kill) closes another of the user's sessions.
+type SessionKillRequest struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// DeactivateRequest (deactivate) deactivates the user's own account.
+type DeactivateRequest struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// DismissRequest (dismiss) hides a notice from the user for good.
+type DismissRequest struct {
+	Type     string `json:"type"`
+	NoticeID string `json:"noticeId"`
+}
+
+// ConversationsRequest (conversations) asks for a page of the conversation
+// list, after Cursor if given.
+type ConversationsRequest struct {
+	Type   string `json:"type"`
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// SnoozeRequest (snooze) mutes notifications for Scope ("all", "global",
+// "room:<name>", "dm:<peer>" or "group:<id>") for Duration ("1h"; "0"
+// ends it). Without a scope it reports the snoozes.
+type SnoozeRequest struct {
+	Type     string `json:"type"`
+	Scope    string `json:"scope,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// TranslateRequest (translate) asks for message ID of Conversation, sent at
+// Time, in language To.
+type TranslateRequest struct {
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	To           string `json:"to"`
+	Conversation string `json:"conversation"`
+	Time         int64  `json:"time"`
+}
+
+// WatchRequest (watch) replaces the user's watched keywords; an empty list
+// clears them and a missing one reports them.
+type WatchRequest struct {
+	Type     string    `json:"type"`
+	Keywords *[]string `json:"keywords,omitempty"`
+}
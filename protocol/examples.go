@@ -0,0 +1,219 @@
+package protocol
+
+import "encoding/json"
+
+// Examples returns one frame of each kind, with fixed contents, in a fixed
+// order: first what the server sends, then what it accepts. Marshalled,
+// they are the golden file TestGolden checks.
+func Examples() []interface{} {
+	msg := Message{ID: "m1", User: "alice", Text: "hi", Time: 1700000000, V: 1}
+	dm := msg
+	dm.To, dm.Direction, dm.TempID = "bob", "out", "t1"
+	custom := msg
+	custom.Text, custom.Emoji = "🎉 :partyparrot:", []CustomEmoji{{Name: "partyparrot", File: "f1"}}
+	history := json.RawMessage(`[{"id":"m1","user":"alice","text":"hi","time":1700000000,"v":1}]`)
+	room := Room{Name: "general", Owner: "alice", MaxMembers: 1000, Members: 2, SlowModeSeconds: 30, HistoryVisibility: "since_join", Topic: "Anything goes"}
+	archive := &RoomArchive{ID: "a1", Room: "old", Deleted: 1700000000, By: "alice", Messages: 12}
+	pos := ReadPosition{ID: "m1", Time: 1700000000, FirstUnread: "m2"}
+	online, limit, used, remaining := true, 100, int64(40), int64(60)
+	enabled := false
+	slow := 30
+	visibility := "since_join"
+	topic, unlisted, permanent := "Anything goes", true, true
+	keywords := []string{"deploy"}
+	retryAfter := int64(4242)
+	poll := Poll{ID: "m3", Question: "Lunch?", Options: []string{"pizza", "sushi"}, Votes: []int64{3, 1}, Closes: 1700000600}
+	pollMsg := Message{ID: "m3", User: "alice", Text: "Lunch?", Time: 1700000000, Kind: "poll", Poll: &poll, V: 1}
+	finalPoll := poll
+	unknownAck := NewAck("t2", "m2", 1700000000)
+	unknownAck.RecipientKnown = new(bool)
+	hookMsg := Message{ID: "m4", User: "github", Text: "[acme/api] alice pushed 1 commit to main", Time: 1700000000, Kind: "webhook", Meta: map[string]string{"hook": "h1"}, Via: "webhook", DisplayName: "CI"}
+	finalPoll.Closed = true
+	codeMsg := Message{ID: "m6", User: "alice", Text: "for i := range 3 {\n\tfmt.Println(i)\n}", Time: 1700000000, Format: "code", Lang: "go", V: 1}
+	groupNotice := Message{ID: "m5", User: "system", Text: "alice added carol", Time: 1700000000, System: true, Code: "system.group_added", Vars: map[string]string{"name": "alice", "member": "carol"}, V: 1}
+
+	init := NewInit([]string{"alice", "bob"}, []string{"bob"}, 2, history, 1700000000000)
+	notice := Notice{ID: "announcement-m2", Kind: "announcement", Text: "Maintenance at 18:00 UTC", From: "admin", Time: 1700000000, Expires: 1700086400}
+	init.Protocol, init.Version, init.Roster, init.Pinned = "chat.v1.json", 1, "diff", &msg
+	init.Notices = []Notice{{ID: "motd-1a2b3c4d", Kind: "motd", Text: "Be nice"}, notice, {ID: "pin-m1", Kind: "pin", Text: "hi", From: "alice", Time: 1700000000}}
+	joined := NewJoined("alice", "s1")
+	joined.Rooms, joined.RoomUnread, joined.RoomsGone = []string{"general"}, map[string]int64{"general": 3}, []string{"old"}
+	joined.GroupDMs = []GroupDMSummary{{ID: "g1", Members: []string{"alice", "bob", "carol"}, LastMessage: &msg, Unread: 1}}
+	joined.ReadPositions = map[string]ReadPosition{"global": pos}
+	joined.Snoozes = map[string]int64{"room:general": 1700003600000}
+	dismissed := notice
+	dismissed.Dismissed = true
+	joined.Notices = []Notice{dismissed}
+	banned := NewError("banned", "you are banned")
+	banned.Reason = "spam"
+	slowMode := NewError("slow_mode", "general is in slow mode")
+	slowMode.Room, slowMode.RetryAfter = "general", 12
+	quotaExceeded := NewError("quota_exceeded", "daily message quota exceeded")
+	quotaExceeded.ResetsIn = 3600
+	roomQuota := NewError("room_quota", "daily room creation quota exceeded")
+	roomQuota.ResetsIn = 3600
+	muted := NewError("muted", "you are muted")
+	muted.Until = 1700003600
+	cleared := NewDMCleared("bob", "alice", false)
+	cleared.Until = 1700000000
+	event := NewAdminEvent("ban", 1700000000)
+	event.Workspace, event.Name, event.By, event.Reason = "acme", "bob", "admin", "spam"
+	export := NewAdminEvent("export", 1700000000)
+	export.Workspace, export.Name, export.By = "acme", "general", "admin@203.0.113.7"
+	export.Export = &ExportInfo{Scope: "room:general", First: 1699990000, Last: 1700000000, Messages: 120, Bytes: 14230, Notified: true, Complete: true}
+	conversations := NewConversations([]Conversation{
+		{Conversation: "dm:bob", Last: &LastActivity{ID: "m1", User: "bob", Snippet: "hi", Time: 1700000000}, Unread: 2, Online: &online},
+		{Conversation: "room:general", MutedUntil: 1700003600000},
+	})
+	conversations.Next = "1700000000:room:general"
+	roomList := NewRoomList([]RoomListing{
+		{Name: "devops", Topic: "Deploys and incidents", Members: 12, LastActivity: 1700000000},
+		{Name: "dev-leads", Members: 3, LastActivity: 1699990000, Private: true},
+	})
+	roomList.Next = "1699990000:dev-leads"
+	translation := NewTranslation("m1", "en")
+	translation.Text, translation.Truncated = "hello", true
+	quota := NewQuota(3600)
+	quota.Limit, quota.Used, quota.Remaining = &limit, &used, &remaining
+
+	return []interface{}{
+		msg,
+		dm,
+		custom,
+		hookMsg,
+		codeMsg,
+		init,
+		NewHistoryChunk(history),
+		NewInitDone(),
+		NewMembersPage(500, []string{"zoe"}, []string{}, 501),
+		joined,
+		NewMemberAdd("carol", true),
+		NewMemberRemove("carol"),
+		NewRosterDiff([]RosterMember{{Name: "carol"}}, []string{"dave"}),
+		NewError("bad_frame", "invalid ping frame"),
+		banned,
+		muted,
+		slowMode,
+		quotaExceeded,
+		roomQuota,
+		NewError("impersonation", "alice is a member's name"),
+		NewAck("t1", "m1", 1700000000),
+		unknownAck,
+		NewError("unknown_user", "zed has never joined"),
+		NewError("unknown_user", "zed nunca se ha unido"),
+		NewUserExists("bob", true),
+		NewPing(1700000000000),
+		NewPong(1699999999000, 1700000000000),
+		NewWhois("bob", "Bob", true, []ConnectionStats{{Protocol: "chat.v1.json", RTTMs: 12.5}}, ActivityStats{MessagesToday: 1, MessagesWeek: 5}),
+		NewMyStats(ActivityStats{MessagesToday: 1, MessagesWeek: 5, DMsToday: 2, DMsWeek: 3}),
+		NewMemberSearch("al", []MemberMatch{{Name: "alice", DisplayName: "Alice", Online: true}, {Name: "albert", Deactivated: true}}),
+		NewAutoReply(true, "Away until Monday"),
+		NewDMStatus("bob", map[string]DeliveryStatus{"m1": {Status: "delivered", At: 1700000000000}, "m2": {Status: "sent"}}),
+		NewDelivered("m1", "bob", 1700000000000),
+		cleared,
+		NewReconnect(1500),
+		NewGroupDM("g1", msg),
+		NewGroupDM("g1", groupNotice),
+		NewGroupDMUpdate("g1", []string{"alice", "bob"}),
+		NewGroupDMHistory("g1", []Message{msg}),
+		NewKey("bob", "<public key>"),
+		NewAdminAuth(),
+		NewAdminSubscribed(),
+		event,
+		export,
+		NewPinned(&msg),
+		NewPinned(nil),
+		NewNoticeAdded(notice),
+		NewNoticeRemoved("announcement-m2"),
+		NewKicked("spam"),
+		NewAccountDeleted(),
+		NewAccountDeactivated("alice", "taking a break"),
+		NewLinkPreview("global", "m1", Preview{URL: "https://example.com", Title: "Example"}),
+		NewReadSync("global", pos),
+		NewRoomJoined(room, []Message{msg}),
+		NewRoomInfo(room),
+		NewRoomInvite("secret", "bob"),
+		NewRoomMessage("general", msg),
+		NewRoomDelete("old", archive),
+		NewRoomDeleted("old", "alice", true),
+		NewSnooze(map[string]int64{"all": 1700003600000}),
+		NewSessions([]Session{{ID: "s1", Device: "iPhone", Kind: "mobile", IP: "203.0.113.7", Instance: "i1", Connected: 1700000000000, LastActive: 1700000030000, Current: true}}),
+		NewSessionKill("s2"),
+		NewLeave("alice"),
+		NewGenInvites([]Invite{{Code: "K7QX2MBA4R", By: "admin", Created: 1700000000, Expires: 1700604800}}),
+		NewDismiss("announcement-m2"),
+		NewSessionKilled("s1"),
+		NewHello("s1", "es"),
+		conversations,
+		roomList,
+		translation,
+		NewWatch(keywords),
+		NewKeywordHit("room:general", keywords, msg),
+		NewSigning("c1"),
+		NewQuota(3600),
+		quota,
+		NewEmojiUpdate("partyparrot", "f1"),
+		NewEmojiUpdate("partyparrot", ""),
+		pollMsg,
+		NewPollUpdate(poll),
+		NewPollUpdate(finalPoll),
+		CloseReason{Kind: CloseShutdown, Reason: "server shutting down", RetryAfterMs: &retryAfter},
+		CloseReason{Kind: CloseKicked, Reason: "spam"},
+		CloseReason{Kind: CloseLeft, Reason: "signed out"},
+		CloseReason{Kind: CloseAddressBanned, Reason: "too many rejected joins", Until: 1700000900},
+
+		SendRequest{Type: TypeMsg, Text: "hi", TempID: "t1"},
+		SendRequest{Type: TypeMsg, Text: "**shipped**", Format: "markdown"},
+		SendRequest{Type: TypeDM, To: "bob", Text: "hi", TempID: "t2"},
+		E2EDMRequest{Type: TypeE2EDM, To: "bob", Payload: "c2VjcmV0", TempID: "t3"},
+		PublishKeyRequest{Type: TypePublishKey, Key: "<public key>"},
+		GetKeyRequest{Type: TypeGetKey, Name: "bob"},
+		AdminAuthRequest{Type: TypeAdminAuth, Token: "<token>"},
+		ModerationRequest{Type: TypeAdminMute, Name: "bob", Reason: "cool off", Duration: 3600},
+		AnnounceRequest{Type: TypeAdminAnnounce, Text: "Maintenance at 18:00 UTC", Sticky: true, TTL: 86400},
+		UnannounceRequest{Type: TypeAdminUnannounce, NoticeID: "announcement-m2"},
+		PinRequest{Type: TypeAdminPin, Text: "Read the rules"},
+		AutoReplyRequest{Type: TypeAutoReply, Enabled: &enabled},
+		DMStatusRequest{Type: TypeDMStatus, To: "bob", IDs: []string{"m1", "m2"}},
+		DMClearRequest{Type: TypeDMClear, Peer: "bob", Both: true},
+		GroupDMCreateRequest{Type: TypeGroupDMCreate, Members: []string{"bob", "carol"}},
+		GroupDMSendRequest{Type: TypeGroupDMSend, ID: "g1", Text: "hi", TempID: "t4"},
+		GroupDMSendRequest{Type: TypeGroupDMSend, ID: "g1", Text: "SELECT 1;", Format: "code", Lang: "sql"},
+		GroupDMAddRequest{Type: TypeGroupDMAdd, ID: "g1", Member: "dave"},
+		GroupDMRemoveRequest{Type: TypeGroupDMRemove, ID: "g1", Member: "dave"},
+		GroupDMLeaveRequest{Type: TypeGroupDMLeave, ID: "g1"},
+		GroupDMHistoryRequest{Type: TypeGroupDMHistory, ID: "g1"},
+		MembersPageRequest{Type: TypeMembersPage, Offset: 500, Limit: 500},
+		PingRequest{Type: TypePing, T: 1700000000000},
+		PongRequest{Type: TypePong, T: 1700000000000},
+		WhoisRequest{Type: TypeWhois, Name: "bob"},
+		ProfileUpdateRequest{Type: TypeProfileUpdate, DisplayName: "Alice"},
+		MemberSearchRequest{Type: TypeMemberSearch, Prefix: "al", Limit: 10, Room: "general"},
+		UserExistsRequest{Type: TypeUserExists, Name: "Bob"},
+		NotifyEmailRequest{Type: TypeNotifyEmail, Email: "alice@example.com"},
+		MarkReadRequest{Type: TypeMarkRead, Conversation: "room:general", ID: "m1", Time: 1700000000},
+		JoinRoomRequest{Type: TypeJoinRoom, Room: "general"},
+		LeaveRoomRequest{Type: TypeLeaveRoom, Room: "general"},
+		RoomSendRequest{Type: TypeRoomSend, Room: "general", Text: "hi", TempID: "t5"},
+		RoomSendRequest{Type: TypeRoomSend, Room: "general", Text: "    indented()", Format: "code", Lang: "python"},
+		RoomSendRequest{Type: TypeRoomSend, Room: "deploys", Text: "build passed", DisplayName: "CI"},
+		RoomSetCapacityRequest{Type: TypeRoomSetCapacity, Room: "general", MaxMembers: 50},
+		RoomInfoRequest{Type: TypeRoomInfo, Room: "general"},
+		RoomInviteRequest{Type: TypeRoomInvite, Room: "secret", Name: "bob"},
+		PollCreateRequest{Type: TypePollCreate, Question: "Lunch?", Options: []string{"pizza", "sushi"}, Duration: "10m", TempID: "t6"},
+		PollVoteRequest{Type: TypePollVote, PollID: "m3", Option: 1},
+		RoomUpdateRequest{Type: TypeRoomUpdate, Room: "general", SlowModeSeconds: &slow, HistoryVisibility: &visibility, Topic: &topic, Unlisted: &unlisted},
+		RoomUpdateRequest{Type: TypeRoomUpdate, Room: "general", Permanent: &permanent},
+		RoomDeleteRequest{Type: TypeRoomDelete, Room: "old", Archive: true},
+		HelloRequest{Type: TypeHello, Device: "iPhone", Kind: "mobile", Lang: "es-MX"},
+		GenInvitesRequest{Type: TypeGenInvites, Count: 20, TTL: "7d"},
+		SessionKillRequest{Type: TypeSessionKill, ID: "s2"},
+		DeactivateRequest{Type: TypeDeactivate, Reason: "taking a break"},
+		DismissRequest{Type: TypeDismiss, NoticeID: "announcement-m2"},
+		ConversationsRequest{Type: TypeConversations, Limit: 50, Cursor: "1700000000:room:general"},
+		RoomListRequest{Type: TypeRoomList, Filter: "public", Query: "dev", Limit: 50, Cursor: "1700000000:devops"},
+		SnoozeRequest{Type: TypeSnooze, Scope: "room:alerts", Duration: "1h"},
+		TranslateRequest{Type: TypeTranslate, ID: "m1", To: "en", Conversation: "room:ops", Time: 1700000000},
+		WatchRequest{Type: TypeWatch, Keywords: &keywords},
+	}
+}
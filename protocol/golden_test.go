@@ -0,0 +1,73 @@
+package protocol_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+
+	"websocket-chatapp/protocol"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files instead of checking them")
+
+const goldenFile = "frames.golden.json"
+
+// TestGolden marshals one frame of each kind (Examples) and compares the
+// result, byte for byte, with frames.golden.json. A renamed field, a
+// changed json tag, a lost omitempty or a reordered field all show up as
+// a difference. When a wire change is intended, rewrite the file with
+// go test ./protocol -update and commit it along with the change.
+func TestGolden(t *testing.T) {
+	got := render(t)
+	if *update {
+		if err := os.WriteFile(goldenFile, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	wantLines := bytes.Split(want, []byte("\n"))
+	gotLines := bytes.Split(got, []byte("\n"))
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var w, g []byte
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if !bytes.Equal(w, g) {
+			t.Errorf("line %d:\n  want %s\n  got  %s", i+1, w, g)
+		}
+	}
+	t.Log("-update rewrites the golden file if the change is intended")
+}
+
+// render marshals the examples as a JSON array, one frame per line, so the
+// file shows each frame exactly as it goes over the wire.
+func render(t *testing.T) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[\n")
+	examples := protocol.Examples()
+	for i, frame := range examples {
+		data, err := json.Marshal(frame)
+		if err != nil {
+			t.Fatalf("marshalling %T: %v", frame, err)
+		}
+		buf.Write(data)
+		if i < len(examples)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("]\n")
+	return buf.Bytes()
+}
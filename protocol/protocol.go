@@ -0,0 +1,409 @@
+// Package protocol defines the chat server's websocket wire format: every
+// JSON frame the server sends (server.go) or accepts (client.go), and the
+// records they carry. Field names and json tags here are the protocol;
+// changing one is a wire change, which TestGolden catches by comparing
+// one frame of each kind (see Examples) with protocol/frames.golden.json.
+//
+// Frames are JSON objects with a "type", one of the Type constants, except
+// chat messages themselves: public messages and DMs are delivered as a bare
+// Message. A few legacy text commands (join:<name>, msg:<user>:<text>,
+// dm:<from>:<to>:<text>) are still accepted.
+package protocol
+
+import "encoding/json"
+
+// Frame types. Several are used in both directions: a request and the
+// server's answer share a type.
+const (
+	// Sent by the server.
+	TypeInit               = "init"
+	TypeHistoryChunk       = "history_chunk"
+	TypeInitDone           = "init_done"
+	TypeJoined             = "joined"
+	TypeMemberAdd          = "member_add"
+	TypeMemberRemove       = "member_remove"
+	TypeRosterDiff         = "roster_diff"
+	TypeError              = "error"
+	TypeAck                = "ack"
+	TypeDelivered          = "delivered"
+	TypeDMCleared          = "dm_cleared"
+	TypeReconnect          = "reconnect"
+	TypeGroupDM            = "group_dm"
+	TypeGroupDMUpdate      = "group_dm_update"
+	TypeKey                = "key"
+	TypeAdminSubscribed    = "admin_subscribed"
+	TypeAdminEvent         = "admin_event"
+	TypePinned             = "pinned"
+	TypeKicked             = "kicked"
+	TypeAccountDeleted     = "account_deleted"
+	TypeAccountDeactivated = "account_deactivated"
+	TypeLinkPreview        = "link_preview"
+	TypeReadSync           = "read_sync"
+	TypeRoomJoined         = "room_joined"
+	TypeRoom               = "room"
+	TypeRoomMessage        = "room_message"
+	TypeRoomDeleted        = "room_deleted"
+	TypeSessionKilled      = "session_killed"
+	TypeTranslation        = "translation"
+	TypeKeywordHit         = "keyword_hit"
+	TypeSigning            = "signing"
+	TypeEmojiUpdate        = "emoji_update"
+	TypeNoticeAdded        = "notice_added"
+	TypeNoticeRemoved      = "notice_removed"
+	TypePollUpdate         = "poll_update"
+
+	// Sent by clients, and answered with a frame of the same type.
+	TypeAdminAuth      = "admin_auth"
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeWhois          = "whois"
+	TypeMyStats        = "my_stats"
+	TypeMembersPage    = "members_page"
+	TypeMemberSearch   = "member_search"
+	TypeAutoReply      = "autoreply"
+	TypeDMStatus       = "dm_status"
+	TypeGroupDMHistory = "group_dm_history"
+	TypeRoomDelete     = "room_delete"
+	TypeSnooze         = "snooze"
+	TypeSessions       = "sessions"
+	TypeSessionKill    = "session_kill"
+	TypeHello          = "hello"
+	TypeConversations  = "conversations"
+	TypeWatch          = "watch"
+	TypeQuota          = "quota"
+	TypeDismiss        = "dismiss"
+	TypeGenInvites     = "gen_invites"
+	TypeRoomList       = "room_list"
+	TypeRoomInvite     = "room_invite"
+	TypeUserExists     = "user_exists"
+	TypeLeave          = "leave"
+
+	// Sent by clients only.
+	TypeMsg             = "msg"
+	TypeDM              = "dm"
+	TypeE2EDM           = "e2e_dm"
+	TypePublishKey      = "publish_key"
+	TypeGetKey          = "get_key"
+	TypeNotifyEmail     = "notify_email"
+	TypeProfileUpdate   = "profile_update"
+	TypeMarkRead        = "mark_read"
+	TypeDMClear         = "dm_clear"
+	TypeTranslate       = "translate"
+	TypeGroupDMCreate   = "group_dm_create"
+	TypeGroupDMSend     = "group_dm_send"
+	TypeGroupDMAdd      = "group_dm_add"
+	TypeGroupDMRemove   = "group_dm_remove"
+	TypeGroupDMLeave    = "group_dm_leave"
+	TypeJoinRoom        = "join_room"
+	TypeLeaveRoom       = "leave_room"
+	TypeRoomSend        = "room_send"
+	TypeRoomSetCapacity = "room_set_capacity"
+	TypeRoomInfo        = "room_info"
+	TypeRoomUpdate      = "room_update"
+	TypeAdminSubscribe  = "admin_subscribe"
+	TypeAdminKick       = "admin_kick"
+	TypeAdminBan        = "admin_ban"
+	TypeAdminUnban      = "admin_unban"
+	TypeAdminMute       = "admin_mute"
+	TypeAdminUnmute     = "admin_unmute"
+	TypeAdminAnnounce   = "admin_announce"
+	TypeAdminPin        = "admin_pin"
+	TypeAdminUnannounce = "admin_unannounce"
+	TypeDeactivate      = "deactivate"
+	TypePollCreate      = "poll_create"
+	TypePollVote        = "poll_vote"
+)
+
+// Message is a chat message, as stored and as delivered.
+type Message struct {
+	ID     string `json:"id,omitempty"`
+	User   string `json:"user"`
+	Text   string `json:"text"`
+	Time   int64  `json:"time"`
+	System bool   `json:"system,omitempty"`
+	// Kind "e2e" marks an end-to-end encrypted DM: Text is empty and
+	// Payload is the client's opaque base64 ciphertext. Kind "webhook"
+	// marks a message posted by an incoming webhook, with the hook's ID in
+	// Meta["hook"]. Kind "poll" marks a poll, with Text its question and
+	// Poll its state.
+	Kind    string `json:"kind,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	Poll    *Poll  `json:"poll,omitempty"`
+	// Meta carries annotations added by inbound middleware.
+	Meta map[string]string `json:"meta,omitempty"`
+	// Emoji resolves the custom emoji the text uses, as :name:.
+	Emoji []CustomEmoji `json:"emoji,omitempty"`
+	// TempID is the sender's own ID for the message, set only on the copy
+	// delivered back to the sending connection.
+	TempID string `json:"tempId,omitempty"`
+	V      int    `json:"v,omitempty"`
+	// To and Direction are set on the copies of a DM delivered to its
+	// participants, never on the stored message.
+	To        string `json:"to,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	// Via is "webhook" or "bot" on a message an incoming webhook or a bot
+	// connection posted, for clients to mark as such. User is still the
+	// hook or bot; DisplayName is the name it asked to be shown under.
+	Via         string `json:"via,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	// Format is how the sender means Text to be rendered: "markdown", or
+	// "code" with Lang naming its language for highlighting. It is empty
+	// for plain text.
+	Format string `json:"format,omitempty"`
+	Lang   string `json:"lang,omitempty"`
+	// Code and Vars are set on a server-generated system message: its
+	// catalog key ("system.group_added") and the values filled into it,
+	// for clients that render it in their own language.
+	Code string            `json:"code,omitempty"`
+	Vars map[string]string `json:"vars,omitempty"`
+	// Truncated is set on a copy that had to be cut down to fit the
+	// server's outbound frame limit: Emoji and Meta were left out.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Poll is a poll's state: its options, the votes for each (by index), and
+// when voting closes (unix seconds). ID is the poll message's ID; Room is
+// set for a poll posted to a room. Once Closed, Votes is the final result.
+type Poll struct {
+	ID       string   `json:"id"`
+	Room     string   `json:"room,omitempty"`
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Votes    []int64  `json:"votes"`
+	Closes   int64    `json:"closes"`
+	Closed   bool     `json:"closed,omitempty"`
+}
+
+// CustomEmoji is a workspace's custom emoji: File is the ID of its image.
+type CustomEmoji struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// Room is a room's metadata and occupancy.
+
+type Room struct {
+	Name            string `json:"name"`
+	Owner           string `json:"owner"`
+	MaxMembers      int    `json:"maxMembers"`
+	Members         int64  `json:"members"`
+	SlowModeSeconds int    `json:"slowModeSeconds"`
+	// HistoryVisibility is "all" or "since_join": whether new members see
+	// the messages from before they joined.
+	HistoryVisibility string `json:"historyVisibility"`
+	Topic             string `json:"topic,omitempty"`
+	// Private rooms are shown only to their members; Unlisted ones to
+	// anyone who asks for them by name, but not in the room directory.
+	Private  bool `json:"private,omitempty"`
+	Unlisted bool `json:"unlisted,omitempty"`
+	// Permanent rooms are never archived for being idle; only an admin
+	// sets it.
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// RoomListing is a room as the room directory lists it. LastActivity is
+// when its latest message was sent, or it was created, in unix seconds.
+type RoomListing struct {
+	Name         string `json:"name"`
+	Topic        string `json:"topic,omitempty"`
+	Members      int64  `json:"members"`
+	LastActivity int64  `json:"lastActivity"`
+	Private      bool   `json:"private,omitempty"`
+	Unlisted     bool   `json:"unlisted,omitempty"`
+}
+
+// RoomArchive describes the history of a deleted room, kept for admins.
+type RoomArchive struct {
+	ID       string `json:"id"`
+	Room     string `json:"room"`
+	Deleted  int64  `json:"deleted"` // unix seconds
+	By       string `json:"by"`
+	Messages int64  `json:"messages"`
+}
+
+// GroupDMSummary is a group DM as listed in the joined frame.
+type GroupDMSummary struct {
+	ID          string   `json:"id"`
+	Members     []string `json:"members"`
+	LastMessage *Message `json:"lastMessage,omitempty"`
+	Unread      int64    `json:"unread"`
+}
+
+// ReadPosition is the last message a user has read in a conversation.
+type ReadPosition struct {
+	ID          string `json:"id,omitempty"`
+	Time        int64  `json:"time"`
+	FirstUnread string `json:"firstUnread,omitempty"`
+}
+
+// DeliveryStatus is what happened to one DM: "sent", or "delivered" at At
+// (unix ms).
+type DeliveryStatus struct {
+	Status string `json:"status"`
+	At     int64  `json:"at,omitempty"`
+}
+
+// RosterMember is a member added in a roster_diff.
+type RosterMember struct {
+	Name      string `json:"name"`
+	Spectator bool   `json:"spectator,omitempty"`
+}
+
+// MemberMatch is one member_search result.
+type MemberMatch struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+	Online      bool   `json:"online"`
+	Deactivated bool   `json:"deactivated,omitempty"`
+}
+
+// Notice is something every connection shows until its user dismisses it:
+// the message of the day (Kind "motd"), a sticky announcement
+// ("announcement") or the pinned notice ("pin"). Time and Expires are unix
+// seconds; Expires is 0 for a notice that stays until removed. Dismissed
+// is for the connection's user, so always false in init, which is sent
+// before joining.
+type Notice struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Text      string `json:"text"`
+	From      string `json:"from,omitempty"`
+	Time      int64  `json:"time,omitempty"`
+	Expires   int64  `json:"expires,omitempty"`
+	Dismissed bool   `json:"dismissed"`
+}
+
+// Invite is an invite code for invite-only mode. Times are unix seconds;
+// UsedBy and UsedAt are set once it is redeemed.
+type Invite struct {
+	Code    string `json:"code"`
+	By      string `json:"by"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires"`
+	UsedBy  string `json:"usedBy,omitempty"`
+	UsedAt  int64  `json:"usedAt,omitempty"`
+}
+
+// ActivityStats are a user's message counters.
+type ActivityStats struct {
+	MessagesToday int64 `json:"messagesToday"`
+	MessagesWeek  int64 `json:"messagesWeek"`
+	DMsToday      int64 `json:"dmsToday"`
+	DMsWeek       int64 `json:"dmsWeek"`
+}
+
+// ConnectionStats describes one connection in whois answers (and in
+// GET /api/stats, with the fields whois leaves out).
+type ConnectionStats struct {
+	Workspace string  `json:"workspace,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Spectator bool    `json:"spectator,omitempty"`
+	Protocol  string  `json:"protocol"`
+	RTTMs     float64 `json:"rttMs"`
+	// PingRTTMs is the smoothed RTT of websocket ping control frames, and
+	// RTTSlow whether it is over the server's threshold.
+	PingRTTMs float64 `json:"pingRttMs,omitempty"`
+	RTTSlow   bool    `json:"rttSlow,omitempty"`
+	// InboundQueued is how many of the connection's frames wait to be
+	// handled, and InboundMs the smoothed time from reading a frame to
+	// having handled it.
+	InboundQueued int     `json:"inboundQueued,omitempty"`
+	InboundMs     float64 `json:"inboundMs,omitempty"`
+}
+
+// Session is one of a user's connections.
+type Session struct {
+	ID         string `json:"id"`
+	Device     string `json:"device,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	IP         string `json:"ip"`
+	Instance   string `json:"instance"`
+	Connected  int64  `json:"connected"`  // unix ms
+	LastActive int64  `json:"lastActive"` // unix ms, updated every 30s or so
+	Current    bool   `json:"current,omitempty"`
+}
+
+// Conversation is one entry of the conversation list.
+type Conversation struct {
+	Conversation string        `json:"conversation"`
+	Last         *LastActivity `json:"last,omitempty"`
+	Unread       int64         `json:"unread"`
+	MutedUntil   int64         `json:"mutedUntil,omitempty"` // unix ms
+	Online       *bool         `json:"online,omitempty"`     // DMs only
+}
+
+// LastActivity is the latest message of a conversation, cut short.
+type LastActivity struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Snippet string `json:"snippet"`
+	Kind    string `json:"kind,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// Preview is what a link in a message points to.
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// Close kinds: why the server closed a connection, in the reason text of
+// its close frame (see CloseReason).
+const (
+	CloseKicked              = "kicked"
+	CloseBanned              = "banned"
+	CloseAddressBanned       = "address_banned" // too many rejected joins
+	CloseAccountDeleted      = "account_deleted"
+	CloseAccountDeactivated  = "account_deactivated"
+	CloseSessionClosed       = "session_closed" // from another session
+	CloseLeft                = "left"           // the client signed out
+	CloseShutdown            = "shutdown"       // or draining; reconnect
+	CloseTooSlow             = "too_slow"
+	CloseWriteFailed         = "write_failed"
+	CloseBadFrame            = "bad_frame"
+	CloseUnsupportedProtocol = "unsupported_protocol"
+)
+
+// CloseReason is the reason text of every close frame the server sends:
+// a kind, the human-readable reason, and for some kinds when the client
+// may come back. It is JSON, kept within the 123 bytes a close frame
+// leaves for its reason by cutting Reason short (see Encode).
+type CloseReason struct {
+	Kind   string `json:"kind"`
+	Reason string `json:"reason,omitempty"`
+	// Until is when a ban ends (unix seconds); 0 for one that doesn't.
+	Until int64 `json:"until,omitempty"`
+	// RetryAfterMs is how long to wait before reconnecting (shutdown).
+	RetryAfterMs *int64 `json:"retryAfterMs,omitempty"`
+}
+
+// MaxCloseReason is the most bytes a close frame's reason text may take.
+const MaxCloseReason = 123
+
+// Encode returns r as a close frame's reason text, dropping characters
+// from the end of Reason until it fits in MaxCloseReason.
+func (r CloseReason) Encode() string {
+	if runes := []rune(r.Reason); len(runes) > MaxCloseReason {
+		r.Reason = string(runes[:MaxCloseReason])
+	}
+	for {
+		data, _ := json.Marshal(r)
+		if len(data) <= MaxCloseReason || r.Reason == "" {
+			return string(data)
+		}
+		runes := []rune(r.Reason)
+		r.Reason = string(runes[:len(runes)-1])
+	}
+}
+
+// DecodeCloseReason parses a close frame's reason text. Text that isn't a
+// CloseReason (older servers, proxies) reports false.
+func DecodeCloseReason(text string) (CloseReason, bool) {
+	var r CloseReason
+	if json.Unmarshal([]byte(text), &r) != nil || r.Kind == "" {
+		return CloseReason{}, false
+	}
+	return r, true
+}
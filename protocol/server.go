@@ -0,0 +1,753 @@
+package protocol
+
+import "encoding/json"
+
+// Frames sent by the server. Each has a constructor that sets its Type and
+// the fields every such frame carries; optional fields are set on the
+// result.
+
+// Init is the connect-time state. History holds the first chunk of public
+// history, an array of Message; the rest follow as history_chunk frames,
+// and init_done marks the end. Pinned is null without a pinned notice.
+// Notices lists what a late joiner must see, in order: the message of the
+// day, sticky announcements oldest first, the pinned notice.
+type Init struct {
+	Type        string          `json:"type"`
+	Members     []string        `json:"members"`
+	Spectators  []string        `json:"spectators"`
+	MemberCount int             `json:"memberCount"`
+	ReadOnly    bool            `json:"readOnly"`
+	Protocol    string          `json:"protocol"`
+	Version     int             `json:"version"`
+	Roster      string          `json:"roster"` // "diff" or "events"
+	History     json.RawMessage `json:"history"`
+	ServerTime  int64           `json:"serverTime"` // unix ms
+	Pinned      *Message        `json:"pinned"`
+	Notices     []Notice        `json:"notices"`
+	// DMsRecorded tells users their DMs are recorded for compliance.
+	DMsRecorded bool `json:"dmsRecorded,omitempty"`
+	// Truncated, here and on the other frames that have it, means the
+	// frame was over the server's outbound limit and lists were cut short
+	// to fit (here the oldest history, then members).
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func NewInit(members, spectators []string, memberCount int, history json.RawMessage, serverTime int64) Init {
+	return Init{Type: TypeInit, Members: members, Spectators: spectators, MemberCount: memberCount, History: history, ServerTime: serverTime, Notices: []Notice{}}
+}
+
+// HistoryChunk carries more public history after init, as an array of
+// Message.
+type HistoryChunk struct {
+	Type      string          `json:"type"`
+	History   json.RawMessage `json:"history"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+func NewHistoryChunk(history json.RawMessage) HistoryChunk {
+	return HistoryChunk{Type: TypeHistoryChunk, History: history}
+}
+
+// InitDone ends the connect-time state.
+type InitDone struct {
+	Type string `json:"type"`
+}
+
+func NewInitDone() InitDone { return InitDone{Type: TypeInitDone} }
+
+// MembersPage answers members_page.
+type MembersPage struct {
+	Type        string   `json:"type"`
+	Offset      int      `json:"offset"`
+	Members     []string `json:"members"`
+	Spectators  []string `json:"spectators"`
+	MemberCount int      `json:"memberCount"`
+	Truncated   bool     `json:"truncated,omitempty"`
+}
+
+func NewMembersPage(offset int, members, spectators []string, memberCount int) MembersPage {
+	return MembersPage{Type: TypeMembersPage, Offset: offset, Members: members, Spectators: spectators, MemberCount: memberCount}
+}
+
+// Joined confirms a join with the user's state: rooms (and their unread
+// counts), rooms deleted while they were away, group DMs, read positions
+// (by conversation), snoozes (scope -> unix ms) and the session ID.
+type Joined struct {
+	Type          string                  `json:"type"`
+	Name          string                  `json:"name"`
+	Rooms         []string                `json:"rooms"`
+	RoomUnread    map[string]int64        `json:"roomUnread"`
+	RoomsGone     []string                `json:"roomsGone"`
+	GroupDMs      []GroupDMSummary        `json:"groupDms"`
+	ReadPositions map[string]ReadPosition `json:"readPositions"`
+	Snoozes       map[string]int64        `json:"snoozes"`
+	Notices       []Notice                `json:"notices"` // as in init, with the user's dismissals
+	Session       string                  `json:"session"`
+	Truncated     bool                    `json:"truncated,omitempty"`
+}
+
+func NewJoined(name, session string) Joined {
+	return Joined{Type: TypeJoined, Name: name, Session: session}
+}
+
+// MemberAdd and MemberRemove report roster changes to connections that
+// asked for events rather than diffs.
+type MemberAdd struct {
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Spectator bool   `json:"spectator,omitempty"`
+}
+
+func NewMemberAdd(name string, spectator bool) MemberAdd {
+	return MemberAdd{Type: TypeMemberAdd, Name: name, Spectator: spectator}
+}
+
+type MemberRemove struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func NewMemberRemove(name string) MemberRemove {
+	return MemberRemove{Type: TypeMemberRemove, Name: name}
+}
+
+// RosterDiff reports the roster changes of one debounce period.
+type RosterDiff struct {
+	Type    string         `json:"type"`
+	Added   []RosterMember `json:"added"`
+	Removed []string       `json:"removed"`
+}
+
+func NewRosterDiff(added []RosterMember, removed []string) RosterDiff {
+	return RosterDiff{Type: TypeRosterDiff, Added: added, Removed: removed}
+}
+
+// Error refuses a frame or reports a failure. Code is stable, Message is
+// for people. Some codes carry more: banned a Reason, muted the end of the
+// mute (Until, unix seconds), slow_mode the Room, slow_mode and the join
+// limits the seconds to wait (RetryAfter), quota_exceeded, room_quota and
+// rate_limited the seconds until the limit resets (ResetsIn).
+type Error struct {
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Reason     string `json:"reason,omitempty"`
+	Until      int64  `json:"until,omitempty"`
+	Room       string `json:"room,omitempty"`
+	RetryAfter int64  `json:"retryAfter,omitempty"`
+	ResetsIn   int64  `json:"resetsIn,omitempty"`
+}
+
+func NewError(code, message string) Error {
+	return Error{Type: TypeError, Code: code, Message: message}
+}
+
+// Ack tells the sender of a msg, dm, room_send, group_dm_send or e2e_dm
+// with a tempId that it was stored, under which ID and time.
+type Ack struct {
+	Type   string `json:"type"`
+	TempID string `json:"tempId"`
+	ID     string `json:"id"`
+	Time   int64  `json:"time"`
+	// RecipientKnown is false on the ack of a DM to a name that never
+	// joined, when the server delivers those at all; otherwise unset.
+	RecipientKnown *bool `json:"recipientKnown,omitempty"`
+}
+
+func NewAck(tempID, id string, time int64) Ack {
+	return Ack{Type: TypeAck, TempID: tempID, ID: id, Time: time}
+}
+
+// Ping is the server's application-level ping; T (unix ms) is to be echoed
+// in a pong and doubles as the server time.
+type Ping struct {
+	Type       string `json:"type"`
+	T          int64  `json:"t"`
+	ServerTime int64  `json:"serverTime"`
+}
+
+func NewPing(now int64) Ping { return Ping{Type: TypePing, T: now, ServerTime: now} }
+
+// Pong answers a client's ping, echoing T.
+type Pong struct {
+	Type       string `json:"type"`
+	T          int64  `json:"t"`
+	ServerTime int64  `json:"serverTime"`
+}
+
+func NewPong(t, serverTime int64) Pong {
+	return Pong{Type: TypePong, T: t, ServerTime: serverTime}
+}
+
+// Whois answers whois. Connections are those on the answering instance.
+// Activity is an ActivityStats, or {"disabled":true} when statistics are
+// off.
+type Whois struct {
+	Type        string            `json:"type"`
+	Name        string            `json:"name"`
+	DisplayName string            `json:"displayName"`
+	Online      bool              `json:"online"`
+	Connections []ConnectionStats `json:"connections"`
+	Activity    interface{}       `json:"activity"`
+}
+
+func NewWhois(name, displayName string, online bool, connections []ConnectionStats, activity interface{}) Whois {
+	return Whois{Type: TypeWhois, Name: name, DisplayName: displayName, Online: online, Connections: connections, Activity: activity}
+}
+
+// MyStats answers my_stats.
+type MyStats struct {
+	Type  string        `json:"type"`
+	Stats ActivityStats `json:"stats"`
+}
+
+func NewMyStats(stats ActivityStats) MyStats { return MyStats{Type: TypeMyStats, Stats: stats} }
+
+// MemberSearch answers member_search.
+type MemberSearch struct {
+	Type      string        `json:"type"`
+	Prefix    string        `json:"prefix"`
+	Results   []MemberMatch `json:"results"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+func NewMemberSearch(prefix string, results []MemberMatch) MemberSearch {
+	return MemberSearch{Type: TypeMemberSearch, Prefix: prefix, Results: results}
+}
+
+// UserExists answers user_exists. Name is the registered spelling when the
+// user exists, else the name asked about.
+type UserExists struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Exists      bool   `json:"exists"`
+	Deactivated bool   `json:"deactivated,omitempty"`
+}
+
+func NewUserExists(name string, exists bool) UserExists {
+	return UserExists{Type: TypeUserExists, Name: name, Exists: exists}
+}
+
+// AutoReply reports the user's auto-reply.
+type AutoReply struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	Text    string `json:"text"`
+}
+
+func NewAutoReply(enabled bool, text string) AutoReply {
+	return AutoReply{Type: TypeAutoReply, Enabled: enabled, Text: text}
+}
+
+// DMStatus answers dm_status: the status of each DM asked about, by ID.
+type DMStatus struct {
+	Type     string                    `json:"type"`
+	To       string                    `json:"to"`
+	Statuses map[string]DeliveryStatus `json:"statuses"`
+}
+
+func NewDMStatus(to string, statuses map[string]DeliveryStatus) DMStatus {
+	return DMStatus{Type: TypeDMStatus, To: to, Statuses: statuses}
+}
+
+// Delivered tells a DM's sender that it reached To at At (unix ms).
+type Delivered struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	To   string `json:"to"`
+	At   int64  `json:"at"`
+}
+
+func NewDelivered(id, to string, at int64) Delivered {
+	return Delivered{Type: TypeDelivered, ID: id, To: to, At: at}
+}
+
+// DMCleared tells a user that their conversation with Peer was cleared by
+// By: for both sides, or for them up to Until (unix seconds).
+type DMCleared struct {
+	Type  string `json:"type"`
+	Peer  string `json:"peer"`
+	By    string `json:"by"`
+	Both  bool   `json:"both"`
+	Until int64  `json:"until,omitempty"`
+}
+
+func NewDMCleared(peer, by string, both bool) DMCleared {
+	return DMCleared{Type: TypeDMCleared, Peer: peer, By: by, Both: both}
+}
+
+// Reconnect asks a client to move to another instance after After ms.
+type Reconnect struct {
+	Type  string `json:"type"`
+	After int64  `json:"after"`
+}
+
+func NewReconnect(after int64) Reconnect { return Reconnect{Type: TypeReconnect, After: after} }
+
+// GroupDM delivers a group DM message.
+type GroupDM struct {
+	Type    string  `json:"type"`
+	ID      string  `json:"id"`
+	Message Message `json:"message"`
+}
+
+func NewGroupDM(id string, msg Message) GroupDM {
+	return GroupDM{Type: TypeGroupDM, ID: id, Message: msg}
+}
+
+// GroupDMUpdate gives a group DM's members after a change; a user removed
+// gets it too, without themselves.
+type GroupDMUpdate struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	Members []string `json:"members"`
+}
+
+func NewGroupDMUpdate(id string, members []string) GroupDMUpdate {
+	return GroupDMUpdate{Type: TypeGroupDMUpdate, ID: id, Members: members}
+}
+
+// GroupDMHistory answers group_dm_history.
+type GroupDMHistory struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	History   []Message `json:"history"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+func NewGroupDMHistory(id string, history []Message) GroupDMHistory {
+	return GroupDMHistory{Type: TypeGroupDMHistory, ID: id, History: history}
+}
+
+// Key answers get_key; Key is empty if Name published none.
+type Key struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+func NewKey(name, key string) Key { return Key{Type: TypeKey, Name: name, Key: key} }
+
+// AdminAuth confirms admin_auth.
+type AdminAuth struct {
+	Type string `json:"type"`
+	OK   bool   `json:"ok"`
+}
+
+func NewAdminAuth() AdminAuth { return AdminAuth{Type: TypeAdminAuth, OK: true} }
+
+// AdminSubscribed confirms admin_subscribe; admin_event frames follow.
+type AdminSubscribed struct {
+	Type string `json:"type"`
+}
+
+func NewAdminSubscribed() AdminSubscribed { return AdminSubscribed{Type: TypeAdminSubscribed} }
+
+// AdminEvent is one entry of the admin feed: a moderation action (Event
+// "kick", "ban", ...) or a health warning ("health", with Instance, a
+// Reason and a Message).
+type AdminEvent struct {
+	Type      string      `json:"type"`
+	Event     string      `json:"event"`
+	Time      int64       `json:"time"`
+	Workspace string      `json:"workspace,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	By        string      `json:"by,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+	Until     int64       `json:"until,omitempty"` // mutes
+	Instance  string      `json:"instance,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Export    *ExportInfo `json:"export,omitempty"` // export, export_start
+}
+
+// FirehoseRecord is one line (or server-sent event) of GET /api/firehose:
+// a stored message, with Seq, the position to resume after, and
+// ConversationSeq, its number in Conversation ("global", "room:<name>",
+// "group:<id>" or "dm:<a>,<b>"). MAC is set when the server has a frame
+// key: base64 HMAC-SHA256 of conversation, "\n", the number, "\n" and the
+// message as given.
+type FirehoseRecord struct {
+	Seq             string          `json:"seq"`
+	Conversation    string          `json:"conversation"`
+	ConversationSeq int64           `json:"conversationSeq"`
+	Message         json.RawMessage `json:"message"`
+	MAC             string          `json:"mac,omitempty"`
+}
+
+// ExportInfo describes a history export in the audit log: the
+// conversation ("global", "room:<name>" or "dm:<a>,<b>"), the requested
+// time range and the times of the first and last message exported (unix
+// seconds, 0 where open or none), how much was sent, whether the room was
+// told, and whether the export ran to the end.
+type ExportInfo struct {
+	Scope    string `json:"scope"`
+	Since    int64  `json:"since,omitempty"`
+	Until    int64  `json:"until,omitempty"`
+	First    int64  `json:"first,omitempty"`
+	Last     int64  `json:"last,omitempty"`
+	Messages int    `json:"messages"`
+	Bytes    int64  `json:"bytes"`
+	Notified bool   `json:"notified,omitempty"`
+	Complete bool   `json:"complete"`
+}
+
+// ImportLine is one line of POST /api/import: a message from another chat
+// system, sent by User at Time (unix seconds). Room, or Peer for a DM
+// between User and Peer, picks the conversation; neither is the public
+// chat. ExternalID, if set, is the message's ID in the other system: a
+// line whose ID was imported before is skipped.
+type ImportLine struct {
+	User       string `json:"user"`
+	Text       string `json:"text"`
+	Time       int64  `json:"time"`
+	Room       string `json:"room,omitempty"`
+	Peer       string `json:"peer,omitempty"`
+	ExternalID string `json:"externalId,omitempty"`
+}
+
+// ImportProgress is one line of POST /api/import's answer, written after
+// each batch: the lines read so far and what became of them, with the
+// lines this batch rejected (the first 100 of the import; later ones are
+// only counted). The last has Done, or Error if the import stopped early.
+type ImportProgress struct {
+	Lines      int64         `json:"lines"`
+	Imported   int64         `json:"imported"`
+	Duplicates int64         `json:"duplicates"`
+	Rejected   int64         `json:"rejected"`
+	Errors     []ImportError `json:"errors,omitempty"`
+	DryRun     bool          `json:"dryRun,omitempty"`
+	Done       bool          `json:"done,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// ImportError says why line Line (counting from 1) wasn't imported.
+type ImportError struct {
+	Line  int64  `json:"line"`
+	Error string `json:"error"`
+}
+
+func NewAdminEvent(event string, time int64) AdminEvent {
+	return AdminEvent{Type: TypeAdminEvent, Event: event, Time: time}
+}
+
+// Pinned gives the pinned notice, null once removed.
+type Pinned struct {
+	Type    string   `json:"type"`
+	Message *Message `json:"message"`
+}
+
+func NewPinned(msg *Message) Pinned { return Pinned{Type: TypePinned, Message: msg} }
+
+// NoticeAdded gives a sticky announcement just posted. (The pinned notice
+// changes with pinned frames.)
+type NoticeAdded struct {
+	Type   string `json:"type"`
+	Notice Notice `json:"notice"`
+}
+
+func NewNoticeAdded(notice Notice) NoticeAdded {
+	return NoticeAdded{Type: TypeNoticeAdded, Notice: notice}
+}
+
+// NoticeRemoved reports a sticky announcement taken down.
+type NoticeRemoved struct {
+	Type     string `json:"type"`
+	NoticeID string `json:"noticeId"`
+}
+
+func NewNoticeRemoved(id string) NoticeRemoved {
+	return NoticeRemoved{Type: TypeNoticeRemoved, NoticeID: id}
+}
+
+// Kicked precedes the server closing a kicked user's connections.
+type Kicked struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func NewKicked(reason string) Kicked { return Kicked{Type: TypeKicked, Reason: reason} }
+
+// AccountDeleted precedes the server closing a deleted user's connections.
+type AccountDeleted struct {
+	Type string `json:"type"`
+}
+
+func NewAccountDeleted() AccountDeleted { return AccountDeleted{Type: TypeAccountDeleted} }
+
+// AccountDeactivated precedes the server closing a deactivated user's
+// connections. By is the user themself or "admin@<address>".
+type AccountDeactivated struct {
+	Type   string `json:"type"`
+	By     string `json:"by"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func NewAccountDeactivated(by, reason string) AccountDeactivated {
+	return AccountDeactivated{Type: TypeAccountDeactivated, By: by, Reason: reason}
+}
+
+// LinkPreview adds a preview to message MessageID of Conversation.
+type LinkPreview struct {
+	Type         string  `json:"type"`
+	Conversation string  `json:"conversation"`
+	MessageID    string  `json:"messageId"`
+	Preview      Preview `json:"preview"`
+	Truncated    bool    `json:"truncated,omitempty"` // the image, then the description and title, were cut
+}
+
+func NewLinkPreview(conversation, messageID string, preview Preview) LinkPreview {
+	return LinkPreview{Type: TypeLinkPreview, Conversation: conversation, MessageID: messageID, Preview: preview}
+}
+
+// ReadSync gives a read position set on another of the user's connections.
+type ReadSync struct {
+	Type         string       `json:"type"`
+	Conversation string       `json:"conversation"`
+	Position     ReadPosition `json:"position"`
+}
+
+func NewReadSync(conversation string, pos ReadPosition) ReadSync {
+	return ReadSync{Type: TypeReadSync, Conversation: conversation, Position: pos}
+}
+
+// RoomJoined answers join_room with the room and its recent history.
+type RoomJoined struct {
+	Type      string    `json:"type"`
+	Room      Room      `json:"room"`
+	History   []Message `json:"history"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+func NewRoomJoined(room Room, history []Message) RoomJoined {
+	return RoomJoined{Type: TypeRoomJoined, Room: room, History: history}
+}
+
+// RoomInfo gives a room's metadata: answering room_info, and to members
+// whenever it or the occupancy changes.
+type RoomInfo struct {
+	Type string `json:"type"`
+	Room Room   `json:"room"`
+}
+
+func NewRoomInfo(room Room) RoomInfo { return RoomInfo{Type: TypeRoom, Room: room} }
+
+// RoomInvite answers room_invite: Name, as registered, may now join Room.
+type RoomInvite struct {
+	Type string `json:"type"`
+	Room string `json:"room"`
+	Name string `json:"name"`
+}
+
+func NewRoomInvite(room, name string) RoomInvite {
+	return RoomInvite{Type: TypeRoomInvite, Room: room, Name: name}
+}
+
+// RoomList answers room_list, most recently active first; Next is the
+// cursor of the next page, if there may be one.
+type RoomList struct {
+	Type      string        `json:"type"`
+	Rooms     []RoomListing `json:"rooms"`
+	Next      string        `json:"next,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+func NewRoomList(rooms []RoomListing) RoomList { return RoomList{Type: TypeRoomList, Rooms: rooms} }
+
+// PollUpdate carries a poll's current tallies, or with Poll.Closed its
+// final result.
+type PollUpdate struct {
+	Type string `json:"type"`
+	Poll Poll   `json:"poll"`
+}
+
+func NewPollUpdate(poll Poll) PollUpdate { return PollUpdate{Type: TypePollUpdate, Poll: poll} }
+
+// RoomMessage delivers a room message.
+type RoomMessage struct {
+	Type    string  `json:"type"`
+	Room    string  `json:"room"`
+	Message Message `json:"message"`
+}
+
+func NewRoomMessage(room string, msg Message) RoomMessage {
+	return RoomMessage{Type: TypeRoomMessage, Room: room, Message: msg}
+}
+
+// RoomDelete answers room_delete; Archive is null unless one was asked for.
+type RoomDelete struct {
+	Type    string       `json:"type"`
+	Room    string       `json:"room"`
+	Archive *RoomArchive `json:"archive"`
+}
+
+func NewRoomDelete(room string, archive *RoomArchive) RoomDelete {
+	return RoomDelete{Type: TypeRoomDelete, Room: room, Archive: archive}
+}
+
+// RoomDeleted tells a room's members it is gone.
+type RoomDeleted struct {
+	Type     string `json:"type"`
+	Room     string `json:"room"`
+	By       string `json:"by"`
+	Archived bool   `json:"archived"`
+}
+
+func NewRoomDeleted(room, by string, archived bool) RoomDeleted {
+	return RoomDeleted{Type: TypeRoomDeleted, Room: room, By: by, Archived: archived}
+}
+
+// Snooze gives the user's snoozes, scope -> unix ms.
+type Snooze struct {
+	Type    string           `json:"type"`
+	Snoozes map[string]int64 `json:"snoozes"`
+}
+
+func NewSnooze(snoozes map[string]int64) Snooze { return Snooze{Type: TypeSnooze, Snoozes: snoozes} }
+
+// Sessions answers sessions.
+type Sessions struct {
+	Type      string    `json:"type"`
+	Sessions  []Session `json:"sessions"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+func NewSessions(sessions []Session) Sessions {
+	return Sessions{Type: TypeSessions, Sessions: sessions}
+}
+
+// SessionKill confirms session_kill.
+type SessionKill struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func NewSessionKill(id string) SessionKill { return SessionKill{Type: TypeSessionKill, ID: id} }
+
+// Leave confirms leave: Name is offline and the server closes the
+// connection next.
+type Leave struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+func NewLeave(name string) Leave { return Leave{Type: TypeLeave, Name: name} }
+
+// GenInvites returns the invite codes gen_invites made.
+type GenInvites struct {
+	Type    string   `json:"type"`
+	Invites []Invite `json:"invites"`
+}
+
+func NewGenInvites(invites []Invite) GenInvites {
+	return GenInvites{Type: TypeGenInvites, Invites: invites}
+}
+
+// Dismiss reports a notice dismissed, to all the user's connections.
+type Dismiss struct {
+	Type     string `json:"type"`
+	NoticeID string `json:"noticeId"`
+}
+
+func NewDismiss(id string) Dismiss { return Dismiss{Type: TypeDismiss, NoticeID: id} }
+
+// SessionKilled precedes the server closing a session another one killed.
+type SessionKilled struct {
+	Type string `json:"type"`
+	By   string `json:"by"`
+}
+
+func NewSessionKilled(by string) SessionKilled { return SessionKilled{Type: TypeSessionKilled, By: by} }
+
+// Hello gives the connection's session ID and the language it gets.
+type Hello struct {
+	Type    string `json:"type"`
+	Session string `json:"session"`
+	Lang    string `json:"lang"`
+}
+
+func NewHello(session, lang string) Hello {
+	return Hello{Type: TypeHello, Session: session, Lang: lang}
+}
+
+// Conversations answers conversations; Next is the cursor of the next
+// page, if there may be one.
+type Conversations struct {
+	Type          string         `json:"type"`
+	Conversations []Conversation `json:"conversations"`
+	Next          string         `json:"next,omitempty"`
+	Truncated     bool           `json:"truncated,omitempty"`
+}
+
+func NewConversations(conversations []Conversation) Conversations {
+	return Conversations{Type: TypeConversations, Conversations: conversations}
+}
+
+// Translation answers translate. Truncated means only the start of a long
+// message was translated.
+type Translation struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	To        string `json:"to"`
+	Text      string `json:"text"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+func NewTranslation(id, to string) Translation {
+	return Translation{Type: TypeTranslation, ID: id, To: to}
+}
+
+// Watch gives the user's watched keywords.
+type Watch struct {
+	Type     string   `json:"type"`
+	Keywords []string `json:"keywords"`
+}
+
+func NewWatch(keywords []string) Watch { return Watch{Type: TypeWatch, Keywords: keywords} }
+
+// KeywordHit tells a user a message contains keywords they watch.
+type KeywordHit struct {
+	Type         string   `json:"type"`
+	Conversation string   `json:"conversation"`
+	Keywords     []string `json:"keywords"`
+	Message      Message  `json:"message"`
+}
+
+func NewKeywordHit(conversation string, keywords []string, msg Message) KeywordHit {
+	return KeywordHit{Type: TypeKeywordHit, Conversation: conversation, Keywords: keywords, Message: msg}
+}
+
+// Signing switches a connection to signed frames; Conn is its ID in
+// signatures.
+type Signing struct {
+	Type string `json:"type"`
+	Conn string `json:"conn"`
+	Alg  string `json:"alg"`
+}
+
+func NewSigning(conn string) Signing {
+	return Signing{Type: TypeSigning, Conn: conn, Alg: "HMAC-SHA256"}
+}
+
+// Quota answers quota. Limit is null when the user has no daily quota;
+// then Used and Remaining are left out.
+type Quota struct {
+	Type      string `json:"type"`
+	ResetsIn  int64  `json:"resetsIn"` // seconds
+	Limit     *int   `json:"limit"`
+	Used      *int64 `json:"used,omitempty"`
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+func NewQuota(resetsIn int64) Quota { return Quota{Type: TypeQuota, ResetsIn: resetsIn} }
+
+// EmojiUpdate announces a custom emoji registered, changed or, with File
+// empty, removed.
+type EmojiUpdate struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+}
+
+func NewEmojiUpdate(name, file string) EmojiUpdate {
+	return EmojiUpdate{Type: TypeEmojiUpdate, Name: name, File: file}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Public mode. CHAT_PUBLIC_MODE=true hardens an internet-facing deployment
+// with one setting: it supplies the values in publicPreset as a layer
+// beneath every explicit setting (environment, config file, API
+// overrides), so an operator can still loosen or tighten any one of them.
+// It also makes CHAT_ALLOWED_ORIGINS mandatory, as only the operator knows
+// where the web client is served from. The mode itself is read at startup
+// only; the settings it supplies stay hot-reloadable as usual.
+//
+// The server has no file uploads to turn off: the only files it knows are
+// custom emoji images, registered through the admin API, which the preset
+// turns off.
+var publicPreset = map[string]string{
+	"CHAT_GUESTS":            "true",
+	"CHAT_JOIN_RATE":         "5",
+	"CHAT_NAMES_PER_IP":      "2",
+	"CHAT_CONNS_PER_IP":      "4",
+	"CHAT_IP_BAN_STRIKES":    "3",
+	"CHAT_IP_BAN_COOLDOWN":   "1h",
+	"CHAT_DAILY_QUOTA":       "300",
+	"CHAT_MAX_MESSAGE_CHARS": "500",
+	"CHAT_MIDDLEWARE":        "profanity,max_links",
+	"CHAT_PROFANITY_MODE":    profanityMask,
+	"CHAT_HISTORY_MAX":       "200",
+	"CHAT_HISTORY_RETENTION": "24h",
+	"CHAT_ADMIN_API":         "false",
+}
+
+// presetSetting is name's value in the public mode preset, if the mode is
+// on.
+func presetSetting(name string) string {
+	v, ok := publicPreset[name]
+	if !ok {
+		return ""
+	}
+	if on, _ := strconv.ParseBool(explicitSetting("CHAT_PUBLIC_MODE")); !on {
+		return ""
+	}
+	return v
+}
+
+// checkPublicMode refuses a config in public mode without an origin list.
+func checkPublicMode(conf *config) error {
+	if conf.PublicMode && len(conf.AllowedOrigins) == 0 {
+		return errors.New("CHAT_PUBLIC_MODE needs CHAT_ALLOWED_ORIGINS, the origins the web client is served from")
+	}
+	return nil
+}
+
+// logPublicMode sums up the hardening in effect, explicit settings
+// included.
+func logPublicMode() {
+	conf := cfg()
+	if !conf.PublicMode {
+		return
+	}
+	parts := []string{"origins " + strings.Join(conf.AllowedOrigins, " ")}
+	if conf.Guests {
+		parts = append(parts, "guests only")
+	} else {
+		parts = append(parts, "chosen names")
+	}
+	parts = append(parts,
+		fmt.Sprintf("%d joins/min, %d names and %s per address", conf.JoinRate, conf.NamesPerIP, limitString(conf.ConnsPerIP, "connections")),
+		fmt.Sprintf("ban after %d strikes for %s", conf.IPBanStrikes, conf.IPBanCooldown),
+		limitString(conf.DailyQuota, "messages a day"),
+		limitString(conf.MaxMessageChars, "characters a message"))
+	if slices.Contains(conf.Middleware, "profanity") {
+		parts = append(parts, "profanity "+conf.ProfanityMode)
+	} else {
+		parts = append(parts, "no profanity filter")
+	}
+	history := limitString(conf.HistoryMax, "messages")
+	if conf.HistoryRetention > 0 {
+		history += " for " + conf.HistoryRetention.String()
+	}
+	parts = append(parts, "history "+history, "no uploads")
+	if conf.AdminToken == "" {
+		parts = append(parts, "admin API off")
+	} else {
+		parts = append(parts, "admin API ON")
+	}
+	log.Println("🛡️ Public mode:", strings.Join(parts, ", "))
+}
+
+// limitString reads "500 characters a message", or "unlimited ..." for 0.
+func limitString(n int, what string) string {
+	if n <= 0 {
+		return "unlimited " + what
+	}
+	return strconv.Itoa(n) + " " + what
+}
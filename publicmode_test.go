@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// presetFields reads each setting of the public mode preset back out of a
+// loaded config, in the preset's own spelling.
+var presetFields = map[string]func(config) string{
+	"CHAT_GUESTS":            func(c config) string { return strconv.FormatBool(c.Guests) },
+	"CHAT_JOIN_RATE":         func(c config) string { return strconv.Itoa(c.JoinRate) },
+	"CHAT_NAMES_PER_IP":      func(c config) string { return strconv.Itoa(c.NamesPerIP) },
+	"CHAT_CONNS_PER_IP":      func(c config) string { return strconv.Itoa(c.ConnsPerIP) },
+	"CHAT_IP_BAN_STRIKES":    func(c config) string { return strconv.Itoa(c.IPBanStrikes) },
+	"CHAT_IP_BAN_COOLDOWN":   func(c config) string { return c.IPBanCooldown.String() },
+	"CHAT_DAILY_QUOTA":       func(c config) string { return strconv.Itoa(c.DailyQuota) },
+	"CHAT_MAX_MESSAGE_CHARS": func(c config) string { return strconv.Itoa(c.MaxMessageChars) },
+	"CHAT_MIDDLEWARE":        func(c config) string { return strings.Join(c.Middleware, ",") },
+	"CHAT_PROFANITY_MODE":    func(c config) string { return c.ProfanityMode },
+	"CHAT_HISTORY_MAX":       func(c config) string { return strconv.Itoa(c.HistoryMax) },
+	"CHAT_HISTORY_RETENTION": func(c config) string { return c.HistoryRetention.String() },
+	"CHAT_ADMIN_API":         func(c config) string { return strconv.FormatBool(c.AdminToken != "") },
+}
+
+// presetValue is how presetFields spells the preset's value for name.
+var presetValue = map[string]string{
+	"CHAT_IP_BAN_COOLDOWN":   "1h0m0s",
+	"CHAT_HISTORY_RETENTION": "24h0m0s",
+}
+
+// TestPublicModePreset checks that CHAT_PUBLIC_MODE applies every setting
+// of the preset, that an explicit setting beats the preset's, and that the
+// mode refuses to start without an origin list.
+func TestPublicModePreset(t *testing.T) {
+	t.Setenv("CHAT_ADMIN_TOKEN", "secret")
+	t.Setenv("CHAT_ALLOWED_ORIGINS", "https://chat.example")
+	for name := range publicPreset {
+		if presetFields[name] == nil {
+			t.Errorf("%s isn't checked", name)
+		}
+	}
+
+	off := loadConfig()
+	t.Setenv("CHAT_PUBLIC_MODE", "true")
+	on := loadConfig()
+	if err := checkPublicMode(&on); err != nil {
+		t.Errorf("checkPublicMode: %v", err)
+	}
+	for name, field := range presetFields {
+		want := publicPreset[name]
+		if v, ok := presetValue[name]; ok {
+			want = v
+		}
+		if got := field(on); got != want {
+			t.Errorf("in public mode %s is %s, want %s", name, got, want)
+		}
+		if field(off) == want && name != "CHAT_PROFANITY_MODE" { // mask is the default
+			t.Errorf("%s is %s without public mode too", name, want)
+		}
+	}
+
+	t.Run("explicit settings win", func(t *testing.T) {
+		t.Setenv("CHAT_HISTORY_MAX", "50")
+		t.Setenv("CHAT_ADMIN_API", "true")
+		t.Setenv("CHAT_GUESTS", "false")
+		conf := loadConfig()
+		if conf.HistoryMax != 50 || conf.AdminToken != "secret" || conf.Guests {
+			t.Errorf("got history max %d, admin token %q, guests %v; want the explicit 50, secret, false", conf.HistoryMax, conf.AdminToken, conf.Guests)
+		}
+		if conf.MaxMessageChars != 500 {
+			t.Errorf("max message chars is %d, want the preset's 500 still", conf.MaxMessageChars)
+		}
+	})
+
+	t.Run("no origins", func(t *testing.T) {
+		t.Setenv("CHAT_ALLOWED_ORIGINS", "")
+		conf := loadConfig()
+		if checkPublicMode(&conf) == nil {
+			t.Error("public mode without CHAT_ALLOWED_ORIGINS was accepted")
+		}
+	})
+}
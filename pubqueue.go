@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Publish retries. A publish that fails (Redis failing over, NATS
+// reconnecting) isn't dropped: it waits in an in-process queue of
+// CHAT_PUBLISH_BUFFER entries that a background goroutine keeps publishing,
+// oldest first, backing off like the spill queue. While the queue isn't
+// empty new publishes join it rather than overtake it, so subscribers see
+// broadcasts in the order they were made. Only when the queue is full is a
+// broadcast dropped, counted and reported to the admin feed. Subscribers
+// that were themselves disconnected meanwhile still miss what was published
+// before they resubscribed; the messages are in history.
+type pendingPublish struct {
+	channel string
+	payload string // sealed
+}
+
+var (
+	publishQueue chan pendingPublish
+
+	publishRetried atomic.Int64
+	publishLost    atomic.Int64
+)
+
+// startPublishQueue starts the publish queue's retrier.
+func startPublishQueue() {
+	publishQueue = make(chan pendingPublish, cfg().PublishBuffer)
+	go retryPublishes(publishQueue)
+}
+
+// publishSealed publishes payload, queueing it if the transport fails or
+// earlier publishes are still queued.
+func publishSealed(channel, payload string) {
+	if publishQueue == nil {
+		// Before startup is done (schema migrations, CLI commands).
+		transport.Publish(channel, payload)
+		return
+	}
+	if len(publishQueue) == 0 && transport.Publish(channel, payload) == nil {
+		return
+	}
+	select {
+	case publishQueue <- pendingPublish{channel, payload}:
+	default:
+		publishLost.Add(1)
+		log.Printf("❌ Publishing to %s failed and the publish queue is full; broadcast dropped", channel)
+		adminAlertf("publish_full", "publish queue full; %d broadcast(s) dropped so far", publishLost.Load())
+	}
+}
+
+// retryPublishes publishes queued broadcasts one at a time, backing off
+// while the transport keeps failing.
+func retryPublishes(queue chan pendingPublish) {
+	for p := range queue {
+		backoff := spillBackoffMin
+		for transport.Publish(p.channel, p.payload) != nil {
+			select {
+			case <-time.After(backoff):
+			case <-serverCtx.Done():
+				return
+			}
+			backoff = min(backoff*2, spillBackoffMax)
+		}
+		if publishRetried.Add(1); len(queue) == 0 {
+			log.Printf("📡 Publish queue drained (%d broadcast(s) retried so far)", publishRetried.Load())
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// The pub/sub transport carries broadcasts between instances: public
+// messages, member events and each user's personal channel. Redis is the
+// default; CHAT_PUBSUB=nats moves them to NATS while Redis (or the in-memory
+// store) keeps the state. Both give the same guarantees: every subscriber on
+// every instance gets every message published while it is subscribed, and
+// nothing is kept for subscribers that come later.
+type pubsubTransport interface {
+	// Publish sends payload to every subscriber of channel.
+	Publish(channel, payload string) error
+	// Subscribe returns once the subscription is active.
+	Subscribe(channel string) subscription
+}
+
+type subscription interface {
+	// Messages is closed when the subscription is.
+	Messages() <-chan pubsubMessage
+	Close()
+}
+
+type pubsubMessage struct {
+	Channel string
+	Payload string
+	// Resubscribed marks a message without payload, sent when the
+	// subscription was made again after the transport reconnected: what
+	// was published in between was missed.
+	Resubscribed bool
+}
+
+// subscribeUntil subscribes to channel and closes the subscription when ctx
+// is cancelled, which ends any loop ranging over its messages.
+func subscribeUntil(ctx context.Context, channel string) subscription {
+	sub := transport.Subscribe(channel)
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+	return sub
+}
+
+var transport pubsubTransport = redisTransport{}
+
+func initTransport() error {
+	switch cfg().PubSub {
+	case "", "redis":
+		transport = redisTransport{}
+	case "nats":
+		t, err := dialNATS(cfg().NATSURL, "chat-"+instanceID)
+		if err != nil {
+			return err
+		}
+		transport = t
+		fmt.Println("✅ Connected to NATS at", redactURL(cfg().NATSURL))
+	default:
+		return fmt.Errorf("unknown CHAT_PUBSUB %q (want redis or nats)", cfg().PubSub)
+	}
+	return nil
+}
+
+type redisTransport struct{}
+
+func (redisTransport) Publish(channel, payload string) error {
+	return rdb.Publish(serverCtx, channel, payload).Err()
+}
+
+func (redisTransport) Subscribe(channel string) subscription {
+	s := &redisSubscription{ps: rdb.Subscribe(serverCtx, channel), ch: make(chan pubsubMessage), done: make(chan struct{})}
+	go s.forward()
+	return s
+}
+
+// resubscribes counts subscriptions made again after a reconnect.
+var resubscribes atomic.Int64
+
+type redisSubscription struct {
+	ps        *redis.PubSub
+	ch        chan pubsubMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	recent    recentPublishes
+}
+
+// forward passes messages on, dropping repeats (see origin.go). go-redis reconnects a subscription whose
+// connection broke (Redis restarted or failed over) and subscribes again;
+// the confirmations after the first tell that that happened.
+func (s *redisSubscription) forward() {
+	defer close(s.ch)
+	subscribed := false
+	for m := range s.ps.ChannelWithSubscriptions() {
+		switch m := m.(type) {
+		case *redis.Subscription:
+			if !subscribed {
+				subscribed = true
+				continue
+			}
+			resubscribes.Add(1)
+			adminAlertf("resubscribed", "pub/sub reconnected to Redis; %d resubscription(s) so far", resubscribes.Load())
+			select {
+			case s.ch <- pubsubMessage{Channel: m.Channel, Resubscribed: true}:
+			case <-s.done:
+				return
+			}
+		case *redis.Message:
+			if s.recent.repeat(m.Payload) {
+				continue
+			}
+			select {
+			case s.ch <- pubsubMessage{Channel: m.Channel, Payload: m.Payload}:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+func (s *redisSubscription) Messages() <-chan pubsubMessage { return s.ch }
+
+func (s *redisSubscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.ps.Close()
+	})
+}
+
+// natsTransport maps each channel to a subject; see natsSubject. The
+// client (nats.go) reconnects with backoff and subscribes again; like
+// go-redis, it keeps nothing published while it is disconnected (publishes
+// fail and go to the publish queue), and each subscription is then told it
+// was made again. Subscriptions don't use queue groups: every instance has
+// to see every broadcast.
+type natsTransport struct {
+	conn *nats.Conn
+
+	mu   sync.Mutex
+	subs map[*natsSubscription]bool
+}
+
+// natsBuffer is how many messages a subscription holds for a slow reader
+// before further ones are dropped, as the server does for slow consumers.
+const natsBuffer = 1024
+
+func dialNATS(rawURL, name string) (*natsTransport, error) {
+	t := &natsTransport{subs: map[*natsSubscription]bool{}}
+	conn, err := nats.Connect(rawURL,
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return min(100*time.Millisecond<<min(attempts, 6), 5*time.Second)
+		}),
+		nats.ReconnectBufSize(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Println("⚠️ NATS disconnected:", err)
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) { t.resubscribed() }),
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	return t, nil
+}
+
+func (t *natsTransport) Publish(channel, payload string) error {
+	return t.conn.Publish(natsSubject(channel), []byte(payload))
+}
+
+func (t *natsTransport) Subscribe(channel string) subscription {
+	s := &natsSubscription{t: t, channel: channel, msgs: make(chan *nats.Msg, natsBuffer), again: make(chan struct{}, 1),
+		ch: make(chan pubsubMessage), done: make(chan struct{})}
+	sub, err := t.conn.ChanSubscribe(natsSubject(channel), s.msgs)
+	if err == nil {
+		err = t.conn.Flush()
+	}
+	if err != nil {
+		// Like a Redis subscription made while Redis is down, it stays
+		// empty; the instance is unusable until NATS is back anyway.
+		log.Println("❌ NATS subscribe to", channel, "failed:", err)
+		close(s.ch)
+		return s
+	}
+	s.sub = sub
+	t.mu.Lock()
+	t.subs[s] = true
+	t.mu.Unlock()
+	go s.forward()
+	return s
+}
+
+// resubscribed tells every subscription that the client reconnected and
+// subscribed again.
+func (t *natsTransport) resubscribed() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	log.Printf("✅ NATS reconnected; restored %d subscription(s)", len(t.subs))
+	for s := range t.subs {
+		resubscribes.Add(1)
+		select {
+		case s.again <- struct{}{}:
+		default: // already pending
+		}
+	}
+	adminAlertf("resubscribed", "pub/sub reconnected to NATS; %d resubscription(s) so far", resubscribes.Load())
+}
+
+type natsSubscription struct {
+	t         *natsTransport
+	channel   string
+	sub       *nats.Subscription
+	msgs      chan *nats.Msg
+	again     chan struct{}
+	ch        chan pubsubMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	recent    recentPublishes
+}
+
+// forward passes messages on, dropping repeats (see origin.go), and a
+// Resubscribed message after each reconnect.
+func (s *natsSubscription) forward() {
+	defer close(s.ch)
+	for {
+		var m pubsubMessage
+		select {
+		case msg := <-s.msgs:
+			payload := string(msg.Data)
+			if s.recent.repeat(payload) {
+				continue
+			}
+			m = pubsubMessage{Channel: s.channel, Payload: payload}
+		case <-s.again:
+			m = pubsubMessage{Channel: s.channel, Resubscribed: true}
+		case <-s.done:
+			return
+		}
+		select {
+		case s.ch <- m:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *natsSubscription) Messages() <-chan pubsubMessage { return s.ch }
+
+func (s *natsSubscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.sub != nil {
+			s.sub.Unsubscribe()
+			s.t.mu.Lock()
+			delete(s.t.subs, s)
+			s.t.mu.Unlock()
+		}
+	})
+}
+
+// natsSubject turns a channel name into a NATS subject: each ':'-separated
+// part becomes a '.'-separated token, with characters NATS reserves in
+// subjects (and '%') percent-encoded, so "chat:ws:acme:dm:a.b" becomes
+// "chat.ws.acme.dm.a%2Eb".
+func natsSubject(channel string) string {
+	parts := strings.Split(strings.TrimSuffix(channel, ":"), ":")
+	for i, p := range parts {
+		var b strings.Builder
+		for _, c := range []byte(p) {
+			if c <= ' ' || c == '.' || c == '*' || c == '>' || c == '%' || c >= 0x7f {
+				fmt.Fprintf(&b, "%%%02X", c)
+				continue
+			}
+			b.WriteByte(c)
+		}
+		if b.Len() == 0 {
+			b.WriteString("%")
+		}
+		parts[i] = b.String()
+	}
+	return strings.Join(parts, ".")
+}
+
+// redactURL hides credentials in a URL for logging.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		u.User = url.User("xxxxx") // token auth
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/memredis"
+)
+
+// TestPubSubContract runs the same checks against each transport: every
+// subscriber of a channel gets what is published to it, in order, and
+// nothing from other channels or from before it subscribed; a stamped
+// broadcast published twice is passed on once; a subscription broken by a
+// lost connection is made again and says so; and Close ends the messages.
+func TestPubSubContract(t *testing.T) {
+	initMemory() // for the admin alerts of the resubscriptions
+	setConfig(t, func(c *config) { c.PubSubTimestamps = true })
+	for _, backend := range []struct {
+		name  string
+		start func(t *testing.T) (tr pubsubTransport, drop func())
+	}{
+		{"redis", startRedisTransport},
+		{"nats", startNATSTransport},
+	} {
+		t.Run(backend.name, func(t *testing.T) {
+			tr, drop := backend.start(t)
+
+			// next returns sub's next message.
+			next := func(sub subscription) pubsubMessage {
+				t.Helper()
+				select {
+				case m, ok := <-sub.Messages():
+					if !ok {
+						t.Fatal("the subscription was closed")
+					}
+					return m
+				case <-time.After(5 * time.Second):
+					t.Fatal("no message")
+				}
+				return pubsubMessage{}
+			}
+			publish := func(channel, payload string) {
+				t.Helper()
+				if err := tr.Publish(channel, payload); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Dots and colons must not make two channels one subject.
+			dotted, colons := "chat:contract:a.b", "chat:contract:a:b"
+			first, second := tr.Subscribe(dotted), tr.Subscribe(dotted)
+			other := tr.Subscribe(colons)
+			defer first.Close()
+			defer second.Close()
+			defer other.Close()
+			for i := range 10 {
+				publish(dotted, strconv.Itoa(i))
+			}
+			publish(colons, "end")
+			for _, sub := range []subscription{first, second} {
+				for i := range 10 {
+					if m := next(sub); m.Channel != dotted || m.Payload != strconv.Itoa(i) {
+						t.Fatalf("message %d is %+v, want %q on %s", i, m, strconv.Itoa(i), dotted)
+					}
+				}
+			}
+			if m := next(other); m.Channel != colons || m.Payload != "end" {
+				t.Errorf("%s got %+v, want only end", colons, m)
+			}
+
+			publish("chat:contract:late", "early")
+			late := tr.Subscribe("chat:contract:late")
+			defer late.Close()
+			publish("chat:contract:late", "late")
+			if m := next(late); m.Payload != "late" {
+				t.Errorf("a late subscriber got %q first, want only what was published after it subscribed", m.Payload)
+			}
+
+			stamped := stampPayload("once")
+			publish(dotted, stamped)
+			publish(dotted, stamped)
+			publish(dotted, "after")
+			if m := next(first); m.Payload != stamped {
+				t.Errorf("got %q, want the stamped broadcast", m.Payload)
+			}
+			if m := next(first); m.Payload != "after" {
+				t.Errorf("got %q after the stamped broadcast, want it once", m.Payload)
+			}
+
+			before := resubscribes.Load()
+			drop()
+			if m := next(other); !m.Resubscribed || m.Channel != colons {
+				t.Fatalf("after the connection broke, got %+v, want a resubscription of %s", m, colons)
+			}
+			if resubscribes.Load() <= before {
+				t.Error("resubscribes didn't go up")
+			}
+			// The publishing side may notice the broken connection only now.
+			for deadline := time.Now().Add(5 * time.Second); tr.Publish(colons, "back") != nil; time.Sleep(50 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("can't publish after reconnecting")
+				}
+			}
+			if m := next(other); m.Payload != "back" {
+				t.Errorf("after reconnecting got %+v, want back", m)
+			}
+
+			other.Close()
+			for deadline := time.After(5 * time.Second); ; {
+				select {
+				case _, ok := <-other.Messages():
+					if ok {
+						continue
+					}
+				case <-deadline:
+					t.Fatal("Close didn't close the messages")
+				}
+				break
+			}
+		})
+	}
+}
+
+// startRedisTransport points rdb at a fresh in-process store whose
+// connections drop closes.
+func startRedisTransport(t *testing.T) (pubsubTransport, func()) {
+	mem := memredis.NewServer()
+	var mu sync.Mutex
+	var conns []net.Conn
+	old := rdb
+	rdb = redis.NewClient(&redis.Options{
+		Addr: "memory",
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := mem.Dial(ctx, network, addr)
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			return c, err
+		},
+		Protocol:        2,
+		DisableIdentity: true,
+	})
+	t.Cleanup(func() {
+		rdb.Close()
+		rdb = old
+	})
+	return redisTransport{}, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+		conns = nil
+	}
+}
+
+// startNATSTransport connects to a fakeNATS whose connections drop closes.
+func startNATSTransport(t *testing.T) (pubsubTransport, func()) {
+	srv := startFakeNATS(t)
+	tr, err := dialNATS("nats://"+srv.addr, "chat-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tr.conn.Close)
+	return tr, srv.drop
+}
+
+// fakeNATS is a NATS server for tests, speaking as much of the core
+// protocol as natsTransport uses: CONNECT, PING, SUB, UNSUB and PUB, with
+// subjects matched exactly.
+type fakeNATS struct {
+	addr string
+
+	mu    sync.Mutex
+	conns map[*fakeNATSConn]bool
+}
+
+type fakeNATSConn struct {
+	nc   net.Conn
+	mu   sync.Mutex        // guards writes and subs
+	subs map[string]string // sid → subject
+}
+
+func startFakeNATS(t *testing.T) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeNATS{addr: ln.Addr().String(), conns: map[*fakeNATSConn]bool{}}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := &fakeNATSConn{nc: nc, subs: map[string]string{}}
+			s.mu.Lock()
+			s.conns[c] = true
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+// drop closes every client connection, as a restarting server would.
+func (s *fakeNATS) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+}
+
+func (s *fakeNATS) serve(c *fakeNATSConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.nc.Close()
+	}()
+	c.write(`INFO {"server_id":"fake","version":"2.10.0","proto":1,"max_payload":1048576}` + "\r\n")
+	r := bufio.NewReader(c.nc)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			c.write("PONG\r\n")
+		case "SUB": // SUB <subject> [queue] <sid>
+			c.mu.Lock()
+			c.subs[args[len(args)-1]] = args[1]
+			c.mu.Unlock()
+		case "UNSUB":
+			c.mu.Lock()
+			delete(c.subs, args[1])
+			c.mu.Unlock()
+		case "PUB": // PUB <subject> [reply] <size>, then the payload
+			size, _ := strconv.Atoi(args[len(args)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.deliver(args[1], payload[:size])
+		}
+	}
+}
+
+func (s *fakeNATS) deliver(subject string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.mu.Lock()
+		for sid, sub := range c.subs {
+			if sub == subject {
+				fmt.Fprintf(c.nc, "MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *fakeNATSConn) write(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	io.WriteString(c.nc, s)
+}
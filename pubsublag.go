@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"websocket-chatapp/metrics"
+)
+
+// Pub/sub lag. Every broadcast goes through publish and every listener
+// through openPayload, so those two stamp and time them. With
+// CHAT_PUBSUB_TIMESTAMPS on, publish prefixes the (sealed) payload with
+// the publishing instance, a number it counts its publishes with (see
+// origin.go) and the time it was called:
+//
+//	0x03 <instance> ':' <seq> ':' <unix ms> ':' <payload>
+//
+// and openPayload strips the stamp and adds the time since to the
+// chat_pubsub_lag_seconds histogram. Stamps from before the instance was in
+// them (0x02 <unix ms> ':' <payload>) are still read. The lag covers the publish queue, the
+// transport and the subscriber's own backlog; it is measured against the
+// publishing instance's clock, so clock skew between instances shows up in
+// it too (negative lags count as 0). A lag over CHAT_PUBSUB_LAG_WARN is
+// counted (pubsubLagged in /api/stats) and logged with the instance it came
+// from, with a health event on
+// the admin feed, at most once every 10s. Writing frames to the websocket
+// is timed separately, in chat_ws_write_seconds, so a slow client isn't
+// mistaken for a slow Redis. /api/stats shows the p99 of both over the
+// last minute or two.
+//
+// Payloads without a stamp are taken as they are, so a new instance reads
+// an older one's broadcasts; older instances can't read stamped ones. To
+// upgrade, deploy with CHAT_PUBSUB_TIMESTAMPS=false and turn it on (it is
+// hot-reloadable) once no older instance is left.
+const (
+	stampedV1 = 0x02
+	stampedV2 = 0x03
+)
+
+var (
+	pubsubLagHistogram = metrics.NewHistogram("chat_pubsub_lag_seconds",
+		"Time from publishing a broadcast to its subscriber reading it.",
+		[]float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10})
+	wsWriteHistogram = metrics.NewHistogram("chat_ws_write_seconds",
+		"Time spent writing one frame to a websocket.",
+		[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5})
+
+	pubsubLagged    atomic.Int64
+	lastLagWarnNano atomic.Int64
+)
+
+// stampPayload prefixes payload with this instance, its next publish
+// number and the current time.
+func stampPayload(payload string) string {
+	if !cfg().PubSubTimestamps {
+		return payload
+	}
+	return string([]byte{stampedV2}) + instanceID + ":" + strconv.FormatUint(publishSeq.Add(1), 10) + ":" +
+		strconv.FormatInt(time.Now().UnixMilli(), 10) + ":" + payload
+}
+
+// parseStamp splits a stamped payload into its parts; origin and seq are
+// empty and 0 for a version 1 stamp.
+func parseStamp(payload string) (origin string, seq uint64, sent int64, rest string, ok bool) {
+	if payload == "" || (payload[0] != stampedV1 && payload[0] != stampedV2) {
+		return "", 0, 0, payload, false
+	}
+	rest = payload[1:]
+	if payload[0] == stampedV2 {
+		var n string
+		if origin, rest, ok = strings.Cut(rest, ":"); !ok {
+			return "", 0, 0, payload, false
+		}
+		if n, rest, ok = strings.Cut(rest, ":"); !ok {
+			return "", 0, 0, payload, false
+		}
+		var err error
+		if seq, err = strconv.ParseUint(n, 10, 64); err != nil {
+			return "", 0, 0, payload, false
+		}
+	}
+	ms, rest, ok := strings.Cut(rest, ":")
+	sent, err := strconv.ParseInt(ms, 10, 64)
+	if !ok || err != nil {
+		return "", 0, 0, payload, false
+	}
+	return origin, seq, sent, rest, true
+}
+
+// unstampPayload strips the stamp off payload, recording the lag on
+// channel. Payloads without one are returned unchanged.
+func unstampPayload(channel, payload string) string {
+	origin, _, sent, rest, ok := parseStamp(payload)
+	if !ok {
+		return payload
+	}
+	lag := time.Duration(max(time.Now().UnixMilli()-sent, 0)) * time.Millisecond
+	pubsubLagHistogram.Observe(lag.Seconds())
+	if warn := cfg().PubSubLagWarn; warn > 0 && lag > warn {
+		warnPubSubLag(channel, origin, lag)
+	}
+	return rest
+}
+
+func warnPubSubLag(channel, origin string, lag time.Duration) {
+	n := pubsubLagged.Add(1)
+	now := time.Now().UnixNano()
+	last := lastLagWarnNano.Load()
+	if now-last < int64(adminAlertInterval) || !lastLagWarnNano.CompareAndSwap(last, now) {
+		return
+	}
+	if origin == "" {
+		origin = "an older instance"
+	}
+	log.Printf("🐌 Pub/sub message on %s from %s arrived %s after it was published (%d over %s so far)", channel, origin, lag, n, cfg().PubSubLagWarn)
+	adminAlertf("pubsub_lag", "pub/sub lag of %s on %s; %d broadcast(s) over %s so far", lag, channel, n, cfg().PubSubLagWarn)
+}
+
+// p99Ms is h's recent p99 in milliseconds.
+func p99Ms(h *metrics.Histogram) float64 {
+	return h.Quantile(0.99) * 1000
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Daily message quotas count every message a user sends (public, DM, group
+// DM and room) in chat:quota:<user>:<UTC date>, which expires at the next
+// UTC midnight. CHAT_DAILY_QUOTA unset means unlimited.
+
+func quotaExempt(name string) bool {
+	for _, n := range cfg().QuotaExempt {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// untilReset is the time left until the quota resets at midnight UTC.
+func untilReset(now time.Time) time.Duration {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return midnight.Sub(now)
+}
+
+// takeQuota counts one message against the quota of the name c joined
+// as, or sends an error (quota_exceeded, or not_joined) and returns false.
+// INCR is atomic, so concurrent sends each see a distinct count and none
+// can slip past the limit.
+func takeQuota(c *client) bool {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return false
+	}
+	quota := c.limit("dailyQuota", cfg().DailyQuota)
+	if quota <= 0 || quotaExempt(name) {
+		return true
+	}
+	now := time.Now()
+	key := c.ws.quotaKey(name, now.UTC().Format(time.DateOnly))
+
+	pipe := rdb.TxPipeline()
+	used := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, untilReset(now))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true // don't block chat on a Redis hiccup
+	}
+	if used.Val() <= int64(quota) {
+		return true
+	}
+
+	frame := errorFrame(c, "quota_exceeded", "daily message quota exceeded")
+	frame.ResetsIn = int64(untilReset(now).Seconds())
+	c.writeJSON(frame)
+	return false
+}
+
+// {"type":"quota"}
+func handleQuota(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+
+	now := time.Now()
+	frame := protocol.NewQuota(int64(untilReset(now).Seconds()))
+	quota := c.limit("dailyQuota", cfg().DailyQuota)
+	if quota <= 0 || quotaExempt(name) {
+		c.writeJSON(frame)
+		return
+	}
+
+	used, _ := rdb.Get(ctx, c.ws.quotaKey(name, now.UTC().Format(time.DateOnly))).Int64()
+	if used > int64(quota) {
+		used = int64(quota) // rejected sends still increment
+	}
+	limit, remaining := quota, int64(quota)-used
+	frame.Limit, frame.Used, frame.Remaining = &limit, &used, &remaining
+	c.writeJSON(frame)
+}
@@ -0,0 +1,19 @@
+package main_test
+
+import "testing"
+
+// TestQuotaSender checks that the daily quota is counted against the
+// sender's joined name, whatever spelling of it a msg: or dm: frame gives.
+func TestQuotaSender(t *testing.T) {
+	addr := startServer(t, "", "CHAT_DAILY_QUOTA=2")
+	alice := dial(t, addr, "", "alice")
+	dial(t, addr, "", "bob")
+	alice.Send("ALICE", "hi")
+	await(t, alice, "message")
+	alice.SendDM("Alice", "bob", "hi")
+	await(t, alice, "message")
+	alice.Send("alice", "one too many")
+	refused(t, alice, "quota_exceeded")
+	alice.SendDM("aLiCe", "bob", "one too many")
+	refused(t, alice, "quota_exceeded")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"websocket-chatapp/protocol"
+)
+
+// Read positions are stored per user in chat:readpos:<user>, a hash mapping
+// a conversation to the last message the user has read in it. Conversations
+// are identified as "global", "room:<name>", "dm:<peer>" or "group:<id>".
+
+// conversationHistoryKey returns the zset holding messages in conversation
+// that name could still have unread.
+func conversationHistoryKey(ws workspace, name, conversation string) (string, bool) {
+	kind, target, _ := strings.Cut(conversation, ":")
+	switch {
+	case conversation == "global":
+		return ws.messagesKey(), true
+	case kind == "room" && target != "":
+		return ws.roomMessagesKey(target), true
+	case kind == "dm" && target != "":
+		return ws.dmKey(name, target), true
+	case kind == "group" && target != "":
+		return ws.groupMessagesKey(target), true
+	}
+	return "", false
+}
+
+func handleMarkRead(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+
+	var req protocol.MarkReadRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Time <= 0 {
+		sendError(c, "bad_frame", "invalid mark_read frame")
+		return
+	}
+	t, ok := clientTime(req.Time)
+	if !ok {
+		sendError(c, "clock_skew", "mark_read time is too far in the future")
+		return
+	}
+	req.Time = t
+	if _, ok := conversationHistoryKey(c.ws, name, req.Conversation); !ok {
+		sendError(c, "bad_request", "unknown conversation: "+req.Conversation)
+		return
+	}
+	if id, ok := strings.CutPrefix(req.Conversation, "group:"); ok && !requireGroupMember(c, id, name) {
+		return
+	}
+
+	// Read positions only move forward, so a stale device can't rewind them.
+	if getReadPosition(ctx, c.ws, name, req.Conversation).Time > req.Time {
+		return
+	}
+	setReadPosition(ctx, c.ws, name, req.Conversation, protocol.ReadPosition{ID: req.ID, Time: req.Time})
+}
+
+// setReadPosition stores the position and pushes it to every connection of
+// name, so their other devices stop showing the messages as unread.
+func setReadPosition(ctx context.Context, ws workspace, name, conversation string, pos protocol.ReadPosition) {
+	raw, _ := json.Marshal(pos)
+	rdb.HSet(ctx, ws.readPosKey(name), conversation, raw)
+
+	frame, _ := json.Marshal(protocol.NewReadSync(conversation, pos))
+
+	publish(ws.userChannel(name), frame)
+}
+
+func getReadPosition(ctx context.Context, ws workspace, name, conversation string) protocol.ReadPosition {
+	var pos protocol.ReadPosition
+	raw, err := rdb.HGet(ctx, ws.readPosKey(name), conversation).Result()
+	if err == nil {
+		json.Unmarshal([]byte(raw), &pos)
+	}
+	return pos
+}
+
+// readPositions returns all of name's read positions, each with a hint of
+// the first unread message the client can scroll to.
+func readPositions(ctx context.Context, ws workspace, name string) map[string]protocol.ReadPosition {
+	positions := map[string]protocol.ReadPosition{}
+	all, _ := rdb.HGetAll(ctx, ws.readPosKey(name)).Result()
+	cleared := dmWatermarks(ctx, ws, name)
+	joined := roomWatermarks(ctx, ws, name)
+	for conversation, raw := range all {
+		var pos protocol.ReadPosition
+		if json.Unmarshal([]byte(raw), &pos) != nil {
+			continue
+		}
+		if key, ok := conversationHistoryKey(ws, name, conversation); ok {
+			after := pos.Time
+			if peer, ok := strings.CutPrefix(conversation, "dm:"); ok {
+				after = max(after, cleared[peer])
+			}
+			if room, ok := strings.CutPrefix(conversation, "room:"); ok {
+				after = max(after, joined[room])
+			}
+			pos.FirstUnread = firstMessageAfter(ctx, ws, key, after)
+		}
+		positions[conversation] = pos
+	}
+	return positions
+}
+
+func firstMessageAfter(ctx context.Context, ws workspace, key string, t int64) string {
+	next, _ := messagesBetween(ctx, ws, key, "("+strconv.FormatInt(t, 10), "+inf", 1)
+	if len(next) == 0 {
+		return ""
+	}
+	msg, _ := decodeMessage(next[0])
+	return msg.ID
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"websocket-chatapp/protocol"
+)
+
+// DM recipients. A DM to a name nobody ever joined with would be stored in
+// a conversation nobody reads, so dm, its JSON form and e2e_dm are refused
+// with unknown_user unless the recipient is known: its name is registered
+// (every join registers it, see names.go) or, in invite-only mode, on the
+// allow-list, as it may join without a code. With CHAT_DM_TO_UNKNOWN such
+// DMs are stored as before and their ack says "recipientKnown":false.
+// Clients can ask first with {"type":"user_exists","name":"bob"}.
+
+// userKnown reports whether name is someone a DM can reach.
+func userKnown(ctx context.Context, ws workspace, name string) bool {
+	if isMemberName(ctx, ws, name) {
+		return true
+	}
+	if !cfg().InviteOnly {
+		return false
+	}
+	invited, _ := rdb.SIsMember(ctx, ws.invitedKey(), name).Result()
+	return invited
+}
+
+// checkRecipient reports whether a DM to peer may be sent, sending the
+// error if not, and whether peer is known.
+func checkRecipient(c *client, peer string) (ok, known bool) {
+	if userKnown(c.ctx, c.ws, peer) {
+		return true, true
+	}
+	if cfg().DMToUnknown {
+		return true, false
+	}
+	sendError(c, "unknown_user", peer+" has never joined", "name", peer)
+	return false, false
+}
+
+// sendDMAck is sendAck for a DM, flagging one to an unknown recipient.
+func sendDMAck(c *client, tempID string, msg ChatMessage, known bool) {
+	if tempID == "" {
+		return
+	}
+	ack := protocol.NewAck(tempID, msg.ID, msg.Time)
+	if !known {
+		ack.RecipientKnown = &known
+	}
+	c.writeJSON(ack)
+}
+
+// {"type":"user_exists","name":"bob"}
+func handleUserExists(c *client, data []byte) {
+	var req protocol.UserExistsRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validJoinName(req.Name) {
+		sendError(c, "bad_frame", "invalid user_exists frame")
+		return
+	}
+	name := resolveName(c.ctx, c.ws, req.Name)
+	frame := protocol.NewUserExists(name, userKnown(c.ctx, c.ws, name))
+	frame.Deactivated = frame.Exists && isDeactivated(c.ctx, c.ws, name)
+	c.writeJSON(frame)
+}
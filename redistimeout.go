@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Every Redis command gets a deadline of CHAT_REDIS_TIMEOUT unless its
+// context already has an earlier one, so a stuck Redis shows up as errors
+// instead of hung handlers. Timeouts are logged, and when the command was
+// made for a connection (its context carries the client), the frame being
+// handled is answered with a "timeout" error; see handleWebSocket.
+type clientKey struct{}
+
+type redisTimeoutHook struct{}
+
+func (redisTimeoutHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (redisTimeoutHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, cancel := withRedisTimeout(ctx)
+		defer cancel()
+		countInitTrip(ctx)
+		err := next(ctx, cmd)
+		noteTimeout(ctx, err, cmd.Name())
+		return err
+	}
+}
+
+func (redisTimeoutHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, cancel := withRedisTimeout(ctx)
+		defer cancel()
+		countInitTrip(ctx)
+		err := next(ctx, cmds)
+		noteTimeout(ctx, err, "pipeline")
+		return err
+	}
+}
+
+// countInitTrip counts a round trip made computing an init state.
+func countInitTrip(ctx context.Context) {
+	if ctx.Value(initTripsKey{}) != nil {
+		initRedisTrips.Add(1)
+	}
+}
+
+func withRedisTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, cfg().RedisTimeout)
+}
+
+func noteTimeout(ctx context.Context, err error, what string) {
+	var netErr net.Error
+	if !errors.Is(err, context.DeadlineExceeded) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		return
+	}
+	log.Printf("⏱ Redis %s timed out after %s", what, cfg().RedisTimeout)
+	adminAlertf("redis_timeout", "Redis %s timed out after %s", what, cfg().RedisTimeout)
+	if c, ok := ctx.Value(clientKey{}).(*client); ok {
+		c.timedOut.Store(true)
+	}
+}
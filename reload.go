@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Hot reload. Settings come from, in order of precedence, overrides set
+// through POST /api/config, the JSON file named by CHAT_CONFIG_FILE
+// ({"CHAT_HISTORY_LIMIT": 50, ...}), the environment, and with
+// CHAT_PUBLIC_MODE the public mode preset (see publicmode.go). SIGHUP re-reads
+// the file; the admin API changes overrides on every instance. Either way a
+// new config is built and swapped in only if every change is to a setting
+// in hotSettings and every value is valid; otherwise the whole reload is
+// rejected and the running config stays as it was.
+//
+// Checks made per message (quotas, clock skew, room capacity, read-only
+// rooms, middleware settings) apply at once. Settings read when a
+// connection opens (history sizes, ping interval) apply to new connections.
+var hotSettings = map[string]string{ // env name -> config field
+	"CHAT_HISTORY_LIMIT":       "HistoryLimit",
+	"CHAT_HISTORY_MAX":         "HistoryMax",
+	"CHAT_HISTORY_RETENTION":   "HistoryRetention",
+	"CHAT_INIT_HISTORY_CHUNK":  "InitHistoryChunk",
+	"CHAT_INIT_MEMBER_PAGE":    "InitMemberPage",
+	"CHAT_INIT_CACHE_TTL":      "InitCacheTTL",
+	"CHAT_HISTORY_CACHE":       "HistoryCache",
+	"CHAT_HISTORY_CACHE_CHECK": "HistoryCacheCheck",
+	"CHAT_MEMBER_RESYNC":       "MemberResync",
+	"CHAT_RECONNECT_JITTER":    "ReconnectJitter",
+	"CHAT_DRAIN_WINDOW":        "DrainWindow",
+	"CHAT_APP_PING_INTERVAL":   "AppPingInterval",
+	"CHAT_RTT_PING_INTERVAL":   "RTTPingInterval",
+	"CHAT_RTT_SLOW":            "RTTSlow",
+	"CHAT_MAX_CLOCK_SKEW":      "MaxClockSkew",
+	"CHAT_DEDUPE_WINDOW":       "DedupeWindow",
+	"CHAT_ROOM_MAX_MEMBERS":    "RoomMaxMembers",
+	"CHAT_MAX_ROOMS":           "MaxRooms",
+	"CHAT_ROOM_CREATE_QUOTA":   "RoomCreateQuota",
+	"CHAT_ROOM_IDLE_ARCHIVE":   "RoomIdleArchive",
+	"CHAT_READONLY_ROOMS":      "ReadOnlyRooms",
+	"CHAT_ROOM_CHANNELS":       "RoomChannels",
+	"CHAT_PUBSUB_TIMESTAMPS":   "PubSubTimestamps",
+	"CHAT_PUBSUB_LAG_WARN":     "PubSubLagWarn",
+
+	"CHAT_DAILY_QUOTA":          "DailyQuota",
+	"CHAT_QUOTA_EXEMPT":         "QuotaExempt",
+	"CHAT_AUTOREPLY_COOLDOWN":   "AutoReplyCooldown",
+	"CHAT_E2E_MAX_PAYLOAD":      "E2EMaxPayload",
+	"CHAT_ALERT_KEYWORDS":       "AlertKeywords",
+	"CHAT_MAX_LINKS":            "MaxLinks",
+	"CHAT_EMOJI_EXPAND":         "EmojiExpand",
+	"CHAT_MAX_MESSAGE_CHARS":    "MaxMessageChars",
+	"CHAT_MAX_CODE_CHARS":       "MaxCodeChars",
+	"CHAT_PROFANITY_MODE":       "ProfanityMode",
+	"CHAT_MOTD":                 "MOTD",
+	"CHAT_PROFANITY_WORDS":      "ProfanityWords",
+	"CHAT_LINK_PREVIEWS":        "LinkPreviews",
+	"CHAT_LINK_PREVIEW_ALLOW":   "LinkPreviewAllow",
+	"CHAT_LINK_PREVIEW_DENY":    "LinkPreviewDeny",
+	"CHAT_TRANSLATE_MAX_CHARS":  "TranslateMaxChars",
+	"CHAT_TRANSLATE_RATE":       "TranslateRate",
+	"CHAT_WRITE_TIMEOUT":        "WriteTimeout",
+	"CHAT_PRESENCE_GRACE":       "PresenceGrace",
+	"CHAT_MAX_FRAME_BYTES":      "MaxFrameBytes",
+	"CHAT_MAX_OUTBOUND_BYTES":   "MaxOutboundBytes",
+	"CHAT_OUTBOUND_TRUNCATE":    "OutboundTruncate",
+	"CHAT_INBOUND_QUEUE":        "InboundQueue",
+	"CHAT_ADMIN_READ_DMS":       "AdminReadDMs",
+	"CHAT_DM_CLEAR_BOTH":        "DMClearBoth",
+	"CHAT_DM_TO_UNKNOWN":        "DMToUnknown",
+	"CHAT_ACTIVITY_STATS":       "ActivityStats",
+	"CHAT_TRUSTED_PROXY_HEADER": "TrustedProxyHeader",
+	"CHAT_TRUSTED_PROXIES":      "TrustedProxies",
+	"CHAT_IP_ALLOW":             "IPAllow",
+	"CHAT_IP_DENY":              "IPDeny",
+	"CHAT_ALLOWED_ORIGINS":      "AllowedOrigins",
+	"CHAT_CONNS_PER_IP":         "ConnsPerIP",
+	"CHAT_GUESTS":               "Guests",
+	"CHAT_INVITE_ONLY":          "InviteOnly",
+	"CHAT_CONN_API_KEYS":        "ConnKeyHashes",
+	"CHAT_JOIN_RATE":            "JoinRate",
+	"CHAT_NAMES_PER_IP":         "NamesPerIP",
+	"CHAT_JOIN_EXEMPT":          "JoinExempt",
+	"CHAT_IP_BAN_STRIKES":       "IPBanStrikes",
+	"CHAT_IP_BAN_COOLDOWN":      "IPBanCooldown",
+	"CHAT_ROSTER_DEBOUNCE":      "RosterDebounce",
+	"CHAT_ROSTER_FORMAT":        "RosterFormat",
+	"CHAT_DEACTIVATED_MEMBERS":  "DeactivatedMembers",
+}
+
+var (
+	reloadMu        sync.Mutex        // serializes reloads and guards the fields below
+	fileSettings    map[string]string // from CHAT_CONFIG_FILE
+	apiSettings     = map[string]string{}
+	invalidSettings []string // settings loadConfig had to ignore
+)
+
+// setting looks name up in the layers above.
+func setting(name string) string {
+	if v := explicitSetting(name); v != "" {
+		return v
+	}
+	return presetSetting(name)
+}
+
+// explicitSetting is setting without the preset.
+func explicitSetting(name string) string {
+	if v, ok := apiSettings[name]; ok {
+		return v
+	}
+	if v, ok := fileSettings[name]; ok {
+		return v
+	}
+	return os.Getenv(name)
+}
+
+func invalidSetting(name, value string) {
+	invalidSettings = append(invalidSettings, fmt.Sprintf("%s=%q", name, value))
+}
+
+// loadConfigFile (re)reads CHAT_CONFIG_FILE into fileSettings.
+func loadConfigFile() error {
+	path := os.Getenv("CHAT_CONFIG_FILE")
+	if path == "" {
+		fileSettings = nil
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fileSettings = settingStrings(raw)
+	return nil
+}
+
+// settingStrings turns JSON values into setting strings: lists are joined
+// with commas, null becomes "" (unset).
+func settingStrings(raw map[string]interface{}) map[string]string {
+	out := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case nil:
+			out[name] = ""
+		case string:
+			out[name] = v
+		case []interface{}:
+			parts := make([]string, len(v))
+			for i, p := range v {
+				parts[i] = fmt.Sprint(p)
+			}
+			out[name] = strings.Join(parts, ",")
+		default:
+			out[name] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// reloadConfig re-reads the config file, applies overrides (an empty value
+// removes one) and swaps in the result. It returns the changes made.
+func reloadConfig(overrides map[string]string) ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	oldFile, oldAPI := fileSettings, apiSettings
+	restore := func() { fileSettings, apiSettings = oldFile, oldAPI }
+
+	if err := loadConfigFile(); err != nil {
+		restore()
+		return nil, err
+	}
+	apiSettings = make(map[string]string, len(oldAPI)+len(overrides))
+	for name, v := range oldAPI {
+		apiSettings[name] = v
+	}
+	for name, v := range overrides {
+		if v == "" {
+			delete(apiSettings, name)
+		} else {
+			apiSettings[name] = v
+		}
+	}
+
+	invalidSettings = nil
+	next := loadConfig()
+	if len(invalidSettings) > 0 {
+		restore()
+		return nil, fmt.Errorf("invalid settings: %s", strings.Join(invalidSettings, ", "))
+	}
+	if err := checkPublicMode(&next); err != nil {
+		restore()
+		return nil, err
+	}
+
+	changes, frozen := diffConfig(cfg(), &next)
+	if len(frozen) > 0 {
+		restore()
+		return nil, fmt.Errorf("cannot be changed without a restart: %s", strings.Join(frozen, ", "))
+	}
+
+	liveConfig.Store(&next)
+	for _, c := range changes {
+		log.Println("🔧 Config", c)
+	}
+	return changes, nil
+}
+
+// diffConfig lists changed fields as "Field: old → new", and separately
+// the changed fields that aren't hot-reloadable.
+func diffConfig(old, next *config) (changes, frozen []string) {
+	hot := map[string]bool{}
+	for _, field := range hotSettings {
+		hot[field] = true
+	}
+	ov, nv := reflect.ValueOf(*old), reflect.ValueOf(*next)
+	for i := 0; i < ov.NumField(); i++ {
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		field := ov.Type().Field(i).Name
+		if !hot[field] {
+			frozen = append(frozen, field)
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %v → %v", field, a, b))
+	}
+	sort.Strings(changes)
+	sort.Strings(frozen)
+	return changes, frozen
+}
+
+// watchReloads reloads on SIGHUP and on overrides published by other
+// instances' admin API.
+func watchReloads() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("🔧 SIGHUP: reloading config")
+			if _, err := reloadConfig(nil); err != nil {
+				log.Println("❌ Config reload rejected:", err)
+			}
+		}
+	}()
+
+	sub := subscribeUntil(serverCtx, configChannel())
+	go func() {
+		for msg := range sub.Messages() {
+			payload, ok := openPayload(msg)
+			if !ok {
+				continue
+			}
+			var overrides map[string]string
+			if json.Unmarshal(payload, &overrides) != nil {
+				continue
+			}
+			// The instance that took the API call has applied these
+			// already; for it this is a no-op.
+			if _, err := reloadConfig(overrides); err != nil {
+				log.Println("❌ Config reload rejected:", err)
+			}
+		}
+	}()
+}
+
+// GET  /api/config  lists the runtime-tunable settings and their values.
+// POST /api/config  {"CHAT_HISTORY_LIMIT": 50} changes them on every
+// instance; null removes an override. An empty body re-reads the file.
+func handleConfigAPI(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		current := reflect.ValueOf(*cfg())
+		settings := map[string]interface{}{}
+		for name, field := range hotSettings {
+			v := current.FieldByName(field).Interface()
+			if d, ok := v.(time.Duration); ok {
+				v = d.String()
+			}
+			settings[name] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"settings": settings})
+
+	case http.MethodPost:
+		var raw map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		overrides := settingStrings(raw)
+		for name := range overrides {
+			if _, ok := hotSettings[name]; !ok {
+				http.Error(w, name+" cannot be changed at runtime", http.StatusBadRequest)
+				return
+			}
+		}
+
+		changes, err := reloadConfig(overrides)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if len(overrides) > 0 {
+			data, _ := json.Marshal(overrides)
+			publish(configChannel(), data)
+		}
+		if changes == nil {
+			changes = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"changed": changes})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
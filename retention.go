@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// History limits, both off by default and hot-reloadable.
+// CHAT_HISTORY_MAX caps the messages kept per conversation: storing one
+// trims the conversation to its newest entries in the same transaction.
+// CHAT_HISTORY_RETENTION drops messages older than it: every
+// historySweepInterval, one instance (the one that takes
+// historySweepLockKey) goes through every conversation of every workspace.
+const historySweepInterval = 5 * time.Minute
+
+// runHistorySweeps sweeps expired history until ctx is cancelled.
+func runHistorySweeps(ctx context.Context) {
+	tick := time.NewTicker(historySweepInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			sweepHistory(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sweepHistory(ctx context.Context) {
+	retention := cfg().HistoryRetention
+	if retention <= 0 {
+		return
+	}
+	if ok, err := rdb.SetNX(ctx, historySweepLockKey(), instanceID, historySweepInterval/2).Result(); err != nil || !ok {
+		return
+	}
+	cutoff := time.Now().Add(-retention).Unix()
+	var dropped int64
+	for _, ws := range knownWorkspaces(ctx) {
+		keys, err := conversationKeys(ctx, ws)
+		if err != nil {
+			log.Printf("❌ Listing the conversations of workspace %q for expiry failed: %v", ws, err)
+			continue
+		}
+		for _, key := range keys {
+			n, err := dropBefore(ctx, ws, key, cutoff)
+			if err != nil {
+				log.Printf("❌ Expiring history in %s failed: %v", key, err)
+				continue
+			}
+			dropped += n
+		}
+	}
+	if dropped > 0 {
+		log.Printf("🧹 Dropped %d message(s) older than %s", dropped, retention)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// Room deletion. The owner, or an admin connection, sends
+//
+//	{"type":"room_delete","room":"old-project","archive":true}
+//
+// The room is first marked closing (chat:room:<name>:closing), which stops
+// joins; then its members are removed, which stops sends and delivery, and
+// told with a room_deleted frame; then its metadata goes and its history is
+// deleted, or moved to chat:archive:<id> and listed in chat:archives, where
+// GET /api/admin/archives reads it. Archives can't be joined.
+//
+// A room_send that passed its membership check just before the members were
+// removed may still store its message a moment later, under the room's old
+// key. After roomDeleteSweep the key is swept into the archive (or deleted),
+// and only then is the closing marker removed, so a room created again with
+// the same name starts empty.
+const (
+	roomDeleteSweep = 5 * time.Second
+	// roomClosingTTL bounds the marker if the deleting instance dies
+	// before removing it.
+	roomClosingTTL = time.Minute
+)
+
+// {"type":"room_delete","room":"old-project","archive":true}
+func handleRoomDelete(c *client, data []byte) {
+	name := c.userName()
+	if name == "" && !c.admin {
+		requireJoined(c)
+		return
+	}
+	by := adminName(c)
+	ws := c.ws
+
+	var req protocol.RoomDeleteRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validRoomName(req.Room) {
+		sendError(c, "bad_frame", "invalid room_delete frame")
+		return
+	}
+	if n, _ := rdb.Exists(c.ctx, ws.roomMetaKey(req.Room), ws.roomMembersKey(req.Room)).Result(); n == 0 {
+		sendError(c, "not_found", "no such room")
+		return
+	}
+	if owner, _ := rdb.HGet(c.ctx, ws.roomMetaKey(req.Room), "owner").Result(); owner != name && !c.admin {
+		sendError(c, "forbidden", "only the room owner or an admin can delete it")
+		return
+	}
+	if ok, err := rdb.SetNX(c.ctx, ws.roomClosingKey(req.Room), by, roomClosingTTL).Result(); err != nil || !ok {
+		sendError(c, "room_closing", req.Room+" is already being deleted", "room", req.Room)
+		return
+	}
+
+	// The rest outlives the connection that asked.
+	archive, err := deleteRoom(serverCtx, ws, req.Room, by, req.Archive)
+	if err != nil {
+		rdb.Del(serverCtx, ws.roomClosingKey(req.Room))
+		sendError(c, "internal", "could not delete room")
+		return
+	}
+	c.writeJSON(protocol.NewRoomDelete(req.Room, archive))
+	recordEvent(ws, chatEvent{Type: "room_delete", User: by, Room: req.Room})
+	if c.admin {
+		publishAdminEvent(protocol.AdminEvent{Event: "room_delete", Workspace: string(ws), Name: req.Room, By: by})
+	}
+	fmt.Printf("🗑 %s deleted room %q\n", by, req.Room)
+}
+
+// deleteRoom removes room, whose closing marker the caller holds, and
+// returns its archive if it made one. The marker is removed by the sweep.
+func deleteRoom(ctx context.Context, ws workspace, room, by string, archive bool) (*protocol.RoomArchive, error) {
+	members, err := rdb.SMembers(ctx, ws.roomMembersKey(room)).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, ws.roomMembersKey(room), ws.roomMetaKey(room), ws.roomInvitesKey(room))
+	pipe.ZRem(ctx, ws.roomDirectoryKey(), room)
+	pipe.SRem(ctx, ws.roomsKey(), room)
+	for _, m := range members {
+		pipe.SRem(ctx, ws.userRoomsKey(m), room)
+		pipe.HDel(ctx, ws.readPosKey(m), "room:"+room)
+		pipe.HDel(ctx, ws.roomSinceKey(m), room)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	frame, _ := json.Marshal(protocol.NewRoomDeleted(room, by, archive))
+
+	for _, m := range members {
+		publish(ws.userChannel(m), frame)
+		publishRoomMembership(ws, m, room, false)
+	}
+
+	var a *protocol.RoomArchive
+
+	if archive {
+		a = &protocol.RoomArchive{ID: ids.New(), Room: room, Deleted: time.Now().Unix(), By: by}
+		if err := moveRoomHistory(ctx, ws, room, a); err != nil {
+			return nil, err
+		}
+	} else if err := dropRoomHistory(ctx, ws, room); err != nil {
+		return nil, err
+	}
+
+	time.AfterFunc(roomDeleteSweep, func() { sweepRoom(ws, room, a) })
+	return a, nil
+}
+
+// moveRoomHistory renames the room's history to a's archive key and
+// records a. The sequence counter goes with it, so startup's order
+// migration sees the archive as already numbered.
+func moveRoomHistory(ctx context.Context, ws workspace, room string, a *protocol.RoomArchive) error {
+	key, archiveKey := ws.roomMessagesKey(room), ws.archiveKey(a.ID)
+	a.Messages, _ = rdb.ZCard(ctx, key).Result()
+	if a.Messages > 0 {
+		seq, _ := rdb.HGet(ctx, ws.seqKey(), key).Result()
+		if err := rdb.Rename(ctx, key, archiveKey).Err(); err != nil {
+			return err
+		}
+		if seq != "" {
+			rdb.HSet(ctx, ws.seqKey(), archiveKey, seq)
+		}
+		// Archives are read by sequence; user deletion still finds them.
+		registerConversation(ctx, ws, archiveKey)
+	}
+	if err := dropRoomHistory(ctx, ws, room); err != nil {
+		return err
+	}
+	raw, _ := json.Marshal(a)
+	return rdb.HSet(ctx, ws.archivesKey(), a.ID, raw).Err()
+}
+
+// dropRoomHistory deletes whatever history the room's key holds, its time
+// index and its sequence counter.
+func dropRoomHistory(ctx context.Context, ws workspace, room string) error {
+	key := ws.roomMessagesKey(room)
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, key, ws.timeIndexKey(key))
+	pipe.HDel(ctx, ws.seqKey(), key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// sweepRoom moves messages stored in room after it was deleted into its
+// archive a (or drops them if there is none), then lets the name be used
+// again.
+func sweepRoom(ws workspace, room string, a *protocol.RoomArchive) {
+	ctx := serverCtx
+	defer rdb.Del(ctx, ws.roomClosingKey(room))
+	key := ws.roomMessagesKey(room)
+	late, _ := rdb.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if len(late) > 0 && a != nil {
+		pipe := rdb.TxPipeline()
+		for _, z := range late {
+			pipe.ZAdd(ctx, ws.archiveKey(a.ID), z)
+		}
+		a.Messages += int64(len(late))
+		raw, _ := json.Marshal(a)
+		pipe.HSet(ctx, ws.archivesKey(), a.ID, raw)
+		pipe.Exec(ctx)
+		registerConversation(ctx, ws, ws.archiveKey(a.ID))
+	}
+	dropRoomHistory(ctx, ws, room)
+}
+
+// roomClosing reports whether room is being deleted.
+func roomClosing(ctx context.Context, ws workspace, room string) bool {
+	n, _ := rdb.Exists(ctx, ws.roomClosingKey(room)).Result()
+	return n > 0
+}
+
+// GET /api/admin/archives?workspace= lists archived rooms, newest first;
+// with &id= it returns that archive with its messages, oldest first.
+func handleArchivesAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+	if !ok {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if id := q.Get("id"); id != "" {
+		raw, err := rdb.HGet(ctx, ws.archivesKey(), id).Result()
+		if err == redis.Nil {
+			http.Error(w, "no such archive", http.StatusNotFound)
+			return
+		}
+		var a protocol.RoomArchive
+		json.Unmarshal([]byte(raw), &a)
+		history, _ := rdb.ZRange(ctx, ws.archiveKey(id), 0, -1).Result()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"archive":  a,
+			"messages": decodeHistory(history),
+		})
+		return
+	}
+
+	all, _ := rdb.HGetAll(ctx, ws.archivesKey()).Result()
+	list := make([]protocol.RoomArchive, 0, len(all))
+	for _, raw := range all {
+		var a protocol.RoomArchive
+		if json.Unmarshal([]byte(raw), &a) == nil {
+			list = append(list, a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Deleted != list[j].Deleted {
+			return list[i].Deleted > list[j].Deleted
+		}
+		return list[i].ID < list[j].ID
+	})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"workspace": string(ws),
+		"archives":  list,
+	})
+}
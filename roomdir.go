@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Room directory. Users find rooms to join with
+//
+//	{"type":"room_list","filter":"public","query":"dev","limit":50}
+//
+// (or GET /api/rooms), which returns rooms with their topic, member count
+// and last activity, most recently active first, a page at a time like the
+// conversation list. A room is listed unless its owner made it private or
+// unlisted (room_update). Private rooms are shown only to their members:
+// filter "all" adds every room the requester is in to the listed ones, and
+// room_info answers others not_found. Unlisted rooms are public but left
+// out of the directory; anyone may still look one up or join it by name.
+//
+// The listed rooms are kept in chat:rooms:directory (room -> unix seconds
+// of the last message, or of its creation), raised by every room message,
+// so a page reads the index rather than every room key. A query filters
+// the index as it is walked: it is a substring of the name or topic, in
+// any case. Rooms created before the index existed are added once per
+// workspace at startup.
+const (
+	defaultRoomListPage = 50
+	maxRoomListPage     = 200
+	maxRoomQuerySize    = 64
+	maxRoomTopicRunes   = 250
+
+	roomListPublic = "public"
+	roomListAll    = "all"
+)
+
+func validRoomTopic(topic string) bool {
+	return utf8.ValidString(topic) && utf8.RuneCountInString(topic) <= maxRoomTopicRunes
+}
+
+// roomListed reports whether a room with metadata meta belongs in the
+// directory.
+func roomListed(meta map[string]string) bool {
+	return meta["private"] != "1" && meta["unlisted"] != "1"
+}
+
+// roomCursor is where a page ended: rooms sort by activity, newest first,
+// then by name in reverse, as the index's ZREVRANGE returns them.
+type roomCursor struct {
+	time int64
+	room string
+}
+
+func (cur roomCursor) String() string {
+	return strconv.FormatInt(cur.time, 10) + ":" + cur.room
+}
+
+func parseRoomCursor(s string) (roomCursor, bool) {
+	t, room, ok := strings.Cut(s, ":")
+	n, err := strconv.ParseInt(t, 10, 64)
+	if !ok || err != nil || room == "" {
+		return roomCursor{}, false
+	}
+	return roomCursor{n, room}, true
+}
+
+// after reports whether l belongs to a later page than the cursor.
+func (cur *roomCursor) after(l protocol.RoomListing) bool {
+	if cur == nil {
+		return true
+	}
+	return l.LastActivity < cur.time || (l.LastActivity == cur.time && l.Name < cur.room)
+}
+
+// roomListQuery is a parsed room_list request or GET /api/rooms.
+type roomListQuery struct {
+	all    bool
+	query  string // lower case
+	cursor *roomCursor
+	limit  int
+}
+
+// parseRoomListQuery checks the request's fields, returning an error
+// message if one is invalid.
+func parseRoomListQuery(filter, query, cursor string, limit int) (roomListQuery, string) {
+	q := roomListQuery{query: strings.ToLower(strings.TrimSpace(query)), limit: limit}
+	switch filter {
+	case "", roomListPublic:
+	case roomListAll:
+		q.all = true
+	default:
+		return q, "filter must be public or all"
+	}
+	if len(q.query) > maxRoomQuerySize {
+		return q, "query too long"
+	}
+	switch {
+	case q.limit < 0:
+		return q, "invalid limit"
+	case q.limit == 0:
+		q.limit = defaultRoomListPage
+	}
+	q.limit = min(q.limit, maxRoomListPage)
+	if cursor != "" {
+		cur, ok := parseRoomCursor(cursor)
+		if !ok {
+			return q, "invalid cursor"
+		}
+		q.cursor = &cur
+	}
+	return q, ""
+}
+
+// matches reports whether l is one the query asks for.
+func (q roomListQuery) matches(l protocol.RoomListing) bool {
+	if !q.cursor.after(l) {
+		return false
+	}
+	return q.query == "" || strings.Contains(strings.ToLower(l.Name), q.query) || strings.Contains(strings.ToLower(l.Topic), q.query)
+}
+
+// {"type":"room_list","filter":"public","query":"dev","limit":50,"cursor":"..."}
+func handleRoomList(c *client, data []byte) {
+	var req protocol.RoomListRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid room_list frame")
+		return
+	}
+	q, problem := parseRoomListQuery(req.Filter, req.Query, req.Cursor, req.Limit)
+	if problem != "" {
+		sendError(c, "bad_frame", "invalid room_list frame; "+problem)
+		return
+	}
+
+	rooms, next, err := roomDirectoryPage(c.ctx, c.ws, c.userName(), q)
+	if err != nil {
+		log.Println("❌ Room directory error:", err)
+		sendError(c, "internal", "could not list rooms")
+		return
+	}
+	frame := protocol.NewRoomList(rooms)
+	if next != nil {
+		frame.Next = next.String()
+	}
+	c.writeJSON(frame)
+}
+
+// GET /api/rooms?workspace=&filter=&q=&limit=&cursor= lists the room
+// directory. With a user API token the workspace is the token's, and
+// filter=all adds the user's own rooms.
+func handleRoomsAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+
+	var ws workspace
+	var user string
+	if bearerToken(r) != "" {
+		var ok bool
+		if ws, user, ok = requireUser(w, r); !ok {
+			return
+		}
+	} else {
+		var ok bool
+		if ws, ok = lookupWorkspace(ctx, params.Get("workspace")); !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+	}
+	limit := 0
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	q, problem := parseRoomListQuery(params.Get("filter"), params.Get("q"), params.Get("cursor"), limit)
+	if problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	rooms, next, err := roomDirectoryPage(ctx, ws, user, q)
+	if err != nil {
+		log.Println("❌ Room directory error:", err)
+		http.Error(w, "could not list rooms", http.StatusInternalServerError)
+		return
+	}
+	page := map[string]interface{}{"rooms": rooms}
+	if next != nil {
+		page["next"] = next.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// roomDirectoryPage returns up to q.limit rooms after q's cursor, and the
+// cursor of the next page if there may be one. name, if set, is the
+// requester, whose own unlisted and private rooms filter "all" adds.
+func roomDirectoryPage(ctx context.Context, ws workspace, name string, q roomListQuery) ([]protocol.RoomListing, *roomCursor, error) {
+	var found []protocol.RoomListing
+	if q.all && name != "" {
+		own, err := unlistedRoomsOf(ctx, ws, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, l := range own {
+			if q.matches(l) {
+				found = append(found, l)
+			}
+		}
+	}
+
+	// Walk the index from the cursor until a page and one more room match.
+	// Own rooms merged in above can only push listed ones to a later page.
+	maxTime := "+inf"
+	if q.cursor != nil {
+		maxTime = strconv.FormatInt(q.cursor.time, 10)
+	}
+	batch := int64(q.limit + 1)
+	if q.query != "" {
+		batch = max(batch, 200)
+	}
+	listed := 0
+	for offset := int64(0); listed <= q.limit; offset += batch {
+		zs, err := rdb.ZRevRangeByScoreWithScores(ctx, ws.roomDirectoryKey(), &redis.ZRangeBy{Min: "-inf", Max: maxTime, Offset: offset, Count: batch}).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		rooms := make([]string, len(zs))
+		times := make([]int64, len(zs))
+		for i, z := range zs {
+			rooms[i], _ = z.Member.(string)
+			times[i] = int64(z.Score)
+		}
+		listings, err := roomListings(ctx, ws, rooms, times)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, l := range listings {
+			// Skip a room made private or unlisted since it was indexed.
+			if !l.Private && !l.Unlisted && q.matches(l) {
+				found = append(found, l)
+				listed++
+			}
+		}
+		if int64(len(zs)) < batch {
+			break
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].LastActivity != found[j].LastActivity {
+			return found[i].LastActivity > found[j].LastActivity
+		}
+		return found[i].Name > found[j].Name
+	})
+	var next *roomCursor
+	if len(found) > q.limit {
+		found = found[:q.limit]
+		last := found[len(found)-1]
+		next = &roomCursor{last.LastActivity, last.Name}
+	}
+	if found == nil {
+		found = []protocol.RoomListing{}
+	}
+	return found, next, nil
+}
+
+// roomListings reads the metadata and member counts of rooms, whose
+// activity times are times, in one round trip. Rooms deleted meanwhile are
+// left out.
+func roomListings(ctx context.Context, ws workspace, rooms []string, times []int64) ([]protocol.RoomListing, error) {
+	if len(rooms) == 0 {
+		return nil, nil
+	}
+	pipe := rdb.Pipeline()
+	metas := make([]*redis.MapStringStringCmd, len(rooms))
+	members := make([]*redis.IntCmd, len(rooms))
+	for i, room := range rooms {
+		metas[i] = pipe.HGetAll(ctx, ws.roomMetaKey(room))
+		members[i] = pipe.SCard(ctx, ws.roomMembersKey(room))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	listings := make([]protocol.RoomListing, 0, len(rooms))
+	for i, room := range rooms {
+		meta := metas[i].Val()
+		if len(meta) == 0 {
+			continue
+		}
+		listings = append(listings, protocol.RoomListing{Name: room, Topic: meta["topic"], Members: members[i].Val(), LastActivity: times[i],
+			Private: meta["private"] == "1", Unlisted: meta["unlisted"] == "1"})
+	}
+	return listings, nil
+}
+
+// unlistedRoomsOf returns the rooms name is in that the index leaves out,
+// with the time of their last message (or creation).
+func unlistedRoomsOf(ctx context.Context, ws workspace, name string) ([]protocol.RoomListing, error) {
+	rooms, err := rdb.SMembers(ctx, ws.userRoomsKey(name)).Result()
+	if err != nil || len(rooms) == 0 {
+		return nil, err
+	}
+	pipe := rdb.Pipeline()
+	scores := make([]*redis.FloatCmd, len(rooms))
+	for i, room := range rooms {
+		scores[i] = pipe.ZScore(ctx, ws.roomDirectoryKey(), room)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	var own []string
+	for i, room := range rooms {
+		if scores[i].Err() == redis.Nil {
+			own = append(own, room)
+		}
+	}
+	times := make([]int64, len(own))
+	for i, room := range own {
+		times[i] = roomLastActivity(ctx, ws, room)
+	}
+	return roomListings(ctx, ws, own, times)
+}
+
+// roomLastActivity is the time of room's last message, or of its creation
+// if it has none (0 for rooms created before that was recorded).
+func roomLastActivity(ctx context.Context, ws workspace, room string) int64 {
+	raw, _ := rdb.ZRange(ctx, ws.roomMessagesKey(room), -1, -1).Result()
+	if len(raw) > 0 {
+		if msg, ok := decodeMessage(raw[0]); ok {
+			return msg.Time
+		}
+	}
+	created, _ := rdb.HGet(ctx, ws.roomMetaKey(room), "created").Int64()
+	return created
+}
+
+// touchRoomDirectory raises room's activity in the index to t, if it is
+// listed there.
+func touchRoomDirectory(ctx context.Context, ws workspace, room string, t int64) {
+	rdb.ZAddArgs(ctx, ws.roomDirectoryKey(), redis.ZAddArgs{XX: true, GT: true, Members: []redis.Z{{Score: float64(t), Member: room}}})
+}
+
+// syncRoomDirectory adds room to the index or removes it, after its
+// settings changed.
+func syncRoomDirectory(ctx context.Context, ws workspace, room string) {
+	meta, _ := rdb.HGetAll(ctx, ws.roomMetaKey(room)).Result()
+	if len(meta) == 0 || !roomListed(meta) {
+		rdb.ZRem(ctx, ws.roomDirectoryKey(), room)
+		return
+	}
+	rdb.ZAddNX(ctx, ws.roomDirectoryKey(), redis.Z{Score: float64(roomLastActivity(ctx, ws, room)), Member: room})
+}
+
+// backfillRoomDirectory indexes the rooms created before the directory
+// (and the set of all rooms, see roomlimits.go) existed, once per
+// workspace. Running it twice, or on several instances at once, is
+// harmless.
+func backfillRoomDirectory(ctx context.Context, ws workspace) error {
+	// Room names may contain ':', so cut the known ends off the key.
+	suffix := ":meta"
+	prefix := strings.TrimSuffix(ws.roomMetaKey(""), suffix)
+	found := 0
+	iter := rdb.Scan(ctx, 0, ws.roomMetaKey("*"), 500).Iterator()
+	for iter.Next(ctx) {
+		room := strings.TrimSuffix(strings.TrimPrefix(iter.Val(), prefix), suffix)
+		syncRoomDirectory(ctx, ws, room)
+		rdb.SAdd(ctx, ws.roomsKey(), room)
+		found++
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("backfilling the room directory: %w", err)
+	}
+	pipe := rdb.Pipeline()
+	pipe.Set(ctx, ws.roomDirectoryBackfilledKey(), "1", 0)
+	pipe.Set(ctx, ws.roomsBackfilledKey(), "1", 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if found > 0 && ws == defaultWorkspace {
+		fmt.Printf("🗂 Indexed %d existing room(s) in the directory\n", found)
+	} else if found > 0 {
+		fmt.Printf("🗂 Indexed %d existing room(s) in the directory of workspace %q\n", found, ws)
+	}
+	return nil
+}
+
+// backfillAllRoomDirectories runs backfillRoomDirectory for every
+// workspace that needs it, at startup and in the background.
+func backfillAllRoomDirectories(ctx context.Context) {
+	for _, ws := range knownWorkspaces(ctx) {
+		if n, _ := rdb.Exists(ctx, ws.roomDirectoryBackfilledKey(), ws.roomsBackfilledKey()).Result(); n == 2 {
+			continue
+		}
+		if err := backfillRoomDirectory(ctx, ws); err != nil {
+			log.Printf("❌ Room directory backfill of workspace %q failed: %v", ws, err)
+		}
+	}
+}
+
+// createRoom records room's creation time and lists it, the first time
+// anyone joins it.
+func createRoom(ctx context.Context, ws workspace, room string) {
+	now := time.Now().Unix()
+	rdb.HSetNX(ctx, ws.roomMetaKey(room), "created", now)
+	rdb.ZAddNX(ctx, ws.roomDirectoryKey(), redis.Z{Score: float64(now), Member: room})
+	rdb.SAdd(ctx, ws.roomsKey(), room)
+}
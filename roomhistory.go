@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// History visibility. A room's historyVisibility metadata field is "all"
+// (the default: members see the whole history) or "since_join", set by its
+// owner or an admin:
+//
+//	{"type":"room_update","room":"general","historyVisibility":"since_join"}
+//
+// Joining a since_join room records the join time as a watermark in
+// chat:user:<name>:room_since (room -> unix seconds), and everything that
+// reads room history for the member (the room_joined history, unread
+// counts, the first unread message, the conversation list, message
+// lookups) skips messages before it. Leaving drops the watermark, so a
+// member who rejoins gets a new one. The setting is applied at join time
+// only: changing it leaves current members' watermarks (or their lack)
+// as they are. Times are in seconds, so a message sent in the second of
+// the join is visible.
+const (
+	historyAll       = "all"
+	historySinceJoin = "since_join"
+)
+
+func validHistoryVisibility(v string) bool {
+	return v == historyAll || v == historySinceJoin
+}
+
+// roomHistoryVisibility is room's setting.
+func roomHistoryVisibility(ctx context.Context, ws workspace, room string) string {
+	if v, _ := rdb.HGet(ctx, ws.roomMetaKey(room), "historyVisibility").Result(); v == historySinceJoin {
+		return v
+	}
+	return historyAll
+}
+
+// recordRoomJoin sets name's watermark for room on joining it, or clears a
+// stale one if the room shows everything.
+func recordRoomJoin(ctx context.Context, ws workspace, room, name string) {
+	if roomHistoryVisibility(ctx, ws, room) == historySinceJoin {
+		rdb.HSet(ctx, ws.roomSinceKey(name), room, time.Now().Unix())
+	} else {
+		rdb.HDel(ctx, ws.roomSinceKey(name), room)
+	}
+}
+
+// roomVisibleAfter returns the time after which name may see room's
+// messages, or 0 (everything). It is exclusive, like a DM watermark.
+func roomVisibleAfter(ctx context.Context, ws workspace, name, room string) int64 {
+	raw, _ := rdb.HGet(ctx, ws.roomSinceKey(name), room).Result()
+	since, _ := strconv.ParseInt(raw, 10, 64)
+	return max(since-1, 0)
+}
+
+// roomWatermarks returns roomVisibleAfter for each of name's since_join
+// rooms.
+func roomWatermarks(ctx context.Context, ws workspace, name string) map[string]int64 {
+	all, _ := rdb.HGetAll(ctx, ws.roomSinceKey(name)).Result()
+	watermarks := parseWatermarks(all)
+	for room, since := range watermarks {
+		watermarks[room] = max(since-1, 0)
+	}
+	return watermarks
+}
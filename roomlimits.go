@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Room limits. join_room creates a room that doesn't exist, so without
+// limits a script can fill Redis and the directory with rooms. Every room
+// is kept in chat:rooms:all (filled once per workspace at startup with the
+// rooms created before), and creating one is refused with room_limit once
+// the workspace has CHAT_MAX_ROOMS, and with room_quota (and resetsIn, as
+// for messages) once the user created CHAT_ROOM_CREATE_QUOTA rooms that
+// UTC day. Admin connections and CHAT_QUOTA_EXEMPT users have no quota.
+//
+// With CHAT_ROOM_IDLE_ARCHIVE set, a room without members and without a
+// message for that long is deleted and its history archived, as
+// room_delete with "archive":true does. A day before (half the period, if
+// that is shorter) a system message in the room says so; joining the room
+// calls it off. Every roomIdleSweepInterval one instance (the one that
+// takes roomIdleSweepLockKey) looks at every room. Rooms an admin made
+// permanent (room_update with "permanent":true) are never archived.
+const (
+	roomIdleSweepInterval = 10 * time.Minute
+	roomIdleWarning       = 24 * time.Hour
+)
+
+// takeRoomCreation checks that name may create room, counting it against
+// the limits, or sends the error and returns false.
+func takeRoomCreation(c *client, name, room string) bool {
+	ctx, ws := c.ctx, c.ws
+	if limit := cfg().RoomCreateQuota; limit > 0 && !c.admin && !quotaExempt(name) {
+		now := time.Now()
+		key := ws.roomQuotaKey(name, now.UTC().Format(time.DateOnly))
+		pipe := rdb.TxPipeline()
+		used := pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, untilReset(now))
+		if _, err := pipe.Exec(ctx); err == nil && used.Val() > int64(limit) {
+			frame := errorFrame(c, "room_quota", "daily room creation quota exceeded")
+			frame.ResetsIn = int64(untilReset(now).Seconds())
+			c.writeJSON(frame)
+			return false
+		}
+	}
+	// Add first, then count, as for room capacity: racing creations of
+	// the last rooms are all refused rather than all let through.
+	added, err := rdb.SAdd(ctx, ws.roomsKey(), room).Result()
+	if err != nil || added == 0 {
+		return true
+	}
+	if n, _ := rdb.SCard(ctx, ws.roomsKey()).Result(); n > int64(cfg().MaxRooms) {
+		rdb.SRem(ctx, ws.roomsKey(), room)
+		sendError(c, "room_limit", "this workspace has as many rooms as it may; join an existing one")
+		return false
+	}
+	return true
+}
+
+// runRoomIdleSweeps archives idle rooms until ctx is cancelled.
+func runRoomIdleSweeps(ctx context.Context) {
+	tick := time.NewTicker(roomIdleSweepInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			sweepIdleRooms(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sweepIdleRooms(ctx context.Context) {
+	idle := cfg().RoomIdleArchive
+	if idle <= 0 {
+		return
+	}
+	if ok, err := rdb.SetNX(ctx, roomIdleSweepLockKey(), instanceID, roomIdleSweepInterval/2).Result(); err != nil || !ok {
+		return
+	}
+	lead := min(roomIdleWarning, idle/2)
+	for _, ws := range knownWorkspaces(ctx) {
+		rooms, err := rdb.SMembers(ctx, ws.roomsKey()).Result()
+		if err != nil {
+			log.Printf("❌ Listing the rooms of workspace %q for idle archiving failed: %v", ws, err)
+			continue
+		}
+		for _, room := range rooms {
+			checkIdleRoom(ctx, ws, room, idle, lead)
+		}
+	}
+}
+
+// checkIdleRoom warns about room or archives it, if it has been idle long
+// enough. The warning is recorded in its metadata: when it was posted
+// (idleWarned), its message ID (idleWarning) and the time of the room's
+// last activity before it (idleSince), which the warning doesn't change.
+func checkIdleRoom(ctx context.Context, ws workspace, room string, idle, lead time.Duration) {
+	meta, err := rdb.HGetAll(ctx, ws.roomMetaKey(room)).Result()
+	if err != nil {
+		return
+	}
+	if len(meta) == 0 {
+		if !roomClosing(ctx, ws, room) {
+			rdb.SRem(ctx, ws.roomsKey(), room) // deleted by an older version
+		}
+		return
+	}
+	if meta["permanent"] == "1" || roomClosing(ctx, ws, room) {
+		return
+	}
+	if n, err := rdb.SCard(ctx, ws.roomMembersKey(room)).Result(); err != nil || n > 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	since, warned := roomIdleSince(ctx, ws, room, meta)
+	switch {
+	case warned > 0 && now-since >= int64(idle/time.Second) && now-warned >= int64(lead/time.Second):
+		archiveIdleRoom(ctx, ws, room, since)
+	case warned == 0 && now-since >= int64((idle-lead)/time.Second):
+		warnIdleRoom(ctx, ws, room, since, time.Unix(since, 0).Add(idle))
+	}
+}
+
+// roomIdleSince is when room was last active, and when its idle warning
+// was posted if that is still its last message (else 0).
+func roomIdleSince(ctx context.Context, ws workspace, room string, meta map[string]string) (since, warned int64) {
+	raw, _ := rdb.ZRange(ctx, ws.roomMessagesKey(room), -1, -1).Result()
+	if len(raw) > 0 {
+		if msg, ok := decodeMessage(raw[0]); ok {
+			if msg.ID != meta["idleWarning"] {
+				return msg.Time, 0
+			}
+			since, _ = strconv.ParseInt(meta["idleSince"], 10, 64)
+			warned, _ = strconv.ParseInt(meta["idleWarned"], 10, 64)
+			return since, warned
+		}
+	}
+	since, _ = strconv.ParseInt(meta["created"], 10, 64)
+	if meta["idleWarning"] != "" {
+		// The warning is gone (history expired); warn again.
+		rdb.HDel(ctx, ws.roomMetaKey(room), "idleWarned", "idleWarning", "idleSince")
+	}
+	return since, 0
+}
+
+func warnIdleRoom(ctx context.Context, ws workspace, room string, since int64, at time.Time) {
+	msg := systemMessage("system.room_idle", "at", at.UTC().Format("Jan 2 15:04 MST"))
+	// Stored only: the room has no members to tell, and the warning isn't
+	// activity for the directory.
+	jsonMsg, _ := json.Marshal(msg)
+	if !storeMessage(ctx, ws, ws.roomMessagesKey(room), msg, jsonMsg) {
+		return
+	}
+	rdb.HSet(ctx, ws.roomMetaKey(room), "idleWarned", msg.Time, "idleWarning", msg.ID, "idleSince", since)
+	log.Printf("⏳ Room %q of workspace %q is idle; archiving it on %s", room, ws, at.UTC().Format(time.RFC3339))
+}
+
+// clearIdleWarning calls off room's archiving, on a join.
+func clearIdleWarning(ctx context.Context, ws workspace, room string) {
+	rdb.HDel(ctx, ws.roomMetaKey(room), "idleWarned", "idleWarning", "idleSince")
+}
+
+func archiveIdleRoom(ctx context.Context, ws workspace, room string, since int64) {
+	const by = "idle"
+	if ok, err := rdb.SetNX(ctx, ws.roomClosingKey(room), by, roomClosingTTL).Result(); err != nil || !ok {
+		return
+	}
+	archive, err := deleteRoom(ctx, ws, room, by, true)
+	if err != nil {
+		rdb.Del(ctx, ws.roomClosingKey(room))
+		log.Printf("❌ Archiving idle room %q of workspace %q failed: %v", room, ws, err)
+		return
+	}
+	recordEvent(ws, chatEvent{Type: "room_delete", User: by, Room: room})
+	publishAdminEvent(protocol.AdminEvent{Event: "room_delete", Workspace: string(ws), Name: room, By: by})
+	log.Printf("🗄 Archived room %q of workspace %q, idle since %s, as %s", room, ws, time.Unix(since, 0).UTC().Format(time.RFC3339), archive.ID)
+}
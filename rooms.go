@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Rooms are named public conversations that users join explicitly. Each has
+// a member set, a history zset and a metadata hash (owner, created,
+// maxMembers, slowMode, historyVisibility, topic, private, unlisted,
+// permanent and the idle warning, see roomlimits.go);
+// messages are delivered through every member's personal dm:<user> channel,
+// like group DMs. The first user to join a room owns it.
+const maxRoomNameSize = 64
+
+func validRoomName(room string) bool {
+	return room != "" && len(room) <= maxRoomNameSize && strings.TrimSpace(room) == room
+}
+
+// roomCapacity is the room's own maxMembers, or the global default.
+func roomCapacity(ctx context.Context, ws workspace, room string) int {
+	if n, err := rdb.HGet(ctx, ws.roomMetaKey(room), "maxMembers").Int(); err == nil && n > 0 {
+		return n
+	}
+	return cfg().RoomMaxMembers
+}
+
+func getRoomInfo(ctx context.Context, ws workspace, room string) protocol.Room {
+	meta, _ := rdb.HGetAll(ctx, ws.roomMetaKey(room)).Result()
+	members, _ := rdb.SCard(ctx, ws.roomMembersKey(room)).Result()
+	return protocol.Room{Name: room, Owner: meta["owner"], MaxMembers: roomCapacity(ctx, ws, room), Members: members,
+		SlowModeSeconds: int(roomSlowMode(ctx, ws, room) / time.Second), HistoryVisibility: roomHistoryVisibility(ctx, ws, room),
+		Topic: meta["topic"], Private: meta["private"] == "1", Unlisted: meta["unlisted"] == "1", Permanent: meta["permanent"] == "1"}
+}
+
+// {"type":"join_room","room":"general"}
+func handleJoinRoom(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.JoinRoomRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validRoomName(req.Room) {
+		sendError(c, "bad_frame", "invalid join_room frame")
+		return
+	}
+
+	// A private room refuses strangers as room_info does, without saying
+	// it exists.
+	if !mayJoinRoom(c, name, req.Room) {
+		sendError(c, "not_found", "no such room")
+		return
+	}
+	if roomClosing(ctx, ws, req.Room) {
+		sendError(c, "room_closing", req.Room+" is being deleted; try again shortly", "room", req.Room)
+		return
+	}
+	if n, _ := rdb.Exists(ctx, ws.roomMetaKey(req.Room)).Result(); n == 0 && !takeRoomCreation(c, name, req.Room) {
+		return
+	}
+	if created, _ := rdb.HSetNX(ctx, ws.roomMetaKey(req.Room), "owner", name).Result(); created {
+		createRoom(ctx, ws, req.Room)
+	}
+
+	// Add first, then check: if two joins race for the last slot both see
+	// the room over capacity and both roll back, so it is never overfilled.
+	added, err := rdb.SAdd(ctx, ws.roomMembersKey(req.Room), name).Result()
+	if err != nil {
+		sendError(c, "internal", "could not join room")
+		return
+	}
+	if added == 1 {
+		if n, _ := rdb.SCard(ctx, ws.roomMembersKey(req.Room)).Result(); n > int64(roomCapacity(ctx, ws, req.Room)) {
+			rdb.SRem(ctx, ws.roomMembersKey(req.Room), name)
+			sendError(c, "room_full", req.Room+" is full", "room", req.Room)
+			return
+		}
+	}
+	rdb.SAdd(ctx, ws.userRoomsKey(name), req.Room)
+	rdb.SRem(ctx, ws.roomInvitesKey(req.Room), name)
+	if added == 1 {
+		recordRoomJoin(ctx, ws, req.Room, name)
+		clearIdleWarning(ctx, ws, req.Room)
+	}
+	enterRoom(c, req.Room)
+	if added == 1 {
+		publishRoomMembership(ws, name, req.Room, true)
+	}
+
+	var rawHistory []string
+	if after := roomVisibleAfter(ctx, ws, name, req.Room); after > 0 {
+		rawHistory, _ = latestBetween(ctx, ws, ws.roomMessagesKey(req.Room), "("+strconv.FormatInt(after, 10), "+inf", int64(c.cfg.HistoryLimit))
+	} else {
+		rawHistory, _ = rdb.ZRange(ctx, ws.roomMessagesKey(req.Room), -int64(c.cfg.HistoryLimit), -1).Result()
+	}
+	c.writeJSON(protocol.NewRoomJoined(getRoomInfo(ctx, ws, req.Room), withPolls(ctx, ws, decodeHistory(rawHistory))))
+	if added == 1 {
+		publishRoomUpdate(ctx, ws, req.Room, nil)
+		recordEvent(ws, chatEvent{Type: "room_join", User: name, Room: req.Room})
+	}
+}
+
+// {"type":"leave_room","room":"general"}
+func handleLeaveRoom(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.LeaveRoomRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid leave_room frame")
+		return
+	}
+	if !requireRoomMember(c, req.Room, name) {
+		return
+	}
+
+	rdb.SRem(ctx, ws.roomMembersKey(req.Room), name)
+	rdb.SRem(ctx, ws.userRoomsKey(name), req.Room)
+	rdb.HDel(ctx, ws.roomSinceKey(name), req.Room)
+	leaveRoom(c, req.Room)
+	publishRoomMembership(ws, name, req.Room, false)
+	publishRoomUpdate(ctx, ws, req.Room, []string{name})
+	recordEvent(ws, chatEvent{Type: "room_leave", User: name, Room: req.Room})
+}
+
+// {"type":"room_send","room":"general","text":"hi"}
+func handleRoomSend(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.RoomSendRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Text == "" || !validTempID(req.TempID) {
+		sendError(c, "bad_frame", "invalid room_send frame")
+		return
+	}
+	if !requireRoomMember(c, req.Room, name) {
+		return
+	}
+	if readOnlyRoom(req.Room) {
+		if owner, _ := rdb.HGet(ctx, ws.roomMetaKey(req.Room), "owner").Result(); owner != name {
+			sendError(c, "read_only", req.Room+" is read-only")
+			return
+		}
+	}
+	if !checkSlowMode(c, req.Room, name) {
+		return
+	}
+	if !takeQuota(c) {
+		return
+	}
+
+	msg := newMessage(name, req.Text)
+	if !applyDisplayName(c, &msg, req.DisplayName) || !applyFormat(c, &msg, req.Format, req.Lang) || !runInbound(c, "room", "room:"+req.Room, &msg) {
+		return
+	}
+	c.expectEcho(msg.ID, req.TempID)
+	if !postRoomMessage(ctx, ws, req.Room, msg) {
+		sendError(c, "not_stored", "message could not be stored; please retry")
+		return
+	}
+	sendAck(c, req.TempID, msg)
+	notifyWatchers(ctx, ws, req.Room, msg)
+	touchActivity(ctx, ws, name)
+	countTalker(ctx, ws, name)
+	recordEvent(ws, chatEvent{Type: "room_message", User: name, Room: req.Room, Len: len(msg.Text)})
+	bridgeMessage(ws, "room:"+req.Room, msg)
+	previewLinks(ws, "room:"+req.Room, msg)
+	setReadPosition(ctx, ws, name, "room:"+req.Room, protocol.ReadPosition{ID: msg.ID, Time: msg.Time})
+}
+
+// {"type":"room_set_capacity","room":"general","maxMembers":50}
+// Lowering the cap below the current occupancy keeps everyone in the room;
+// it only stops new joins.
+func handleRoomSetCapacity(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.RoomSetCapacityRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.MaxMembers <= 0 {
+		sendError(c, "bad_frame", "invalid room_set_capacity frame")
+		return
+	}
+	if owner, _ := rdb.HGet(ctx, ws.roomMetaKey(req.Room), "owner").Result(); owner != name {
+		sendError(c, "forbidden", "only the room owner can change its capacity")
+		return
+	}
+
+	rdb.HSet(ctx, ws.roomMetaKey(req.Room), "maxMembers", req.MaxMembers)
+	publishRoomUpdate(ctx, ws, req.Room, nil)
+}
+
+// {"type":"room_info","room":"general"}
+func handleRoomInfo(c *client, data []byte) {
+	ctx := c.ctx
+	var req protocol.RoomInfoRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validRoomName(req.Room) {
+		sendError(c, "bad_frame", "invalid room_info frame")
+		return
+	}
+	if n, _ := rdb.Exists(ctx, c.ws.roomMetaKey(req.Room)).Result(); n == 0 || !roomVisibleTo(c, req.Room) {
+		sendError(c, "not_found", "no such room")
+		return
+	}
+
+	c.writeJSON(protocol.NewRoomInfo(getRoomInfo(ctx, c.ws, req.Room)))
+}
+
+// roomVisibleTo reports whether c may see that room exists: it isn't
+// private, or c is a member or an admin connection (see roomdir.go).
+func roomVisibleTo(c *client, room string) bool {
+	if private, _ := rdb.HGet(c.ctx, c.ws.roomMetaKey(room), "private").Result(); private != "1" || c.admin {
+		return true
+	}
+	name := c.userName()
+	member, _ := rdb.SIsMember(c.ctx, c.ws.roomMembersKey(room), name).Result()
+	return name != "" && member
+}
+
+// mayJoinRoom reports whether name may join room: it isn't private, or
+// they are already a member, hold an invite, or c is an admin connection.
+func mayJoinRoom(c *client, name, room string) bool {
+	if roomVisibleTo(c, room) {
+		return true
+	}
+	invited, _ := rdb.SIsMember(c.ctx, c.ws.roomInvitesKey(room), name).Result()
+	return invited
+}
+
+// {"type":"room_invite","room":"secret","name":"bob"}
+func handleRoomInvite(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.RoomInviteRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validRoomName(req.Room) || strings.TrimSpace(req.Name) == "" {
+		sendError(c, "bad_frame", "invalid room_invite frame")
+		return
+	}
+	if n, _ := rdb.Exists(ctx, ws.roomMetaKey(req.Room)).Result(); n == 0 || !roomVisibleTo(c, req.Room) {
+		sendError(c, "not_found", "no such room")
+		return
+	}
+	if !c.admin && !requireRoomMember(c, req.Room, name) {
+		return
+	}
+
+	invitee := resolveName(ctx, ws, strings.TrimSpace(req.Name))
+	if err := rdb.SAdd(ctx, ws.roomInvitesKey(req.Room), invitee).Err(); err != nil {
+		sendError(c, "internal", "could not store the invite")
+		return
+	}
+	c.writeJSON(protocol.NewRoomInvite(req.Room, invitee))
+}
+
+func requireRoomMember(c *client, room, name string) bool {
+	ctx := c.ctx
+	if room != "" {
+		if ok, _ := rdb.SIsMember(ctx, c.ws.roomMembersKey(room), name).Result(); ok {
+			return true
+		}
+	}
+	sendError(c, "not_found", "you are not in that room")
+	return false
+}
+
+// postRoomMessage stores and delivers msg, reporting false if it could
+// not be stored (see storeMessage).
+func postRoomMessage(ctx context.Context, ws workspace, room string, msg ChatMessage) bool {
+	jsonMsg, _ := json.Marshal(msg)
+	if !storeMessage(ctx, ws, ws.roomMessagesKey(room), msg, jsonMsg) {
+		return false
+	}
+
+	touchRoomDirectory(ctx, ws, room, msg.Time)
+
+	frame, _ := json.Marshal(protocol.NewRoomMessage(room, msg))
+	publishRoom(ctx, ws, room, frame, nil)
+	return true
+}
+
+// publishRoomUpdate sends the room's metadata and occupancy to its members
+// (and anyone who just left).
+func publishRoomUpdate(ctx context.Context, ws workspace, room string, left []string) {
+	frame, _ := json.Marshal(protocol.NewRoomInfo(getRoomInfo(ctx, ws, room)))
+	publishRoom(ctx, ws, room, frame, left)
+}
+
+// restoreRooms returns, for the joined frame, the rooms name is in with
+// their unread counts. Memberships outlive connections: only leave_room
+// (or deleting the user) ends one. A room that no longer lists name as a
+// member, because it was deleted while they were away, is dropped from
+// their set and returned in gone.
+func restoreRooms(ctx context.Context, ws workspace, name string) (rooms []string, unread map[string]int64, gone []string) {
+	rooms, unread, gone = []string{}, map[string]int64{}, []string{}
+	names, _ := rdb.SMembers(ctx, ws.userRoomsKey(name)).Result()
+	if len(names) == 0 {
+		return
+	}
+
+	pipe := rdb.Pipeline()
+	member := make([]*redis.BoolCmd, len(names))
+	for i, room := range names {
+		member[i] = pipe.SIsMember(ctx, ws.roomMembersKey(room), name)
+	}
+	pipe.Exec(ctx)
+
+	watermarks := roomWatermarks(ctx, ws, name)
+	for i, room := range names {
+		if member[i].Err() == nil && !member[i].Val() {
+			rdb.SRem(ctx, ws.userRoomsKey(name), room)
+			rdb.HDel(ctx, ws.roomSinceKey(name), room)
+			gone = append(gone, room)
+			continue
+		}
+		rooms = append(rooms, room)
+		pos := getReadPosition(ctx, ws, name, "room:"+room)
+		after := max(pos.Time, watermarks[room])
+		unread[room], _ = countBetween(ctx, ws, ws.roomMessagesKey(room), "("+strconv.FormatInt(after, 10), "+inf")
+	}
+	sort.Strings(rooms)
+	return
+}
@@ -0,0 +1,180 @@
+package main_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestPrivateRoomJoin checks that join_room refuses a private room to a
+// user who isn't a member or invited, with the not_found room_info gives,
+// and lets in a member's invitee and an admin connection.
+func TestPrivateRoomJoin(t *testing.T) {
+	addr := startServer(t, "", withAdmin)
+	alice := dial(t, addr, "", "alice")
+	alice.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "secret"})
+	await(t, alice, protocol.TypeRoomJoined)
+	private := true
+	alice.SendFrame(protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "secret", Private: &private})
+	await(t, alice, protocol.TypeRoom)
+	alice.SendFrame(protocol.RoomSendRequest{Type: protocol.TypeRoomSend, Room: "secret", Text: "the launch code is 1234"})
+	await(t, alice, protocol.TypeRoomMessage)
+
+	mallory := dial(t, addr, "", "mallory")
+	for _, frame := range []interface{}{
+		protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "secret"},
+		protocol.RoomInfoRequest{Type: protocol.TypeRoomInfo, Room: "secret"},
+		protocol.RoomInviteRequest{Type: protocol.TypeRoomInvite, Room: "secret", Name: "mallory"},
+		protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "secret"},
+	} {
+		mallory.SendFrame(frame)
+		refused(t, mallory, "not_found")
+	}
+	alice.SendFrame(protocol.RoomInfoRequest{Type: protocol.TypeRoomInfo, Room: "secret"})
+	var info protocol.RoomInfo
+	decode(t, await(t, alice, protocol.TypeRoom), &info)
+	if info.Room.Members != 1 {
+		t.Errorf("secret has %d members, want alice alone", info.Room.Members)
+	}
+
+	bob := dial(t, addr, "", "bob")
+	bob.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello}) // until bob's join is handled
+	await(t, bob, protocol.TypeHello)
+	alice.SendFrame(protocol.RoomInviteRequest{Type: protocol.TypeRoomInvite, Room: "secret", Name: "BOB"})
+	var invite protocol.RoomInvite
+	decode(t, await(t, alice, protocol.TypeRoomInvite), &invite)
+	if invite.Room != "secret" || invite.Name != "bob" {
+		t.Errorf("got %+v, want bob invited to secret", invite)
+	}
+	bob.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "secret"})
+	var joined protocol.RoomJoined
+	decode(t, await(t, bob, protocol.TypeRoomJoined), &joined)
+	if len(joined.History) != 1 || !strings.Contains(joined.History[0].Text, "1234") {
+		t.Errorf("bob joined with history %+v", joined.History)
+	}
+
+	admin := adminConn(t, addr, "admin")
+	admin.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "secret"})
+	await(t, admin, protocol.TypeRoomJoined)
+}
+
+// TestSlowMode has alice, the owner, put her room in slow mode and then
+// set it to 0. In slow mode bob's second message is refused with slow_mode
+// and the seconds left, and alice is never limited; at 0 bob can send
+// back to back again, and the room frame tells members it is off.
+func TestSlowMode(t *testing.T) {
+	addr := startServer(t, "")
+	alice := dial(t, addr, "", "alice")
+	bob := dial(t, addr, "", "bob")
+	for _, c := range []*client.Client{alice, bob} {
+		c.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "lounge"})
+		await(t, c, protocol.TypeRoomJoined)
+	}
+	// update sets lounge's slow mode and waits until both are told, past
+	// the room frames of their joins.
+	update := func(seconds int) {
+		t.Helper()
+		alice.SendFrame(protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "lounge", SlowModeSeconds: &seconds})
+		for _, c := range []*client.Client{alice, bob} {
+			for info := (protocol.RoomInfo{}); info.Room.Name != "lounge" || info.Room.SlowModeSeconds != seconds; {
+				decode(t, await(t, c, protocol.TypeRoom), &info)
+			}
+		}
+	}
+	send := func(c *client.Client, text string) {
+		c.SendFrame(protocol.RoomSendRequest{Type: protocol.TypeRoomSend, Room: "lounge", Text: text})
+	}
+
+	update(60)
+	send(bob, "one")
+	await(t, bob, protocol.TypeRoomMessage)
+	send(bob, "two")
+	var e protocol.Error
+	decode(t, await(t, bob, protocol.TypeError), &e)
+	if e.Code != "slow_mode" || e.Room != "lounge" || e.RetryAfter < 59 || e.RetryAfter > 60 {
+		t.Errorf("got %+v, want slow_mode for lounge with about 60s left", e)
+	}
+	for _, text := range []string{"mine", "mine too"} {
+		send(alice, text)
+		await(t, alice, protocol.TypeRoomMessage)
+	}
+
+	update(0)
+	for _, text := range []string{"three", "four"} {
+		send(bob, text)
+		if f := await(t, bob, protocol.TypeRoomMessage, protocol.TypeError); f.Type != protocol.TypeRoomMessage {
+			t.Errorf("with slow mode off, %s got %s", text, f.Raw)
+		}
+	}
+}
+
+// TestHistorySinceJoin has bob join a since_join room, leave it and
+// rejoin: each join must show him only messages from its own second on,
+// none from before his first join or from while he was away, while carol,
+// joining once the room shows everything, sees all of them.
+func TestHistorySinceJoin(t *testing.T) {
+	addr := startServer(t, "")
+	alice := dial(t, addr, "", "alice")
+	bob := dial(t, addr, "", "bob")
+	alice.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "club"})
+	await(t, alice, protocol.TypeRoomJoined)
+	visibility := "since_join"
+	alice.SendFrame(protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "club", HistoryVisibility: &visibility})
+	await(t, alice, protocol.TypeRoom)
+	send := func(text string) {
+		t.Helper()
+		alice.SendFrame(protocol.RoomSendRequest{Type: protocol.TypeRoomSend, Room: "club", Text: text})
+		await(t, alice, protocol.TypeRoomMessage)
+	}
+	// join has c join club and returns the history it is shown.
+	join := func(c *client.Client) []string {
+		t.Helper()
+		c.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "club"})
+		var joined protocol.RoomJoined
+		decode(t, await(t, c, protocol.TypeRoomJoined), &joined)
+		var texts []string
+		for _, m := range joined.History {
+			if !m.System {
+				texts = append(texts, m.Text)
+			}
+		}
+		return texts
+	}
+	// Times are in seconds: a message in the second of the join shows.
+	nextSecond := func() { time.Sleep(time.Until(time.Unix(time.Now().Unix()+1, 0))) }
+
+	send("before")
+	nextSecond()
+	if got := join(bob); got != nil {
+		t.Errorf("bob joined with history %q, want none", got)
+	}
+	send("during")
+	if f := await(t, bob, protocol.TypeRoomMessage); !strings.Contains(string(f.Raw), "during") {
+		t.Errorf("bob got %s, want during", f.Raw)
+	}
+
+	bob.SendFrame(protocol.LeaveRoomRequest{Type: protocol.TypeLeaveRoom, Room: "club"})
+	bob.SendFrame(protocol.HelloRequest{Type: protocol.TypeHello}) // until the leave is handled
+	await(t, bob, protocol.TypeHello)
+	send("while away")
+	nextSecond()
+	if got := join(bob); got != nil {
+		t.Errorf("bob rejoined with history %q, want none", got)
+	}
+	send("back")
+	if f := await(t, bob, protocol.TypeRoomMessage); !strings.Contains(string(f.Raw), "back") {
+		t.Errorf("bob got %s, want back", f.Raw)
+	}
+
+	visibility = "all"
+	alice.SendFrame(protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "club", HistoryVisibility: &visibility})
+	await(t, alice, protocol.TypeRoom)
+	carol := dial(t, addr, "", "carol")
+	if got, want := join(carol), []string{"before", "during", "while away", "back"}; !slices.Equal(got, want) {
+		t.Errorf("carol joined with history %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Slow mode. The room's owner (or an admin connection) sets an interval:
+//
+//	{"type":"room_update","room":"general","slowModeSeconds":30}
+//
+// after which every other member may send one room_send per interval; 0
+// turns it off. The interval is the room's slowMode metadata field, so the
+// room frames members get carry it (slowModeSeconds) and clients can show a
+// countdown. The time of each member's last message is kept in
+// chat:room:<room>:slow:<name>, set only if absent and expiring with the
+// interval, so two connections of one user can't both get through. A
+// message sent too early is refused with a slow_mode error giving the
+// seconds left (retryAfter). Like the quota, a slot is used up by a message
+// refused further on.
+const maxSlowModeSeconds = 6 * 60 * 60
+
+// roomSlowMode is the room's slow mode interval, 0 if it is off.
+func roomSlowMode(ctx context.Context, ws workspace, room string) time.Duration {
+	n, _ := rdb.HGet(ctx, ws.roomMetaKey(room), "slowMode").Int()
+	return time.Duration(n) * time.Second
+}
+
+// takeSlowModeSlot records that name is sending to room now, or returns
+// how long they must still wait. Redis errors let the message through.
+func takeSlowModeSlot(ctx context.Context, ws workspace, room, name string, interval time.Duration) time.Duration {
+	key := ws.roomSlowKey(room, name)
+	for range 2 {
+		now := time.Now().UnixMilli()
+		ok, err := rdb.SetNX(ctx, key, now, interval).Result()
+		if err != nil || ok {
+			return 0
+		}
+		sent, err := rdb.Get(ctx, key).Int64()
+		if err == redis.Nil {
+			continue // expired in between
+		}
+		if err != nil {
+			return 0
+		}
+		if wait := time.Duration(sent+interval.Milliseconds()-now) * time.Millisecond; wait > 0 {
+			return wait
+		}
+		// Exactly at the interval, or the interval was shortened since:
+		// the last slot is over.
+		rdb.Del(ctx, key)
+	}
+	return 0
+}
+
+// checkSlowMode sends a slow_mode error and returns false if name must
+// wait before sending to room. The owner and admin connections are exempt.
+func checkSlowMode(c *client, room, name string) bool {
+	interval := roomSlowMode(c.ctx, c.ws, room)
+	if interval <= 0 || c.admin {
+		return true
+	}
+	if owner, _ := rdb.HGet(c.ctx, c.ws.roomMetaKey(room), "owner").Result(); owner == name {
+		return true
+	}
+	wait := takeSlowModeSlot(c.ctx, c.ws, room, name, interval)
+	if wait <= 0 {
+		return true
+	}
+	frame := errorFrame(c, "slow_mode", room+" is in slow mode", "room", room)
+	frame.Room, frame.RetryAfter = room, int64((wait+time.Second-1)/time.Second)
+	c.writeJSON(frame)
+	return false
+}
+
+// {"type":"room_update","room":"general","slowModeSeconds":30,"historyVisibility":"since_join","topic":"...","unlisted":true}
+// Admin connections may also send "permanent" (see roomlimits.go).
+func handleRoomUpdate(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.RoomUpdateRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validRoomName(req.Room) ||
+		req.SlowModeSeconds == nil && req.HistoryVisibility == nil && req.Topic == nil && req.Private == nil && req.Unlisted == nil && req.Permanent == nil {
+		sendError(c, "bad_frame", "invalid room_update frame")
+		return
+	}
+	if req.SlowModeSeconds != nil && (*req.SlowModeSeconds < 0 || *req.SlowModeSeconds > maxSlowModeSeconds) {
+		sendError(c, "bad_frame", "invalid room_update frame; slowModeSeconds must be 0 to "+strconv.Itoa(maxSlowModeSeconds))
+		return
+	}
+	if req.HistoryVisibility != nil && !validHistoryVisibility(*req.HistoryVisibility) {
+		sendError(c, "bad_frame", "invalid room_update frame; historyVisibility must be all or since_join")
+		return
+	}
+	if req.Topic != nil && !validRoomTopic(*req.Topic) {
+		sendError(c, "bad_frame", "invalid room_update frame; topic must be at most "+strconv.Itoa(maxRoomTopicRunes)+" characters")
+		return
+	}
+	if n, _ := rdb.Exists(ctx, ws.roomMetaKey(req.Room)).Result(); n == 0 {
+		sendError(c, "not_found", "no such room")
+		return
+	}
+	if owner, _ := rdb.HGet(ctx, ws.roomMetaKey(req.Room), "owner").Result(); owner != name && !c.admin {
+		sendError(c, "forbidden", "only the room owner or an admin can change its settings")
+		return
+	}
+	if req.Permanent != nil && !c.admin {
+		sendError(c, "forbidden", "only an admin can make a room permanent")
+		return
+	}
+
+	switch {
+	case req.SlowModeSeconds == nil:
+	case *req.SlowModeSeconds == 0:
+		rdb.HDel(ctx, ws.roomMetaKey(req.Room), "slowMode")
+	default:
+		rdb.HSet(ctx, ws.roomMetaKey(req.Room), "slowMode", *req.SlowModeSeconds)
+	}
+	if req.HistoryVisibility != nil {
+		rdb.HSet(ctx, ws.roomMetaKey(req.Room), "historyVisibility", *req.HistoryVisibility)
+	}
+	switch {
+	case req.Topic == nil:
+	case *req.Topic == "":
+		rdb.HDel(ctx, ws.roomMetaKey(req.Room), "topic")
+	default:
+		rdb.HSet(ctx, ws.roomMetaKey(req.Room), "topic", *req.Topic)
+	}
+	for field, v := range map[string]*bool{"private": req.Private, "unlisted": req.Unlisted, "permanent": req.Permanent} {
+		switch {
+		case v == nil:
+		case *v:
+			rdb.HSet(ctx, ws.roomMetaKey(req.Room), field, "1")
+		default:
+			rdb.HDel(ctx, ws.roomMetaKey(req.Room), field)
+		}
+	}
+	if req.Private != nil || req.Unlisted != nil {
+		syncRoomDirectory(ctx, ws, req.Room)
+	}
+	publishRoomUpdate(ctx, ws, req.Room, nil)
+	if member, _ := rdb.SIsMember(ctx, ws.roomMembersKey(req.Room), name).Result(); !member {
+		// An admin outside the room gets no broadcast.
+		c.writeJSON(protocol.NewRoomInfo(getRoomInfo(ctx, ws, req.Room)))
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSlowModeSlot checks takeSlowModeSlot around the interval: a send
+// exactly one interval after the last one gets through, even while the
+// last one's key hasn't expired yet, and one just short of it waits for
+// the rest. An interval of 0 is slow mode off.
+func TestSlowModeSlot(t *testing.T) {
+	ctx := context.Background()
+	initMemory()
+	ws := defaultWorkspace
+	const interval = 2 * time.Second
+	key := ws.roomSlowKey("general", "bob")
+
+	if wait := takeSlowModeSlot(ctx, ws, "general", "bob", interval); wait != 0 {
+		t.Fatalf("the first send waits %s", wait)
+	}
+	if wait := takeSlowModeSlot(ctx, ws, "general", "bob", interval); wait <= interval-time.Second || wait > interval {
+		t.Errorf("a second send right away waits %s, want about %s", wait, interval)
+	}
+
+	// Without an expiry, as if Redis hadn't got round to expiring it.
+	rdb.Set(ctx, key, time.Now().Add(-interval).UnixMilli(), 0)
+	if wait := takeSlowModeSlot(ctx, ws, "general", "bob", interval); wait != 0 {
+		t.Errorf("a send exactly at the interval waits %s", wait)
+	}
+	if ttl := rdb.PTTL(ctx, key).Val(); ttl <= 0 || ttl > interval {
+		t.Errorf("the send at the interval left the key with ttl %s, want it to start a new interval", ttl)
+	}
+
+	rdb.Set(ctx, key, time.Now().Add(-interval+300*time.Millisecond).UnixMilli(), 0)
+	if wait := takeSlowModeSlot(ctx, ws, "general", "bob", interval); wait <= 0 || wait > 300*time.Millisecond {
+		t.Errorf("a send 300ms short of the interval waits %s, want the rest of it", wait)
+	}
+
+	meta := ws.roomMetaKey("general")
+	rdb.HSet(ctx, meta, "slowMode", 30)
+	if got := roomSlowMode(ctx, ws, "general"); got != 30*time.Second {
+		t.Errorf("slow mode %s, want 30s", got)
+	}
+	rdb.HDel(ctx, meta, "slowMode")
+	if got := roomSlowMode(ctx, ws, "general"); got != 0 {
+		t.Errorf("slow mode %s after turning it off, want 0", got)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Roster broadcasts. member_add and member_remove arrive one pub/sub
+// message per join or leave; a burst of them (a bot cycling names, a
+// restart reconnecting everyone) would cost every client one frame each.
+// Each workspace's listener instead sends at most one roster update per
+// CHAT_ROSTER_DEBOUNCE: the first change after a quiet period goes out at
+// once, and changes arriving within the period after it are collected and
+// sent together when it ends. Only the last change per name counts, so a
+// join and leave of the same name in one batch becomes a single remove.
+//
+// Connections get each update as one frame,
+//
+//	{"type":"roster_diff","added":[{"name":"alice"},{"name":"sam","spectator":true}],"removed":["bob"]}
+//
+// or, if they connected with ?roster=events (or CHAT_ROSTER_FORMAT=events
+// makes that the default), as the older member_add / member_remove frames,
+// one per name. init says which.
+const (
+	rosterDiff   = "diff"
+	rosterEvents = "events"
+)
+
+// rosterFormat is the roster format r asks for, or the configured default.
+func rosterFormat(r *http.Request) string {
+	switch f := r.URL.Query().Get("roster"); f {
+	case rosterDiff, rosterEvents:
+		return f
+	}
+	if cfg().RosterFormat == rosterEvents {
+		return rosterEvents
+	}
+	return rosterDiff
+}
+
+type rosterChange struct {
+	added     bool
+	spectator bool
+}
+
+func listenRoster(ctx context.Context, ws workspace, adds, removes subscription) {
+	pending := map[string]rosterChange{}
+	var order []string
+	var flush <-chan time.Time
+	var last time.Time
+
+	note := func(name string, ch rosterChange) {
+		if _, ok := pending[name]; !ok {
+			order = append(order, name)
+		}
+		pending[name] = ch
+		if flush == nil {
+			flush = time.After(time.Until(last.Add(cfg().RosterDebounce)))
+		}
+	}
+
+	members := memberCacheOf(ws)
+	addCh, removeCh := adds.Messages(), removes.Messages()
+	for {
+		select {
+		case msg, ok := <-addCh:
+			if !ok {
+				return
+			}
+			name, ok := openPayload(msg)
+			if !ok {
+				continue
+			}
+			members.change(string(name), true)
+			spectator, _ := rdb.SIsMember(ctx, ws.spectatorsKey(), string(name)).Result()
+			note(string(name), rosterChange{added: true, spectator: spectator})
+		case msg, ok := <-removeCh:
+			if !ok {
+				return
+			}
+			name, ok := openPayload(msg)
+			if !ok {
+				continue
+			}
+			members.change(string(name), false)
+			note(string(name), rosterChange{})
+		case <-flush:
+			sendRoster(ws, order, pending)
+			pending, order, flush, last = map[string]rosterChange{}, nil, nil, time.Now()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sendRoster(ws workspace, order []string, pending map[string]rosterChange) {
+	added, removed := []protocol.RosterMember{}, []string{}
+	events := make([]interface{}, 0, len(order))
+	for _, name := range order {
+		ch := pending[name]
+		if ch.added {
+			added = append(added, protocol.RosterMember{Name: name, Spectator: ch.spectator})
+			events = append(events, protocol.NewMemberAdd(name, ch.spectator))
+		} else {
+			removed = append(removed, name)
+			events = append(events, protocol.NewMemberRemove(name))
+		}
+	}
+	diff, _ := json.Marshal(protocol.NewRosterDiff(added, removed))
+
+	for _, c := range workspaceClients(ws) {
+		if c.roster == rosterDiff {
+			c.writeMessage(diff)
+			continue
+		}
+		for _, event := range events {
+			if c.writeJSON(event) != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/metrics"
+)
+
+// Control-frame RTT. Besides the application-level pings in latency.go,
+// which need the client's cooperation, the server sends a websocket ping
+// control frame every CHAT_RTT_PING_INTERVAL carrying the time it was sent
+// (8 bytes, big-endian nanoseconds on the monotonic clock since start).
+// Browsers and websocket libraries answer pings with a pong echoing the
+// payload on their own, so every connection is measured. The pong handler
+// takes the delta, keeps a moving average per connection (pingRttMs in
+// /api/stats) and adds it to the chat_ws_rtt_seconds histogram on
+// /metrics. A connection whose average goes over CHAT_RTT_SLOW is flagged
+// (rttSlow), a candidate for eviction before its writes start timing out.
+//
+// The pings neither set nor extend a read deadline: they only measure.
+// gorilla keeps one pong handler per connection, so a keepalive that
+// extends read deadlines on pongs must do it in handleControlPong, not
+// install a handler of its own. Pings are sent with WriteControl, which
+// may run alongside a blocked data write, so a stuck client still gets
+// them (and a missing pong then shows up as no new sample, not a bogus
+// one).
+var (
+	processStart = time.Now()
+
+	rttHistogram = metrics.NewHistogram("chat_ws_rtt_seconds",
+		"Round-trip time of websocket ping control frames.",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5})
+)
+
+// startControlPings installs the pong handler, before the read loop
+// starts, and pings the client until the connection closes.
+func startControlPings(c *client) {
+	c.conn.SetPongHandler(func(payload string) error {
+		handleControlPong(c, payload)
+		return nil
+	})
+	go runControlPings(c)
+}
+
+func runControlPings(c *client) {
+	ticker := time.NewTicker(cfg().RTTPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			var payload [8]byte
+			binary.BigEndian.PutUint64(payload[:], uint64(time.Since(processStart)))
+			if c.conn.WriteControl(websocket.PingMessage, payload[:], time.Now().Add(cfg().WriteTimeout)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleControlPong records the RTT of one of our pings. It runs on the
+// read loop's goroutine, inside ReadMessage.
+func handleControlPong(c *client, payload string) {
+	if len(payload) != 8 {
+		return
+	}
+	sent := time.Duration(binary.BigEndian.Uint64([]byte(payload)))
+	rtt := time.Since(processStart) - sent
+	// Ignore pongs to pings we can't have sent recently.
+	if rtt < 0 || rtt > 2*cfg().RTTPingInterval {
+		return
+	}
+	rttHistogram.Observe(rtt.Seconds())
+	c.recordPingRTT(float64(rtt) / float64(time.Millisecond))
+}
+
+func (c *client) recordPingRTT(ms float64) {
+	c.mu.Lock()
+	if c.pingRTTMs == 0 {
+		c.pingRTTMs = ms
+	} else {
+		c.pingRTTMs += rttSmoothing * (ms - c.pingRTTMs)
+	}
+	avg, name := c.pingRTTMs, c.name
+	slow := avg > float64(cfg().RTTSlow)/float64(time.Millisecond)
+	crossed := slow && !c.rttSlow
+	c.rttSlow = slow
+	c.mu.Unlock()
+
+	if crossed {
+		log.Printf("📶 Connection of %q from %s is slow: RTT %.1fms over %s", name, c.ip, avg, cfg().RTTSlow)
+	}
+}
+
+func (c *client) pingRTT() (ms float64, slow bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pingRTTMs, c.rttSlow
+}
+
+// slowRTTClients is how many connections here are flagged as slow.
+func slowRTTClients() int {
+	n := 0
+	for _, c := range connectedClients() {
+		if _, slow := c.pingRTT(); slow {
+			n++
+		}
+	}
+	return n
+}
+
+// connectionsByWorkspace counts the connections here, and those flagged
+// as slow, per workspace ID ("" for the default workspace, always listed).
+func connectionsByWorkspace() (conns, slow map[string]float64) {
+	conns, slow = map[string]float64{"": 0}, map[string]float64{"": 0}
+	for _, c := range connectedClients() {
+		conns[string(c.ws)]++
+		if _, ok := slow[string(c.ws)]; !ok {
+			slow[string(c.ws)] = 0
+		}
+		if _, s := c.pingRTT(); s {
+			slow[string(c.ws)]++
+		}
+	}
+	return conns, slow
+}
+
+// GET /metrics, in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rttHistogram.WriteTo(w)
+	pubsubLagHistogram.WriteTo(w)
+	wsWriteHistogram.WriteTo(w)
+	inboundWaitHistogram.WriteTo(w)
+	inboundHandleHistogram.WriteTo(w)
+	metrics.WriteGauge(w, "chat_inbound_queued", "Frames waiting for a worker, all connections together.", float64(inboundQueued.Load()))
+	metrics.WriteGauge(w, "chat_inbound_queue_longest", "Frames waiting for a worker on the connection with the most.", float64(longestInboundQueue()))
+	metrics.WriteCounter(w, "chat_inbound_rejected_total", "Frames refused with queue_full.", float64(inboundRejected.Load()))
+	metrics.WriteCounter(w, "chat_pubsub_lagged_total", "Broadcasts read more than CHAT_PUBSUB_LAG_WARN after they were published.", float64(pubsubLagged.Load()))
+	conns, slow := connectionsByWorkspace()
+	metrics.WriteGaugeVec(w, "chat_ws_rtt_slow_connections", "Connections whose smoothed RTT is over CHAT_RTT_SLOW, per workspace.", "workspace", slow)
+	metrics.WriteGaugeVec(w, "chat_ws_connections", "Open websocket connections, per workspace.", "workspace", conns)
+	metrics.WriteCounter(w, "chat_outbound_truncated_total", "Frames cut down to fit CHAT_MAX_OUTBOUND_BYTES.", float64(outboundTruncated.Load()))
+	metrics.WriteCounter(w, "chat_outbound_rejected_total", "Frames dropped for being over CHAT_MAX_OUTBOUND_BYTES.", float64(outboundRejected.Load()))
+	metrics.WriteCounter(w, "chat_ws_slow_evictions_total", "Connections closed because a write timed out.", float64(slowEvictions.Load()))
+	metrics.WriteCounter(w, "chat_listener_restarts_total", "Pub/sub listeners restarted after a panic or a closed subscription.", float64(listeners.Restarts()))
+	metrics.WriteGauge(w, "chat_listeners_down", "Pub/sub listeners waiting to be restarted.", float64(len(listeners.Down())))
+}
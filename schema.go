@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Schema versions. chat:schema_version says which layout the data in Redis
+// has, for all workspaces at once. At startup checkSchema compares it with
+// the migrations this binary knows: newer data makes the server refuse to
+// start, rather than misread it; older data is brought up to date by
+// running the missing migrations in order, each bumping the version once it
+// is done. One instance migrates, holding chat:schema_migration; others
+// starting meanwhile wait for it. Data without a version predates it and is
+// version 0, so an empty database runs every migration, finding nothing to
+// do.
+//
+// Migrations must be idempotent, as one cut short (a crash, the lock
+// expiring) runs again at the next start. Instances of an older binary
+// know nothing of the version, so stop them before upgrading across one.
+const (
+	schemaMigrationLock = 10 * time.Minute
+	schemaPollInterval  = time.Second
+)
+
+type migration struct {
+	version int
+	name    string
+	run     func(ctx context.Context) error
+}
+
+// migrations, oldest first. Append only.
+var migrations = []migration{
+	{1, "one history key per DM conversation", migrateCanonicalDMs},
+	{2, "case-insensitive names", migrateCaseInsensitiveNames},
+}
+
+// schemaVersion is the version of the data this binary writes.
+func schemaVersion() int { return migrations[len(migrations)-1].version }
+
+// dataSchemaVersion is the version of the data in Redis.
+func dataSchemaVersion(ctx context.Context) (int, error) {
+	raw, err := rdb.Get(ctx, schemaVersionKey()).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(raw)
+}
+
+// checkSchema returns once the data is at schemaVersion, migrating it if
+// it is older, and fails if it is newer or can't be migrated.
+func checkSchema(ctx context.Context) error {
+	for waiting := false; ; waiting = true {
+		v, err := dataSchemaVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("reading the schema version: %w", err)
+		}
+		if v > schemaVersion() {
+			return fmt.Errorf("the data is at schema version %d, newer than this server understands (%d); run a newer server", v, schemaVersion())
+		}
+		if v == schemaVersion() {
+			return nil
+		}
+
+		ok, err := rdb.SetNX(ctx, schemaLockKey(), instanceID, schemaMigrationLock).Result()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if !waiting {
+				fmt.Printf("⏳ Waiting for another instance to migrate the data to schema version %d\n", schemaVersion())
+			}
+			select {
+			case <-time.After(schemaPollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		err = runMigrations(ctx, v)
+		// A migration that outlasts the lock may find another instance
+		// holding it by now; that one's lock isn't this one's to drop.
+		if holder, _ := rdb.Get(ctx, schemaLockKey()).Result(); holder == instanceID {
+			rdb.Del(ctx, schemaLockKey())
+		}
+		return err
+	}
+}
+
+// runMigrations runs the migrations after version from, in order.
+func runMigrations(ctx context.Context, from int) error {
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		start := time.Now()
+		if err := m.run(ctx); err != nil {
+			return fmt.Errorf("migrating to schema version %d (%s): %w", m.version, m.name, err)
+		}
+		if err := rdb.Set(ctx, schemaVersionKey(), m.version, 0).Err(); err != nil {
+			return err
+		}
+		fmt.Printf("🧬 Migrated the data to schema version %d (%s) in %v\n", m.version, m.name, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// migrateCanonicalDMs moves DMs from the two per-direction keys of schema
+// version 0 (chat:dm:<sender>:<receiver>) into the conversation's one key
+// (see dmKey), merged in time order and renumbered.
+func migrateCanonicalDMs(ctx context.Context) error {
+	for _, ws := range knownWorkspaces(ctx) {
+		prefix := ws.key("dm") + ":"
+		pairs := map[[2]string]bool{}
+		iter := rdb.Scan(ctx, 0, escapeGlob(prefix)+"*", 500).Iterator()
+		for iter.Next(ctx) {
+			sender, receiver, ok := strings.Cut(strings.TrimPrefix(iter.Val(), prefix), ":")
+			if !ok || strings.Contains(receiver, ":") {
+				continue
+			}
+			if receiver < sender {
+				sender, receiver = receiver, sender
+			}
+			pairs[[2]string{sender, receiver}] = true
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		moved := 0
+		for pair := range pairs {
+			n, err := migrateDMPair(ctx, ws, pair[0], pair[1])
+			if err != nil {
+				return fmt.Errorf("DMs between %s and %s: %w", pair[0], pair[1], err)
+			}
+			moved += n
+		}
+		if len(pairs) > 0 {
+			fmt.Printf("🧬 Moved %d DM(s) of %d conversation(s) in workspace %q to one key each\n", moved, len(pairs), ws)
+		}
+	}
+	return nil
+}
+
+// migrateDMPair moves the DMs between a and b into dmKey(a, b), in one
+// transaction, and returns how many there were.
+func migrateDMPair(ctx context.Context, ws workspace, a, b string) (int, error) {
+	sources := []string{ws.legacyDMKey(a, b)}
+	if a != b {
+		sources = append(sources, ws.legacyDMKey(b, a))
+	}
+
+	type entry struct {
+		member string
+		time   int64
+	}
+	var entries []entry
+	for _, src := range sources {
+		members, err := rdb.ZRange(ctx, src, 0, -1).Result()
+		if err != nil {
+			return 0, err
+		}
+		for _, member := range members {
+			// The stored message has its time; the time index may not, for
+			// entries migrateOrder hasn't renumbered yet.
+			msg, _ := decodeMessage(member)
+			entries = append(entries, entry{member, msg.Time})
+		}
+	}
+	// Per direction the entries are already in order; a stable sort keeps
+	// that for messages of the same second.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time < entries[j].time })
+
+	key := ws.dmKey(a, b)
+	var first int64
+	if len(entries) > 0 {
+		last, err := rdb.HIncrBy(ctx, ws.seqKey(), key, int64(len(entries))).Result()
+		if err != nil {
+			return 0, err
+		}
+		first = last - int64(len(entries)) + 1
+	}
+	pipe := rdb.TxPipeline()
+	for i, e := range entries {
+		addOrdered(ctx, pipe, ws, key, first+int64(i), e.time, e.member)
+	}
+	for _, src := range sources {
+		pipe.Del(ctx, src, ws.timeIndexKey(src))
+		pipe.HDel(ctx, ws.seqKey(), src)
+		pipe.SRem(ctx, ws.conversationsKey(), src)
+	}
+	if len(entries) > 0 {
+		pipe.SAdd(ctx, ws.conversationsKey(), key)
+	}
+	_, err := pipe.Exec(ctx)
+	return len(entries), err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/username"
+)
+
+// loadFixture points rdb at a fresh in-memory store holding the snapshot
+// testdata/name.
+func loadFixture(t *testing.T, name string) {
+	t.Helper()
+	initMemory()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := readSnapshot(context.Background(), f); err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+}
+
+// TestMigrations runs every migration on data at schema version 0 (DMs in
+// a key per direction, names differing only in case), checks the result,
+// and then runs them again, as after a migration cut short, to check that
+// they are idempotent.
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	loadFixture(t, "schema-v0.jsonl")
+	if err := checkSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		t.Helper()
+		if v, _ := dataSchemaVersion(ctx); v != schemaVersion() {
+			t.Errorf("the data is at schema version %d, want %d", v, schemaVersion())
+		}
+		acme := workspace("acme")
+		for key, want := range map[string][]string{
+			defaultWorkspace.dmKey("alice", "bob"):   {"one", "two", "three"},
+			defaultWorkspace.dmKey("carol", "carol"): {"note to self"},
+			acme.dmKey("dave", "erin"):               {"hi dave"},
+		} {
+			raw, _ := rdb.ZRange(ctx, key, 0, -1).Result()
+			var texts []string
+			for _, msg := range decodeHistory(raw) {
+				texts = append(texts, msg.Text)
+			}
+			if !reflect.DeepEqual(texts, want) {
+				t.Errorf("%s holds %q, want %q", key, texts, want)
+			}
+		}
+		for _, key := range []string{defaultWorkspace.legacyDMKey("alice", "bob"), defaultWorkspace.legacyDMKey("bob", "alice"), acme.legacyDMKey("erin", "dave"), schemaLockKey()} {
+			if n, _ := rdb.Exists(ctx, key).Result(); n != 0 {
+				t.Errorf("%s is still there", key)
+			}
+		}
+		for _, tc := range []struct {
+			ws         workspace
+			name, want string
+		}{
+			{defaultWorkspace, "ALICE", "alice"},
+			{defaultWorkspace, "Alice", "Alice"},
+			{defaultWorkspace, "Bob", "bob"},
+			{acme, "dave", "Dave"},
+			{acme, "ALICE", "ALICE"},
+		} {
+			if got := resolveName(ctx, tc.ws, tc.name); got != tc.want {
+				t.Errorf("%q resolves to %q in workspace %q, want %q", tc.name, got, tc.ws, tc.want)
+			}
+		}
+		if registered, _ := rdb.HGet(ctx, defaultWorkspace.namesKey(), username.Canonical("Alice")).Result(); registered != "alice" {
+			t.Errorf("alice is registered as %q, want the most recently active spelling", registered)
+		}
+	}
+	check()
+	if err := runMigrations(ctx, 0); err != nil {
+		t.Fatalf("running the migrations again: %v", err)
+	}
+	check()
+}
+
+// TestSchemaLockTaken checks that an instance whose migration outlasted
+// the lock leaves the lock to the instance that took it over.
+func TestSchemaLockTaken(t *testing.T) {
+	ctx := context.Background()
+	loadFixture(t, "schema-v0.jsonl")
+	defer func(m []migration) { migrations = m }(migrations)
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{schemaVersion() + 1, "outlasting the lock", func(ctx context.Context) error {
+		return rdb.Set(ctx, schemaLockKey(), "another-instance", 0).Err()
+	}})
+	if err := checkSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _ := rdb.Get(ctx, schemaLockKey()).Result(); holder != "another-instance" {
+		t.Errorf("the lock is held by %q, want the instance that took it over", holder)
+	}
+}
+
+// TestOrderAfterMigration checks that migrateOrder renumbers history
+// written by the old version, scored by time, in its old order, that
+// range-by-time queries then find messages by their time, including one
+// from a clock behind the others, and that running it again changes
+// nothing.
+func TestOrderAfterMigration(t *testing.T) {
+	ctx := context.Background()
+	loadFixture(t, "schema-v0.jsonl")
+	general := defaultWorkspace.roomMessagesKey("general")
+	// As the old version stored them, two of them in the same second.
+	for _, msg := range []ChatMessage{
+		{ID: "01HFV0000000000000000000R1", User: "alice", Text: "first", Time: 1700000010},
+		{ID: "01HFV0000000000000000000R2", User: "bob", Text: "same second", Time: 1700000010},
+		{ID: "01HFV0000000000000000000R3", User: "alice", Text: "later", Time: 1700000020},
+	} {
+		raw, _ := json.Marshal(msg)
+		rdb.ZAdd(ctx, general, redis.Z{Score: float64(msg.Time), Member: string(raw)})
+	}
+	if err := checkSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateOrder(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// An instance whose clock is 5s behind sends after the migration.
+	behind := ChatMessage{ID: "01HFV0000000000000000000R4", User: "bob", Text: "behind", Time: 1700000015}
+	raw, _ := json.Marshal(behind)
+	if !storeMessage(ctx, defaultWorkspace, general, behind, raw) {
+		t.Fatal("storing a message failed")
+	}
+
+	texts := func(raws []string, err error) []string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var texts []string
+		for _, msg := range decodeHistory(raws) {
+			texts = append(texts, msg.Text)
+		}
+		return texts
+	}
+	scores := func(key string) []redis.Z {
+		z, _ := rdb.ZRangeWithScores(ctx, key, 0, -1).Result()
+		return z
+	}
+	if got, want := texts(rdb.ZRange(ctx, general, 0, -1).Result()), []string{"first", "same second", "later", "behind"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("general holds %q, want %q", got, want)
+	}
+	for i, z := range scores(general) {
+		if z.Score != float64(i+1) {
+			t.Errorf("entry %d is numbered %v", i, z.Score)
+		}
+	}
+
+	dm := defaultWorkspace.dmKey("alice", "bob")
+	for _, tc := range []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"one second", texts(messagesBetween(ctx, defaultWorkspace, general, "1700000010", "1700000010", 0)), []string{"first", "same second"}},
+		{"after a time", texts(messagesBetween(ctx, defaultWorkspace, general, "(1700000010", "+inf", 0)), []string{"later", "behind"}},
+		{"the clock behind", texts(messagesBetween(ctx, defaultWorkspace, general, "1700000011", "1700000016", 0)), []string{"behind"}},
+		{"oldest by time", texts(messagesBetween(ctx, defaultWorkspace, general, "-inf", "+inf", 3)), []string{"first", "same second", "behind"}},
+		{"newest by time", texts(latestBetween(ctx, defaultWorkspace, general, "-inf", "+inf", 2)), []string{"later", "behind"}},
+		{"migrated DMs", texts(messagesBetween(ctx, defaultWorkspace, dm, "1700000001", "1700000002", 0)), []string{"two", "three"}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, tc.got, tc.want)
+		}
+	}
+	if n, _ := countBetween(ctx, defaultWorkspace, general, "(1700000010", "+inf"); n != 2 {
+		t.Errorf("%d messages after 1700000010, want 2", n)
+	}
+
+	before, index := scores(general), scores(defaultWorkspace.timeIndexKey(general))
+	if err := migrateOrder(ctx); err != nil {
+		t.Fatalf("migrating again: %v", err)
+	}
+	if !reflect.DeepEqual(scores(general), before) || !reflect.DeepEqual(scores(defaultWorkspace.timeIndexKey(general)), index) {
+		t.Error("migrating again renumbered messages")
+	}
+
+	if n, err := dropBefore(ctx, defaultWorkspace, general, 1700000011); err != nil || n != 2 {
+		t.Errorf("dropBefore dropped %d (%v), want 2", n, err)
+	}
+	if got, want := texts(rdb.ZRange(ctx, general, 0, -1).Result()), []string{"later", "behind"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after dropping, general holds %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Sessions. A client can say what device it runs on, when it connects
+// (/ws?device=iPhone&kind=mobile) or later:
+//
+//	{"type":"hello","device":"iPhone","kind":"mobile"}
+//
+// Once joined, each connection is listed in chat:user:<name>:sessions
+// (connection id -> JSON protocol.Session), so {"type":"sessions"} shows
+// the user all their connections, on every instance, and
+// {"type":"session_kill","id":"..."} closes one of them. Kills travel on
+// the user's control channel, chat:control:<name>, which every connection
+// of the user subscribes to; so do room membership changes (see
+// fanout.go). Entries are removed when their connection closes; those of a
+// crashed instance are dropped the next time the list is read.
+const (
+	maxDeviceLabel       = 64
+	sessionTouchInterval = 30 * time.Second
+)
+
+var deviceKinds = map[string]bool{"mobile": true, "tablet": true, "desktop": true, "web": true, "bot": true, "other": true}
+
+type sessionControl struct {
+	Op      string `json:"op"` // "kill", "room_join" or "room_leave"
+	Session string `json:"session,omitempty"`
+	By      string `json:"by,omitempty"`
+	Room    string `json:"room,omitempty"`
+}
+
+// validDevice reports whether device and kind may label a connection.
+func validDevice(device, kind string) bool {
+	return utf8.ValidString(device) && utf8.RuneCountInString(device) <= maxDeviceLabel &&
+		strings.TrimSpace(device) == device && (kind == "" || deviceKinds[kind])
+}
+
+// deviceFromQuery reads ?device= and ?kind=, ignoring invalid ones.
+func deviceFromQuery(r *http.Request) (string, string) {
+	q := r.URL.Query()
+	device, kind := q.Get("device"), q.Get("kind")
+	if !validDevice(device, kind) {
+		return "", ""
+	}
+	return device, kind
+}
+
+// {"type":"hello","device":"iPhone","kind":"mobile"}
+func handleHello(c *client, data []byte) {
+	var req protocol.HelloRequest
+	if err := json.Unmarshal(data, &req); err != nil || !validDevice(req.Device, req.Kind) {
+		sendError(c, "bad_frame", "device must be at most 64 characters and kind one of mobile, tablet, desktop, web, bot or other")
+		return
+	}
+	c.mu.Lock()
+	c.device, c.deviceKind = req.Device, req.Kind
+	if req.Lang != "" {
+		c.lang = matchLang(req.Lang)
+	}
+	lang := c.lang
+	c.mu.Unlock()
+	if c.userName() != "" {
+		saveSession(c)
+	}
+	c.writeJSON(protocol.NewHello(c.id, lang))
+}
+
+func (c *client) session() protocol.Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return protocol.Session{
+		ID:         c.id,
+		Device:     c.device,
+		Kind:       c.deviceKind,
+		IP:         c.ip,
+		Instance:   instanceID,
+		Connected:  c.connected.UnixMilli(),
+		LastActive: c.lastActive.Load(),
+	}
+}
+
+// saveSession writes c's entry in its user's sessions.
+func saveSession(c *client) {
+	raw, _ := json.Marshal(c.session())
+	rdb.HSet(c.ctx, c.ws.sessionsKey(c.userName()), c.id, raw)
+}
+
+// dropSession removes c's entry; the connection's context is gone by then.
+func dropSession(c *client, name string) {
+	rdb.HDel(serverCtx, c.ws.sessionsKey(name), c.id)
+}
+
+// touchSession records that c was just active, writing it out at most
+// every sessionTouchInterval.
+func touchSession(c *client) {
+	now := time.Now()
+	last := c.lastActive.Load()
+	if now.Sub(time.UnixMilli(last)) < sessionTouchInterval || !c.lastActive.CompareAndSwap(last, now.UnixMilli()) {
+		return
+	}
+	if c.userName() != "" {
+		saveSession(c)
+	}
+}
+
+// listenSessionControl acts on the commands sent to name's connections:
+// closing c if it is the session killed, and following the user's rooms.
+func listenSessionControl(c *client, name string) {
+	sub := subscribeUntil(c.ctx, c.ws.sessionControlChannel(name))
+	go func() {
+		for msg := range sub.Messages() {
+			payload, ok := openPayload(msg)
+			if !ok {
+				continue
+			}
+			var ctl sessionControl
+			if json.Unmarshal(payload, &ctl) != nil {
+				continue
+			}
+			switch ctl.Op {
+			case "room_join":
+				enterRoom(c, ctl.Room)
+			case "room_leave":
+				leaveRoom(c, ctl.Room)
+			case "kill":
+				if ctl.Session == c.id {
+					c.writeJSON(protocol.NewSessionKilled(ctl.By))
+					closeClient(c, websocket.CloseNormalClosure, closeReason(protocol.CloseSessionClosed, "session closed"))
+					return
+				}
+			}
+		}
+	}()
+}
+
+// userSessions lists name's sessions on live instances, dropping the
+// others.
+func userSessions(ctx context.Context, ws workspace, name string) []protocol.Session {
+	all, _ := rdb.HGetAll(ctx, ws.sessionsKey(name)).Result()
+	live, _ := rdb.ZRangeByScore(ctx, instancesKey(), &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-instanceTTL).Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	isLive := map[string]bool{}
+	for _, id := range live {
+		isLive[id] = true
+	}
+
+	sessions := []protocol.Session{}
+	for id, raw := range all {
+		var s protocol.Session
+		if json.Unmarshal([]byte(raw), &s) != nil || !isLive[s.Instance] {
+			rdb.HDel(ctx, ws.sessionsKey(name), id)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// {"type":"sessions"}
+func handleSessions(c *client, data []byte) {
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	sessions := userSessions(c.ctx, c.ws, name)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == c.id
+	}
+	c.writeJSON(protocol.NewSessions(sessions))
+}
+
+// {"type":"session_kill","id":"..."}
+func handleSessionKill(c *client, data []byte) {
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	var req protocol.SessionKillRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.ID == "" {
+		sendError(c, "bad_frame", "invalid session_kill frame")
+		return
+	}
+	if req.ID == c.id {
+		sendError(c, "bad_request", "this is the current session; close the connection instead")
+		return
+	}
+	found := false
+	for _, s := range userSessions(c.ctx, c.ws, name) {
+		found = found || s.ID == req.ID
+	}
+	if !found {
+		sendError(c, "not_found", "no such session")
+		return
+	}
+	raw, _ := json.Marshal(sessionControl{Op: "kill", Session: req.ID, By: c.id})
+	publish(c.ws.sessionControlChannel(name), raw)
+	c.writeJSON(protocol.NewSessionKill(req.ID))
+}
+
+// {"type":"leave"} signs the connection out. closeClient does what any
+// close does, and all of it at once: the user leaves the member list
+// (member_remove), their session entry and the name their address holds
+// are dropped. Unlike a dropped connection, the client is told with a
+// leave frame first and gets a left close, so it knows not to reconnect.
+func handleLeave(c *client, data []byte) {
+	name := c.userName()
+	c.writeJSON(protocol.NewLeave(name))
+	if name != "" {
+		log.Printf("👋 %s signed out", name)
+	}
+	closeClient(c, websocket.CloseNormalClosure, closeReason(protocol.CloseLeft, "signed out"))
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Conversation list. A client draws its sidebar from
+//
+//	{"type":"conversations","limit":50}
+//
+// which returns the conversations the user takes part in (global, their
+// rooms, group DMs and DMs), most recent first, each with its last message,
+// unread count, snooze and, for DMs, whether the peer is online. Rooms and
+// groups are few and all considered; DM peers can be many, so they come
+// from chat:user:<name>:dms (peer -> time of the last message either way),
+// a page at a time. The whole page costs three pipelined round trips
+// (plus the snoozes), however many conversations it holds.
+//
+// A reply has a next cursor while there is more; sending it back as
+// "cursor" returns the next page.
+const (
+	defaultConversationPage = 50
+	maxConversationPage     = 200
+	snippetRunes            = 100
+)
+
+// conversationSummary is a protocol.Conversation with the time it sorts by.
+type conversationSummary struct {
+	Conversation string
+	Last         *protocol.LastActivity
+	Unread       int64
+	MutedUntil   int64 // unix ms; see snooze.go
+	Online       *bool
+
+	time int64
+}
+
+// conversationCursor is where a page ended: conversations sort by time,
+// newest first, then by name.
+type conversationCursor struct {
+	time         int64
+	conversation string
+}
+
+func (cur conversationCursor) String() string {
+	return strconv.FormatInt(cur.time, 10) + ":" + cur.conversation
+}
+
+func parseConversationCursor(s string) (conversationCursor, bool) {
+	t, conversation, ok := strings.Cut(s, ":")
+	n, err := strconv.ParseInt(t, 10, 64)
+	if !ok || err != nil || conversation == "" {
+		return conversationCursor{}, false
+	}
+	return conversationCursor{n, conversation}, true
+}
+
+// after reports whether s sorts after the cursor, i.e. belongs to a later
+// page.
+func (cur *conversationCursor) after(s conversationSummary) bool {
+	if cur == nil {
+		return true
+	}
+	return s.time < cur.time || (s.time == cur.time && s.Conversation > cur.conversation)
+}
+
+// {"type":"conversations","limit":50,"cursor":"..."}
+func handleConversations(c *client, data []byte) {
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+
+	var req protocol.ConversationsRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Limit < 0 {
+		sendError(c, "bad_frame", "invalid conversations frame")
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultConversationPage
+	}
+	req.Limit = min(req.Limit, maxConversationPage)
+	var cursor *conversationCursor
+	if req.Cursor != "" {
+		cur, ok := parseConversationCursor(req.Cursor)
+		if !ok {
+			sendError(c, "bad_frame", "invalid cursor")
+			return
+		}
+		cursor = &cur
+	}
+
+	page, next, err := conversationPage(c.ctx, c.ws, name, cursor, req.Limit)
+	if err != nil {
+		log.Println("❌ Conversation list error:", err)
+		sendError(c, "internal", "could not list conversations")
+		return
+	}
+	conversations := make([]protocol.Conversation, len(page))
+	for i, s := range page {
+		conversations[i] = protocol.Conversation{Conversation: s.Conversation, Last: s.Last, Unread: s.Unread, MutedUntil: s.MutedUntil, Online: s.Online}
+	}
+	frame := protocol.NewConversations(conversations)
+	if next != nil {
+		frame.Next = next.String()
+	}
+	c.writeJSON(frame)
+}
+
+// conversationPage returns up to limit of name's conversations after
+// cursor, and the cursor of the next page if there may be one.
+func conversationPage(ctx context.Context, ws workspace, name string, cursor *conversationCursor, limit int) ([]conversationSummary, *conversationCursor, error) {
+	// Round one: what name takes part in.
+	maxTime := "+inf"
+	if cursor != nil {
+		maxTime = strconv.FormatInt(cursor.time, 10)
+	}
+	pipe := rdb.Pipeline()
+	roomsCmd := pipe.SMembers(ctx, ws.userRoomsKey(name))
+	groupsCmd := pipe.SMembers(ctx, ws.userGroupsKey(name))
+	dmsCmd := pipe.ZRevRangeByScoreWithScores(ctx, ws.userDMsKey(name), &redis.ZRangeBy{Min: "-inf", Max: maxTime, Count: int64(limit) + 1})
+	positionsCmd := pipe.HGetAll(ctx, ws.readPosKey(name))
+	clearedCmd := pipe.HGetAll(ctx, ws.dmClearedKey(name))
+	joinedCmd := pipe.HGetAll(ctx, ws.roomSinceKey(name))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+
+	// Round two: when global, the rooms and the groups last had a message.
+	// DMs already have their time.
+	var candidates []conversationSummary
+	var lasts []*redis.StringSliceCmd
+	pipe = rdb.Pipeline()
+	addShared := func(conversation, key string) {
+		candidates = append(candidates, conversationSummary{Conversation: conversation})
+		lasts = append(lasts, pipe.ZRange(ctx, key, -1, -1))
+	}
+	addShared("global", ws.messagesKey())
+	for _, room := range roomsCmd.Val() {
+		addShared("room:"+room, ws.roomMessagesKey(room))
+	}
+	for _, id := range groupsCmd.Val() {
+		addShared("group:"+id, ws.groupMessagesKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+	// A since_join room's messages before the join don't count.
+	joined := parseWatermarks(joinedCmd.Val())
+	for i, cmd := range lasts {
+		room, _ := strings.CutPrefix(candidates[i].Conversation, "room:")
+		if last := lastMessageOf(cmd); last != nil && (joined[room] == 0 || last.Time >= joined[room]) {
+			candidates[i].Last, candidates[i].time = last, last.Time
+		}
+	}
+	for _, z := range dmsCmd.Val() {
+		peer, _ := z.Member.(string)
+		candidates = append(candidates, conversationSummary{Conversation: "dm:" + peer, time: int64(z.Score)})
+	}
+
+	page := candidates[:0]
+	for _, s := range candidates {
+		if cursor.after(s) {
+			page = append(page, s)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool {
+		if page[i].time != page[j].time {
+			return page[i].time > page[j].time
+		}
+		return page[i].Conversation < page[j].Conversation
+	})
+	var next *conversationCursor
+	// A full DM batch means there may be DMs beyond it.
+	if len(page) > limit || len(dmsCmd.Val()) > limit {
+		page = page[:min(limit, len(page))]
+		if len(page) > 0 {
+			last := page[len(page)-1]
+			next = &conversationCursor{last.time, last.Conversation}
+		}
+	}
+
+	// Round three: unread counts, and the DMs' last messages and peers.
+	snoozes := userSnoozes(ctx, ws, name)
+	positions := positionsCmd.Val()
+	cleared := parseWatermarks(clearedCmd.Val())
+	unread := make([]*redis.IntCmd, len(page))
+	dmLasts := make([]*redis.StringSliceCmd, len(page))
+	online := make([]*redis.BoolCmd, len(page))
+	pipe = rdb.Pipeline()
+	for i, s := range page {
+		var pos protocol.ReadPosition
+		if raw, ok := positions[s.Conversation]; ok {
+			json.Unmarshal([]byte(raw), &pos)
+		}
+		peer, isDM := strings.CutPrefix(s.Conversation, "dm:")
+		if isDM {
+			pos.Time = max(pos.Time, cleared[peer])
+		}
+		if room, ok := strings.CutPrefix(s.Conversation, "room:"); ok && joined[room] > 0 {
+			pos.Time = max(pos.Time, joined[room]-1)
+		}
+		if key, ok := conversationHistoryKey(ws, name, s.Conversation); ok {
+			unread[i] = pipe.ZCount(ctx, ws.timeIndexKey(key), "("+strconv.FormatInt(pos.Time, 10), "+inf")
+		}
+		if isDM {
+			dmLasts[i] = pipe.ZRange(ctx, ws.dmKey(name, peer), -1, -1)
+			online[i] = pipe.SIsMember(ctx, ws.membersKey(), peer)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+	for i := range page {
+		s := &page[i]
+		if unread[i] != nil {
+			s.Unread = unread[i].Val()
+		}
+		if online[i] != nil {
+			isOnline := online[i].Val()
+			s.Online = &isOnline
+			s.Last = lastMessageOf(dmLasts[i])
+		}
+		s.MutedUntil = max(snoozes["all"], snoozes[s.Conversation])
+	}
+	return page, next, nil
+}
+
+// lastMessageOf summarizes the message cmd (a ZRANGE -1 -1) returned.
+func lastMessageOf(cmd *redis.StringSliceCmd) *protocol.LastActivity {
+	entries := cmd.Val()
+	if len(entries) == 0 {
+		return nil
+	}
+	msg, ok := decodeMessage(entries[0])
+	if !ok {
+		return nil
+	}
+	return &protocol.LastActivity{ID: msg.ID, User: msg.User, Snippet: snippet(msg.Text), Kind: msg.Kind, Time: msg.Time}
+}
+
+func snippet(text string) string {
+	if utf8.RuneCountInString(text) <= snippetRunes {
+		return text
+	}
+	return string([]rune(text)[:snippetRunes]) + "…"
+}
+
+// indexDM records on pipe that sender and peer last talked at t.
+func indexDM(ctx context.Context, pipe redis.Pipeliner, ws workspace, sender, peer string, t int64) {
+	pipe.ZAddGT(ctx, ws.userDMsKey(sender), redis.Z{Score: float64(t), Member: peer})
+	pipe.ZAddGT(ctx, ws.userDMsKey(peer), redis.Z{Score: float64(t), Member: sender})
+}
+
+// backfillDMIndex builds the DM index from DMs stored before it existed,
+// once per workspace. Running it twice, or on several instances at once,
+// is harmless.
+func backfillDMIndex(ctx context.Context, ws workspace) error {
+	keys, err := conversationKeys(ctx, ws)
+	if err != nil {
+		return err
+	}
+	found := 0
+	for _, key := range keys {
+		if !ws.isDMKey(key) {
+			continue
+		}
+		last, err := rdb.ZRange(ctx, key, -1, -1).Result()
+		if err != nil {
+			return err
+		}
+		if len(last) == 0 {
+			continue
+		}
+		msg, ok := decodeMessage(last[0])
+		peer, isDM := ws.dmPeer(key, msg.User)
+		if !ok || !isDM {
+			continue
+		}
+		pipe := rdb.Pipeline()
+		indexDM(ctx, pipe, ws, msg.User, peer, msg.Time)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		found++
+	}
+	if err := rdb.Set(ctx, ws.dmIndexBackfilledKey(), "1", 0).Err(); err != nil {
+		return err
+	}
+	if found > 0 && ws == defaultWorkspace {
+		fmt.Printf("🗂 Indexed %d existing DM conversation(s)\n", found)
+	} else if found > 0 {
+		fmt.Printf("🗂 Indexed %d existing DM conversation(s) in workspace %q\n", found, ws)
+	}
+	return nil
+}
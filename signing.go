@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"websocket-chatapp/framesig"
+	"websocket-chatapp/ids"
+	"websocket-chatapp/protocol"
+)
+
+// Signed connections. A client that connects with ?sign=1 gets every frame
+// wrapped in an HMAC-signed envelope (see package framesig) keyed by
+// CHAT_FRAME_KEY, which clients get out of band, and must wrap its own
+// frames the same way. The first frame announces the connection ID that
+// goes into every MAC:
+//
+//	{"seq":1,"frame":{"type":"signing","conn":"<id>","alg":"HMAC-SHA256"},"mac":"..."}
+//
+// Inbound frames that aren't validly signed, or replay an old sequence
+// number, are dropped and counted (signatureDropped in /api/stats).
+var (
+	frameKey         []byte
+	signatureDropped atomic.Int64
+)
+
+// initFrameSigning loads CHAT_FRAME_KEY: base64 of at least 32 bytes.
+func initFrameSigning() error {
+	raw := cfg().FrameKey
+	if raw == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) < 32 {
+		return fmt.Errorf("CHAT_FRAME_KEY: want base64 of at least 32 bytes")
+	}
+	frameKey = key
+	fmt.Println("🔏 Signed connections available (?sign=1)")
+	return nil
+}
+
+func signingRequested(r *http.Request) bool {
+	v := r.URL.Query().Get("sign")
+	return v == "1" || v == "true"
+}
+
+// startSigning switches c to signed frames and announces its connection ID.
+func startSigning(c *client) error {
+	id := ids.New()
+	c.signer = framesig.NewSigner(frameKey, id, true)
+	return writeNow(c, protocol.NewSigning(id)) // ahead of init
+}
+
+// openSigned unwraps an inbound frame on a signed connection, or reports
+// false if it has to be dropped.
+func openSigned(c *client, msg []byte) ([]byte, bool) {
+	frame, err := c.signer.Open(msg)
+	if err != nil {
+		if signatureDropped.Add(1)%100 == 1 {
+			log.Printf("⚠️ Dropping inbound frame on a signed connection: %v (%d dropped so far)", err, signatureDropped.Load())
+		}
+		return nil, false
+	}
+	return frame, true
+}
@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSlowClientEvicted has a client stop reading while alice posts big
+// messages. Once the socket buffers are full, a write to it must time out
+// after CHAT_WRITE_TIMEOUT and the server must disconnect it, while alice
+// keeps getting her messages.
+func TestSlowClientEvicted(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_WRITE_TIMEOUT=200ms")
+	slow := rawJoin(t, addr, "slowpoke")
+	t.Cleanup(func() { slow.Close() })
+	// A small receive buffer fills after fewer messages.
+	slow.NetConn().(*net.TCPConn).SetReadBuffer(4096)
+
+	alice := dial(t, addr, "", "alice")
+	texts := make(chan string, 1000)
+	go func() {
+		for {
+			f, err := alice.Read()
+			if err != nil {
+				return
+			}
+			if f.Type == "message" {
+				texts <- f.Message.Text
+			}
+		}
+	}()
+
+	big := strings.Repeat("x", 100*1024)
+	var s struct {
+		SlowEvictions int64 `json:"slowEvictions"`
+	}
+	for i := 0; ; i++ {
+		if i == 200 {
+			t.Fatal("slowpoke wasn't evicted after 20MB of messages")
+		}
+		alice.Send("alice", big)
+		select {
+		case <-texts:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("alice's message %d didn't come back: the server is stuck writing to slowpoke", i)
+		}
+		if stats(t, addr, &s); s.SlowEvictions > 0 {
+			break
+		}
+	}
+	awaitConnections(t, addr, "slowpoke", 0)
+
+	alice.Send("alice", "still here")
+	select {
+	case got := <-texts:
+		if got != "still here" {
+			t.Errorf("alice got %.20q, want her last message", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("alice's message didn't come back after slowpoke was evicted")
+	}
+}
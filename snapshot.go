@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A snapshot is a JSON-lines file: one header line, then one record per
+// chunk of a key. Large keys are split across several records so neither
+// side ever holds a whole zset in memory.
+//
+// Version history:
+//
+//	1: initial format
+const (
+	snapshotFormat    = "websocket-chatapp-snapshot"
+	snapshotVersion   = 1
+	snapshotChunkSize = 500
+)
+
+// Snapshots cover every key under cfg().KeyPrefix, in every workspace, except
+// presence and instance bookkeeping, which describe live connections, not
+// chat state, and would only produce ghosts in the restored database, the
+// analytics stream and the firehose, which are consumed elsewhere (a
+// recorder's position means nothing in another database), and the audit
+// log, which records admin reads on the database it was written to. The
+// patterns are in default-workspace form; see unscopedKey.
+func snapshotSkip() []string {
+	return []string{
+		defaultWorkspace.membersKey(),
+		defaultWorkspace.membersSinceKey(),
+		instancesKey(),
+		defaultWorkspace.key("instance", "*"),
+		eventsKey(),
+		defaultWorkspace.firehoseKey(),
+		auditKey(),
+	}
+}
+
+type snapshotHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Created int64  `json:"created"`
+}
+
+type snapshotRecord struct {
+	Key    string            `json:"key"`
+	Type   string            `json:"type"`
+	TTLms  int64             `json:"ttlMs,omitempty"`
+	String *string           `json:"string,omitempty"`
+	Hash   map[string]string `json:"hash,omitempty"`
+	Set    []string          `json:"set,omitempty"`
+	Zset   []snapshotZEntry  `json:"zset,omitempty"`
+}
+
+type snapshotZEntry struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// chatserver snapshot --out state.json
+func runSnapshot(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := fs.String("out", "", "file to write the snapshot to (default stdout)")
+	addr := fs.String("redis", redisAddr, "Redis address")
+	fs.Parse(args)
+
+	initRedis(*addr)
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := writeSnapshot(serverCtx, w)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Snapshot failed:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "📦 Wrote %d keys\n", n)
+}
+
+// chatserver restore --in state.json
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "snapshot file to load (default stdin)")
+	addr := fs.String("redis", redisAddr, "Redis address")
+	fs.Parse(args)
+
+	initRedis(*addr)
+
+	r := os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	n, err := readSnapshot(serverCtx, r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Restore failed:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "📦 Restored %d keys\n", n)
+}
+
+func skipSnapshotKey(key string) bool {
+	key = unscopedKey(key)
+	for _, pattern := range snapshotSkip() {
+		if p, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(key, p) {
+			return true
+		}
+		if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func writeSnapshot(ctx context.Context, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(snapshotHeader{Format: snapshotFormat, Version: snapshotVersion, Created: time.Now().Unix()}); err != nil {
+		return 0, err
+	}
+
+	keys := 0
+	iter := rdb.Scan(ctx, 0, redisKey("*"), 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if skipSnapshotKey(key) {
+			continue
+		}
+		if err := snapshotKey(ctx, enc, key); err != nil {
+			return keys, fmt.Errorf("%s: %w", key, err)
+		}
+		keys++
+	}
+	if err := iter.Err(); err != nil {
+		return keys, err
+	}
+	return keys, bw.Flush()
+}
+
+func snapshotKey(ctx context.Context, enc *json.Encoder, key string) error {
+	typ, err := rdb.Type(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	var ttl int64
+	if d, err := rdb.PTTL(ctx, key).Result(); err == nil && d > 0 {
+		ttl = d.Milliseconds()
+	}
+	rec := snapshotRecord{Key: key, Type: typ, TTLms: ttl}
+
+	switch typ {
+	case "none":
+		return nil // expired or deleted since SCAN saw it
+	case "string":
+		s, err := rdb.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec.String = &s
+		return enc.Encode(rec)
+	case "hash":
+		iter := rdb.HScan(ctx, key, 0, "", snapshotChunkSize).Iterator()
+		rec.Hash = map[string]string{}
+		for iter.Next(ctx) {
+			field := iter.Val()
+			if !iter.Next(ctx) {
+				break
+			}
+			rec.Hash[field] = iter.Val()
+			if len(rec.Hash) >= snapshotChunkSize {
+				if err := enc.Encode(rec); err != nil {
+					return err
+				}
+				rec.Hash = map[string]string{}
+			}
+		}
+		if len(rec.Hash) > 0 {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	case "set":
+		iter := rdb.SScan(ctx, key, 0, "", snapshotChunkSize).Iterator()
+		for iter.Next(ctx) {
+			rec.Set = append(rec.Set, iter.Val())
+			if len(rec.Set) >= snapshotChunkSize {
+				if err := enc.Encode(rec); err != nil {
+					return err
+				}
+				rec.Set = nil
+			}
+		}
+		if len(rec.Set) > 0 {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	case "zset":
+		for start := int64(0); ; start += snapshotChunkSize {
+			page, err := rdb.ZRangeWithScores(ctx, key, start, start+snapshotChunkSize-1).Result()
+			if err != nil {
+				return err
+			}
+			if len(page) == 0 {
+				return nil
+			}
+			rec.Zset = rec.Zset[:0]
+			for _, z := range page {
+				rec.Zset = append(rec.Zset, snapshotZEntry{Member: z.Member.(string), Score: z.Score})
+			}
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("unsupported key type %q", typ)
+}
+
+// readSnapshot loads a snapshot. Each key is deleted before its first record
+// is applied, so restoring the same file twice yields the same state.
+func readSnapshot(ctx context.Context, r io.Reader) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("reading header: %w", err)
+	}
+	if header.Format != snapshotFormat {
+		return 0, fmt.Errorf("not a chat snapshot (format %q)", header.Format)
+	}
+	if header.Version < 1 || header.Version > snapshotVersion {
+		return 0, fmt.Errorf("snapshot version %d is not supported by this binary (max %d)", header.Version, snapshotVersion)
+	}
+
+	seen := map[string]bool{}
+	for {
+		var rec snapshotRecord
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return len(seen), nil
+		}
+		if err != nil {
+			return len(seen), err
+		}
+		upgradeSnapshotRecord(header.Version, &rec)
+
+		pipe := rdb.Pipeline()
+		if !seen[rec.Key] {
+			seen[rec.Key] = true
+			pipe.Del(ctx, rec.Key)
+		}
+		switch rec.Type {
+		case "string":
+			if rec.String != nil {
+				pipe.Set(ctx, rec.Key, *rec.String, 0)
+			}
+		case "hash":
+			for f, v := range rec.Hash {
+				pipe.HSet(ctx, rec.Key, f, v)
+			}
+		case "set":
+			for _, m := range rec.Set {
+				pipe.SAdd(ctx, rec.Key, m)
+			}
+		case "zset":
+			for _, z := range rec.Zset {
+				pipe.ZAdd(ctx, rec.Key, redis.Z{Score: z.Score, Member: z.Member})
+			}
+		default:
+			return len(seen), fmt.Errorf("%s: unsupported key type %q", rec.Key, rec.Type)
+		}
+		if rec.TTLms > 0 {
+			pipe.PExpire(ctx, rec.Key, time.Duration(rec.TTLms)*time.Millisecond)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return len(seen), fmt.Errorf("%s: %w", rec.Key, err)
+		}
+	}
+}
+
+// upgradeSnapshotRecord rewrites records from older snapshot versions into
+// the current shape. Version 1 is current, so there is nothing to do yet.
+func upgradeSnapshotRecord(version int, rec *snapshotRecord) {}
@@ -0,0 +1,68 @@
+package main_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestSnapshotStreams checks that a snapshot of a store holding the
+// server's streams (the audit log, and a firehose in two workspaces)
+// succeeds, and leaves them out.
+func TestSnapshotStreams(t *testing.T) {
+	store := serveStore(t, 0)
+	addr := startServer(t, store, withAdmin, "CHAT_ADMIN_READ_DMS=true", "CHAT_FIREHOSE=true", "CHAT_FIREHOSE_DMS=true")
+	alice := dial(t, addr, "", "alice")
+	dial(t, addr, "", "bob")
+	if code, data := api(t, addr, http.MethodPost, "/api/workspaces", []byte(`{"id":"acme"}`)); code != http.StatusOK {
+		t.Fatalf("creating a workspace got %d: %s", code, data)
+	}
+	carol := dial(t, addr, "/acme", "carol")
+	alice.SendDM("alice", "bob", "hi")
+	await(t, alice, "message")
+	carol.Send("carol", "hi")
+	await(t, carol, "message")
+	if code, data := api(t, addr, http.MethodGet, "/api/dm/bob/messages?user=alice", nil); code != http.StatusOK {
+		t.Fatalf("reading alice's DMs got %d: %s", code, data)
+	}
+
+	keys := snapshotKeys(t, store)
+	if !keys["chat:dms:alice:bob"] {
+		t.Errorf("the snapshot has no DMs: %v", keys)
+	}
+	for _, skipped := range []string{"chat:audit", "chat:firehose", "chat:ws:acme:firehose"} {
+		if keys[skipped] {
+			t.Errorf("the snapshot has %s", skipped)
+		}
+	}
+}
+
+// snapshotKeys takes a snapshot of the Redis at store and returns the keys
+// it has.
+func snapshotKeys(t *testing.T, store string) map[string]bool {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "state.json")
+	if data, err := exec.Command(serverBin, "snapshot", "--redis", store, "--out", out).CombinedOutput(); err != nil {
+		t.Fatalf("snapshot: %v\n%s", err, data)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	keys := map[string]bool{}
+	lines := bufio.NewScanner(f)
+	lines.Buffer(nil, 1<<20)
+	for lines.Scan() {
+		var rec struct {
+			Key string `json:"key"`
+		}
+		json.Unmarshal(lines.Bytes(), &rec)
+		keys[rec.Key] = true
+	}
+	return keys
+}
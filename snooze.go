@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Snoozes. During an incident a room can hit someone's watched keywords
+// hundreds of times; a snooze silences the notifications about a
+// conversation (keyword_hit frames, offline DM notifications) for a while,
+// without touching the messages themselves:
+//
+//	{"type":"snooze","scope":"room:alerts","duration":"1h"}
+//
+// The scope is a conversation (global, room:<name>, dm:<peer>,
+// group:<id>) or "all"; duration "0" lifts the snooze. Snoozes live in
+// chat:user:<name>:snoozes (scope -> until, unix ms), which expires with the
+// last of them. Notification paths read them through a per-instance cache,
+// dropped per user when they change anywhere (chat:snoozes channel) and
+// reloaded after snoozeCacheTTL in case a change was missed.
+const (
+	maxSnooze        = 7 * 24 * time.Hour
+	maxSnoozeScope   = 100
+	snoozeCacheTTL   = time.Minute
+	snoozeCacheLimit = 10000
+)
+
+type snoozeUser struct {
+	ws   workspace
+	name string
+}
+
+type snoozeEntry struct {
+	until  map[string]int64
+	loaded time.Time
+}
+
+var (
+	snoozeMu      sync.Mutex
+	snoozeCache   = map[snoozeUser]snoozeEntry{}
+	snoozeChanges int // so a load that raced a change isn't kept
+)
+
+// {"type":"snooze","scope":"room:alerts","duration":"1h"}; without a scope
+// it just reports your snoozes.
+func handleSnooze(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.SnoozeRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid snooze frame")
+		return
+	}
+	if req.Scope != "" {
+		if !validSnoozeScope(req.Scope) {
+			sendError(c, "bad_frame", "scope must be all, global, room:<name>, dm:<user> or group:<id>")
+			return
+		}
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d < 0 || d > maxSnooze {
+			sendError(c, "bad_frame", "duration must be between 0 and "+maxSnooze.String())
+			return
+		}
+		if err := setSnooze(ctx, ws, name, req.Scope, d); err != nil {
+			sendError(c, "internal", "could not save snooze")
+			return
+		}
+	}
+
+	c.writeJSON(protocol.NewSnooze(userSnoozes(ctx, ws, name)))
+}
+
+func validSnoozeScope(scope string) bool {
+	if scope == "all" || scope == "global" {
+		return true
+	}
+	kind, rest, ok := strings.Cut(scope, ":")
+	if !ok || rest == "" || len(scope) > maxSnoozeScope {
+		return false
+	}
+	return kind == "room" || kind == "dm" || kind == "group"
+}
+
+// setSnooze snoozes scope for d, or lifts its snooze if d is 0.
+func setSnooze(ctx context.Context, ws workspace, name, scope string, d time.Duration) error {
+	key := ws.snoozesKey(name)
+	if d == 0 {
+		rdb.HDel(ctx, key, scope)
+	} else if err := rdb.HSet(ctx, key, scope, time.Now().Add(d).UnixMilli()).Err(); err != nil {
+		return err
+	}
+	// The key lives as long as its longest snooze.
+	var last int64
+	for _, until := range userSnoozes(ctx, ws, name) {
+		last = max(last, until)
+	}
+	if last > 0 {
+		rdb.PExpire(ctx, key, time.Until(time.UnixMilli(last)))
+	}
+	publish(ws.snoozesChannel(), []byte(name))
+	return nil
+}
+
+// userSnoozes returns name's snoozes that haven't ended, removing the
+// others.
+func userSnoozes(ctx context.Context, ws workspace, name string) map[string]int64 {
+	all, _ := rdb.HGetAll(ctx, ws.snoozesKey(name)).Result()
+	now := serverNow()
+	snoozes := map[string]int64{}
+	for scope, raw := range all {
+		until, _ := strconv.ParseInt(raw, 10, 64)
+		if until <= now {
+			rdb.HDel(ctx, ws.snoozesKey(name), scope)
+			continue
+		}
+		snoozes[scope] = until
+	}
+	return snoozes
+}
+
+// snoozed reports whether name has notifications about conversation
+// snoozed. It reads the cache; Redis only when name isn't in it.
+func snoozed(ctx context.Context, ws workspace, name, conversation string) bool {
+	u := snoozeUser{ws, name}
+	snoozeMu.Lock()
+	entry, ok := snoozeCache[u]
+	gen := snoozeChanges
+	snoozeMu.Unlock()
+
+	if !ok || time.Since(entry.loaded) > snoozeCacheTTL {
+		all, err := rdb.HGetAll(ctx, ws.snoozesKey(name)).Result()
+		if err != nil {
+			return false // notify rather than lose notifications
+		}
+		entry = snoozeEntry{until: map[string]int64{}, loaded: time.Now()}
+		for scope, raw := range all {
+			entry.until[scope], _ = strconv.ParseInt(raw, 10, 64)
+		}
+		snoozeMu.Lock()
+		if snoozeChanges == gen {
+			if len(snoozeCache) >= snoozeCacheLimit {
+				clear(snoozeCache)
+			}
+			snoozeCache[u] = entry
+		}
+		snoozeMu.Unlock()
+	}
+
+	now := serverNow()
+	return entry.until["all"] > now || entry.until[conversation] > now
+}
+
+// listenSnoozeChanges drops a user's cached snoozes whenever they change.
+func listenSnoozeChanges(ws workspace, sub subscription) {
+	for msg := range sub.Messages() {
+		name, ok := openPayload(msg)
+		if !ok {
+			continue
+		}
+		snoozeMu.Lock()
+		delete(snoozeCache, snoozeUser{ws, string(name)})
+		snoozeChanges++
+		snoozeMu.Unlock()
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Spectators are read-only connections: they get init, history and live
+// messages but can't post. A connection opts in with ?spectator=1 on the
+// upgrade URL; there is no auth layer yet to assign it from a token scope.
+// Separately, rooms matching CHAT_READONLY_ROOMS only accept messages from
+// their owner.
+
+// spectatorFrames are the frames a spectator may send: joining, signing
+// out, keepalives and frames that only read. Anything else, frame types
+// added later included, is refused.
+var spectatorFrames = map[string]bool{
+	"join":             true,
+	"leave":            true,
+	"hello":            true,
+	"ping":             true,
+	"pong":             true,
+	"sessions":         true,
+	"whois":            true,
+	"my_stats":         true,
+	"members_page":     true,
+	"member_search":    true,
+	"user_exists":      true,
+	"room_info":        true,
+	"room_list":        true,
+	"group_dm_history": true,
+	"conversations":    true,
+	"dm_status":        true,
+	"get_key":          true,
+	"quota":            true,
+	"translate":        true,
+}
+
+func spectatorRequested(r *http.Request) bool {
+	v := r.URL.Query().Get("spectator")
+	return v == "1" || v == "true"
+}
+
+// rejectReadOnly sends a read_only error if c is a spectator and frames of
+// type typ aren't in spectatorFrames.
+func rejectReadOnly(c *client, typ string) bool {
+	if !c.readOnly || spectatorFrames[typ] {
+		return false
+	}
+	sendError(c, "read_only", "spectators can't send "+typ+" frames")
+	return true
+}
+
+// listed reports whether c appears in the member list.
+func (c *client) listed() bool {
+	return !c.readOnly || cfg().ListSpectators
+}
+
+// readOnlyRoom reports whether room matches one of CHAT_READONLY_ROOMS.
+func readOnlyRoom(room string) bool {
+	for _, pattern := range cfg().ReadOnlyRooms {
+		if ok, _ := path.Match(pattern, room); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// spectatorsIn returns the members of names that joined as spectators.
+func spectatorsIn(ctx context.Context, ws workspace, names []string) []string {
+	spectators := []string{}
+	if len(names) == 0 {
+		return spectators
+	}
+	pipe := rdb.Pipeline()
+	flags := make([]*redis.BoolCmd, len(names))
+	for i, n := range names {
+		flags[i] = pipe.SIsMember(ctx, ws.spectatorsKey(), n)
+	}
+	pipe.Exec(ctx)
+	for i, f := range flags {
+		if f.Val() {
+			spectators = append(spectators, names[i])
+		}
+	}
+	return spectators
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,38 @@
+package main_test
+
+import (
+	"testing"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestSpectatorReadOnly checks that a spectator can send frames that read,
+// and nothing else: not joining or changing rooms, not settings, not frame
+// types it doesn't know.
+func TestSpectatorReadOnly(t *testing.T) {
+	addr := startServer(t, "")
+	alice := dial(t, addr, "", "alice")
+	alice.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "general"})
+	await(t, alice, protocol.TypeRoomJoined)
+	topic, keywords := "hijacked", []string{"go"}
+	spectator := dial(t, addr, "?spectator=1", "sam")
+	for _, frame := range []interface{}{
+		protocol.AutoReplyRequest{Type: protocol.TypeAutoReply, Text: "away"},
+		protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "lobby"},
+		protocol.RoomUpdateRequest{Type: protocol.TypeRoomUpdate, Room: "general", Topic: &topic},
+		protocol.SendRequest{Type: protocol.TypeMsg, Text: "hi"},
+		protocol.WatchRequest{Type: protocol.TypeWatch, Keywords: &keywords},
+		map[string]string{"type": "no_such_frame"},
+	} {
+		spectator.SendFrame(frame)
+		refused(t, spectator, "read_only")
+	}
+	spectator.SendFrame(protocol.RoomListRequest{Type: protocol.TypeRoomList})
+	await(t, spectator, protocol.TypeRoomList)
+	spectator.SendFrame(protocol.RoomInfoRequest{Type: protocol.TypeRoomInfo, Room: "general"})
+	var info protocol.RoomInfo
+	decode(t, await(t, spectator, protocol.TypeRoom), &info)
+	if info.Room.Owner != "alice" || info.Room.Topic != "" {
+		t.Errorf("a spectator changed the room: %+v", info.Room)
+	}
+}
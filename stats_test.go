@@ -0,0 +1,26 @@
+package main_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestStatsAdmin checks that GET /api/stats, which names every connected
+// user, takes the admin token.
+func TestStatsAdmin(t *testing.T) {
+	addr := startServer(t, "", withAdmin)
+	dial(t, addr, "", "alice")
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{adminToken + "x", http.StatusUnauthorized},
+		{adminToken, http.StatusOK},
+	} {
+		if code, data := apiAs(t, addr, tc.token, http.MethodGet, "/api/stats", nil); code != tc.want {
+			t.Errorf("with token %q, /api/stats got %d, want %d: %s", tc.token, code, tc.want, data)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-chatapp/protocol"
+)
+
+// TestSubprotocols dials with no Sec-WebSocket-Protocol, with protocols
+// that include one the server speaks, and with only protocols it doesn't.
+// The first two must be upgraded with the default or the mutual protocol,
+// told in init; the last must be closed with 1002 and a reason listing
+// what the server supports.
+func TestSubprotocols(t *testing.T) {
+	addr := startServer(t, "")
+	for _, tc := range []struct {
+		name    string
+		offered []string
+		want    string // the protocol the server answers with, "" for none
+		refused bool
+	}{
+		{"no header", nil, "", false},
+		{"matching", []string{"chat.v1.json"}, "chat.v1.json", false},
+		{"matching among others", []string{"chat.v9.msgpack", "chat.v1.json"}, "chat.v1.json", false},
+		{"unsupported only", []string{"chat.v9.msgpack", "chat.v0.xml"}, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tc.offered, HandshakeTimeout: 5 * time.Second}
+			conn, resp, err := dialer.Dial("ws://"+addr+"/ws", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != tc.want {
+				t.Errorf("the server answered with protocol %q, want %q", got, tc.want)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			if tc.refused {
+				_, _, err := conn.ReadMessage()
+				var ce *websocket.CloseError
+				if !errors.As(err, &ce) || ce.Code != websocket.CloseProtocolError {
+					t.Fatalf("got %v, want a 1002 close", err)
+				}
+				reason, ok := protocol.DecodeCloseReason(ce.Text)
+				if !ok || reason.Kind != protocol.CloseUnsupportedProtocol || !strings.Contains(reason.Reason, "chat.v1.json") {
+					t.Errorf("closed with %q, want kind %s and the supported protocols", ce.Text, protocol.CloseUnsupportedProtocol)
+				}
+				return
+			}
+
+			conn.WriteMessage(websocket.TextMessage, []byte("join:alice-"+strings.ReplaceAll(tc.name, " ", "-")))
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatalf("no init: %v", err)
+				}
+				var init protocol.Init
+				if json.Unmarshal(data, &init); init.Type != protocol.TypeInit {
+					continue
+				}
+				if init.Protocol != "chat.v1.json" || init.Version != 1 {
+					t.Errorf("init says protocol %q version %d, want chat.v1.json version 1", init.Protocol, init.Version)
+				}
+				return
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Package supervisor keeps long-running listener loops alive. A listener
+// that panics is recovered and run again; one that returns, because the
+// source it read ended, is run again too, told so, so it can open a new
+// source. Restarts wait a backoff that doubles with each quick failure and
+// starts over once a run has lasted a while. A Registry counts the
+// restarts and knows which listeners are down, waiting to restart, for
+// readiness checks.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Exit is how a listener's previous run ended.
+type Exit int
+
+const (
+	// First: there was none.
+	First Exit = iota
+	// Panicked: it panicked and was recovered. Its source is unchanged.
+	Panicked
+	// Returned: it returned while it should still run; its source ended.
+	Returned
+)
+
+// Registry runs listeners and tracks their health. Its settings are read
+// when a listener starts; the zero value of each picks the default.
+type Registry struct {
+	// MinBackoff is the wait before the first restart (100ms), doubled up
+	// to MaxBackoff (30s) while runs keep failing within Healthy (1m).
+	MinBackoff, MaxBackoff, Healthy time.Duration
+	// Logf reports stops and restarts (log.Printf).
+	Logf func(format string, args ...interface{})
+
+	restarts atomic.Int64
+	mu       sync.Mutex
+	entries  map[*entry]bool
+}
+
+type entry struct {
+	name     string
+	down     bool
+	reason   string
+	restarts int
+}
+
+// Status is a listener's health.
+type Status struct {
+	Name string
+	// Down means the listener stopped and waits to be restarted, after
+	// Reason.
+	Down     bool
+	Reason   string
+	Restarts int
+}
+
+// Go runs listen in a new goroutine until ctx is done, restarting it with
+// backoff whenever it panics or returns. A listen that returns once ctx is
+// done, or a backoff cut short by it, ends the listener.
+func (r *Registry) Go(ctx context.Context, name string, listen func(last Exit)) {
+	e := &entry{name: name}
+	r.mu.Lock()
+	if r.entries == nil {
+		r.entries = map[*entry]bool{}
+	}
+	r.entries[e] = true
+	r.mu.Unlock()
+
+	minBackoff, maxBackoff, healthy := orDefault(r.MinBackoff, 100*time.Millisecond), orDefault(r.MaxBackoff, 30*time.Second), orDefault(r.Healthy, time.Minute)
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.entries, e)
+			r.mu.Unlock()
+		}()
+		last, backoff := First, minBackoff
+		for {
+			started := time.Now()
+			reason := r.run(name, listen, last)
+			if ctx.Err() != nil {
+				return
+			}
+			last = Panicked
+			if reason == "" {
+				last, reason = Returned, "returned"
+			}
+			if time.Since(started) >= healthy {
+				backoff = minBackoff
+			}
+			r.restarts.Add(1)
+			r.mu.Lock()
+			e.down, e.reason = true, reason
+			e.restarts++
+			r.mu.Unlock()
+			r.logf("⚠️ Listener %s stopped (%s); restarting in %s", name, reason, backoff)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+			r.mu.Lock()
+			e.down = false
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// run calls listen once, returning what it panicked with, or "" if it
+// returned.
+func (r *Registry) run(name string, listen func(Exit), last Exit) (reason string) {
+	defer func() {
+		if v := recover(); v != nil {
+			reason = fmt.Sprint("panic: ", v)
+			r.logf("❌ Listener %s panicked: %v\n%s", name, v, debug.Stack())
+		}
+	}()
+	listen(last)
+	return ""
+}
+
+func (r *Registry) logf(format string, args ...interface{}) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Restarts is how many times listeners were restarted.
+func (r *Registry) Restarts() int64 { return r.restarts.Load() }
+
+// Statuses returns the running listeners' health, by name.
+func (r *Registry) Statuses() []Status {
+	r.mu.Lock()
+	list := make([]Status, 0, len(r.entries))
+	for e := range r.entries {
+		list = append(list, Status{Name: e.name, Down: e.down, Reason: e.reason, Restarts: e.restarts})
+	}
+	r.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Down returns the listeners waiting to be restarted.
+func (r *Registry) Down() []Status {
+	var down []Status
+	for _, s := range r.Statuses() {
+		if s.Down {
+			down = append(down, s)
+		}
+	}
+	return down
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,117 @@
+package supervisor_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-chatapp/supervisor"
+)
+
+const backoff = 200 * time.Millisecond
+
+// TestRestart makes a listener reading a channel of messages panic on one
+// of them, then return as if its subscription closed, and checks that it
+// is reported down meanwhile, is restarted each time, and goes on to
+// deliver every later message; and that it stops with its context.
+func TestRestart(t *testing.T) {
+	reg := &supervisor.Registry{MinBackoff: backoff, MaxBackoff: 4 * backoff, Logf: func(string, ...interface{}) {}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var mu sync.Mutex
+	source := make(chan string)
+	sources := 1
+	delivered := make(chan string, 10)
+	var exits []supervisor.Exit
+	reg.Go(ctx, "test", func(last supervisor.Exit) {
+		mu.Lock()
+		exits = append(exits, last)
+		if last == supervisor.Returned {
+			source = make(chan string) // subscribe again
+			sources++
+		}
+		src := source
+		mu.Unlock()
+		for msg := range src {
+			switch msg {
+			case "panic":
+				panic("injected")
+			case "close":
+				return
+			}
+			delivered <- msg
+		}
+	})
+	// send waits for a listener to take msg, from the current source: a
+	// restart may replace it meanwhile.
+	send := func(msg string) {
+		for deadline := time.Now().Add(10 * backoff); time.Now().Before(deadline); {
+			mu.Lock()
+			src := source
+			mu.Unlock()
+			select {
+			case src <- msg:
+				return
+			case <-time.After(backoff / 10):
+			}
+		}
+		t.Fatalf("listener didn't take %q", msg)
+	}
+	expect := func(msg string) {
+		select {
+		case got := <-delivered:
+			if got != msg {
+				t.Fatalf("delivered %q, want %q", got, msg)
+			}
+		case <-time.After(10 * backoff):
+			t.Fatalf("%q wasn't delivered", msg)
+		}
+	}
+
+	send("one")
+	expect("one")
+	if down := reg.Down(); len(down) != 0 {
+		t.Fatalf("down before any failure: %+v", down)
+	}
+
+	send("panic")
+	time.Sleep(backoff / 2)
+	down := reg.Down()
+	if len(down) != 1 || down[0].Name != "test" || down[0].Reason != "panic: injected" || down[0].Restarts != 1 {
+		t.Fatalf("after the panic, Down() = %+v; want test down with panic: injected", down)
+	}
+	send("two")
+	expect("two")
+	if down := reg.Down(); len(down) != 0 {
+		t.Fatalf("still down after restarting: %+v", down)
+	}
+
+	send("close")
+	time.Sleep(backoff / 2)
+	if down := reg.Down(); len(down) != 1 || down[0].Reason != "returned" {
+		t.Fatalf("after the subscription closed, Down() = %+v; want test down, returned", down)
+	}
+	send("three")
+	expect("three")
+	mu.Lock()
+	if sources != 2 || len(exits) != 3 || exits[0] != supervisor.First || exits[1] != supervisor.Panicked || exits[2] != supervisor.Returned {
+		t.Errorf("runs were told %v with %d sources; want First, Panicked, Returned with 2", exits, sources)
+	}
+	mu.Unlock()
+	if n := reg.Restarts(); n != 2 {
+		t.Fatalf("Restarts() = %d, want 2", n)
+	}
+
+	cancel()
+	mu.Lock()
+	close(source)
+	mu.Unlock()
+	time.Sleep(backoff / 2)
+	if st := reg.Statuses(); len(st) != 0 {
+		t.Fatalf("listener still registered after its context ended: %+v", st)
+	}
+	if n := reg.Restarts(); n != 2 {
+		t.Fatalf("restarted after its context ended: Restarts() = %d", n)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Optimistic sends. A client may tag a message it sends with a tempId of
+// its own (msg, dm, e2e_dm, room_send, group_dm_send), render it right
+// away and reconcile when the server confirms it. Once the message is
+// stored the server answers
+//
+//	{"type":"ack","tempId":"t1","id":"<server id>","time":1700000000}
+//
+// and the copy of the message delivered back to the sending connection
+// carries "tempId" too. The tempId is never stored or published: only the
+// sending connection knows it (client.echoes), and writeMessage adds it to
+// that connection's own frames, so no other recipient, including the
+// sender's other connections, ever sees it.
+const (
+	maxTempIDLen     = 64
+	maxPendingEchoes = 256
+	echoTTL          = time.Minute // forget tempIds whose copy never came back
+)
+
+type pendingEcho struct {
+	tempID string
+	at     time.Time
+}
+
+func validTempID(tempID string) bool {
+	return len(tempID) <= maxTempIDLen
+}
+
+// expectEcho remembers tempID until the copy of message id reaches c.
+func (c *client) expectEcho(id, tempID string) {
+	if tempID == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.echoes == nil {
+		c.echoes = map[string]pendingEcho{}
+	}
+	now := time.Now()
+	for k, e := range c.echoes {
+		if now.Sub(e.at) > echoTTL {
+			delete(c.echoes, k)
+		}
+	}
+	if len(c.echoes) < maxPendingEchoes {
+		c.echoes[id] = pendingEcho{tempID: tempID, at: now}
+	}
+}
+
+// sendAck confirms a stored message to the connection that sent it.
+func sendAck(c *client, tempID string, msg ChatMessage) {
+	if tempID == "" {
+		return
+	}
+	c.writeJSON(protocol.NewAck(tempID, msg.ID, msg.Time))
+}
+
+// Resends. A client that times out waiting for an ack may send a public
+// message again with the same tempId, although the first one went
+// through. Each tempId a user sends msg with is claimed for
+// CHAT_DEDUPE_WINDOW in chat:user:<name>:sent:<tempId>, set only if absent,
+// and holds "<id> <time>" once the message is stored. A resend within the
+// window, from any of the user's connections (so also after a reconnect),
+// is not stored again: it gets the original ack, or nothing if the first
+// send is still in flight (its ack is on the way, or a later resend gets
+// it). A send that fails gives its claim back. Redis errors let the
+// message through.
+
+// claimTempID reserves tempID for a public message from name, or answers
+// a resend and returns false.
+func claimTempID(c *client, name, tempID string) bool {
+	window := cfg().DedupeWindow
+	if tempID == "" || window <= 0 {
+		return true
+	}
+	key := c.ws.sentKey(name, tempID)
+	for range 2 {
+		ok, err := rdb.SetNX(c.ctx, key, "", window).Result()
+		if err != nil || ok {
+			return true
+		}
+		sent, err := rdb.Get(c.ctx, key).Result()
+		if err == redis.Nil {
+			continue // expired in between
+		}
+		if err != nil {
+			return true
+		}
+		if id, t, ok := strings.Cut(sent, " "); ok {
+			at, _ := strconv.ParseInt(t, 10, 64)
+			sendAck(c, tempID, ChatMessage{ID: id, Time: at})
+		}
+		return false
+	}
+	return true
+}
+
+// recordTempID keeps the ID and time of the message stored for name's
+// tempID, for the acks of resends.
+func recordTempID(ctx context.Context, ws workspace, name, tempID string, msg ChatMessage) {
+	if tempID == "" || cfg().DedupeWindow <= 0 {
+		return
+	}
+	rdb.Set(ctx, ws.sentKey(name, tempID), msg.ID+" "+strconv.FormatInt(msg.Time, 10), cfg().DedupeWindow)
+}
+
+// releaseTempID gives back the claim of a message that was not stored.
+func releaseTempID(ctx context.Context, ws workspace, name, tempID string) {
+	if tempID == "" || cfg().DedupeWindow <= 0 {
+		return
+	}
+	rdb.Del(ctx, ws.sentKey(name, tempID))
+}
+
+// withTempID returns data with the tempId added if it is a message c sent
+// with one, which is then forgotten. Messages go out bare (msg, dm,
+// e2e_dm) or as the "message" of a room_message or group_dm frame.
+func (c *client) withTempID(data []byte) []byte {
+	c.mu.Lock()
+	pending := len(c.echoes) > 0
+	c.mu.Unlock()
+	if !pending || len(data) == 0 || data[0] != '{' {
+		return data
+	}
+
+	var frame map[string]json.RawMessage
+	if json.Unmarshal(data, &frame) != nil {
+		return data
+	}
+	raw, wrapped := frame["message"], true
+	if raw == nil {
+		if frame["type"] != nil {
+			return data // not a message
+		}
+		raw, wrapped = data, false
+	}
+	var msg ChatMessage
+	if json.Unmarshal(raw, &msg) != nil || msg.ID == "" {
+		return data
+	}
+
+	c.mu.Lock()
+	echo, ok := c.echoes[msg.ID]
+	delete(c.echoes, msg.ID)
+	c.mu.Unlock()
+	if !ok {
+		return data
+	}
+	msg.TempID = echo.tempID
+	out, _ := json.Marshal(msg)
+	if wrapped {
+		frame["message"] = out
+		out, _ = json.Marshal(frame)
+	}
+	return out
+}
+
+// handleSendFrame handles the JSON forms of msg and dm, which unlike the
+// colon-separated ones can carry a tempId and always send as the joined
+// user:
+//
+//	{"type":"msg","text":"hi","tempId":"t1"}
+//	{"type":"dm","to":"bob","text":"hi","tempId":"t2"}
+func handleSendFrame(c *client, ev Event) {
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	var req protocol.SendRequest
+	if err := json.Unmarshal(ev.Data, &req); err != nil || req.Text == "" || (ev.Type == protocol.TypeDM && req.To == "") || !validTempID(req.TempID) {
+		sendError(c, "bad_frame", "invalid "+ev.Type+" frame")
+		return
+	}
+	handleLegacyFrame(c, Event{Type: ev.Type, From: name, To: req.To, Text: req.Text, TempID: req.TempID, DisplayName: req.DisplayName, Format: req.Format, Lang: req.Lang})
+}
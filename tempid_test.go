@@ -0,0 +1,89 @@
+package main_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"websocket-chatapp/client"
+	"websocket-chatapp/protocol"
+)
+
+// TestTempIDPrivate checks, for each kind of message, that the tempId a
+// connection sends with it comes back on its ack and its own copy only:
+// the sender's other connection and the other recipients never see it,
+// and it isn't stored.
+func TestTempIDPrivate(t *testing.T) {
+	addr := startServer(t, "", withAdmin, "CHAT_ADMIN_READ_DMS=true")
+	alice := dial(t, addr, "", "alice")
+	bob := dial(t, addr, "", "bob")
+	carol := dial(t, addr, "", "carol")
+	for _, c := range []*client.Client{alice, bob} {
+		c.SendFrame(protocol.JoinRoomRequest{Type: protocol.TypeJoinRoom, Room: "general"})
+		await(t, c, protocol.TypeRoomJoined)
+	}
+	alice.SendFrame(protocol.GroupDMCreateRequest{Type: protocol.TypeGroupDMCreate, Members: []string{"bob", "carol"}})
+	var group protocol.GroupDMUpdate
+	decode(t, await(t, alice, protocol.TypeGroupDMUpdate), &group)
+	await(t, bob, protocol.TypeGroupDMUpdate)
+	await(t, carol, protocol.TypeGroupDMUpdate)
+	// alice's second connection is in the room and the group DM too.
+	alicePhone := dial(t, addr, "", "alice")
+	sessionID(t, alicePhone)
+
+	for _, tc := range []struct {
+		name   string
+		frame  interface{}
+		tempID string
+		copy   string // the type of the frames delivering the message
+		others []*client.Client
+	}{
+		{"msg", protocol.SendRequest{Type: protocol.TypeMsg, Text: "public", TempID: "tmp-msg"}, "tmp-msg", "message", []*client.Client{alicePhone, bob, carol}},
+		{"dm", protocol.SendRequest{Type: protocol.TypeDM, To: "bob", Text: "direct", TempID: "tmp-dm"}, "tmp-dm", "message", []*client.Client{alicePhone, bob}},
+		{"e2e_dm", protocol.E2EDMRequest{Type: protocol.TypeE2EDM, To: "bob", Payload: "c2VhbGVk", TempID: "tmp-e2e"}, "tmp-e2e", "message", []*client.Client{alicePhone, bob}},
+		{"room_send", protocol.RoomSendRequest{Type: protocol.TypeRoomSend, Room: "general", Text: "room", TempID: "tmp-room"}, "tmp-room", protocol.TypeRoomMessage, []*client.Client{alicePhone, bob}},
+		{"group_dm_send", protocol.GroupDMSendRequest{Type: protocol.TypeGroupDMSend, ID: group.ID, Text: "group", TempID: "tmp-group"}, "tmp-group", protocol.TypeGroupDM, []*client.Client{alicePhone, bob, carol}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice.SendFrame(tc.frame)
+			// The ack and the copy may come in either order.
+			got := map[string]bool{}
+			for range 2 {
+				f := await(t, alice, protocol.TypeAck, tc.copy)
+				got[f.Type] = true
+				if f.Type == tc.copy {
+					if !strings.Contains(string(f.Raw), `"tempId":"`+tc.tempID+`"`) {
+						t.Errorf("the sending connection's copy has no tempId: %s", f.Raw)
+					}
+					continue
+				}
+				var ack protocol.Ack
+				decode(t, f, &ack)
+				if ack.TempID != tc.tempID || ack.ID == "" {
+					t.Errorf("ack %+v, want tempId %s and the message's ID", ack, tc.tempID)
+				}
+			}
+			if !got[protocol.TypeAck] || !got[tc.copy] {
+				t.Fatalf("the sending connection got %v, want an ack and its copy", got)
+			}
+			for _, c := range tc.others {
+				if f := await(t, c, tc.copy); strings.Contains(string(f.Raw), "tmp-") {
+					t.Errorf("another recipient saw the tempId: %s", f.Raw)
+				}
+			}
+		})
+	}
+
+	t.Run("stored", func(t *testing.T) {
+		for _, path := range []string{"/api/admin/export", "/api/admin/export?room=general", "/api/admin/export?user=alice&peer=bob"} {
+			code, data := api(t, addr, http.MethodGet, path, nil)
+			if code != http.StatusOK || strings.Contains(string(data), "tmp-") {
+				t.Errorf("GET %s got %d: %s", path, code, data)
+			}
+		}
+		bob.SendFrame(protocol.GroupDMHistoryRequest{Type: protocol.TypeGroupDMHistory, ID: group.ID})
+		if f := await(t, bob, protocol.TypeGroupDMHistory); strings.Contains(string(f.Raw), "tmp-") {
+			t.Errorf("the group DM's history has a tempId: %s", f.Raw)
+		}
+	})
+}
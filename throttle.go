@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/protocol"
+)
+
+// Join throttling, against bots that cycle through join: names to flood
+// the member list. Limits are per client address, shared by all instances:
+//
+//   - CHAT_JOIN_RATE joins a minute;
+//   - CHAT_NAMES_PER_IP distinct names joined at once, per workspace.
+//
+// A join over either limit is refused with a join_throttled or
+// too_many_names error and counts as a strike; CHAT_IP_BAN_STRIKES strikes
+// within ipStrikeWindow ban the address for CHAT_IP_BAN_COOLDOWN: its
+// connection is closed and new ones are refused with 429. A connection can
+// only join once (see handleLegacyFrame), so the address is the unit.
+//
+// The address is clientIP's (see access.go). Addresses in CHAT_JOIN_EXEMPT
+// (loopback by default) are never limited.
+//
+// Names held are kept in a sorted set per address with an expiry time as
+// score; this instance refreshes the names it holds with every heartbeat,
+// so a crashed instance's names run out on their own.
+const (
+	ipStrikeWindow = 10 * time.Minute
+	heldNameTTL    = 2 * instanceTTL
+)
+
+var (
+	joinsRejected atomic.Int64
+	ipBans        atomic.Int64
+)
+
+func joinExempt(ip string) bool {
+	return ip == "" || slices.Contains(cfg().JoinExempt, ip)
+}
+
+// ipBanned returns how much longer ip is banned, or 0.
+func ipBanned(ctx context.Context, ip string) time.Duration {
+	if joinExempt(ip) {
+		return 0
+	}
+	ttl, err := rdb.TTL(ctx, ipBanKey(ip)).Result()
+	if err != nil || ttl <= 0 {
+		return 0
+	}
+	return ttl
+}
+
+// allowJoin checks c's address against the join limits before it joins as
+// name, refusing (and striking) it if it is over one.
+func allowJoin(c *client, name string) bool {
+	ip := c.ip
+	if joinExempt(ip) {
+		return true
+	}
+	ctx := c.ctx
+	now := time.Now()
+
+	pipe := rdb.Pipeline()
+	key := ipJoinsKey(ip, now.Unix()/60)
+	joins := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 2*time.Minute)
+	namesKey := c.ws.ipNamesKey(ip)
+	pipe.ZRemRangeByScore(ctx, namesKey, "-inf", strconv.FormatInt(now.Unix(), 10))
+	held := pipe.ZScore(ctx, namesKey, name)
+	count := pipe.ZCard(ctx, namesKey)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return true // fail open; Redis trouble is reported elsewhere
+	}
+
+	if joins.Val() > int64(cfg().JoinRate) {
+		strikeJoin(c, "join_throttled", "too many joins from your address; slow down", 60-now.Unix()%60)
+		return false
+	}
+	if held.Err() == redis.Nil && count.Val() >= int64(c.limit("namesPerIP", cfg().NamesPerIP)) {
+		strikeJoin(c, "too_many_names", fmt.Sprintf("your address already holds %d names", count.Val()), 0, "count", strconv.FormatInt(count.Val(), 10))
+		return false
+	}
+	return true
+}
+
+// strikeJoin refuses a join and bans the address once it has too many
+// strikes.
+func strikeJoin(c *client, code, message string, retryAfter int64, vars ...string) {
+	joinsRejected.Add(1)
+	frame := errorFrame(c, code, message, vars...)
+	frame.RetryAfter = retryAfter
+
+	c.writeJSON(frame)
+
+	ctx, ip := serverCtx, c.ip
+	strikes, err := rdb.Incr(ctx, ipStrikesKey(ip)).Result()
+	if err != nil {
+		return
+	}
+	if strikes == 1 {
+		rdb.Expire(ctx, ipStrikesKey(ip), ipStrikeWindow)
+	}
+	if strikes < int64(cfg().IPBanStrikes) {
+		return
+	}
+	cooldown := cfg().IPBanCooldown
+	until := time.Now().Add(cooldown)
+	if ok, _ := rdb.SetNX(ctx, ipBanKey(ip), strikes, cooldown).Result(); !ok {
+		if ttl, err := rdb.TTL(ctx, ipBanKey(ip)).Result(); err == nil && ttl > 0 {
+			until = time.Now().Add(ttl)
+		}
+	} else {
+		ipBans.Add(1)
+		rdb.Del(ctx, ipStrikesKey(ip))
+		log.Printf("🚫 Banned address %s for %s after %d rejected joins", ip, cooldown, strikes)
+		publishAdminEvent(protocol.AdminEvent{Event: "ip_ban", Workspace: string(c.ws), Reason: ip, Until: until.Unix()})
+	}
+	reason := protocol.CloseReason{Kind: protocol.CloseAddressBanned, Reason: "too many rejected joins", Until: until.Unix()}
+	closeClient(c, websocket.ClosePolicyViolation, reason.Encode())
+}
+
+// Names this instance holds, by address and workspace, with their number
+// of connections.
+type heldName struct {
+	ws   workspace
+	ip   string
+	name string
+}
+
+var (
+	heldMu    sync.Mutex
+	heldNames = map[heldName]int{}
+)
+
+// holdName records that c's address now holds name.
+func holdName(c *client, name string) {
+	if joinExempt(c.ip) {
+		return
+	}
+	heldMu.Lock()
+	heldNames[heldName{c.ws, c.ip, name}]++
+	heldMu.Unlock()
+	rdb.ZAdd(serverCtx, c.ws.ipNamesKey(c.ip), redis.Z{Score: float64(time.Now().Add(heldNameTTL).Unix()), Member: name})
+	rdb.Expire(serverCtx, c.ws.ipNamesKey(c.ip), heldNameTTL)
+}
+
+// releaseName is holdName's undo, on disconnect.
+func releaseName(c *client, name string) {
+	h := heldName{c.ws, c.ip, name}
+	heldMu.Lock()
+	n, ok := heldNames[h]
+	last := ok && n <= 1
+	if last {
+		delete(heldNames, h)
+	} else if ok {
+		heldNames[h] = n - 1
+	}
+	heldMu.Unlock()
+	if last {
+		rdb.ZRem(serverCtx, c.ws.ipNamesKey(c.ip), name)
+	}
+}
+
+// refreshHeldNames extends the names this instance holds; it runs with
+// the heartbeat.
+func refreshHeldNames(ctx context.Context) {
+	heldMu.Lock()
+	names := make([]heldName, 0, len(heldNames))
+	for h := range heldNames {
+		names = append(names, h)
+	}
+	heldMu.Unlock()
+	if len(names) == 0 {
+		return
+	}
+	until := float64(time.Now().Add(heldNameTTL).Unix())
+	pipe := rdb.Pipeline()
+	for _, h := range names {
+		pipe.ZAdd(ctx, h.ws.ipNamesKey(h.ip), redis.Z{Score: until, Member: h.name})
+		pipe.Expire(ctx, h.ws.ipNamesKey(h.ip), heldNameTTL)
+	}
+	pipe.Exec(ctx)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"websocket-chatapp/protocol"
+)
+
+// Translation on demand. {"type":"translate","id":"<message ID>","to":"en"}
+// asks for one message in another language; the answer goes only to the
+// requesting connection. CHAT_TRANSLATOR picks the Translator: "noop"
+// (returns the text as is, for trying clients out) or "http" (see
+// httpTranslator). Translations are cached per message and language in
+// chat:translations:<id> for translationTTL, sealed like messages.
+// End-to-end encrypted messages are never translated: the server can't
+// read them, and that's the point.
+type Translator interface {
+	Translate(ctx context.Context, text, to string) (string, error)
+}
+
+const (
+	translationTTL     = 7 * 24 * time.Hour
+	translateTimeout   = 10 * time.Second
+	translateSearchMax = 1000 // messages scanned for an ID when no time is given
+)
+
+var (
+	translator Translator
+	langTag    = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+)
+
+func startTranslator() error {
+	switch cfg().Translator {
+	case "":
+	case "noop":
+		translator = noopTranslator{}
+	case "http":
+		if cfg().TranslateURL == "" {
+			return fmt.Errorf("CHAT_TRANSLATOR=http needs CHAT_TRANSLATE_URL")
+		}
+		translator = &httpTranslator{url: cfg().TranslateURL, key: cfg().TranslateKey, client: &http.Client{Timeout: translateTimeout}}
+		fmt.Println("🌐 Translating through", redactURL(cfg().TranslateURL))
+	default:
+		return fmt.Errorf("unknown CHAT_TRANSLATOR %q (want noop or http)", cfg().Translator)
+	}
+	return nil
+}
+
+// {"type":"translate","id":"<message ID>","to":"en","conversation":"room:ops","time":1700000000}
+// conversation defaults to "global"; time (the message's) is optional but
+// saves searching the history for the ID.
+func handleTranslate(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.TranslateRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.ID == "" || !langTag.MatchString(req.To) {
+		sendError(c, "bad_frame", "invalid translate frame")
+		return
+	}
+	if req.Conversation == "" {
+		req.Conversation = "global"
+	}
+	if translator == nil {
+		sendError(c, "unavailable", "translation is not enabled on this server")
+		return
+	}
+
+	msg, ok := findMessage(ctx, ws, name, req.Conversation, req.ID, req.Time)
+	if !ok {
+		sendError(c, "not_found", "no such message")
+		return
+	}
+	if msg.Kind == "e2e" {
+		sendError(c, "bad_request", "end-to-end encrypted messages can't be translated")
+		return
+	}
+
+	reply := protocol.NewTranslation(msg.ID, req.To)
+	if cached, err := rdb.HGet(ctx, ws.translationsKey(msg.ID), req.To).Result(); err == nil {
+		if text, err := unseal(cached); err == nil {
+			reply.Text = string(text)
+			c.writeJSON(reply)
+			return
+		}
+	}
+	if !takeTranslateRate(c, name) {
+		return
+	}
+
+	text := msg.Text
+	if r := []rune(text); len(r) > cfg().TranslateMaxChars {
+		text = string(r[:cfg().TranslateMaxChars])
+		reply.Truncated = true
+	}
+	// The translator may be slow; don't hold up the connection's other frames.
+	go func() {
+		tctx, cancel := context.WithTimeout(ctx, translateTimeout)
+		defer cancel()
+		translated, err := translator.Translate(tctx, text, req.To)
+		if err != nil {
+			log.Printf("❌ Translating message %s failed: %v", msg.ID, err)
+			sendError(c, "unavailable", "translation failed")
+			return
+		}
+		if !reply.Truncated {
+			pipe := rdb.Pipeline()
+			pipe.HSet(serverCtx, ws.translationsKey(msg.ID), req.To, seal([]byte(translated)))
+			pipe.Expire(serverCtx, ws.translationsKey(msg.ID), translationTTL)
+			pipe.Exec(serverCtx)
+		}
+		reply.Text = translated
+		c.writeJSON(reply)
+	}()
+}
+
+// findMessage looks message id up in a conversation name can read: global,
+// room:<name> (if a member, from their join if the room hides older
+// history) and group:<id> (if a member) or dm:<peer> (either side).
+func findMessage(ctx context.Context, ws workspace, name, conversation, id string, at int64) (ChatMessage, bool) {
+	var keys []string
+	var cleared int64 // see dmclear.go and roomhistory.go
+	kind, target, _ := strings.Cut(conversation, ":")
+	switch {
+	case conversation == "global":
+		keys = []string{ws.messagesKey()}
+	case kind == "room" && target != "":
+		if ok, _ := rdb.SIsMember(ctx, ws.roomMembersKey(target), name).Result(); ok {
+			keys = []string{ws.roomMessagesKey(target)}
+			cleared = roomVisibleAfter(ctx, ws, name, target)
+		}
+	case kind == "group" && target != "":
+		if ok, _ := rdb.SIsMember(ctx, ws.groupMembersKey(target), name).Result(); ok {
+			keys = []string{ws.groupMessagesKey(target)}
+		}
+	case kind == "dm" && target != "":
+		keys = []string{ws.dmKey(name, target)}
+		cleared = dmClearedAt(ctx, ws, name, target)
+	}
+
+	for _, key := range keys {
+		var raws []string
+		if at > 0 {
+			t := strconv.FormatInt(at, 10)
+			raws, _ = messagesBetween(ctx, ws, key, t, t, 0)
+		} else {
+			raws, _ = rdb.ZRevRange(ctx, key, 0, translateSearchMax-1).Result()
+		}
+		for _, raw := range raws {
+			if msg, ok := decodeMessage(raw); ok && msg.ID == id && msg.Time > cleared {
+				return msg, true
+			}
+		}
+	}
+	return ChatMessage{}, false
+}
+
+// takeTranslateRate allows CHAT_TRANSLATE_RATE translations per user per
+// minute. Cached translations don't count.
+func takeTranslateRate(c *client, name string) bool {
+	ctx := c.ctx
+	minute := time.Now().Unix() / 60
+	key := c.ws.translateRateKey(name, minute)
+	pipe := rdb.TxPipeline()
+	used := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true
+	}
+	if used.Val() <= int64(c.limit("translateRate", cfg().TranslateRate)) {
+		return true
+	}
+	frame := errorFrame(c, "rate_limited", "too many translations; try again shortly")
+	frame.ResetsIn = 60 - time.Now().Unix()%60
+	c.writeJSON(frame)
+
+	return false
+}
+
+// noopTranslator returns the text unchanged.
+type noopTranslator struct{}
+
+func (noopTranslator) Translate(ctx context.Context, text, to string) (string, error) {
+	return text, nil
+}
+
+// httpTranslator POSTs {"text":"...","to":"en"} to CHAT_TRANSLATE_URL, with
+// "Authorization: Bearer <CHAT_TRANSLATE_KEY>" when set, and expects
+// {"text":"<translation>"} back. A small adapter in front of any
+// translation API fits this.
+type httpTranslator struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+func (t *httpTranslator) Translate(ctx context.Context, text, to string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"text": text, "to": to})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.key != "" {
+		req.Header.Set("Authorization", "Bearer "+t.key)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("translator answered %s", resp.Status)
+	}
+	var out struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.Text, nil
+}
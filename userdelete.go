@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// User data deletion. DELETE /api/users/<name> starts a background job that
+// purges everything stored about the user in one workspace: presence,
+// profile, read positions, quotas, search index entries, room and group DM
+// memberships, and their messages in the global history, rooms, group DMs
+// and DMs (deleted, or anonymized as "deleted-user").
+//
+// Every step is idempotent, so a job is resumed by running it again from
+// the top; progress lives in chat:deletion:<name> and unfinished jobs are
+// picked up again when an instance starts.
+const deletedUserName = "deleted-user"
+
+// accountDeletedFrame is published on the user's personal channel; every
+// instance closes the connections that receive it.
+const accountDeletedFrame = `{"type":"account_deleted"}`
+
+type deletionJob struct {
+	ws     workspace
+	name   string
+	mode   string // "delete" or "anonymize"
+	dryRun bool
+
+	keys     int // keys deleted (or that would be)
+	messages int // messages deleted or anonymized (or that would be)
+	scanned  int // message keys scanned
+}
+
+// DELETE /api/users/<name>?workspace=&mode=delete|anonymize&dryRun=1
+// GET    /api/users/<name>/deletion?workspace=  reports progress.
+func handleUsersAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rest := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	name, sub, _ := strings.Cut(rest, "/")
+	if sub == "status" && name != "" {
+		handleUserStatusAPI(w, r, name) // the user may call it too
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	ws, ok := lookupWorkspace(ctx, r.URL.Query().Get("workspace"))
+	if !ok || name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	name = resolveName(ctx, ws, name)
+
+	switch {
+	case r.Method == http.MethodGet && sub == "deletion":
+		status, _ := rdb.HGetAll(ctx, ws.deletionKey(name)).Result()
+		if len(status) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+
+	case r.Method == http.MethodDelete && sub == "":
+		q := r.URL.Query()
+		job := &deletionJob{ws: ws, name: name, mode: q.Get("mode"), dryRun: q.Get("dryRun") == "1" || q.Get("dryRun") == "true"}
+		if job.mode == "" {
+			job.mode = "anonymize"
+		}
+		if job.mode != "delete" && job.mode != "anonymize" {
+			http.Error(w, "mode must be delete or anonymize", http.StatusBadRequest)
+			return
+		}
+
+		if job.dryRun {
+			// Dry runs are read-only and usually small enough to answer inline.
+			job.run(ctx)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(job.status("done"))
+			return
+		}
+
+		rdb.SAdd(ctx, ws.deletionsKey(), name)
+		rdb.HSet(ctx, ws.deletionKey(name), job.status("running"))
+		go job.run(serverCtx) // outlives the request
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job.status("running"))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (j *deletionJob) status(state string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     j.name,
+		"mode":     j.mode,
+		"dryRun":   strconv.FormatBool(j.dryRun),
+		"status":   state,
+		"keys":     j.keys,
+		"messages": j.messages,
+		"scanned":  j.scanned,
+		"updated":  time.Now().Unix(),
+	}
+}
+
+func (j *deletionJob) progress(ctx context.Context, state string) {
+	if !j.dryRun {
+		rdb.HSet(ctx, j.ws.deletionKey(j.name), j.status(state))
+	}
+}
+
+// resumeDeletions restarts jobs left running by an instance that stopped.
+// Running a job twice at once is harmless, just wasted work.
+func resumeDeletions(ctx context.Context) {
+	for _, ws := range knownWorkspaces(ctx) {
+		names, _ := rdb.SMembers(ctx, ws.deletionsKey()).Result()
+		for _, name := range names {
+			status, _ := rdb.HGetAll(ctx, ws.deletionKey(name)).Result()
+			if status["status"] != "running" {
+				continue
+			}
+			log.Printf("🗑 Resuming deletion of %q", name)
+			go (&deletionJob{ws: ws, name: name, mode: status["mode"]}).run(ctx)
+		}
+	}
+}
+
+func (j *deletionJob) run(ctx context.Context) {
+	ws, name := j.ws, j.name
+	if !j.dryRun {
+		publish(ws.userChannel(name), []byte(accountDeletedFrame))
+		dropPresence(ctx, ws, name)
+	}
+
+	// Memberships: take the user out of every room and group DM. Rooms
+	// they owned become ownerless; the next user to join claims them.
+	rooms, _ := rdb.SMembers(ctx, ws.userRoomsKey(name)).Result()
+	groups, _ := rdb.SMembers(ctx, ws.userGroupsKey(name)).Result()
+	if !j.dryRun {
+		for _, room := range rooms {
+			rdb.SRem(ctx, ws.roomMembersKey(room), name)
+			if owner, _ := rdb.HGet(ctx, ws.roomMetaKey(room), "owner").Result(); owner == name {
+				rdb.HDel(ctx, ws.roomMetaKey(room), "owner")
+			}
+		}
+		for _, id := range groups {
+			rdb.SRem(ctx, ws.groupMembersKey(id), name)
+		}
+	}
+
+	// Search index entries for the username and display name.
+	displayName, _ := rdb.HGet(ctx, ws.profileKey(name), "displayName").Result()
+	if !j.dryRun {
+		rdb.ZRem(ctx, ws.usersLexKey(), lexEntry(name, name))
+		if displayName != "" {
+			rdb.ZRem(ctx, ws.usersLexKey(), lexEntry(displayName, name))
+		}
+		rdb.ZRem(ctx, ws.usersActivityKey(), name)
+		rdb.SRem(ctx, ws.invitedKey(), name)
+		unregisterName(ctx, ws, name)
+		if n, _ := rdb.HDel(ctx, ws.watchesKey(), name).Result(); n > 0 {
+			publish(ws.watchesChannel(), []byte(name))
+		}
+		resetActivity(ctx, ws, name)
+	}
+
+	keys := []string{ws.profileKey(name), ws.readPosKey(name), ws.userRoomsKey(name), ws.userGroupsKey(name), ws.autoReplyKey(name), ws.snoozesKey(name), ws.userDMsKey(name), ws.dmClearedKey(name), ws.sessionsKey(name), ws.dismissedKey(name), ws.roomSinceKey(name), ws.statusKey(name)}
+	keys = append(keys, j.scanKeys(ctx, ws.quotaKey(escapeGlob(name), "*"))...)
+	keys = append(keys, j.scanKeys(ctx, ws.autoReplySentKey(escapeGlob(name), "*"))...)
+	keys = append(keys, j.scanKeys(ctx, ws.notifyThrottleKey(escapeGlob(name), "*"))...)
+	keys = append(keys, j.scanKeys(ctx, ws.deliveredKey(escapeGlob(name), "*"))...)
+	keys = append(keys, j.scanKeys(ctx, ws.deliveredKey("*", escapeGlob(name)))...)
+	for _, key := range keys {
+		if n, _ := rdb.Exists(ctx, key).Result(); n == 0 {
+			continue
+		}
+		j.keys++
+		if !j.dryRun {
+			rdb.Del(ctx, key)
+		}
+	}
+	// Votes in polls; closed polls keep their final result.
+	for _, key := range j.scanKeys(ctx, ws.pollVotesKey("*")) {
+		if !j.dryRun {
+			rdb.HDel(ctx, key, name)
+		}
+	}
+	j.progress(ctx, "running")
+
+	// Messages: every history zset in the workspace. DMs are stored per
+	// pair of participants, so all of them have to be looked at.
+	msgKeys, err := conversationKeys(ctx, ws)
+	if err != nil {
+		// Left "running", so the next start resumes it.
+		log.Printf("❌ Deleting data of %q: listing conversations failed: %v", name, err)
+		return
+	}
+	for _, key := range msgKeys {
+		j.purgeMessages(ctx, key)
+		j.scanned++
+		if j.scanned%100 == 0 {
+			j.progress(ctx, "running")
+		}
+	}
+
+	j.progress(ctx, "done")
+	if !j.dryRun {
+		fmt.Printf("🗑 Deleted data of %q: %d keys, %d messages\n", name, j.keys, j.messages)
+	}
+}
+
+func (j *deletionJob) scanKeys(ctx context.Context, pattern string) []string {
+	var keys []string
+	iter := rdb.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys
+}
+
+// purgeMessages deletes or anonymizes the user's messages in one zset.
+func (j *deletionJob) purgeMessages(ctx context.Context, key string) {
+	iter := rdb.ZScan(ctx, key, 0, "*", 500).Iterator()
+	for iter.Next(ctx) {
+		member := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+		score, _ := strconv.ParseFloat(iter.Val(), 64)
+
+		data, err := unseal(member)
+		if err != nil {
+			continue
+		}
+		var msg ChatMessage
+		if json.Unmarshal(data, &msg) != nil || msg.User != j.name {
+			continue
+		}
+		j.messages++
+		if j.dryRun {
+			continue
+		}
+
+		pipe := rdb.TxPipeline()
+		pipe.ZRem(ctx, key, member)
+		if j.mode == "anonymize" {
+			msg.User = deletedUserName
+			anon, _ := json.Marshal(msg)
+			pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: seal(anon)})
+		} else {
+			pipe.ZRem(ctx, j.ws.timeIndexKey(key), strconv.FormatFloat(score, 'f', -1, 64))
+			pipe.Del(ctx, j.ws.translationsKey(msg.ID))
+		}
+		pipe.Exec(ctx)
+		if key == j.ws.messagesKey() {
+			forgetRecentHistory(j.ws)
+		}
+	}
+}
+
+// escapeGlob quotes the glob metacharacters of s for SCAN MATCH.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
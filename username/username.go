@@ -0,0 +1,33 @@
+// Package username defines when two user names are the same name. Names
+// are compared by their canonical form: the name decomposed, case-folded
+// and composed again (NFC), as in Unicode's canonical caseless matching.
+// "Bob", "bob" and "BOB" are one name, and so are "Straße" and "STRASSE"
+// (ß folds to ss), and "é" typed as one character or as e and a combining
+// accent. Folding is the default, language-independent one: "İ" (capital I
+// with a dot) folds to i and a combining dot, not to a plain i, so "İ" and
+// "i" stay different names, as do "I" and "ı".
+//
+// Folding and normalization come from golang.org/x/text (cases, norm).
+package username
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Canonical is name's canonical form. Invalid UTF-8 is dropped.
+func Canonical(name string) string {
+	// A Caser keeps state, so each call gets its own.
+	folded := cases.Fold().String(norm.NFD.String(strings.ToValidUTF8(name, "")))
+	return norm.NFC.String(folded)
+}
+
+// Equal reports whether a and b are the same name.
+func Equal(a, b string) bool {
+	return a == b || Canonical(a) == Canonical(b)
+}
+
+// UnicodeVersion is the Unicode version names are folded and normalized by.
+func UnicodeVersion() string { return norm.Version }
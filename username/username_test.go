@@ -0,0 +1,63 @@
+package username_test
+
+import (
+	"testing"
+
+	"websocket-chatapp/username"
+)
+
+// TestCanonical checks canonical forms against Unicode's default case
+// folding and NFC, including the pairs that differ from plain lowercasing.
+func TestCanonical(t *testing.T) {
+	for _, tc := range []struct{ name, want string }{
+		{"Bob", "bob"},
+		{"STRASSE", "strasse"},
+		{"Straße", "strasse"},
+		{"ß", "ss"},
+		{"\u1E9E", "ss"}, // capital sharp s
+		{"\uFB00", "ff"}, // ligature ff
+		{"İ", "i\u0307"}, // dotted capital I: i and a combining dot
+		{"I", "i"},
+		{"ı", "ı"}, // dotless i folds to itself
+		{"Σ", "σ"},
+		{"ς", "σ"},            // final sigma
+		{"e\u0301", "\u00E9"}, // é decomposed composes
+		{"\u00C5", "\u00E5"},  // Å
+		{"\u212B", "\u00E5"},  // angstrom sign
+		{"\u212A", "k"},       // kelvin sign
+		{"\u01C5", "\u01C6"},  // title case dž
+		{"\uD55C", "\uD55C"},  // Hangul syllable, decomposed and composed by arithmetic
+		{"", ""},
+		{"\xff\xfe", ""}, // invalid UTF-8 is dropped
+		{"a\xffb", "ab"},
+	} {
+		if got := username.Canonical(tc.name); got != tc.want {
+			t.Errorf("Canonical(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestEqual checks that İ/i and I/ı stay different names while ß/ss and
+// the two encodings of é don't.
+func TestEqual(t *testing.T) {
+	for _, tc := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"bob", "BOB", true},
+		{"Straße", "STRASSE", true},
+		{"straße", "strasse", true},
+		{"émile", "émile", true},
+		{"Ångström", "ångström", true},
+		{"İ", "i", false},
+		{"İ", "I", false},
+		{"İ", "i\u0307", true},
+		{"I", "ı", false},
+		{"i", "ı", false},
+		{"alice", "alice2", false},
+	} {
+		if got := username.Equal(tc.a, tc.b); got != tc.equal {
+			t.Errorf("Equal(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.equal)
+		}
+	}
+}
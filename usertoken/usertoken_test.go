@@ -0,0 +1,60 @@
+package usertoken
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	key      = []byte("0123456789abcdef0123456789abcdef")
+	otherKey = []byte("fedcba9876543210fedcba9876543210")
+	now      = time.Unix(1700000000, 0)
+)
+
+// TestVerify checks that Verify accepts what Issue signs, and refuses a
+// token signed with another key or altered, an expired one and malformed
+// ones without returning their claims.
+func TestVerify(t *testing.T) {
+	claims := Claims{Workspace: "acme", User: "alice", Expires: now.Add(time.Hour).Unix()}
+	token := Issue(key, claims)
+	parts := strings.Split(token, ".")
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	if got, err := Verify(key, token, now); err != nil || got != claims {
+		t.Fatalf("Verify of an issued token = %+v, %v; want %+v", got, err, claims)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		key   []byte
+		token string
+		now   time.Time
+		want  error
+	}{
+		{"another key", otherKey, token, now, ErrSignature},
+		{"signed with another key", key, Issue(otherKey, claims), now, ErrSignature},
+		{"claims swapped", key, parts[0] + "." + encode(`{"ws":"acme","sub":"bob","exp":1700003600}`) + "." + parts[2], now, ErrSignature},
+		{"other workspace's claims", key, parts[0] + "." + encode(`{"sub":"alice","exp":1700003600}`) + "." + parts[2], now, ErrSignature},
+		{"signature cut", key, token[:len(token)-4], now, ErrSignature},
+		{"expiry", key, token, time.Unix(claims.Expires, 0), ErrExpired},
+		{"after expiry", key, token, now.Add(2 * time.Hour), ErrExpired},
+		{"no expiry", key, Issue(key, Claims{User: "alice"}), now, ErrExpired},
+		{"other version", key, "v2." + parts[1] + "." + parts[2], now, ErrMalformed},
+		{"two parts", key, parts[0] + "." + parts[1], now, ErrMalformed},
+		{"signature not base64", key, parts[0] + "." + parts[1] + ".!!", now, ErrMalformed},
+		{"no user", key, Issue(key, Claims{Workspace: "acme", Expires: claims.Expires}), now, ErrMalformed},
+		{"empty", key, "", now, ErrMalformed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Verify(tc.key, tc.token, tc.now)
+			if err != tc.want {
+				t.Errorf("Verify error is %v, want %v", err, tc.want)
+			}
+			if got != (Claims{}) {
+				t.Errorf("Verify returned claims %+v with an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ahocorasick"
+	"websocket-chatapp/protocol"
+)
+
+// Keyword watches. A user can list words they want to hear about without
+// being mentioned; public and room messages containing one (ignoring case,
+// anywhere in the text) send them a keyword_hit on their personal channel
+// if they are online, can see the message and didn't write it. Lists live
+// in the chat:watches hash (name -> JSON array). Each instance keeps one
+// Aho-Corasick matcher over every list in a workspace, dropped whenever a
+// list changes anywhere (chat:watches channel) and rebuilt on next use.
+const (
+	maxWatchKeywords   = 20
+	minWatchKeywordLen = 2
+	maxWatchKeywordLen = 50
+)
+
+type watchIndex struct {
+	matcher  *ahocorasick.Matcher
+	keywords []string   // lower-cased, one per pattern
+	watchers [][]string // who watches keywords[i]
+}
+
+var (
+	watchIndexMu sync.Mutex
+	watchIndexes = map[workspace]*watchIndex{}
+	watchChanges = map[workspace]int{} // so a build that raced a change isn't kept
+)
+
+// {"type":"watch","keywords":["deploy","outage"]} replaces your keywords;
+// an empty list clears them and {"type":"watch"} reports them.
+func handleWatch(c *client, data []byte) {
+	ctx := c.ctx
+	name := requireJoined(c)
+	if name == "" {
+		return
+	}
+	ws := c.ws
+
+	var req protocol.WatchRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		sendError(c, "bad_frame", "invalid watch frame")
+		return
+	}
+	if req.Keywords != nil {
+		keywords, err := normalizeKeywords(*req.Keywords)
+		if err != nil {
+			sendError(c, "bad_frame", err.Error())
+			return
+		}
+		if len(keywords) == 0 {
+			rdb.HDel(ctx, ws.watchesKey(), name)
+		} else {
+			list, _ := json.Marshal(keywords)
+			rdb.HSet(ctx, ws.watchesKey(), name, list)
+		}
+		publish(ws.watchesChannel(), []byte(name))
+	}
+
+	c.writeJSON(protocol.NewWatch(userWatches(ctx, ws, name)))
+}
+
+// normalizeKeywords lower-cases, trims and de-duplicates keywords and
+// checks the limits.
+func normalizeKeywords(in []string) ([]string, error) {
+	keywords := []string{}
+	seen := map[string]bool{}
+	for _, k := range in {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "" || seen[k] {
+			continue
+		}
+		if n := utf8.RuneCountInString(k); n < minWatchKeywordLen || n > maxWatchKeywordLen {
+			return nil, fmt.Errorf("keywords must be %d to %d characters", minWatchKeywordLen, maxWatchKeywordLen)
+		}
+		seen[k] = true
+		keywords = append(keywords, k)
+	}
+	if len(keywords) > maxWatchKeywords {
+		return nil, fmt.Errorf("at most %d keywords", maxWatchKeywords)
+	}
+	return keywords, nil
+}
+
+func userWatches(ctx context.Context, ws workspace, name string) []string {
+	keywords := []string{}
+	if raw, err := rdb.HGet(ctx, ws.watchesKey(), name).Result(); err == nil {
+		json.Unmarshal([]byte(raw), &keywords)
+	}
+	return keywords
+}
+
+// listenWatchChanges drops ws's matcher whenever someone's list changes.
+func listenWatchChanges(ws workspace, sub subscription) {
+	for range sub.Messages() {
+		watchIndexMu.Lock()
+		delete(watchIndexes, ws)
+		watchChanges[ws]++
+		watchIndexMu.Unlock()
+	}
+}
+
+// loadWatchIndex returns ws's matcher, building it if needed.
+func loadWatchIndex(ctx context.Context, ws workspace) (*watchIndex, error) {
+	watchIndexMu.Lock()
+	idx, gen := watchIndexes[ws], watchChanges[ws]
+	watchIndexMu.Unlock()
+	if idx != nil {
+		return idx, nil
+	}
+
+	lists, err := rdb.HGetAll(ctx, ws.watchesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	idx = &watchIndex{}
+	pattern := map[string]int{}
+	for name, raw := range lists {
+		var keywords []string
+		if json.Unmarshal([]byte(raw), &keywords) != nil {
+			continue
+		}
+		for _, k := range keywords {
+			i, ok := pattern[k]
+			if !ok {
+				i = len(idx.keywords)
+				pattern[k] = i
+				idx.keywords = append(idx.keywords, k)
+				idx.watchers = append(idx.watchers, nil)
+			}
+			idx.watchers[i] = append(idx.watchers[i], name)
+		}
+	}
+	idx.matcher = ahocorasick.New(idx.keywords)
+
+	watchIndexMu.Lock()
+	if watchChanges[ws] == gen {
+		watchIndexes[ws] = idx
+	}
+	watchIndexMu.Unlock()
+	return idx, nil
+}
+
+// notifyWatchers sends keyword_hit to the online users watching a keyword
+// in msg, other than its author and users who snoozed the conversation
+// (see snooze.go). For room messages, room is the room and
+// only its members are told; it is empty for public messages.
+func notifyWatchers(ctx context.Context, ws workspace, room string, msg ChatMessage) {
+	if msg.Text == "" {
+		return
+	}
+	idx, err := loadWatchIndex(ctx, ws)
+	if err != nil {
+		log.Println("❌ Keyword watch error:", err)
+		return
+	}
+	hits := map[string][]string{}
+	for _, i := range idx.matcher.Match(strings.ToLower(msg.Text)) {
+		for _, name := range idx.watchers[i] {
+			if name != msg.User {
+				hits[name] = append(hits[name], idx.keywords[i])
+			}
+		}
+	}
+	if len(hits) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(hits))
+	online := make([]*redis.BoolCmd, 0, len(hits))
+	inRoom := make([]*redis.BoolCmd, 0, len(hits))
+	pipe := rdb.Pipeline()
+	for name := range hits {
+		names = append(names, name)
+		online = append(online, pipe.SIsMember(ctx, ws.membersKey(), name))
+		if room != "" {
+			inRoom = append(inRoom, pipe.SIsMember(ctx, ws.roomMembersKey(room), name))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Println("❌ Keyword watch error:", err)
+		return
+	}
+
+	conversation := "global"
+	if room != "" {
+		conversation = "room:" + room
+	}
+	for i, name := range names {
+		if !online[i].Val() || (room != "" && !inRoom[i].Val()) || snoozed(ctx, ws, name, conversation) {
+			continue
+		}
+		frame, _ := json.Marshal(protocol.NewKeywordHit(conversation, hits[name], msg))
+
+		publish(ws.userChannel(name), frame)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The demo client is compiled into the binary so it always matches the
+// server's protocol. Production deployments can turn it off with
+// CHAT_DEMO_CLIENT=false.
+//
+//go:embed index.html
+var indexHTML []byte
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(indexHTML)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"websocket-chatapp/ids"
+	"websocket-chatapp/msgfilter"
+	"websocket-chatapp/protocol"
+)
+
+// Outgoing webhooks. An admin registers URLs that get a workspace's public
+// and room messages POSTed as JSON (DMs are never sent), each with a filter
+// on rooms, senders, keywords, a regular expression and the kind of message
+// (see package msgfilter). Registrations live in chat:webhooks (hash: id ->
+// JSON). Each instance keeps a workspace's filters compiled, dropped
+// whenever a registration changes anywhere (chat:webhooks channel) and
+// rebuilt on next use, and runs them before anything is queued: a message
+// no filter wants costs a few map lookups. Matching messages go through a
+// buffered queue to background workers, like offline notifications; a slow
+// or failing webhook only costs deliveries, never messages.
+const (
+	maxWebhooks    = 20
+	webhookWorkers = 4
+	webhookTimeout = 10 * time.Second
+)
+
+// webhook is a registration. Token, when set, is sent as
+// "Authorization: Bearer <token>" and never shown again.
+type webhook struct {
+	ID      string           `json:"id"`
+	URL     string           `json:"url"`
+	Token   string           `json:"token,omitempty"`
+	Filter  msgfilter.Filter `json:"filter"`
+	Created int64            `json:"created"`
+}
+
+type compiledWebhook struct {
+	webhook
+	matcher *msgfilter.Matcher
+}
+
+type webhookDelivery struct {
+	url, token string
+	body       []byte
+}
+
+var (
+	webhookSetsMu  sync.Mutex
+	webhookSets    = map[workspace][]*compiledWebhook{}
+	webhookChanges = map[workspace]int{} // so a load that raced a change isn't kept
+
+	webhookQueue    chan webhookDelivery
+	webhookClient   = &http.Client{Timeout: webhookTimeout}
+	webhooksSent    atomic.Int64
+	webhooksFailed  atomic.Int64
+	webhooksDropped atomic.Int64
+)
+
+// startWebhooks starts the delivery workers.
+func startWebhooks() {
+	webhookQueue = make(chan webhookDelivery, cfg().WebhookBuffer)
+	for i := 0; i < webhookWorkers; i++ {
+		go runWebhooks(webhookQueue)
+	}
+}
+
+// queueWebhooks queues msg for every webhook of ws whose filter wants it.
+// Only the global chat and rooms are delivered. It returns immediately,
+// unless the workspace's webhooks need loading.
+func queueWebhooks(ws workspace, conversation string, msg ChatMessage) {
+	room, isRoom := strings.CutPrefix(conversation, "room:")
+	if !isRoom {
+		room = ""
+	}
+	if webhookQueue == nil || (!isRoom && conversation != "global") {
+		return
+	}
+	hooks, _ := loadWebhooks(serverCtx, ws)
+	if len(hooks) == 0 {
+		return
+	}
+	filtered := msgfilter.Message{Room: room, Sender: msg.User, Text: msg.Text, Kind: msgfilter.KindMessage}
+	if msg.System {
+		filtered.Kind = msgfilter.KindSystem
+	}
+	for _, h := range hooks {
+		if ok, _ := h.matcher.Match(filtered); !ok {
+			continue
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"workspace":    string(ws),
+			"webhook":      h.ID,
+			"conversation": conversation,
+			"message":      msg,
+		})
+		select {
+		case webhookQueue <- webhookDelivery{url: h.URL, token: h.Token, body: body}:
+		default:
+			if webhooksDropped.Add(1)%1000 == 1 {
+				log.Printf("⚠️ Webhook buffer full; %d delivery(ies) dropped so far", webhooksDropped.Load())
+				adminAlertf("webhooks_dropped", "webhook buffer full; %d delivery(ies) dropped so far", webhooksDropped.Load())
+			}
+		}
+	}
+}
+
+func runWebhooks(queue <-chan webhookDelivery) {
+	for d := range queue {
+		if err := deliverWebhook(d); err != nil {
+			webhooksFailed.Add(1)
+			log.Printf("❌ Webhook delivery to %s failed: %v", redactURL(d.url), err)
+			continue
+		}
+		webhooksSent.Add(1)
+	}
+}
+
+func deliverWebhook(d webhookDelivery) error {
+	req, err := http.NewRequestWithContext(serverCtx, http.MethodPost, d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// listenWebhookChanges drops ws's compiled webhooks whenever one is
+// registered or deleted.
+func listenWebhookChanges(ws workspace, sub subscription) {
+	for range sub.Messages() {
+		webhookSetsMu.Lock()
+		delete(webhookSets, ws)
+		webhookChanges[ws]++
+		webhookSetsMu.Unlock()
+	}
+}
+
+// loadWebhooks returns ws's webhooks, compiling them if needed. They are
+// kept only while this instance hears about changes, that is once ws's
+// listeners run.
+func loadWebhooks(ctx context.Context, ws workspace) ([]*compiledWebhook, error) {
+	webhookSetsMu.Lock()
+	hooks, ok := webhookSets[ws]
+	gen := webhookChanges[ws]
+	webhookSetsMu.Unlock()
+	if ok {
+		return hooks, nil
+	}
+
+	all, err := rdb.HGetAll(ctx, ws.webhooksKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	hooks = []*compiledWebhook{}
+	for _, raw := range all {
+		var h compiledWebhook
+		if json.Unmarshal([]byte(raw), &h.webhook) != nil {
+			continue
+		}
+		// Filters were checked when registered; this only fails if the
+		// limits were lowered since.
+		if h.matcher, err = msgfilter.Compile(h.Filter); err != nil {
+			log.Printf("⚠️ Skipping webhook %s: %v", h.ID, err)
+			continue
+		}
+		hooks = append(hooks, &h)
+	}
+
+	if ws.listened() {
+		webhookSetsMu.Lock()
+		if webhookChanges[ws] == gen {
+			webhookSets[ws] = hooks
+		}
+		webhookSetsMu.Unlock()
+	}
+	return hooks, nil
+}
+
+// validWebhookURL accepts absolute http and https URLs.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// GET /api/admin/webhooks?workspace=acme lists the webhooks; POST
+// {"workspace":"acme","url":"https://bot.example.com/hook","token":"...",
+// "filter":{"rooms":["deploys"],"keywords":["failed"]}} registers one and
+// DELETE ?workspace=acme&id=<id> removes one. All need
+// "Authorization: Bearer <CHAT_ADMIN_TOKEN>".
+func handleWebhooksAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ws, ok := lookupWorkspace(ctx, r.URL.Query().Get("workspace"))
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		all, _ := rdb.HGetAll(ctx, ws.webhooksKey()).Result()
+		list := make([]webhook, 0, len(all))
+		for _, raw := range all {
+			var h webhook
+			if json.Unmarshal([]byte(raw), &h) == nil {
+				list = append(list, shownWebhook(h))
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Created < list[j].Created })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"workspace": string(ws), "webhooks": list})
+
+	case http.MethodPost:
+		var req struct {
+			Workspace string           `json:"workspace"`
+			URL       string           `json:"url"`
+			Token     string           `json:"token"`
+			Filter    msgfilter.Filter `json:"filter"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validWebhookURL(req.URL) {
+			http.Error(w, "invalid webhook; url must be an http or https URL", http.StatusBadRequest)
+			return
+		}
+		if _, err := msgfilter.Compile(req.Filter); err != nil {
+			http.Error(w, "invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ws, ok := lookupWorkspace(ctx, req.Workspace)
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		if n, _ := rdb.HLen(ctx, ws.webhooksKey()).Result(); n >= maxWebhooks {
+			http.Error(w, fmt.Sprintf("at most %d webhooks per workspace", maxWebhooks), http.StatusConflict)
+			return
+		}
+
+		h := webhook{ID: ids.New(), URL: req.URL, Token: req.Token, Filter: req.Filter, Created: time.Now().Unix()}
+		raw, _ := json.Marshal(h)
+		if err := rdb.HSet(ctx, ws.webhooksKey(), h.ID, raw).Err(); err != nil {
+			http.Error(w, "could not register webhook", http.StatusInternalServerError)
+			return
+		}
+		publish(ws.webhooksChannel(), []byte(h.ID))
+		publishAdminEvent(protocol.AdminEvent{Event: "webhook_add", Workspace: string(ws), Name: h.ID, Message: redactURL(h.URL)})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(shownWebhook(h))
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		ws, ok := lookupWorkspace(ctx, q.Get("workspace"))
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		if n, _ := rdb.HDel(ctx, ws.webhooksKey(), q.Get("id")).Result(); n == 0 {
+			http.Error(w, "no such webhook", http.StatusNotFound)
+			return
+		}
+		publish(ws.webhooksChannel(), []byte(q.Get("id")))
+		publishAdminEvent(protocol.AdminEvent{Event: "webhook_delete", Workspace: string(ws), Name: q.Get("id")})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// shownWebhook is h as the API shows it: without the token, and without
+// any password in the URL.
+func shownWebhook(h webhook) webhook {
+	h.URL = redactURL(h.URL)
+	if h.Token != "" {
+		h.Token = "xxxxx"
+	}
+	return h
+}
+
+// POST /api/admin/webhooks/test {"workspace":"acme","id":"<id>",
+// "message":{"room":"deploys","user":"ci","text":"build failed"}} runs a
+// registered webhook's filter, or the one given as "filter", against a
+// sample message. The kind defaults to "message" and the room to "" (the
+// global chat). Answers {"match":false,"refusedBy":"keyword"}; nothing is
+// delivered.
+func handleWebhookTestAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Workspace string            `json:"workspace"`
+		ID        string            `json:"id"`
+		Filter    *msgfilter.Filter `json:"filter"`
+		Message   struct {
+			Room string `json:"room"`
+			User string `json:"user"`
+			Text string `json:"text"`
+			Kind string `json:"kind"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.ID == "") == (req.Filter == nil) {
+		http.Error(w, "invalid test; give either id or filter, and a message", http.StatusBadRequest)
+		return
+	}
+
+	filter := req.Filter
+	if filter == nil {
+		ws, ok := lookupWorkspace(ctx, req.Workspace)
+		if !ok {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		raw, err := rdb.HGet(ctx, ws.webhooksKey(), req.ID).Result()
+		if err == redis.Nil {
+			http.Error(w, "no such webhook", http.StatusNotFound)
+			return
+		}
+		var h webhook
+		if err != nil || json.Unmarshal([]byte(raw), &h) != nil {
+			http.Error(w, "could not load webhook", http.StatusInternalServerError)
+			return
+		}
+		filter = &h.Filter
+	}
+	m, err := msgfilter.Compile(*filter)
+	if err != nil {
+		http.Error(w, "invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Message.Kind == "" {
+		req.Message.Kind = msgfilter.KindMessage
+	}
+
+	match, refusedBy := m.Match(msgfilter.Message{Room: req.Message.Room, Sender: req.Message.User, Text: req.Message.Text, Kind: req.Message.Kind})
+	resp := map[string]interface{}{"match": match}
+	if !match {
+		resp["refusedBy"] = refusedBy
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
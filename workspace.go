@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"websocket-chatapp/supervisor"
+)
+
+// A workspace is an isolated chat: its own members, history, rooms, DMs and
+// pub/sub channels. Clients pick one with the upgrade URL (/ws/<id>); plain
+// /ws is the default workspace, whose keys are the unscoped ones.
+// Workspaces other than the default must be created through the admin API
+// and are listed in the <prefix>workspaces set.
+type workspace string
+
+const (
+	defaultWorkspace   workspace = ""
+	maxWorkspaceIDSize           = 32
+)
+
+// validWorkspaceID allows lowercase letters, digits and dashes, so IDs are
+// safe inside keys and URLs.
+func validWorkspaceID(id string) bool {
+	if id == "" || len(id) > maxWorkspaceIDSize {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupWorkspace resolves a workspace ID from a URL or query. "" is the
+// default workspace; anything else must exist.
+func lookupWorkspace(ctx context.Context, id string) (workspace, bool) {
+	if id == "" {
+		return defaultWorkspace, true
+	}
+	if !validWorkspaceID(id) {
+		return "", false
+	}
+	ok, _ := rdb.SIsMember(ctx, workspacesKey(), id).Result()
+	return workspace(id), ok
+}
+
+// knownWorkspaces returns the default workspace followed by every created
+// one.
+func knownWorkspaces(ctx context.Context) []workspace {
+	list := []workspace{defaultWorkspace}
+	ids, _ := rdb.SMembers(ctx, workspacesKey()).Result()
+	for _, id := range ids {
+		list = append(list, workspace(id))
+	}
+	return list
+}
+
+// Per-workspace overrides of the global config, stored as a hash of the
+// JSON field names below. The rate limits (dailyQuota, translateRate,
+// namesPerIP) are looked up as they are checked (see client.limit), so
+// that a hot reload of the global setting still reaches workspaces that
+// don't override it.
+var workspaceConfigFields = map[string]func(*config, int){
+	"historyLimit":     func(c *config, n int) { c.HistoryLimit = n },
+	"initHistoryChunk": func(c *config, n int) { c.InitHistoryChunk = n },
+	"initMemberPage":   func(c *config, n int) { c.InitMemberPage = n },
+	"dailyQuota":       func(c *config, n int) { c.DailyQuota = n },
+	"translateRate":    func(c *config, n int) { c.TranslateRate = n },
+	"namesPerIP":       func(c *config, n int) { c.NamesPerIP = n },
+}
+
+// overrides reads the workspace's valid overrides.
+func (ws workspace) overrides(ctx context.Context) map[string]int {
+	overrides := map[string]int{}
+	if ws == defaultWorkspace {
+		return overrides
+	}
+	raw, _ := rdb.HGetAll(ctx, ws.configKey()).Result()
+	for field, v := range raw {
+		if _, ok := workspaceConfigFields[field]; !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			overrides[field] = n
+		}
+	}
+	return overrides
+}
+
+// withOverrides returns cfg with overrides applied.
+func withOverrides(overrides map[string]int) config {
+	conf := *cfg()
+	for field, n := range overrides {
+		workspaceConfigFields[field](&conf, n)
+	}
+	return conf
+}
+
+// limit is the workspace's override of a rate limit, as read when c
+// connected, or else the global setting.
+func (c *client) limit(field string, global int) int {
+	if n, ok := c.overrides[field]; ok {
+		return n
+	}
+	return global
+}
+
+var (
+	listeningMu sync.Mutex
+	listening   = map[workspace]bool{}
+)
+
+// listen starts the workspace's broadcast listeners the first time one of
+// its clients connects to this instance. They run until the server shuts
+// down, supervised (see listeners.go). Subscribing happens before listen
+// returns, so nothing published after the client is registered is missed.
+// Public and room messages come through fan-out channels instead, held
+// only while needed; see fanout.go.
+func (ws workspace) listen() {
+	listeningMu.Lock()
+	defer listeningMu.Unlock()
+	if listening[ws] {
+		return
+	}
+	listening[ws] = true
+
+	adds, stopAdds := subscribeWhile(serverCtx, ws.memberAddChannel())
+	removes, stopRemoves := subscribeWhile(serverCtx, ws.memberRemoveChannel())
+	listeners.Go(serverCtx, ws.listenerName("roster"), func(last supervisor.Exit) {
+		if last == supervisor.Returned {
+			stopAdds()
+			stopRemoves()
+			adds.Close()
+			removes.Close()
+			adds, stopAdds = subscribeWhile(serverCtx, ws.memberAddChannel())
+			removes, stopRemoves = subscribeWhile(serverCtx, ws.memberRemoveChannel())
+		}
+		listenRoster(serverCtx, ws, adds, removes)
+	})
+	superviseChannel(serverCtx, ws.listenerName("watches"), ws.watchesChannel(), func(sub subscription) { listenWatchChanges(ws, sub) })
+	superviseChannel(serverCtx, ws.listenerName("snoozes"), ws.snoozesChannel(), func(sub subscription) { listenSnoozeChanges(ws, sub) })
+	superviseChannel(serverCtx, ws.listenerName("webhooks"), ws.webhooksChannel(), func(sub subscription) { listenWebhookChanges(ws, sub) })
+	superviseChannel(serverCtx, ws.listenerName("emoji"), ws.emojiChannel(), func(sub subscription) { listenEmojiChanges(ws, sub) })
+
+	go resyncMembers(serverCtx, ws)
+}
+
+// listened reports whether this instance listens to ws's broadcasts.
+func (ws workspace) listened() bool {
+	listeningMu.Lock()
+	defer listeningMu.Unlock()
+	return listening[ws]
+}
+
+// workspaceClients returns the connected clients of one workspace, but
+// those not admitted yet (see invites.go).
+func workspaceClients(ws workspace) []*client {
+	var list []*client
+	for _, c := range connectedClients() {
+		if c.ws == ws && c.admitted() {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// POST /api/workspaces {"id":"acme","config":{"historyLimit":50}} creates a
+// workspace or updates its config; GET lists workspaces. Both need
+// "Authorization: Bearer <CHAT_ADMIN_TOKEN>".
+func handleWorkspacesAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ids, _ := rdb.SMembers(ctx, workspacesKey()).Result()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"workspaces": ids})
+
+	case http.MethodPost:
+		var req struct {
+			ID     string         `json:"id"`
+			Config map[string]int `json:"config"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validWorkspaceID(req.ID) {
+			http.Error(w, "invalid workspace", http.StatusBadRequest)
+			return
+		}
+		ws := workspace(req.ID)
+		for field, n := range req.Config {
+			if _, ok := workspaceConfigFields[field]; !ok || n <= 0 {
+				http.Error(w, "invalid config field: "+field, http.StatusBadRequest)
+				return
+			}
+		}
+
+		pipe := rdb.TxPipeline()
+		pipe.SAdd(ctx, workspacesKey(), req.ID)
+		for field, n := range req.Config {
+			pipe.HSet(ctx, ws.configKey(), field, n)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "could not create workspace", http.StatusInternalServerError)
+			return
+		}
+
+		conf := withOverrides(ws.overrides(ctx))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": req.ID,
+			"config": map[string]int{
+				"historyLimit":     conf.HistoryLimit,
+				"initHistoryChunk": conf.InitHistoryChunk,
+				"initMemberPage":   conf.InitMemberPage,
+				"dailyQuota":       conf.DailyQuota,
+				"translateRate":    conf.TranslateRate,
+				"namesPerIP":       conf.NamesPerIP,
+			},
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requireAdmin checks the admin bearer token, answering the request itself
+// when it is missing or wrong.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg().AdminToken == "" {
+		http.Error(w, "admin API disabled", http.StatusForbidden)
+		return false
+	}
+	if !adminRequest(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// workspaceFromPath extracts the workspace ID from /ws or /ws/<id>.
+func workspaceFromPath(path string) string {
+	id, _ := strings.CutPrefix(path, "/ws")
+	return strings.Trim(id, "/")
+}
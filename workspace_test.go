@@ -0,0 +1,89 @@
+package main_test
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"websocket-chatapp/client"
+)
+
+// TestWorkspaceIsolation checks that two workspaces sharing one store, and
+// two instances, see only their own messages and members, even with the
+// same names in both, and that a workspace's rate-limit override applies
+// to it alone.
+func TestWorkspaceIsolation(t *testing.T) {
+	store := serveStore(t, 0)
+	addrA := startServer(t, store, withAdmin)
+	addrB := startServer(t, store, withAdmin)
+	for _, body := range []string{`{"id":"acme","config":{"dailyQuota":1}}`, `{"id":"globex"}`} {
+		if code, data := api(t, addrA, http.MethodPost, "/api/workspaces", []byte(body)); code != http.StatusOK {
+			t.Fatalf("creating a workspace got %d: %s", code, data)
+		}
+	}
+	alice := dial(t, addrA, "/acme", "alice")
+	carol := dial(t, addrB, "/acme", "carol")
+	bob := dial(t, addrB, "/globex", "bob")
+	otherAlice := dial(t, addrA, "/globex", "alice")
+
+	alice.Send("alice", "hi acme")
+	if got := awaitText(t, carol); got != "hi acme" {
+		t.Fatalf("carol got %q, want alice's message", got)
+	}
+	// bob is on carol's instance and alice's namesake on alice's, so
+	// alice's message would have reached them by now: what they read
+	// first must be their own.
+	bob.Send("bob", "hi globex")
+	if got := awaitText(t, bob); got != "hi globex" {
+		t.Errorf("bob got %q from acme", got)
+	}
+	if got := awaitText(t, otherAlice); got != "hi globex" {
+		t.Errorf("globex's alice got %q, want bob's message", got)
+	}
+	if got := awaitText(t, alice); got != "hi acme" {
+		t.Errorf("alice got %q, want her own message", got)
+	}
+
+	for _, m := range []struct {
+		c    *client.Client
+		want []string
+	}{{alice, []string{"alice", "carol"}}, {carol, []string{"alice", "carol"}}, {bob, []string{"alice", "bob"}}} {
+		got := memberNames(t, m.c)
+		slices.Sort(got)
+		if !slices.Equal(got, m.want) {
+			t.Errorf("members %v, want %v", got, m.want)
+		}
+	}
+
+	t.Run("rate limits", func(t *testing.T) {
+		alice.Send("alice", "one too many")
+		refused(t, alice, "quota_exceeded")
+		otherAlice.Send("alice", "still fine")
+		if got := awaitText(t, otherAlice); got != "still fine" {
+			t.Errorf("globex's alice got %q, want her second message", got)
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		code, data := api(t, addrB, http.MethodGet, "/metrics", nil)
+		if code != http.StatusOK {
+			t.Fatalf("metrics got %d: %s", code, data)
+		}
+		for _, line := range []string{
+			`chat_ws_connections{workspace=""} 0`,
+			`chat_ws_connections{workspace="acme"} 1`,
+			`chat_ws_connections{workspace="globex"} 1`,
+		} {
+			if !strings.Contains(string(data), line+"\n") {
+				t.Errorf("metrics have no %s", line)
+			}
+		}
+	})
+}
+
+// awaitText reads c's frames up to a message and returns its text.
+func awaitText(t *testing.T, c *client.Client) string {
+	t.Helper()
+	return await(t, c, "message").Message.Text
+}